@@ -76,6 +76,18 @@ app.kubernetes.io/instance: {{ .Release.Name }}
   value: {{ .Values.groupcache.size | quote }}
 {{- end }}
 
+{{- if .Values.groupcache.zone }}
+- name: BESKAR_CACHE_ZONE
+  value: {{ .Values.groupcache.zone | quote }}
+{{- else if .Values.groupcache.zoneLabel }}
+- name: BESKAR_CACHE_ZONENODELABEL
+  value: {{ .Values.groupcache.zoneLabel | quote }}
+- name: NODE_NAME
+  valueFrom:
+    fieldRef:
+      fieldPath: spec.nodeName
+{{- end }}
+
 {{- if .Values.secrets.beskarPassword }}
 - name: BESKAR_REGISTRY_AUTH
   value: "beskar"
@@ -4,12 +4,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"syscall"
+	"time"
 
 	"go.ciq.dev/beskar/internal/pkg/config"
 	"go.ciq.dev/beskar/internal/pkg/yumplugin"
@@ -48,7 +51,16 @@ func serve(beskarYumCmd *flag.FlagSet) error {
 		errCh <- yp.Serve(ln)
 	}()
 
-	return wait()
+	err = wait()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if shutdownErr := yp.Shutdown(shutdownCtx); err == nil {
+		err = shutdownErr
+	}
+
+	return err
 }
 
 func addPackage(beskarYumAddPkgCmd *flag.FlagSet) error {
@@ -56,12 +68,14 @@ func addPackage(beskarYumAddPkgCmd *flag.FlagSet) error {
 		packageDir        string
 		packageID         string
 		packageRepository string
+		idempotencyKey    string
 		keepDatabaseDir   bool
 	)
 
 	beskarYumAddPkgCmd.StringVar(&packageDir, "dir", "", "package directory")
 	beskarYumAddPkgCmd.StringVar(&packageID, "id", "", "package identifier")
 	beskarYumAddPkgCmd.StringVar(&packageRepository, "repository", "", "package repository")
+	beskarYumAddPkgCmd.StringVar(&idempotencyKey, "idempotency-key", "", "package idempotency key")
 	beskarYumAddPkgCmd.BoolVar(&keepDatabaseDir, "keep-db-dir", false, "keep database temporary directory")
 
 	if err := beskarYumAddPkgCmd.Parse(os.Args[2:]); err != nil {
@@ -83,7 +97,7 @@ func addPackage(beskarYumAddPkgCmd *flag.FlagSet) error {
 	}
 
 	go func() {
-		dbDir, err := yp.AddPackageToDatabase(ctx, packageID, packageRepository, packageDir, false, keepDatabaseDir)
+		dbDir, err := yp.AddPackageToDatabase(ctx, packageID, packageRepository, packageDir, idempotencyKey, false, keepDatabaseDir)
 		if err == nil {
 			fmt.Fprintf(os.Stdout, "%s\n", dbDir)
 		} else {
@@ -133,6 +147,59 @@ func genMetadata(beskarYumGenMetaCmd *flag.FlagSet) error {
 	return wait()
 }
 
+func verify(beskarYumVerifyCmd *flag.FlagSet) error {
+	var (
+		repository string
+		repair     bool
+	)
+
+	beskarYumVerifyCmd.StringVar(&repository, "repository", "", "repository to verify")
+	beskarYumVerifyCmd.BoolVar(&repair, "repair", false, "regenerate repository metadata to match the storage")
+
+	if err := beskarYumVerifyCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	} else if repository == "" {
+		return fmt.Errorf("repository is required")
+	}
+
+	beskarYumConfig, err := config.ParseBeskarYumConfig(configDir)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	yp, err := yumplugin.New(ctx, beskarYumConfig, false)
+	if err != nil {
+		return err
+	}
+
+	verifyRepository := yp.VerifyRepository
+	if repair {
+		verifyRepository = yp.RepairRepository
+	}
+
+	report, err := verifyRepository(ctx, repository)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	} else if !report.OK() && !report.Repaired {
+		return fmt.Errorf(
+			"repository %s has %d missing, %d corrupt and %d unlisted packages",
+			repository, len(report.Missing), len(report.Corrupt), len(report.Unlisted),
+		)
+	} else if len(report.Corrupt) > 0 {
+		return fmt.Errorf("repository %s has %d corrupt packages", repository, len(report.Corrupt))
+	}
+
+	return nil
+}
+
 func main() {
 	beskarYumCmd := flag.NewFlagSet("beskar-yum", flag.ExitOnError)
 	beskarYumCmd.StringVar(&configDir, "config-dir", "", "configuration directory")
@@ -143,6 +210,9 @@ func main() {
 	beskarYumGenMetaCmd := flag.NewFlagSet("beskar-yum-gen-meta", flag.ExitOnError)
 	beskarYumGenMetaCmd.StringVar(&configDir, "config-dir", "", "configuration directory")
 
+	beskarYumVerifyCmd := flag.NewFlagSet("beskar-yum-verify", flag.ExitOnError)
+	beskarYumVerifyCmd.StringVar(&configDir, "config-dir", "", "configuration directory")
+
 	subCommand := ""
 	if len(os.Args) > 1 {
 		subCommand = os.Args[1]
@@ -157,6 +227,10 @@ func main() {
 		if err := genMetadata(beskarYumGenMetaCmd); err != nil {
 			log.Fatal(err)
 		}
+	case "verify":
+		if err := verify(beskarYumVerifyCmd); err != nil {
+			log.Fatal(err)
+		}
 	case "version":
 		fmt.Println(Version)
 	default:
@@ -4,10 +4,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"syscall"
 
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
@@ -18,13 +21,22 @@ import (
 
 var Version = "dev"
 
-var configDir string
+var (
+	configDir          string
+	registryStorageDir string
+)
 
 func serve(beskarCmd *flag.FlagSet) error {
 	if err := beskarCmd.Parse(os.Args[1:]); err != nil {
 		return err
 	}
 
+	if registryStorageDir != "" {
+		if err := os.Setenv(config.RegistryStorageDirEnv, registryStorageDir); err != nil {
+			return err
+		}
+	}
+
 	beskarConfig, err := config.ParseBeskarConfig(configDir)
 	if err != nil {
 		return fmt.Errorf("while parsing configuration: %w", err)
@@ -67,13 +79,81 @@ func gc(beskarGCCmd *flag.FlagSet) error {
 	return wait()
 }
 
+func migrateConfig(beskarMigrateCmd *flag.FlagSet) error {
+	var write bool
+
+	beskarMigrateCmd.BoolVar(&write, "write", false, "rewrite the configuration file instead of printing the migrated configuration")
+
+	if err := beskarMigrateCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	dir := configDir
+	if dir == "" {
+		dir = config.DefaultConfigDir
+	}
+	filename := filepath.Join(dir, config.BeskarConfigFile)
+
+	oldConfig, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	newConfig, err := config.MigrateConfig(oldConfig)
+	if err != nil {
+		return fmt.Errorf("while migrating %s: %w", filename, err)
+	}
+
+	if write {
+		return os.WriteFile(filename, newConfig, 0o600)
+	}
+
+	_, err = os.Stdout.Write(newConfig)
+	return err
+}
+
+func selftest(beskarSelfTestCmd *flag.FlagSet) error {
+	if err := beskarSelfTestCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if registryStorageDir != "" {
+		if err := os.Setenv(config.RegistryStorageDirEnv, registryStorageDir); err != nil {
+			return err
+		}
+	}
+
+	report := beskar.RunSelfTest(context.Background(), configDir)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	} else if !report.OK() {
+		return fmt.Errorf("self-test failed")
+	}
+
+	return nil
+}
+
 func main() {
 	beskarCmd := flag.NewFlagSet("beskar", flag.ExitOnError)
 	beskarCmd.StringVar(&configDir, "config-dir", "", "configuration directory")
+	beskarCmd.StringVar(
+		&registryStorageDir, "registry-storage-dir", "",
+		fmt.Sprintf("registry storage directory used with the default configuration (default %s or $%s)", config.DefaultRegistryStorageDir, config.RegistryStorageDirEnv),
+	)
 
 	beskarGCCmd := flag.NewFlagSet("beskar-gc", flag.ExitOnError)
 	beskarGCCmd.StringVar(&configDir, "config-dir", "", "configuration directory")
 
+	beskarMigrateCmd := flag.NewFlagSet("beskar-migrate-config", flag.ExitOnError)
+	beskarMigrateCmd.StringVar(&configDir, "config-dir", "", "configuration directory")
+
+	beskarSelfTestCmd := flag.NewFlagSet("beskar-selftest", flag.ExitOnError)
+	beskarSelfTestCmd.StringVar(&configDir, "config-dir", "", "configuration directory")
+	beskarSelfTestCmd.StringVar(&registryStorageDir, "registry-storage-dir", "", "registry storage directory used with the default configuration")
+
 	subCommand := ""
 	if len(os.Args) > 1 {
 		subCommand = os.Args[1]
@@ -81,13 +161,21 @@ func main() {
 
 	switch subCommand {
 	case "gc":
-		if err := gc(beskarCmd); err != nil {
+		if err := gc(beskarGCCmd); err != nil {
+			log.Fatal(err)
+		}
+	case "migrate-config":
+		if err := migrateConfig(beskarMigrateCmd); err != nil {
+			log.Fatal(err)
+		}
+	case "selftest":
+		if err := selftest(beskarSelfTestCmd); err != nil {
 			log.Fatal(err)
 		}
 	case "version":
 		fmt.Println(Version)
 	default:
-		if err := serve(beskarGCCmd); err != nil {
+		if err := serve(beskarCmd); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// RedactedValue replaces the value of sensitive headers.
+const RedactedValue = "<redacted>"
+
+// sensitiveHeaders lists the headers carrying credentials.
+var sensitiveHeaders = map[string]struct{}{
+	"Authorization":       {},
+	"Proxy-Authorization": {},
+	"Cookie":              {},
+	"Set-Cookie":          {},
+	"X-Registry-Auth":     {},
+}
+
+// Logger emits one structured record per request with
+// the configured fields.
+type Logger struct {
+	logger     *logrus.Logger
+	fields     []string
+	sampleRate float64
+	// file is the log file, it's nil for the standard outputs.
+	file *os.File
+}
+
+// New returns an access logger writing records to the configured output.
+func New(accessLog config.AccessLog) (*Logger, error) {
+	var (
+		output io.Writer
+		file   *os.File
+	)
+
+	switch accessLog.Output {
+	case config.AccessLogOutputStdout:
+		output = os.Stdout
+	case config.AccessLogOutputStderr:
+		output = os.Stderr
+	default:
+		f, err := os.OpenFile(accessLog.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("while opening access log %s: %w", accessLog.Output, err)
+		}
+		output = f
+		file = f
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(output)
+
+	timestamp := false
+	for _, field := range accessLog.Fields {
+		timestamp = timestamp || field == config.AccessLogFieldTimestamp
+	}
+
+	fieldMap := logrus.FieldMap{
+		logrus.FieldKeyTime: config.AccessLogFieldTimestamp,
+	}
+
+	if accessLog.Format == config.AccessLogFormatText {
+		logger.SetFormatter(&logrus.TextFormatter{
+			DisableColors:    true,
+			DisableTimestamp: !timestamp,
+			FullTimestamp:    true,
+			TimestampFormat:  time.RFC3339Nano,
+			FieldMap:         fieldMap,
+		})
+	} else {
+		logger.SetFormatter(&logrus.JSONFormatter{
+			DisableTimestamp: !timestamp,
+			TimestampFormat:  time.RFC3339Nano,
+			FieldMap:         fieldMap,
+		})
+	}
+
+	return &Logger{
+		logger:     logger,
+		fields:     accessLog.Fields,
+		sampleRate: accessLog.SampleRate,
+		file:       file,
+	}, nil
+}
+
+// Close closes the log file, records can't be logged afterwards.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Sampled returns true if the request must be logged.
+func (l *Logger) Sampled() bool {
+	//nolint:gosec // sampling doesn't require a secure random generator
+	return l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
+
+// Log emits a record with the configured fields found in values.
+func (l *Logger) Log(values map[string]interface{}) {
+	fields := make(logrus.Fields, len(l.fields))
+	for _, field := range l.fields {
+		if value, ok := values[field]; ok {
+			fields[field] = value
+		}
+	}
+
+	l.logger.WithFields(fields).Info("access")
+}
+
+// RedactHeaders returns the request headers with the
+// value of sensitive headers redacted.
+func RedactHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if _, ok := sensitiveHeaders[http.CanonicalHeaderKey(name)]; ok {
+			headers[name] = RedactedValue
+		} else {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+	return headers
+}
+
+// RequestBody records the number of bytes read from the request body.
+type RequestBody struct {
+	io.ReadCloser
+	Bytes int64
+}
+
+// NewRequestBody wraps the body of the request.
+func NewRequestBody(r *http.Request) *RequestBody {
+	body := &RequestBody{ReadCloser: r.Body}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = body
+	}
+	return body
+}
+
+func (rb *RequestBody) Read(p []byte) (int, error) {
+	n, err := rb.ReadCloser.Read(p)
+	rb.Bytes += int64(n)
+	return n, err
+}
+
+// ResponseWriter records the status and the number of bytes written.
+type ResponseWriter struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+// NewResponseWriter wraps the response writer, the status defaults to 200.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (rw *ResponseWriter) WriteHeader(status int) {
+	rw.Status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *ResponseWriter) Write(p []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(p)
+	rw.Bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher for streamed responses.
+func (rw *ResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -14,16 +14,54 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// repositoryAccess is the access to a repository granted by a plugin.
+type repositoryAccess int
+
+const (
+	// repositoryAccessDefault means the plugin doesn't restrict the
+	// repository access, the registry rules apply.
+	repositoryAccessDefault repositoryAccess = iota
+	// repositoryAccessGranted means the plugin grants the access
+	// to the request credentials.
+	repositoryAccessGranted
+	// repositoryAccessDenied means the plugin restricts the repository
+	// access and doesn't grant it to the request credentials, the
+	// registry credentials are required.
+	repositoryAccessDenied
+)
+
+// repositoryAuthorizer authorizes the registry API access to plugin
+// repositories, it returns the identity of the credentials granted
+// access.
+type repositoryAuthorizer interface {
+	authorizeRepository(ctx context.Context, r *http.Request, repository, action string) (repositoryAccess, string, error)
+}
+
 // accessController provides a simple implementation of auth.AccessController
 // that simply checks for a non-empty Authorization header. It is useful for
 // demonstration and testing.
 type accessController struct {
 	hashPassword []byte
+	repositories repositoryAuthorizer
 }
 
 var _ auth.AccessController = &accessController{}
 
-func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+// newAccessControllerFunc returns the access controller constructor, plugins
+// restricting the access to their repositories are consulted through the
+// repository authorizer.
+func newAccessControllerFunc(repositories repositoryAuthorizer) auth.InitFunc {
+	return func(options map[string]interface{}) (auth.AccessController, error) {
+		ac, err := newAccessController(options)
+		if err != nil {
+			return nil, err
+		}
+		ac.repositories = repositories
+		return ac, nil
+	}
+}
+
+func newAccessController(options map[string]interface{}) (*accessController, error) {
 	account, ok := options["account"]
 	if !ok {
 		return nil, fmt.Errorf("account with hashed password is missing: htpasswd bcrypt format expected")
@@ -44,7 +82,9 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 }
 
 // Authorized simply checks for the existence of the authorization header,
-// responding with a bearer challenge if it doesn't exist.
+// responding with a bearer challenge if it doesn't exist. Plugins may grant
+// the access to their repositories to other credentials, or restrict it to
+// the registry credentials.
 func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
 	req, err := dcontext.GetRequest(ctx)
 	if err != nil {
@@ -52,25 +92,40 @@ func (ac *accessController) Authorized(ctx context.Context, accessRecords ...aut
 	}
 
 	requireAuthentication := false
+	grantedIdentity := ""
 
 	for _, record := range accessRecords {
+		if record.Type == "repository" && ac.repositories != nil {
+			access, identity, err := ac.repositories.authorizeRepository(ctx, req, record.Name, record.Action)
+			if err != nil {
+				return nil, err
+			}
+			switch access {
+			case repositoryAccessGranted:
+				grantedIdentity = identity
+				continue
+			case repositoryAccessDenied:
+				requireAuthentication = true
+				continue
+			}
+		}
 		// enforce authentication for:
 		// - catalog
 		// - push/delete
 		// - beskar internal repository (reserved for future use)
 		if record.Type == "registry" {
 			requireAuthentication = true
-			break
 		} else if record.Action == "push" || record.Action == "delete" {
 			requireAuthentication = true
-			break
 		} else if record.Name == "beskar" || strings.HasPrefix(record.Name, "beskar/") {
 			requireAuthentication = true
-			break
 		}
 	}
 
 	if !requireAuthentication {
+		if grantedIdentity != "" {
+			return auth.WithUser(ctx, auth.UserInfo{Name: grantedIdentity}), nil
+		}
 		return ctx, nil
 	}
 
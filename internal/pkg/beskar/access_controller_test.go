@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestAccessController(t *testing.T, repositories repositoryAuthorizer) *accessController {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	ac, err := newAccessControllerFunc(repositories)(map[string]interface{}{
+		"account": "beskar:" + string(hash),
+	})
+	require.NoError(t, err)
+
+	return ac.(*accessController)
+}
+
+func TestAccessControllerRepositoryTokens(t *testing.T) {
+	// the plugin backend grants the token "tenant" the access to
+	// yum/rocky repositories, yum/alma repositories are not restricted
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/authorize", r.URL.Path)
+		repository := r.URL.Query().Get("repository")
+		if repository == "yum/alma/repodata" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if _, password, ok := r.BasicAuth(); ok && password == "tenant" && r.URL.Query().Get("action") != "delete" {
+			_, _ = w.Write([]byte(`{"identity":"token:id"}`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer backend.Close()
+
+	authorizeURL, err := url.Parse(backend.URL + "/authorize")
+	require.NoError(t, err)
+
+	br := &Registry{
+		proxyPlugins: map[string]*proxyPlugin{
+			"application/vnd.ciq.rpm-package.v1.config+json": {
+				prefix:       "/yum",
+				authorizeURL: authorizeURL,
+				client:       backend.Client(),
+			},
+		},
+	}
+
+	ac := newTestAccessController(t, br)
+
+	authorized := func(password string, records ...auth.Access) (string, error) {
+		// the yum plugin redirects its repository requests to the blob URLs
+		req := httptest.NewRequest(http.MethodGet, "/v2/yum/rocky/repodata/blobs/sha256:abc", nil)
+		if password != "" {
+			req.SetBasicAuth("user", password)
+		}
+		ctx, err := ac.Authorized(dcontext.WithRequest(context.Background(), req), records...)
+		if err != nil {
+			return "", err
+		}
+		return dcontext.GetStringValue(ctx, "auth.user.name"), nil
+	}
+
+	access := func(name, action string) auth.Access {
+		return auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: name},
+			Action:   action,
+		}
+	}
+
+	var ch *challenge
+
+	// restricted repositories require a token or the registry credentials
+	_, err = authorized("", access("yum/rocky/repodata", "pull"))
+	require.ErrorAs(t, err, &ch)
+	_, err = authorized("other", access("yum/rocky/repodata", "pull"))
+	require.ErrorIs(t, err, auth.ErrAuthenticationFailure)
+
+	user, err := authorized("tenant", access("yum/rocky/repodata", "pull"))
+	require.NoError(t, err)
+	require.Equal(t, "token:id", user)
+
+	user, err = authorized("secret", access("yum/rocky/repodata", "pull"))
+	require.NoError(t, err)
+	require.Equal(t, "user", user)
+
+	// tokens granting push don't require the registry credentials
+	user, err = authorized("tenant", access("yum/rocky/packages", "pull"), access("yum/rocky/packages", "push"))
+	require.NoError(t, err)
+	require.Equal(t, "token:id", user)
+
+	_, err = authorized("tenant", access("yum/rocky/packages", "delete"))
+	require.ErrorIs(t, err, auth.ErrAuthenticationFailure)
+
+	// unrestricted repositories follow the registry rules
+	user, err = authorized("", access("yum/alma/repodata", "pull"))
+	require.NoError(t, err)
+	require.Empty(t, user)
+	_, err = authorized("", access("iso/rocky/images", "push"))
+	require.ErrorAs(t, err, &ch)
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.ciq.dev/beskar/internal/pkg/accesslog"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// registryPathRegexp matches the repository requests of the registry API.
+var registryPathRegexp = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|tags)/(.*)$`)
+
+// accessOperation returns the repository and the operation of registry and
+// plugin requests, false is returned for other requests which aren't logged.
+// Plugin prefixes are matched in the plugin resolution order.
+func accessOperation(plugins map[string]config.Plugin, order []string, method, path string) (string, string, bool) {
+	switch path {
+	case "/v2", "/v2/":
+		return "", "base", true
+	case "/v2/_catalog":
+		return "", "catalog", true
+	}
+
+	if m := registryPathRegexp.FindStringSubmatch(path); m != nil {
+		repository, kind, rest := m[1], m[2], m[3]
+
+		read := method == http.MethodGet || method == http.MethodHead
+
+		switch {
+		case kind == "tags":
+			return repository, "list-tags", true
+		case kind == "blobs" && strings.HasPrefix(rest, "uploads"):
+			return repository, "push-blob", true
+		case method == http.MethodDelete:
+			return repository, "delete-" + strings.TrimSuffix(kind, "s"), true
+		case read:
+			return repository, "pull-" + strings.TrimSuffix(kind, "s"), true
+		default:
+			return repository, "push-" + strings.TrimSuffix(kind, "s"), true
+		}
+	}
+
+	for _, name := range order {
+		plugin := plugins[name]
+		if path == plugin.Prefix || strings.HasPrefix(path, plugin.Prefix+"/") {
+			return "", "plugin:" + name, true
+		}
+	}
+
+	return "", "", false
+}
+
+// accessLogHandler logs registry and plugin requests, requests to beskar
+// endpoints (health, API, profiling) are not logged.
+func (br *Registry) accessLogHandler(logger *accesslog.Logger, next http.Handler) http.Handler {
+	if logger == nil {
+		return next
+	}
+
+	plugins := br.beskarConfig.Plugins
+	order := br.beskarConfig.PluginRouting.ResolutionOrder(plugins)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repository, operation, ok := accessOperation(plugins, order, r.Method, r.URL.Path)
+		if !ok || !logger.Sampled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := accesslog.NewRequestBody(r)
+		rw := accesslog.NewResponseWriter(w)
+		start := time.Now()
+
+		next.ServeHTTP(rw, r)
+
+		identity := requestIdentity(br.beskarConfig.Authorization, br.trustedProxies, r)
+		if identity == "" {
+			identity, _, _ = r.BasicAuth()
+		}
+
+		logger.Log(map[string]interface{}{
+			config.AccessLogFieldClientIP:   br.trustedProxies.ClientIP(r),
+			config.AccessLogFieldIdentity:   identity,
+			config.AccessLogFieldRepository: repository,
+			config.AccessLogFieldOperation:  operation,
+			config.AccessLogFieldMethod:     r.Method,
+			config.AccessLogFieldPath:       r.URL.Path,
+			config.AccessLogFieldHeaders:    accesslog.RedactHeaders(r.Header),
+			config.AccessLogFieldBytes:      rw.Bytes,
+			config.AccessLogFieldBytesIn:    body.Bytes,
+			config.AccessLogFieldStatus:     rw.Status,
+			config.AccessLogFieldDuration:   time.Since(start).Seconds(),
+		})
+	})
+}
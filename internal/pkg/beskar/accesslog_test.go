@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestAccessOperation(t *testing.T) {
+	plugins := map[string]config.Plugin{
+		"yum":          {Prefix: "/yum"},
+		"yum-internal": {Prefix: "/yum/internal"},
+	}
+	order := config.PluginRouting{}.ResolutionOrder(plugins)
+
+	tests := []struct {
+		method     string
+		path       string
+		repository string
+		operation  string
+		logged     bool
+	}{
+		{http.MethodGet, "/v2/", "", "base", true},
+		{http.MethodGet, "/v2/_catalog", "", "catalog", true},
+		{http.MethodGet, "/v2/yum/rocky/manifests/latest", "yum/rocky", "pull-manifest", true},
+		{http.MethodHead, "/v2/library/alpine/manifests/3.18", "library/alpine", "pull-manifest", true},
+		{http.MethodPut, "/v2/library/alpine/manifests/3.18", "library/alpine", "push-manifest", true},
+		{http.MethodDelete, "/v2/library/alpine/manifests/sha256:abc", "library/alpine", "delete-manifest", true},
+		{http.MethodGet, "/v2/library/alpine/blobs/sha256:abc", "library/alpine", "pull-blob", true},
+		{http.MethodPost, "/v2/library/alpine/blobs/uploads/", "library/alpine", "push-blob", true},
+		{http.MethodPatch, "/v2/library/alpine/blobs/uploads/1234", "library/alpine", "push-blob", true},
+		{http.MethodGet, "/v2/library/alpine/tags/list", "library/alpine", "list-tags", true},
+		{http.MethodGet, "/yum/repo/rocky/repodata/repomd.xml", "", "plugin:yum", true},
+		{http.MethodGet, "/yum/internal/repo/rocky", "", "plugin:yum-internal", true},
+		{http.MethodGet, "/yumyum", "", "", false},
+		{http.MethodGet, "/beskar/readyz", "", "", false},
+		{http.MethodGet, "/debug/pprof/", "", "", false},
+	}
+
+	for _, tt := range tests {
+		repository, operation, logged := accessOperation(plugins, order, tt.method, tt.path)
+		require.Equal(t, tt.logged, logged, "%s %s", tt.method, tt.path)
+		require.Equal(t, tt.repository, repository, "%s %s", tt.method, tt.path)
+		require.Equal(t, tt.operation, operation, "%s %s", tt.method, tt.path)
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/netutil"
+)
+
+// AuthorizationRequest describes a plugin request to authorize.
+type AuthorizationRequest struct {
+	// Plugin name.
+	Plugin string
+	// Identity of the client, empty if unknown.
+	Identity string
+	Method   string
+	Path     string
+	// Request is the original HTTP request.
+	Request *http.Request
+}
+
+// Authorizer is consulted before proxying requests to plugins.
+type Authorizer interface {
+	Authorize(ctx context.Context, request *AuthorizationRequest) (bool, error)
+}
+
+// WithAuthorizer sets the authorizer for plugin requests, it takes
+// precedence over the authorizer defined in the configuration.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(br *Registry) {
+		br.authorizer = authorizer
+	}
+}
+
+func newAuthorizer(authorization config.Authorization) Authorizer {
+	if authorization.Type == config.AuthorizerStatic {
+		return &staticAuthorizer{rules: authorization.Rules}
+	}
+	return allowAllAuthorizer{}
+}
+
+type allowAllAuthorizer struct{}
+
+func (allowAllAuthorizer) Authorize(context.Context, *AuthorizationRequest) (bool, error) {
+	return true, nil
+}
+
+// staticAuthorizer allows requests matching one of the rules.
+type staticAuthorizer struct {
+	rules []config.AuthorizationRule
+}
+
+func (sa *staticAuthorizer) Authorize(_ context.Context, request *AuthorizationRequest) (bool, error) {
+	for _, rule := range sa.rules {
+		if rule.Identity != "*" && (request.Identity == "" || rule.Identity != request.Identity) {
+			continue
+		} else if len(rule.Methods) > 0 && !containsFold(rule.Methods, request.Method) {
+			continue
+		}
+		for _, prefix := range rule.Prefixes {
+			if strings.HasPrefix(request.Path, prefix) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIdentity returns the identity of the client sending the request,
+// the identity header is only honored for requests sent by a trusted proxy
+// otherwise the mTLS identity is used if any.
+func requestIdentity(authorization config.Authorization, trustedProxies netutil.TrustedProxies, r *http.Request) string {
+	if authorization.IdentitySource == config.IdentitySourceHeader {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if trustedProxies.Contains(net.ParseIP(host)) {
+			return r.Header.Get(authorization.IdentityHeader)
+		}
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return ""
+}
+
+// authorizeHandler rejects plugin requests which are not allowed
+// by the registry authorizer.
+func (br *Registry) authorizeHandler(plugin string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, err := br.authorizer.Authorize(r.Context(), &AuthorizationRequest{
+			Plugin:   plugin,
+			Identity: requestIdentity(br.beskarConfig.Authorization, br.trustedProxies, r),
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Request:  r,
+		})
+		if err != nil {
+			br.logger.Errorf("while authorizing %s request %s %s: %s", plugin, r.Method, r.URL.Path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/netutil"
+)
+
+func TestStaticAuthorizer(t *testing.T) {
+	authorizer := newAuthorizer(config.Authorization{
+		Type: config.AuthorizerStatic,
+		Rules: []config.AuthorizationRule{
+			{Identity: "ci", Prefixes: []string{"/yum/api/v1/repo/rocky/"}},
+			{Identity: "*", Prefixes: []string{"/yum/repo/"}, Methods: []string{"get", "head"}},
+		},
+	})
+
+	tests := []struct {
+		identity string
+		method   string
+		path     string
+		allowed  bool
+	}{
+		{"ci", http.MethodPost, "/yum/api/v1/repo/rocky/tokens", true},
+		{"ci", http.MethodPost, "/yum/api/v1/repo/epel/tokens", false},
+		{"dev", http.MethodPost, "/yum/api/v1/repo/rocky/tokens", false},
+		{"", http.MethodGet, "/yum/repo/rocky/repodata/repomd.xml", true},
+		{"dev", http.MethodPut, "/yum/repo/rocky/repodata/repomd.xml", false},
+	}
+
+	for _, tt := range tests {
+		allowed, err := authorizer.Authorize(context.Background(), &AuthorizationRequest{
+			Identity: tt.identity,
+			Method:   tt.method,
+			Path:     tt.path,
+		})
+		require.NoError(t, err)
+		require.Equal(t, tt.allowed, allowed, "%s %s %s", tt.identity, tt.method, tt.path)
+	}
+
+	allowed, err := newAuthorizer(config.Authorization{}).Authorize(context.Background(), &AuthorizationRequest{})
+	require.NoError(t, err)
+	require.True(t, allowed)
+}
+
+func TestRequestIdentity(t *testing.T) {
+	trustedProxies, err := netutil.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	headerSource := config.Authorization{
+		IdentitySource: config.IdentitySourceHeader,
+		IdentityHeader: "X-Forwarded-User",
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/yum", nil)
+	r.RemoteAddr = "10.0.0.1:4242"
+	r.Header.Set("X-Forwarded-User", "ci")
+
+	require.Equal(t, "", requestIdentity(config.Authorization{
+		IdentitySource: config.IdentitySourceMTLS,
+	}, trustedProxies, r))
+	require.Equal(t, "ci", requestIdentity(headerSource, trustedProxies, r))
+
+	// clients not going through a trusted proxy can't spoof the identity
+	r.RemoteAddr = "192.0.2.10:4242"
+	require.Equal(t, "", requestIdentity(headerSource, trustedProxies, r))
+	require.Equal(t, "", requestIdentity(headerSource, nil, r))
+
+	// the mTLS identity is used instead
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "client"}}}},
+	}
+	require.Equal(t, "client", requestIdentity(headerSource, trustedProxies, r))
+}
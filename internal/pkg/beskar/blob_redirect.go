@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// blobRedirectedParam marks the blob requests redirected to their owner,
+// they are served by the node receiving them even if its peers view
+// differs, which prevents redirect loops.
+const blobRedirectedParam = "beskar-redirected"
+
+var blobPathRegexp = regexp.MustCompile(`^/v2/(.+)/blobs/([a-z0-9]+:[a-zA-Z0-9=_-]+)$`)
+
+// blobRedirectHandler redirects the blob fetches to the public URL of the
+// peer owning the blob returned by ownerPublicURL, blobs owned by the local
+// node or by peers without a public URL are served locally.
+func blobRedirectHandler(ownerPublicURL func(key string) (string, bool), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		matches := blobPathRegexp.FindStringSubmatch(r.URL.Path)
+		if matches == nil || r.URL.Query().Has(blobRedirectedParam) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		publicURL, ok := ownerPublicURL(matches[1] + "@" + matches[2])
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		location, err := url.Parse(publicURL)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		query.Set(blobRedirectedParam, "true")
+
+		location.Path = r.URL.Path
+		location.RawQuery = query.Encode()
+
+		http.Redirect(w, r, location.String(), http.StatusTemporaryRedirect)
+	})
+}
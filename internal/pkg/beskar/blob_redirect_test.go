@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobRedirectHandler(t *testing.T) {
+	ownedDigest := digest.FromString("owned")
+	localDigest := digest.FromString("local")
+
+	var keys []string
+
+	ownerPublicURL := func(key string) (string, bool) {
+		keys = append(keys, key)
+		if key == "test/repo@"+ownedDigest.String() {
+			return "https://peer.example.com", true
+		}
+		return "", false
+	}
+	handler := blobRedirectHandler(ownerPublicURL, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	owned := "/v2/test/repo/blobs/" + ownedDigest.String()
+	local := "/v2/test/repo/blobs/" + localDigest.String()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, owned+"?ns=docker.io", nil))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	require.Equal(t, "https://peer.example.com"+owned+"?beskar-redirected=true&ns=docker.io", rec.Header().Get("Location"))
+
+	// redirected requests are served locally
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, owned+"?beskar-redirected=true", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, local, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// uploads and manifests are never redirected
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, owned, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/test/repo/manifests/latest", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.Equal(t, []string{"test/repo@" + ownedDigest.String(), "test/repo@" + localDigest.String()}, keys)
+}
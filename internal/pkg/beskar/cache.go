@@ -25,6 +25,9 @@ type ManifestSink struct {
 	value           []byte
 	manifestService distribution.ManifestService
 	options         []distribution.ManifestServiceOption
+	// loaded reports whether the manifest was read from the local
+	// storage rather than from a cache
+	loaded bool
 }
 
 func newManifestSink(manifestService distribution.ManifestService, options ...distribution.ManifestServiceOption) *ManifestSink {
@@ -47,6 +50,8 @@ func (ms *ManifestSink) FromManifest(manifest distribution.Manifest) error {
 		return err
 	}
 
+	ms.loaded = true
+
 	return ms.Sink.SetBytes(value, time.Now().Add(1*time.Hour))
 }
 
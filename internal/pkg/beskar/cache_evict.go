@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.ciq.dev/beskar/internal/pkg/gossip"
+)
+
+const (
+	cacheEvictPath = "/beskar/api/v1/cache/evict"
+	// cacheEvictTimeout bounds the removal of the evicted
+	// keys from the cache peers.
+	cacheEvictTimeout = 30 * time.Second
+)
+
+// CacheEvictResult reports the number of manifest cache entries evicted.
+type CacheEvictResult struct {
+	Evicted int `json:"evicted"`
+}
+
+// evictCacheKeys evicts the manifest cache key or the keys starting with
+// the key when the eviction is a prefix eviction, it returns the number
+// of evicted entries.
+func (br *Registry) evictCacheKeys(ctx context.Context, eviction gossip.CacheEviction) (int, error) {
+	if eviction.Prefix {
+		return br.manifestGroup.EvictPrefix(ctx, eviction.Key)
+	}
+	return br.manifestGroup.EvictKey(ctx, eviction.Key)
+}
+
+// evictLocalCacheKeys is like evictCacheKeys but evicts the keys from
+// the local cache only.
+func (br *Registry) evictLocalCacheKeys(ctx context.Context, eviction gossip.CacheEviction) (int, error) {
+	if eviction.Prefix {
+		return br.manifestGroup.EvictLocalPrefix(ctx, eviction.Key)
+	}
+	return br.manifestGroup.EvictLocalKey(ctx, eviction.Key)
+}
+
+// evictReceivedCacheKeys evicts the manifest cache keys of an eviction
+// broadcasted by a gossip peer, the keys are evicted from the local cache
+// only as the eviction was already sent to every peer.
+func (br *Registry) evictReceivedCacheKeys(eviction gossip.CacheEviction) {
+	if !br.cacheInitialized.Load() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(br.ctx, cacheEvictTimeout)
+	defer cancel()
+
+	evicted, err := br.evictLocalCacheKeys(ctx, eviction)
+	if err != nil {
+		br.logger.Warnf("Cache eviction of %q received from peer failed: %s", eviction.Key, err)
+		return
+	}
+
+	br.logger.Infof("Evicted %d manifest cache entries for %q on peer request", evicted, eviction.Key)
+}
+
+// cacheEvictHandler evicts the manifest cache key given by the key query
+// parameter, or the keys starting with the prefix query parameter. Keys
+// have the repository@digest format. When the broadcast query parameter
+// is true the eviction is also broadcasted to the gossip peers so that
+// they evict the keys they know about.
+func cacheEvictHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "cache") {
+			return
+		}
+
+		key, prefix := r.URL.Query().Get("key"), r.URL.Query().Get("prefix")
+		if (key == "") == (prefix == "") {
+			http.Error(w, "either key or prefix query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		broadcast := false
+		if v := r.URL.Query().Get("broadcast"); v != "" {
+			var err error
+			broadcast, err = strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("while parsing broadcast: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !br.cacheInitialized.Load() {
+			http.Error(w, "cache is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		eviction := gossip.CacheEviction{Key: key}
+		if prefix != "" {
+			eviction = gossip.CacheEviction{Key: prefix, Prefix: true}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cacheEvictTimeout)
+		defer cancel()
+
+		evicted, err := br.evictCacheKeys(ctx, eviction)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("while evicting cache entries: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		br.logger.Infof("Evicted %d manifest cache entries for %q", evicted, eviction.Key)
+
+		if broadcast && br.member != nil {
+			if err := br.member.BroadcastCacheEviction(eviction); err != nil {
+				br.logger.Warnf("Cache eviction of %q not broadcasted to all members: %s", eviction.Key, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		_ = json.NewEncoder(w).Encode(CacheEvictResult{Evicted: evicted})
+	}
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/mailgun/groupcache/v2"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/cache"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestCacheEvictHandler(t *testing.T) {
+	ctx := context.Background()
+
+	loads := 0
+
+	group := cache.NewGroup("evict", 1<<20, groupcache.GetterFunc(func(_ context.Context, key string, dest groupcache.Sink) error {
+		loads++
+		return dest.SetString(key, time.Time{})
+	}))
+
+	keys := []string{
+		"library/alpine@sha256:1",
+		"library/alpine@sha256:2",
+		"library/busybox@sha256:1",
+	}
+	for _, key := range keys {
+		require.NoError(t, group.Set(ctx, key, []byte(key), time.Time{}, false))
+	}
+
+	br := &Registry{
+		ctx:           ctx,
+		beskarConfig:  &config.BeskarConfig{},
+		manifestGroup: group,
+		logger:        dcontext.GetLogger(ctx),
+	}
+
+	evict := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cacheEvictHandler(br).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, cacheEvictPath+query, nil))
+		return rec
+	}
+
+	require.Equal(t, http.StatusServiceUnavailable, evict("?key=library/alpine@sha256:1").Code)
+
+	br.cacheInitialized.Store(true)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "no key",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "key and prefix",
+			query:      "?key=library/alpine@sha256:1&prefix=library/",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "bad broadcast",
+			query:      "?key=library/alpine@sha256:1&broadcast=maybe",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "key",
+			query:      "?key=library/busybox@sha256:1",
+			wantStatus: http.StatusOK,
+			wantBody:   "{\"evicted\":1}\n",
+		},
+		{
+			name:       "unknown key",
+			query:      "?key=library/busybox@sha256:1",
+			wantStatus: http.StatusOK,
+			wantBody:   "{\"evicted\":0}\n",
+		},
+		{
+			name:       "prefix broadcasted without gossip",
+			query:      "?prefix=library/alpine@&broadcast=true",
+			wantStatus: http.StatusOK,
+			wantBody:   "{\"evicted\":2}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := evict(tt.query)
+			require.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			require.Equal(t, jsonContentType, rec.Header().Get("Content-Type"))
+			require.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+
+	// evicted keys are loaded again
+	for _, key := range keys {
+		var value string
+		require.NoError(t, group.Get(ctx, key, groupcache.StringSink(&value)))
+		require.Equal(t, key, value)
+	}
+	require.Equal(t, len(keys), loads)
+}
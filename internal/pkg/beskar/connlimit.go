@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+	"go.ciq.dev/beskar/pkg/netutil"
+)
+
+var (
+	connectionsNamespace = metrics.NewNamespace("beskar", "connections")
+
+	// client IPs are not used as label as they are unbounded
+	openConnectionsGauge = connectionsNamespace.NewLabeledGauge(
+		"open", "The number of connections accounted by the client IP limit", "", "listener",
+	)
+	rejectedConnectionsCounter = connectionsNamespace.NewLabeledCounter(
+		"rejected", "The number of connections rejected by the client IP limit", "listener",
+	)
+)
+
+func init() {
+	metrics.Register(connectionsNamespace)
+}
+
+// newConnLimiter returns the connection limiter of the listener,
+// nil is returned when connections are not limited.
+func newConnLimiter(connLimit config.ConnLimit, listener string) *netutil.ConnLimiter {
+	if !connLimit.Enabled() {
+		return nil
+	}
+
+	overrides := make([]netutil.ConnLimitOverride, 0, len(connLimit.Overrides))
+	for _, o := range connLimit.Overrides {
+		// CIDRs are validated while parsing the configuration
+		_, network, _ := net.ParseCIDR(o.CIDR)
+		overrides = append(overrides, netutil.ConnLimitOverride{
+			Network: network,
+			Limit:   o.PerIP,
+		})
+	}
+
+	limiter := netutil.NewConnLimiter(connLimit.PerIP, overrides)
+	limiter.OnTotal = func(delta int) {
+		openConnectionsGauge.WithValues(listener).Inc(float64(delta))
+	}
+	limiter.OnReject = func(string) {
+		rejectedConnectionsCounter.WithValues(listener).Inc(1)
+	}
+
+	return limiter
+}
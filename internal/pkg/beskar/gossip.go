@@ -0,0 +1,394 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/gossip"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	gossipMembersPath     = "/beskar/api/v1/gossip/members"
+	gossipRediscoverPath  = "/beskar/api/v1/gossip/rediscover"
+	gossipCAPath          = "/beskar/api/v1/gossip/ca"
+	gossipRediscoverLimit = 30 * time.Second
+	// caSecretTimeout bounds the CA mirroring to the Kubernetes Secret.
+	caSecretTimeout = 30 * time.Second
+
+	jsonContentType = "application/json"
+	yamlContentType = "application/yaml"
+)
+
+// GossipMemberMeta is the decoded meta data advertised by a member, it's
+// gossiped to all cluster members and doesn't hold any secret.
+type GossipMemberMeta struct {
+	CachePort         uint16 `json:"cache-port" yaml:"cache-port"`
+	Zone              string `json:"zone" yaml:"zone"`
+	PublicURL         string `json:"public-url" yaml:"public-url"`
+	ConfigFingerprint string `json:"config-fingerprint" yaml:"config-fingerprint"`
+}
+
+// GossipMember describes a gossip cluster member, Meta is nil
+// when the member meta data can't be decoded.
+type GossipMember struct {
+	Name  string            `json:"name" yaml:"name"`
+	Addr  string            `json:"addr" yaml:"addr"`
+	State string            `json:"state" yaml:"state"`
+	Local bool              `json:"local" yaml:"local"`
+	Meta  *GossipMemberMeta `json:"meta" yaml:"meta"`
+}
+
+// GossipMemberFilter selects gossip members, empty fields match any member.
+type GossipMemberFilter struct {
+	Zone  string
+	State string
+}
+
+// validate returns an error if the filter can't match any member.
+func (f GossipMemberFilter) validate() error {
+	switch f.State {
+	case "", gossip.PeerStateAlive, gossip.PeerStateDead:
+		return nil
+	}
+	return fmt.Errorf("unsupported state %q, supported states are %s and %s", f.State, gossip.PeerStateAlive, gossip.PeerStateDead)
+}
+
+// match returns true if the member is selected by the filter, members
+// without meta data are not selected when filtering by zone.
+func (f GossipMemberFilter) match(member GossipMember) bool {
+	if f.State != "" && member.State != f.State {
+		return false
+	} else if f.Zone != "" && (member.Meta == nil || member.Meta.Zone != f.Zone) {
+		return false
+	}
+	return true
+}
+
+// filterGossipMembers returns the members selected by the filter.
+func filterGossipMembers(members []GossipMember, filter GossipMemberFilter) []GossipMember {
+	filtered := make([]GossipMember, 0, len(members))
+	for _, member := range members {
+		if filter.match(member) {
+			filtered = append(filtered, member)
+		}
+	}
+	return filtered
+}
+
+// joinGossipClusters joins the named gossip clusters, the default
+// cluster is joined with the CA shared by the cache peers.
+func (br *Registry) joinGossipClusters(ctx context.Context) error {
+	names := make([]string, 0, len(br.beskarConfig.GossipClusters))
+	for _, name := range br.beskarConfig.GossipClusterNames() {
+		if name != config.DefaultGossipClusterName {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	br.logger.Infof("Joining gossip clusters %s", strings.Join(names, ", "))
+
+	clusters, err := gossip.StartClustersContext(ctx, br.beskarConfig, br.kubeClient, names...)
+	if err != nil {
+		return err
+	}
+	br.clusters = clusters
+
+	for name, member := range clusters {
+		go br.watchGossipCluster(br.ctx, name, member)
+	}
+
+	return nil
+}
+
+// watchGossipCluster consumes the events of a named gossip cluster member
+// until the context is cancelled, the member delegate blocks once its event
+// channel is full which would stall the memberlist handlers.
+func (br *Registry) watchGossipCluster(ctx context.Context, name string, member *gossip.Member) {
+	self := member.LocalNode()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-member.Watch():
+			node, ok := event.Arg.(*memberlist.Node)
+			if !ok || self.Name == node.Name {
+				continue
+			}
+			switch event.EventType {
+			case gossip.NodeJoin:
+				br.logger.Debugf("Added node %s to gossip cluster %q", node.Addr, name)
+			case gossip.NodeLeave:
+				br.logger.Debugf("Removed node %s from gossip cluster %q", node.Addr, name)
+			}
+		}
+	}
+}
+
+// WithKubernetesClient sets the kubernetes client used by the gossip peer
+// discovery and the CA mirroring, an in-cluster client is created otherwise
+// when running in kubernetes.
+func WithKubernetesClient(client kubernetes.Interface) Option {
+	return func(br *Registry) {
+		br.kubeClient = client
+	}
+}
+
+// initKubernetesClient creates the in-cluster kubernetes client shared by
+// the gossip clusters when running in kubernetes without a client set.
+func (br *Registry) initKubernetesClient() error {
+	if br.kubeClient != nil || !br.beskarConfig.RunInKubernetes() {
+		return nil
+	}
+
+	client, err := gossip.KubernetesClient(nil)
+	if err != nil {
+		return err
+	}
+	br.kubeClient = client
+
+	return nil
+}
+
+// GossipCluster returns the member of the named gossip cluster, the member
+// of the default cluster is returned for config.DefaultGossipClusterName.
+// Nil is returned when the cluster isn't joined.
+func (br *Registry) GossipCluster(name string) *gossip.Member {
+	if name == config.DefaultGossipClusterName {
+		return br.member
+	}
+	return br.clusters.Get(name)
+}
+
+// GossipMembers returns the gossip cluster members, the local node
+// included, an empty list is returned when gossip is disabled.
+func (br *Registry) GossipMembers() []GossipMember {
+	members, _ := br.GossipClusterMembers(config.DefaultGossipClusterName)
+	return members
+}
+
+// GossipClusterMembers returns the members of the named gossip cluster,
+// the local node and the recently dead members included, sorted by name.
+// False is returned if the cluster isn't configured.
+func (br *Registry) GossipClusterMembers(name string) ([]GossipMember, bool) {
+	clusterConfig, ok := br.beskarConfig.GossipCluster(name)
+	if !ok {
+		return nil, false
+	}
+
+	cluster := br.GossipCluster(name)
+	peers := append(cluster.Peers(), cluster.DeadPeers()...)
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+
+	members := make([]GossipMember, 0, len(peers))
+
+	for _, peer := range peers {
+		member := GossipMember{
+			Name:  peer.Name,
+			Addr:  peer.Addr,
+			State: peer.State,
+			Local: peer.Local,
+		}
+
+		meta := gossip.NewBeskarMeta(clusterConfig.Gossip.Cluster)
+		if err := meta.Decode(peer.Meta); err == nil {
+			member.Meta = &GossipMemberMeta{
+				CachePort:         meta.CachePort,
+				Zone:              meta.Zone,
+				PublicURL:         meta.PublicURL,
+				ConfigFingerprint: meta.ConfigFingerprint,
+			}
+		}
+
+		members = append(members, member)
+	}
+
+	return members, true
+}
+
+// gossipMembersHandler renders the gossip cluster members as JSON or YAML
+// depending on the Accept header, the cluster query parameter selects a
+// named gossip cluster instead of the default one and the zone and state
+// (alive or dead) query parameters filter the members. Member meta data is
+// rendered as is, it's advertised to all cluster members and doesn't hold
+// any secret.
+func gossipMembersHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "gossip") {
+			return
+		}
+
+		contentType := negotiateContentType(r, jsonContentType, yamlContentType)
+		if contentType == "" {
+			http.Error(w, fmt.Sprintf("supported media types are %s and %s", jsonContentType, yamlContentType), http.StatusNotAcceptable)
+			return
+		}
+
+		clusterMembers, ok := br.GossipClusterMembers(r.URL.Query().Get("cluster"))
+		if !ok {
+			http.Error(w, fmt.Sprintf("gossip cluster %q is not configured", r.URL.Query().Get("cluster")), http.StatusNotFound)
+			return
+		}
+
+		filter := GossipMemberFilter{
+			Zone:  r.URL.Query().Get("zone"),
+			State: r.URL.Query().Get("state"),
+		}
+		if err := filter.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		members := filterGossipMembers(clusterMembers, filter)
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Add("Vary", "Accept")
+
+		if contentType == yamlContentType {
+			_ = yaml.NewEncoder(w).Encode(members)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(members)
+	}
+}
+
+// gossipForgetHandler forgets a gossip member identified by the node query
+// parameter (name or gossip address), alive members are only forgotten when
+// the force query parameter is true.
+func gossipForgetHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "gossip") {
+			return
+		}
+
+		id := r.URL.Query().Get("node")
+		if id == "" {
+			http.Error(w, "node query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		force := false
+		if v := r.URL.Query().Get("force"); v != "" {
+			var err error
+			force, err = strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("while parsing force: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		node, err := br.member.ForgetNode(id, force)
+		switch {
+		case errors.Is(err, gossip.ErrNodeNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, gossip.ErrNodeAlive):
+			http.Error(w, fmt.Sprintf("%s, use force=true to forget it anyway", err), http.StatusConflict)
+			return
+		case errors.Is(err, gossip.ErrLocalNode):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case node == nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			br.logger.Warnf("Gossip node %s forgotten locally but not by all members: %s", node.Name, err)
+		}
+
+		if force && node.State == memberlist.StateAlive {
+			br.logger.Warnf("Forgot alive gossip node %s (%s), it remains a cluster member until it leaves", node.Name, node.Address())
+		} else {
+			br.logger.Infof("Forgot gossip node %s (%s)", node.Name, node.Address())
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GossipRediscoverResult reports the peers joined by a peer rediscovery.
+type GossipRediscoverResult struct {
+	Joined []string `json:"joined"`
+}
+
+// gossipRediscoverHandler runs the gossip peer discovery again and
+// joins the new peers, it's a manual recovery lever for partitioned
+// clusters.
+func gossipRediscoverHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "gossip") {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), gossipRediscoverLimit)
+		defer cancel()
+
+		joined, err := br.member.RediscoverPeers(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("while rediscovering gossip peers: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		br.logger.Infof("Gossip peer rediscovery joined %d peer(s)", len(joined))
+
+		if joined == nil {
+			joined = []string{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(GossipRediscoverResult{Joined: joined})
+	}
+}
+
+// gossipCAHandler renders the status of the cluster CA, it responds with
+// a 404 when gossip is disabled as the single node CA isn't shared.
+func gossipCAHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "gossip") {
+			return
+		}
+
+		if br.member == nil {
+			http.Error(w, "gossip is disabled", http.StatusNotFound)
+			return
+		}
+
+		status, err := br.member.CAStatus()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("while reading gossip CA status: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	}
+}
+
+// mirrorCASecret mirrors the CA certificate to the configured Kubernetes
+// Secret, failures (eg: missing RBAC permissions) are logged as warnings.
+func (br *Registry) mirrorCASecret(caCert []byte) {
+	ctx, cancel := context.WithTimeout(br.ctx, caSecretTimeout)
+	defer cancel()
+
+	if err := gossip.MirrorCASecret(ctx, br.beskarConfig, br.kubeClient, caCert); err != nil {
+		br.logger.Warnf("Gossip CA not mirrored to Kubernetes secret: %s", err)
+	}
+}
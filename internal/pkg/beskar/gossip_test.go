@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/gossip"
+)
+
+func TestFilterGossipMembers(t *testing.T) {
+	members := []GossipMember{
+		{Name: "a", State: "alive", Meta: &GossipMemberMeta{Zone: "zone-a"}},
+		{Name: "b", State: "dead", Meta: &GossipMemberMeta{Zone: "zone-b"}},
+		{Name: "c", State: "alive", Meta: &GossipMemberMeta{Zone: "zone-b"}},
+		{Name: "d", State: "alive"},
+	}
+
+	names := func(members []GossipMember) []string {
+		names := make([]string, 0, len(members))
+		for _, member := range members {
+			names = append(names, member.Name)
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"a", "b", "c", "d"}, names(filterGossipMembers(members, GossipMemberFilter{})))
+	require.Equal(t, []string{"b", "c"}, names(filterGossipMembers(members, GossipMemberFilter{Zone: "zone-b"})))
+	require.Equal(t, []string{"a", "c", "d"}, names(filterGossipMembers(members, GossipMemberFilter{State: "alive"})))
+	require.Equal(t, []string{"c"}, names(filterGossipMembers(members, GossipMemberFilter{Zone: "zone-b", State: "alive"})))
+	require.Equal(t, []string{"b"}, names(filterGossipMembers(members, GossipMemberFilter{State: "dead"})))
+	require.Empty(t, filterGossipMembers(members, GossipMemberFilter{Zone: "zone-c"}))
+
+	require.NoError(t, GossipMemberFilter{State: "dead"}.validate())
+	require.ErrorContains(t, GossipMemberFilter{State: "suspect"}.validate(), `unsupported state "suspect"`)
+}
+
+func TestGossipMembersHandler(t *testing.T) {
+	br := &Registry{
+		beskarConfig: &config.BeskarConfig{},
+	}
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "json by default",
+			wantStatus:      http.StatusOK,
+			wantContentType: jsonContentType,
+			wantBody:        "[]\n",
+		},
+		{
+			name:            "yaml",
+			accept:          "application/yaml",
+			wantStatus:      http.StatusOK,
+			wantContentType: yamlContentType,
+			wantBody:        "[]\n",
+		},
+		{
+			name:       "not acceptable",
+			accept:     "text/html",
+			wantStatus: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, gossipMembersPath+"?zone=zone-a", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			rec := httptest.NewRecorder()
+			gossipMembersHandler(br).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			require.Equal(t, tt.wantContentType, rec.Header().Get("Content-Type"))
+			require.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestGossipCAHandlerDisabled(t *testing.T) {
+	br := &Registry{
+		beskarConfig: &config.BeskarConfig{},
+	}
+
+	rec := httptest.NewRecorder()
+	gossipCAHandler(br).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, gossipCAPath, nil))
+
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGossipMembersHandlerCluster(t *testing.T) {
+	br := &Registry{
+		beskarConfig: &config.BeskarConfig{
+			GossipClusters: map[string]config.Gossip{
+				"control": {},
+			},
+		},
+	}
+
+	serve := func(cluster string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		gossipMembersHandler(br).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, gossipMembersPath+"?cluster="+cluster, nil))
+		return rec
+	}
+
+	// the control cluster isn't joined
+	rec := serve("control")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "[]\n", rec.Body.String())
+
+	require.Equal(t, http.StatusNotFound, serve("unknown").Code)
+
+	rec = httptest.NewRecorder()
+	gossipMembersHandler(br).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, gossipMembersPath+"?state=left", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestWatchGossipCluster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	br := &Registry{
+		logger: dcontext.GetLogger(ctx),
+	}
+
+	a, err := gossip.NewMember("a", nil, gossip.WithBindAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer a.Shutdown()
+
+	go br.watchGossipCluster(ctx, "control", a)
+
+	b, err := gossip.NewMember("b", []string{a.LocalNode().Address()}, gossip.WithBindAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer b.Shutdown()
+
+	// more events than the member event channel can hold
+	for i := 0; i < 64; i++ {
+		require.NoError(t, b.Send(a.LocalNode(), []byte(fmt.Sprintf("message %d", i))))
+	}
+
+	// the member still processes joins once the events are consumed
+	c, err := gossip.NewMember("c", []string{a.LocalNode().Address()}, gossip.WithBindAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer c.Shutdown()
+
+	require.Eventually(t, func() bool {
+		return len(a.Peers()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// hopByHopHeaders are the headers which must not be forwarded
+// by proxies as defined in RFC 7230 section 6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type headerFilter struct {
+	allow map[string]struct{}
+	deny  map[string]struct{}
+}
+
+func newHeaderFilter(hf config.HeaderFilter) *headerFilter {
+	filter := &headerFilter{
+		deny: make(map[string]struct{}, len(hf.Deny)),
+	}
+	if len(hf.Allow) > 0 {
+		filter.allow = make(map[string]struct{}, len(hf.Allow))
+		for _, h := range hf.Allow {
+			filter.allow[textproto.CanonicalMIMEHeaderKey(h)] = struct{}{}
+		}
+	}
+	for _, h := range hf.Deny {
+		filter.deny[textproto.CanonicalMIMEHeaderKey(h)] = struct{}{}
+	}
+	return filter
+}
+
+// apply removes hop-by-hop headers, headers not present in the allow
+// list if any and headers present in the deny list. TE: trailers is
+// kept like net/http/httputil does, gRPC backends require it.
+func (hf *headerFilter) apply(header http.Header) {
+	trailers := acceptsTrailers(header)
+
+	// headers listed in Connection are hop-by-hop too
+	for _, value := range header.Values("Connection") {
+		for _, h := range strings.Split(value, ",") {
+			if h = textproto.TrimString(h); h != "" {
+				header.Del(h)
+			}
+		}
+	}
+	for _, h := range hopByHopHeaders {
+		header.Del(h)
+	}
+	if trailers {
+		header.Set("Te", "trailers")
+	}
+	for h := range header {
+		if _, ok := hf.deny[h]; ok {
+			header.Del(h)
+		} else if _, ok := hf.allow[h]; hf.allow != nil && !ok {
+			header.Del(h)
+		}
+	}
+}
+
+// acceptsTrailers returns true if the TE header contains trailers.
+func acceptsTrailers(header http.Header) bool {
+	for _, value := range header.Values("Te") {
+		for _, te := range strings.Split(value, ",") {
+			// transfer codings can have parameters, eg: trailers;q=1
+			te, _, _ = strings.Cut(te, ";")
+			if strings.EqualFold(textproto.TrimString(te), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	newHeader := func() http.Header {
+		return http.Header{
+			"Connection":    []string{"keep-alive, X-Hop"},
+			"Keep-Alive":    []string{"timeout=5"},
+			"X-Hop":         []string{"hop"},
+			"Authorization": []string{"Basic xxx"},
+			"Content-Type":  []string{"application/json"},
+			"X-Request-Id":  []string{"id"},
+		}
+	}
+
+	header := newHeader()
+	newHeaderFilter(config.HeaderFilter{}).apply(header)
+	require.Equal(t, http.Header{
+		"Authorization": []string{"Basic xxx"},
+		"Content-Type":  []string{"application/json"},
+		"X-Request-Id":  []string{"id"},
+	}, header)
+
+	header = newHeader()
+	newHeaderFilter(config.HeaderFilter{
+		Deny: []string{"authorization"},
+	}).apply(header)
+	require.Equal(t, http.Header{
+		"Content-Type": []string{"application/json"},
+		"X-Request-Id": []string{"id"},
+	}, header)
+
+	header = newHeader()
+	newHeaderFilter(config.HeaderFilter{
+		Allow: []string{"content-type", "x-request-id", "connection"},
+		Deny:  []string{"x-request-id"},
+	}).apply(header)
+	require.Equal(t, http.Header{
+		"Content-Type": []string{"application/json"},
+	}, header)
+
+	// TE: trailers is kept, other transfer codings are dropped
+	header = http.Header{
+		"Te":           []string{"gzip, trailers"},
+		"Content-Type": []string{"application/grpc"},
+	}
+	newHeaderFilter(config.HeaderFilter{}).apply(header)
+	require.Equal(t, http.Header{
+		"Te":           []string{"trailers"},
+		"Content-Type": []string{"application/grpc"},
+	}, header)
+
+	header = http.Header{"Te": []string{"gzip"}}
+	newHeaderFilter(config.HeaderFilter{}).apply(header)
+	require.Empty(t, header)
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.ciq.dev/beskar/internal/pkg/gossip"
+)
+
+const (
+	livenessPath  = "/beskar/livez"
+	readinessPath = "/beskar/readyz"
+
+	healthCheckTimeout = 2 * time.Second
+)
+
+var errClusterNotJoined = errors.New("cluster not joined yet")
+
+// Liveness reports whether the process is healthy, it's healthy as long
+// as it's able to serve requests. Cluster and storage states are
+// deliberately ignored: a node unable to join the cluster must be
+// reported as not ready rather than being restarted.
+func (br *Registry) Liveness(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Readiness reports whether the node is able to serve requests: the
+// cluster must be joined with at least the configured minimum number of
+// peers, or gossip is disabled, and the storage must be reachable.
+func (br *Registry) Readiness(ctx context.Context) error {
+	if !br.cacheInitialized.Load() {
+		return errClusterNotJoined
+	}
+
+	if br.beskarConfig.Gossip.IsEnabled() {
+		// cluster nodes include the local node
+		peers := len(br.member.Nodes()) - 1
+		if peers < br.beskarConfig.Gossip.MinPeers {
+			err := fmt.Errorf("%d cluster peers joined, %d required", peers, br.beskarConfig.Gossip.MinPeers)
+			// peers may be unable to join because of a key mismatch
+			lastFailure := br.member.LastDecryptionFailure()
+			if !lastFailure.IsZero() && time.Since(lastFailure) < br.beskarConfig.Gossip.KeyMismatchWindow {
+				err = fmt.Errorf("%w: %w", err, gossip.ErrKeyMismatch)
+			}
+			return err
+		}
+	}
+
+	if _, err := br.listBeskarTags(ctx); err != nil {
+		return fmt.Errorf("storage unreachable: %w", err)
+	}
+
+	return nil
+}
+
+// startupHandler rejects the requests until the cluster is joined, the
+// health checks are still served so the node is reported alive while
+// it's joining the cluster.
+func startupHandler(started *atomic.Bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !started.Load() && r.URL.Path != livenessPath && r.URL.Path != readinessPath {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "service starting", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func healthHandler(check func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		if err := check(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	}
+}
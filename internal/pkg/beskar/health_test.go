@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/gossip"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	br := &Registry{
+		beskarConfig: &config.BeskarConfig{},
+	}
+
+	rec := httptest.NewRecorder()
+	healthHandler(br.Liveness).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, livenessPath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// a node which didn't join the cluster yet is alive but not ready
+	rec = httptest.NewRecorder()
+	healthHandler(br.Readiness).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, readinessPath, nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Contains(t, rec.Body.String(), errClusterNotJoined.Error())
+}
+
+func TestStartupHandler(t *testing.T) {
+	var started atomic.Bool
+
+	handler := startupHandler(&started, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	// health checks are served while joining the cluster
+	require.Equal(t, http.StatusOK, get(livenessPath))
+	require.Equal(t, http.StatusOK, get(readinessPath))
+	require.Equal(t, http.StatusServiceUnavailable, get("/v2/"))
+
+	started.Store(true)
+	require.Equal(t, http.StatusOK, get("/v2/"))
+}
+
+func TestReadinessKeyMismatch(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	member, err := gossip.NewMember("a", nil, gossip.WithBindAddress("127.0.0.1:0"), gossip.WithSecretKey(key))
+	require.NoError(t, err)
+	defer func() {
+		_ = member.Shutdown()
+	}()
+
+	br := &Registry{
+		beskarConfig: &config.BeskarConfig{
+			Gossip: config.Gossip{
+				MinPeers:          1,
+				KeyMismatchWindow: time.Minute,
+			},
+		},
+		member: member,
+	}
+	br.cacheInitialized.Store(true)
+
+	err = br.Readiness(context.Background())
+	require.EqualError(t, err, "0 cluster peers joined, 1 required")
+
+	// a peer with another key tries to join
+	_, err = gossip.NewMember(
+		"b", []string{member.LocalNode().Address()},
+		gossip.WithBindAddress("127.0.0.1:0"),
+		gossip.WithSecretKey(bytes.Repeat([]byte{2}, 32)),
+		gossip.WithJoinRetries(0, 1),
+	)
+	require.ErrorIs(t, err, gossip.ErrKeyMismatch)
+
+	require.Eventually(t, func() bool {
+		return errors.Is(br.Readiness(context.Background()), gossip.ErrKeyMismatch)
+	}, 5*time.Second, 10*time.Millisecond)
+}
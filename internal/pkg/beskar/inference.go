@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"path"
+	"sort"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// layerTitleAnnotation is the annotation set to the file name of the
+// layers by ORAS.
+const layerTitleAnnotation = "org.opencontainers.image.title"
+
+// inferenceRule matches the layers of the manifests routed to a plugin.
+type inferenceRule struct {
+	mediatype       string
+	filenames       []string
+	layerMediatypes map[string]struct{}
+}
+
+func (ir inferenceRule) match(layer v1.Descriptor) bool {
+	if _, ok := ir.layerMediatypes[string(layer.MediaType)]; ok {
+		return true
+	}
+
+	title, ok := layer.Annotations[layerTitleAnnotation]
+	if !ok {
+		return false
+	}
+
+	for _, pattern := range ir.filenames {
+		// patterns are validated with the configuration
+		if matched, _ := path.Match(pattern, path.Base(title)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mediatypeInferrer infers the plugin media type of manifests pushed
+// with a generic config media type from their layers.
+type mediatypeInferrer struct {
+	generic map[string]struct{}
+	rules   []inferenceRule
+}
+
+// newMediatypeInferrer returns the media type inferrer, nil is returned
+// when the inference is disabled. Rules are evaluated in the plugin name
+// order.
+func newMediatypeInferrer(inference config.MediatypeInference, plugins map[string]config.Plugin) *mediatypeInferrer {
+	if !inference.Enabled {
+		return nil
+	}
+
+	mi := &mediatypeInferrer{
+		generic: make(map[string]struct{}, len(inference.GenericMediatypes)),
+	}
+
+	for _, mediatype := range inference.GenericMediatypes {
+		mi.generic[mediatype] = struct{}{}
+	}
+
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		plugin := plugins[name]
+		if len(plugin.Inference.Filenames) == 0 && len(plugin.Inference.LayerMediatypes) == 0 {
+			continue
+		}
+
+		rule := inferenceRule{
+			mediatype:       plugin.Mediatype,
+			filenames:       plugin.Inference.Filenames,
+			layerMediatypes: make(map[string]struct{}, len(plugin.Inference.LayerMediatypes)),
+		}
+		for _, mediatype := range plugin.Inference.LayerMediatypes {
+			rule.layerMediatypes[mediatype] = struct{}{}
+		}
+
+		mi.rules = append(mi.rules, rule)
+	}
+
+	return mi
+}
+
+// infer returns the media type of the plugin whose rules match a layer
+// of the manifest, explicit config media types are never overridden.
+func (mi *mediatypeInferrer) infer(configMediatype string, layers []v1.Descriptor) (string, bool) {
+	if mi == nil {
+		return "", false
+	} else if _, ok := mi.generic[configMediatype]; !ok {
+		return "", false
+	}
+
+	for _, layer := range layers {
+		for _, rule := range mi.rules {
+			if rule.match(layer) {
+				return rule.mediatype, true
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestMediatypeInferrer(t *testing.T) {
+	const (
+		yumMediatype   = "application/vnd.ciq.rpm-package.v1.config+json"
+		isoMediatype   = "application/vnd.ciq.iso.v1.config+json"
+		rpmLayerType   = "application/vnd.ciq.rpm-package.v1.bin"
+		octetStream    = "application/octet-stream"
+		imageMediatype = "application/vnd.oci.image.config.v1+json"
+	)
+
+	plugins := map[string]config.Plugin{
+		"yum": {
+			Mediatype: yumMediatype,
+			Inference: config.PluginInference{
+				Filenames:       []string{"*.rpm"},
+				LayerMediatypes: []string{rpmLayerType},
+			},
+		},
+		"iso": {
+			Mediatype: isoMediatype,
+			Inference: config.PluginInference{
+				Filenames: []string{"*.iso"},
+			},
+		},
+		"static": {
+			Mediatype: "application/vnd.ciq.static.v1.config+json",
+		},
+	}
+
+	titled := func(mediatype, title string) v1.Descriptor {
+		return v1.Descriptor{
+			MediaType:   types.MediaType(mediatype),
+			Annotations: map[string]string{layerTitleAnnotation: title},
+		}
+	}
+
+	require.Nil(t, newMediatypeInferrer(config.MediatypeInference{}, plugins))
+
+	inferrer := newMediatypeInferrer(config.MediatypeInference{
+		Enabled:           true,
+		GenericMediatypes: config.DefaultGenericMediatypes,
+	}, plugins)
+
+	tests := []struct {
+		name          string
+		configMedia   string
+		layers        []v1.Descriptor
+		wantMediatype string
+		wantInferred  bool
+	}{
+		{
+			name:          "filename pattern",
+			configMedia:   octetStream,
+			layers:        []v1.Descriptor{titled(octetStream, "bash-5.1-1.x86_64.rpm")},
+			wantMediatype: yumMediatype,
+			wantInferred:  true,
+		},
+		{
+			name:          "filename pattern with directory",
+			configMedia:   "application/vnd.unknown.config.v1+json",
+			layers:        []v1.Descriptor{titled(octetStream, "images/boot.iso")},
+			wantMediatype: isoMediatype,
+			wantInferred:  true,
+		},
+		{
+			name:          "layer media type",
+			configMedia:   "",
+			layers:        []v1.Descriptor{{MediaType: rpmLayerType}},
+			wantMediatype: yumMediatype,
+			wantInferred:  true,
+		},
+		{
+			name:        "explicit media type takes precedence",
+			configMedia: imageMediatype,
+			layers:      []v1.Descriptor{titled(octetStream, "bash-5.1-1.x86_64.rpm")},
+		},
+		{
+			name:        "no matching layer",
+			configMedia: octetStream,
+			layers:      []v1.Descriptor{titled(octetStream, "README.md"), {MediaType: octetStream}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediatype, ok := inferrer.infer(tt.configMedia, tt.layers)
+			require.Equal(t, tt.wantInferred, ok)
+			require.Equal(t, tt.wantMediatype, mediatype)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+const maintenancePath = "/beskar/api/v1/maintenance"
+
+// maintenanceStatus is the maintenance mode representation of the admin API,
+// the maintenance mode is node local and Node is the node it applies to.
+type maintenanceStatus struct {
+	Node       string   `json:"node"`
+	Enabled    bool     `json:"enabled"`
+	Block      []string `json:"block"`
+	Plugins    []string `json:"plugins"`
+	RetryAfter string   `json:"retry-after"`
+}
+
+// maintenanceMode holds the maintenance settings which can be changed at
+// runtime, it's initialized from the configuration.
+type maintenanceMode struct {
+	mutex    sync.RWMutex
+	settings config.Maintenance
+	// prefixes are the path prefixes of the plugins in maintenance.
+	prefixes []string
+	plugins  map[string]config.Plugin
+}
+
+func newMaintenanceMode(beskarConfig *config.BeskarConfig) *maintenanceMode {
+	mm := &maintenanceMode{
+		plugins: beskarConfig.Plugins,
+	}
+	mm.set(beskarConfig.Maintenance)
+	return mm
+}
+
+func (mm *maintenanceMode) set(settings config.Maintenance) {
+	prefixes := make([]string, 0, 2*len(settings.Plugins))
+	for _, name := range settings.Plugins {
+		prefix := "/" + strings.Trim(mm.plugins[name].Prefix, "/")
+		// plugin artifacts are pushed to repositories named after the prefix
+		prefixes = append(prefixes, prefix+"/", "/v2"+prefix+"/")
+	}
+
+	mm.mutex.Lock()
+	mm.settings = settings
+	mm.prefixes = prefixes
+	mm.mutex.Unlock()
+}
+
+func (mm *maintenanceMode) get() config.Maintenance {
+	mm.mutex.RLock()
+	defer mm.mutex.RUnlock()
+
+	return mm.settings
+}
+
+// blocks returns true if the request is rejected by the maintenance mode,
+// the Retry-After delay is returned along.
+func (mm *maintenanceMode) blocks(r *http.Request) (bool, time.Duration) {
+	// admin, health and profiling endpoints remain available
+	if strings.HasPrefix(r.URL.Path, "/beskar/") || strings.HasPrefix(r.URL.Path, "/debug/") {
+		return false, 0
+	}
+
+	mm.mutex.RLock()
+	defer mm.mutex.RUnlock()
+
+	if !mm.settings.Enabled {
+		return false, 0
+	}
+
+	operation := config.MaintenanceBlockWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		operation = config.MaintenanceBlockRead
+	}
+	if !mm.settings.Blocks(operation) {
+		return false, 0
+	} else if len(mm.prefixes) == 0 {
+		return true, mm.settings.RetryAfter
+	}
+
+	for _, prefix := range mm.prefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true, mm.settings.RetryAfter
+		}
+	}
+
+	return false, 0
+}
+
+// maintenanceHandler rejects requests blocked by the maintenance mode
+// with a 503 response.
+func maintenanceHandler(mm *maintenanceMode, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked, retryAfter := mm.blocks(r); blocked {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			http.Error(w, "service in maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// nodeName returns the gossip name of the node, or its hostname
+// in single node mode.
+func (br *Registry) nodeName() string {
+	if node := br.member.LocalNode(); node != nil {
+		return node.Name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// maintenanceSettingsHandler renders the maintenance mode settings on GET
+// and replaces them on PUT.
+func maintenanceSettingsHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "maintenance") {
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			var status maintenanceStatus
+
+			if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+				http.Error(w, fmt.Sprintf("while decoding maintenance settings: %s", err), http.StatusBadRequest)
+				return
+			}
+
+			settings := config.Maintenance{
+				Enabled: status.Enabled,
+				Block:   status.Block,
+				Plugins: status.Plugins,
+			}
+			if status.RetryAfter != "" {
+				retryAfter, err := time.ParseDuration(status.RetryAfter)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("while parsing retry-after: %s", err), http.StatusBadRequest)
+					return
+				}
+				settings.RetryAfter = retryAfter
+			}
+			if err := settings.SetDefaults(br.beskarConfig.Plugins); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			br.maintenance.set(settings)
+			br.logger.Warnf(
+				"Maintenance mode set to enabled=%t, blocked operations: %s, plugins: %s",
+				settings.Enabled, strings.Join(settings.Block, ", "), strings.Join(settings.Plugins, ", "),
+			)
+		}
+
+		settings := br.maintenance.get()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(maintenanceStatus{
+			Node:       br.nodeName(),
+			Enabled:    settings.Enabled,
+			Block:      settings.Block,
+			Plugins:    settings.Plugins,
+			RetryAfter: settings.RetryAfter.String(),
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	beskarConfig := &config.BeskarConfig{
+		Plugins: map[string]config.Plugin{
+			"yum": {Prefix: "/yum"},
+		},
+	}
+	br := &Registry{
+		beskarConfig: beskarConfig,
+		maintenance:  newMaintenanceMode(beskarConfig),
+		logger:       dcontext.GetLogger(context.Background()),
+	}
+
+	handler := maintenanceHandler(br.maintenance, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, "/v2/yum/repo/manifests/latest").Code)
+
+	setMaintenance := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		maintenanceSettingsHandler(br).ServeHTTP(rec, httptest.NewRequest(http.MethodPut, maintenancePath, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := setMaintenance(`{"enabled": true, "plugins": ["yum"], "retry-after": "30s"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// the node the maintenance mode applies to is the host in single node mode
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(
+		`{"node": %q, "enabled": true, "block": ["write"], "plugins": ["yum"], "retry-after": "30s"}`, hostname,
+	), rec.Body.String())
+
+	rec = serve(http.MethodPost, "/yum/api/v1/repo/test/verify")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "30", rec.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPut, "/v2/yum/repo/manifests/latest").Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "/yum/repo/test/repodata/repomd.xml").Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, "/v2/other/manifests/latest").Code)
+
+	// the whole server, reads included
+	rec = setMaintenance(`{"enabled": true, "block": ["write", "read"]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(http.MethodGet, "/v2/other/manifests/latest")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "120", rec.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, readinessPath).Code)
+
+	rec = setMaintenance(`{"enabled": true, "plugins": ["unknown"]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = setMaintenance(`{"enabled": false}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "/v2/other/manifests/latest").Code)
+}
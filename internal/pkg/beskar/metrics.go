@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	pluginNamespace = metrics.NewNamespace("beskar", "plugin")
+
+	routedManifestsCounter = pluginNamespace.NewLabeledCounter("routed_manifests", "The number of manifests routed to a plugin", "mediatype", "prefix")
+	// unrouted media types are not used as label as they are client defined
+	unroutedManifestsCounter = pluginNamespace.NewCounter("unrouted_manifests", "The number of manifests whose media type matches no plugin")
+	inferredManifestsCounter = pluginNamespace.NewLabeledCounter("inferred_manifests", "The number of manifests with a generic media type routed by inference", "mediatype")
+
+	cacheNamespace = metrics.NewNamespace("beskar", "cache")
+
+	cacheDisagreementsCounter = cacheNamespace.NewCounter("storage_disagreements", "The number of cached manifests found missing from the storage")
+	// the manifests set are still served from the storage
+	cacheRemoteInvalidationFailuresCounter = cacheNamespace.NewCounter("remote_invalidation_failures", "The number of cached manifests set which couldn't be removed from the peers of other zones")
+)
+
+func init() {
+	metrics.Register(pluginNamespace)
+	metrics.Register(cacheNamespace)
+}
+
+// WithMeterProvider sets the meter provider used by the OpenTelemetry
+// metrics backend, by default metrics are pushed to the configured OTLP
+// collector and exported with the Prometheus default registerer.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(br *Registry) {
+		br.meterProvider = mp
+	}
+}
+
+func (br *Registry) initMetrics(ctx context.Context) error {
+	if br.beskarConfig.Metrics.Backend != config.MetricsBackendOpenTelemetry {
+		return nil
+	} else if br.meterProvider == nil {
+		meterProvider, err := metrics.NewOTLPMeterProvider(ctx, br.beskarConfig.Metrics.Exporter, nil)
+		if err != nil {
+			return err
+		}
+		br.meterProvider = meterProvider
+		br.shutdownMetrics = meterProvider.Shutdown
+	}
+	return metrics.SetBackend(metrics.NewOpenTelemetryBackend(br.meterProvider))
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiateContentType returns the offered media type preferred by the
+// Accept header of the request, ties are broken by the offers order. The
+// first offer is returned without Accept header and an empty string is
+// returned when no offer is acceptable.
+func negotiateContentType(r *http.Request, offers ...string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 || len(offers) == 0 {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+
+	type acceptRange struct {
+		mediatype string
+		q         float64
+	}
+
+	var ranges []acceptRange
+
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			mediatype, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+			}
+			ranges = append(ranges, acceptRange{mediatype: mediatype, q: q})
+		}
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, offer := range offers {
+		// the quality of an offer is the one of the most specific range
+		specificity, q := -1, 0.0
+
+		for _, ar := range ranges {
+			s := -1
+			switch {
+			case ar.mediatype == offer:
+				s = 2
+			case strings.HasSuffix(ar.mediatype, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(ar.mediatype, "*")):
+				s = 1
+			case ar.mediatype == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				specificity, q = s, ar.q
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"application/json", "application/yaml"}
+
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{
+			name: "no accept header",
+			want: "application/json",
+		},
+		{
+			name:   "any",
+			accept: "*/*",
+			want:   "application/json",
+		},
+		{
+			name:   "exact",
+			accept: "application/yaml",
+			want:   "application/yaml",
+		},
+		{
+			name:   "quality",
+			accept: "application/json;q=0.5, application/yaml",
+			want:   "application/yaml",
+		},
+		{
+			name:   "most specific range",
+			accept: "application/*;q=0.1, application/yaml;q=0, */*",
+			want:   "application/json",
+		},
+		{
+			name:   "not acceptable",
+			accept: "text/html",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			require.Equal(t, tt.want, negotiateContentType(req, offers...))
+		})
+	}
+}
@@ -6,7 +6,10 @@ package beskar
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
@@ -14,17 +17,65 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"go.ciq.dev/beskar/internal/pkg/config"
 	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"go.ciq.dev/beskar/pkg/netutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxRejectionReasonSize bounds the reason of a manifest rejected
+// by a plugin forwarded to clients.
+const maxRejectionReasonSize = 4096
+
+// errIdempotencyKeyConflict is returned to clients when the idempotency key
+// was already used to upload a different content.
+var errIdempotencyKeyConflict = errcode.Register("beskar", errcode.ErrorDescriptor{
+	Value:          "IDEMPOTENCY_KEY_CONFLICT",
+	Message:        "idempotency key already used with a different content",
+	Description:    "Returned when the idempotency key was already used to upload a different content.",
+	HTTPStatusCode: http.StatusConflict,
+})
+
 type proxyPlugin struct {
-	url *url.URL
+	prefix          string
+	url             *url.URL
+	authorizeURL    *url.URL
+	client          *http.Client
+	requestIDHeader string
+	tracer          trace.Tracer
+	propagator      propagation.TextMapPropagator
 }
 
-func (pp proxyPlugin) send(ctx context.Context, repository string, mediaType string, payload []byte, dgst string) error {
+func (pp proxyPlugin) send(ctx context.Context, repository string, mediaType string, payload []byte, dgst string) (errFn error) {
+	ctx, span := pp.tracer.Start(
+		ctx,
+		"plugin.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("beskar.repository", repository),
+			attribute.String("beskar.mediatype", mediaType),
+		),
+	)
+	defer func() {
+		if errFn != nil {
+			span.SetStatus(codes.Error, errFn.Error())
+		}
+		span.End()
+	}()
+
 	event := &eventv1.ManifestEvent{
 		Digest:     dgst,
 		Mediatype:  mediaType,
@@ -43,22 +94,91 @@ func (pp proxyPlugin) send(ctx context.Context, repository string, mediaType str
 	}
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/octet-stream")
+	pp.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
 
-	client := http.DefaultClient
+	if requestID := getRequestID(ctx); requestID != "" {
+		req.Header.Set(pp.requestIDHeader, requestID)
+	}
 
-	resp, err := client.Do(req)
+	// forward client idempotency key to plugin if any
+	if clientReq, err := dcontext.GetRequest(ctx); err == nil {
+		if key := clientReq.Header.Get(idempotencyKeyHeader); key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+	}
+
+	resp, err := pp.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode == http.StatusConflict {
+		return errIdempotencyKeyConflict.WithArgs()
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		// the plugin limits the concurrent uploads,
+		// its retry delay is forwarded to the client
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if w, err := dcontext.GetResponseWriter(ctx); err == nil {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+		}
+		return errcode.ErrorCodeTooManyRequests.WithArgs()
+	} else if resp.StatusCode == http.StatusBadRequest {
+		// the plugin has rejected the manifest content,
+		// its reason is forwarded to the client
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, maxRejectionReasonSize))
+		return v2.ErrorCodeManifestInvalid.WithDetail(strings.TrimSpace(string(reason)))
+	} else if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("plugin backend has returned an unknown status %d", resp.StatusCode)
 	}
 
 	return nil
 }
 
+// authorizeRepository asks the plugin whether the client credentials are
+// granted the action on the plugin repository.
+func (pp proxyPlugin) authorizeRepository(ctx context.Context, r *http.Request, repository, action string) (repositoryAccess, string, error) {
+	authorizeURL := *pp.authorizeURL
+	authorizeURL.RawQuery = url.Values{
+		"repository": []string{repository},
+		"action":     []string{action},
+	}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authorizeURL.String(), nil)
+	if err != nil {
+		return repositoryAccessDefault, "", err
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := pp.client.Do(req)
+	if err != nil {
+		return repositoryAccessDefault, "", fmt.Errorf("while authorizing repository %s: %w", repository, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		granted := struct {
+			Identity string `json:"identity"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&granted); err != nil {
+			return repositoryAccessDefault, "", fmt.Errorf("while decoding repository %s authorization: %w", repository, err)
+		}
+		return repositoryAccessGranted, granted.Identity, nil
+	case http.StatusForbidden:
+		return repositoryAccessDenied, "", nil
+	case http.StatusNoContent, http.StatusNotFound:
+		// plugins without repository authorization
+		// don't implement the endpoint
+		return repositoryAccessDefault, "", nil
+	}
+
+	return repositoryAccessDefault, "", fmt.Errorf("plugin backend has returned an unknown status %d while authorizing repository %s", resp.StatusCode, repository)
+}
+
 func initPlugins(ctx context.Context, registry *Registry) error {
 	logger := dcontext.GetLogger(ctx)
 
@@ -68,18 +188,27 @@ func initPlugins(ctx context.Context, registry *Registry) error {
 	}
 	execPath := filepath.Dir(self)
 
-	for name, plugin := range registry.beskarConfig.Plugins {
+	plugins := registry.beskarConfig.Plugins
+	rateLimiters := pluginRateLimiters(registry.beskarConfig, registry.trustedProxies)
+
+	// the router matches the plugin prefixes in the registration order
+	for _, name := range registry.beskarConfig.PluginRouting.ResolutionOrder(plugins) {
+		plugin := plugins[name]
+
 		logger.Infof("Loading plugin %s service", name)
 
 		if len(plugin.Backends) != 1 {
 			return fmt.Errorf("only backend supported for now")
 		}
 
-		logger.Debugf("Using plugin backend URL %s", plugin.Backends[0].URL)
+		backend := plugin.Backends[0]
+		timeout := plugin.RequestTimeout
+
+		logger.Debugf("Using plugin backend URL %s", backend.URL)
 
-		pluginURL, err := url.Parse(plugin.Backends[0].URL)
+		pluginURL, err := url.Parse(backend.URL)
 		if err != nil {
-			return fmt.Errorf("while parsing plugin URL %s: %w", plugin.Backends[0].URL, err)
+			return fmt.Errorf("while parsing plugin URL %s: %w", backend.URL, err)
 		}
 
 		executable := pluginURL.Query().Get("executable")
@@ -98,16 +227,194 @@ func initPlugins(ctx context.Context, registry *Registry) error {
 
 		pluginURL.RawQuery = ""
 
+		var dnsCache *netutil.DNSCache
+		if plugin.Client.DNSCacheTTL > 0 {
+			dnsCache = netutil.NewDNSCache(plugin.Client.DNSCacheTTL, nil)
+			registry.pluginDNSCaches[name] = dnsCache
+		}
+
+		transport, err := newPluginTransport(pluginURL.Scheme, plugin.Client, dnsCache)
+		if err != nil {
+			return fmt.Errorf("while creating plugin %s transport: %w", name, err)
+		}
+
+		requestFilter := newHeaderFilter(plugin.Headers.Request)
+		responseFilter := newHeaderFilter(plugin.Headers.Response)
+
 		proxy := httputil.NewSingleHostReverseProxy(pluginURL)
-		registry.router.PathPrefix(plugin.Prefix).Handler(proxy)
+		proxy.Transport = transport
+		director := proxy.Director
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			requestFilter.apply(req.Header)
+			registry.propagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+		}
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			responseFilter.apply(resp.Header)
+			return nil
+		}
+		proxy.ErrorHandler, err = newProxyErrorHandler(name, plugin.ErrorResponses)
+		if err != nil {
+			return err
+		}
+		proxy.ErrorHandler = flushDNSOnError(proxy.ErrorHandler, dnsCache, pluginURL.Hostname())
+		setResponseBuffering(proxy, plugin.ResponseBuffering)
+		registry.router.PathPrefix(plugin.Prefix).Handler(
+			rateLimitHandler(name, rateLimiters[name], registry.authorizeHandler(name, registry.responseCache.handler(withTimeout(proxy, timeout)))),
+		)
 
 		purl := *pluginURL
 		purl.Path = "/event"
 
+		aurl := *pluginURL
+		aurl.Path = "/authorize"
+
 		registry.proxyPlugins[plugin.Mediatype] = &proxyPlugin{
-			url: &purl,
+			prefix:          plugin.Prefix,
+			url:             &purl,
+			authorizeURL:    &aurl,
+			client:          &http.Client{Transport: transport, Timeout: timeout},
+			requestIDHeader: registry.beskarConfig.RequestIDHeader,
+			tracer:          registry.tracer,
+			propagator:      registry.propagator,
 		}
 	}
 
 	return nil
 }
+
+// withTimeout bounds the time a plugin backend takes to send the response
+// headers once the request body has been read, request and response bodies
+// are streamed without time limit so that large uploads and downloads are
+// not interrupted. The handler is returned as is with a zero timeout.
+func withTimeout(handler http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+
+		ht := &headerTimeout{
+			timeout: timeout,
+			cancel:  cancel,
+		}
+		defer ht.stop()
+
+		r = r.WithContext(ctx)
+		if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+			ht.start()
+		} else {
+			r.Body = &headerTimeoutBody{ReadCloser: r.Body, timeout: ht}
+		}
+
+		handler.ServeHTTP(&headerTimeoutWriter{ResponseWriter: w, timeout: ht}, r)
+	})
+}
+
+// headerTimeout cancels the request with context.DeadlineExceeded as cause
+// if it's not stopped within the timeout once started.
+type headerTimeout struct {
+	timeout time.Duration
+	cancel  context.CancelCauseFunc
+
+	mutex   sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func (ht *headerTimeout) start() {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	if ht.timer == nil && !ht.stopped {
+		ht.timer = time.AfterFunc(ht.timeout, func() {
+			ht.cancel(context.DeadlineExceeded)
+		})
+	}
+}
+
+func (ht *headerTimeout) stop() {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	ht.stopped = true
+	if ht.timer != nil {
+		ht.timer.Stop()
+	}
+}
+
+// headerTimeoutBody starts the timeout once the request body is read.
+type headerTimeoutBody struct {
+	io.ReadCloser
+	timeout *headerTimeout
+}
+
+func (b *headerTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.timeout.start()
+	}
+	return n, err
+}
+
+func (b *headerTimeoutBody) Close() error {
+	b.timeout.start()
+	return b.ReadCloser.Close()
+}
+
+// headerTimeoutWriter stops the timeout once the response headers are sent.
+type headerTimeoutWriter struct {
+	http.ResponseWriter
+	timeout *headerTimeout
+}
+
+func (w *headerTimeoutWriter) WriteHeader(status int) {
+	// informational responses precede the response headers
+	if status >= http.StatusOK {
+		w.timeout.stop()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerTimeoutWriter) Write(p []byte) (int, error) {
+	w.timeout.stop()
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap allows http.ResponseController to flush and hijack the connection.
+func (w *headerTimeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flushDNSOnError flushes the cached resolution of the backend host
+// before reporting a backend error, the backend may have moved to other
+// addresses. The handler is returned as is without DNS cache.
+func flushDNSOnError(handler func(http.ResponseWriter, *http.Request, error), dnsCache *netutil.DNSCache, host string) func(http.ResponseWriter, *http.Request, error) {
+	if dnsCache == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		dnsCache.Flush(host)
+		handler(w, r, err)
+	}
+}
+
+// proxyErrorHandler reports plugin backend errors, requests
+// exceeding the backend timeout are reported as gateway timeouts.
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	_, status := proxyFailure(r, err)
+
+	dcontext.GetLogger(r.Context()).Errorf("plugin backend error: %s", err)
+
+	w.WriteHeader(status)
+}
+
+// proxyFailure returns the failure kind of a plugin backend
+// error along with the status reported by default.
+func proxyFailure(r *http.Request, err error) (string, int) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(context.Cause(r.Context()), context.DeadlineExceeded) {
+		return config.PluginErrorTimeout, http.StatusGatewayTimeout
+	}
+	return config.PluginErrorUnavailable, http.StatusBadGateway
+}
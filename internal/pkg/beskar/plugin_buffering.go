@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// bufferedAttempts is the number of times idempotent requests are sent
+// to a plugin backend with buffered responses.
+const bufferedAttempts = 2
+
+// bufferedTransport reads plugin backend responses in memory before
+// they are sent to clients, GET and HEAD requests are sent again when
+// the backend fails before the response is fully read. Responses larger
+// than the limit are streamed past the limit and aren't retried.
+type bufferedTransport struct {
+	next  http.RoundTripper
+	limit int64
+}
+
+func (bt *bufferedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		(req.Body == nil || req.Body == http.NoBody)
+
+	var err error
+
+	for attempt := 0; attempt < bufferedAttempts; attempt++ {
+		var resp *http.Response
+
+		resp, err = bt.next.RoundTrip(req)
+		if err == nil {
+			resp, err = bt.buffer(resp)
+			if err == nil {
+				return resp, nil
+			}
+		}
+
+		if !retryable || req.Context().Err() != nil {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// buffer reads the response body in memory up to the limit, the body
+// of larger responses is read from the buffer then from the backend.
+func (bt *bufferedTransport) buffer(resp *http.Response) (*http.Response, error) {
+	if resp.ContentLength > bt.limit {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, bt.limit+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+
+	if int64(len(body)) > bt.limit {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+			Closer: resp.Body,
+		}
+		return resp, nil
+	}
+
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// HEAD responses advertise the length of the GET response body
+	if resp.Request == nil || resp.Request.Method != http.MethodHead {
+		resp.ContentLength = int64(len(body))
+		resp.TransferEncoding = nil
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return resp, nil
+}
+
+// setResponseBuffering configures how the proxy sends the plugin
+// backend responses to clients.
+func setResponseBuffering(proxy *httputil.ReverseProxy, mode string) {
+	switch mode {
+	case config.PluginResponseBuffer:
+		proxy.Transport = &bufferedTransport{
+			next:  proxy.Transport,
+			limit: int64(config.DefaultPluginResponseBufferLimit),
+		}
+	default:
+		// flush each write so streamed responses reach clients promptly
+		proxy.FlushInterval = -1
+	}
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// PluginErrorContext is the context of the plugin error response
+// body templates.
+type PluginErrorContext struct {
+	Plugin string
+	Method string
+	Path   string
+	// Kind is the failure kind, either timeout or unavailable.
+	Kind string
+	// Error describes the failure kind, the backend error isn't
+	// exposed as it may contain the backend addresses.
+	Error string
+	// Status is the status reported by default.
+	Status int
+}
+
+// proxyFailureErrors are the failure descriptions
+// exposed to the error response body templates.
+var proxyFailureErrors = map[string]string{
+	config.PluginErrorTimeout:     "plugin backend timed out",
+	config.PluginErrorUnavailable: "plugin backend unavailable",
+}
+
+type pluginErrorResponse struct {
+	config.PluginErrorResponse
+	template config.PluginErrorTemplate
+}
+
+// newProxyErrorHandler returns the proxy error handler of the plugin
+// sending the first error response matching the backend failure, the
+// default handler is used if no response is configured or matches.
+func newProxyErrorHandler(plugin string, responses []config.PluginErrorResponse) (func(http.ResponseWriter, *http.Request, error), error) {
+	if len(responses) == 0 {
+		return proxyErrorHandler, nil
+	}
+
+	errorResponses := make([]pluginErrorResponse, 0, len(responses))
+
+	for i, response := range responses {
+		// templates are validated while parsing the configuration
+		tmpl, err := response.Template(fmt.Sprintf("%s-%d", plugin, i))
+		if err != nil {
+			return nil, fmt.Errorf("while parsing plugin %s error response body template: %w", plugin, err)
+		}
+		errorResponses = append(errorResponses, pluginErrorResponse{
+			PluginErrorResponse: response,
+			template:            tmpl,
+		})
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		failure, status := proxyFailure(r, err)
+
+		logger := dcontext.GetLogger(r.Context())
+		logger.Errorf("plugin backend error: %s", err)
+
+		for _, response := range errorResponses {
+			if !response.Matches(failure) {
+				continue
+			}
+
+			body := new(bytes.Buffer)
+
+			templateErr := response.template.Execute(body, PluginErrorContext{
+				Plugin: plugin,
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Kind:   failure,
+				Error:  proxyFailureErrors[failure],
+				Status: status,
+			})
+			if templateErr != nil {
+				logger.Errorf("while executing plugin %s error response body template: %s", plugin, templateErr)
+				break
+			}
+
+			if response.Status != 0 {
+				status = response.Status
+			}
+			if response.ContentType != "" {
+				w.Header().Set("Content-Type", response.ContentType)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+			w.WriteHeader(status)
+			_, _ = body.WriteTo(w)
+			return
+		}
+
+		w.WriteHeader(status)
+	}, nil
+}
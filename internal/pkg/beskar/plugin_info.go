@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+const (
+	BackendHealthy   = "healthy"
+	BackendUnhealthy = "unhealthy"
+	BackendUnknown   = "unknown"
+)
+
+// PluginBackendInfo describes a plugin backend, the URL is redacted.
+type PluginBackendInfo struct {
+	URL    string `json:"url"`
+	MTLS   bool   `json:"mtls"`
+	Health string `json:"health"`
+}
+
+// PluginInfo describes a loaded plugin.
+type PluginInfo struct {
+	Name      string              `json:"name"`
+	Prefix    string              `json:"prefix"`
+	Mediatype string              `json:"mediatype"`
+	Backends  []PluginBackendInfo `json:"backends"`
+}
+
+// ListPlugins returns a summary of the loaded plugins sorted by name,
+// backend health reflects whether the backend accepts connections. The
+// cached resolution of unhealthy backend hosts is flushed, it's also
+// flushed by the proxy on backend errors.
+func (br *Registry) ListPlugins(ctx context.Context) []PluginInfo {
+	plugins := make([]PluginInfo, 0, len(br.beskarConfig.Plugins))
+
+	type probedBackend struct {
+		plugin string
+		url    *url.URL
+		info   *PluginBackendInfo
+	}
+
+	var probed []probedBackend
+
+	for name, plugin := range br.beskarConfig.Plugins {
+		info := PluginInfo{
+			Name:      name,
+			Prefix:    plugin.Prefix,
+			Mediatype: plugin.Mediatype,
+			Backends:  make([]PluginBackendInfo, len(plugin.Backends)),
+		}
+
+		for i, backend := range plugin.Backends {
+			backendInfo := &info.Backends[i]
+			backendInfo.MTLS = backend.MTLS.Enabled
+			backendInfo.Health = BackendUnknown
+
+			u, err := url.Parse(backend.URL)
+			if err != nil {
+				backendInfo.URL = invalidURL
+				continue
+			}
+			backendInfo.URL = redactURL(u)
+
+			probed = append(probed, probedBackend{plugin: name, url: u, info: backendInfo})
+		}
+
+		plugins = append(plugins, info)
+	}
+
+	urls := make([]*url.URL, len(probed))
+	for i, backend := range probed {
+		urls[i] = backend.url
+	}
+
+	healths := probeBackends(ctx, urls, br.beskarConfig.PluginHealth, backendHealth)
+
+	for i, backend := range probed {
+		backend.info.Health = healths[i]
+		// the backend may have moved to other addresses
+		if dnsCache := br.pluginDNSCaches[backend.plugin]; healths[i] == BackendUnhealthy && dnsCache != nil {
+			dnsCache.Flush(backend.url.Hostname())
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+
+	return plugins
+}
+
+// probeBackends probes the backends with a bounded pool of workers and
+// returns their health in order. A probe exceeding the timeout is
+// abandoned and the backend is reported unhealthy, the worker moves on
+// to the next backend while the probe is left to return on its own.
+func probeBackends(ctx context.Context, urls []*url.URL, health config.PluginHealth, probe func(context.Context, *url.URL) string) []string {
+	healths := make([]string, len(urls))
+
+	workers := health.Concurrency
+	if workers <= 0 {
+		workers = config.DefaultPluginHealthConcurrency
+	}
+	if workers > len(urls) {
+		workers = len(urls)
+	}
+
+	timeout := health.Timeout
+	if timeout <= 0 {
+		timeout = config.DefaultPluginHealthTimeout
+	}
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				probeCtx, cancel := context.WithTimeout(ctx, timeout)
+
+				// buffered so that an abandoned probe doesn't leak
+				result := make(chan string, 1)
+				go func(u *url.URL) {
+					result <- probe(probeCtx, u)
+				}(urls[i])
+
+				// each worker only writes the health of its backends,
+				// results are read once all workers are done
+				select {
+				case healths[i] = <-result:
+				case <-probeCtx.Done():
+					healths[i] = BackendUnhealthy
+				}
+
+				cancel()
+			}
+		}()
+	}
+
+	for i := range urls {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+
+	return healths
+}
+
+// invalidURL replaces backend URLs which can't be parsed.
+const invalidURL = "<invalid>"
+
+// redactBackendURL is like redactURL for a raw backend URL.
+func redactBackendURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return invalidURL
+	}
+	return redactURL(u)
+}
+
+// redactURL removes user information and query values
+// from the URL except the plugin executable.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+
+	query := redacted.Query()
+	for key := range query {
+		if key != "executable" {
+			query.Set(key, "xxxxx")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+
+	return redacted.String()
+}
+
+func backendHealth(ctx context.Context, u *url.URL) string {
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return BackendUnhealthy
+	}
+	_ = conn.Close()
+
+	return BackendHealthy
+}
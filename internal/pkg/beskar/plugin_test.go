@@ -0,0 +1,424 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/netutil"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPluginProxyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	proxy := httputil.NewSingleHostReverseProxy(backendURL)
+	proxy.ErrorHandler = proxyErrorHandler
+
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		path       string
+		body       io.Reader
+		wantStatus int
+	}{
+		{
+			name:       "within timeout",
+			timeout:    time.Second,
+			path:       "/fast",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "timeout exceeded",
+			timeout:    50 * time.Millisecond,
+			path:       "/slow",
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			// the timeout only applies once the request body is sent
+			name:       "upload slower than timeout",
+			timeout:    50 * time.Millisecond,
+			path:       "/fast",
+			body:       &slowReader{chunks: 4, delay: 30 * time.Millisecond},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodGet
+			if tt.body != nil {
+				method = http.MethodPut
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(method, tt.path, tt.body)
+
+			withTimeout(proxy, tt.timeout).ServeHTTP(rec, req)
+			require.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+
+	// no timeout returns the handler as is
+	require.Equal(t, http.Handler(proxy), withTimeout(proxy, 0))
+}
+
+// slowReader returns chunks of data after a delay.
+type slowReader struct {
+	chunks int
+	delay  time.Duration
+}
+
+func (sr *slowReader) Read(p []byte) (int, error) {
+	if sr.chunks == 0 {
+		return 0, io.EOF
+	}
+	sr.chunks--
+	time.Sleep(sr.delay)
+	return copy(p, "beskar"), nil
+}
+
+func TestPluginResponseBuffering(t *testing.T) {
+	var attempts atomic.Int32
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first response is interrupted after a partial body
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Content-Length", "11")
+			_, _ = w.Write([]byte("hello"))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		mode         string
+		method       string
+		wantStatus   int
+		wantBody     string
+		wantAttempts int32
+	}{
+		{
+			name:         "buffered GET is retried",
+			mode:         config.PluginResponseBuffer,
+			method:       http.MethodGet,
+			wantStatus:   http.StatusOK,
+			wantBody:     "hello world",
+			wantAttempts: 2,
+		},
+		{
+			name:         "buffered POST isn't retried",
+			mode:         config.PluginResponseBuffer,
+			method:       http.MethodPost,
+			wantStatus:   http.StatusBadGateway,
+			wantAttempts: 1,
+		},
+		{
+			name:         "streamed GET isn't retried",
+			mode:         config.PluginResponseStream,
+			method:       http.MethodGet,
+			wantStatus:   http.StatusOK,
+			wantBody:     "hello",
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts.Store(0)
+
+			proxy := httputil.NewSingleHostReverseProxy(backendURL)
+			proxy.Transport = http.DefaultTransport.(*http.Transport).Clone()
+			proxy.ErrorHandler = proxyErrorHandler
+			proxy.ErrorLog = log.New(io.Discard, "", 0)
+			setResponseBuffering(proxy, tt.mode)
+
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+
+			req, err := http.NewRequest(tt.method, server.URL, nil)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			// the streamed response is truncated
+			body, _ := io.ReadAll(resp.Body)
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			require.Equal(t, tt.wantBody, string(body))
+			require.Equal(t, tt.wantAttempts, attempts.Load())
+		})
+	}
+}
+
+func TestBufferedTransportLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		_, _ = w.Write([]byte("hello "))
+		flusher.Flush()
+		_, _ = w.Write([]byte("world"))
+	}))
+	defer backend.Close()
+
+	for _, limit := range []int64{4, 1024} {
+		bt := &bufferedTransport{
+			next:  http.DefaultTransport,
+			limit: limit,
+		}
+
+		req, err := http.NewRequest(http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := bt.RoundTrip(req)
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "hello world", string(body))
+
+		// responses within the limit are sent with their length
+		if limit == 1024 {
+			require.Equal(t, int64(11), resp.ContentLength)
+		} else {
+			require.Equal(t, int64(-1), resp.ContentLength)
+		}
+	}
+}
+
+func TestPluginErrorResponses(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	unavailableURL, err := url.Parse("http://127.0.0.1:1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		url             *url.URL
+		responses       []config.PluginErrorResponse
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+		path            string
+	}{
+		{
+			name:       "default",
+			url:        unavailableURL,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "unavailable",
+			url:  unavailableURL,
+			responses: []config.PluginErrorResponse{
+				{
+					Errors: []string{config.PluginErrorTimeout},
+					Status: http.StatusServiceUnavailable,
+				},
+				{
+					Errors:      []string{config.PluginErrorUnavailable},
+					Status:      http.StatusNotFound,
+					ContentType: "text/plain",
+					Body:        "{{ .Method }} {{ .Path }} not found in {{ .Plugin }} ({{ .Status }})",
+				},
+			},
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "text/plain",
+			wantBody:        "GET /yum/repodata/repomd.xml not found in yum (502)",
+		},
+		{
+			name: "html escaped",
+			url:  unavailableURL,
+			responses: []config.PluginErrorResponse{
+				{
+					Status:      http.StatusNotFound,
+					ContentType: "text/html; charset=utf-8",
+					Body:        "<p>{{ .Path }}: {{ .Error }} ({{ .Kind }})</p>",
+				},
+			},
+			wantStatus:      http.StatusNotFound,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "<p>/yum/&lt;script&gt;: plugin backend unavailable (unavailable)</p>",
+			path:            "/yum/<script>",
+		},
+		{
+			name: "unset content type escaped",
+			url:  unavailableURL,
+			responses: []config.PluginErrorResponse{
+				{
+					Body: "<p>{{ .Path }}</p>",
+				},
+			},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "<p>/yum/&lt;script&gt;</p>",
+			path:       "/yum/<script>",
+		},
+		{
+			name: "timeout keeps default status",
+			url:  backendURL,
+			responses: []config.PluginErrorResponse{
+				{
+					Errors: []string{config.PluginErrorUnavailable},
+					Status: http.StatusNotFound,
+				},
+				{
+					Body: "timeout",
+				},
+			},
+			wantStatus: http.StatusGatewayTimeout,
+			wantBody:   "timeout",
+		},
+		{
+			name: "template error",
+			url:  unavailableURL,
+			responses: []config.PluginErrorResponse{
+				{
+					Status: http.StatusNotFound,
+					Body:   "{{ .Unknown }}",
+				},
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := httputil.NewSingleHostReverseProxy(tt.url)
+			proxy.ErrorLog = log.New(io.Discard, "", 0)
+
+			proxy.ErrorHandler, err = newProxyErrorHandler("yum", tt.responses)
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/yum/repodata/repomd.xml", nil)
+			if tt.path != "" {
+				req.URL.Path = tt.path
+			}
+
+			withTimeout(proxy, 50*time.Millisecond).ServeHTTP(rec, req)
+			require.Equal(t, tt.wantStatus, rec.Code)
+			require.Equal(t, tt.wantBody, rec.Body.String())
+			if tt.wantContentType != "" {
+				require.Equal(t, tt.wantContentType, rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+
+	_, err = newProxyErrorHandler("yum", []config.PluginErrorResponse{{Body: "{{"}})
+	require.Error(t, err)
+}
+
+func TestPluginSendRejected(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "malformed RPM package: bad lead magic 3c68746d", http.StatusBadRequest)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	pp := proxyPlugin{
+		prefix:     "yum",
+		url:        backendURL,
+		client:     backend.Client(),
+		tracer:     trace.NewNoopTracerProvider().Tracer("test"),
+		propagator: propagation.TraceContext{},
+	}
+
+	err = pp.send(context.Background(), "yum/rocky/packages", "application/vnd.ciq.rpm-package.v1.config+json", nil, "sha256:abc")
+
+	var ecErr errcode.Error
+	require.ErrorAs(t, err, &ecErr)
+	require.Equal(t, v2.ErrorCodeManifestInvalid, ecErr.Code)
+	require.Equal(t, "malformed RPM package: bad lead magic 3c68746d", ecErr.Detail)
+}
+
+type countingResolver struct {
+	lookups atomic.Int32
+}
+
+func (cr *countingResolver) LookupIPAddr(_ context.Context, _ string) ([]net.IPAddr, error) {
+	cr.lookups.Add(1)
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func TestFlushDNSOnError(t *testing.T) {
+	ctx := context.Background()
+
+	resolver := &countingResolver{}
+	dnsCache := netutil.NewDNSCache(time.Hour, resolver)
+
+	handler := flushDNSOnError(proxyErrorHandler, dnsCache, "backend")
+
+	_, err := dnsCache.Lookup(ctx, "backend")
+	require.NoError(t, err)
+	_, err = dnsCache.Lookup(ctx, "backend")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), resolver.lookups.Load())
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil), errors.New("connection refused"))
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+
+	// the backend host is resolved again after an error
+	_, err = dnsCache.Lookup(ctx, "backend")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), resolver.lookups.Load())
+
+	// the handler is returned as is without DNS cache
+	rec = httptest.NewRecorder()
+	flushDNSOnError(proxyErrorHandler, nil, "backend")(rec, httptest.NewRequest(http.MethodGet, "/", nil), context.DeadlineExceeded)
+	require.Equal(t, http.StatusGatewayTimeout, rec.Code)
+}
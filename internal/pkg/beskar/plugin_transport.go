@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/netutil"
+	"golang.org/x/net/http2"
+)
+
+// newPluginTransport returns the HTTP transport used to reach a plugin
+// backend according to the plugin client configuration, backend hosts
+// are resolved through the DNS cache if not nil.
+func newPluginTransport(scheme string, clientConfig config.PluginClient, dnsCache *netutil.DNSCache) (http.RoundTripper, error) {
+	dialer := &net.Dialer{
+		Timeout:   config.DefaultPluginDialTimeout,
+		KeepAlive: clientConfig.KeepaliveInterval,
+	}
+
+	dial := dialer.DialContext
+	if dnsCache != nil {
+		dial = dnsCache.DialContext(dial)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dial
+
+	switch clientConfig.HTTP2 {
+	case config.PluginHTTP2Auto:
+		// Go default behavior: HTTP/2 is negotiated with TLS backends only
+		return transport, nil
+	case config.PluginHTTP2Disabled:
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		return transport, nil
+	case config.PluginHTTP2Enabled:
+	default:
+		return nil, fmt.Errorf("unknown http2 mode %q", clientConfig.HTTP2)
+	}
+
+	if scheme == "https" {
+		h2Transport, err := http2.ConfigureTransports(transport)
+		if err != nil {
+			return nil, err
+		}
+		h2Transport.ReadIdleTimeout = clientConfig.KeepaliveInterval
+		h2Transport.PingTimeout = clientConfig.KeepaliveTimeout
+		return transport, nil
+	}
+
+	// HTTP/2 over cleartext (h2c) with prior knowledge
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+		ReadIdleTimeout: clientConfig.KeepaliveInterval,
+		PingTimeout:     clientConfig.KeepaliveTimeout,
+	}, nil
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/lru"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+	"go.ciq.dev/beskar/pkg/netutil"
+	"golang.org/x/time/rate"
+)
+
+var (
+	rateLimitNamespace = metrics.NewNamespace("beskar", "ratelimit")
+
+	rateLimitedRequestsCounter = rateLimitNamespace.NewLabeledCounter(
+		"rejected", "The number of plugin requests rejected by the rate limit", "plugin",
+	)
+)
+
+func init() {
+	metrics.Register(rateLimitNamespace)
+}
+
+// maxRateLimitedClients bounds the number of client limiters kept by
+// a rate limiter, the least recently seen clients are evicted first.
+const maxRateLimitedClients = 10000
+
+// clientRateLimiter limits the requests rate of each client IP, the
+// client IP is the one forwarded by the trusted proxies if any.
+type clientRateLimiter struct {
+	limit          rate.Limit
+	burst          int
+	trustedProxies netutil.TrustedProxies
+
+	mutex   sync.Mutex
+	clients *lru.Cache
+}
+
+func newClientRateLimiter(rl config.RateLimit, trustedProxies netutil.TrustedProxies) *clientRateLimiter {
+	return &clientRateLimiter{
+		limit:          rate.Limit(rl.Rate),
+		burst:          rl.Burst,
+		trustedProxies: trustedProxies,
+		clients:        lru.New(maxRateLimitedClients),
+	}
+}
+
+// allow reports whether the request is within the rate
+// limit of its client IP.
+func (cl *clientRateLimiter) allow(r *http.Request) bool {
+	ip := cl.trustedProxies.ClientIP(r)
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	limiter, ok := cl.clients.Get(ip)
+	if !ok {
+		limiter = rate.NewLimiter(cl.limit, cl.burst)
+		cl.clients.Add(ip, limiter, time.Time{})
+	}
+
+	return limiter.(*rate.Limiter).Allow()
+}
+
+// pluginRateLimiters returns the rate limiter of each plugin, plugins
+// without their own rate limit share the global limiter while plugins
+// without any rate limit are not part of the returned map. Requests are
+// limited per client IP.
+func pluginRateLimiters(beskarConfig *config.BeskarConfig, trustedProxies netutil.TrustedProxies) map[string]*clientRateLimiter {
+	limiters := make(map[string]*clientRateLimiter)
+
+	var global *clientRateLimiter
+	if beskarConfig.RateLimit.Enabled() {
+		global = newClientRateLimiter(beskarConfig.RateLimit, trustedProxies)
+	}
+
+	for name, plugin := range beskarConfig.Plugins {
+		switch rl := beskarConfig.PluginRateLimit(name); {
+		case plugin.RateLimit != nil && rl.Enabled():
+			limiters[name] = newClientRateLimiter(rl, trustedProxies)
+		case plugin.RateLimit == nil && global != nil:
+			limiters[name] = global
+		}
+	}
+
+	return limiters
+}
+
+// rateLimitHandler rejects the plugin requests exceeding the rate limit
+// of their client with a 429, requests are not limited when limiter is nil.
+func rateLimitHandler(plugin string, limiter *clientRateLimiter, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.allow(r) {
+			rateLimitedRequestsCounter.WithValues(plugin).Inc(1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/netutil"
+)
+
+func TestPluginRateLimit(t *testing.T) {
+	beskarConfig := &config.BeskarConfig{
+		RateLimit: config.RateLimit{Rate: 0.001, Burst: 3},
+		Plugins: map[string]config.Plugin{
+			"mirror":    {RateLimit: &config.RateLimit{Rate: 0.001, Burst: 1}},
+			"static":    {},
+			"yum":       {},
+			"unlimited": {RateLimit: &config.RateLimit{}},
+		},
+	}
+
+	limiters := pluginRateLimiters(beskarConfig, nil)
+	require.Len(t, limiters, 3)
+	require.Same(t, limiters["static"], limiters["yum"])
+	require.NotSame(t, limiters["mirror"], limiters["yum"])
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handlers := make(map[string]http.Handler)
+	for name := range beskarConfig.Plugins {
+		handlers[name] = rateLimitHandler(name, limiters[name], ok)
+	}
+
+	serve := func(plugin string) int {
+		rec := httptest.NewRecorder()
+		handlers[plugin].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+plugin, nil))
+		return rec.Code
+	}
+
+	// the stricter plugin limit is reached independently
+	require.Equal(t, http.StatusOK, serve("mirror"))
+	require.Equal(t, http.StatusTooManyRequests, serve("mirror"))
+	require.Equal(t, http.StatusOK, serve("yum"))
+
+	// plugins without their own limit share the global limit
+	require.Equal(t, http.StatusOK, serve("static"))
+	require.Equal(t, http.StatusOK, serve("yum"))
+	require.Equal(t, http.StatusTooManyRequests, serve("static"))
+	require.Equal(t, http.StatusTooManyRequests, serve("yum"))
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, http.StatusOK, serve("unlimited"))
+	}
+
+	rec := httptest.NewRecorder()
+	handlers["mirror"].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mirror", nil))
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+}
+
+func TestClientRateLimit(t *testing.T) {
+	trustedProxies, err := netutil.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	limiter := newClientRateLimiter(config.RateLimit{Rate: 0.001, Burst: 1}, trustedProxies)
+
+	request := func(remoteAddr, forwardedFor string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return r
+	}
+
+	// each client has its own limit
+	require.True(t, limiter.allow(request("192.0.2.1:1234", "")))
+	require.False(t, limiter.allow(request("192.0.2.1:1234", "")))
+	require.True(t, limiter.allow(request("192.0.2.2:1234", "")))
+
+	// clients behind a trusted proxy are limited by their forwarded IP
+	require.True(t, limiter.allow(request("10.0.0.1:1234", "198.51.100.1")))
+	require.True(t, limiter.allow(request("10.0.0.1:1234", "198.51.100.2")))
+	require.False(t, limiter.allow(request("10.0.0.2:1234", "198.51.100.1")))
+
+	// the least recently seen clients are evicted
+	for i := 0; i < maxRateLimitedClients; i++ {
+		limiter.allow(request(fmt.Sprintf("172.16.%d.%d:1234", i/256, i%256), ""))
+	}
+	require.Equal(t, maxRateLimitedClients, limiter.clients.Len())
+	require.True(t, limiter.allow(request("192.0.2.1:1234", "")))
+}
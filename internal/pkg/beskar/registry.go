@@ -6,19 +6,20 @@ package beskar
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/distribution/distribution/v3"
-	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/reference"
-	"github.com/distribution/distribution/v3/registry"
 	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/distribution/distribution/v3/version"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -26,12 +27,19 @@ import (
 	"github.com/hashicorp/memberlist"
 	"github.com/mailgun/groupcache/v2"
 	"github.com/opencontainers/go-digest"
+	"go.ciq.dev/beskar/internal/pkg/accesslog"
 	"go.ciq.dev/beskar/internal/pkg/cache"
 	"go.ciq.dev/beskar/internal/pkg/config"
 	"go.ciq.dev/beskar/internal/pkg/gossip"
 	"go.ciq.dev/beskar/pkg/mtls"
 	"go.ciq.dev/beskar/pkg/netutil"
 	"go.ciq.dev/beskar/pkg/sighandler"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/kubernetes"
 
 	// load distribution filesystem storage driver
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
@@ -44,63 +52,223 @@ import (
 )
 
 type Registry struct {
-	registry      distribution.Namespace
-	beskarConfig  *config.BeskarConfig
-	router        *mux.Router
-	server        *registry.Registry
-	member        *gossip.Member
-	manifestCache *cache.GroupCache
-	proxyPlugins  map[string]*proxyPlugin
-	errCh         chan error
-	logger        dcontext.Logger
-	wait          sighandler.WaitFunc
+	ctx              context.Context
+	registry         distribution.Namespace
+	beskarConfig     *config.BeskarConfig
+	router           *mux.Router
+	server           *registryServer
+	member           *gossip.Member
+	clusters         gossip.Members
+	kubeClient       kubernetes.Interface
+	responseCache    *responseCache
+	manifestCache    *cache.GroupCache
+	manifestGroup    *cache.Group
+	proxyPlugins     map[string]*proxyPlugin
+	pluginDNSCaches  map[string]*netutil.DNSCache
+	trustedProxies   netutil.TrustedProxies
+	inferrer         *mediatypeInferrer
+	accessController auth.AccessController
+	authorizer       Authorizer
+	maintenance      *maintenanceMode
+	errCh            chan error
+	logger           dcontext.Logger
+	wait             sighandler.WaitFunc
+
+	// cacheInitialized is set once the cluster is joined
+	// and the cache is initialized.
+	cacheInitialized atomic.Bool
+
+	tracerProvider trace.TracerProvider
+	tracer         trace.Tracer
+	propagator     propagation.TextMapPropagator
+	// shutdownTracing flushes the spans not exported yet,
+	// it's nil unless beskar exports the spans itself.
+	shutdownTracing func(context.Context) error
+
+	meterProvider metric.MeterProvider
+	// shutdownMetrics pushes the metrics not exported yet,
+	// it's nil unless beskar exports the metrics itself.
+	shutdownMetrics func(context.Context) error
 }
 
-func New(beskarConfig *config.BeskarConfig) (context.Context, *Registry, error) {
+func New(beskarConfig *config.BeskarConfig, options ...Option) (context.Context, *Registry, error) {
 	beskarRegistry := &Registry{
-		beskarConfig: beskarConfig,
-		proxyPlugins: make(map[string]*proxyPlugin),
-		errCh:        make(chan error, 1),
+		beskarConfig:    beskarConfig,
+		proxyPlugins:    make(map[string]*proxyPlugin),
+		pluginDNSCaches: make(map[string]*netutil.DNSCache),
+		inferrer:        newMediatypeInferrer(beskarConfig.Inference, beskarConfig.Plugins),
+		maintenance:     newMaintenanceMode(beskarConfig),
+		errCh:           make(chan error, 1),
+	}
+
+	for _, opt := range options {
+		opt(beskarRegistry)
+	}
+
+	if beskarRegistry.authorizer == nil {
+		beskarRegistry.authorizer = newAuthorizer(beskarConfig.Authorization)
 	}
 
 	ctx, waitFunc := sighandler.New(beskarRegistry.errCh, syscall.SIGINT)
 	beskarRegistry.wait = waitFunc
+	beskarRegistry.ctx = ctx
 
 	ctx = dcontext.WithVersion(ctx, version.Version)
 
-	registryCh, err := registerRegistryMiddleware(beskarRegistry, beskarRegistry.initCacheFunc)
+	err := runStartupPhases(ctx, []startupPhase{
+		{
+			name: "telemetry",
+			run:  beskarRegistry.initTelemetry,
+		},
+		{
+			name:      "registry",
+			dependsOn: []string{"telemetry"},
+			run:       beskarRegistry.initRegistry,
+		},
+		{
+			name:      "plugins",
+			dependsOn: []string{"registry"},
+			run: func(ctx context.Context) error {
+				return initPlugins(ctx, beskarRegistry)
+			},
+		},
+		{
+			name:      "routes",
+			dependsOn: []string{"registry"},
+			run:       beskarRegistry.initRoutes,
+		},
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if err := auth.Register("beskar", auth.InitFunc(newAccessController)); err != nil {
-		return nil, nil, err
+	return ctx, beskarRegistry, nil
+}
+
+func (br *Registry) initTelemetry(ctx context.Context) error {
+	if err := br.initTracing(ctx); err != nil {
+		return err
 	}
 
-	beskarRegistry.router = mux.NewRouter()
+	return br.initMetrics(ctx)
+}
+
+// initRegistry initializes the distribution registry along with the
+// beskar middlewares and HTTP handlers.
+func (br *Registry) initRegistry(ctx context.Context) error {
+	beskarConfig := br.beskarConfig
+
+	// CIDRs are validated while parsing the configuration
+	br.trustedProxies, _ = netutil.ParseTrustedProxies(beskarConfig.TrustedProxies)
 
-	registry.RegisterHandler(func(config *configuration.Configuration, handler http.Handler) http.Handler {
-		beskarRegistry.router.NotFoundHandler = handler
-		return beskarRegistry.router
+	registryCh, err := registerRegistryMiddleware(br, br.initCacheFunc, beskarConfig.Cache.Reconciliation, br.tracer)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.Register("beskar", newAccessControllerFunc(br)); err != nil {
+		return err
+	}
+
+	if beskarConfig.Tracing.Enabled {
+		if err := registerStorageTracing(beskarConfig.Registry, br.tracer); err != nil {
+			return err
+		}
+	}
+
+	br.router = mux.NewRouter()
+
+	var accessLogger *accesslog.Logger
+	if beskarConfig.AccessLog.Enabled {
+		accessLogger, err = accesslog.New(beskarConfig.AccessLog)
+		if err != nil {
+			return err
+		}
+	}
+
+	// plugin responses are cached once authorized, registry responses
+	// are keyed by the authorization checked by the registry handler
+	br.responseCache = newResponseCache(beskarConfig.Cache.Response, beskarConfig.RequestIDHeader, func(r *http.Request) string {
+		return requestIdentity(beskarConfig.Authorization, br.trustedProxies, r)
 	})
 
-	beskarRegistry.server, err = registry.NewRegistry(ctx, beskarConfig.Registry)
+	br.server, err = newRegistryServer(ctx, beskarConfig.Registry, func(handler http.Handler) http.Handler {
+		br.router.NotFoundHandler = br.responseCache.handler(handler)
+		return requestIDHandler(
+			beskarConfig.RequestIDHeader,
+			br.accessLogHandler(
+				accessLogger,
+				tracingHandler(
+					br.tracer,
+					br.propagator,
+					startupHandler(
+						&br.cacheInitialized,
+						maintenanceHandler(
+							br.maintenance,
+							blobRedirectHandler(br.blobOwnerPublicURL, br.router),
+						),
+					),
+				),
+			),
+		)
+	})
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	br.registry = <-registryCh
+
+	beskarConfig.Server.Timeouts.Apply(br.server.server)
+	if limiter := newConnLimiter(beskarConfig.ConnLimit, "registry"); limiter != nil {
+		limiter.TrustedProxies = br.trustedProxies
+		limiter.ApplyServer(br.server.server)
 	}
-	beskarRegistry.registry = <-registryCh
 
-	beskarRegistry.logger = dcontext.GetLogger(ctx)
+	br.logger = dcontext.GetLogger(ctx)
 
-	if err := initPlugins(ctx, beskarRegistry); err != nil {
-		return nil, nil, err
+	return nil
+}
+
+// blobOwnerPublicURL returns the public URL of the cache peer owning
+// the blob key, the cache is only available once the node joined the
+// cluster.
+func (br *Registry) blobOwnerPublicURL(key string) (string, bool) {
+	if br.manifestCache == nil {
+		return "", false
+	}
+	return br.manifestCache.OwnerPublicURL(key)
+}
+
+// initRoutes initializes the access controller and the beskar API routes.
+func (br *Registry) initRoutes(context.Context) error {
+	beskarConfig := br.beskarConfig
+
+	if beskarConfig.Registry.Auth.Type() != "" {
+		var err error
+
+		br.accessController, err = auth.GetAccessController(
+			beskarConfig.Registry.Auth.Type(),
+			beskarConfig.Registry.Auth.Parameters(),
+		)
+		if err != nil {
+			return fmt.Errorf("while initializing access controller: %w", err)
+		}
 	}
+	br.router.Handle(routesPath, routesHandler(br)).Methods(http.MethodGet)
+	br.router.Handle(pluginsPath, pluginsHandler(br)).Methods(http.MethodGet)
+	br.router.Handle(maintenancePath, maintenanceSettingsHandler(br)).Methods(http.MethodGet, http.MethodPut)
+	br.router.Handle(gossipMembersPath, gossipMembersHandler(br)).Methods(http.MethodGet)
+	br.router.Handle(gossipMembersPath, gossipForgetHandler(br)).Methods(http.MethodDelete)
+	br.router.Handle(gossipRediscoverPath, gossipRediscoverHandler(br)).Methods(http.MethodPost)
+	br.router.Handle(gossipCAPath, gossipCAHandler(br)).Methods(http.MethodGet)
+	br.router.Handle(cacheEvictPath, cacheEvictHandler(br)).Methods(http.MethodPost)
+	br.router.Handle(livenessPath, healthHandler(br.Liveness)).Methods(http.MethodGet)
+	br.router.Handle(readinessPath, healthHandler(br.Readiness)).Methods(http.MethodGet)
 
 	if beskarConfig.Profiling {
-		beskarRegistry.setProfiling()
+		br.setProfiling()
 	}
 
-	return ctx, beskarRegistry, nil
+	return nil
 }
 
 func (br *Registry) setProfiling() {
@@ -115,12 +283,18 @@ func (br *Registry) setProfiling() {
 }
 
 func (br *Registry) listBeskarTags(ctx context.Context) ([]string, error) {
+	return listBeskarTags(ctx, br.registry)
+}
+
+// listBeskarTags returns the tags of the beskar repository, no tag is
+// returned when the repository doesn't exist yet.
+func listBeskarTags(ctx context.Context, registry distribution.Namespace) ([]string, error) {
 	rn, err := reference.WithName("beskar")
 	if err != nil {
 		return nil, err
 	}
 
-	repo, err := br.registry.Repository(ctx, rn)
+	repo, err := registry.Repository(ctx, rn)
 	if err != nil {
 		return nil, err
 	}
@@ -138,6 +312,7 @@ func (br *Registry) listBeskarTags(ctx context.Context) ([]string, error) {
 
 func (br *Registry) startGossipWatcher() {
 	self := br.member.LocalNode()
+	fingerprint := config.ConfigFingerprint(br.beskarConfig)
 
 	for event := range br.member.Watch() {
 		switch event.EventType {
@@ -150,13 +325,27 @@ func (br *Registry) startGossipWatcher() {
 			br.logger.Debugf("Added node %s to cluster", node.Addr)
 
 			if self.Port != node.Port || !self.Addr.Equal(node.Addr) {
-				meta := gossip.NewBeskarMeta()
-				if err := meta.Decode(node.Meta); err == nil {
+				meta := gossip.NewBeskarMeta(br.beskarConfig.Gossip.Cluster)
+				if err := meta.Decode(node.Meta); errors.Is(err, gossip.ErrIncompatibleMeta) {
+					br.logger.Warnf("Node %s has unknown meta, not used as groupcache peer: %s", node.Addr, err)
+				} else if err == nil {
+					if meta.ConfigFingerprint != "" && meta.ConfigFingerprint != fingerprint {
+						br.logger.Warnf(
+							"Node %s configuration fingerprint %s doesn't match local fingerprint %s",
+							node.Addr, meta.ConfigFingerprint, fingerprint,
+						)
+					}
 					peer := net.JoinHostPort(node.Addr.String(), strconv.Itoa(int(meta.CachePort)))
-					br.manifestCache.AddPeer(fmt.Sprintf("https://%s", peer), node.Name)
-					br.logger.Debugf("Added groupcache peer %s", peer)
+					br.manifestCache.AddPeer(fmt.Sprintf("https://%s", peer), node.Name, meta.Zone, meta.PublicURL)
+					br.logger.Debugf("Added groupcache peer %s (zone %q, public URL %q)", peer, meta.Zone, meta.PublicURL)
 				}
 			}
+		case gossip.NodeCacheEviction:
+			eviction, ok := event.Arg.(gossip.CacheEviction)
+			if !ok {
+				continue
+			}
+			go br.evictReceivedCacheKeys(eviction)
 		case gossip.NodeLeave:
 			node, ok := event.Arg.(*memberlist.Node)
 			if !ok || self.Name == node.Name {
@@ -166,7 +355,7 @@ func (br *Registry) startGossipWatcher() {
 			br.logger.Debugf("Removed node %s from cluster", node.Addr)
 
 			if self.Port != node.Port || !self.Addr.Equal(node.Addr) {
-				meta := gossip.NewBeskarMeta()
+				meta := gossip.NewBeskarMeta(br.beskarConfig.Gossip.Cluster)
 				if err := meta.Decode(node.Meta); err == nil {
 					peer := net.JoinHostPort(node.Addr.String(), strconv.Itoa(int(meta.CachePort)))
 					br.manifestCache.RemovePeer(fmt.Sprintf("https://%s", peer), node.Name)
@@ -177,15 +366,20 @@ func (br *Registry) startGossipWatcher() {
 	}
 }
 
-func (br *Registry) initCacheFunc() (_ *groupcache.Group, errFn error) {
+// joinCluster starts the gossip member and returns the CA shared by
+// the cluster members.
+func (br *Registry) joinCluster(ctx context.Context) (_ *mtls.CAPEM, errFn error) {
 	var err error
 
-	br.logger.Info("Initializing gossip and groupcache")
-
-	br.member, err = gossip.Start(br.beskarConfig, nil, 300*time.Second)
+	ctx, span := br.tracer.Start(ctx, "gossip.Start")
+	br.member, err = gossip.StartContext(ctx, br.beskarConfig, br.kubeClient)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("gossip.members", len(br.member.Nodes())))
+	span.End()
 	defer func() {
 		if errFn != nil {
 			_ = br.member.Shutdown()
@@ -198,8 +392,18 @@ func (br *Registry) initCacheFunc() (_ *groupcache.Group, errFn error) {
 			return nil, err
 		}
 		remoteState, err = br.member.LocalState()
-		if err != nil {
+		if err != nil && br.beskarConfig.Gossip.SoloCA != config.SoloCAWait {
 			return nil, err
+		} else if err != nil {
+			br.logger.Infof("Waiting up to %s for a CA from peers", br.beskarConfig.Gossip.SoloCATimeout)
+
+			waitCtx, waitCancel := context.WithTimeout(ctx, br.beskarConfig.Gossip.SoloCATimeout)
+			remoteState, err = br.member.WaitRemoteState(waitCtx)
+			waitCancel()
+
+			if err != nil {
+				return nil, fmt.Errorf("while waiting for a CA from peers: %w", err)
+			}
 		}
 	}
 
@@ -208,10 +412,74 @@ func (br *Registry) initCacheFunc() (_ *groupcache.Group, errFn error) {
 		return nil, fmt.Errorf("while unmarshalling CA certificates: %w", err)
 	}
 
+	// a CA outside of its validity period most likely means
+	// that the local clock or the clock of its issuer is skewed
+	err = mtls.CheckValidity(caPem.Cert, time.Now(), br.beskarConfig.Gossip.ClockSkewAllowance)
+	if err != nil {
+		return nil, fmt.Errorf("while validating CA certificate, check the nodes clock synchronization: %w", err)
+	}
+
+	return caPem, nil
+}
+
+func (br *Registry) initCacheFunc() (_ *cache.Group, errFn error) {
+	var caPem *mtls.CAPEM
+
+	ctx, cancel := context.WithTimeout(br.ctx, 300*time.Second)
+	defer cancel()
+
+	if err := br.initKubernetesClient(); err != nil {
+		return nil, err
+	}
+
+	if br.beskarConfig.Gossip.IsEnabled() {
+		br.logger.Info("Initializing gossip and groupcache")
+
+		var err error
+
+		caPem, err = br.joinCluster(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// sets the CA expiry gauge
+		if _, err := br.member.CAStatus(); err != nil {
+			br.logger.Warnf("Gossip CA status unavailable: %s", err)
+		}
+
+		go br.mirrorCASecret(caPem.Cert)
+		defer func() {
+			if errFn != nil {
+				_ = br.member.Shutdown()
+			}
+		}()
+	} else {
+		br.logger.Info("Gossip disabled, initializing groupcache in single-node mode")
+
+		caCert, caKey, err := mtls.GenerateCA("beskar", time.Now().AddDate(10, 0, 0), mtls.ECDSAKey)
+		if err != nil {
+			return nil, fmt.Errorf("while generating CA certificates: %w", err)
+		}
+		caPem = &mtls.CAPEM{
+			Cert: caCert,
+			Key:  caKey,
+		}
+	}
+
+	if err := br.joinGossipClusters(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errFn != nil {
+			_ = br.clusters.Shutdown()
+		}
+	}()
+
 	cacheClientConfig, err := mtls.GenerateClientConfig(
 		bytes.NewReader(caPem.Cert),
 		bytes.NewReader(caPem.Key),
 		time.Now().AddDate(10, 0, 0),
+		mtls.WithClockSkew(br.beskarConfig.Gossip.ClockSkewAllowance),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("while generating cache client mTLS certificates: %w", err)
@@ -227,6 +495,7 @@ func (br *Registry) initCacheFunc() (_ *groupcache.Group, errFn error) {
 		bytes.NewReader(caPem.Key),
 		time.Now().AddDate(10, 0, 0),
 		mtls.WithCertRequestIPs(localIPs...),
+		mtls.WithClockSkew(br.beskarConfig.Gossip.ClockSkewAllowance),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("while generating cache server mTLS certificates: %w", err)
@@ -237,32 +506,85 @@ func (br *Registry) initCacheFunc() (_ *groupcache.Group, errFn error) {
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.TLSClientConfig = cacheClientConfig
 
-	br.manifestCache = cache.NewCache(cacheAddr, &groupcache.HTTPPoolOptions{
+	br.manifestCache = cache.NewCache(cacheAddr, br.beskarConfig.Cache.Zone, &groupcache.HTTPPoolOptions{
 		Transport: func(context.Context) http.RoundTripper {
 			return transport
 		},
 	})
 
-	go br.startGossipWatcher()
+	if br.member != nil {
+		go br.startGossipWatcher()
+	}
+
+	br.member.StartSuspectReaper(br.ctx, br.beskarConfig.Gossip.MaxSuspectAge, func(node *memberlist.Node) {
+		br.logger.Warnf("Removed node %s suspect for more than %s", node.Addr, br.beskarConfig.Gossip.MaxSuspectAge)
+	})
 
 	go func() {
-		err = br.manifestCache.Start(cacheServerConfig)
-		if err != nil {
+		if err := br.manifestCache.Start(cacheServerConfig, newConnLimiter(br.beskarConfig.ConnLimit, "cache")); err != nil {
 			br.errCh <- err
 		}
 	}()
 
-	return br.manifestCache.NewGroup("manifests", cache.DefaultCacheSize, cacheGetter{})
+	group, err := br.manifestCache.NewGroup("manifests", int64(br.beskarConfig.Cache.Size), cacheGetter{})
+	if err != nil {
+		return nil, err
+	}
+	// peers of other zones may serve a stale manifest until its expiry
+	group.OnRemoteError(func(key string, err error) {
+		cacheRemoteInvalidationFailuresCounter.Inc(1)
+		br.logger.Warnf("Cache key %s not removed from the cache peers of other zones: %s", key, err)
+	})
+
+	br.manifestGroup = group
+	br.cacheInitialized.Store(true)
+
+	return group, nil
+}
+
+// checkStorage checks that the registry storage is reachable, the
+// storage is accessed without the beskar middleware which would
+// initialize the cache.
+func (br *Registry) checkStorage(ctx context.Context) error {
+	registry := br.registry
+	if mr, ok := registry.(*RegistryMiddleware); ok {
+		registry = mr.registry
+	}
+	_, err := listBeskarTags(ctx, registry)
+	return err
+}
+
+// initCache joins the gossip cluster and initializes the cache which
+// are otherwise initialized on first repository access.
+func (br *Registry) initCache(context.Context) error {
+	mr, ok := br.registry.(*RegistryMiddleware)
+	if !ok {
+		return fmt.Errorf("beskar registry middleware not found")
+	}
+	return mr.initCache()
 }
 
 func (br *Registry) Serve(ctx context.Context) error {
 	br.logger.Info("Starting beskar server")
 
+	// the listener is opened first so the liveness is served while
+	// joining the cluster, the other requests are rejected until the
+	// cluster is joined and the readiness is reported once joined
 	go func() {
 		br.errCh <- br.server.ListenAndServe()
 	}()
 
-	_, err := br.listBeskarTags(ctx)
+	err := runStartupPhases(ctx, []startupPhase{
+		{
+			name: "storage",
+			run:  br.checkStorage,
+		},
+		{
+			name:      "cluster",
+			dependsOn: []string{"storage"},
+			run:       br.initCache,
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -275,10 +597,30 @@ func (br *Registry) Serve(ctx context.Context) error {
 	if err == nil {
 		err = gossipErr
 	}
+	clustersErr := br.clusters.Shutdown()
+	if err == nil {
+		err = clustersErr
+	}
 	manifestCacheErr := br.manifestCache.Stop(ctx)
 	if err == nil {
 		err = manifestCacheErr
 	}
+	if br.shutdownTracing != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		tracingErr := br.shutdownTracing(shutdownCtx)
+		cancel()
+		if err == nil {
+			err = tracingErr
+		}
+	}
+	if br.shutdownMetrics != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		metricsErr := br.shutdownMetrics(shutdownCtx)
+		cancel()
+		if err == nil {
+			err = metricsErr
+		}
+	}
 
 	return err
 }
@@ -295,10 +637,21 @@ func (br *Registry) Put(ctx context.Context, repository distribution.Repository,
 		mediaType = string(ociManifest.Config.MediaType)
 		proxyPlugin, ok := br.proxyPlugins[mediaType]
 		if !ok {
+			if inferred, found := br.inferrer.infer(mediaType, ociManifest.Layers); found {
+				inferredManifestsCounter.WithValues(inferred).Inc(1)
+				br.logger.Debugf("Inferred media type %s for manifest %s with media type %q", inferred, repository.Named().String(), mediaType)
+				mediaType = inferred
+				proxyPlugin, ok = br.proxyPlugins[mediaType]
+			}
+		}
+		if !ok {
+			unroutedManifestsCounter.Inc(1)
+			br.logger.Debugf("No plugin found for manifest %s with media type %s", repository.Named().String(), mediaType)
 			return nil
 		}
 
-		br.logger.Debugf("Sending manifest %s event to plugin", repository.Named().String())
+		routedManifestsCounter.WithValues(mediaType, proxyPlugin.prefix).Inc(1)
+		br.logger.Debugf("Routing manifest %s with media type %s to plugin %s", repository.Named().String(), mediaType, proxyPlugin.prefix)
 
 		return proxyPlugin.send(
 			ctx,
@@ -313,6 +666,18 @@ func (br *Registry) Put(ctx context.Context, repository distribution.Repository,
 	return nil
 }
 
+// authorizeRepository consults the plugin owning the repository about the
+// registry API access, a repository is owned by the plugin whose prefix is
+// the first component of the repository name.
+func (br *Registry) authorizeRepository(ctx context.Context, r *http.Request, repository, action string) (repositoryAccess, string, error) {
+	for _, proxyPlugin := range br.proxyPlugins {
+		if strings.HasPrefix(repository, strings.TrimPrefix(proxyPlugin.prefix, "/")+"/") {
+			return proxyPlugin.authorizeRepository(ctx, r, repository, action)
+		}
+	}
+	return repositoryAccessDefault, "", nil
+}
+
 func (br *Registry) Delete(context.Context, digest.Digest) error {
 	return nil
 }
@@ -12,31 +12,37 @@ import (
 	"github.com/distribution/distribution/v3/reference"
 	middleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
-	"github.com/mailgun/groupcache/v2"
+	"go.ciq.dev/beskar/internal/pkg/cache"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type initCacheFunc func() (*groupcache.Group, error)
+type initCacheFunc func() (*cache.Group, error)
 
 type RegistryMiddleware struct {
 	registry             distribution.Namespace
 	manifestEventHandler ManifestEventHandler
 	initCacheOnce        sync.Once
 	initCacheFunc        initCacheFunc
-	cache                *groupcache.Group
+	initCacheErr         error
+	cache                *cache.Group
+	reconciliation       string
+	tracer               trace.Tracer
 }
 
-func registerRegistryMiddleware(meh ManifestEventHandler, initCacheFunc initCacheFunc) (<-chan distribution.Namespace, error) {
+func registerRegistryMiddleware(meh ManifestEventHandler, initCacheFunc initCacheFunc, reconciliation string, tracer trace.Tracer) (<-chan distribution.Namespace, error) {
 	registryCh := make(chan distribution.Namespace, 1)
-	err := middleware.Register("beskar", initRegistryMiddleware(meh, initCacheFunc, registryCh))
+	err := middleware.Register("beskar", initRegistryMiddleware(meh, initCacheFunc, reconciliation, tracer, registryCh))
 	return registryCh, err
 }
 
-func initRegistryMiddleware(meh ManifestEventHandler, initCacheFunc initCacheFunc, registryCh chan distribution.Namespace) middleware.InitFunc {
+func initRegistryMiddleware(meh ManifestEventHandler, initCacheFunc initCacheFunc, reconciliation string, tracer trace.Tracer, registryCh chan distribution.Namespace) middleware.InitFunc {
 	return func(ctx context.Context, registry distribution.Namespace, driver storagedriver.StorageDriver, options map[string]interface{}) (distribution.Namespace, error) {
 		mr := &RegistryMiddleware{
 			registry:             registry,
 			manifestEventHandler: meh,
 			initCacheFunc:        initCacheFunc,
+			reconciliation:       reconciliation,
+			tracer:               tracer,
 		}
 		registryCh <- mr
 		close(registryCh)
@@ -60,22 +66,31 @@ func (m *RegistryMiddleware) Repository(ctx context.Context, name reference.Name
 		return nil, err
 	}
 
-	m.initCacheOnce.Do(func() {
-		if m.initCacheFunc != nil {
-			m.cache, err = m.initCacheFunc()
-			if err != nil {
-				err = fmt.Errorf("while initializing cache: %w", err)
-			}
-		}
-	})
+	err = m.initCache()
 
 	return &RepositoryMiddleware{
 		repository:           repository,
 		manifestEventHandler: m.manifestEventHandler,
 		cache:                m.cache,
+		reconciliation:       m.reconciliation,
+		tracer:               m.tracer,
 	}, err
 }
 
+// initCache initializes the cache once, the initialization error
+// is returned by subsequent calls.
+func (m *RegistryMiddleware) initCache() error {
+	m.initCacheOnce.Do(func() {
+		if m.initCacheFunc != nil {
+			m.cache, m.initCacheErr = m.initCacheFunc()
+			if m.initCacheErr != nil {
+				m.initCacheErr = fmt.Errorf("while initializing cache: %w", m.initCacheErr)
+			}
+		}
+	})
+	return m.initCacheErr
+}
+
 // Repositories fills 'repos' with a lexicographically sorted catalog of repositories
 // up to the size of 'repos' and returns the value 'n' for the number of entries
 // which were filled.  'last' contains an offset in the catalog, and 'err' will be
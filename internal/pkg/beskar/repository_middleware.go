@@ -5,18 +5,28 @@ package beskar
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/reference"
-	"github.com/mailgun/groupcache/v2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/opencontainers/go-digest"
+	"go.ciq.dev/beskar/internal/pkg/cache"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RepositoryMiddleware struct {
 	repository           distribution.Repository
 	manifestEventHandler ManifestEventHandler
-	cache                *groupcache.Group
+	cache                *cache.Group
+	reconciliation       string
+	tracer               trace.Tracer
 }
 
 // Named returns the name of the repository.
@@ -41,6 +51,8 @@ func (m *RepositoryMiddleware) Manifests(ctx context.Context, options ...distrib
 		manifestEventHandler: m.manifestEventHandler,
 		repository:           m,
 		cache:                m.cache,
+		reconciliation:       m.reconciliation,
+		tracer:               m.tracer,
 	}, nil
 }
 
@@ -58,7 +70,26 @@ type manifestServiceWrapper struct {
 	distribution.ManifestService
 	manifestEventHandler ManifestEventHandler
 	repository           distribution.Repository
-	cache                *groupcache.Group
+	cache                *cache.Group
+	reconciliation       string
+	tracer               trace.Tracer
+}
+
+func (w *manifestServiceWrapper) startSpan(ctx context.Context, name string, dgst digest.Digest) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		attribute.String("beskar.repository", w.repository.Named().Name()),
+	}
+	if dgst != "" {
+		attributes = append(attributes, attribute.String("beskar.digest", dgst.String()))
+	}
+	return w.tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
 
 // Exists returns true if the manifest exists.
@@ -67,22 +98,53 @@ func (w *manifestServiceWrapper) Exists(ctx context.Context, dgst digest.Digest)
 }
 
 // Get retrieves the manifest specified by the given digest
-func (w *manifestServiceWrapper) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+func (w *manifestServiceWrapper) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (_ distribution.Manifest, errFn error) {
+	ctx, span := w.startSpan(ctx, "manifests.Get", dgst)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+
 	destSink := newManifestSink(w.ManifestService, options...)
 
-	if err := w.cache.Get(ctx, getCacheKey(w.repository, dgst), destSink); err != nil {
+	cacheKey := getCacheKey(w.repository, dgst)
+
+	if err := w.cache.Get(ctx, cacheKey, destSink); err != nil {
 		return nil, err
 	}
 
+	// manifests served by a cache may have been deleted from the storage
+	// by another node or by the garbage collector
+	if !destSink.loaded && w.reconciliation == config.CacheReconciliationStorageAuthoritative {
+		exists, err := w.ManifestService.Exists(ctx, dgst)
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			cacheDisagreementsCounter.Inc()
+			if err := w.cache.Remove(ctx, cacheKey); err != nil {
+				return nil, err
+			}
+			return nil, distribution.ErrManifestUnknownRevision{
+				Name:     w.repository.Named().Name(),
+				Revision: dgst,
+			}
+		}
+	}
+
 	return destSink.ToManifest()
 }
 
 // Put creates or updates the given manifest returning the manifest digest
-func (w *manifestServiceWrapper) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+func (w *manifestServiceWrapper) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (_ digest.Digest, errFn error) {
+	ctx, span := w.startSpan(ctx, "manifests.Put", "")
+	defer func() {
+		endSpan(span, errFn)
+	}()
+
 	dgst, err := w.ManifestService.Put(ctx, manifest, options...)
 	if err != nil {
 		return "", err
 	}
+	span.SetAttributes(attribute.String("beskar.digest", dgst.String()))
 
 	mediaType, payload, err := manifest.Payload()
 	if err != nil {
@@ -99,13 +161,51 @@ func (w *manifestServiceWrapper) Put(ctx context.Context, manifest distribution.
 		return "", err
 	}
 
-	return dgst, w.manifestEventHandler.Put(ctx, w.repository, dgst, mediaType, payload)
+	err = w.manifestEventHandler.Put(ctx, w.repository, dgst, mediaType, payload)
+	if isManifestRejected(err) {
+		// the manifest is already stored, it must not remain
+		// pullable once rejected by the plugin
+		w.discardManifest(ctx, dgst)
+	}
+
+	return dgst, err
+}
+
+// isManifestRejected returns true if the plugin has rejected the
+// manifest content.
+func isManifestRejected(err error) bool {
+	var ecErr errcode.Error
+	return errors.As(err, &ecErr) && ecErr.Code == v2.ErrorCodeManifestInvalid
+}
+
+// discardManifest deletes a stored manifest and evicts it from the cache,
+// failures are only logged as the client gets the rejection error.
+func (w *manifestServiceWrapper) discardManifest(ctx context.Context, dgst digest.Digest) {
+	logger := dcontext.GetLogger(ctx)
+
+	if err := w.ManifestService.Delete(ctx, dgst); err != nil && !errors.Is(err, distribution.ErrBlobUnknown) {
+		logger.Warnf("Rejected manifest %s@%s not deleted: %s", w.repository.Named().Name(), dgst, err)
+	}
+	if err := w.cache.Remove(ctx, getCacheKey(w.repository, dgst)); err != nil {
+		logger.Warnf("Rejected manifest %s@%s not evicted from cache: %s", w.repository.Named().Name(), dgst, err)
+	}
 }
 
 // Delete removes the manifest specified by the given digest. Deleting
 // a manifest that doesn't exist will return ErrManifestNotFound
-func (w *manifestServiceWrapper) Delete(ctx context.Context, dgst digest.Digest) error {
+func (w *manifestServiceWrapper) Delete(ctx context.Context, dgst digest.Digest) (errFn error) {
+	ctx, span := w.startSpan(ctx, "manifests.Delete", dgst)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+
 	if err := w.ManifestService.Delete(ctx, dgst); err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) && w.reconciliation == config.CacheReconciliationStorageAuthoritative {
+			// the manifest may still be cached, don't serve it anymore
+			if removeErr := w.cache.Remove(ctx, getCacheKey(w.repository, dgst)); removeErr != nil {
+				return removeErr
+			}
+		}
 		return err
 	}
 
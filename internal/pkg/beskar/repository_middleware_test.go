@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/cache"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fakeRepository struct {
+	distribution.Repository
+	name reference.Named
+}
+
+func (r *fakeRepository) Named() reference.Named {
+	return r.name
+}
+
+type fakeManifestService struct {
+	distribution.ManifestService
+	manifests map[digest.Digest]distribution.Manifest
+}
+
+func (s *fakeManifestService) Exists(_ context.Context, dgst digest.Digest) (bool, error) {
+	_, ok := s.manifests[dgst]
+	return ok, nil
+}
+
+func (s *fakeManifestService) Get(_ context.Context, dgst digest.Digest, _ ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	m, ok := s.manifests[dgst]
+	if !ok {
+		return nil, distribution.ErrManifestUnknownRevision{Revision: dgst}
+	}
+	return m, nil
+}
+
+func (s *fakeManifestService) Put(_ context.Context, m distribution.Manifest, _ ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	_, payload, err := m.Payload()
+	if err != nil {
+		return "", err
+	}
+	dgst := digest.FromBytes(payload)
+	s.manifests[dgst] = m
+	return dgst, nil
+}
+
+func (s *fakeManifestService) Delete(_ context.Context, dgst digest.Digest) error {
+	if _, ok := s.manifests[dgst]; !ok {
+		return distribution.ErrBlobUnknown
+	}
+	delete(s.manifests, dgst)
+	return nil
+}
+
+// rejectingEventHandler rejects all the manifests like a plugin
+// rejecting malformed packages.
+type rejectingEventHandler struct{}
+
+func (rejectingEventHandler) Put(context.Context, distribution.Repository, digest.Digest, string, []byte) error {
+	return v2.ErrorCodeManifestInvalid.WithDetail("malformed package")
+}
+
+func (rejectingEventHandler) Delete(context.Context, digest.Digest) error {
+	return nil
+}
+
+func TestManifestRejected(t *testing.T) {
+	ctx := context.Background()
+
+	name, err := reference.WithName("test/rejected")
+	require.NoError(t, err)
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: distribution.Descriptor{
+			MediaType: v1.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		},
+	})
+	require.NoError(t, err)
+
+	storage := &fakeManifestService{
+		manifests: make(map[digest.Digest]distribution.Manifest),
+	}
+
+	w := &manifestServiceWrapper{
+		ManifestService:      storage,
+		manifestEventHandler: rejectingEventHandler{},
+		repository:           &fakeRepository{name: name},
+		cache:                cache.NewGroup("rejected", 1<<20, cacheGetter{}),
+		reconciliation:       config.CacheReconciliationCacheTolerant,
+		tracer:               trace.NewNoopTracerProvider().Tracer(""),
+	}
+
+	dgst, err := w.Put(ctx, m)
+	var ecErr errcode.Error
+	require.ErrorAs(t, err, &ecErr)
+	require.Equal(t, v2.ErrorCodeManifestInvalid, ecErr.Code)
+
+	// the rejected manifest is neither stored nor cached
+	require.Empty(t, storage.manifests)
+	_, err = w.Get(ctx, dgst)
+	require.ErrorAs(t, err, &distribution.ErrManifestUnknownRevision{})
+}
+
+func TestManifestCacheReconciliation(t *testing.T) {
+	ctx := context.Background()
+
+	name, err := reference.WithName("test/reconciliation")
+	require.NoError(t, err)
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: distribution.Descriptor{
+			MediaType: v1.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		},
+	})
+	require.NoError(t, err)
+
+	_, payload, err := m.Payload()
+	require.NoError(t, err)
+	dgst := digest.FromBytes(payload)
+
+	tests := []struct {
+		name           string
+		reconciliation string
+		wantServed     bool
+	}{
+		{
+			name:           "cache tolerant",
+			reconciliation: config.CacheReconciliationCacheTolerant,
+			wantServed:     true,
+		},
+		{
+			name:           "storage authoritative",
+			reconciliation: config.CacheReconciliationStorageAuthoritative,
+			wantServed:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &fakeManifestService{
+				manifests: map[digest.Digest]distribution.Manifest{dgst: m},
+			}
+
+			w := &manifestServiceWrapper{
+				ManifestService: storage,
+				repository:      &fakeRepository{name: name},
+				cache:           cache.NewGroup("reconciliation-"+tt.reconciliation, 1<<20, cacheGetter{}),
+				reconciliation:  tt.reconciliation,
+				tracer:          trace.NewNoopTracerProvider().Tracer(""),
+			}
+
+			// cached by the first get
+			_, err := w.Get(ctx, dgst)
+			require.NoError(t, err)
+
+			// deleted from the storage behind the cache
+			delete(storage.manifests, dgst)
+
+			_, err = w.Get(ctx, dgst)
+			if tt.wantServed {
+				require.NoError(t, err)
+				require.ErrorIs(t, w.Delete(ctx, dgst), distribution.ErrBlobUnknown)
+				_, err = w.Get(ctx, dgst)
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorAs(t, err, &distribution.ErrManifestUnknownRevision{})
+
+			// evicted, the storage is read again
+			storage.manifests[dgst] = m
+			_, err = w.Get(ctx, dgst)
+			require.NoError(t, err)
+
+			// deleting a manifest missing from the storage evicts it
+			delete(storage.manifests, dgst)
+			require.ErrorIs(t, w.Delete(ctx, dgst), distribution.ErrBlobUnknown)
+			_, err = w.Get(ctx, dgst)
+			require.ErrorAs(t, err, &distribution.ErrManifestUnknownRevision{})
+		})
+	}
+}
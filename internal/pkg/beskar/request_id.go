@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"net/http"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/google/uuid"
+)
+
+type requestIDKey struct{}
+
+// requestIDHandler ensures each request carries a request ID, the incoming
+// one is used if present otherwise a new one is generated. The request ID
+// is added to the request logger, sent back to the client and forwarded to
+// plugin backends.
+func requestIDHandler(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(header)
+		if requestID == "" {
+			requestID = uuid.NewString()
+			r.Header.Set(header, requestID)
+		}
+		w.Header().Set(header, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		ctx = dcontext.WithLogger(ctx, dcontext.GetLoggerWithField(ctx, "http.request.correlationid", requestID))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getRequestID returns the request ID associated with the context if any.
+func getRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
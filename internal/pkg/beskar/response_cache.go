@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.ciq.dev/beskar/internal/pkg/cache"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+type responseCache struct {
+	cache   *cache.ResponseCache
+	ttl     time.Duration
+	paths   []string
+	headers []string
+	maxSize int64
+	// requestIDHeader is the response header carrying the request
+	// ID, it's set to the ID of the current request on cache hits.
+	requestIDHeader string
+	// headerFilter removes the hop-by-hop and request scoped
+	// headers from the stored responses.
+	headerFilter *headerFilter
+	// identity returns the client identity, if set.
+	identity func(*http.Request) string
+}
+
+// newResponseCache returns a cache of whole responses of GET and HEAD
+// requests matching the configured path prefixes, nil is returned when
+// the response cache is disabled. The identity function returns the client
+// identity the responses are keyed by along with the authorization.
+func newResponseCache(rc config.ResponseCache, requestIDHeader string, identity func(*http.Request) string) *responseCache {
+	if !rc.Enabled {
+		return nil
+	}
+
+	maxSize := int64(rc.Size)
+
+	return &responseCache{
+		cache:    cache.NewResponseCache(maxSize),
+		ttl:      rc.TTL,
+		paths:    rc.Paths,
+		headers:  append([]string{"Authorization"}, rc.Headers...),
+		maxSize:  maxSize,
+		identity: identity,
+
+		requestIDHeader: requestIDHeader,
+		headerFilter: newHeaderFilter(config.HeaderFilter{
+			Deny: []string{requestIDHeader, "Date"},
+		}),
+	}
+}
+
+// handler serves the cached responses of next, Cache-Control directives
+// are honored for both requests and responses. The handler must be placed
+// after the authorization of the requests, next is returned as is with a
+// nil cache.
+func (c *responseCache) handler(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestDirectives := parseCacheControl(r.Header)
+		if _, ok := requestDirectives["no-store"]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := c.key(r)
+
+		if _, ok := requestDirectives["no-cache"]; !ok {
+			if resp, ok := c.cache.Get(key); ok {
+				for k, v := range resp.Header {
+					w.Header()[k] = v
+				}
+				if requestID := getRequestID(r.Context()); requestID != "" && c.requestIDHeader != "" {
+					w.Header().Set(c.requestIDHeader, requestID)
+				}
+				w.WriteHeader(resp.StatusCode)
+				_, _ = w.Write(resp.Body)
+				return
+			}
+		}
+
+		recorder := &responseRecorder{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+			maxSize:        c.maxSize,
+		}
+		next.ServeHTTP(recorder, r)
+
+		if ttl := c.responseTTL(recorder); ttl > 0 {
+			c.cache.Add(key, &cache.Response{
+				StatusCode: recorder.statusCode,
+				Header:     c.storedHeader(w.Header()),
+				Body:       recorder.body.Bytes(),
+			}, time.Now().Add(ttl))
+		}
+	})
+}
+
+// storedHeader returns a copy of the response header without the
+// hop-by-hop and request scoped headers.
+func (c *responseCache) storedHeader(header http.Header) http.Header {
+	stored := header.Clone()
+	c.headerFilter.apply(stored)
+	return stored
+}
+
+func (c *responseCache) cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, path := range c.paths {
+		if strings.HasPrefix(r.URL.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns a digest of the request method, URL, client identity and
+// headers varying the response, the digest avoids to keep credentials in
+// memory.
+func (c *responseCache) key(r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.RequestURI()))
+	if c.identity != nil {
+		h.Write([]byte{0})
+		h.Write([]byte(c.identity(r)))
+	}
+	for _, header := range c.headers {
+		h.Write([]byte{0})
+		h.Write([]byte(strings.Join(r.Header.Values(header), ",")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseTTL returns how long the recorded response can be cached,
+// zero means the response must not be cached.
+func (c *responseCache) responseTTL(recorder *responseRecorder) time.Duration {
+	if recorder.statusCode != http.StatusOK || recorder.overflow {
+		return 0
+	}
+
+	header := recorder.Header()
+	if header.Get("Set-Cookie") != "" || header.Get("Vary") == "*" {
+		return 0
+	}
+
+	directives := parseCacheControl(header)
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[directive]; ok {
+			return 0
+		}
+	}
+
+	ttl := c.ttl
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil {
+			return 0
+		} else if age := time.Duration(seconds) * time.Second; age < ttl {
+			ttl = age
+		}
+	}
+
+	return ttl
+}
+
+// parseCacheControl returns the Cache-Control directives and their
+// values if any.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(directive, "=")
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+
+	return directives
+}
+
+// responseRecorder forwards the response to the client while keeping
+// a copy of the body up to maxSize bytes.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	body        bytes.Buffer
+	maxSize     int64
+	overflow    bool
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if !rr.wroteHeader {
+		rr.statusCode = statusCode
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	if !rr.overflow {
+		if int64(rr.body.Len()+len(b)) > rr.maxSize {
+			rr.overflow = true
+			rr.body.Reset()
+		} else {
+			rr.body.Write(b)
+		}
+	}
+	return rr.ResponseWriter.Write(b)
+}
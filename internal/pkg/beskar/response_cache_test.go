@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestResponseCacheHandler(t *testing.T) {
+	calls := 0
+
+	handler := newResponseCache(config.ResponseCache{
+		Enabled: true,
+		Size:    config.MiB,
+		TTL:     time.Minute,
+		Paths:   []string{"/v2/_catalog", "/private"},
+		Headers: []string{"Accept"},
+	}, "X-Request-Id", func(r *http.Request) string {
+		return r.Header.Get("X-Identity")
+	}).handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		_, _ = fmt.Fprintf(w, "call %d", calls)
+	}))
+
+	get := func(path string, header http.Header) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	require.Equal(t, "call 1", get("/v2/_catalog", nil))
+	require.Equal(t, "call 1", get("/v2/_catalog", nil))
+
+	// different authorization or varying header
+	require.Equal(t, "call 2", get("/v2/_catalog", http.Header{"Authorization": []string{"Basic xxx"}}))
+	require.Equal(t, "call 3", get("/v2/_catalog", http.Header{"Accept": []string{"application/json"}}))
+	require.Equal(t, "call 2", get("/v2/_catalog", http.Header{"Authorization": []string{"Basic xxx"}}))
+
+	// different client identity
+	require.Equal(t, "call 4", get("/v2/_catalog", http.Header{"X-Identity": []string{"tenant"}}))
+	require.Equal(t, "call 4", get("/v2/_catalog", http.Header{"X-Identity": []string{"tenant"}}))
+
+	// request cache directives
+	require.Equal(t, "call 5", get("/v2/_catalog", http.Header{"Cache-Control": []string{"no-store"}}))
+	require.Equal(t, "call 1", get("/v2/_catalog", nil))
+	require.Equal(t, "call 6", get("/v2/_catalog", http.Header{"Cache-Control": []string{"no-cache"}}))
+	require.Equal(t, "call 6", get("/v2/_catalog", nil))
+
+	// uncacheable response and path
+	require.Equal(t, "call 7", get("/private", nil))
+	require.Equal(t, "call 8", get("/private", nil))
+	require.Equal(t, "call 9", get("/v2/library/tags/list", nil))
+	require.Equal(t, "call 10", get("/v2/library/tags/list", nil))
+}
+
+func TestResponseCacheHeaders(t *testing.T) {
+	const requestIDHeader = "X-Request-Id"
+
+	handler := requestIDHandler(requestIDHeader, newResponseCache(config.ResponseCache{
+		Enabled: true,
+		Size:    config.MiB,
+		TTL:     time.Minute,
+		Paths:   []string{"/v2/_catalog"},
+	}, requestIDHeader, nil).handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "hop")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		_, _ = fmt.Fprint(w, "{}")
+	})))
+
+	get := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v2/_catalog", nil)
+		req.Header.Set(requestIDHeader, requestID)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	rec := get("first")
+	require.Equal(t, "first", rec.Header().Get(requestIDHeader))
+
+	// cache hits carry the current request ID without the hop-by-hop headers
+	rec = get("second")
+	require.Equal(t, "second", rec.Header().Get(requestIDHeader))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.Empty(t, rec.Header().Get("Connection"))
+	require.Empty(t, rec.Header().Get("X-Hop"))
+	require.Empty(t, rec.Header().Get("Keep-Alive"))
+	require.Equal(t, "{}", rec.Body.String())
+}
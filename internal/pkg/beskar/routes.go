@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"encoding/json"
+	"net/http"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+const (
+	routesPath  = "/beskar/api/v1/routes"
+	pluginsPath = "/beskar/api/v1/plugins"
+)
+
+// authorizeAdmin checks that the request is authenticated with the same
+// credentials as the registry catalog, it writes the challenge response
+// and returns false if not.
+func (br *Registry) authorizeAdmin(w http.ResponseWriter, r *http.Request, name string) bool {
+	if br.accessController == nil {
+		return true
+	}
+
+	ctx := dcontext.WithRequest(r.Context(), r)
+	_, err := br.accessController.Authorized(ctx, auth.Access{
+		Resource: auth.Resource{
+			Type: "registry",
+			Name: name,
+		},
+		Action: "*",
+	})
+	if err != nil {
+		//nolint:errorlint // challenge is not wrapped
+		if ch, ok := err.(auth.Challenge); ok {
+			ch.SetHeaders(r, w)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// routesHandler renders the plugin routing table.
+func routesHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "routes") {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(routingTable(br.beskarConfig))
+	}
+}
+
+// routingTable returns the plugin routing table with the backend
+// URLs redacted like the plugins endpoint does.
+func routingTable(beskarConfig *config.BeskarConfig) []config.Route {
+	routes := beskarConfig.RoutingTable()
+	for _, route := range routes {
+		for i, backend := range route.Backends {
+			route.Backends[i] = redactBackendURL(backend)
+		}
+	}
+	return routes
+}
+
+// pluginsHandler renders the loaded plugins along with their health.
+func pluginsHandler(br *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !br.authorizeAdmin(w, r, "plugins") {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(br.ListPlugins(r.Context()))
+	}
+}
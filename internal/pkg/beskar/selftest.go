@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/version"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/gossip"
+)
+
+// selfTestTimeout bounds each self-test check.
+const selfTestTimeout = time.Minute
+
+const (
+	SelfTestPassed  = "passed"
+	SelfTestFailed  = "failed"
+	SelfTestSkipped = "skipped"
+)
+
+// SelfTestResult is the result of a subsystem check.
+type SelfTestResult struct {
+	Subsystem string `json:"subsystem"`
+	Status    string `json:"status"`
+	Duration  string `json:"duration"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SelfTestReport reports the status of each subsystem, subsystems
+// depending on a failed one are skipped.
+type SelfTestReport struct {
+	Results []SelfTestResult `json:"results"`
+}
+
+// OK returns true if no check failed.
+func (r *SelfTestReport) OK() bool {
+	for _, result := range r.Results {
+		if result.Status == SelfTestFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// run runs a subsystem check and returns true if it passed, the check
+// is skipped when skip is not empty.
+func (r *SelfTestReport) run(ctx context.Context, subsystem, skip string, check func(context.Context) (string, error)) bool {
+	result := SelfTestResult{
+		Subsystem: subsystem,
+		Status:    SelfTestSkipped,
+		Detail:    skip,
+	}
+
+	if skip == "" {
+		ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		defer cancel()
+
+		start := time.Now()
+		detail, err := check(ctx)
+		result.Duration = time.Since(start).Round(time.Millisecond).String()
+		result.Detail = detail
+
+		if err != nil {
+			result.Status = SelfTestFailed
+			result.Error = err.Error()
+		} else {
+			result.Status = SelfTestPassed
+		}
+	}
+
+	r.Results = append(r.Results, result)
+
+	return result.Status == SelfTestPassed
+}
+
+// RunSelfTest parses the configuration and checks the storage, the registry
+// and the gossip cluster without serving requests. The gossip cluster is
+// joined briefly and left once checked.
+func RunSelfTest(ctx context.Context, configDir string) *SelfTestReport {
+	report := new(SelfTestReport)
+
+	ctx = dcontext.WithVersion(ctx, version.Version)
+
+	var (
+		beskarConfig *config.BeskarConfig
+		driver       storagedriver.StorageDriver
+	)
+
+	configOK := report.run(ctx, "config", "", func(context.Context) (_ string, err error) {
+		beskarConfig, err = config.ParseBeskarConfig(configDir)
+		if err != nil {
+			return "", fmt.Errorf("while parsing configuration: %w", err)
+		}
+		return fmt.Sprintf("version %s, %d plugin(s)", beskarConfig.Version, len(beskarConfig.Plugins)), nil
+	})
+
+	skip := ""
+	if !configOK {
+		skip = "configuration is invalid"
+	}
+
+	storageOK := report.run(ctx, "storage", skip, func(ctx context.Context) (_ string, err error) {
+		storageType := beskarConfig.Registry.Storage.Type()
+
+		driver, err = factory.Create(storageType, beskarConfig.Registry.Storage.Parameters())
+		if err != nil {
+			return "", fmt.Errorf("while creating %s storage driver: %w", storageType, err)
+		}
+
+		// an empty storage has no root directory yet
+		_, err = driver.List(ctx, "/")
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			err = nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("while listing %s storage: %w", storageType, err)
+		}
+
+		return fmt.Sprintf("%s storage reachable", storageType), nil
+	})
+
+	if skip == "" && !storageOK {
+		skip = "storage is unreachable"
+	}
+
+	report.run(ctx, "registry", skip, func(ctx context.Context) (string, error) {
+		registry, err := storage.NewRegistry(ctx, driver)
+		if err != nil {
+			return "", fmt.Errorf("while creating registry: %w", err)
+		}
+
+		tags, err := listBeskarTags(ctx, registry)
+		if err != nil {
+			return "", fmt.Errorf("while listing beskar repository tags: %w", err)
+		}
+
+		return fmt.Sprintf("%d beskar repository tag(s)", len(tags)), nil
+	})
+
+	skip = ""
+	if !configOK {
+		skip = "configuration is invalid"
+	} else if !beskarConfig.Gossip.IsEnabled() {
+		skip = "gossip is disabled"
+	}
+
+	report.run(ctx, "gossip", skip, func(ctx context.Context) (string, error) {
+		member, err := gossip.StartContext(ctx, beskarConfig, nil)
+		if err != nil {
+			return "", fmt.Errorf("while joining gossip cluster: %w", err)
+		}
+
+		members := len(member.Nodes())
+
+		if err := member.Shutdown(); err != nil {
+			return "", fmt.Errorf("while leaving gossip cluster: %w", err)
+		}
+
+		return fmt.Sprintf("cluster %q joined with %d member(s)", beskarConfig.Gossip.Cluster, members), nil
+	})
+
+	return report
+}
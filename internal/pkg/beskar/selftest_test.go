@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	ctx := context.Background()
+
+	statuses := func(report *SelfTestReport) map[string]string {
+		statuses := make(map[string]string)
+		for _, result := range report.Results {
+			statuses[result.Subsystem] = result.Status
+		}
+		return statuses
+	}
+
+	dir := t.TempDir()
+	storageDir := filepath.Join(dir, "registry")
+
+	beskarConfig := fmt.Sprintf(`version: 1.0
+cache:
+  addr: 127.0.0.1:5103
+gossip:
+  enabled: false
+  addr: 127.0.0.1:5102
+registry:
+  http:
+    addr: 127.0.0.1:5100
+  storage:
+    filesystem:
+      rootdirectory: %s
+`, storageDir)
+
+	err := os.WriteFile(filepath.Join(dir, config.BeskarConfigFile), []byte(beskarConfig), 0o600)
+	require.NoError(t, err)
+
+	report := RunSelfTest(ctx, dir)
+	require.True(t, report.OK(), "%+v", report.Results)
+	require.Equal(t, map[string]string{
+		"config":   SelfTestPassed,
+		"storage":  SelfTestPassed,
+		"registry": SelfTestPassed,
+		"gossip":   SelfTestSkipped,
+	}, statuses(report))
+
+	// the checks depending on the configuration are skipped
+	err = os.WriteFile(filepath.Join(dir, config.BeskarConfigFile), []byte("version: 1.0\ngossip: [invalid]\n"), 0o600)
+	require.NoError(t, err)
+
+	report = RunSelfTest(ctx, dir)
+	require.False(t, report.OK())
+	require.Equal(t, map[string]string{
+		"config":   SelfTestFailed,
+		"storage":  SelfTestSkipped,
+		"registry": SelfTestSkipped,
+		"gossip":   SelfTestSkipped,
+	}, statuses(report))
+	require.Contains(t, report.Results[0].Error, "while parsing configuration")
+}
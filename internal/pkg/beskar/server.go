@@ -0,0 +1,317 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	logstash "github.com/bshuster-repo/logrus-logstash-hook"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/health"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/listener"
+	"github.com/distribution/distribution/v3/uuid"
+	gorhandlers "github.com/gorilla/handlers"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsVersions maps the http.tls.minimumtls values to the TLS versions.
+var tlsVersions = map[string]uint16{
+	"tls1.2": tls.VersionTLS12,
+	"tls1.3": tls.VersionTLS13,
+}
+
+// defaultCipherSuites are the cipher suites used by distribution
+// when no cipher suite is configured.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_AES_128_GCM_SHA256,
+	tls.TLS_CHACHA20_POLY1305_SHA256,
+	tls.TLS_AES_256_GCM_SHA384,
+}
+
+// registryServer serves the distribution application, the HTTP server
+// is built by beskar the same way as by distribution so the server
+// settings are directly configurable.
+type registryServer struct {
+	config *configuration.Configuration
+	server *http.Server
+	logger dcontext.Logger
+}
+
+// newRegistryServer creates the distribution application and its HTTP
+// server, the application handler is wrapped by wrap along with the
+// distribution health and liveness handlers.
+func newRegistryServer(ctx context.Context, config *configuration.Configuration, wrap func(http.Handler) http.Handler) (*registryServer, error) {
+	if config.Reporting.Bugsnag.APIKey != "" || config.Reporting.NewRelic.LicenseKey != "" {
+		return nil, fmt.Errorf("registry reporting is not supported")
+	}
+
+	ctx, err := configureLogging(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("while configuring logger: %w", err)
+	}
+
+	// warns about uuid generation issues under low entropy
+	uuid.Loggerf = dcontext.GetLogger(ctx).Warnf
+
+	app := handlers.NewApp(ctx, config)
+	// health checks are global, the application is created once
+	app.RegisterHealthChecks()
+
+	var handler http.Handler = app
+	handler = alive("/", handler)
+	handler = health.Handler(handler)
+	handler = panicHandler(handler)
+	if !config.Log.AccessLog.Disabled {
+		handler = gorhandlers.CombinedLoggingHandler(os.Stdout, handler)
+	}
+
+	return &registryServer{
+		config: config,
+		server: &http.Server{
+			Handler: wrap(handler),
+		},
+		logger: dcontext.GetLogger(ctx),
+	}, nil
+}
+
+// ListenAndServe serves the registry on the configured address, when a
+// drain timeout is configured the server is gracefully stopped on SIGTERM.
+func (rs *registryServer) ListenAndServe() error {
+	ln, err := listener.NewListener(rs.config.HTTP.Net, rs.config.HTTP.Addr)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := rs.tlsConfig()
+	if err != nil {
+		_ = ln.Close()
+		return err
+	} else if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		rs.logger.Infof("listening on %v, tls", ln.Addr())
+	} else {
+		rs.logger.Infof("listening on %v", ln.Addr())
+	}
+
+	return rs.serve(ln)
+}
+
+func (rs *registryServer) serve(ln net.Listener) error {
+	drainTimeout := rs.config.HTTP.DrainTimeout
+	if drainTimeout == 0 {
+		return rs.server.Serve(ln)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- rs.server.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-quit:
+		rs.logger.Infof("stopping server gracefully, draining connections for %s", drainTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+
+		return rs.server.Shutdown(ctx)
+	}
+}
+
+// tlsConfig returns the server TLS configuration built from the
+// http.tls settings, nil is returned when TLS is not configured.
+func (rs *registryServer) tlsConfig() (*tls.Config, error) {
+	config := rs.config.HTTP.TLS
+
+	if config.Certificate == "" && config.LetsEncrypt.CacheFile == "" {
+		return nil, nil
+	}
+
+	minimumTLS := config.MinimumTLS
+	if minimumTLS == "" {
+		minimumTLS = "tls1.2"
+	}
+	minVersion, ok := tlsVersions[minimumTLS]
+	if !ok {
+		return nil, fmt.Errorf("unknown minimum TLS level '%s' specified for http.tls.minimumtls", minimumTLS)
+	}
+
+	tlsConfig := &tls.Config{
+		ClientAuth: tls.NoClientCert,
+		NextProtos: []string{"h2", "http/1.1"},
+		MinVersion: minVersion,
+	}
+	if rs.config.HTTP.HTTP2.Disabled {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	// cipher suites are not configurable with TLS 1.3
+	if minVersion <= tls.VersionTLS12 {
+		cipherSuites, err := parseCipherSuites(config.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = cipherSuites
+	}
+
+	if config.LetsEncrypt.CacheFile != "" {
+		if config.Certificate != "" {
+			return nil, fmt.Errorf("cannot specify both certificate and Let's Encrypt")
+		}
+		m := &autocert.Manager{
+			HostPolicy: autocert.HostWhitelist(config.LetsEncrypt.Hosts...),
+			Cache:      autocert.DirCache(config.LetsEncrypt.CacheFile),
+			Email:      config.LetsEncrypt.Email,
+			Prompt:     autocert.AcceptTOS,
+		}
+		if config.LetsEncrypt.DirectoryURL != "" {
+			m.Client = &acme.Client{DirectoryURL: config.LetsEncrypt.DirectoryURL}
+		}
+		tlsConfig.GetCertificate = m.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	} else {
+		certificate, err := tls.LoadX509KeyPair(config.Certificate, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if len(config.ClientCAs) > 0 {
+		pool := x509.NewCertPool()
+
+		for _, ca := range config.ClientCAs {
+			caPem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, err
+			} else if !pool.AppendCertsFromPEM(caPem) {
+				return nil, fmt.Errorf("could not add CA %s to pool", ca)
+			}
+		}
+
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// parseCipherSuites returns the cipher suites matching the names,
+// the distribution default cipher suites are returned without names.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return defaultCipherSuites, nil
+	}
+
+	suites := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite '%s' specified for http.tls.cipherSuites", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// configureLogging configures the logger from the registry log settings
+// and returns a context carrying the configured log fields.
+func configureLogging(ctx context.Context, config *configuration.Configuration) (context.Context, error) {
+	level, err := logrus.ParseLevel(string(config.Log.Level))
+	if err != nil {
+		level = logrus.InfoLevel
+		logrus.Warnf("error parsing level %q: %v, using %q", config.Log.Level, err, level)
+	}
+	logrus.SetLevel(level)
+	logrus.SetReportCaller(config.Log.ReportCaller)
+
+	switch config.Log.Formatter {
+	case "", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat:   time.RFC3339Nano,
+			DisableHTMLEscape: true,
+		})
+	case "logstash":
+		logrus.SetFormatter(&logstash.LogstashFormatter{
+			Formatter: &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano},
+		})
+	default:
+		return ctx, fmt.Errorf("unsupported logging formatter: %q", config.Log.Formatter)
+	}
+
+	if len(config.Log.Fields) > 0 {
+		fields := make([]interface{}, 0, len(config.Log.Fields))
+		for k := range config.Log.Fields {
+			fields = append(fields, k)
+		}
+
+		ctx = dcontext.WithValues(ctx, config.Log.Fields)
+		ctx = dcontext.WithLogger(ctx, dcontext.GetLogger(ctx, fields...))
+	}
+
+	dcontext.SetDefaultLogger(dcontext.GetLogger(ctx))
+
+	return ctx, nil
+}
+
+// panicHandler logs the panics of the handler, the log hooks are
+// notified by logrus before it panics again.
+func panicHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				logrus.Panic(fmt.Sprintf("%v", err))
+			}
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// alive answers 200 on path to report the server is up,
+// other requests are passed to handler.
+func alive(path string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
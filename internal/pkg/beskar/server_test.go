@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRegistryServer(t *testing.T) {
+	registryConfig := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+	registryConfig.Log.AccessLog.Disabled = true
+
+	wrapped := false
+
+	rs, err := newRegistryServer(context.Background(), registryConfig, func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped = true
+			handler.ServeHTTP(w, r)
+		})
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	rs.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, wrapped)
+
+	rec = httptest.NewRecorder()
+	rs.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// TLS isn't configured
+	tlsConfig, err := rs.tlsConfig()
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+
+	registryConfig.Reporting.Bugsnag.APIKey = "key"
+	_, err = newRegistryServer(context.Background(), registryConfig, nil)
+	require.ErrorContains(t, err, "registry reporting is not supported")
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := parseCipherSuites(nil)
+	require.NoError(t, err)
+	require.Equal(t, defaultCipherSuites, suites)
+
+	suites, err = parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_128_CBC_SHA"})
+	require.NoError(t, err)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}, suites)
+
+	_, err = parseCipherSuites([]string{"TLS_UNKNOWN"})
+	require.ErrorContains(t, err, "unknown TLS cipher suite 'TLS_UNKNOWN'")
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+// startupPhase is a step of the server startup, a phase only runs once
+// the phases it depends on have succeeded.
+type startupPhase struct {
+	name      string
+	dependsOn []string
+	run       func(context.Context) error
+}
+
+// orderStartupPhases returns the phases ordered so that each phase comes
+// after the phases it depends on, independent phases keep their declaration
+// order. Duplicated phases, unknown dependencies and dependency cycles are
+// reported as errors.
+func orderStartupPhases(phases []startupPhase) ([]startupPhase, error) {
+	declared := make(map[string]struct{}, len(phases))
+
+	for _, phase := range phases {
+		if _, ok := declared[phase.name]; ok {
+			return nil, fmt.Errorf("startup phase %s is declared more than once", phase.name)
+		}
+		declared[phase.name] = struct{}{}
+	}
+	for _, phase := range phases {
+		for _, dependency := range phase.dependsOn {
+			if _, ok := declared[dependency]; !ok {
+				return nil, fmt.Errorf("startup phase %s depends on unknown phase %s", phase.name, dependency)
+			}
+		}
+	}
+
+	ordered := make([]startupPhase, 0, len(phases))
+	placed := make(map[string]struct{}, len(phases))
+
+	for len(ordered) < len(phases) {
+		progress := false
+
+		for _, phase := range phases {
+			if _, ok := placed[phase.name]; ok {
+				continue
+			}
+			ready := true
+			for _, dependency := range phase.dependsOn {
+				if _, ok := placed[dependency]; !ok {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, phase)
+				placed[phase.name] = struct{}{}
+				progress = true
+			}
+		}
+
+		if !progress {
+			cycle := make([]string, 0, len(phases)-len(ordered))
+			for _, phase := range phases {
+				if _, ok := placed[phase.name]; !ok {
+					cycle = append(cycle, phase.name)
+				}
+			}
+			return nil, fmt.Errorf("startup phases %s have cyclic dependencies", strings.Join(cycle, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+// runStartupPhases runs the phases in dependency order, the phases
+// depending on a failed or skipped phase are skipped while the other
+// phases still run, the errors of the failed phases are returned. The
+// outcome and duration of each phase are logged.
+func runStartupPhases(ctx context.Context, phases []startupPhase) error {
+	ordered, err := orderStartupPhases(phases)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	// failed holds the failed and skipped phases
+	failed := make(map[string]struct{})
+
+	for _, phase := range ordered {
+		// the logger is retrieved for each phase as the registry
+		// initialization configures the default logger
+		logger := dcontext.GetLogger(ctx)
+
+		skip := ""
+		for _, dependency := range phase.dependsOn {
+			if _, ok := failed[dependency]; ok {
+				skip = dependency
+				break
+			}
+		}
+		if skip != "" {
+			logger.Warnf("Startup phase %s skipped, it depends on phase %s which didn't complete", phase.name, skip)
+			failed[phase.name] = struct{}{}
+			continue
+		}
+
+		start := time.Now()
+		err := phase.run(ctx)
+		duration := time.Since(start).Round(time.Millisecond)
+
+		if err != nil {
+			logger.Errorf("Startup phase %s failed after %s: %s", phase.name, duration, err)
+			errs = append(errs, fmt.Errorf("while running startup phase %s: %w", phase.name, err))
+			failed[phase.name] = struct{}{}
+			continue
+		}
+
+		logger.Infof("Startup phase %s completed in %s", phase.name, duration)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunStartupPhases(t *testing.T) {
+	ctx := context.Background()
+
+	var ran []string
+
+	phase := func(name string, err error, dependsOn ...string) startupPhase {
+		return startupPhase{
+			name:      name,
+			dependsOn: dependsOn,
+			run: func(context.Context) error {
+				ran = append(ran, name)
+				return err
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		phases  []startupPhase
+		wantRan []string
+		wantErr string
+	}{
+		{
+			name: "ordered phases",
+			phases: []startupPhase{
+				phase("storage", nil),
+				phase("cluster", nil, "storage"),
+				phase("listener", nil, "storage", "cluster"),
+			},
+			wantRan: []string{"storage", "cluster", "listener"},
+		},
+		{
+			name: "failed phase skips its dependents",
+			phases: []startupPhase{
+				phase("storage", errors.New("unreachable")),
+				phase("cluster", nil, "storage"),
+				phase("listener", nil, "storage", "cluster"),
+			},
+			wantRan: []string{"storage"},
+			wantErr: "while running startup phase storage: unreachable",
+		},
+		{
+			name: "phases are ordered by dependency",
+			phases: []startupPhase{
+				phase("listener", nil, "storage", "cluster"),
+				phase("cluster", nil, "storage"),
+				phase("telemetry", nil),
+				phase("storage", nil),
+			},
+			wantRan: []string{"telemetry", "storage", "cluster", "listener"},
+		},
+		{
+			name: "dependents of a failed phase are skipped",
+			phases: []startupPhase{
+				phase("registry", nil),
+				phase("plugins", errors.New("no plugin"), "registry"),
+				phase("mirrors", nil, "plugins"),
+				phase("routes", nil, "registry"),
+				phase("cluster", errors.New("no peer")),
+				phase("listener", nil, "routes", "cluster"),
+			},
+			wantRan: []string{"registry", "plugins", "routes", "cluster"},
+			wantErr: "while running startup phase plugins: no plugin\nwhile running startup phase cluster: no peer",
+		},
+		{
+			name: "unknown dependency",
+			phases: []startupPhase{
+				phase("listener", nil, "gossip"),
+			},
+			wantErr: "startup phase listener depends on unknown phase gossip",
+		},
+		{
+			name: "cyclic dependencies",
+			phases: []startupPhase{
+				phase("storage", nil),
+				phase("cluster", nil, "storage", "listener"),
+				phase("listener", nil, "cluster"),
+			},
+			wantErr: "startup phases cluster, listener have cyclic dependencies",
+		},
+		{
+			name: "duplicated phase",
+			phases: []startupPhase{
+				phase("storage", nil),
+				phase("storage", nil),
+			},
+			wantErr: "startup phase storage is declared more than once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+
+			err := runStartupPhases(ctx, tt.phases)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantRan, ran)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"io"
+
+	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// storageTracingMiddleware is the name of the storage middleware
+// tracing the registry storage driver operations.
+const storageTracingMiddleware = "beskar-tracing"
+
+// registerStorageTracing adds the storage tracing middleware to the
+// registry storage middlewares.
+func registerStorageTracing(registryConfig *configuration.Configuration, tracer trace.Tracer) error {
+	err := storagemiddleware.Register(storageTracingMiddleware, func(driver storagedriver.StorageDriver, _ map[string]interface{}) (storagedriver.StorageDriver, error) {
+		return &tracingStorageDriver{StorageDriver: driver, tracer: tracer}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if registryConfig.Middleware == nil {
+		registryConfig.Middleware = make(map[string][]configuration.Middleware)
+	}
+	for _, middleware := range registryConfig.Middleware["storage"] {
+		if middleware.Name == storageTracingMiddleware {
+			return nil
+		}
+	}
+	registryConfig.Middleware["storage"] = append(registryConfig.Middleware["storage"], configuration.Middleware{
+		Name: storageTracingMiddleware,
+	})
+
+	return nil
+}
+
+// tracingStorageDriver creates a span for each storage driver operation.
+type tracingStorageDriver struct {
+	storagedriver.StorageDriver
+	tracer trace.Tracer
+}
+
+func (d *tracingStorageDriver) startSpan(ctx context.Context, operation, path string) (context.Context, trace.Span) {
+	return d.tracer.Start(
+		ctx,
+		"storage."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("beskar.storage.driver", d.StorageDriver.Name()),
+			attribute.String("beskar.storage.path", path),
+		),
+	)
+}
+
+func (d *tracingStorageDriver) GetContent(ctx context.Context, path string) (_ []byte, errFn error) {
+	ctx, span := d.startSpan(ctx, "GetContent", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *tracingStorageDriver) PutContent(ctx context.Context, path string, content []byte) (errFn error) {
+	ctx, span := d.startSpan(ctx, "PutContent", path)
+	span.SetAttributes(attribute.Int("beskar.storage.size", len(content)))
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+// Reader traces the opening of the reader, reads are not traced.
+func (d *tracingStorageDriver) Reader(ctx context.Context, path string, offset int64) (_ io.ReadCloser, errFn error) {
+	ctx, span := d.startSpan(ctx, "Reader", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+// Writer traces the opening of the writer, writes are not traced.
+func (d *tracingStorageDriver) Writer(ctx context.Context, path string, append bool) (_ storagedriver.FileWriter, errFn error) {
+	ctx, span := d.startSpan(ctx, "Writer", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+func (d *tracingStorageDriver) Stat(ctx context.Context, path string) (_ storagedriver.FileInfo, errFn error) {
+	ctx, span := d.startSpan(ctx, "Stat", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func (d *tracingStorageDriver) List(ctx context.Context, path string) (_ []string, errFn error) {
+	ctx, span := d.startSpan(ctx, "List", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.List(ctx, path)
+}
+
+func (d *tracingStorageDriver) Move(ctx context.Context, sourcePath string, destPath string) (errFn error) {
+	ctx, span := d.startSpan(ctx, "Move", sourcePath)
+	span.SetAttributes(attribute.String("beskar.storage.destination", destPath))
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+func (d *tracingStorageDriver) Delete(ctx context.Context, path string) (errFn error) {
+	ctx, span := d.startSpan(ctx, "Delete", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.Delete(ctx, path)
+}
+
+func (d *tracingStorageDriver) URLFor(ctx context.Context, path string, options map[string]interface{}) (_ string, errFn error) {
+	ctx, span := d.startSpan(ctx, "URLFor", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.URLFor(ctx, path, options)
+}
+
+func (d *tracingStorageDriver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) (errFn error) {
+	ctx, span := d.startSpan(ctx, "Walk", path)
+	defer func() {
+		endSpan(span, errFn)
+	}()
+	return d.StorageDriver.Walk(ctx, path, f)
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStorageTracing(t *testing.T) {
+	ctx := context.Background()
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+
+	registryConfig := &configuration.Configuration{}
+	require.NoError(t, registerStorageTracing(registryConfig, tracer))
+	require.Equal(t, []configuration.Middleware{{Name: storageTracingMiddleware}}, registryConfig.Middleware["storage"])
+
+	driver, err := storagemiddleware.Get(storageTracingMiddleware, nil, inmemory.New())
+	require.NoError(t, err)
+
+	require.NoError(t, driver.PutContent(ctx, "/blob", []byte("content")))
+	content, err := driver.GetContent(ctx, "/blob")
+	require.NoError(t, err)
+	require.Equal(t, []byte("content"), content)
+	_, err = driver.Stat(ctx, "/missing")
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	require.Equal(t, "storage.PutContent", spans[0].Name())
+	require.Equal(t, "storage.GetContent", spans[1].Name())
+	require.Equal(t, "storage.Stat", spans[2].Name())
+	require.Equal(t, codes.Error, spans[2].Status().Code)
+
+	for _, attr := range spans[1].Attributes() {
+		if attr.Key == "beskar.storage.path" {
+			require.Equal(t, "/blob", attr.Value.AsString())
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package beskar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go.ciq.dev/beskar"
+
+// Option defines a registry option.
+type Option func(*Registry)
+
+// WithTracerProvider sets the tracer provider used when tracing is enabled,
+// spans are exported to the configured OTLP collector by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(br *Registry) {
+		br.tracerProvider = tp
+	}
+}
+
+func (br *Registry) initTracing(ctx context.Context) error {
+	if !br.beskarConfig.Tracing.Enabled {
+		br.tracerProvider = trace.NewNoopTracerProvider()
+	} else if br.tracerProvider == nil {
+		tp, err := newExportingTracerProvider(ctx, br.beskarConfig.Tracing.Exporter)
+		if err != nil {
+			return fmt.Errorf("while initializing tracing exporter: %w", err)
+		}
+		br.tracerProvider = tp
+		br.shutdownTracing = tp.Shutdown
+	}
+	br.tracer = br.tracerProvider.Tracer(tracerName)
+	br.propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+	return nil
+}
+
+// newExportingTracerProvider returns a tracer provider exporting the
+// spans in batches to an OTLP HTTP collector.
+func newExportingTracerProvider(ctx context.Context, exporter config.TracingExporter) (*sdktrace.TracerProvider, error) {
+	var options []otlptracehttp.Option
+	if exporter.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(exporter.Endpoint))
+	}
+	if exporter.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	spanExporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName("beskar")),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// tracingHandler creates a span for each request, the trace context
+// of the incoming request is used as parent if any.
+func tracingHandler(tracer trace.Tracer, propagator propagation.TextMapPropagator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(
+			ctx,
+			fmt.Sprintf("HTTP %s", r.Method),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher for streamed responses.
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -6,49 +6,83 @@ package cache
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/mailgun/groupcache/v2"
+	"github.com/mailgun/groupcache/v2/consistenthash"
+	"go.ciq.dev/beskar/pkg/netutil"
 )
 
-const (
-	// 64 MiB cache size by default
-	DefaultCacheSize = 1024 * 1024 * 64
-)
+const defaultBasePath = "/_groupcache/"
+
+// poolReplicas is the number of keys of each peer on the consistent
+// hash ring, it's the groupcache default.
+const poolReplicas = 50
+
+type peer struct {
+	name      string
+	zone      string
+	publicURL string
+}
 
 type GroupCache struct {
 	peerMutex sync.Mutex
-	peers     map[string]string
-	pool      *groupcache.HTTPPool
-	groups    map[string]*groupcache.Group
-	self      string
-	server    http.Server
+	peers     map[string]peer
+	// remotePeers are the peers left out of the pool because
+	// they are located in another zone.
+	remotePeers []string
+	pool        *groupcache.HTTPPool
+	transport   func(context.Context) http.RoundTripper
+	groups      map[string]*Group
+	self        string
+	zone        string
+	basePath    string
+	server      http.Server
 }
 
-func NewCache(self string, options *groupcache.HTTPPoolOptions) *GroupCache {
+// NewCache returns a group cache, when a zone is specified peers
+// located in the same zone are preferred.
+func NewCache(self string, zone string, options *groupcache.HTTPPoolOptions) *GroupCache {
 	if options == nil {
 		options = &groupcache.HTTPPoolOptions{}
 	}
+	// Owner picks keys with the same ring as the pool
+	if options.Replicas == 0 {
+		options.Replicas = poolReplicas
+	}
 
 	pool := groupcache.NewHTTPPoolOpts(self, options)
 	pool.Set(self)
 
+	basePath := options.BasePath
+	if basePath == "" {
+		basePath = defaultBasePath
+	}
+
 	return &GroupCache{
-		peers: map[string]string{
-			self: "",
+		peers: map[string]peer{
+			self: {zone: zone},
 		},
-		pool:   pool,
-		self:   self,
-		groups: make(map[string]*groupcache.Group),
+		pool:      pool,
+		transport: options.Transport,
+		self:      self,
+		zone:      zone,
+		basePath:  basePath,
+		groups:    make(map[string]*Group),
 	}
 }
 
-func (gc *GroupCache) Start(tlsConfig *tls.Config) error {
+// Start serves the cache, connections exceeding the limiter client IP
+// limit are closed, a nil limiter means no limit.
+func (gc *GroupCache) Start(tlsConfig *tls.Config, limiter *netutil.ConnLimiter) error {
 	u, err := url.Parse(gc.self)
 	if err != nil {
 		return err
@@ -57,6 +91,9 @@ func (gc *GroupCache) Start(tlsConfig *tls.Config) error {
 	if err != nil {
 		return err
 	}
+	if limiter != nil {
+		ln = limiter.Listener(ln)
+	}
 	ln = tls.NewListener(ln, tlsConfig)
 	gc.server = http.Server{
 		Handler:           gc.pool,
@@ -72,34 +109,199 @@ func (gc *GroupCache) Stop(ctx context.Context) error {
 	return gc.server.Shutdown(ctx)
 }
 
+// SelectPeers returns the sorted peer URLs keys are distributed across
+// from the zone of each peer URL, keys are distributed across peers of
+// the same zone if there is at least one other peer in the zone, otherwise
+// keys are distributed across all peers.
+func SelectPeers(zone string, peerZones map[string]string) []string {
+	peers := make([]string, 0, len(peerZones))
+	zonePeers := make([]string, 0, len(peerZones))
+
+	for url, peerZone := range peerZones {
+		peers = append(peers, url)
+		if zone != "" && peerZone == zone {
+			zonePeers = append(zonePeers, url)
+		}
+	}
+
+	if len(zonePeers) > 1 {
+		peers = zonePeers
+	}
+
+	sort.Strings(peers)
+
+	return peers
+}
+
+// Owner returns the URL of the peer owning the key among the selected
+// peers, the owner is picked the same way as by the cache pool.
+func Owner(key string, peers []string) string {
+	ring := consistenthash.New(poolReplicas, nil)
+	ring.Add(peers...)
+	return ring.Get(key)
+}
+
+// setPeers updates the pool peers, the peers left out of the pool
+// are kept to propagate the key removals to them.
 func (gc *GroupCache) setPeers() {
-	peers := make([]string, 0, len(gc.peers))
+	peerZones := make(map[string]string, len(gc.peers))
+	for url, p := range gc.peers {
+		peerZones[url] = p.zone
+	}
 
-	for peer := range gc.peers {
-		peers = append(peers, peer)
+	peers := SelectPeers(gc.zone, peerZones)
+	for _, url := range peers {
+		delete(peerZones, url)
 	}
 
+	gc.remotePeers = gc.remotePeers[:0]
+	for url := range peerZones {
+		gc.remotePeers = append(gc.remotePeers, url)
+	}
+	sort.Strings(gc.remotePeers)
+
 	gc.pool.Set(peers...)
 }
 
-func (gc *GroupCache) AddPeer(peer string, name string) {
+// removeFromRemotePeers removes the key of the group from the caches of
+// the peers located in other zones, the pool only broadcasts removals to
+// the peers keys are distributed across.
+func (gc *GroupCache) removeFromRemotePeers(ctx context.Context, group string, key string) error {
 	gc.peerMutex.Lock()
-	gc.peers[peer] = name
+	peers := append([]string(nil), gc.remotePeers...)
+	gc.peerMutex.Unlock()
+
+	if len(peers) == 0 {
+		return nil
+	}
+
+	transport := http.DefaultTransport
+	if gc.transport != nil {
+		transport = gc.transport(ctx)
+	}
+
+	errs := make([]error, len(peers))
+	wg := sync.WaitGroup{}
+
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			errs[i] = removeFromPeer(ctx, transport, peer+gc.basePath, group, key)
+		}(i, peer)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// removeFromPeer sends a key removal to the peer the same way as the
+// pool does, peers handle it like a removal broadcast by the pool.
+func removeFromPeer(ctx context.Context, transport http.RoundTripper, baseURL string, group string, key string) error {
+	u := baseURL + url.PathEscape(group) + "/" + url.PathEscape(key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("while removing key from cache peer %s: %w", baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cache peer %s has returned status %d while removing key", baseURL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// removeFromLocalCache removes the key of the group from the local cache
+// only, groupcache doesn't expose it so the removal is served in-process
+// like a removal broadcast by a peer.
+func (gc *GroupCache) removeFromLocalCache(ctx context.Context, group string, key string) error {
+	u := &url.URL{Path: gc.basePath + group + "/" + key}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	rec := &statusRecorder{header: make(http.Header), status: http.StatusOK}
+	gc.pool.ServeHTTP(rec, req)
+
+	if rec.status != http.StatusOK {
+		return fmt.Errorf("local cache has returned status %d while removing key", rec.status)
+	}
+
+	return nil
+}
+
+// statusRecorder is a response writer discarding the body and recording
+// the status of in-process requests.
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (sr *statusRecorder) Header() http.Header {
+	return sr.header
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+}
+
+// AddPeer adds a cache peer, the public URL is the externally reachable
+// URL of the peer node if advertised.
+func (gc *GroupCache) AddPeer(url string, name string, zone string, publicURL string) {
+	gc.peerMutex.Lock()
+	gc.peers[url] = peer{
+		name:      name,
+		zone:      zone,
+		publicURL: publicURL,
+	}
 	gc.setPeers()
 	gc.peerMutex.Unlock()
 }
 
-func (gc *GroupCache) RemovePeer(peer string, name string) {
+func (gc *GroupCache) RemovePeer(url string, name string) {
 	gc.peerMutex.Lock()
-	v, ok := gc.peers[peer]
-	if ok && v == name {
-		delete(gc.peers, peer)
+	v, ok := gc.peers[url]
+	if ok && v.name == name {
+		delete(gc.peers, url)
 		gc.setPeers()
 	}
 	gc.peerMutex.Unlock()
 }
 
-func (gc *GroupCache) NewGroup(name string, cacheBytes int64, getter groupcache.Getter) (*groupcache.Group, error) {
+// OwnerPublicURL returns the public URL of the peer owning the key, false
+// is returned if the key is owned by the local node or if the owner doesn't
+// advertise a public URL.
+func (gc *GroupCache) OwnerPublicURL(key string) (string, bool) {
+	getter, ok := gc.pool.PickPeer(key)
+	if !ok {
+		return "", false
+	}
+
+	gc.peerMutex.Lock()
+	defer gc.peerMutex.Unlock()
+
+	p, ok := gc.peers[strings.TrimSuffix(getter.GetURL(), gc.basePath)]
+	if !ok || p.publicURL == "" {
+		return "", false
+	}
+	return p.publicURL, true
+}
+
+func (gc *GroupCache) NewGroup(name string, cacheBytes int64, getter groupcache.Getter) (*Group, error) {
 	if group, ok := gc.groups[name]; ok {
 		return group, nil
 	}
@@ -108,7 +310,9 @@ func (gc *GroupCache) NewGroup(name string, cacheBytes int64, getter groupcache.
 		return nil, fmt.Errorf("getter is nil")
 	}
 
-	group := groupcache.NewGroup(name, cacheBytes, getter)
+	group := NewGroup(name, cacheBytes, getter)
+	group.removeRemote = gc.removeFromRemotePeers
+	group.removeLocal = gc.removeFromLocalCache
 	gc.groups[name] = group
 
 	return group, nil
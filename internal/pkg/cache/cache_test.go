@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/groupcache/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type peerRecorder struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	requests []string
+}
+
+func newPeerRecorder(t *testing.T) *peerRecorder {
+	pr := &peerRecorder{}
+	pr.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pr.mutex.Lock()
+		pr.requests = append(pr.requests, r.Method+" "+r.URL.Path)
+		pr.mutex.Unlock()
+	}))
+	t.Cleanup(pr.Close)
+	return pr
+}
+
+func (pr *peerRecorder) Requests() []string {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	return append([]string(nil), pr.requests...)
+}
+
+func TestGroupCacheZones(t *testing.T) {
+	ctx := context.Background()
+
+	// the pool also broadcasts removals to the local node
+	self := newPeerRecorder(t)
+	zonePeer := newPeerRecorder(t)
+	remotePeer := newPeerRecorder(t)
+
+	gc := NewCache(self.URL, "zone-a", nil)
+	gc.AddPeer(zonePeer.URL, "b", "zone-a", "https://b.example.com")
+	gc.AddPeer(remotePeer.URL, "c", "zone-b", "")
+
+	// keys are only distributed across the peers of the zone
+	require.Equal(t, []string{remotePeer.URL}, gc.remotePeers)
+	for _, getter := range gc.pool.GetAll() {
+		require.NotEqual(t, remotePeer.URL+defaultBasePath, getter.GetURL())
+	}
+
+	// keys owned by a peer redirect to its public URL
+	owned, local := 0, 0
+	for i := 0; i < 256; i++ {
+		key := fmt.Sprintf("%x", sha256.Sum256([]byte{byte(i)}))
+		publicURL, ok := gc.OwnerPublicURL(key)
+		if _, remote := gc.pool.PickPeer(key); remote {
+			require.True(t, ok)
+			require.Equal(t, "https://b.example.com", publicURL)
+			owned++
+		} else {
+			require.False(t, ok)
+			local++
+		}
+	}
+	require.NotZero(t, owned)
+	require.NotZero(t, local)
+
+	group, err := gc.NewGroup("zones", 1<<20, groupcache.GetterFunc(func(context.Context, string, groupcache.Sink) error {
+		return nil
+	}))
+	require.NoError(t, err)
+
+	// removals reach the peers of other zones
+	require.NoError(t, group.Remove(ctx, "key"))
+	require.Contains(t, remotePeer.Requests(), "DELETE /_groupcache/zones/key")
+	require.Contains(t, zonePeer.Requests(), "DELETE /_groupcache/zones/key")
+
+	// peers of other zones drop the keys set
+	require.NoError(t, group.Set(ctx, "other", []byte("value"), time.Now().Add(time.Minute), false))
+	require.Contains(t, remotePeer.Requests(), "DELETE /_groupcache/zones/other")
+
+	// unreachable peers of other zones don't fail the keys set
+	var remoteErrs []error
+	group.OnRemoteError(func(key string, err error) {
+		require.Equal(t, "unreachable", key)
+		remoteErrs = append(remoteErrs, err)
+	})
+	unreachable := newPeerRecorder(t)
+	unreachable.Close()
+	gc.AddPeer(unreachable.URL, "d", "zone-c", "")
+
+	require.NoError(t, group.Set(ctx, "unreachable", []byte("value"), time.Now().Add(time.Minute), false))
+	require.Len(t, remoteErrs, 1)
+	require.Contains(t, remotePeer.Requests(), "DELETE /_groupcache/zones/unreachable")
+	gc.RemovePeer(unreachable.URL, "d")
+
+	// local evictions aren't broadcasted to the peers
+	loads := 0
+	localGroup, err := gc.NewGroup("local", 1<<20, groupcache.GetterFunc(func(_ context.Context, key string, dest groupcache.Sink) error {
+		loads++
+		return dest.SetString(key, time.Time{})
+	}))
+	require.NoError(t, err)
+
+	var keys []string
+	for i := 0; len(keys) < 2; i++ {
+		key := fmt.Sprintf("repo/%x", sha256.Sum256([]byte{byte(i)}))
+		if _, remote := gc.pool.PickPeer(key); !remote {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		var value string
+		require.NoError(t, localGroup.Get(ctx, key, groupcache.StringSink(&value)))
+	}
+	require.Equal(t, 2, loads)
+
+	selfRequests, zoneRequests, remoteRequests := len(self.Requests()), len(zonePeer.Requests()), len(remotePeer.Requests())
+
+	evicted, err := localGroup.EvictLocalKey(ctx, keys[0])
+	require.NoError(t, err)
+	require.Equal(t, 1, evicted)
+
+	evicted, err = localGroup.EvictLocalPrefix(ctx, "repo/")
+	require.NoError(t, err)
+	require.Equal(t, 1, evicted)
+
+	require.Len(t, self.Requests(), selfRequests)
+	require.Len(t, zonePeer.Requests(), zoneRequests)
+	require.Len(t, remotePeer.Requests(), remoteRequests)
+
+	// locally evicted keys are loaded again
+	for _, key := range keys {
+		var value string
+		require.NoError(t, localGroup.Get(ctx, key, groupcache.StringSink(&value)))
+	}
+	require.Equal(t, 4, loads)
+
+	// without another peer in the zone, keys are distributed across all peers
+	gc.RemovePeer(zonePeer.URL, "b")
+	require.Empty(t, gc.remotePeers)
+}
+
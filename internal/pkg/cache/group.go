@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache/v2"
+	"github.com/mailgun/groupcache/v2/lru"
+)
+
+// maxIndexedKeys bounds the number of keys indexed by a group,
+// the least recently used keys are dropped from the index first.
+const maxIndexedKeys = 1 << 16
+
+// Group is a groupcache group indexing the keys read or set through
+// it, groupcache doesn't allow to enumerate cached keys which is
+// required to evict keys by prefix.
+type Group struct {
+	*groupcache.Group
+
+	mutex sync.Mutex
+	index *lru.Cache
+	keys  map[string]struct{}
+
+	// removeRemote removes a key from the caches of the peers
+	// the group keys aren't distributed across, if set.
+	removeRemote func(ctx context.Context, group string, key string) error
+	// removeLocal removes a key from the local cache only, if
+	// set, groupcache removals are broadcasted to all peers.
+	removeLocal func(ctx context.Context, group string, key string) error
+	// onRemoteError is called when a key set can't be removed
+	// from the caches of the peers of other zones, if set.
+	onRemoteError func(key string, err error)
+}
+
+// NewGroup returns a group indexing up to maxIndexedKeys keys.
+func NewGroup(name string, cacheBytes int64, getter groupcache.Getter) *Group {
+	g := &Group{
+		Group: groupcache.NewGroup(name, cacheBytes, getter),
+		index: lru.New(maxIndexedKeys),
+		keys:  make(map[string]struct{}),
+	}
+	g.index.OnEvicted = func(key lru.Key, _ interface{}) {
+		delete(g.keys, key.(string))
+	}
+	return g
+}
+
+func (g *Group) addKey(key string) {
+	g.mutex.Lock()
+	g.index.Add(key, nil, time.Time{})
+	g.keys[key] = struct{}{}
+	g.mutex.Unlock()
+}
+
+func (g *Group) removeKey(key string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	_, ok := g.keys[key]
+	g.index.Remove(key)
+	return ok
+}
+
+// Get retrieves the value of the key and indexes the key.
+func (g *Group) Get(ctx context.Context, key string, dest groupcache.Sink) error {
+	if err := g.Group.Get(ctx, key, dest); err != nil {
+		return err
+	}
+	g.addKey(key)
+	return nil
+}
+
+// Set sets the value of the key and indexes the key, peers of other
+// zones drop the key and load the new value on their next read. The
+// removal from the peers of other zones is best effort, failures are
+// reported to the handler set with OnRemoteError.
+func (g *Group) Set(ctx context.Context, key string, value []byte, expire time.Time, hotCache bool) error {
+	if err := g.Group.Set(ctx, key, value, expire, hotCache); err != nil {
+		return err
+	}
+	g.addKey(key)
+	if err := g.removeFromRemotePeers(ctx, key); err != nil && g.onRemoteError != nil {
+		g.onRemoteError(key, err)
+	}
+	return nil
+}
+
+// OnRemoteError sets the handler called when a key set can't be removed
+// from the caches of the peers of other zones.
+func (g *Group) OnRemoteError(handler func(key string, err error)) {
+	g.onRemoteError = handler
+}
+
+// Remove removes the key from the group and from the index.
+func (g *Group) Remove(ctx context.Context, key string) error {
+	g.removeKey(key)
+	if err := g.Group.Remove(ctx, key); err != nil {
+		return err
+	}
+	return g.removeFromRemotePeers(ctx, key)
+}
+
+// removeFromLocalCache removes the key from the local cache only, it falls
+// back to the groupcache removal for groups not created by a GroupCache.
+func (g *Group) removeFromLocalCache(ctx context.Context, key string) error {
+	if g.removeLocal == nil {
+		return g.Group.Remove(ctx, key)
+	}
+	return g.removeLocal(ctx, g.Name(), key)
+}
+
+func (g *Group) removeFromRemotePeers(ctx context.Context, key string) error {
+	if g.removeRemote == nil {
+		return nil
+	}
+	return g.removeRemote(ctx, g.Name(), key)
+}
+
+// EvictKey removes the key from the local cache and from the cache of
+// all peers, it returns 1 if the key was indexed, 0 otherwise.
+func (g *Group) EvictKey(ctx context.Context, key string) (int, error) {
+	return g.evictKey(ctx, key, g.Remove)
+}
+
+// EvictLocalKey is like EvictKey but removes the key from the local
+// cache only, it's meant for evictions already sent to all peers.
+func (g *Group) EvictLocalKey(ctx context.Context, key string) (int, error) {
+	return g.evictKey(ctx, key, g.removeFromLocalCache)
+}
+
+func (g *Group) evictKey(ctx context.Context, key string, remove func(context.Context, string) error) (int, error) {
+	indexed := g.removeKey(key)
+
+	if err := remove(ctx, key); err != nil {
+		return 0, err
+	}
+	if indexed {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// EvictPrefix removes the indexed keys starting with prefix from the
+// local cache and from the cache of all peers, it returns the number
+// of keys removed. Only the keys read or set through this group are
+// known, keys cached by peers on their behalf must be evicted by them.
+func (g *Group) EvictPrefix(ctx context.Context, prefix string) (int, error) {
+	return g.evictPrefix(ctx, prefix, g.Remove)
+}
+
+// EvictLocalPrefix is like EvictPrefix but removes the keys from the
+// local cache only, it's meant for evictions already sent to all peers.
+func (g *Group) EvictLocalPrefix(ctx context.Context, prefix string) (int, error) {
+	return g.evictPrefix(ctx, prefix, g.removeFromLocalCache)
+}
+
+func (g *Group) evictPrefix(ctx context.Context, prefix string, remove func(context.Context, string) error) (int, error) {
+	g.mutex.Lock()
+	keys := make([]string, 0)
+	for key := range g.keys {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	g.mutex.Unlock()
+
+	sort.Strings(keys)
+
+	var errs []error
+
+	evicted := 0
+
+	for _, key := range keys {
+		g.removeKey(key)
+		if err := remove(ctx, key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		evicted++
+	}
+
+	return evicted, errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache/v2/lru"
+)
+
+// Response is a cached HTTP response.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (r *Response) size() int64 {
+	size := int64(len(r.Body))
+	for k, values := range r.Header {
+		size += int64(len(k))
+		for _, v := range values {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
+
+// ResponseCache is a local LRU cache of HTTP responses bounded in bytes,
+// it relies on the same LRU implementation than groupcache.
+type ResponseCache struct {
+	mutex    sync.Mutex
+	lru      *lru.Cache
+	maxBytes int64
+	nbytes   int64
+}
+
+// NewResponseCache returns a response cache holding up to maxBytes
+// of responses.
+func NewResponseCache(maxBytes int64) *ResponseCache {
+	rc := &ResponseCache{
+		lru:      lru.New(0),
+		maxBytes: maxBytes,
+	}
+	rc.lru.OnEvicted = func(_ lru.Key, value interface{}) {
+		rc.nbytes -= value.(*Response).size()
+	}
+	return rc
+}
+
+// Get returns the cached response for the key if any and not expired.
+func (rc *ResponseCache) Get(key string) (*Response, bool) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	value, ok := rc.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return value.(*Response), true
+}
+
+// Add caches the response for the key until it expires, responses
+// larger than the cache size are ignored.
+func (rc *ResponseCache) Add(key string, resp *Response, expire time.Time) {
+	size := resp.size()
+	if size > rc.maxBytes {
+		return
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	rc.lru.Add(key, resp, expire)
+	rc.nbytes += size
+
+	for rc.nbytes > rc.maxBytes {
+		rc.lru.RemoveOldest()
+	}
+}
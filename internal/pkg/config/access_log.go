@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	AccessLogOutputStdout = "stdout"
+	AccessLogOutputStderr = "stderr"
+
+	AccessLogFormatJSON = "json"
+	AccessLogFormatText = "text"
+
+	AccessLogFieldTimestamp  = "timestamp"
+	AccessLogFieldClientIP   = "client-ip"
+	AccessLogFieldIdentity   = "identity"
+	AccessLogFieldRepository = "repository"
+	AccessLogFieldOperation  = "operation"
+	AccessLogFieldMethod     = "method"
+	AccessLogFieldPath       = "path"
+	// AccessLogFieldHeaders holds the request headers, the value of
+	// headers carrying credentials is redacted.
+	AccessLogFieldHeaders = "headers"
+	// AccessLogFieldBytes holds the response body size and
+	// AccessLogFieldBytesIn the request body size.
+	AccessLogFieldBytes    = "bytes"
+	AccessLogFieldBytesIn  = "bytes-in"
+	AccessLogFieldStatus   = "status"
+	AccessLogFieldDuration = "duration"
+)
+
+// AccessLogFields are the fields available in access log records.
+var AccessLogFields = []string{
+	AccessLogFieldTimestamp,
+	AccessLogFieldClientIP,
+	AccessLogFieldIdentity,
+	AccessLogFieldRepository,
+	AccessLogFieldOperation,
+	AccessLogFieldMethod,
+	AccessLogFieldPath,
+	AccessLogFieldHeaders,
+	AccessLogFieldBytes,
+	AccessLogFieldBytesIn,
+	AccessLogFieldStatus,
+	AccessLogFieldDuration,
+}
+
+// AccessLog defines the access logger emitting one record per repository
+// request, separately from the application log.
+type AccessLog struct {
+	Enabled bool `yaml:"enabled"`
+	// Output is either stdout, stderr or a file path.
+	Output string `yaml:"output"`
+	Format string `yaml:"format"`
+	// Fields lists the record fields, all fields by default.
+	Fields []string `yaml:"fields"`
+	// SampleRate is the ratio of logged requests between 0 and 1,
+	// a zero value means all requests are logged.
+	SampleRate float64 `yaml:"sample-rate"`
+}
+
+func (al *AccessLog) setDefaults() error {
+	if !al.Enabled {
+		return nil
+	}
+
+	if al.Output == "" {
+		al.Output = AccessLogOutputStdout
+	}
+
+	switch al.Format {
+	case "":
+		al.Format = AccessLogFormatJSON
+	case AccessLogFormatJSON, AccessLogFormatText:
+	default:
+		return fmt.Errorf("access-log format must be either %q or %q", AccessLogFormatJSON, AccessLogFormatText)
+	}
+
+	if len(al.Fields) == 0 {
+		al.Fields = AccessLogFields
+	}
+	for _, field := range al.Fields {
+		known := false
+		for _, f := range AccessLogFields {
+			known = known || f == field
+		}
+		if !known {
+			return fmt.Errorf("access-log field %q is unknown, supported fields are: %s", field, strings.Join(AccessLogFields, ", "))
+		}
+	}
+
+	if al.SampleRate == 0 {
+		al.SampleRate = 1
+	} else if al.SampleRate < 0 || al.SampleRate > 1 {
+		return fmt.Errorf("access-log sample-rate must be between 0 and 1")
+	}
+
+	return nil
+}
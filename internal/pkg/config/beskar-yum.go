@@ -13,72 +13,526 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/distribution/distribution/v3/configuration"
+	"github.com/sirupsen/logrus"
 )
 
 const (
 	BeskarYumConfigFile     = "beskar-yum.yaml"
 	DefaultBeskarYumDataDir = "/tmp/beskar-yum"
 
+	DefaultBeskarYumRegistryRetryBackoff = 500 * time.Millisecond
+
+	DefaultBeskarYumStorageRetryBaseDelay = 100 * time.Millisecond
+
+	DefaultBeskarYumS3MultipartCleanupInterval = time.Hour
+	DefaultBeskarYumS3MultipartCleanupMaxAge   = 24 * time.Hour
+
+	// DefaultBeskarYumS3Region is the region used with a custom endpoint
+	// when no region is set, S3 compatible servers like MinIO accept it.
+	DefaultBeskarYumS3Region = "us-east-1"
+
+	// DefaultBeskarYumUploadsRetryAfter is the retry delay suggested
+	// to clients when an upload is rejected.
+	DefaultBeskarYumUploadsRetryAfter = 30 * time.Second
+	// DefaultBeskarYumUploadsQueueTimeout is the time an upload waits
+	// for a slot before being rejected in queue mode.
+	DefaultBeskarYumUploadsQueueTimeout = time.Minute
+	// DefaultBeskarYumUploadsResumableTTL is the time after which an
+	// interrupted resumable upload is garbage collected.
+	DefaultBeskarYumUploadsResumableTTL = 24 * time.Hour
+
+	// DefaultBeskarYumGCGracePeriod is the time a package manifest must
+	// have been seen unreferenced before being garbage collected.
+	DefaultBeskarYumGCGracePeriod = time.Hour
+
+	// UploadsOverflowReject rejects the uploads exceeding the limit.
+	UploadsOverflowReject = "reject"
+	// UploadsOverflowQueue makes the uploads exceeding the limit wait
+	// for a slot up to the queue timeout.
+	UploadsOverflowQueue = "queue"
+
+	// MetadataCompressionGzip compresses the XML metadata with gzip,
+	// it's supported by all yum and dnf clients.
+	MetadataCompressionGzip = "gzip"
+	// MetadataCompressionXZ compresses the XML metadata with xz.
+	MetadataCompressionXZ = "xz"
+	// MetadataCompressionZstd compresses the XML metadata with zstd, it
+	// requires dnf clients built with zstd support (EL9, recent Fedora).
+	MetadataCompressionZstd = "zstd"
+
+	// DefaultMetadataCompression is the default XML metadata codec.
+	DefaultMetadataCompression = MetadataCompressionGzip
+
+	// DefaultBeskarYumStatsMaxRepositories is the number of repositories
+	// with their own statistics series.
+	DefaultBeskarYumStatsMaxRepositories = 50
+
 	FSStorageDriver    = "filesystem"
 	S3StorageDriver    = "s3"
 	GCSStorageDriver   = "gcs"
 	AzureStorageDriver = "azure"
+	// InMemoryStorageDriver keeps objects in memory and is intended for tests.
+	InMemoryStorageDriver = "inmemory"
+
+	// EncryptionModeDirect encrypts objects with the configured key.
+	EncryptionModeDirect = "direct"
+	// EncryptionModeEnvelope encrypts each object with a random data key
+	// wrapped by the configured key.
+	EncryptionModeEnvelope = "envelope"
+
+	// StoragePrefixPluginVar is replaced by the plugin name in storage prefix.
+	StoragePrefixPluginVar = "{plugin}"
+	// StoragePrefixRepoVar is replaced by the repository name in storage prefix.
+	StoragePrefixRepoVar = "{repo}"
 )
 
 //go:embed default/beskar-yum.yaml
 var defaultBeskarYumConfig string
 
 type BeskarYumRegistry struct {
-	URL      string `yaml:"url"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	URL          string        `yaml:"url"`
+	Username     string        `yaml:"username"`
+	Password     string        `yaml:"password"`
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry-backoff"`
+}
+
+// BeskarYumStorageRetry defines how storage operations failing with a
+// transient error are retried, retries are disabled when MaxRetries is 0.
+type BeskarYumStorageRetry struct {
+	MaxRetries int           `yaml:"max-retries"`
+	BaseDelay  time.Duration `yaml:"base-delay"`
+}
+
+// BeskarYumS3MultipartCleanup defines the periodic abort of incomplete
+// multipart uploads left by interrupted writes, uploads initiated more
+// than MaxAge ago are aborted every Interval.
+type BeskarYumS3MultipartCleanup struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	MaxAge   time.Duration `yaml:"max-age"`
+}
+
+func (mc *BeskarYumS3MultipartCleanup) setDefaults() error {
+	if mc.Interval < 0 {
+		return fmt.Errorf("storage s3 multipart-cleanup interval must be positive")
+	} else if mc.Interval == 0 {
+		mc.Interval = DefaultBeskarYumS3MultipartCleanupInterval
+	}
+	if mc.MaxAge < 0 {
+		return fmt.Errorf("storage s3 multipart-cleanup max-age must be positive")
+	} else if mc.MaxAge == 0 {
+		mc.MaxAge = DefaultBeskarYumS3MultipartCleanupMaxAge
+	}
+	return nil
 }
 
 type BeskarYumS3Storage struct {
-	Endpoint        string `yaml:"endpoint"`
-	Bucket          string `yaml:"bucket"`
-	AccessKeyID     string `yaml:"access-key-id"`
-	SecretAccessKey string `yaml:"secret-access-key"`
-	SessionToken    string `yaml:"session-token"`
-	Region          string `yaml:"region"`
-	DisableSSL      bool   `yaml:"disable-ssl"`
+	Endpoint         string                      `yaml:"endpoint"`
+	Bucket           string                      `yaml:"bucket"`
+	AccessKeyID      string                      `yaml:"access-key-id"`
+	SecretAccessKey  string                      `yaml:"secret-access-key"`
+	SessionToken     string                      `yaml:"session-token"`
+	Region           string                      `yaml:"region"`
+	DisableSSL       bool                        `yaml:"disable-ssl"`
+	Retry            BeskarYumStorageRetry       `yaml:"retry"`
+	MultipartCleanup BeskarYumS3MultipartCleanup `yaml:"multipart-cleanup"`
+	// ObjectTags are set on the written objects, eg: to be
+	// targeted by bucket lifecycle rules.
+	ObjectTags map[string]string `yaml:"object-tags"`
+}
+
+// awsS3EndpointRegexp matches the regional AWS S3 endpoints and
+// captures the region, eg: s3.eu-west-1.amazonaws.com.
+var awsS3EndpointRegexp = regexp.MustCompile(`^(?:https?://)?(?:[^/]+\.)?s3[.-](?:dualstack\.)?([a-z]{2}(?:-[a-z]+)+-[0-9]+)\.amazonaws\.com(?:\.cn)?(?:[:/]|$)`)
+
+// setDefaults validates the endpoint and the region, the endpoint is
+// resolved from the region for AWS when not set. A region is required
+// by the SDK: with a custom endpoint it defaults to the region of an AWS
+// endpoint or to DefaultBeskarYumS3Region.
+func (s *BeskarYumS3Storage) setDefaults() error {
+	if s.Endpoint == "" && s.Region == "" {
+		return fmt.Errorf("storage s3 requires a region for AWS or an endpoint for S3 compatible servers")
+	}
+
+	var endpointRegion string
+	if m := awsS3EndpointRegexp.FindStringSubmatch(s.Endpoint); m != nil {
+		endpointRegion = m[1]
+	}
+
+	switch {
+	case s.Region == "" && endpointRegion != "":
+		s.Region = endpointRegion
+	case s.Region == "":
+		s.Region = DefaultBeskarYumS3Region
+	case endpointRegion != "" && endpointRegion != s.Region:
+		return fmt.Errorf(
+			"storage s3 endpoint %s is in region %s but region is %s, remove the endpoint to resolve it from the region",
+			s.Endpoint, endpointRegion, s.Region,
+		)
+	}
+
+	return validateS3ObjectTags(s.ObjectTags)
+}
+
+const (
+	maxS3ObjectTags        = 10
+	maxS3ObjectTagKeyLen   = 128
+	maxS3ObjectTagValueLen = 256
+)
+
+// s3ObjectTagRegexp matches the characters allowed in S3 tag keys and values.
+var s3ObjectTagRegexp = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// validateS3ObjectTags validates the object tags against the S3 limits.
+func validateS3ObjectTags(tags map[string]string) error {
+	if len(tags) > maxS3ObjectTags {
+		return fmt.Errorf("storage s3 object-tags has %d tags, S3 allows at most %d tags", len(tags), maxS3ObjectTags)
+	}
+
+	for key, value := range tags {
+		switch {
+		case key == "" || utf8.RuneCountInString(key) > maxS3ObjectTagKeyLen:
+			return fmt.Errorf("storage s3 object tag key %q must be between 1 and %d characters", key, maxS3ObjectTagKeyLen)
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			return fmt.Errorf("storage s3 object tag key %q must not use the reserved aws: prefix", key)
+		case !s3ObjectTagRegexp.MatchString(key):
+			return fmt.Errorf("storage s3 object tag key %q contains invalid characters", key)
+		case utf8.RuneCountInString(value) > maxS3ObjectTagValueLen:
+			return fmt.Errorf("storage s3 object tag %q value must be at most %d characters", key, maxS3ObjectTagValueLen)
+		case !s3ObjectTagRegexp.MatchString(value):
+			return fmt.Errorf("storage s3 object tag %q value %q contains invalid characters", key, value)
+		}
+	}
+
+	return nil
 }
 
 type BeskarYumFSStorage struct {
-	Directory string `yaml:"directory"`
+	Directory string                `yaml:"directory"`
+	Retry     BeskarYumStorageRetry `yaml:"retry"`
 }
 
 type BeskarYumGCSStorage struct {
-	Bucket  string `yaml:"bucket"`
-	Keyfile string `yaml:"keyfile"`
+	Bucket  string                `yaml:"bucket"`
+	Keyfile string                `yaml:"keyfile"`
+	Retry   BeskarYumStorageRetry `yaml:"retry"`
 }
 
 type BeskarYumAzureStorage struct {
-	Container   string `yaml:"container"`
-	AccountName string `yaml:"account-name"`
-	AccountKey  string `yaml:"account-key"`
+	Container   string                `yaml:"container"`
+	AccountName string                `yaml:"account-name"`
+	AccountKey  string                `yaml:"account-key"`
+	Retry       BeskarYumStorageRetry `yaml:"retry"`
+}
+
+// BeskarYumEncryptionKey defines an AES key identified by its ID, the
+// base64 encoded key is either read from a file or from an environment
+// variable.
+type BeskarYumEncryptionKey struct {
+	ID      string `yaml:"id"`
+	KeyFile string `yaml:"key-file"`
+	KeyEnv  string `yaml:"key-env"`
+}
+
+// BeskarYumEncryption defines the keys used to encrypt objects before
+// they are written to the storage. Objects are encrypted with the key
+// referenced by KeyID, the other keys are kept to read objects encrypted
+// before a key rotation. In envelope mode, the key referenced by KeyID is
+// the master key wrapping the data key of each object. Streamed objects are
+// encrypted in chunks so their size isn't bounded by the memory.
+type BeskarYumEncryption struct {
+	Enabled bool                     `yaml:"enabled"`
+	Mode    string                   `yaml:"mode"`
+	KeyID   string                   `yaml:"key-id"`
+	Keys    []BeskarYumEncryptionKey `yaml:"keys"`
 }
 
 type BeskarYumStorage struct {
 	Driver     string                `yaml:"driver"`
 	Prefix     string                `yaml:"prefix"`
+	Encryption BeskarYumEncryption   `yaml:"encryption"`
 	S3         BeskarYumS3Storage    `yaml:"s3"`
 	Filesystem BeskarYumFSStorage    `yaml:"filesystem"`
 	GCS        BeskarYumGCSStorage   `yaml:"gcs"`
 	Azure      BeskarYumAzureStorage `yaml:"azure"`
 }
 
+// DriverRetry returns the retry settings of the configured driver.
+func (s BeskarYumStorage) DriverRetry() BeskarYumStorageRetry {
+	switch s.Driver {
+	case S3StorageDriver:
+		return s.S3.Retry
+	case FSStorageDriver:
+		return s.Filesystem.Retry
+	case GCSStorageDriver:
+		return s.GCS.Retry
+	case AzureStorageDriver:
+		return s.Azure.Retry
+	}
+	return BeskarYumStorageRetry{}
+}
+
+// DriverParameters returns the parameters honored by the configured driver
+// as they are used to open the storage, secrets are redacted so they can be
+// logged. Parameters of other drivers are ignored.
+func (s BeskarYumStorage) DriverParameters() map[string]interface{} {
+	params := map[string]interface{}{
+		"driver":     s.Driver,
+		"prefix":     s.Prefix,
+		"encryption": s.Encryption.Enabled,
+	}
+
+	if s.Encryption.Enabled {
+		params["encryption-mode"] = s.Encryption.Mode
+		params["encryption-key-id"] = s.Encryption.KeyID
+	}
+
+	switch s.Driver {
+	case S3StorageDriver:
+		params["endpoint"] = s.S3.Endpoint
+		params["bucket"] = s.S3.Bucket
+		params["access-key-id"] = s.S3.AccessKeyID
+		params["secret-access-key"] = redact(s.S3.SecretAccessKey)
+		params["session-token"] = redact(s.S3.SessionToken)
+		params["region"] = s.S3.Region
+		params["disable-ssl"] = s.S3.DisableSSL
+	case FSStorageDriver:
+		params["directory"] = s.Filesystem.Directory
+	case GCSStorageDriver:
+		params["bucket"] = s.GCS.Bucket
+		params["keyfile"] = s.GCS.Keyfile
+	case AzureStorageDriver:
+		params["account-name"] = s.Azure.AccountName
+		params["account-key"] = redact(s.Azure.AccountKey)
+	default:
+		return params
+	}
+
+	retry := s.DriverRetry()
+	params["retry-max-retries"] = retry.MaxRetries
+	params["retry-base-delay"] = retry.BaseDelay
+
+	return params
+}
+
+// redact hides a secret value, an empty value is kept to show that
+// the secret is not set.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+func (r *BeskarYumStorageRetry) setDefaults(driver string) error {
+	if r.MaxRetries < 0 {
+		return fmt.Errorf("storage %s retry max-retries must be positive", driver)
+	} else if r.BaseDelay < 0 {
+		return fmt.Errorf("storage %s retry base-delay must be positive", driver)
+	} else if r.BaseDelay == 0 {
+		r.BaseDelay = DefaultBeskarYumStorageRetryBaseDelay
+	}
+	return nil
+}
+
+// BeskarYumGPG defines the GPG key used to sign repository metadata,
+// the armored private key is either read from a file or from an
+// environment variable.
+type BeskarYumGPG struct {
+	KeyFile       string `yaml:"key-file"`
+	KeyEnv        string `yaml:"key-env"`
+	KeyID         string `yaml:"key-id"`
+	PassphraseEnv string `yaml:"passphrase-env"`
+}
+
+func (g BeskarYumGPG) Enabled() bool {
+	return g.KeyFile != "" || g.KeyEnv != ""
+}
+
+// BeskarYumRepository defines a repository created at startup if it
+// doesn't exist, distro tags are added to the repository metadata.
+// Compression overrides the metadata compression of the repository.
+type BeskarYumRepository struct {
+	Name        string   `yaml:"name"`
+	Distro      []string `yaml:"distro"`
+	Compression string   `yaml:"compression"`
+}
+
 type BeskarYumConfig struct {
-	Version         string            `yaml:"version"`
-	Addr            string            `yaml:"addr"`
-	Registry        BeskarYumRegistry `yaml:"registry"`
-	Storage         BeskarYumStorage  `yaml:"storage"`
-	Profiling       bool              `yaml:"profiling"`
-	DataDir         string            `yaml:"datadir"`
-	ConfigDirectory string            `yaml:"-"`
+	Version         string                `yaml:"version"`
+	Addr            string                `yaml:"addr"`
+	Server          Server                `yaml:"server"`
+	Registry        BeskarYumRegistry     `yaml:"registry"`
+	Storage         BeskarYumStorage      `yaml:"storage"`
+	GPG             BeskarYumGPG          `yaml:"gpg"`
+	Profiling       bool                  `yaml:"profiling"`
+	AccessLog       AccessLog             `yaml:"access-log"`
+	Metrics         Metrics               `yaml:"metrics"`
+	DataDir         string                `yaml:"datadir"`
+	StagingDir      string                `yaml:"staging-dir"`
+	Metadata        BeskarYumMetadata     `yaml:"metadata"`
+	Bootstrap       []BeskarYumRepository `yaml:"bootstrap"`
+	Uploads         BeskarYumUploads      `yaml:"uploads"`
+	GC              BeskarYumGC           `yaml:"gc"`
+	Stats           BeskarYumStats        `yaml:"stats"`
+	ConfigDirectory string                `yaml:"-"`
+}
+
+// BeskarYumUploads limits the packages of a repository queued or being
+// processed, uploads exceeding the limit are rejected with a 429 response
+// and a Retry-After header, either immediately or after waiting for a slot
+// up to the queue timeout. Uploaded packages with a malformed lead or
+// header are rejected with a 400 response, StrictValidation also rejects
+// the odd packages accepted by rpm: packages with a lead other than 3.0,
+// without a signature digest or without the os and payloadformat tags.
+// Resumable uploads which haven't received any chunk during ResumableTTL
+// are garbage collected.
+type BeskarYumUploads struct {
+	// MaxConcurrent is the maximum number of in-flight uploads
+	// per repository, zero means no limit.
+	MaxConcurrent    int           `yaml:"max-concurrent"`
+	Overflow         string        `yaml:"overflow"`
+	QueueTimeout     time.Duration `yaml:"queue-timeout"`
+	RetryAfter       time.Duration `yaml:"retry-after"`
+	StrictValidation bool          `yaml:"strict-validation"`
+	ResumableTTL     time.Duration `yaml:"resumable-ttl"`
+}
+
+func (u *BeskarYumUploads) setDefaults() error {
+	if u.MaxConcurrent < 0 {
+		return fmt.Errorf("uploads max-concurrent must be positive")
+	}
+
+	switch u.Overflow {
+	case "":
+		u.Overflow = UploadsOverflowReject
+	case UploadsOverflowReject, UploadsOverflowQueue:
+	default:
+		return fmt.Errorf("uploads overflow must be either %q or %q", UploadsOverflowReject, UploadsOverflowQueue)
+	}
+
+	if u.QueueTimeout == 0 {
+		u.QueueTimeout = DefaultBeskarYumUploadsQueueTimeout
+	} else if u.QueueTimeout < 0 {
+		return fmt.Errorf("uploads queue-timeout must be positive")
+	}
+
+	if u.RetryAfter == 0 {
+		u.RetryAfter = DefaultBeskarYumUploadsRetryAfter
+	} else if u.RetryAfter < 0 {
+		return fmt.Errorf("uploads retry-after must be positive")
+	}
+
+	if u.ResumableTTL == 0 {
+		u.ResumableTTL = DefaultBeskarYumUploadsResumableTTL
+	} else if u.ResumableTTL < 0 {
+		return fmt.Errorf("uploads resumable-ttl must be positive")
+	}
+
+	return nil
+}
+
+// BeskarYumGC defines the scheduled garbage collection of the package
+// manifests which are not referenced by their repository database, the
+// collection is disabled when Interval is 0. In dry-run mode unreferenced
+// packages are only reported. Unreferenced manifests are only deleted once
+// they have been seen unreferenced by collections spanning GracePeriod, the
+// manifests of packages being uploaded are stored before being processed.
+type BeskarYumGC struct {
+	Interval    time.Duration `yaml:"interval"`
+	DryRun      bool          `yaml:"dry-run"`
+	GracePeriod time.Duration `yaml:"grace-period"`
+}
+
+func (gc *BeskarYumGC) setDefaults() error {
+	if gc.Interval < 0 {
+		return fmt.Errorf("gc interval must be positive")
+	}
+	if gc.GracePeriod == 0 {
+		gc.GracePeriod = DefaultBeskarYumGCGracePeriod
+	} else if gc.GracePeriod < 0 {
+		return fmt.Errorf("gc grace-period must be positive")
+	}
+	return nil
+}
+
+// BeskarYumStats defines the periodic collection of the repository
+// statistics exported as metrics, the collection is disabled when Interval
+// is 0. Only the MaxRepositories largest repositories have their own
+// series, the statistics of the other repositories are aggregated.
+type BeskarYumStats struct {
+	Interval        time.Duration `yaml:"interval"`
+	MaxRepositories int           `yaml:"max-repositories"`
+}
+
+func (s *BeskarYumStats) setDefaults() error {
+	if s.Interval < 0 {
+		return fmt.Errorf("stats interval must be positive")
+	}
+
+	if s.MaxRepositories < 0 {
+		return fmt.Errorf("stats max-repositories must be positive")
+	} else if s.MaxRepositories == 0 {
+		s.MaxRepositories = DefaultBeskarYumStatsMaxRepositories
+	}
+
+	return nil
+}
+
+// BeskarYumMetadata defines the generated repository metadata, the XML
+// files are compressed with either gzip, xz or zstd.
+type BeskarYumMetadata struct {
+	Compression string `yaml:"compression"`
+}
+
+func (m *BeskarYumMetadata) setDefaults() error {
+	if m.Compression == "" {
+		m.Compression = DefaultMetadataCompression
+	}
+	return validateMetadataCompression(m.Compression)
+}
+
+// validateMetadataCompression checks that the metadata codec is supported
+// and warns about the clients unable to read zstd compressed metadata.
+func validateMetadataCompression(compression string) error {
+	switch compression {
+	case MetadataCompressionGzip, MetadataCompressionXZ:
+	case MetadataCompressionZstd:
+		logrus.Warnf("zstd compressed metadata can't be read by yum on EL7 and EL8, use gzip or xz for these clients")
+	default:
+		return fmt.Errorf(
+			"metadata compression must be either %q, %q or %q",
+			MetadataCompressionGzip, MetadataCompressionXZ, MetadataCompressionZstd,
+		)
+	}
+	return nil
+}
+
+// MetadataCompression returns the metadata compression of the
+// repository, the bootstrap definition takes precedence.
+func (bc BeskarYumConfig) MetadataCompression(name string) string {
+	if repo, ok := bc.BootstrapRepository(name); ok && repo.Compression != "" {
+		return repo.Compression
+	}
+	return bc.Metadata.Compression
+}
+
+// BootstrapRepository returns the bootstrap definition of the
+// repository if any.
+func (bc BeskarYumConfig) BootstrapRepository(name string) (BeskarYumRepository, bool) {
+	for _, repo := range bc.Bootstrap {
+		if repo.Name == name {
+			return repo, true
+		}
+	}
+	return BeskarYumRepository{}, false
 }
 
 func (bc BeskarYumConfig) ListenIP() (string, error) {
@@ -99,6 +553,61 @@ func (bc BeskarYumConfig) ListenPort() (string, error) {
 
 type BeskarYumConfigV1 BeskarYumConfig
 
+// ValidateStoragePrefix checks that the storage prefix template only
+// references known variables and doesn't escape the bucket root.
+func ValidateStoragePrefix(template string) error {
+	stripped := strings.NewReplacer(StoragePrefixPluginVar, "", StoragePrefixRepoVar, "").Replace(template)
+	if strings.ContainsAny(stripped, "{}") {
+		return fmt.Errorf(
+			"storage prefix %q is malformed: only %s and %s variables are supported",
+			template, StoragePrefixPluginVar, StoragePrefixRepoVar,
+		)
+	}
+	for _, segment := range strings.Split(template, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("storage prefix %q must not contain relative path segments", template)
+		}
+	}
+	return nil
+}
+
+func validateEncryption(encryption *BeskarYumEncryption) error {
+	if !encryption.Enabled {
+		return nil
+	}
+
+	switch encryption.Mode {
+	case "":
+		encryption.Mode = EncryptionModeDirect
+	case EncryptionModeDirect, EncryptionModeEnvelope:
+	default:
+		return fmt.Errorf("unknown storage encryption mode %s", encryption.Mode)
+	}
+
+	activeKey := false
+	keyIDs := make(map[string]struct{}, len(encryption.Keys))
+
+	for _, key := range encryption.Keys {
+		if key.ID == "" {
+			return fmt.Errorf("storage encryption key id is missing")
+		} else if len(key.ID) > 255 {
+			return fmt.Errorf("storage encryption key id %s exceeds 255 characters", key.ID)
+		} else if _, ok := keyIDs[key.ID]; ok {
+			return fmt.Errorf("storage encryption key %s is declared more than once", key.ID)
+		} else if (key.KeyFile == "") == (key.KeyEnv == "") {
+			return fmt.Errorf("storage encryption key %s requires either a key file or a key environment variable", key.ID)
+		}
+		keyIDs[key.ID] = struct{}{}
+		activeKey = activeKey || key.ID == encryption.KeyID
+	}
+
+	if !activeKey {
+		return fmt.Errorf("storage encryption key-id %q doesn't reference a declared key", encryption.KeyID)
+	}
+
+	return nil
+}
+
 func ParseBeskarYumConfig(dir string) (*BeskarYumConfig, error) {
 	customDir := false
 	filename := filepath.Join(DefaultConfigDir, BeskarYumConfigFile)
@@ -135,6 +644,125 @@ func ParseBeskarYumConfig(dir string) (*BeskarYumConfig, error) {
 			ConversionFunc: func(c interface{}) (interface{}, error) {
 				if v1, ok := c.(*BeskarYumConfigV1); ok {
 					v1.ConfigDirectory = configDir
+
+					if err := resolveSecrets(map[string]*string{
+						"registry.password":            &v1.Registry.Password,
+						"storage.s3.secret-access-key": &v1.Storage.S3.SecretAccessKey,
+						"storage.s3.session-token":     &v1.Storage.S3.SessionToken,
+						"storage.azure.account-key":    &v1.Storage.Azure.AccountKey,
+					}); err != nil {
+						return nil, err
+					}
+
+					if v1.Registry.Retries < 0 {
+						return nil, fmt.Errorf("registry retries must be positive")
+					} else if v1.Registry.Retries > 0 && v1.Registry.RetryBackoff <= 0 {
+						v1.Registry.RetryBackoff = DefaultBeskarYumRegistryRetryBackoff
+					}
+
+					if !StorageDriverEnabled(v1.Storage.Driver) {
+						return nil, fmt.Errorf(
+							"storage driver %q is not enabled in this build, enabled drivers: %s",
+							v1.Storage.Driver, strings.Join(StorageDrivers(), ", "),
+						)
+					}
+
+					if err := ValidateStoragePrefix(v1.Storage.Prefix); err != nil {
+						return nil, err
+					}
+					// plugins may share the same bucket, without the plugin
+					// name a templated prefix could collide with other plugins
+					prefix := strings.Trim(v1.Storage.Prefix, "/")
+					if strings.Contains(prefix, StoragePrefixRepoVar) && !strings.Contains(prefix, StoragePrefixPluginVar) {
+						return nil, fmt.Errorf(
+							"storage prefix %q references %s and must also reference %s",
+							v1.Storage.Prefix, StoragePrefixRepoVar, StoragePrefixPluginVar,
+						)
+					}
+
+					if err := v1.Server.Timeouts.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := v1.AccessLog.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := v1.Metrics.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					for driver, retry := range map[string]*BeskarYumStorageRetry{
+						S3StorageDriver:    &v1.Storage.S3.Retry,
+						FSStorageDriver:    &v1.Storage.Filesystem.Retry,
+						GCSStorageDriver:   &v1.Storage.GCS.Retry,
+						AzureStorageDriver: &v1.Storage.Azure.Retry,
+					} {
+						if err := retry.setDefaults(driver); err != nil {
+							return nil, err
+						}
+					}
+
+					if err := v1.Storage.S3.MultipartCleanup.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if v1.Storage.Driver == S3StorageDriver {
+						if err := v1.Storage.S3.setDefaults(); err != nil {
+							return nil, err
+						}
+					}
+
+					// uploaded packages are staged in the data directory by default
+					if v1.DataDir == "" {
+						v1.DataDir = DefaultBeskarYumDataDir
+					}
+					if v1.StagingDir == "" {
+						v1.StagingDir = v1.DataDir
+					}
+
+					if err := validateEncryption(&v1.Storage.Encryption); err != nil {
+						return nil, err
+					}
+
+					if err := v1.Uploads.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := v1.GC.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := v1.Metadata.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := v1.Stats.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					bootstrapped := make(map[string]struct{}, len(v1.Bootstrap))
+					for i, repo := range v1.Bootstrap {
+						repo.Name = strings.Trim(repo.Name, "/")
+						if repo.Name == "" {
+							return nil, fmt.Errorf("bootstrap repository name is missing")
+						} else if _, ok := bootstrapped[repo.Name]; ok {
+							return nil, fmt.Errorf("bootstrap repository %s is declared more than once", repo.Name)
+						}
+						for _, segment := range strings.Split(repo.Name, "/") {
+							if segment == "." || segment == ".." {
+								return nil, fmt.Errorf("bootstrap repository %s must not contain relative path segments", repo.Name)
+							}
+						}
+						if repo.Compression != "" {
+							if err := validateMetadataCompression(repo.Compression); err != nil {
+								return nil, fmt.Errorf("bootstrap repository %s: %w", repo.Name, err)
+							}
+						}
+						bootstrapped[repo.Name] = struct{}{}
+						v1.Bootstrap[i] = repo
+					}
+
 					return (*BeskarYumConfig)(v1), nil
 				}
 				return nil, fmt.Errorf("expected *BeskarConfigV1, received %#v", c)
@@ -4,29 +4,76 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
+// skipWithoutFilesystemDriver skips the tests parsing the default configuration,
+// it uses the filesystem storage driver which can be excluded from the build.
+func skipWithoutFilesystemDriver(t *testing.T) {
+	t.Helper()
+
+	if !StorageDriverEnabled(FSStorageDriver) {
+		t.Skip("filesystem storage driver is excluded from this build")
+	}
+}
+
 func TestParseBeskarYumConfig(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
 	bc, err := ParseBeskarYumConfig("")
 	require.NoError(t, err)
 
 	require.Equal(t, "1.0", bc.Version)
 
+	require.Equal(t, ServerTimeouts{
+		ReadHeader: DefaultServerReadHeaderTimeout,
+		Read:       DefaultServerReadTimeout,
+		Write:      DefaultServerWriteTimeout,
+		Idle:       DefaultServerIdleTimeout,
+	}, bc.Server.Timeouts)
+
 	require.Equal(t, "127.0.0.1:5200", bc.Addr)
 
 	require.Equal(t, true, bc.Profiling)
 
+	require.Equal(t, false, bc.AccessLog.Enabled)
+
 	require.Equal(t, "/tmp/beskar-yum", bc.DataDir)
+	require.Equal(t, "/tmp/beskar-yum", bc.StagingDir)
+
+	require.Empty(t, bc.Bootstrap)
+
+	require.Equal(t, BeskarYumUploads{
+		Overflow:     UploadsOverflowReject,
+		QueueTimeout: DefaultBeskarYumUploadsQueueTimeout,
+		RetryAfter:   DefaultBeskarYumUploadsRetryAfter,
+		ResumableTTL: DefaultBeskarYumUploadsResumableTTL,
+	}, bc.Uploads)
+
+	require.Equal(t, BeskarYumGC{GracePeriod: DefaultBeskarYumGCGracePeriod}, bc.GC)
+	require.Equal(t, BeskarYumStats{MaxRepositories: DefaultBeskarYumStatsMaxRepositories}, bc.Stats)
 
 	require.Equal(t, "http://127.0.0.1:5100", bc.Registry.URL)
 	require.Equal(t, "beskar", bc.Registry.Username)
 	require.Equal(t, "beskar", bc.Registry.Password)
+	require.Equal(t, 3, bc.Registry.Retries)
+	require.Equal(t, DefaultBeskarYumRegistryRetryBackoff, bc.Registry.RetryBackoff)
+
+	require.Equal(t, false, bc.GPG.Enabled())
+	require.Equal(t, "", bc.GPG.KeyID)
 
 	require.Equal(t, "filesystem", bc.Storage.Driver)
 	require.Equal(t, "", bc.Storage.Prefix)
+	require.Equal(t, false, bc.Storage.Encryption.Enabled)
+	require.Equal(t, MetricsBackendPrometheus, bc.Metrics.Backend)
+	require.Equal(t, DefaultMetricsExportInterval, bc.Metrics.Exporter.Interval)
+	require.Equal(t, EncryptionModeDirect, bc.Storage.Encryption.Mode)
 
 	require.Equal(t, "127.0.0.1:9100", bc.Storage.S3.Endpoint)
 	require.Equal(t, "beskar-yum", bc.Storage.S3.Bucket)
@@ -35,8 +82,14 @@ func TestParseBeskarYumConfig(t *testing.T) {
 	require.Equal(t, "", bc.Storage.S3.SessionToken)
 	require.Equal(t, "us-east-1", bc.Storage.S3.Region)
 	require.Equal(t, true, bc.Storage.S3.DisableSSL)
+	require.Equal(t, BeskarYumStorageRetry{MaxRetries: 3, BaseDelay: 100 * time.Millisecond}, bc.Storage.S3.Retry)
+	require.Equal(t, BeskarYumS3MultipartCleanup{
+		Interval: DefaultBeskarYumS3MultipartCleanupInterval,
+		MaxAge:   DefaultBeskarYumS3MultipartCleanupMaxAge,
+	}, bc.Storage.S3.MultipartCleanup)
 
 	require.Equal(t, "/tmp/beskar-yum", bc.Storage.Filesystem.Directory)
+	require.Equal(t, BeskarYumStorageRetry{BaseDelay: DefaultBeskarYumStorageRetryBaseDelay}, bc.Storage.DriverRetry())
 
 	require.Equal(t, "beskar-yum", bc.Storage.GCS.Bucket)
 	require.Equal(t, "/path/to/keyfile", bc.Storage.GCS.Keyfile)
@@ -45,3 +98,640 @@ func TestParseBeskarYumConfig(t *testing.T) {
 	require.Equal(t, "account_name", bc.Storage.Azure.AccountName)
 	require.Equal(t, "base64_encoded_account_key", bc.Storage.Azure.AccountKey)
 }
+
+func TestValidateStoragePrefix(t *testing.T) {
+	for _, prefix := range []string{"", "/", "beskar", "{plugin}/{repo}", "/data/{plugin}-{repo}/"} {
+		require.NoError(t, ValidateStoragePrefix(prefix), "prefix %q", prefix)
+	}
+	for _, prefix := range []string{"{repository}", "{plugin", "data/}", "../{plugin}", "./{repo}"} {
+		require.Error(t, ValidateStoragePrefix(prefix), "prefix %q", prefix)
+	}
+}
+
+func TestBeskarYumStorageDriverParameters(t *testing.T) {
+	retry := BeskarYumStorageRetry{MaxRetries: 3, BaseDelay: time.Second}
+
+	storage := BeskarYumStorage{
+		Prefix: "{plugin}",
+		S3: BeskarYumS3Storage{
+			Endpoint:        "127.0.0.1:9100",
+			Bucket:          "s3-bucket",
+			AccessKeyID:     "access",
+			SecretAccessKey: "secret",
+			Region:          "us-east-1",
+			Retry:           retry,
+		},
+		Filesystem: BeskarYumFSStorage{
+			Directory: "/tmp/beskar-yum",
+		},
+		GCS: BeskarYumGCSStorage{
+			Bucket:  "gcs-bucket",
+			Keyfile: "/path/to/keyfile",
+		},
+		Azure: BeskarYumAzureStorage{
+			AccountName: "account",
+			AccountKey:  "secret",
+		},
+	}
+
+	tests := []struct {
+		driver string
+		want   map[string]interface{}
+	}{
+		{
+			driver: S3StorageDriver,
+			want: map[string]interface{}{
+				"endpoint":          "127.0.0.1:9100",
+				"bucket":            "s3-bucket",
+				"access-key-id":     "access",
+				"secret-access-key": "<redacted>",
+				"session-token":     "",
+				"region":            "us-east-1",
+				"disable-ssl":       false,
+				"retry-max-retries": 3,
+				"retry-base-delay":  time.Second,
+			},
+		},
+		{
+			driver: FSStorageDriver,
+			want: map[string]interface{}{
+				"directory":         "/tmp/beskar-yum",
+				"retry-max-retries": 0,
+				"retry-base-delay":  time.Duration(0),
+			},
+		},
+		{
+			driver: GCSStorageDriver,
+			want: map[string]interface{}{
+				"bucket":            "gcs-bucket",
+				"keyfile":           "/path/to/keyfile",
+				"retry-max-retries": 0,
+				"retry-base-delay":  time.Duration(0),
+			},
+		},
+		{
+			driver: AzureStorageDriver,
+			want: map[string]interface{}{
+				"account-name":      "account",
+				"account-key":       "<redacted>",
+				"retry-max-retries": 0,
+				"retry-base-delay":  time.Duration(0),
+			},
+		},
+		{
+			driver: InMemoryStorageDriver,
+			want:   map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			storage.Driver = tt.driver
+
+			want := map[string]interface{}{
+				"driver":     tt.driver,
+				"prefix":     "{plugin}",
+				"encryption": false,
+			}
+			for k, v := range tt.want {
+				want[k] = v
+			}
+
+			require.Equal(t, want, storage.DriverParameters())
+		})
+	}
+
+	storage.Driver = FSStorageDriver
+	storage.Encryption = BeskarYumEncryption{
+		Enabled: true,
+		Mode:    EncryptionModeEnvelope,
+		KeyID:   "key1",
+	}
+
+	params := storage.DriverParameters()
+	require.Equal(t, true, params["encryption"])
+	require.Equal(t, EncryptionModeEnvelope, params["encryption-mode"])
+	require.Equal(t, "key1", params["encryption-key-id"])
+}
+
+func TestParseBeskarYumConfigAccessLog(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	tests := []struct {
+		name      string
+		accessLog string
+		want      AccessLog
+		wantErr   string
+	}{
+		{
+			name:      "defaults",
+			accessLog: "access-log:\n  enabled: true\n",
+			want: AccessLog{
+				Enabled:    true,
+				Output:     AccessLogOutputStdout,
+				Format:     AccessLogFormatJSON,
+				Fields:     AccessLogFields,
+				SampleRate: 1,
+			},
+		},
+		{
+			name:      "custom",
+			accessLog: "access-log:\n  enabled: true\n  output: /var/log/access.log\n  format: text\n  fields: [repository, status]\n  sample-rate: 0.1\n",
+			want: AccessLog{
+				Enabled:    true,
+				Output:     "/var/log/access.log",
+				Format:     AccessLogFormatText,
+				Fields:     []string{AccessLogFieldRepository, AccessLogFieldStatus},
+				SampleRate: 0.1,
+			},
+		},
+		{
+			name:      "unknown format",
+			accessLog: "access-log:\n  enabled: true\n  format: xml\n",
+			wantErr:   "access-log format must be either",
+		},
+		{
+			name:      "unknown field",
+			accessLog: "access-log:\n  enabled: true\n  fields: [user-agent]\n",
+			wantErr:   "access-log field \"user-agent\" is unknown",
+		},
+		{
+			name:      "bad sample rate",
+			accessLog: "access-log:\n  enabled: true\n  sample-rate: 2\n",
+			wantErr:   "access-log sample-rate must be between 0 and 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			i := strings.Index(defaultBeskarYumConfig, "access-log:")
+			j := strings.Index(defaultBeskarYumConfig, "datadir:")
+			require.True(t, i > 0 && j > i)
+
+			config := defaultBeskarYumConfig[:i] + defaultBeskarYumConfig[j:] + "\n" + tt.accessLog
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.AccessLog)
+		})
+	}
+}
+
+func TestParseBeskarYumConfigBootstrap(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	tests := []struct {
+		name      string
+		bootstrap string
+		want      []BeskarYumRepository
+		wantErr   string
+	}{
+		{
+			name:      "repositories",
+			bootstrap: "bootstrap:\n- name: /rocky/9/\n  distro: [\"Rocky Linux 9\"]\n- name: epel\n",
+			want: []BeskarYumRepository{
+				{Name: "rocky/9", Distro: []string{"Rocky Linux 9"}},
+				{Name: "epel"},
+			},
+		},
+		{
+			name:      "missing name",
+			bootstrap: "bootstrap:\n- distro: [\"Rocky Linux 9\"]\n",
+			wantErr:   "bootstrap repository name is missing",
+		},
+		{
+			name:      "duplicate name",
+			bootstrap: "bootstrap:\n- name: epel\n- name: epel/\n",
+			wantErr:   "declared more than once",
+		},
+		{
+			name:      "relative name",
+			bootstrap: "bootstrap:\n- name: ../epel\n",
+			wantErr:   "relative path segments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarYumConfig, "bootstrap: []\n", tt.bootstrap, 1)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.Bootstrap)
+
+			repo, ok := bc.BootstrapRepository("rocky/9")
+			require.True(t, ok)
+			require.Equal(t, []string{"Rocky Linux 9"}, repo.Distro)
+		})
+	}
+}
+
+func TestParseBeskarYumConfigStorageDriver(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	require.Equal(t, []string{
+		AzureStorageDriver, FSStorageDriver, GCSStorageDriver, InMemoryStorageDriver, S3StorageDriver,
+	}, StorageDrivers())
+
+	dir := t.TempDir()
+
+	config := strings.Replace(defaultBeskarYumConfig, "driver: filesystem", "driver: ftp", 1)
+	err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+	require.NoError(t, err)
+
+	_, err = ParseBeskarYumConfig(dir)
+	require.ErrorContains(t, err, "storage driver \"ftp\" is not enabled in this build")
+}
+
+func TestParseBeskarYumConfigS3EndpointRegion(t *testing.T) {
+	tests := []struct {
+		name         string
+		endpoint     string
+		region       string
+		wantEndpoint string
+		wantRegion   string
+		wantErr      string
+	}{
+		{
+			name:       "aws region only",
+			region:     "eu-west-1",
+			wantRegion: "eu-west-1",
+		},
+		{
+			name:         "custom endpoint only",
+			endpoint:     "minio:9000",
+			wantEndpoint: "minio:9000",
+			wantRegion:   DefaultBeskarYumS3Region,
+		},
+		{
+			name:         "aws endpoint only",
+			endpoint:     "https://s3.eu-west-1.amazonaws.com",
+			wantEndpoint: "https://s3.eu-west-1.amazonaws.com",
+			wantRegion:   "eu-west-1",
+		},
+		{
+			name:         "aws endpoint and region",
+			endpoint:     "s3.us-gov-west-1.amazonaws.com",
+			region:       "us-gov-west-1",
+			wantEndpoint: "s3.us-gov-west-1.amazonaws.com",
+			wantRegion:   "us-gov-west-1",
+		},
+		{
+			name:     "aws endpoint region mismatch",
+			endpoint: "s3.eu-west-1.amazonaws.com",
+			region:   "us-east-1",
+			wantErr:  "storage s3 endpoint s3.eu-west-1.amazonaws.com is in region eu-west-1 but region is us-east-1",
+		},
+		{
+			name:    "no endpoint nor region",
+			wantErr: "storage s3 requires a region for AWS or an endpoint for S3 compatible servers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarYumConfig, "driver: filesystem", "driver: s3", 1)
+			config = strings.Replace(config, "endpoint: 127.0.0.1:9100", "endpoint: "+tt.endpoint, 1)
+			config = strings.Replace(config, "region: us-east-1", "region: "+tt.region, 1)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantEndpoint, bc.Storage.S3.Endpoint)
+			require.Equal(t, tt.wantRegion, bc.Storage.S3.Region)
+		})
+	}
+}
+
+func TestParseBeskarYumConfigS3ObjectTags(t *testing.T) {
+	tests := []struct {
+		name       string
+		objectTags string
+		wantTags   map[string]string
+		wantErr    string
+	}{
+		{
+			name:       "no tags",
+			objectTags: "{}",
+			wantTags:   map[string]string{},
+		},
+		{
+			name:       "lifecycle tags",
+			objectTags: "{expiry: 30d, team: beskar-ops}",
+			wantTags:   map[string]string{"expiry": "30d", "team": "beskar-ops"},
+		},
+		{
+			name:       "reserved prefix",
+			objectTags: "{aws:expiry: 30d}",
+			wantErr:    `storage s3 object tag key "aws:expiry" must not use the reserved aws: prefix`,
+		},
+		{
+			name:       "invalid value",
+			objectTags: `{expiry: "30d&drop"}`,
+			wantErr:    `storage s3 object tag "expiry" value "30d&drop" contains invalid characters`,
+		},
+		{
+			name:       "value too long",
+			objectTags: "{expiry: " + strings.Repeat("d", 257) + "}",
+			wantErr:    `storage s3 object tag "expiry" value must be at most 256 characters`,
+		},
+		{
+			name:       "too many tags",
+			objectTags: "{a: 1, b: 2, c: 3, d: 4, e: 5, f: 6, g: 7, h: 8, i: 9, j: 10, k: 11}",
+			wantErr:    "storage s3 object-tags has 11 tags, S3 allows at most 10 tags",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarYumConfig, "driver: filesystem", "driver: s3", 1)
+			config = strings.Replace(config, "object-tags: {}", "object-tags: "+tt.objectTags, 1)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantTags, bc.Storage.S3.ObjectTags)
+		})
+	}
+}
+
+func TestParseBeskarYumConfigUploads(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	tests := []struct {
+		name          string
+		maxConcurrent string
+		overflow      string
+		resumableTTL  string
+		wantErr       string
+	}{
+		{
+			name:          "queue",
+			maxConcurrent: "4",
+			overflow:      UploadsOverflowQueue,
+		},
+		{
+			name:          "negative limit",
+			maxConcurrent: "-1",
+			overflow:      UploadsOverflowReject,
+			wantErr:       "uploads max-concurrent must be positive",
+		},
+		{
+			name:          "unknown overflow",
+			maxConcurrent: "4",
+			overflow:      "drop",
+			wantErr:       `uploads overflow must be either "reject" or "queue"`,
+		},
+		{
+			name:          "negative resumable ttl",
+			maxConcurrent: "4",
+			overflow:      UploadsOverflowReject,
+			resumableTTL:  "-1h",
+			wantErr:       "uploads resumable-ttl must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			resumableTTL := tt.resumableTTL
+			if resumableTTL == "" {
+				resumableTTL = "12h"
+			}
+
+			config := strings.NewReplacer(
+				"max-concurrent: 0", "max-concurrent: "+tt.maxConcurrent,
+				"overflow: reject", "overflow: "+tt.overflow,
+				"resumable-ttl: 24h", "resumable-ttl: "+resumableTTL,
+			).Replace(defaultBeskarYumConfig)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.overflow, bc.Uploads.Overflow)
+			require.Equal(t, 12*time.Hour, bc.Uploads.ResumableTTL)
+		})
+	}
+}
+
+func TestParseBeskarYumConfigGC(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	tests := []struct {
+		name        string
+		interval    string
+		gracePeriod string
+		want        time.Duration
+		wantErr     string
+	}{
+		{
+			name:     "scheduled",
+			interval: "24h",
+			want:     24 * time.Hour,
+		},
+		{
+			name:     "negative interval",
+			interval: "-1h",
+			wantErr:  "gc interval must be positive",
+		},
+		{
+			name:        "negative grace period",
+			interval:    "24h",
+			gracePeriod: "-1h",
+			wantErr:     "gc grace-period must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarYumConfig, "interval: 0", "interval: "+tt.interval, 1)
+			if tt.gracePeriod != "" {
+				config = strings.Replace(config, "grace-period: 1h", "grace-period: "+tt.gracePeriod, 1)
+			}
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.GC.Interval)
+		})
+	}
+}
+
+func TestParseBeskarYumConfigStats(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	tests := []struct {
+		name            string
+		interval        string
+		maxRepositories string
+		want            BeskarYumStats
+		wantErr         string
+	}{
+		{
+			name:            "enabled",
+			interval:        "15m",
+			maxRepositories: "0",
+			want: BeskarYumStats{
+				Interval:        15 * time.Minute,
+				MaxRepositories: DefaultBeskarYumStatsMaxRepositories,
+			},
+		},
+		{
+			name:            "bounded",
+			interval:        "1h",
+			maxRepositories: "10",
+			want: BeskarYumStats{
+				Interval:        time.Hour,
+				MaxRepositories: 10,
+			},
+		},
+		{
+			name:            "negative interval",
+			interval:        "-1h",
+			maxRepositories: "50",
+			wantErr:         "stats interval must be positive",
+		},
+		{
+			name:            "negative max repositories",
+			interval:        "1h",
+			maxRepositories: "-1",
+			wantErr:         "stats max-repositories must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(
+				defaultBeskarYumConfig,
+				"stats:\n  interval: 0\n  max-repositories: 50",
+				"stats:\n  interval: "+tt.interval+"\n  max-repositories: "+tt.maxRepositories,
+				1,
+			)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.Stats)
+		})
+	}
+}
+
+func TestParseBeskarYumConfigMetadataCompression(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	tests := []struct {
+		name            string
+		compression     string
+		bootstrap       string
+		wantCompression string
+		wantRepo        string
+		wantErr         string
+	}{
+		{
+			name:            "default",
+			compression:     `""`,
+			bootstrap:       "[]",
+			wantCompression: MetadataCompressionGzip,
+			wantRepo:        MetadataCompressionGzip,
+		},
+		{
+			name:            "repository override",
+			compression:     "zstd",
+			bootstrap:       "[{name: rocky/9/baseos, compression: xz}]",
+			wantCompression: MetadataCompressionZstd,
+			wantRepo:        MetadataCompressionXZ,
+		},
+		{
+			name:        "unknown compression",
+			compression: "bzip2",
+			bootstrap:   "[]",
+			wantErr:     `metadata compression must be either "gzip", "xz" or "zstd"`,
+		},
+		{
+			name:        "unknown repository compression",
+			compression: "gzip",
+			bootstrap:   "[{name: rocky/9/baseos, compression: lz4}]",
+			wantErr:     "bootstrap repository rocky/9/baseos: metadata compression must be",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.NewReplacer(
+				"compression: gzip", "compression: "+tt.compression,
+				"bootstrap: []", "bootstrap: "+tt.bootstrap,
+			).Replace(defaultBeskarYumConfig)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarYumConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCompression, bc.Metadata.Compression)
+			require.Equal(t, tt.wantRepo, bc.MetadataCompression("rocky/9/baseos"))
+		})
+	}
+}
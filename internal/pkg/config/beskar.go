@@ -6,13 +6,18 @@ package config
 import (
 	"bytes"
 	_ "embed"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
 )
@@ -20,20 +25,434 @@ import (
 const (
 	DefaultConfigDir = "/etc/beskar"
 	BeskarConfigFile = "beskar.yaml"
+
+	DefaultGossipCluster = "true"
+
+	// DefaultRegistryStorageDir is the registry filesystem storage directory
+	// used with the embedded default configuration.
+	DefaultRegistryStorageDir = "/tmp/beskar-registry"
+	// RegistryStorageDirEnv overrides the default registry storage directory,
+	// it's the configuration parser variable of the filesystem root directory.
+	RegistryStorageDirEnv = "BESKAR_REGISTRY_STORAGE_FILESYSTEM_ROOTDIRECTORY"
+
+	DefaultCacheSize = 64 * MiB
+
+	// SoloCAGenerate generates a CA when a node starts without peers.
+	SoloCAGenerate = "generate"
+	// SoloCAWait waits for a CA from peers when a node starts without peers.
+	SoloCAWait = "wait"
+
+	DefaultGossipSoloCA        = SoloCAGenerate
+	DefaultGossipSoloCATimeout = 2 * time.Minute
+	// DefaultGossipCAElectionTimeout is how long members not elected
+	// to generate the CA wait for the elected member before generating
+	// a CA themselves.
+	DefaultGossipCAElectionTimeout = 30 * time.Second
+
+	// DefaultGossipStartupJitter is the upper bound of the random delay
+	// applied before discovery to spread joins of nodes starting at once.
+	DefaultGossipStartupJitter = 2 * time.Second
+	// MaxGossipStartupJitter bounds the configurable startup jitter.
+	MaxGossipStartupJitter = time.Minute
+	// DefaultGossipMaxConcurrentJoins is the number of peers contacted
+	// at once when joining the cluster.
+	DefaultGossipMaxConcurrentJoins = 2
+	// DefaultGossipJoinRetries is the number of join retries when
+	// discovered peers are unreachable.
+	DefaultGossipJoinRetries = 3
+	// DefaultGossipJoinMinSuccess is the minimum number of peers joined.
+	DefaultGossipJoinMinSuccess = 1
+	// DefaultGossipStateTTL is the time after which shared state
+	// entries not refreshed by their owner are expired.
+	DefaultGossipStateTTL = 5 * time.Minute
+	// DefaultGossipClockSkewAllowance is the tolerated clock difference
+	// between nodes for the certificates validity and the shared state
+	// expiry.
+	DefaultGossipClockSkewAllowance = time.Minute
+
+	// DefaultGossipKeyMismatchWindow is how long a decryption failure
+	// is reported by the readiness check of a node missing peers.
+	DefaultGossipKeyMismatchWindow = 5 * time.Minute
+	// DefaultGossipKeyRotationTimeout is the time to wait for all
+	// members to acknowledge a gossip key rotation step.
+	DefaultGossipKeyRotationTimeout = 30 * time.Second
+	// DefaultGossipKeyRotationDrain is the time during which the old
+	// key is still accepted once the new key is used by all members.
+	DefaultGossipKeyRotationDrain = 5 * time.Second
+
+	// DefaultGossipUDPBufferSize is the memberlist default, it's
+	// conservative to fit within a standard 1500 bytes MTU.
+	DefaultGossipUDPBufferSize = 1400
+	// MaxGossipUDPBufferSize is the maximum payload of a UDP datagram.
+	MaxGossipUDPBufferSize = 65507
+
+	DefaultRequestIDHeader = "X-Request-ID"
+
+	DefaultListenHost = "0.0.0.0"
+
+	// PluginHTTP2Auto uses HTTP/2 with TLS backends only (Go default behavior).
+	PluginHTTP2Auto = "auto"
+	// PluginHTTP2Enabled always uses HTTP/2, with prior knowledge for cleartext backends.
+	PluginHTTP2Enabled = "enabled"
+	// PluginHTTP2Disabled always uses HTTP/1.1.
+	PluginHTTP2Disabled = "disabled"
+
+	// PluginResponseStream sends plugin responses to clients as they are
+	// received from the backend, responses are never retried.
+	PluginResponseStream = "stream"
+	// PluginResponseBuffer reads plugin responses in memory before sending
+	// them to clients, idempotent requests are retried on backend failures.
+	PluginResponseBuffer = "buffer"
+
+	DefaultPluginResponseBuffering = PluginResponseStream
+	// DefaultPluginResponseBufferLimit is the size above which buffered
+	// plugin responses are streamed.
+	DefaultPluginResponseBufferLimit = 8 * MiB
+
+	// defaults matching the Go default HTTP transport
+	DefaultPluginDialTimeout       = 30 * time.Second
+	DefaultPluginKeepaliveInterval = 30 * time.Second
+	DefaultPluginKeepaliveTimeout  = 15 * time.Second
+
+	DefaultResponseCacheTTL = 10 * time.Second
+
+	// CacheReconciliationCacheTolerant serves cached manifests even when
+	// they are missing from the storage.
+	CacheReconciliationCacheTolerant = "cache-tolerant"
+	// CacheReconciliationStorageAuthoritative checks that cached manifests
+	// exist in the storage and evicts them otherwise.
+	CacheReconciliationStorageAuthoritative = "storage-authoritative"
+	// DefaultCacheReconciliation is the default cache reconciliation policy.
+	DefaultCacheReconciliation = CacheReconciliationCacheTolerant
+
+	// AuthorizerAllowAll allows all plugin requests.
+	AuthorizerAllowAll = "allow-all"
+	// AuthorizerStatic allows plugin requests matching the static rules.
+	AuthorizerStatic = "static"
+
+	// IdentitySourceMTLS reads the identity from the client certificate.
+	IdentitySourceMTLS = "mtls"
+	// IdentitySourceHeader reads the identity from a request header.
+	IdentitySourceHeader = "header"
 )
 
+// DefaultResponseCachePaths are the request path prefixes cached when
+// the response cache is enabled without paths.
+var DefaultResponseCachePaths = []string{"/v2/_catalog"}
+
 //go:embed default/beskar.yaml
 var defaultBeskarConfig string
 
+// ResponseCache defines a local cache of whole GET responses for the
+// request paths matching one of the path prefixes, cached responses are
+// keyed by method, URL, authorization, client identity and the listed
+// request headers. Plugin responses are cached once the request has been
+// authorized. The size defaults to the cache size.
+type ResponseCache struct {
+	Enabled bool          `yaml:"enabled"`
+	Size    ByteSize      `yaml:"size"`
+	TTL     time.Duration `yaml:"ttl"`
+	Paths   []string      `yaml:"paths"`
+	Headers []string      `yaml:"headers"`
+}
+
 type Cache struct {
 	Addr string `yaml:"addr"`
-	Size uint32 `yaml:"size"`
+	// Size bounds the memory used by cached objects, see ByteSize.
+	Size ByteSize `yaml:"size"`
+	Zone string   `yaml:"zone"`
+	// ZoneNodeLabel is the label of the kubernetes node running the pod
+	// the zone is read from when no zone is set, the node name is read
+	// from the NODE_NAME environment variable.
+	ZoneNodeLabel string        `yaml:"zone-node-label"`
+	Response      ResponseCache `yaml:"response"`
+	// Reconciliation is the policy applied when the cache and the
+	// storage disagree about a manifest, see CacheReconciliationCacheTolerant
+	// and CacheReconciliationStorageAuthoritative.
+	Reconciliation string `yaml:"reconciliation"`
 }
 
 type Gossip struct {
-	Addr  string   `yaml:"addr"`
-	Key   string   `yaml:"key"`
-	Peers []string `yaml:"peers"`
+	Enabled           *bool           `yaml:"enabled"`
+	Addr              string          `yaml:"addr"`
+	AdvertiseAddr     string          `yaml:"advertise-addr"`
+	Key               string          `yaml:"key"`
+	Peers             []string        `yaml:"peers"`
+	MinPeers          int             `yaml:"min-peers"`
+	PeerPort          int             `yaml:"peer-port"`
+	Discovery         GossipDiscovery `yaml:"discovery"`
+	Cluster           string          `yaml:"cluster"`
+	DeadNodeReclaim   time.Duration   `yaml:"dead-node-reclaim"`
+	UDPBufferSize     int             `yaml:"udp-buffer-size"`
+	SoloCA            string          `yaml:"solo-ca"`
+	SoloCATimeout     time.Duration   `yaml:"solo-ca-timeout"`
+	CAElectionTimeout time.Duration   `yaml:"ca-election-timeout"`
+	MaxSuspectAge     time.Duration   `yaml:"max-suspect-age"`
+	// StartupJitter is the upper bound of the random delay applied
+	// before discovery and cluster join, zero disables the delay and
+	// the default applies when unset.
+	StartupJitter      *time.Duration `yaml:"startup-jitter"`
+	MaxConcurrentJoins int            `yaml:"max-concurrent-joins"`
+	// JoinRetries is the number of times discovered peers not joined
+	// are contacted again until JoinMinSuccess peers have been joined,
+	// zero disables the retries and the default applies when unset.
+	JoinRetries    *int `yaml:"join-retries"`
+	JoinMinSuccess int  `yaml:"join-min-success"`
+	// RequireEncryption makes the member creation fail instead
+	// of gossiping in plaintext.
+	RequireEncryption bool `yaml:"require-encryption"`
+	// StateTTL is the time after which shared state entries not
+	// refreshed by their owner are expired, the CA is exempt.
+	StateTTL time.Duration `yaml:"state-ttl"`
+	// ClockSkewAllowance is the tolerated clock difference between
+	// nodes, it's applied to the CA and the gossip and cache mTLS
+	// certificates validity period and when expiring shared state
+	// entries.
+	ClockSkewAllowance time.Duration `yaml:"clock-skew-allowance"`
+	// KeyMismatchWindow is how long a gossip message decryption failure
+	// is reported as a possible key mismatch by the readiness check of
+	// a node which didn't join the minimum number of peers.
+	KeyMismatchWindow time.Duration `yaml:"key-mismatch-window"`
+	// KeyRotation bounds the steps of a gossip key rotation.
+	KeyRotation GossipKeyRotation `yaml:"key-rotation"`
+	// CASecret is the Kubernetes Secret the CA certificate is
+	// mirrored to, the CA key is never written.
+	CASecret GossipCASecret `yaml:"ca-secret"`
+	// TLS runs the gossip transport over mutual TLS instead of
+	// encrypting messages with the symmetric key.
+	TLS GossipTLS `yaml:"tls"`
+}
+
+// GossipKeyRotation configures the gossip key rotation, the new key is
+// installed on all members, then used and the old key is finally removed
+// once drained. The rotation is aborted if a member doesn't acknowledge
+// a step within the timeout.
+type GossipKeyRotation struct {
+	Timeout time.Duration `yaml:"timeout"`
+	Drain   time.Duration `yaml:"drain"`
+}
+
+// GossipTLS runs the gossip transport over mutual TLS, each member
+// derives its certificate from the CA which is also the CA shared by the
+// cluster members. As members must trust each other before joining, the
+// CA is provisioned on every member instead of being generated by the
+// first member. Gossip packets are sent over TCP connections instead of
+// UDP which costs more connections and a higher failure detection
+// latency. TLS and the symmetric key are mutually exclusive.
+type GossipTLS struct {
+	Enabled bool   `yaml:"enabled"`
+	CACert  string `yaml:"ca-cert"`
+	CAKey   string `yaml:"ca-key"`
+}
+
+// GossipCASecret names the Kubernetes Secret the gossip CA certificate
+// is mirrored to, the namespace defaults to the pod namespace. Nothing
+// is mirrored when the name is empty.
+type GossipCASecret struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+// GossipDiscovery selects the peer discovery provider.
+type GossipDiscovery struct {
+	// Type is the name of the provider, it defaults to kubernetes
+	// when running in kubernetes and to static otherwise.
+	Type       string                    `yaml:"type"`
+	Kubernetes GossipKubernetesDiscovery `yaml:"kubernetes"`
+}
+
+// GossipKubernetesDiscovery defines the namespaces whose gossip endpoints
+// are listed in addition to the pod namespace, either by name or by a
+// namespace label selector.
+type GossipKubernetesDiscovery struct {
+	Namespaces        []string `yaml:"namespaces"`
+	NamespaceSelector string   `yaml:"namespace-selector"`
+}
+
+// IsEnabled returns false when gossip is explicitly disabled,
+// beskar then runs as a single node.
+func (g Gossip) IsEnabled() bool {
+	return g.Enabled == nil || *g.Enabled
+}
+
+// StartupJitterBound returns the upper bound of the startup delay,
+// the default applies when startup-jitter is unset.
+func (g Gossip) StartupJitterBound() time.Duration {
+	if g.StartupJitter == nil {
+		return DefaultGossipStartupJitter
+	}
+	return *g.StartupJitter
+}
+
+// JoinRetryCount returns the number of join retries, the default
+// applies when join-retries is unset.
+func (g Gossip) JoinRetryCount() int {
+	if g.JoinRetries == nil {
+		return DefaultGossipJoinRetries
+	}
+	return *g.JoinRetries
+}
+
+// DecodeKey returns the decoded gossip key, the key must be a base64
+// encoded AES-128, AES-192 or AES-256 key.
+func (g Gossip) DecodeKey() ([]byte, error) {
+	if g.Key == "" {
+		return nil, fmt.Errorf("gossip key is missing")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(g.Key)
+	if err != nil {
+		return nil, fmt.Errorf("gossip key is not valid base64: %w", err)
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("gossip key is %d bytes long, it must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256)", len(key))
+	}
+}
+
+// setDefaults sets the defaults of the gossip settings and validates them.
+func (g *Gossip) setDefaults() error {
+	var err error
+
+	g.Addr, err = normalizeAddr("gossip.addr", g.Addr, DefaultListenHost, true)
+	if err != nil {
+		return err
+	}
+
+	if g.MinPeers < 0 {
+		return fmt.Errorf("gossip min-peers must be positive")
+	}
+
+	// zero means the port is discovered from kubernetes endpoints
+	if g.PeerPort < 0 || g.PeerPort > 65535 {
+		return fmt.Errorf("gossip peer-port must be between 1 and 65535")
+	}
+
+	if g.AdvertiseAddr != "" {
+		g.AdvertiseAddr, err = expandAdvertiseAddr(g.AdvertiseAddr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if g.Enabled == nil {
+		enabled := true
+		g.Enabled = &enabled
+	}
+
+	if err := resolveSecrets(map[string]*string{
+		"gossip.key": &g.Key,
+	}); err != nil {
+		return err
+	}
+
+	// the key isn't required in single node mode
+	switch {
+	case !*g.Enabled:
+	case g.TLS.Enabled && g.Key != "":
+		return fmt.Errorf("gossip key and tls are mutually exclusive, remove the key to enable tls")
+	case g.TLS.Enabled && (g.TLS.CACert == "" || g.TLS.CAKey == ""):
+		return fmt.Errorf("gossip tls requires a ca-cert and a ca-key")
+	case !g.TLS.Enabled:
+		if _, err := g.DecodeKey(); err != nil {
+			return err
+		}
+	}
+
+	if g.Cluster == "" {
+		g.Cluster = DefaultGossipCluster
+	}
+
+	switch g.SoloCA {
+	case "":
+		g.SoloCA = DefaultGossipSoloCA
+	case SoloCAGenerate, SoloCAWait:
+	default:
+		return fmt.Errorf("gossip solo-ca must be either %q or %q", SoloCAGenerate, SoloCAWait)
+	}
+
+	if g.SoloCATimeout == 0 {
+		g.SoloCATimeout = DefaultGossipSoloCATimeout
+	}
+
+	for _, namespace := range g.Discovery.Kubernetes.Namespaces {
+		if namespace == "" {
+			return fmt.Errorf("gossip discovery kubernetes namespaces can't be empty")
+		}
+	}
+
+	if g.CAElectionTimeout == 0 {
+		g.CAElectionTimeout = DefaultGossipCAElectionTimeout
+	} else if g.CAElectionTimeout < 0 {
+		return fmt.Errorf("gossip ca-election-timeout must be positive")
+	}
+
+	if g.StartupJitter == nil {
+		startupJitter := DefaultGossipStartupJitter
+		g.StartupJitter = &startupJitter
+	} else if *g.StartupJitter < 0 || *g.StartupJitter > MaxGossipStartupJitter {
+		return fmt.Errorf("gossip startup-jitter must be between 0 and %s", MaxGossipStartupJitter)
+	}
+
+	if g.MaxConcurrentJoins == 0 {
+		g.MaxConcurrentJoins = DefaultGossipMaxConcurrentJoins
+	} else if g.MaxConcurrentJoins < 0 {
+		return fmt.Errorf("gossip max-concurrent-joins must be positive")
+	}
+
+	if g.JoinRetries == nil {
+		joinRetries := DefaultGossipJoinRetries
+		g.JoinRetries = &joinRetries
+	} else if *g.JoinRetries < 0 {
+		return fmt.Errorf("gossip join-retries must be positive")
+	}
+
+	if g.JoinMinSuccess == 0 {
+		g.JoinMinSuccess = DefaultGossipJoinMinSuccess
+	} else if g.JoinMinSuccess < 0 {
+		return fmt.Errorf("gossip join-min-success must be positive")
+	}
+
+	if g.StateTTL == 0 {
+		g.StateTTL = DefaultGossipStateTTL
+	} else if g.StateTTL < 0 {
+		return fmt.Errorf("gossip state-ttl must be positive")
+	}
+
+	if g.ClockSkewAllowance == 0 {
+		g.ClockSkewAllowance = DefaultGossipClockSkewAllowance
+	} else if g.ClockSkewAllowance < 0 {
+		return fmt.Errorf("gossip clock-skew-allowance must be positive")
+	}
+
+	if g.KeyMismatchWindow == 0 {
+		g.KeyMismatchWindow = DefaultGossipKeyMismatchWindow
+	} else if g.KeyMismatchWindow < 0 {
+		return fmt.Errorf("gossip key-mismatch-window must be positive")
+	}
+
+	if g.KeyRotation.Timeout == 0 {
+		g.KeyRotation.Timeout = DefaultGossipKeyRotationTimeout
+	} else if g.KeyRotation.Timeout < 0 {
+		return fmt.Errorf("gossip key-rotation timeout must be positive")
+	}
+
+	if g.KeyRotation.Drain == 0 {
+		g.KeyRotation.Drain = DefaultGossipKeyRotationDrain
+	} else if g.KeyRotation.Drain < 0 {
+		return fmt.Errorf("gossip key-rotation drain must be positive")
+	}
+
+	if g.UDPBufferSize == 0 {
+		g.UDPBufferSize = DefaultGossipUDPBufferSize
+	} else if g.UDPBufferSize < 0 || g.UDPBufferSize > MaxGossipUDPBufferSize {
+		return fmt.Errorf("gossip udp-buffer-size must be between 1 and %d", MaxGossipUDPBufferSize)
+	}
+
+	return nil
 }
 
 type PluginMTLS struct {
@@ -47,27 +466,213 @@ type PluginBackend struct {
 	MTLS PluginMTLS `yaml:"mtls"`
 }
 
+type HeaderFilter struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+type PluginHeaders struct {
+	Request  HeaderFilter `yaml:"request"`
+	Response HeaderFilter `yaml:"response"`
+}
+
+type PluginClient struct {
+	HTTP2             string        `yaml:"http2"`
+	KeepaliveInterval time.Duration `yaml:"keepalive-interval"`
+	KeepaliveTimeout  time.Duration `yaml:"keepalive-timeout"`
+	// DNSCacheTTL is how long backend host resolutions are cached,
+	// zero means hosts are resolved for each connection.
+	DNSCacheTTL time.Duration `yaml:"dns-cache-ttl"`
+}
+
 type Plugin struct {
 	Prefix    string          `yaml:"prefix"`
 	Mediatype string          `yaml:"mediatype"`
 	Backends  []PluginBackend `yaml:"backends"`
+	Headers   PluginHeaders   `yaml:"headers"`
+	Client    PluginClient    `yaml:"client"`
+	Inference PluginInference `yaml:"inference"`
+	// RateLimit overrides the global rate limit for the plugin
+	// requests when set.
+	RateLimit *RateLimit `yaml:"ratelimit"`
+	// RequestTimeout bounds the time the plugin backends take to send
+	// the response headers once the request body is sent, the bodies are
+	// streamed without time limit, zero means no timeout.
+	RequestTimeout time.Duration `yaml:"request-timeout"`
+	// ResponseBuffering is either stream or buffer, it trades the
+	// memory of buffered responses for their retry-ability.
+	ResponseBuffering string `yaml:"response-buffering"`
+	// ErrorResponses replace the responses sent to clients on
+	// backend failures, the first matching response is used.
+	ErrorResponses []PluginErrorResponse `yaml:"error-responses"`
+}
+
+// AuthorizationRule allows an identity to send requests to plugins for
+// the request paths matching one of the prefixes, methods restrict the
+// allowed request methods if any. The "*" identity matches any identity.
+type AuthorizationRule struct {
+	Identity string   `yaml:"identity"`
+	Prefixes []string `yaml:"prefixes"`
+	Methods  []string `yaml:"methods"`
+}
+
+// Authorization defines how plugin requests are authorized, the identity
+// is either the mTLS client certificate common name or the value of a
+// header set by a trusted proxy.
+type Authorization struct {
+	Type           string              `yaml:"type"`
+	IdentitySource string              `yaml:"identity-source"`
+	IdentityHeader string              `yaml:"identity-header"`
+	Rules          []AuthorizationRule `yaml:"rules"`
+}
+
+// Node defines the node settings advertised to peers.
+type Node struct {
+	// PublicURL is the externally reachable URL of the node, peers redirect
+	// the blob fetches of the blobs owned by the node to it.
+	PublicURL string `yaml:"public-url"`
+}
+
+type Tracing struct {
+	Enabled  bool            `yaml:"enabled"`
+	Exporter TracingExporter `yaml:"exporter"`
+}
+
+// TracingExporter defines the OTLP HTTP collector receiving the spans,
+// the standard OTEL_EXPORTER_OTLP_* environment variables apply to the
+// unset fields.
+type TracingExporter struct {
+	// Endpoint is the collector host:port.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure disables TLS for the collector connection.
+	Insecure bool `yaml:"insecure"`
 }
 
 type BeskarConfig struct {
-	Version   string                       `yaml:"version"`
-	Profiling bool                         `yaml:"profiling"`
-	Cache     Cache                        `yaml:"cache"`
-	Gossip    Gossip                       `yaml:"gossip"`
-	Plugins   map[string]Plugin            `yaml:"plugins"`
-	Registry  *configuration.Configuration `yaml:"registry"`
+	Version         string                       `yaml:"version"`
+	Profiling       bool                         `yaml:"profiling"`
+	RequestIDHeader string                       `yaml:"request-id-header"`
+	Tracing         Tracing                      `yaml:"tracing"`
+	AccessLog       AccessLog                    `yaml:"access-log"`
+	Metrics         Metrics                      `yaml:"metrics"`
+	Server          Server                       `yaml:"server"`
+	ConnLimit       ConnLimit                    `yaml:"conn-limit"`
+	TrustedProxies  []string                     `yaml:"trusted-proxies"`
+	RateLimit       RateLimit                    `yaml:"ratelimit"`
+	Node            Node                         `yaml:"node"`
+	Cache           Cache                        `yaml:"cache"`
+	Gossip          Gossip                       `yaml:"gossip"`
+	GossipClusters  map[string]Gossip            `yaml:"gossip-clusters"`
+	Plugins         map[string]Plugin            `yaml:"plugins"`
+	PluginRouting   PluginRouting                `yaml:"plugin-routing"`
+	PluginHealth    PluginHealth                 `yaml:"plugin-health"`
+	Inference       MediatypeInference           `yaml:"mediatype-inference"`
+	Authorization   Authorization                `yaml:"authorization"`
+	Maintenance     Maintenance                  `yaml:"maintenance"`
+	Registry        *configuration.Configuration `yaml:"registry"`
 }
 
 func (bc *BeskarConfig) RunInKubernetes() bool {
 	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
 }
 
+// Route describes how requests and manifest events are routed to a plugin.
+type Route struct {
+	Plugin    string   `json:"plugin"`
+	Prefix    string   `json:"prefix"`
+	Mediatype string   `json:"mediatype"`
+	Backends  []string `json:"backends"`
+}
+
+// RoutingTable returns a snapshot of the plugin routing table in the
+// order the plugin prefixes are matched against requests.
+func (bc *BeskarConfig) RoutingTable() []Route {
+	routes := make([]Route, 0, len(bc.Plugins))
+
+	for _, name := range bc.PluginRouting.ResolutionOrder(bc.Plugins) {
+		plugin := bc.Plugins[name]
+
+		route := Route{
+			Plugin:    name,
+			Prefix:    plugin.Prefix,
+			Mediatype: plugin.Mediatype,
+			Backends:  make([]string, 0, len(plugin.Backends)),
+		}
+		for _, backend := range plugin.Backends {
+			route.Backends = append(route.Backends, backend.URL)
+		}
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
 type BeskarConfigV1 BeskarConfig
 
+// normalizeAddr validates a host:port address and fills the
+// host with the default host if missing.
+func normalizeAddr(field, addr, defaultHost string, allowZeroPort bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("%s must be host:port: %w", field, err)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("%s port %q is not a valid port number", field, port)
+	} else if portNumber == 0 && !allowZeroPort {
+		return "", fmt.Errorf("%s port must not be 0", field)
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// expandAdvertiseAddr interpolates the environment variables of the
+// gossip advertise address (eg: ${POD_IP}:5102) and validates the
+// resolved address, memberlist requires an IP address.
+func expandAdvertiseAddr(addr string) (string, error) {
+	var missing []string
+
+	expanded := os.Expand(addr, func(key string) string {
+		value := os.Getenv(key)
+		if value == "" {
+			missing = append(missing, key)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("gossip.advertise-addr environment variables not set: %s", strings.Join(missing, ", "))
+	}
+
+	host, port, err := net.SplitHostPort(expanded)
+	if err != nil {
+		return "", fmt.Errorf("gossip.advertise-addr %q must be host:port: %w", expanded, err)
+	} else if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("gossip.advertise-addr host %q is not an IP address", host)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || portNumber == 0 {
+		return "", fmt.Errorf("gossip.advertise-addr port %q is not a valid port number", port)
+	}
+
+	return expanded, nil
+}
+
+// ensureWritableDir creates the directory if it doesn't exist
+// and checks that files can be created in it.
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".beskar-write-check-")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
 func ParseBeskarConfig(dir string) (*BeskarConfig, error) {
 	inMemoryConfig := false
 	customDir := false
@@ -96,6 +701,12 @@ func ParseBeskarConfig(dir string) (*BeskarConfig, error) {
 		return nil, err
 	}
 
+	return parseBeskarConfig(configBuffer.Bytes(), inMemoryConfig)
+}
+
+// parseBeskarConfig parses and validates the configuration, inMemoryConfig
+// is true when data is the embedded default configuration.
+func parseBeskarConfig(data []byte, inMemoryConfig bool) (*BeskarConfig, error) {
 	beskarConfig := new(BeskarConfig)
 
 	configParser := configuration.NewParser("beskar", []configuration.VersionedParseInfo{
@@ -104,6 +715,8 @@ func ParseBeskarConfig(dir string) (*BeskarConfig, error) {
 			ParseAs: reflect.TypeOf(BeskarConfigV1{}),
 			ConversionFunc: func(c interface{}) (interface{}, error) {
 				if v1, ok := c.(*BeskarConfigV1); ok {
+					var err error
+
 					if v1.Registry.Log.Level == configuration.Loglevel("") {
 						//nolint:staticcheck // legacy behavior
 						if v1.Registry.Loglevel != configuration.Loglevel("") {
@@ -124,16 +737,196 @@ func ParseBeskarConfig(dir string) (*BeskarConfig, error) {
 					if v1.Registry.Storage.Type() == "" {
 						return nil, errors.New("no storage configuration provided")
 					} else if inMemoryConfig && v1.Registry.Storage.Type() == "filesystem" {
+						storageDir := os.Getenv(RegistryStorageDirEnv)
+						if storageDir == "" {
+							storageDir = DefaultRegistryStorageDir
+						}
+						if err := ensureWritableDir(storageDir); err != nil {
+							return nil, fmt.Errorf("registry storage directory %s: %w", storageDir, err)
+						}
 						params := v1.Registry.Storage.Parameters()
-						params["rootdirectory"] = "/tmp/beskar-registry"
+						params["rootdirectory"] = storageDir
+					}
+
+					if err := resolveRegistrySecrets(v1.Registry); err != nil {
+						return nil, err
 					}
 
 					if v1.Cache.Size == 0 {
-						v1.Cache.Size = 64
+						v1.Cache.Size = DefaultCacheSize
+					}
+
+					if v1.Cache.Response.Size == 0 {
+						v1.Cache.Response.Size = v1.Cache.Size
+					}
+					if v1.Cache.Response.TTL == 0 {
+						v1.Cache.Response.TTL = DefaultResponseCacheTTL
+					} else if v1.Cache.Response.TTL < 0 {
+						return nil, fmt.Errorf("cache response ttl must be positive")
+					}
+					if len(v1.Cache.Response.Paths) == 0 {
+						v1.Cache.Response.Paths = DefaultResponseCachePaths
+					}
+
+					switch v1.Cache.Reconciliation {
+					case "":
+						v1.Cache.Reconciliation = DefaultCacheReconciliation
+					case CacheReconciliationCacheTolerant, CacheReconciliationStorageAuthoritative:
+					default:
+						return nil, fmt.Errorf(
+							"cache reconciliation must be either %q or %q",
+							CacheReconciliationCacheTolerant, CacheReconciliationStorageAuthoritative,
+						)
+					}
+
+					// cache port is advertised to peers and can't be random
+					v1.Cache.Addr, err = normalizeAddr("cache.addr", v1.Cache.Addr, DefaultListenHost, false)
+					if err != nil {
+						return nil, err
+					}
+
+					for name, plugin := range v1.Plugins {
+						switch plugin.Client.HTTP2 {
+						case "":
+							plugin.Client.HTTP2 = PluginHTTP2Auto
+						case PluginHTTP2Auto, PluginHTTP2Enabled, PluginHTTP2Disabled:
+						default:
+							return nil, fmt.Errorf(
+								"plugin %s client http2 must be one of %q, %q or %q",
+								name, PluginHTTP2Auto, PluginHTTP2Enabled, PluginHTTP2Disabled,
+							)
+						}
+						switch plugin.ResponseBuffering {
+						case "":
+							plugin.ResponseBuffering = DefaultPluginResponseBuffering
+						case PluginResponseStream, PluginResponseBuffer:
+						default:
+							return nil, fmt.Errorf(
+								"plugin %s response-buffering must be either %q or %q",
+								name, PluginResponseStream, PluginResponseBuffer,
+							)
+						}
+						if plugin.Client.KeepaliveInterval == 0 {
+							plugin.Client.KeepaliveInterval = DefaultPluginKeepaliveInterval
+						}
+						if plugin.Client.KeepaliveTimeout == 0 {
+							plugin.Client.KeepaliveTimeout = DefaultPluginKeepaliveTimeout
+						}
+						if plugin.Client.DNSCacheTTL < 0 {
+							return nil, fmt.Errorf("plugin %s client dns-cache-ttl must be positive", name)
+						}
+						if err := plugin.Inference.validate(name); err != nil {
+							return nil, err
+						}
+						if plugin.RequestTimeout < 0 {
+							return nil, fmt.Errorf("plugin %s request-timeout must be positive", name)
+						}
+						if err := validatePluginErrorResponses(name, plugin.ErrorResponses); err != nil {
+							return nil, err
+						}
+						if plugin.RateLimit != nil {
+							if err := plugin.RateLimit.setDefaults(fmt.Sprintf("plugin %s ratelimit", name)); err != nil {
+								return nil, err
+							}
+						}
+						v1.Plugins[name] = plugin
+					}
+
+					if err := v1.PluginRouting.setDefaults(v1.Plugins); err != nil {
+						return nil, err
+					}
+
+					if err := v1.PluginHealth.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					v1.Inference.setDefaults()
+
+					if err := v1.AccessLog.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := v1.ConnLimit.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := validateTrustedProxies(v1.TrustedProxies); err != nil {
+						return nil, err
+					}
+
+					if err := v1.RateLimit.setDefaults("ratelimit"); err != nil {
+						return nil, err
+					}
+
+					if err := v1.Metrics.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					if err := v1.Maintenance.SetDefaults(v1.Plugins); err != nil {
+						return nil, err
+					}
+
+					if err := v1.Gossip.setDefaults(); err != nil {
+						return nil, err
+					}
+
+					for name, cluster := range v1.GossipClusters {
+						if !cluster.IsEnabled() {
+							continue
+						}
+						// members of other clusters are rejected by their meta data
+						if cluster.Cluster == "" {
+							cluster.Cluster = name
+						}
+						if err := cluster.setDefaults(); err != nil {
+							return nil, fmt.Errorf("gossip-clusters %s: %w", name, err)
+						}
+						v1.GossipClusters[name] = cluster
+					}
+
+					if err := checkGossipClusters(v1.Gossip, v1.GossipClusters); err != nil {
+						return nil, err
+					}
+
+					switch v1.Authorization.Type {
+					case "":
+						v1.Authorization.Type = AuthorizerAllowAll
+					case AuthorizerAllowAll, AuthorizerStatic:
+					default:
+						return nil, fmt.Errorf("authorization type must be either %q or %q", AuthorizerAllowAll, AuthorizerStatic)
+					}
+
+					switch v1.Authorization.IdentitySource {
+					case "":
+						v1.Authorization.IdentitySource = IdentitySourceMTLS
+					case IdentitySourceMTLS:
+					case IdentitySourceHeader:
+						if v1.Authorization.IdentityHeader == "" {
+							return nil, fmt.Errorf("authorization identity-header is required with the %q identity source", IdentitySourceHeader)
+						} else if len(v1.TrustedProxies) == 0 {
+							// the header is only honored for requests sent by a trusted proxy
+							return nil, fmt.Errorf("trusted-proxies are required with the %q identity source", IdentitySourceHeader)
+						}
+					default:
+						return nil, fmt.Errorf("authorization identity-source must be either %q or %q", IdentitySourceMTLS, IdentitySourceHeader)
+					}
+
+					if v1.Node.PublicURL != "" {
+						publicURL, err := url.Parse(v1.Node.PublicURL)
+						if err != nil {
+							return nil, fmt.Errorf("while parsing node public-url: %w", err)
+						} else if (publicURL.Scheme != "http" && publicURL.Scheme != "https") || publicURL.Host == "" {
+							return nil, fmt.Errorf("node public-url %s must be an absolute http(s) URL", v1.Node.PublicURL)
+						}
+						v1.Node.PublicURL = strings.TrimSuffix(publicURL.String(), "/")
+					}
+
+					if err := v1.Server.Timeouts.setDefaults(); err != nil {
+						return nil, err
 					}
 
-					if v1.Gossip.Key == "" {
-						return nil, fmt.Errorf("gossip key is missing")
+					if v1.RequestIDHeader == "" {
+						v1.RequestIDHeader = DefaultRequestIDHeader
 					}
 
 					return (*BeskarConfig)(v1), nil
@@ -143,7 +936,7 @@ func ParseBeskarConfig(dir string) (*BeskarConfig, error) {
 		},
 	})
 
-	if err := configParser.Parse(configBuffer.Bytes(), beskarConfig); err != nil {
+	if err := configParser.Parse(data, beskarConfig); err != nil {
 		return nil, err
 	}
 
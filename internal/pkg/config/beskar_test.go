@@ -4,22 +4,1040 @@
 package config
 
 import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestParseBeskarConfig(t *testing.T) {
+	storageDir := filepath.Join(t.TempDir(), "registry")
+	t.Setenv(RegistryStorageDirEnv, storageDir)
+
 	bc, err := ParseBeskarConfig("")
 	require.NoError(t, err)
 
+	require.Equal(t, storageDir, bc.Registry.Storage.Parameters()["rootdirectory"])
+	require.DirExists(t, storageDir)
+
 	require.Equal(t, "1.0", bc.Version)
+
+	uploadPurging, ok := bc.Registry.Storage["maintenance"]["uploadpurging"].(map[interface{}]interface{})
+	require.True(t, ok)
+	require.Equal(t, true, uploadPurging["enabled"])
+	require.Equal(t, "168h", uploadPurging["age"])
+
+	require.Equal(t, ServerTimeouts{
+		ReadHeader: DefaultServerReadHeaderTimeout,
+		Read:       DefaultServerReadTimeout,
+		Write:      DefaultServerWriteTimeout,
+		Idle:       DefaultServerIdleTimeout,
+	}, bc.Server.Timeouts)
 	require.Equal(t, true, bc.Profiling)
+	require.Equal(t, DefaultRequestIDHeader, bc.RequestIDHeader)
+	require.Equal(t, false, bc.Tracing.Enabled)
+	require.Equal(t, false, bc.AccessLog.Enabled)
+
+	require.Equal(t, "", bc.Node.PublicURL)
 
 	require.Equal(t, "0.0.0.0:5103", bc.Cache.Addr)
-	require.Equal(t, uint32(64), bc.Cache.Size)
+	require.Equal(t, 64*MiB, bc.Cache.Size)
+	require.Equal(t, CacheReconciliationCacheTolerant, bc.Cache.Reconciliation)
+	require.Equal(t, ResponseCache{
+		Enabled: false,
+		Size:    64 * MiB,
+		TTL:     DefaultResponseCacheTTL,
+		Paths:   []string{"/v2/_catalog"},
+		Headers: []string{"Accept"},
+	}, bc.Cache.Response)
 
+	require.Equal(t, true, bc.Gossip.IsEnabled())
 	require.Equal(t, "0.0.0.0:5102", bc.Gossip.Addr)
 	require.Equal(t, "XD1IOhcp0HWFgZJ/HAaARqMKJwfMWtz284Yj7wxmerA=", bc.Gossip.Key)
 	require.Equal(t, []string{}, bc.Gossip.Peers)
+	require.Equal(t, DefaultGossipCluster, bc.Gossip.Cluster)
+	require.Equal(t, MetricsBackendPrometheus, bc.Metrics.Backend)
+	require.Equal(t, DefaultMetricsExportInterval, bc.Metrics.Exporter.Interval)
+	require.Equal(t, time.Duration(0), bc.Gossip.DeadNodeReclaim)
+	require.Equal(t, DefaultGossipUDPBufferSize, bc.Gossip.UDPBufferSize)
+	require.Equal(t, SoloCAGenerate, bc.Gossip.SoloCA)
+	require.Equal(t, DefaultGossipSoloCATimeout, bc.Gossip.SoloCATimeout)
+	require.Equal(t, DefaultGossipCAElectionTimeout, bc.Gossip.CAElectionTimeout)
+	require.Equal(t, time.Duration(0), bc.Gossip.MaxSuspectAge)
+	require.Equal(t, DefaultGossipStartupJitter, bc.Gossip.StartupJitterBound())
+	require.Equal(t, DefaultGossipMaxConcurrentJoins, bc.Gossip.MaxConcurrentJoins)
+	require.Equal(t, DefaultGossipJoinRetries, bc.Gossip.JoinRetryCount())
+	require.Equal(t, DefaultGossipJoinMinSuccess, bc.Gossip.JoinMinSuccess)
+	require.False(t, bc.Gossip.RequireEncryption)
+	require.Equal(t, DefaultGossipStateTTL, bc.Gossip.StateTTL)
+	require.Equal(t, DefaultGossipClockSkewAllowance, bc.Gossip.ClockSkewAllowance)
+	require.Equal(t, GossipKeyRotation{
+		Timeout: DefaultGossipKeyRotationTimeout,
+		Drain:   DefaultGossipKeyRotationDrain,
+	}, bc.Gossip.KeyRotation)
+	require.False(t, bc.Gossip.TLS.Enabled)
+
+	require.Equal(t, []Route{
+		{
+			Plugin:    "yum",
+			Prefix:    "/yum",
+			Mediatype: "application/vnd.ciq.rpm-package.v1.config+json",
+			Backends:  []string{"http://127.0.0.1:5200?executable=beskar-yum"},
+		},
+	}, bc.RoutingTable())
+
+	require.Equal(t, Authorization{
+		Type:           AuthorizerAllowAll,
+		IdentitySource: IdentitySourceMTLS,
+		Rules:          []AuthorizationRule{},
+	}, bc.Authorization)
+
+	require.Equal(t, Maintenance{
+		Block:      []string{MaintenanceBlockWrite},
+		Plugins:    []string{},
+		RetryAfter: DefaultMaintenanceRetryAfter,
+	}, bc.Maintenance)
+
+	require.Equal(t, PluginClient{
+		HTTP2:             PluginHTTP2Auto,
+		KeepaliveInterval: DefaultPluginKeepaliveInterval,
+		KeepaliveTimeout:  DefaultPluginKeepaliveTimeout,
+	}, bc.Plugins["yum"].Client)
+
+	require.Equal(t, PluginInference{
+		Filenames:       []string{"*.rpm"},
+		LayerMediatypes: []string{"application/vnd.ciq.rpm-package.v1.bin"},
+	}, bc.Plugins["yum"].Inference)
+	require.Equal(t, MediatypeInference{
+		GenericMediatypes: DefaultGenericMediatypes,
+	}, bc.Inference)
+}
+
+func TestParseBeskarConfigAddr(t *testing.T) {
+	tests := []struct {
+		name      string
+		cacheAddr string
+		gossip    string
+		wantCache string
+		wantErr   string
+	}{
+		{
+			name:      "default host",
+			cacheAddr: ":5103",
+			gossip:    ":5102",
+			wantCache: "0.0.0.0:5103",
+		},
+		{
+			name:      "missing port",
+			cacheAddr: "127.0.0.1",
+			gossip:    ":5102",
+			wantErr:   "cache.addr must be host:port",
+		},
+		{
+			name:      "zero cache port",
+			cacheAddr: "127.0.0.1:0",
+			gossip:    ":5102",
+			wantErr:   "cache.addr port must not be 0",
+		},
+		{
+			name:      "bad gossip port",
+			cacheAddr: ":5103",
+			gossip:    ":gossip",
+			wantErr:   "gossip.addr port",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.NewReplacer(
+				"0.0.0.0:5103", tt.cacheAddr,
+				"0.0.0.0:5102", tt.gossip,
+			).Replace(defaultBeskarConfig)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCache, bc.Cache.Addr)
+		})
+	}
+}
+
+func TestParseBeskarConfigRegistryStorageDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	t.Setenv(RegistryStorageDirEnv, filepath.Join(file, "registry"))
+
+	_, err := ParseBeskarConfig("")
+	require.ErrorContains(t, err, "registry storage directory")
+}
+
+func TestParseBeskarConfigGossipDisabled(t *testing.T) {
+	dir := t.TempDir()
+
+	config := strings.NewReplacer(
+		"  enabled: true\n  addr: 0.0.0.0:5102", "  enabled: false\n  addr: 0.0.0.0:5102",
+		"XD1IOhcp0HWFgZJ/HAaARqMKJwfMWtz284Yj7wxmerA=", "",
+	).Replace(defaultBeskarConfig)
+
+	err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+	require.NoError(t, err)
+
+	bc, err := ParseBeskarConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, false, bc.Gossip.IsEnabled())
+	require.Equal(t, "", bc.Gossip.Key)
+}
+
+func TestParseBeskarConfigJoinRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		joinRetries string
+		want        int
+		wantErr     string
+	}{
+		{name: "unset", want: DefaultGossipJoinRetries},
+		{name: "disabled", joinRetries: "0", want: 0},
+		{name: "set", joinRetries: "5", want: 5},
+		{name: "negative", joinRetries: "-1", wantErr: "gossip join-retries must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := defaultBeskarConfig
+			if tt.joinRetries != "" {
+				config = strings.Replace(
+					config,
+					"  enabled: true\n  addr: 0.0.0.0:5102",
+					"  enabled: true\n  addr: 0.0.0.0:5102\n  join-retries: "+tt.joinRetries,
+					1,
+				)
+			}
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.Gossip.JoinRetryCount())
+		})
+	}
+}
+
+func TestParseBeskarConfigStartupJitter(t *testing.T) {
+	tests := []struct {
+		name          string
+		startupJitter string
+		want          time.Duration
+		wantErr       string
+	}{
+		{name: "unset", want: DefaultGossipStartupJitter},
+		{name: "disabled", startupJitter: "0", want: 0},
+		{name: "set", startupJitter: "5s", want: 5 * time.Second},
+		{name: "negative", startupJitter: "-1s", wantErr: "gossip startup-jitter must be between 0 and"},
+		{name: "too large", startupJitter: "2m", wantErr: "gossip startup-jitter must be between 0 and"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultBeskarConfig
+			if tt.startupJitter != "" {
+				config = strings.Replace(
+					config,
+					"  enabled: true\n  addr: 0.0.0.0:5102",
+					"  enabled: true\n  addr: 0.0.0.0:5102\n  startup-jitter: "+tt.startupJitter,
+					1,
+				)
+			}
+
+			bc, err := parseBeskarConfig([]byte(config), false)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.Gossip.StartupJitterBound())
+		})
+	}
+}
+
+func TestParseBeskarConfigAdvertiseAddr(t *testing.T) {
+	t.Setenv("BESKAR_TEST_POD_IP", "10.1.2.3")
+	t.Setenv("BESKAR_TEST_HOSTNAME", "beskar-0")
+
+	tests := []struct {
+		name          string
+		advertiseAddr string
+		want          string
+		wantErr       string
+	}{
+		{
+			name:          "environment variable",
+			advertiseAddr: "${BESKAR_TEST_POD_IP}:5102",
+			want:          "10.1.2.3:5102",
+		},
+		{
+			name:          "static address",
+			advertiseAddr: "192.168.1.1:5102",
+			want:          "192.168.1.1:5102",
+		},
+		{
+			name:          "unset environment variable",
+			advertiseAddr: "${BESKAR_TEST_UNSET}:5102",
+			wantErr:       "environment variables not set: BESKAR_TEST_UNSET",
+		},
+		{
+			name:          "missing port",
+			advertiseAddr: "${BESKAR_TEST_POD_IP}",
+			wantErr:       "must be host:port",
+		},
+		{
+			name:          "not an IP",
+			advertiseAddr: "${BESKAR_TEST_HOSTNAME}:5102",
+			wantErr:       "is not an IP address",
+		},
+		{
+			name:          "zero port",
+			advertiseAddr: "${BESKAR_TEST_POD_IP}:0",
+			wantErr:       "is not a valid port number",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(
+				defaultBeskarConfig,
+				"  addr: 0.0.0.0:5102\n",
+				"  addr: 0.0.0.0:5102\n  advertise-addr: "+tt.advertiseAddr+"\n",
+				1,
+			)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.Gossip.AdvertiseAddr)
+		})
+	}
+}
+
+func TestParseBeskarConfigPeerPort(t *testing.T) {
+	for _, tt := range []struct {
+		peerPort string
+		want     int
+		wantErr  string
+	}{
+		{peerPort: "7946", want: 7946},
+		{peerPort: "70000", wantErr: "gossip peer-port must be between 1 and 65535"},
+	} {
+		dir := t.TempDir()
+
+		config := strings.Replace(
+			defaultBeskarConfig,
+			"  peers: []\n",
+			"  peers: []\n  peer-port: "+tt.peerPort+"\n",
+			1,
+		)
+
+		err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+		require.NoError(t, err)
+
+		bc, err := ParseBeskarConfig(dir)
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.want, bc.Gossip.PeerPort)
+	}
+}
+
+func TestConfigFingerprint(t *testing.T) {
+	t.Setenv(RegistryStorageDirEnv, filepath.Join(t.TempDir(), "registry"))
+
+	bc, err := ParseBeskarConfig("")
+	require.NoError(t, err)
+
+	fingerprint := ConfigFingerprint(bc)
+	require.Len(t, fingerprint, 64)
+	require.Equal(t, fingerprint, ConfigFingerprint(bc))
+
+	// secrets and node specific settings are excluded
+	bc.Gossip.Key = "c2VjcmV0"
+	bc.Gossip.AdvertiseAddr = "10.0.0.1:5102"
+	bc.Cache.Zone = "us-east-1a"
+	bc.Node.PublicURL = "https://beskar-0.example.com"
+	bc.Registry.HTTP.Secret = "secret"
+	require.Equal(t, fingerprint, ConfigFingerprint(bc))
+
+	bc.Cache.Size = 128 * MiB
+	require.NotEqual(t, fingerprint, ConfigFingerprint(bc))
+}
+
+func TestParseBeskarConfigGossipKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		enabled string
+		tls     bool
+		caKey   string
+		wantErr string
+	}{
+		{
+			name: "aes-128 key",
+			key:  base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		},
+		{
+			name:    "missing key",
+			key:     "",
+			wantErr: "gossip key is missing",
+		},
+		{
+			name:    "invalid base64",
+			key:     "not-base64!",
+			wantErr: "gossip key is not valid base64",
+		},
+		{
+			name:    "invalid AES length",
+			key:     base64.StdEncoding.EncodeToString(make([]byte, 10)),
+			wantErr: "gossip key is 10 bytes long, it must be 16, 24 or 32 bytes long",
+		},
+		{
+			name:    "invalid key in single node mode",
+			key:     "not-base64!",
+			enabled: "false",
+		},
+		{
+			name: "tls without key",
+			key:  "",
+			tls:  true,
+		},
+		{
+			name:    "tls with key",
+			key:     base64.StdEncoding.EncodeToString(make([]byte, 16)),
+			tls:     true,
+			wantErr: "gossip key and tls are mutually exclusive",
+		},
+		{
+			name:    "tls without CA key",
+			key:     "",
+			tls:     true,
+			caKey:   `""`,
+			wantErr: "gossip tls requires a ca-cert and a ca-key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			replacements := []string{"XD1IOhcp0HWFgZJ/HAaARqMKJwfMWtz284Yj7wxmerA=", tt.key}
+			if tt.enabled != "" {
+				replacements = append(replacements, "  enabled: true\n  addr: 0.0.0.0:5102", "  enabled: "+tt.enabled+"\n  addr: 0.0.0.0:5102")
+			}
+			if tt.tls {
+				replacements = append(replacements, "  tls:\n    enabled: false", "  tls:\n    enabled: true")
+			}
+			if tt.caKey != "" {
+				replacements = append(replacements, "ca-key: /path/to/ca/key", "ca-key: "+tt.caKey)
+			}
+			config := strings.NewReplacer(replacements...).Replace(defaultBeskarConfig)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			_, err = ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestParseBeskarConfigPluginTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestTimeout string
+		wantTimeout    time.Duration
+		wantErr        string
+	}{
+		{
+			name:           "no timeout",
+			requestTimeout: "0s",
+		},
+		{
+			name:           "plugin timeout",
+			requestTimeout: "30s",
+			wantTimeout:    30 * time.Second,
+		},
+		{
+			name:           "negative plugin timeout",
+			requestTimeout: "-1s",
+			wantErr:        "plugin yum request-timeout must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.ReplaceAll(defaultBeskarConfig, "request-timeout: 0s", "request-timeout: "+tt.requestTimeout)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, tt.wantTimeout, bc.Plugins["yum"].RequestTimeout)
+		})
+	}
+}
+
+func TestParseBeskarConfigPluginRouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		conflicts string
+		order     string
+		wantOrder []string
+		wantErr   string
+	}{
+		{
+			name:      "default",
+			prefix:    "/yum",
+			conflicts: "warn",
+			order:     "[]",
+			wantOrder: []string{"yum"},
+		},
+		{
+			name:      "conflict warning",
+			prefix:    "/v2/yum",
+			conflicts: "warn",
+			order:     "[yum]",
+			wantOrder: []string{"yum"},
+		},
+		{
+			name:      "conflict error",
+			prefix:    "/v2/yum",
+			conflicts: "error",
+			order:     "[]",
+			wantErr:   "plugin prefix conflicts: plugin yum prefix /v2/yum overlaps the reserved path /v2/",
+		},
+		{
+			name:      "unknown conflicts mode",
+			prefix:    "/yum",
+			conflicts: "ignore",
+			order:     "[]",
+			wantErr:   `plugin-routing conflicts must be either "warn" or "error"`,
+		},
+		{
+			name:      "unknown order plugin",
+			prefix:    "/yum",
+			conflicts: "warn",
+			order:     "[iso]",
+			wantErr:   "plugin-routing order plugin iso is not configured",
+		},
+		{
+			name:      "duplicated order plugin",
+			prefix:    "/yum",
+			conflicts: "warn",
+			order:     "[yum, yum]",
+			wantErr:   "plugin-routing order plugin yum is listed more than once",
+		},
+		{
+			name:      "relative prefix",
+			prefix:    "yum",
+			conflicts: "warn",
+			order:     "[]",
+			wantErr:   "plugin yum prefix must start with a slash",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.NewReplacer(
+				"prefix: /yum", "prefix: "+tt.prefix,
+				"conflicts: warn", "conflicts: "+tt.conflicts,
+				"order: []", "order: "+tt.order,
+			).Replace(defaultBeskarConfig)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOrder, bc.PluginRouting.ResolutionOrder(bc.Plugins))
+
+			routes := make([]string, 0, len(tt.wantOrder))
+			for _, route := range bc.RoutingTable() {
+				routes = append(routes, route.Plugin)
+			}
+			require.Equal(t, tt.wantOrder, routes)
+		})
+	}
+}
+
+func TestParseBeskarConfigPluginResponseBuffering(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		wantMode string
+		wantErr  string
+	}{
+		{
+			name:     "default",
+			mode:     `""`,
+			wantMode: PluginResponseStream,
+		},
+		{
+			name:     "buffer",
+			mode:     "buffer",
+			wantMode: PluginResponseBuffer,
+		},
+		{
+			name:    "unknown mode",
+			mode:    "cache",
+			wantErr: `plugin yum response-buffering must be either "stream" or "buffer"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.ReplaceAll(defaultBeskarConfig, "response-buffering: stream", "response-buffering: "+tt.mode)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantMode, bc.Plugins["yum"].ResponseBuffering)
+		})
+	}
+}
+
+func TestParseBeskarConfigCacheReconciliation(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		wantPolicy string
+		wantErr    string
+	}{
+		{
+			name:       "default",
+			policy:     `""`,
+			wantPolicy: DefaultCacheReconciliation,
+		},
+		{
+			name:       "storage authoritative",
+			policy:     "storage-authoritative",
+			wantPolicy: CacheReconciliationStorageAuthoritative,
+		},
+		{
+			name:    "unknown policy",
+			policy:  "storage",
+			wantErr: `cache reconciliation must be either "cache-tolerant" or "storage-authoritative"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.ReplaceAll(defaultBeskarConfig, "reconciliation: cache-tolerant", "reconciliation: "+tt.policy)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPolicy, bc.Cache.Reconciliation)
+		})
+	}
+}
+
+func TestParseBeskarConfigRateLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		global        string
+		plugin        string
+		wantRateLimit RateLimit
+		wantErr       string
+	}{
+		{
+			name: "unlimited",
+		},
+		{
+			name:          "global",
+			global:        "rate: 2.5",
+			wantRateLimit: RateLimit{Rate: 2.5, Burst: 3},
+		},
+		{
+			name:          "plugin",
+			plugin:        "rate: 1\n      burst: 5",
+			wantRateLimit: RateLimit{Rate: 1, Burst: 5},
+		},
+		{
+			name:          "plugin override",
+			global:        "rate: 100",
+			plugin:        "rate: 1",
+			wantRateLimit: RateLimit{Rate: 1, Burst: 1},
+		},
+		{
+			name:   "plugin unlimited override",
+			global: "rate: 100",
+			plugin: "rate: 0",
+		},
+		{
+			name:    "negative global rate",
+			global:  "rate: -1",
+			wantErr: "ratelimit rate must be positive",
+		},
+		{
+			name:    "negative plugin burst",
+			plugin:  "rate: 1\n      burst: -1",
+			wantErr: "plugin yum ratelimit burst must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := defaultBeskarConfig
+			if tt.global != "" {
+				config = strings.Replace(config, "ratelimit:\n  rate: 0", "ratelimit:\n  "+tt.global, 1)
+			}
+			if tt.plugin != "" {
+				config = strings.Replace(config, "    backends:", "    ratelimit:\n      "+tt.plugin+"\n    backends:", 1)
+			}
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantRateLimit, bc.PluginRateLimit("yum"))
+		})
+	}
+}
+
+func TestParseBeskarConfigGossipClusters(t *testing.T) {
+	controlKey := "g0xkceEWaLS5fCdDL6CeCvBm+wPwsZgsIUkvG4orPqk="
+
+	tests := []struct {
+		name      string
+		clusters  string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "default cluster only",
+			clusters:  "{}",
+			wantNames: []string{DefaultGossipClusterName},
+		},
+		{
+			name:      "named clusters",
+			clusters:  "\n  control:\n    addr: 127.0.0.1:5104\n    key: " + controlKey + "\n  disabled:\n    enabled: false",
+			wantNames: []string{DefaultGossipClusterName, "control"},
+		},
+		{
+			name:     "empty name",
+			clusters: "\n  \"\":\n    addr: 127.0.0.1:5104\n    key: " + controlKey,
+			wantErr:  "gossip-clusters names can't be empty",
+		},
+		{
+			name:     "invalid key",
+			clusters: "\n  control:\n    addr: 127.0.0.1:5104\n    key: short",
+			wantErr:  "gossip-clusters control: gossip key is not valid base64",
+		},
+		{
+			name:     "address conflict",
+			clusters: "\n  control:\n    addr: 0.0.0.0:5102\n    key: " + controlKey,
+			wantErr:  "gossip-clusters control addr 0.0.0.0:5102 is already used by gossip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarConfig, "gossip-clusters: {}", "gossip-clusters: "+tt.clusters, 1)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantNames, bc.GossipClusterNames())
+
+			defaultConfig, ok := bc.GossipCluster(DefaultGossipClusterName)
+			require.True(t, ok)
+			require.Same(t, bc, defaultConfig)
+
+			_, ok = bc.GossipCluster("unknown")
+			require.False(t, ok)
+
+			if len(tt.wantNames) == 1 {
+				return
+			}
+
+			controlConfig, ok := bc.GossipCluster("control")
+			require.True(t, ok)
+			require.Equal(t, "control", controlConfig.Gossip.Cluster)
+			require.Equal(t, controlKey, controlConfig.Gossip.Key)
+			require.Equal(t, DefaultGossipJoinRetries, controlConfig.Gossip.JoinRetryCount())
+			require.Equal(t, bc.Cache, controlConfig.Cache)
+			require.Equal(t, DefaultGossipCluster, bc.Gossip.Cluster)
+		})
+	}
+}
+
+func TestParseBeskarConfigPluginErrorResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses string
+		wantErr   string
+	}{
+		{
+			name:      "none",
+			responses: "[]",
+		},
+		{
+			name:      "valid",
+			responses: "\n    - errors: [unavailable, timeout]\n      status: 404\n      body: \"{{ .Path }} not found\"",
+		},
+		{
+			name:      "unknown error",
+			responses: "\n    - errors: [refused]",
+			wantErr:   `plugin yum error-responses[0] errors must be either "timeout" or "unavailable"`,
+		},
+		{
+			name:      "invalid status",
+			responses: "\n    - status: 999",
+			wantErr:   "plugin yum error-responses[0] status 999 is not a valid HTTP status",
+		},
+		{
+			name:      "success status",
+			responses: "\n    - status: 200",
+			wantErr:   "plugin yum error-responses[0] status 200 must be a 4xx or 5xx HTTP status",
+		},
+		{
+			name:      "redirect status",
+			responses: "\n    - status: 302",
+			wantErr:   "plugin yum error-responses[0] status 302 must be a 4xx or 5xx HTTP status",
+		},
+		{
+			name:      "invalid template",
+			responses: "\n    - body: \"{{ .Path \"",
+			wantErr:   "while parsing plugin yum error-responses[0] body template",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarConfig, "error-responses: []", "error-responses: "+tt.responses, 1)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			_, err = ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestParseBeskarConfigPluginHealth(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency string
+		timeout     string
+		want        PluginHealth
+		wantErr     string
+	}{
+		{
+			name:        "default",
+			concurrency: "0",
+			timeout:     "0s",
+			want: PluginHealth{
+				Concurrency: DefaultPluginHealthConcurrency,
+				Timeout:     DefaultPluginHealthTimeout,
+			},
+		},
+		{
+			name:        "custom",
+			concurrency: "2",
+			timeout:     "500ms",
+			want:        PluginHealth{Concurrency: 2, Timeout: 500 * time.Millisecond},
+		},
+		{
+			name:        "negative concurrency",
+			concurrency: "-1",
+			timeout:     "2s",
+			wantErr:     "plugin-health concurrency must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(
+				defaultBeskarConfig,
+				"plugin-health:\n  concurrency: 8\n  timeout: 2s",
+				"plugin-health:\n  concurrency: "+tt.concurrency+"\n  timeout: "+tt.timeout,
+				1,
+			)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.PluginHealth)
+		})
+	}
+}
+
+func TestParseBeskarConfigTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "cidrs",
+			proxies: "[10.0.0.0/8, 192.0.2.1/32, fd00::/8]",
+			want:    []string{"10.0.0.0/8", "192.0.2.1/32", "fd00::/8"},
+		},
+		{
+			name:    "address without prefix length",
+			proxies: "[10.0.0.1]",
+			wantErr: `trusted-proxies cidr "10.0.0.1" is invalid`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarConfig, "trusted-proxies: []", "trusted-proxies: "+tt.proxies, 1)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, bc.TrustedProxies)
+		})
+	}
+}
+
+func TestParseBeskarConfigIdentityHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies string
+		wantErr string
+	}{
+		{
+			name:    "trusted proxies",
+			proxies: "[10.0.0.0/8]",
+		},
+		{
+			name:    "no trusted proxy",
+			proxies: "[]",
+			wantErr: `trusted-proxies are required with the "header" identity source`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			config := strings.Replace(defaultBeskarConfig, "trusted-proxies: []", "trusted-proxies: "+tt.proxies, 1)
+			config = strings.Replace(config, "identity-source: mtls", "identity-source: header", 1)
+			config = strings.Replace(config, `identity-header: ""`, "identity-header: X-Forwarded-User", 1)
+
+			err := os.WriteFile(filepath.Join(dir, BeskarConfigFile), []byte(config), 0o600)
+			require.NoError(t, err)
+
+			bc, err := ParseBeskarConfig(dir)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, IdentitySourceHeader, bc.Authorization.IdentitySource)
+		})
+	}
 }
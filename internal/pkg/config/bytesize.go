@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	KiB ByteSize = 1 << 10
+	MiB ByteSize = 1 << 20
+	GiB ByteSize = 1 << 30
+	TiB ByteSize = 1 << 40
+)
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	// longest suffixes first
+	{"KiB", KiB},
+	{"MiB", MiB},
+	{"GiB", GiB},
+	{"TiB", TiB},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+// ByteSize is a size in bytes, it's expressed in configuration files either
+// with a unit suffix (eg: 512MiB, 1GB, 4096B) or as a number without unit
+// which is interpreted as MiB for backward compatibility.
+type ByteSize int64
+
+// ParseByteSize parses a size with an optional unit suffix,
+// sizes without unit are in MiB.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+
+	unit := MiB
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseUint(s, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	} else if ByteSize(value) > ByteSize(1<<63-1)/unit {
+		return 0, fmt.Errorf("size %q overflows", s)
+	}
+
+	return ByteSize(value) * unit, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (bs *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*bs = size
+	return nil
+}
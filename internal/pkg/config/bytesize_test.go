@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]ByteSize{
+		"64":      64 * MiB,
+		"512MiB":  512 * MiB,
+		"1 GiB":   GiB,
+		"4KiB":    4 * KiB,
+		"1GB":     1e9,
+		"100KB":   1e5,
+		"2TiB":    2 * TiB,
+		"4096B":   4096,
+		" 16MB  ": 16e6,
+	} {
+		size, err := ParseByteSize(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, size, s)
+	}
+
+	for _, s := range []string{"", "MiB", "-1MiB", "1.5GiB", "1PiB", "9999999999TiB"} {
+		_, err := ParseByteSize(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestByteSizeUnmarshalYAML(t *testing.T) {
+	var v struct {
+		Legacy ByteSize `yaml:"legacy"`
+		Unit   ByteSize `yaml:"unit"`
+	}
+
+	err := yaml.Unmarshal([]byte("legacy: 64\nunit: 512MiB\n"), &v)
+	require.NoError(t, err)
+	require.Equal(t, 64*MiB, v.Legacy)
+	require.Equal(t, 512*MiB, v.Unit)
+
+	err = yaml.Unmarshal([]byte("legacy: 64XB\n"), &v)
+	require.ErrorContains(t, err, "invalid size")
+}
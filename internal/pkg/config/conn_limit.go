@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// ConnLimitOverride overrides the connection limit of the client
+// IPs within the CIDR, a zero limit means no limit.
+type ConnLimitOverride struct {
+	CIDR  string `yaml:"cidr"`
+	PerIP int    `yaml:"per-ip"`
+}
+
+// ConnLimit limits the concurrent connections per client IP on the
+// registry and cache listeners, a zero limit means no limit. The first
+// override matching the client IP takes precedence over PerIP.
+type ConnLimit struct {
+	PerIP     int                 `yaml:"per-ip"`
+	Overrides []ConnLimitOverride `yaml:"overrides"`
+}
+
+// Enabled returns true if connections are limited for some clients.
+func (cl ConnLimit) Enabled() bool {
+	return cl.PerIP > 0 || len(cl.Overrides) > 0
+}
+
+func (cl *ConnLimit) setDefaults() error {
+	if cl.PerIP < 0 {
+		return fmt.Errorf("conn-limit per-ip must be positive")
+	}
+	for _, o := range cl.Overrides {
+		if _, _, err := net.ParseCIDR(o.CIDR); err != nil {
+			return fmt.Errorf("conn-limit override cidr %q is invalid: %w", o.CIDR, err)
+		} else if o.PerIP < 0 {
+			return fmt.Errorf("conn-limit override %s per-ip must be positive", o.CIDR)
+		}
+	}
+	return nil
+}
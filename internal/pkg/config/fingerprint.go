@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fingerprintNodePaths lists the settings which legitimately differ
+// between nodes of a cluster and are excluded from the fingerprint.
+var fingerprintNodePaths = map[string]struct{}{
+	"node":                  {},
+	"cache.zone":            {},
+	"gossip.advertise-addr": {},
+}
+
+// fingerprintSecretWords identifies settings holding secrets by name,
+// they are excluded from the fingerprint.
+var fingerprintSecretWords = []string{"secret", "password", "token", "key"}
+
+// ConfigFingerprint returns the hex encoded SHA-256 of the canonicalized
+// configuration, secrets and node specific settings are excluded so that
+// nodes of a cluster which loaded the same configuration share the same
+// fingerprint. An empty string is returned if the configuration can't be
+// serialized.
+func ConfigFingerprint(bc *BeskarConfig) string {
+	b, err := yaml.Marshal(bc)
+	if err != nil {
+		return ""
+	}
+
+	var tree interface{}
+	if err := yaml.Unmarshal(b, &tree); err != nil {
+		return ""
+	}
+
+	// JSON encoding sorts map keys
+	canonical, err := json.Marshal(canonicalize("", tree))
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalize converts YAML maps to string keyed maps and removes
+// the excluded settings.
+func canonicalize(path string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			name := fmt.Sprint(key)
+			childPath := name
+			if path != "" {
+				childPath = path + "." + name
+			}
+			if isFingerprintExcluded(childPath, name) {
+				continue
+			}
+			m[name] = canonicalize(childPath, value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = canonicalize(path, value)
+		}
+		return s
+	}
+	return value
+}
+
+func isFingerprintExcluded(path, name string) bool {
+	if _, ok := fingerprintNodePaths[path]; ok {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, word := range fingerprintSecretWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
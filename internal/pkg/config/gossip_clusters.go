@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// DefaultGossipClusterName is the name of the gossip cluster configured
+// by the gossip block, the gossip-clusters names can't be empty.
+const DefaultGossipClusterName = ""
+
+// checkGossipClusters checks that the named gossip clusters have a name
+// and that the enabled clusters don't listen on the same address.
+func checkGossipClusters(gossip Gossip, clusters map[string]Gossip) error {
+	addrs := make(map[string]string)
+	if gossip.IsEnabled() {
+		addrs[gossip.Addr] = "gossip"
+	}
+
+	for _, name := range sortedKeys(clusters) {
+		cluster := clusters[name]
+		if name == DefaultGossipClusterName {
+			return fmt.Errorf("gossip-clusters names can't be empty")
+		} else if !cluster.IsEnabled() {
+			continue
+		}
+
+		// random ports don't conflict
+		if _, port, _ := net.SplitHostPort(cluster.Addr); port == "0" {
+			continue
+		}
+
+		if other, ok := addrs[cluster.Addr]; ok {
+			return fmt.Errorf("gossip-clusters %s addr %s is already used by %s", name, cluster.Addr, other)
+		}
+		addrs[cluster.Addr] = "gossip-clusters " + name
+	}
+
+	return nil
+}
+
+func sortedKeys(clusters map[string]Gossip) []string {
+	names := make([]string, 0, len(clusters))
+	for name := range clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GossipClusterNames returns the names of the enabled gossip clusters
+// sorted by name, the default cluster comes first when enabled.
+func (bc *BeskarConfig) GossipClusterNames() []string {
+	names := make([]string, 0, len(bc.GossipClusters)+1)
+	if bc.Gossip.IsEnabled() {
+		names = append(names, DefaultGossipClusterName)
+	}
+	for _, name := range sortedKeys(bc.GossipClusters) {
+		if bc.GossipClusters[name].IsEnabled() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// GossipCluster returns the configuration with the gossip settings of
+// the named cluster in place of the gossip block, the configuration is
+// returned as is for the default cluster. The returned configuration is
+// a shallow copy sharing everything but the gossip settings.
+func (bc *BeskarConfig) GossipCluster(name string) (*BeskarConfig, bool) {
+	if name == DefaultGossipClusterName {
+		return bc, true
+	}
+
+	cluster, ok := bc.GossipClusters[name]
+	if !ok {
+		return nil, false
+	}
+
+	clusterConfig := *bc
+	clusterConfig.Gossip = cluster
+
+	return &clusterConfig, true
+}
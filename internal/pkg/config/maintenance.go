@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// MaintenanceBlockWrite blocks requests modifying data.
+	MaintenanceBlockWrite = "write"
+	// MaintenanceBlockRead blocks requests reading data.
+	MaintenanceBlockRead = "read"
+
+	DefaultMaintenanceRetryAfter = 2 * time.Minute
+)
+
+// Maintenance defines the maintenance mode, while enabled the blocked
+// operations are rejected with a 503 response and a Retry-After header.
+// It can be toggled at runtime through the admin API and doesn't affect
+// the node readiness. Runtime changes only apply to the node serving the
+// admin request, they must be repeated on each node of a cluster.
+type Maintenance struct {
+	Enabled bool `yaml:"enabled"`
+	// Block lists the blocked operations, writes only by default.
+	Block []string `yaml:"block"`
+	// Plugins restricts the maintenance to the plugin requests,
+	// the whole server is in maintenance when empty.
+	Plugins    []string      `yaml:"plugins"`
+	RetryAfter time.Duration `yaml:"retry-after"`
+}
+
+// SetDefaults sets the default maintenance settings and validates them
+// against the configured plugins.
+func (m *Maintenance) SetDefaults(plugins map[string]Plugin) error {
+	if len(m.Block) == 0 {
+		m.Block = []string{MaintenanceBlockWrite}
+	}
+	for _, operation := range m.Block {
+		if operation != MaintenanceBlockWrite && operation != MaintenanceBlockRead {
+			return fmt.Errorf("maintenance block operation must be either %q or %q", MaintenanceBlockWrite, MaintenanceBlockRead)
+		}
+	}
+
+	for _, name := range m.Plugins {
+		if _, ok := plugins[name]; !ok {
+			return fmt.Errorf("maintenance plugin %s is not configured", name)
+		}
+	}
+
+	if m.RetryAfter == 0 {
+		m.RetryAfter = DefaultMaintenanceRetryAfter
+	} else if m.RetryAfter < time.Second {
+		return fmt.Errorf("maintenance retry-after must be at least one second")
+	}
+
+	return nil
+}
+
+// Blocks returns true if the operation is blocked.
+func (m Maintenance) Blocks(operation string) bool {
+	for _, op := range m.Block {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
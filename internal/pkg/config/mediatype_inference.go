@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// DefaultGenericMediatypes are the config media types set by clients
+// which don't specify one, eg: ORAS uses application/vnd.unknown.config.v1+json.
+var DefaultGenericMediatypes = []string{
+	"",
+	"application/octet-stream",
+	"application/vnd.oci.empty.v1+json",
+	"application/vnd.unknown.config.v1+json",
+}
+
+// MediatypeInference routes manifests pushed with a generic config media
+// type to the plugin whose inference rules match the manifest layers. An
+// explicit config media type always takes precedence over the inference.
+type MediatypeInference struct {
+	Enabled           bool     `yaml:"enabled"`
+	GenericMediatypes []string `yaml:"generic-mediatypes"`
+}
+
+func (mi *MediatypeInference) setDefaults() {
+	if mi.GenericMediatypes == nil {
+		mi.GenericMediatypes = DefaultGenericMediatypes
+	}
+}
+
+// PluginInference defines the rules matching the layers of manifests with
+// a generic config media type routed to the plugin.
+type PluginInference struct {
+	// Filenames are path.Match patterns matched against the layer title
+	// annotation, eg: *.rpm.
+	Filenames []string `yaml:"filenames"`
+	// LayerMediatypes are matched against the layer media types.
+	LayerMediatypes []string `yaml:"layer-mediatypes"`
+}
+
+func (pi PluginInference) validate(plugin string) error {
+	for _, pattern := range pi.Filenames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("plugin %s inference filename pattern %q is invalid: %w", plugin, pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	MetricsBackendPrometheus    = "prometheus"
+	MetricsBackendOpenTelemetry = "opentelemetry"
+
+	DefaultMetricsExportInterval = time.Minute
+)
+
+// Metrics defines the backend metrics are emitted to. Metrics are exposed
+// in the Prometheus format with both backends, OpenTelemetry metrics are
+// also pushed to the OTLP HTTP collector of the exporter.
+type Metrics struct {
+	Backend  string          `yaml:"backend"`
+	Exporter MetricsExporter `yaml:"exporter"`
+}
+
+// MetricsExporter defines the OTLP HTTP collector receiving the metrics
+// every interval, the standard OTEL_EXPORTER_OTLP_* environment variables
+// apply to the unset fields.
+type MetricsExporter struct {
+	// Endpoint is the collector host:port.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure disables TLS for the collector connection.
+	Insecure bool          `yaml:"insecure"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (m *Metrics) setDefaults() error {
+	switch m.Backend {
+	case "":
+		m.Backend = MetricsBackendPrometheus
+	case MetricsBackendPrometheus, MetricsBackendOpenTelemetry:
+	default:
+		return fmt.Errorf("metrics backend must be either %q or %q", MetricsBackendPrometheus, MetricsBackendOpenTelemetry)
+	}
+
+	if m.Exporter.Interval < 0 {
+		return fmt.Errorf("metrics exporter interval must be positive")
+	} else if m.Exporter.Interval == 0 {
+		m.Exporter.Interval = DefaultMetricsExportInterval
+	}
+
+	return nil
+}
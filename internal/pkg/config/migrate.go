@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// beskarConfigMigrations lists the migrations of deprecated settings
+// to their current form, applied in order by MigrateConfig.
+var beskarConfigMigrations = []func(root *yaml.Node) error{
+	migrateRegistryLoglevel,
+	migrateCacheSizes,
+}
+
+// MigrateConfig rewrites a beskar configuration using deprecated settings
+// to the current schema, comments are preserved while the formatting may
+// change (indentation, blank lines). The migrated configuration is validated
+// before being returned.
+func MigrateConfig(oldYAML []byte) ([]byte, error) {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(oldYAML, &doc); err != nil {
+		return nil, fmt.Errorf("while decoding configuration: %w", err)
+	} else if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("configuration is not a YAML mapping")
+	}
+
+	root := doc.Content[0]
+
+	_, version := mappingValue(root, "version")
+	if version == nil {
+		return nil, fmt.Errorf("configuration version is missing")
+	} else if version.Value != "1.0" && version.Value != "1" {
+		return nil, fmt.Errorf("configuration version %s is not supported", version.Value)
+	}
+
+	for _, migrate := range beskarConfigMigrations {
+		if err := migrate(root); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := new(bytes.Buffer)
+
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("while encoding configuration: %w", err)
+	} else if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("while encoding configuration: %w", err)
+	}
+
+	if _, err := parseBeskarConfig(buf.Bytes(), false); err != nil {
+		return nil, fmt.Errorf("while validating migrated configuration: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// migrateRegistryLoglevel moves registry.loglevel to registry.log.level,
+// registry.log.level takes precedence when both are set.
+func migrateRegistryLoglevel(root *yaml.Node) error {
+	_, registry := mappingValue(root, "registry")
+	if registry == nil || registry.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	i, loglevel := mappingValue(registry, "loglevel")
+	if loglevel == nil {
+		return nil
+	}
+
+	_, log := mappingValue(registry, "log")
+	if log != nil && log.Kind != yaml.MappingNode {
+		return fmt.Errorf("registry.loglevel can't be migrated: registry.log is not a mapping")
+	}
+
+	key := registry.Content[i]
+	registry.Content = append(registry.Content[:i], registry.Content[i+2:]...)
+
+	if log == nil {
+		log = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		registry.Content = append(registry.Content, scalarNode("log", key.HeadComment), log)
+	}
+
+	if _, level := mappingValue(log, "level"); level == nil {
+		log.Content = append(log.Content, scalarNode("level", ""), loglevel)
+	}
+
+	return nil
+}
+
+// migrateCacheSizes adds the MiB unit to the cache sizes
+// expressed as numbers.
+func migrateCacheSizes(root *yaml.Node) error {
+	_, cache := mappingValue(root, "cache")
+	if cache == nil {
+		return nil
+	}
+
+	sizes := []*yaml.Node{cache}
+	if _, response := mappingValue(cache, "response"); response != nil {
+		sizes = append(sizes, response)
+	}
+
+	for _, m := range sizes {
+		if _, size := mappingValue(m, "size"); size != nil && size.Kind == yaml.ScalarNode && size.Tag == "!!int" {
+			size.Value += "MiB"
+			size.Tag = "!!str"
+		}
+	}
+
+	return nil
+}
+
+// mappingValue returns the index of the key and the value node
+// associated to the key of a mapping node, the value is nil if
+// the key is not found.
+func mappingValue(m *yaml.Node, key string) (int, *yaml.Node) {
+	if m.Kind != yaml.MappingNode {
+		return -1, nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i, m.Content[i+1]
+		}
+	}
+	return -1, nil
+}
+
+func scalarNode(value, headComment string) *yaml.Node {
+	return &yaml.Node{
+		Kind:        yaml.ScalarNode,
+		Tag:         "!!str",
+		Value:       value,
+		HeadComment: headComment,
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		replacer *strings.Replacer
+		want     []string
+		wantErr  string
+	}{
+		{
+			name:     "current",
+			replacer: strings.NewReplacer(),
+			want:     []string{"size: 64MiB", "# metrics backend, either prometheus or opentelemetry"},
+		},
+		{
+			name: "registry loglevel",
+			replacer: strings.NewReplacer(
+				"registry:\n  log:\n    fields:\n      service: beskar\n",
+				"registry:\n  # registry log level\n  loglevel: debug\n",
+			),
+			want: []string{"  # registry log level\n  log:\n    level: debug\n"},
+		},
+		{
+			name: "registry loglevel with log",
+			replacer: strings.NewReplacer(
+				"registry:\n  log:\n",
+				"registry:\n  loglevel: debug\n  log:\n",
+			),
+			want: []string{"  log:\n    fields:\n      service: beskar\n    level: debug\n"},
+		},
+		{
+			name: "registry loglevel with log level",
+			replacer: strings.NewReplacer(
+				"registry:\n  log:\n",
+				"registry:\n  loglevel: debug\n  log:\n    level: warn\n",
+			),
+			want: []string{"  log:\n    level: warn\n"},
+		},
+		{
+			name: "registry loglevel with log scalar",
+			replacer: strings.NewReplacer(
+				"registry:\n  log:\n    fields:\n      service: beskar\n",
+				"registry:\n  loglevel: debug\n  log: stdout\n",
+			),
+			wantErr: "registry.loglevel can't be migrated: registry.log is not a mapping",
+		},
+		{
+			name: "cache sizes",
+			replacer: strings.NewReplacer(
+				"size: 64MiB", "size: 128",
+				"ttl: 10s", "ttl: 10s\n    size: 32",
+			),
+			want: []string{"size: 128MiB", "size: 32MiB", "# memory used by cached objects"},
+		},
+		{
+			name:     "unsupported version",
+			replacer: strings.NewReplacer("version: 1.0", "version: 2.0"),
+			wantErr:  "configuration version 2.0 is not supported",
+		},
+		{
+			name:     "invalid",
+			replacer: strings.NewReplacer("size: 64MiB", "size: -1"),
+			wantErr:  "while validating migrated configuration",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated, err := MigrateConfig([]byte(tt.replacer.Replace(defaultBeskarConfig)))
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			for _, want := range tt.want {
+				require.Contains(t, string(migrated), want)
+			}
+			require.NotContains(t, string(migrated), "loglevel")
+
+			// migrations are idempotent
+			again, err := MigrateConfig(migrated)
+			require.NoError(t, err)
+			require.Equal(t, string(migrated), string(again))
+		})
+	}
+}
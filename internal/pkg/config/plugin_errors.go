@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"text/template"
+)
+
+const (
+	// PluginErrorTimeout matches the requests exceeding
+	// the plugin backend timeout.
+	PluginErrorTimeout = "timeout"
+	// PluginErrorUnavailable matches the requests which couldn't
+	// be sent to the plugin backend or whose response couldn't be
+	// read.
+	PluginErrorUnavailable = "unavailable"
+)
+
+// PluginErrorResponse replaces the response sent to clients when the
+// plugin backend fails, the body is a template executed with the failure
+// context, an html/template unless the content type isn't HTML. A zero
+// status keeps the default status, 502 or 504 on timeouts.
+type PluginErrorResponse struct {
+	// Errors are the failures replaced by the response, either
+	// timeout or unavailable, all failures match when empty.
+	Errors      []string `yaml:"errors"`
+	Status      int      `yaml:"status"`
+	ContentType string   `yaml:"content-type"`
+	Body        string   `yaml:"body"`
+}
+
+// Matches returns true if the response replaces the failure.
+func (r PluginErrorResponse) Matches(failure string) bool {
+	if len(r.Errors) == 0 {
+		return true
+	}
+	for _, e := range r.Errors {
+		if e == failure {
+			return true
+		}
+	}
+	return false
+}
+
+// PluginErrorTemplate is a parsed error response body template.
+type PluginErrorTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// Template returns the parsed body template, the template is parsed with
+// html/template when the content type is HTML or is unset as the content
+// type may then be detected as HTML by clients.
+func (r PluginErrorResponse) Template(name string) (PluginErrorTemplate, error) {
+	if r.isHTML() {
+		return htmltemplate.New(name).Option("missingkey=error").Parse(r.Body)
+	}
+	return template.New(name).Option("missingkey=error").Parse(r.Body)
+}
+
+func (r PluginErrorResponse) isHTML() bool {
+	if r.ContentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(r.ContentType)
+	return err != nil || mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func validatePluginErrorResponses(plugin string, responses []PluginErrorResponse) error {
+	for i, r := range responses {
+		for _, e := range r.Errors {
+			if e != PluginErrorTimeout && e != PluginErrorUnavailable {
+				return fmt.Errorf(
+					"plugin %s error-responses[%d] errors must be either %q or %q",
+					plugin, i, PluginErrorTimeout, PluginErrorUnavailable,
+				)
+			}
+		}
+		if r.Status != 0 && http.StatusText(r.Status) == "" {
+			return fmt.Errorf("plugin %s error-responses[%d] status %d is not a valid HTTP status", plugin, i, r.Status)
+		} else if r.Status != 0 && r.Status < http.StatusBadRequest {
+			return fmt.Errorf("plugin %s error-responses[%d] status %d must be a 4xx or 5xx HTTP status", plugin, i, r.Status)
+		}
+		if _, err := r.Template(plugin); err != nil {
+			return fmt.Errorf("while parsing plugin %s error-responses[%d] body template: %w", plugin, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultPluginHealthConcurrency = 8
+	DefaultPluginHealthTimeout     = 2 * time.Second
+)
+
+// PluginHealth defines how plugin backends are probed, at most
+// Concurrency backends are probed at once and a probe is abandoned
+// once Timeout is exceeded, the backend is then reported unhealthy.
+type PluginHealth struct {
+	Concurrency int           `yaml:"concurrency"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+func (ph *PluginHealth) setDefaults() error {
+	if ph.Concurrency < 0 {
+		return fmt.Errorf("plugin-health concurrency must be positive")
+	} else if ph.Concurrency == 0 {
+		ph.Concurrency = DefaultPluginHealthConcurrency
+	}
+	if ph.Timeout < 0 {
+		return fmt.Errorf("plugin-health timeout must be positive")
+	} else if ph.Timeout == 0 {
+		ph.Timeout = DefaultPluginHealthTimeout
+	}
+	return nil
+}
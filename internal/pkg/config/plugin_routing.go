@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PluginConflictsWarn logs a warning for each plugin prefix conflict.
+	PluginConflictsWarn = "warn"
+	// PluginConflictsError rejects the configuration on plugin prefix conflicts.
+	PluginConflictsError = "error"
+
+	DefaultPluginConflicts = PluginConflictsWarn
+)
+
+// ReservedPaths are the path prefixes served by beskar and the registry,
+// plugin prefixes overlapping them shadow registry repositories or
+// beskar endpoints.
+var ReservedPaths = []string{
+	"/v2/",
+	"/beskar/",
+	"/debug/",
+}
+
+// PluginRouting defines how plugin prefix conflicts are reported and
+// the order in which plugin prefixes are matched against requests. The
+// plugins listed by Order are matched first, the others are matched by
+// decreasing prefix length so that nested prefixes take precedence.
+type PluginRouting struct {
+	Conflicts string   `yaml:"conflicts"`
+	Order     []string `yaml:"order"`
+}
+
+// PluginConflict describes a plugin prefix overlapping a reserved path
+// or the prefix of another plugin, Other is empty for a reserved path.
+type PluginConflict struct {
+	Plugin      string
+	Prefix      string
+	Other       string
+	OtherPrefix string
+}
+
+func (pc PluginConflict) String() string {
+	if pc.Other == "" {
+		return fmt.Sprintf("plugin %s prefix %s overlaps the reserved path %s", pc.Plugin, pc.Prefix, pc.OtherPrefix)
+	}
+	return fmt.Sprintf("plugin %s prefix %s overlaps plugin %s prefix %s", pc.Plugin, pc.Prefix, pc.Other, pc.OtherPrefix)
+}
+
+// prefixesOverlap returns true if requests could be matched by both
+// prefixes, prefixes are matched as plain string prefixes by the router.
+func prefixesOverlap(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// PluginConflicts returns the conflicts between the plugin prefixes and
+// with the reserved paths sorted by plugin name.
+func PluginConflicts(plugins map[string]Plugin) []PluginConflict {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conflicts []PluginConflict
+
+	for i, name := range names {
+		prefix := plugins[name].Prefix
+
+		for _, path := range ReservedPaths {
+			if prefixesOverlap(prefix, path) {
+				conflicts = append(conflicts, PluginConflict{
+					Plugin:      name,
+					Prefix:      prefix,
+					OtherPrefix: path,
+				})
+			}
+		}
+		for _, other := range names[i+1:] {
+			if otherPrefix := plugins[other].Prefix; prefixesOverlap(prefix, otherPrefix) {
+				conflicts = append(conflicts, PluginConflict{
+					Plugin:      name,
+					Prefix:      prefix,
+					Other:       other,
+					OtherPrefix: otherPrefix,
+				})
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// ResolutionOrder returns the plugin names in the order their prefixes
+// are matched against requests.
+func (pr PluginRouting) ResolutionOrder(plugins map[string]Plugin) []string {
+	order := make([]string, 0, len(plugins))
+	ordered := make(map[string]struct{}, len(pr.Order))
+
+	for _, name := range pr.Order {
+		if _, ok := plugins[name]; ok {
+			order = append(order, name)
+			ordered[name] = struct{}{}
+		}
+	}
+
+	remaining := make([]string, 0, len(plugins)-len(order))
+	for name := range plugins {
+		if _, ok := ordered[name]; !ok {
+			remaining = append(remaining, name)
+		}
+	}
+
+	sort.Slice(remaining, func(i, j int) bool {
+		pi, pj := plugins[remaining[i]].Prefix, plugins[remaining[j]].Prefix
+		if len(pi) != len(pj) {
+			return len(pi) > len(pj)
+		}
+		return remaining[i] < remaining[j]
+	})
+
+	return append(order, remaining...)
+}
+
+func (pr *PluginRouting) setDefaults(plugins map[string]Plugin) error {
+	switch pr.Conflicts {
+	case "":
+		pr.Conflicts = DefaultPluginConflicts
+	case PluginConflictsWarn, PluginConflictsError:
+	default:
+		return fmt.Errorf("plugin-routing conflicts must be either %q or %q", PluginConflictsWarn, PluginConflictsError)
+	}
+
+	seen := make(map[string]struct{}, len(pr.Order))
+	for _, name := range pr.Order {
+		if _, ok := plugins[name]; !ok {
+			return fmt.Errorf("plugin-routing order plugin %s is not configured", name)
+		} else if _, ok := seen[name]; ok {
+			return fmt.Errorf("plugin-routing order plugin %s is listed more than once", name)
+		}
+		seen[name] = struct{}{}
+	}
+
+	for name, plugin := range plugins {
+		if !strings.HasPrefix(plugin.Prefix, "/") {
+			return fmt.Errorf("plugin %s prefix must start with a slash", name)
+		}
+	}
+
+	conflicts := PluginConflicts(plugins)
+	if len(conflicts) == 0 {
+		return nil
+	} else if pr.Conflicts == PluginConflictsError {
+		reasons := make([]string, 0, len(conflicts))
+		for _, conflict := range conflicts {
+			reasons = append(reasons, conflict.String())
+		}
+		return fmt.Errorf("plugin prefix conflicts: %s", strings.Join(reasons, ", "))
+	}
+
+	order := strings.Join(pr.ResolutionOrder(plugins), ", ")
+	for _, conflict := range conflicts {
+		logrus.Warnf("%s, plugins are matched in the order: %s", conflict, order)
+	}
+
+	return nil
+}
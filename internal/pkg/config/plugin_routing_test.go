@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginConflicts(t *testing.T) {
+	tests := []struct {
+		name          string
+		plugins       map[string]Plugin
+		wantConflicts []string
+	}{
+		{
+			name: "distinct prefixes",
+			plugins: map[string]Plugin{
+				"yum":    {Prefix: "/yum"},
+				"static": {Prefix: "/static"},
+			},
+		},
+		{
+			name: "same prefix",
+			plugins: map[string]Plugin{
+				"yum":  {Prefix: "/yum"},
+				"yum2": {Prefix: "/yum"},
+			},
+			wantConflicts: []string{
+				"plugin yum prefix /yum overlaps plugin yum2 prefix /yum",
+			},
+		},
+		{
+			name: "nested prefixes",
+			plugins: map[string]Plugin{
+				"artifacts": {Prefix: "/artifacts"},
+				"yum":       {Prefix: "/artifacts/yum"},
+			},
+			wantConflicts: []string{
+				"plugin artifacts prefix /artifacts overlaps plugin yum prefix /artifacts/yum",
+			},
+		},
+		{
+			name: "overlapping prefixes",
+			plugins: map[string]Plugin{
+				"yum":   {Prefix: "/yum"},
+				"yummy": {Prefix: "/yummy"},
+			},
+			wantConflicts: []string{
+				"plugin yum prefix /yum overlaps plugin yummy prefix /yummy",
+			},
+		},
+		{
+			name: "registry path",
+			plugins: map[string]Plugin{
+				"yum": {Prefix: "/v2/yum"},
+			},
+			wantConflicts: []string{
+				"plugin yum prefix /v2/yum overlaps the reserved path /v2/",
+			},
+		},
+		{
+			name: "reserved path shadowed",
+			plugins: map[string]Plugin{
+				"yum": {Prefix: "/bes"},
+			},
+			wantConflicts: []string{
+				"plugin yum prefix /bes overlaps the reserved path /beskar/",
+			},
+		},
+		{
+			name: "reserved path lookalike",
+			plugins: map[string]Plugin{
+				"yum": {Prefix: "/v2yum"},
+			},
+		},
+		{
+			name: "root prefix",
+			plugins: map[string]Plugin{
+				"all": {Prefix: "/"},
+				"yum": {Prefix: "/yum"},
+			},
+			wantConflicts: []string{
+				"plugin all prefix / overlaps the reserved path /v2/",
+				"plugin all prefix / overlaps the reserved path /beskar/",
+				"plugin all prefix / overlaps the reserved path /debug/",
+				"plugin all prefix / overlaps plugin yum prefix /yum",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conflicts []string
+			for _, conflict := range PluginConflicts(tt.plugins) {
+				conflicts = append(conflicts, conflict.String())
+			}
+			require.Equal(t, tt.wantConflicts, conflicts)
+		})
+	}
+}
+
+func TestPluginResolutionOrder(t *testing.T) {
+	plugins := map[string]Plugin{
+		"artifacts": {Prefix: "/artifacts"},
+		"yum":       {Prefix: "/artifacts/yum"},
+		"iso":       {Prefix: "/artifacts/iso"},
+		"static":    {Prefix: "/static"},
+	}
+
+	// nested prefixes are matched first by default
+	order := PluginRouting{}.ResolutionOrder(plugins)
+	require.Equal(t, []string{"iso", "yum", "artifacts", "static"}, order)
+
+	order = PluginRouting{Order: []string{"artifacts", "static"}}.ResolutionOrder(plugins)
+	require.Equal(t, []string{"artifacts", "static", "iso", "yum"}, order)
+}
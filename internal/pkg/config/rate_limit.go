@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"math"
+)
+
+// RateLimit limits the rate of the requests sent to plugins by each
+// client IP with a token bucket, a zero rate means no limit.
+type RateLimit struct {
+	// Rate is the number of requests allowed per second.
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of requests allowed at once, it
+	// defaults to the rate rounded up.
+	Burst int `yaml:"burst"`
+}
+
+// Enabled returns true if requests are limited.
+func (rl RateLimit) Enabled() bool {
+	return rl.Rate > 0
+}
+
+func (rl *RateLimit) setDefaults(name string) error {
+	if rl.Rate < 0 {
+		return fmt.Errorf("%s rate must be positive", name)
+	} else if rl.Burst < 0 {
+		return fmt.Errorf("%s burst must be positive", name)
+	}
+	if rl.Burst == 0 && rl.Enabled() {
+		rl.Burst = int(math.Ceil(rl.Rate))
+	}
+	return nil
+}
+
+// PluginRateLimit returns the rate limit of the plugin requests, the
+// plugin rate limit takes precedence over the global rate limit.
+func (bc *BeskarConfig) PluginRateLimit(name string) RateLimit {
+	if plugin, ok := bc.Plugins[name]; ok && plugin.RateLimit != nil {
+		return *plugin.RateLimit
+	}
+	return bc.RateLimit
+}
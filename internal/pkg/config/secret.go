@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// SecretResolver resolves the secret references of a scheme,
+// eg: vault://secret/beskar#gossip-key.
+type SecretResolver interface {
+	Resolve(ref *url.URL) (string, error)
+}
+
+// SecretResolverFunc is a function implementing SecretResolver.
+type SecretResolverFunc func(ref *url.URL) (string, error)
+
+func (f SecretResolverFunc) Resolve(ref *url.URL) (string, error) {
+	return f(ref)
+}
+
+var (
+	secretResolversMutex sync.RWMutex
+	secretResolvers      = map[string]SecretResolver{
+		"env":  SecretResolverFunc(resolveEnvSecret),
+		"file": SecretResolverFunc(resolveFileSecret),
+	}
+)
+
+// secretRefRegexp matches values referencing a secret.
+var secretRefRegexp = regexp.MustCompile(`^[a-z][a-z0-9+.-]*://`)
+
+// RegisterSecretResolver registers the resolver of a scheme, it must be
+// called before parsing the configuration. The built-in env and file
+// resolvers can be replaced. The package is internal, resolvers can only
+// be added by the beskar commands of this module.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	secretResolversMutex.Lock()
+	defer secretResolversMutex.Unlock()
+
+	secretResolvers[scheme] = resolver
+}
+
+// resolveSecret returns the value of a secret field, values of the form
+// scheme://... are resolved by the resolver registered for the scheme,
+// other values are returned as is.
+func resolveSecret(field, value string) (string, error) {
+	if !secretRefRegexp.MatchString(value) {
+		return value, nil
+	}
+
+	ref, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("%s secret reference is malformed: %w", field, err)
+	}
+
+	secretResolversMutex.RLock()
+	resolver, ok := secretResolvers[ref.Scheme]
+	secretResolversMutex.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("%s secret reference: no resolver registered for scheme %s", field, ref.Scheme)
+	}
+
+	secret, err := resolver.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("while resolving %s secret reference: %w", field, err)
+	}
+
+	return secret, nil
+}
+
+// resolveSecrets resolves the secret fields in place.
+func resolveSecrets(fields map[string]*string) error {
+	for field, value := range fields {
+		secret, err := resolveSecret(field, *value)
+		if err != nil {
+			return err
+		}
+		*value = secret
+	}
+	return nil
+}
+
+// registryStorageSecrets are the storage driver parameters holding secrets.
+var registryStorageSecrets = []string{"secretkey", "sessiontoken", "accountkey", "password"}
+
+// resolveRegistrySecrets resolves the registry HTTP secret, the storage
+// driver credentials and the accounts of the auth providers in place.
+func resolveRegistrySecrets(registry *configuration.Configuration) error {
+	if err := resolveSecrets(map[string]*string{
+		"registry.http.secret": &registry.HTTP.Secret,
+	}); err != nil {
+		return err
+	}
+
+	storageType := registry.Storage.Type()
+	if err := resolveParameterSecrets("registry.storage."+storageType, registry.Storage.Parameters(), registryStorageSecrets); err != nil {
+		return err
+	}
+
+	for name, params := range registry.Auth {
+		if err := resolveParameterSecrets("registry.auth."+name, params, []string{"account"}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveParameterSecrets resolves the string parameters listed in names
+// in place, prefix is the path of the parameters in the configuration.
+func resolveParameterSecrets(prefix string, params configuration.Parameters, names []string) error {
+	for _, name := range names {
+		value, ok := params[name].(string)
+		if !ok {
+			continue
+		}
+		secret, err := resolveSecret(prefix+"."+name, value)
+		if err != nil {
+			return err
+		}
+		params[name] = secret
+	}
+	return nil
+}
+
+// resolveEnvSecret resolves env://NAME references.
+func resolveEnvSecret(ref *url.URL) (string, error) {
+	name := ref.Host + ref.Path
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// resolveFileSecret resolves file:///path references, the trailing
+// newline of the file is removed.
+func resolveFileSecret(ref *url.URL) (string, error) {
+	data, err := os.ReadFile(ref.Host + ref.Path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
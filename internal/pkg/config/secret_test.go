@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0o600))
+
+	t.Setenv("BESKAR_TEST_SECRET", "from-env")
+
+	RegisterSecretResolver("vault", SecretResolverFunc(func(ref *url.URL) (string, error) {
+		if ref.Fragment == "" {
+			return "", fmt.Errorf("no field")
+		}
+		return ref.Host + ref.Path + ":" + ref.Fragment, nil
+	}))
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr string
+	}{
+		{value: "literal", want: "literal"},
+		{value: "", want: ""},
+		{value: "XD1IOhcp0HWFgZJ/HAaARqMKJwfMWtz284Yj7wxmerA=", want: "XD1IOhcp0HWFgZJ/HAaARqMKJwfMWtz284Yj7wxmerA="},
+		{value: "env://BESKAR_TEST_SECRET", want: "from-env"},
+		{value: "env://BESKAR_TEST_UNSET", wantErr: "environment variable BESKAR_TEST_UNSET is not set"},
+		{value: "file://" + secretFile, want: "from-file"},
+		{value: "file://" + filepath.Join(dir, "missing"), wantErr: "while resolving test secret reference"},
+		{value: "vault://secret/beskar#key", want: "secret/beskar:key"},
+		{value: "vault://secret/beskar", wantErr: "no field"},
+		{value: "kms://key", wantErr: "test secret reference: no resolver registered for scheme kms"},
+	}
+
+	for _, tt := range tests {
+		secret, err := resolveSecret("test", tt.value)
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr, "value %q", tt.value)
+			continue
+		}
+		require.NoError(t, err, "value %q", tt.value)
+		require.Equal(t, tt.want, secret, "value %q", tt.value)
+	}
+}
+
+func TestParseBeskarYumConfigSecrets(t *testing.T) {
+	skipWithoutFilesystemDriver(t)
+
+	dir := t.TempDir()
+
+	t.Setenv("BESKAR_TEST_REGISTRY_PASSWORD", "secret")
+
+	config := strings.Replace(defaultBeskarYumConfig, "password: beskar", "password: env://BESKAR_TEST_REGISTRY_PASSWORD", 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600))
+
+	bc, err := ParseBeskarYumConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, "secret", bc.Registry.Password)
+
+	config = strings.Replace(defaultBeskarYumConfig, "secret-access-key: minioadmin", "secret-access-key: kms://key", 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, BeskarYumConfigFile), []byte(config), 0o600))
+
+	_, err = ParseBeskarYumConfig(dir)
+	require.ErrorContains(t, err, "storage.s3.secret-access-key secret reference: no resolver registered for scheme kms")
+}
+
+func TestParseBeskarConfigRegistrySecrets(t *testing.T) {
+	t.Setenv("BESKAR_TEST_HTTP_SECRET", "http-secret")
+	t.Setenv("BESKAR_TEST_ACCOUNT", "beskar:hash")
+
+	config := strings.NewReplacer(
+		"net: tcp", "net: tcp\n    secret: env://BESKAR_TEST_HTTP_SECRET",
+		"account: beskar:$2y$10$wxHHFuYbK4y4wBqCSU7pROuocx9CyV6EXKNv8id0eJzZwKghjUnyC", "account: env://BESKAR_TEST_ACCOUNT",
+		"maxthreads: 100", "maxthreads: 100\n      password: file://"+filepath.Join(t.TempDir(), "missing"),
+	).Replace(defaultBeskarConfig)
+
+	_, err := parseBeskarConfig([]byte(config), false)
+	require.ErrorContains(t, err, "while resolving registry.storage.filesystem.password secret reference")
+
+	config = strings.Replace(config, "password: file://", "password: ", 1)
+
+	bc, err := parseBeskarConfig([]byte(config), false)
+	require.NoError(t, err)
+	require.Equal(t, "http-secret", bc.Registry.HTTP.Secret)
+	require.Equal(t, "beskar:hash", bc.Registry.Auth["beskar"]["account"])
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultServerReadHeaderTimeout protects against slowloris attacks.
+	DefaultServerReadHeaderTimeout = 5 * time.Second
+	// DefaultServerReadTimeout and DefaultServerWriteTimeout bound the
+	// whole request and response transfer, they must be large enough to
+	// transfer multi-GB layers over slow links.
+	DefaultServerReadTimeout  = 30 * time.Minute
+	DefaultServerWriteTimeout = 30 * time.Minute
+	DefaultServerIdleTimeout  = 2 * time.Minute
+)
+
+// ServerTimeouts defines the HTTP server timeouts, a zero value
+// means the default timeout.
+type ServerTimeouts struct {
+	ReadHeader time.Duration `yaml:"read-header"`
+	Read       time.Duration `yaml:"read"`
+	Write      time.Duration `yaml:"write"`
+	Idle       time.Duration `yaml:"idle"`
+}
+
+type Server struct {
+	Timeouts ServerTimeouts `yaml:"timeouts"`
+}
+
+// Apply sets the timeouts of the HTTP server.
+func (st ServerTimeouts) Apply(server *http.Server) {
+	server.ReadHeaderTimeout = st.ReadHeader
+	server.ReadTimeout = st.Read
+	server.WriteTimeout = st.Write
+	server.IdleTimeout = st.Idle
+}
+
+func (st *ServerTimeouts) setDefaults() error {
+	for _, timeout := range []struct {
+		name         string
+		value        *time.Duration
+		defaultValue time.Duration
+	}{
+		{"read-header", &st.ReadHeader, DefaultServerReadHeaderTimeout},
+		{"read", &st.Read, DefaultServerReadTimeout},
+		{"write", &st.Write, DefaultServerWriteTimeout},
+		{"idle", &st.Idle, DefaultServerIdleTimeout},
+	} {
+		if *timeout.value == 0 {
+			*timeout.value = timeout.defaultValue
+		} else if *timeout.value < 0 {
+			return fmt.Errorf("server %s timeout must be positive", timeout.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !exclude_s3
+
+package config
+
+func init() {
+	registerStorageDriver(S3StorageDriver)
+}
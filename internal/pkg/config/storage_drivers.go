@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "sort"
+
+// storageDrivers is the set of storage drivers enabled in this build,
+// drivers are excluded with the exclude_<driver> build tags
+// (eg: exclude_gcs,exclude_azure).
+var storageDrivers = map[string]struct{}{
+	InMemoryStorageDriver: {},
+}
+
+func registerStorageDriver(driver string) {
+	storageDrivers[driver] = struct{}{}
+}
+
+// StorageDrivers returns the sorted list of storage drivers
+// enabled in this build.
+func StorageDrivers() []string {
+	drivers := make([]string, 0, len(storageDrivers))
+	for driver := range storageDrivers {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+	return drivers
+}
+
+// StorageDriverEnabled returns true if the storage driver
+// is enabled in this build.
+func StorageDriverEnabled(driver string) bool {
+	_, ok := storageDrivers[driver]
+	return ok
+}
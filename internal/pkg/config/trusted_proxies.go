@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// validateTrustedProxies checks that the trusted proxies are CIDRs,
+// single addresses must be written with a /32 or /128 prefix length.
+func validateTrustedProxies(proxies []string) error {
+	for _, cidr := range proxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("trusted-proxies cidr %q is invalid: %w", cidr, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// CASecretKey is the key of the CA certificate in the mirrored Secret.
+const CASecretKey = "ca.crt"
+
+// MirrorCASecret writes the CA certificate to the configured Kubernetes
+// Secret so components outside of the gossip cluster can trust it, the
+// Secret is created if it doesn't exist and other keys of an existing
+// Secret are preserved. It's a no-op when no Secret is configured or when
+// not running in Kubernetes. The caller needs RBAC permissions to get,
+// create and update Secrets.
+func MirrorCASecret(ctx context.Context, beskarConfig *config.BeskarConfig, client kubernetes.Interface, caCert []byte) error {
+	caSecret := beskarConfig.Gossip.CASecret
+	if caSecret.Name == "" || !beskarConfig.RunInKubernetes() {
+		return nil
+	}
+
+	namespace := caSecret.Namespace
+	if namespace == "" {
+		var err error
+
+		namespace, err = podNamespace()
+		if err != nil {
+			return fmt.Errorf("while reading pod namespace: %w", err)
+		}
+	}
+
+	client, err := KubernetesClient(client)
+	if err != nil {
+		return err
+	}
+
+	secrets := client.CoreV1().Secrets(namespace)
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, caSecret.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = secrets.Create(ctx, &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      caSecret.Name,
+					Namespace: namespace,
+					Labels: map[string]string{
+						GossipLabelKey: beskarConfig.Gossip.Cluster,
+					},
+				},
+				Type: v1.SecretTypeOpaque,
+				Data: map[string][]byte{
+					CASecretKey: caCert,
+				},
+			}, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// created concurrently by another member, retried as an update
+				return apierrors.NewConflict(v1.Resource("secrets"), caSecret.Name, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		} else if bytes.Equal(secret.Data[CASecretKey], caCert) {
+			return nil
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[CASecretKey] = caCert
+
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("while mirroring CA to secret %s/%s: %w", namespace, caSecret.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestMirrorCASecret(t *testing.T) {
+	ctx := context.Background()
+
+	beskarConfig := &config.BeskarConfig{
+		Gossip: config.Gossip{
+			Cluster: "beskar",
+			CASecret: config.GossipCASecret{
+				Name:      "beskar-ca",
+				Namespace: "beskar",
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset()
+
+	getSecret := func() *v1.Secret {
+		secret, err := client.CoreV1().Secrets("beskar").Get(ctx, "beskar-ca", metav1.GetOptions{})
+		require.NoError(t, err)
+		return secret
+	}
+
+	// no-op when not running in kubernetes
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	require.NoError(t, MirrorCASecret(ctx, beskarConfig, client, []byte("ca1")))
+	_, err := client.CoreV1().Secrets("beskar").Get(ctx, "beskar-ca", metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+
+	require.NoError(t, MirrorCASecret(ctx, beskarConfig, client, []byte("ca1")))
+	secret := getSecret()
+	require.Equal(t, map[string][]byte{CASecretKey: []byte("ca1")}, secret.Data)
+	require.Equal(t, "beskar", secret.Labels[GossipLabelKey])
+
+	// other keys are preserved on update
+	secret.Data["extra"] = []byte("extra")
+	_, err = client.CoreV1().Secrets("beskar").Update(ctx, secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, MirrorCASecret(ctx, beskarConfig, client, []byte("ca2")))
+	require.Equal(t, map[string][]byte{
+		CASecretKey: []byte("ca2"),
+		"extra":     []byte("extra"),
+	}, getSecret().Data)
+
+	// missing permissions
+	client.PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(v1.Resource("secrets"), "beskar-ca", nil)
+	})
+	err = MirrorCASecret(ctx, beskarConfig, client, []byte("ca3"))
+	require.ErrorContains(t, err, "while mirroring CA to secret beskar/beskar-ca")
+	require.True(t, apierrors.IsForbidden(err))
+}
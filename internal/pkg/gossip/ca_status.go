@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"go.ciq.dev/beskar/pkg/mtls"
+)
+
+const (
+	// CARotationNone reports that the local node uses the cluster CA.
+	CARotationNone = "none"
+	// CARotationInProgress reports that the local node is provisioned
+	// with a CA different from the cluster CA, like when the CA files of
+	// the nodes are replaced one node at a time. A CA generated by the
+	// node is replaced by the cluster CA when joining.
+	CARotationInProgress = "in-progress"
+)
+
+// CAStatus describes the CA shared by the cluster members.
+type CAStatus struct {
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	// Fingerprint is the hex encoded SHA-256 digest
+	// of the DER encoded certificate.
+	Fingerprint string `json:"fingerprint"`
+	Rotation    string `json:"rotation"`
+}
+
+// CAStatus returns the status of the cluster CA agreed when joining the
+// cluster, the CA received from the peers takes precedence over the local
+// CA. The CA expiry gauge is updated with the returned status.
+func (member *Member) CAStatus() (CAStatus, error) {
+	if member == nil {
+		return CAStatus{}, errNoMember
+	}
+
+	member.nd.stateMutex.Lock()
+	localState, remoteState := member.nd.localState, member.nd.remoteState
+	clusterState, localGenerated := member.nd.clusterState, member.nd.localGenerated
+	member.nd.stateMutex.Unlock()
+
+	state := clusterState
+	if state == nil {
+		state = remoteState
+	}
+	if state == nil {
+		state = localState
+	}
+	if state == nil {
+		return CAStatus{}, fmt.Errorf("no CA received from peers or set locally")
+	}
+
+	cert, err := decodeCACertificate(state)
+	if err != nil {
+		return CAStatus{}, err
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	status := CAStatus{
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Rotation:    CARotationNone,
+	}
+
+	// a generated CA is replaced by the cluster CA once joined
+	if localState != nil && !localGenerated {
+		localCert, err := decodeCACertificate(localState)
+		if err != nil || !localCert.Equal(cert) {
+			status.Rotation = CARotationInProgress
+		}
+	}
+
+	caExpiryGauge.Set(float64(cert.NotAfter.Unix()))
+
+	return status, nil
+}
+
+// decodeCACertificate returns the CA certificate of the gossip state.
+func decodeCACertificate(state []byte) (*x509.Certificate, error) {
+	caPem, err := mtls.UnmarshalCAPEM(state)
+	if err != nil {
+		return nil, fmt.Errorf("while unmarshalling CA certificates: %w", err)
+	}
+
+	block, _ := pem.Decode(caPem.Cert)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM CA certificate found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing CA certificate: %w", err)
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/pkg/mtls"
+)
+
+func generateCAState(t *testing.T, validity time.Time) []byte {
+	t.Helper()
+
+	caCert, caKey, err := mtls.GenerateCA("beskar", validity, mtls.ECDSAKey)
+	require.NoError(t, err)
+
+	state, err := mtls.MarshalCAPEM(&mtls.CAPEM{Cert: caCert, Key: caKey})
+	require.NoError(t, err)
+
+	return state
+}
+
+func TestMemberCAStatus(t *testing.T) {
+	_, err := (*Member)(nil).CAStatus()
+	require.ErrorIs(t, err, errNoMember)
+
+	member := &Member{nd: &nodeDelegate{}}
+
+	_, err = member.CAStatus()
+	require.ErrorContains(t, err, "no CA received from peers or set locally")
+
+	notAfter := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	localState := generateCAState(t, notAfter)
+
+	// CA set locally by the first cluster member
+	member.nd.localState = localState
+
+	status, err := member.CAStatus()
+	require.NoError(t, err)
+	require.True(t, status.NotAfter.Equal(notAfter))
+	require.True(t, status.NotBefore.Before(notAfter))
+	require.Len(t, status.Fingerprint, 64)
+	require.Equal(t, CARotationNone, status.Rotation)
+
+	// the CA received from peers takes precedence
+	clusterNotAfter := notAfter.Add(time.Hour)
+	member.nd.remoteState = generateCAState(t, clusterNotAfter)
+
+	clusterStatus, err := member.CAStatus()
+	require.NoError(t, err)
+	require.True(t, clusterStatus.NotAfter.Equal(clusterNotAfter))
+	require.NotEqual(t, status.Fingerprint, clusterStatus.Fingerprint)
+	require.Equal(t, CARotationInProgress, clusterStatus.Rotation)
+
+	// the local state is set to the received CA when there is none
+	member.nd.localState = member.nd.remoteState
+
+	clusterStatus, err = member.CAStatus()
+	require.NoError(t, err)
+	require.Equal(t, CARotationNone, clusterStatus.Rotation)
+
+	member.nd.remoteState = []byte(`{"cert":"bm90IGEgY2VydA=="}`)
+	_, err = member.CAStatus()
+	require.ErrorContains(t, err, "no PEM CA certificate found")
+}
+
+func TestMemberCAStatusStaticDiscovery(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	notAfter := time.Now().Add(24 * time.Hour)
+
+	// with static discovery each node generates its own CA
+	a, err := NewMember(
+		"a", nil,
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithGeneratedLocalState(generateCAState(t, notAfter)),
+	)
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	clusterStatus, err := a.CAStatus()
+	require.NoError(t, err)
+	require.Equal(t, CARotationNone, clusterStatus.Rotation)
+
+	b, err := NewMember(
+		"b", []string{a.LocalNode().Address()},
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithGeneratedLocalState(generateCAState(t, notAfter)),
+	)
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	// the generated CA is replaced by the cluster CA once joined
+	status, err := b.CAStatus()
+	require.NoError(t, err)
+	require.Equal(t, clusterStatus.Fingerprint, status.Fingerprint)
+	require.Equal(t, CARotationNone, status.Rotation)
+
+	localState, err := b.LocalState()
+	require.NoError(t, err)
+	remoteState, err := a.LocalState()
+	require.NoError(t, err)
+	require.Equal(t, remoteState, localState)
+
+	// the CA of the nodes joining later doesn't replace the cluster CA
+	status, err = a.CAStatus()
+	require.NoError(t, err)
+	require.Equal(t, clusterStatus, status)
+
+	// a provisioned CA is kept and reported as being rotated
+	c, err := NewMember(
+		"c", []string{b.LocalNode().Address()},
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithLocalState(generateCAState(t, notAfter)),
+	)
+	require.NoError(t, err)
+	defer c.ml.Shutdown()
+
+	status, err = c.CAStatus()
+	require.NoError(t, err)
+	require.Equal(t, clusterStatus.Fingerprint, status.Fingerprint)
+	require.Equal(t, CARotationInProgress, status.Rotation)
+}
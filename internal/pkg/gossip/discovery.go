@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/retry"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// StaticDiscovery uses the configured gossip peers.
+	StaticDiscovery = "static"
+	// KubernetesDiscovery lists the gossip endpoints of the pod namespace
+	// and of the configured namespaces.
+	KubernetesDiscovery = "kubernetes"
+)
+
+// PeerDiscoverer discovers the gossip address (host:port)
+// of the cluster peers.
+type PeerDiscoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// PeerDiscovererFactory returns the peer discoverer for a configuration.
+type PeerDiscovererFactory func(beskarConfig *config.BeskarConfig) (PeerDiscoverer, error)
+
+// leaderDiscoverer is implemented by discoverers electing the member
+// generating the CA when no peer is ready.
+type leaderDiscoverer interface {
+	discoverWithLeader(ctx context.Context) ([]string, string, error)
+}
+
+var (
+	peerDiscoverersMutex sync.RWMutex
+	peerDiscoverers      = map[string]PeerDiscovererFactory{
+		StaticDiscovery:     newStaticDiscoverer,
+		KubernetesDiscovery: newKubernetesDiscoverer,
+	}
+)
+
+// RegisterPeerDiscoverer registers a peer discovery provider selected by
+// the gossip discovery type, it must be called before starting the gossip
+// member. The built-in providers can be replaced. The package is internal,
+// providers can only be added by the beskar commands of this module,
+// eg: from an init function of a custom cmd/beskar build.
+func RegisterPeerDiscoverer(name string, factory PeerDiscovererFactory) {
+	peerDiscoverersMutex.Lock()
+	defer peerDiscoverersMutex.Unlock()
+
+	peerDiscoverers[name] = factory
+}
+
+// PeerDiscoverers returns the sorted names of the registered providers.
+func PeerDiscoverers() []string {
+	peerDiscoverersMutex.RLock()
+	defer peerDiscoverersMutex.RUnlock()
+
+	names := make([]string, 0, len(peerDiscoverers))
+	for name := range peerDiscoverers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// discoveryType returns the configured discovery type, it defaults
+// to kubernetes when running in kubernetes and to static otherwise.
+func discoveryType(beskarConfig *config.BeskarConfig) string {
+	if beskarConfig.Gossip.Discovery.Type != "" {
+		return beskarConfig.Gossip.Discovery.Type
+	} else if beskarConfig.RunInKubernetes() {
+		return KubernetesDiscovery
+	}
+	return StaticDiscovery
+}
+
+// newPeerDiscoverer returns the peer discoverer of the configured discovery
+// type, the kubernetes discoverer uses client and newBackoff when set.
+func newPeerDiscoverer(beskarConfig *config.BeskarConfig, client kubernetes.Interface, newBackoff retry.BackoffFactory) (PeerDiscoverer, error) {
+	name := discoveryType(beskarConfig)
+
+	peerDiscoverersMutex.RLock()
+	factory, ok := peerDiscoverers[name]
+	peerDiscoverersMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("gossip discovery type %q is not registered, registered types: %s", name, strings.Join(PeerDiscoverers(), ", "))
+	}
+
+	discoverer, err := factory(beskarConfig)
+	if err != nil {
+		return nil, fmt.Errorf("while creating %s gossip peer discoverer: %w", name, err)
+	}
+
+	if kd, ok := discoverer.(*kubernetesDiscoverer); ok {
+		if client != nil {
+			kd.client = client
+		}
+		if newBackoff != nil {
+			kd.newBackoff = newBackoff
+		}
+	}
+
+	return discoverer, nil
+}
+
+// getPeers returns the list of gossip peers discovered by the configured
+// provider along with the CA leader elected by the kubernetes provider
+// when no ready peer is found.
+func getPeers(ctx context.Context, beskarConfig *config.BeskarConfig, client kubernetes.Interface, newBackoff retry.BackoffFactory) ([]string, string, error) {
+	discoverer, err := newPeerDiscoverer(beskarConfig, client, newBackoff)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if ld, ok := discoverer.(leaderDiscoverer); ok {
+		return ld.discoverWithLeader(ctx)
+	}
+
+	peers, err := discoverer.Discover(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("while discovering gossip peers: %w", err)
+	}
+
+	return peers, "", nil
+}
+
+// staticDiscoverer returns the configured peers.
+type staticDiscoverer struct {
+	peers []string
+}
+
+func newStaticDiscoverer(beskarConfig *config.BeskarConfig) (PeerDiscoverer, error) {
+	return &staticDiscoverer{peers: beskarConfig.Gossip.Peers}, nil
+}
+
+func (sd *staticDiscoverer) Discover(context.Context) ([]string, error) {
+	return sd.peers, nil
+}
@@ -0,0 +1,382 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/netutil"
+	"go.ciq.dev/beskar/pkg/retry"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	GossipLabelKey = "go.ciq.dev/beskar-gossip"
+	namespaceFile  = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	// NodeNameEnv is the environment variable holding the name of
+	// the kubernetes node running the pod.
+	NodeNameEnv = "NODE_NAME"
+)
+
+// kubernetesDiscoverer discovers the peers from the endpoints labeled with
+// the gossip cluster name in the pod namespace and in the configured
+// namespaces, the endpoints listing is retried with a backoff created by
+// newBackoff until at least one peer is found or the context is cancelled.
+type kubernetesDiscoverer struct {
+	beskarConfig *config.BeskarConfig
+	client       kubernetes.Interface
+	newBackoff   retry.BackoffFactory
+	// namespace is the pod namespace, read from the service
+	// account namespace file when empty
+	namespace string
+	// namespaceSelector selects additional namespaces, nil
+	// when no selector is configured
+	namespaceSelector labels.Selector
+}
+
+func newKubernetesDiscoverer(beskarConfig *config.BeskarConfig) (PeerDiscoverer, error) {
+	kd := &kubernetesDiscoverer{
+		beskarConfig: beskarConfig,
+		newBackoff:   retry.DefaultBackoffFactory,
+	}
+
+	if selector := beskarConfig.Gossip.Discovery.Kubernetes.NamespaceSelector; selector != "" {
+		var err error
+
+		kd.namespaceSelector, err = labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing gossip discovery namespace selector: %w", err)
+		}
+	}
+
+	return kd, nil
+}
+
+// Discover returns the peers, the CA leader is returned as the only
+// peer when no ready peer is found.
+func (kd *kubernetesDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	peers, leader, err := kd.discoverWithLeader(ctx)
+	if leader != "" {
+		peers = append(peers, leader)
+	}
+	return peers, err
+}
+
+// discoverWithLeader returns the peers, when no ready peer is found, members
+// starting simultaneously all see zero peers, the starting member with the
+// lowest address is then elected to generate the CA and its gossip address
+// is returned as the leader for the other members to join it.
+func (kd *kubernetesDiscoverer) discoverWithLeader(ctx context.Context) ([]string, string, error) {
+	beskarConfig := kd.beskarConfig
+
+	namespace := kd.namespace
+	if namespace == "" {
+		var err error
+
+		namespace, err = podNamespace()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	client, err := KubernetesClient(kd.client)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := kd.checkAccess(ctx, client); err != nil {
+		return nil, "", err
+	}
+
+	podIP, err := getPodIP(beskarConfig)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		peers []string
+		// candidates are the ready and starting members
+		candidates []string
+		gossipPort int32
+	)
+
+	getPeers := func() error {
+		listCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		namespaces, err := kd.namespaces(listCtx, client, namespace)
+		if err != nil {
+			return err
+		}
+
+		var endpoints []v1.Endpoints
+
+		for _, ns := range namespaces {
+			endpointList, err := client.CoreV1().Endpoints(ns).List(listCtx, metav1.ListOptions{
+				LabelSelector: labels.Set(map[string]string{
+					GossipLabelKey: beskarConfig.Gossip.Cluster,
+				}).String(),
+			})
+			if apierrors.IsForbidden(err) {
+				return backoff.Permanent(fmt.Errorf("pod service account is not allowed to list endpoints in namespace %s: %w", ns, err))
+			} else if err != nil {
+				return fmt.Errorf("while listing endpoints in namespace %s: %w", ns, err)
+			}
+			endpoints = append(endpoints, endpointList.Items...)
+		}
+
+		var subsetIPs []string
+		// the configured peer port takes precedence over the endpoints port
+		gossipPort = int32(beskarConfig.Gossip.PeerPort)
+		discoverPort := gossipPort == 0
+		peers = nil
+		candidates = nil
+
+		for _, ep := range endpoints {
+			for _, subset := range ep.Subsets {
+				for _, port := range subset.Ports {
+					if discoverPort && port.Protocol == v1.ProtocolTCP {
+						gossipPort = port.Port
+						break
+					}
+				}
+				for _, address := range subset.Addresses {
+					subsetIPs = append(subsetIPs, address.IP)
+				}
+				for _, address := range subset.NotReadyAddresses {
+					candidates = append(candidates, address.IP)
+				}
+			}
+		}
+
+		candidates = append(candidates, subsetIPs...)
+
+		if gossipPort == 0 {
+			return fmt.Errorf("no gossip port found")
+		}
+
+		for _, ip := range subsetIPs {
+			if ip == podIP {
+				continue
+			}
+			peer := net.JoinHostPort(ip, fmt.Sprintf("%d", gossipPort))
+			peers = append(peers, peer)
+		}
+
+		if len(candidates) == 0 {
+			return fmt.Errorf("no gossip peer found")
+		}
+
+		return nil
+	}
+
+	if err := retry.Retry(ctx, kd.newBackoff, getPeers); err != nil {
+		return nil, "", fmt.Errorf("while discovering gossip peers: %w", err)
+	} else if len(peers) > 0 {
+		return peers, "", nil
+	}
+
+	// the local member may not be listed yet
+	leader := electCALeader(append(candidates, podIP))
+	if leader == "" || leader == podIP {
+		return nil, "", nil
+	}
+
+	return nil, net.JoinHostPort(leader, fmt.Sprintf("%d", gossipPort)), nil
+}
+
+// namespaces returns the namespaces whose endpoints are listed, the pod
+// namespace comes first followed by the configured and selected namespaces.
+func (kd *kubernetesDiscoverer) namespaces(ctx context.Context, client kubernetes.Interface, podNamespace string) ([]string, error) {
+	namespaces := []string{podNamespace}
+	seen := map[string]struct{}{podNamespace: {}}
+
+	add := func(namespace string) {
+		if _, ok := seen[namespace]; !ok {
+			seen[namespace] = struct{}{}
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	for _, namespace := range kd.beskarConfig.Gossip.Discovery.Kubernetes.Namespaces {
+		add(namespace)
+	}
+
+	if kd.namespaceSelector == nil {
+		return namespaces, nil
+	}
+
+	namespaceList, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: kd.namespaceSelector.String(),
+	})
+	if apierrors.IsForbidden(err) {
+		return nil, backoff.Permanent(fmt.Errorf("pod service account is not allowed to list namespaces: %w", err))
+	} else if err != nil {
+		return nil, fmt.Errorf("while listing namespaces: %w", err)
+	}
+
+	selected := make([]string, 0, len(namespaceList.Items))
+	for _, item := range namespaceList.Items {
+		selected = append(selected, item.Name)
+	}
+	sort.Strings(selected)
+
+	for _, namespace := range selected {
+		add(namespace)
+	}
+
+	return namespaces, nil
+}
+
+// checkAccess verifies that the pod service account is allowed to list the
+// endpoints of the configured namespaces and to list the namespaces when a
+// namespace selector is configured.
+func (kd *kubernetesDiscoverer) checkAccess(ctx context.Context, client kubernetes.Interface) error {
+	var attributes []authorizationv1.ResourceAttributes
+
+	for _, namespace := range kd.beskarConfig.Gossip.Discovery.Kubernetes.Namespaces {
+		attributes = append(attributes, authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "list",
+			Resource:  "endpoints",
+		})
+	}
+	if kd.namespaceSelector != nil {
+		attributes = append(attributes, authorizationv1.ResourceAttributes{
+			Verb:     "list",
+			Resource: "namespaces",
+		})
+	}
+
+	for i := range attributes {
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &attributes[i],
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("while reviewing gossip discovery access: %w", err)
+		} else if review.Status.Allowed {
+			continue
+		}
+
+		target := "namespaces"
+		if attributes[i].Namespace != "" {
+			target = fmt.Sprintf("%s in namespace %s", attributes[i].Resource, attributes[i].Namespace)
+		}
+		if review.Status.Reason != "" {
+			return fmt.Errorf("pod service account is not allowed to list %s: %s", target, review.Status.Reason)
+		}
+		return fmt.Errorf("pod service account is not allowed to list %s", target)
+	}
+
+	return nil
+}
+
+// electCALeader returns the lowest address of the candidates, the
+// ordering is the same for all members.
+func electCALeader(candidates []string) string {
+	leader := ""
+	var leaderIP net.IP
+
+	for _, candidate := range candidates {
+		ip := net.ParseIP(candidate)
+		if ip == nil {
+			continue
+		} else if leaderIP == nil || bytes.Compare(ip.To16(), leaderIP.To16()) < 0 {
+			leader = candidate
+			leaderIP = ip
+		}
+	}
+
+	return leader
+}
+
+// getPodIP returns the pod IP address used to exclude the local node from
+// the gossip peers, the configured advertise address takes precedence over
+// the route based detection.
+func getPodIP(beskarConfig *config.BeskarConfig) (string, error) {
+	if beskarConfig.Gossip.AdvertiseAddr != "" {
+		host, _, err := net.SplitHostPort(beskarConfig.Gossip.AdvertiseAddr)
+		return host, err
+	}
+	return netutil.RouteGetSourceAddress(os.Getenv("KUBERNETES_SERVICE_HOST"))
+}
+
+// resolveNodeZone sets the cache zone from the zone label of the kubernetes
+// node running the pod when no zone is configured, topology labels are set
+// on nodes and aren't available to pods through the downward API.
+func resolveNodeZone(ctx context.Context, beskarConfig *config.BeskarConfig, client kubernetes.Interface) error {
+	label := beskarConfig.Cache.ZoneNodeLabel
+	if beskarConfig.Cache.Zone != "" || label == "" {
+		return nil
+	}
+
+	nodeName := os.Getenv(NodeNameEnv)
+	if nodeName == "" {
+		return fmt.Errorf("%s environment variable not set", NodeNameEnv)
+	}
+
+	client, err := KubernetesClient(client)
+	if err != nil {
+		return err
+	}
+
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("while getting node %s: %w", nodeName, err)
+	}
+
+	zone, ok := node.Labels[label]
+	if !ok {
+		return fmt.Errorf("node %s has no %s label", nodeName, label)
+	}
+	beskarConfig.Cache.Zone = zone
+
+	return nil
+}
+
+// podNamespace returns the namespace of the pod.
+func podNamespace() (string, error) {
+	data, err := os.ReadFile(namespaceFile)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(data)), nil
+}
+
+// KubernetesClient returns the client if not nil
+// or an in-cluster client.
+func KubernetesClient(client kubernetes.Interface) (kubernetes.Interface, error) {
+	if client != nil {
+		return client, nil
+	}
+
+	inCluster, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("while getting k8s cluster configuration: %w", err)
+	}
+
+	client, err = kubernetes.NewForConfig(inCluster)
+	if err != nil {
+		return nil, fmt.Errorf("while instantiating k8s client: %w", err)
+	}
+
+	return client, nil
+}
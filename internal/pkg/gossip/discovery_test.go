@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/pkg/retry"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type testDiscoverer struct {
+	peers []string
+	err   error
+}
+
+func (td testDiscoverer) Discover(context.Context) ([]string, error) {
+	return td.peers, td.err
+}
+
+func TestGetPeersDiscovery(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	beskarConfig := &config.BeskarConfig{
+		Gossip: config.Gossip{
+			Peers: []string{"10.0.0.1:5102"},
+		},
+	}
+
+	// static by default outside of kubernetes
+	peers, leader, err := getPeers(context.Background(), beskarConfig, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:5102"}, peers)
+	require.Empty(t, leader)
+
+	beskarConfig.Gossip.Discovery.Type = "test"
+
+	_, _, err = getPeers(context.Background(), beskarConfig, nil, nil)
+	require.ErrorContains(t, err, `gossip discovery type "test" is not registered, registered types: kubernetes, static`)
+
+	discoverer := &testDiscoverer{peers: []string{"10.0.0.2:5102", "10.0.0.3:5102"}}
+
+	RegisterPeerDiscoverer("test", func(beskarConfig *config.BeskarConfig) (PeerDiscoverer, error) {
+		return discoverer, nil
+	})
+	defer func() {
+		peerDiscoverersMutex.Lock()
+		delete(peerDiscoverers, "test")
+		peerDiscoverersMutex.Unlock()
+	}()
+
+	require.Equal(t, []string{KubernetesDiscovery, StaticDiscovery, "test"}, PeerDiscoverers())
+
+	peers, leader, err = getPeers(context.Background(), beskarConfig, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.2:5102", "10.0.0.3:5102"}, peers)
+	require.Empty(t, leader)
+
+	discoverer.err = errors.New("unavailable")
+
+	_, _, err = getPeers(context.Background(), beskarConfig, nil, nil)
+	require.ErrorContains(t, err, "while discovering gossip peers: unavailable")
+}
+
+func TestKubernetesDiscoveryNamespaces(t *testing.T) {
+	gossipLabels := map[string]string{GossipLabelKey: "beskar"}
+
+	endpoints := func(namespace string, ips ...string) *v1.Endpoints {
+		var addresses []v1.EndpointAddress
+		for _, ip := range ips {
+			addresses = append(addresses, v1.EndpointAddress{IP: ip})
+		}
+		return &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "beskar", Namespace: namespace, Labels: gossipLabels},
+			Subsets:    []v1.EndpointSubset{{Addresses: addresses}},
+		}
+	}
+
+	namespace := func(name string, labels map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	tests := []struct {
+		name      string
+		discovery config.GossipKubernetesDiscovery
+		denied    string
+		wantPeers []string
+		wantErr   string
+	}{
+		{
+			name:      "pod namespace",
+			wantPeers: []string{"10.0.0.2:5102"},
+		},
+		{
+			name: "namespaces",
+			discovery: config.GossipKubernetesDiscovery{
+				Namespaces: []string{"extra", "beskar"},
+			},
+			wantPeers: []string{"10.0.0.2:5102", "10.0.1.1:5102"},
+		},
+		{
+			name: "namespace selector",
+			discovery: config.GossipKubernetesDiscovery{
+				Namespaces:        []string{"extra"},
+				NamespaceSelector: "federation=true",
+			},
+			wantPeers: []string{"10.0.0.2:5102", "10.0.1.1:5102", "10.0.2.1:5102"},
+		},
+		{
+			name: "namespace denied",
+			discovery: config.GossipKubernetesDiscovery{
+				Namespaces: []string{"extra"},
+			},
+			denied:  "extra",
+			wantErr: "pod service account is not allowed to list endpoints in namespace extra",
+		},
+		{
+			name: "namespaces list denied",
+			discovery: config.GossipKubernetesDiscovery{
+				NamespaceSelector: "federation=true",
+			},
+			denied:  "namespaces",
+			wantErr: "pod service account is not allowed to list namespaces",
+		},
+		{
+			name: "bad selector",
+			discovery: config.GossipKubernetesDiscovery{
+				NamespaceSelector: "federation=true=",
+			},
+			wantErr: "while parsing gossip discovery namespace selector",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(
+				endpoints("beskar", "10.0.0.1", "10.0.0.2"),
+				endpoints("extra", "10.0.1.1"),
+				endpoints("selected", "10.0.2.1"),
+				endpoints("ignored", "10.0.3.1"),
+				namespace("selected", map[string]string{"federation": "true"}),
+				namespace("ignored", nil),
+			)
+			client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				review.Status.Allowed = tt.denied == "" || (attributes.Namespace != tt.denied && attributes.Resource != tt.denied)
+				return true, review, nil
+			})
+
+			beskarConfig := &config.BeskarConfig{
+				Gossip: config.Gossip{
+					Cluster:       "beskar",
+					PeerPort:      5102,
+					AdvertiseAddr: "10.0.0.1:5102",
+					Discovery: config.GossipDiscovery{
+						Type:       KubernetesDiscovery,
+						Kubernetes: tt.discovery,
+					},
+				},
+			}
+
+			discoverer, err := newKubernetesDiscoverer(beskarConfig)
+			if err != nil {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			kd := discoverer.(*kubernetesDiscoverer)
+			kd.client = client
+			kd.namespace = "beskar"
+			kd.newBackoff = retry.ConstantBackoffFactory(0, 0)
+
+			peers, err := kd.Discover(context.Background())
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPeers, peers)
+		})
+	}
+}
+
+func TestResolveNodeZone(t *testing.T) {
+	ctx := context.Background()
+
+	const zoneLabel = "topology.kubernetes.io/zone"
+
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{zoneLabel: "us-east-1a"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+	)
+
+	newConfig := func(zone string) *config.BeskarConfig {
+		return &config.BeskarConfig{
+			Cache: config.Cache{
+				Zone:          zone,
+				ZoneNodeLabel: zoneLabel,
+			},
+		}
+	}
+
+	t.Setenv(NodeNameEnv, "node-a")
+
+	beskarConfig := newConfig("")
+	require.NoError(t, resolveNodeZone(ctx, beskarConfig, client))
+	require.Equal(t, "us-east-1a", beskarConfig.Cache.Zone)
+
+	// the configured zone takes precedence
+	beskarConfig = newConfig("eu-west-1b")
+	require.NoError(t, resolveNodeZone(ctx, beskarConfig, client))
+	require.Equal(t, "eu-west-1b", beskarConfig.Cache.Zone)
+
+	t.Setenv(NodeNameEnv, "node-b")
+	beskarConfig = newConfig("")
+	require.ErrorContains(t, resolveNodeZone(ctx, beskarConfig, client), "node node-b has no topology.kubernetes.io/zone label")
+	require.Empty(t, beskarConfig.Cache.Zone)
+
+	t.Setenv(NodeNameEnv, "")
+	require.ErrorContains(t, resolveNodeZone(ctx, newConfig(""), client), "NODE_NAME environment variable not set")
+}
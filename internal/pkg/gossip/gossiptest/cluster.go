@@ -0,0 +1,283 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+// Package gossiptest runs in-process gossip clusters over loopback for
+// the tests of this module covering cluster level behaviors like
+// convergence, meta propagation and cache keys distribution.
+package gossiptest
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.ciq.dev/beskar/internal/pkg/cache"
+	"go.ciq.dev/beskar/internal/pkg/gossip"
+)
+
+// DefaultCluster is the gossip cluster name of the nodes meta data.
+const DefaultCluster = "gossiptest"
+
+// convergencePollInterval is the interval between two checks of the
+// cluster convergence.
+const convergencePollInterval = 50 * time.Millisecond
+
+// Node is a member of an in-process cluster.
+type Node struct {
+	Name   string
+	Member *gossip.Member
+	// Meta is the meta data advertised by the node, the cache port
+	// is a free port nothing listens on.
+	Meta *gossip.BeskarMeta
+
+	cluster string
+	stopped bool
+}
+
+// CacheURL returns the cache peer URL advertised by the node.
+func (n *Node) CacheURL() string {
+	return cacheURL(n.Member.LocalNode().Addr, n.Meta.CachePort)
+}
+
+func cacheURL(ip net.IP, port uint16) string {
+	return fmt.Sprintf("https://%s", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+}
+
+// PeerMeta returns the meta data of the members known by the node, the
+// node included, by member name. Members with undecodable meta data
+// are not returned.
+func (n *Node) PeerMeta() map[string]*gossip.BeskarMeta {
+	peers := n.Member.Peers()
+	metas := make(map[string]*gossip.BeskarMeta, len(peers))
+
+	for _, peer := range peers {
+		meta := gossip.NewBeskarMeta(n.cluster)
+		if err := meta.Decode(peer.Meta); err == nil {
+			metas[peer.Name] = meta
+		}
+	}
+
+	return metas
+}
+
+// CachePeers returns the cache peer URLs keys are distributed across as
+// seen by the node, from the gossip members and their meta data.
+func (n *Node) CachePeers() []string {
+	peerZones := map[string]string{
+		n.CacheURL(): n.Meta.Zone,
+	}
+
+	for _, node := range n.Member.Nodes() {
+		meta := gossip.NewBeskarMeta(n.cluster)
+		if err := meta.Decode(node.Meta); err == nil {
+			peerZones[cacheURL(node.Addr, meta.CachePort)] = meta.Zone
+		}
+	}
+
+	return cache.SelectPeers(n.Meta.Zone, peerZones)
+}
+
+// CacheOwner returns the cache peer URL owning the key as seen by the node.
+func (n *Node) CacheOwner(key string) string {
+	return cache.Owner(key, n.CachePeers())
+}
+
+// Option configures the cluster nodes.
+type Option func(*Cluster)
+
+// WithZones assigns the zones to the nodes in turn.
+func WithZones(zones ...string) Option {
+	return func(c *Cluster) {
+		c.zones = zones
+	}
+}
+
+// WithMemberOptions adds member options to all nodes.
+func WithMemberOptions(options ...gossip.MemberOption) Option {
+	return func(c *Cluster) {
+		c.memberOptions = append(c.memberOptions, options...)
+	}
+}
+
+// Cluster is an in-process gossip cluster, its nodes listen on random
+// loopback ports and share a random gossip key.
+type Cluster struct {
+	t             testing.TB
+	key           []byte
+	zones         []string
+	memberOptions []gossip.MemberOption
+
+	mutex sync.Mutex
+	nodes []*Node
+}
+
+// NewCluster starts a cluster of size nodes, the test fails if a node
+// can't be started. Nodes are shut down once the test is done.
+func NewCluster(t testing.TB, size int, options ...Option) *Cluster {
+	t.Helper()
+
+	c := &Cluster{
+		t:   t,
+		key: make([]byte, 32),
+	}
+	if _, err := rand.Read(c.key); err != nil {
+		t.Fatalf("while generating gossip key: %s", err)
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	t.Cleanup(c.shutdown)
+
+	for i := 0; i < size; i++ {
+		c.AddNode()
+	}
+
+	return c
+}
+
+// AddNode starts a node joining the first running node.
+func (c *Cluster) AddNode() *Node {
+	c.t.Helper()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	index := len(c.nodes)
+
+	meta := gossip.NewBeskarMeta(DefaultCluster)
+	meta.CachePort = freePort(c.t)
+	if len(c.zones) > 0 {
+		meta.Zone = c.zones[index%len(c.zones)]
+	}
+
+	encodedMeta, err := meta.Encode()
+	if err != nil {
+		c.t.Fatalf("while encoding node meta: %s", err)
+	}
+
+	var peers []string
+	for _, node := range c.nodes {
+		if !node.stopped {
+			peers = append(peers, node.Member.LocalNode().Address())
+			break
+		}
+	}
+
+	name := fmt.Sprintf("node-%d", index)
+
+	memberOptions := append([]gossip.MemberOption{
+		gossip.WithBindAddress("127.0.0.1:0"),
+		gossip.WithSecretKey(c.key),
+		gossip.WithNodeMeta(encodedMeta),
+	}, c.memberOptions...)
+
+	member, err := gossip.NewMember(name, peers, memberOptions...)
+	if err != nil {
+		c.t.Fatalf("while starting node %s: %s", name, err)
+	}
+
+	node := &Node{
+		Name:    name,
+		Member:  member,
+		Meta:    meta,
+		cluster: DefaultCluster,
+	}
+	c.nodes = append(c.nodes, node)
+
+	return node
+}
+
+// Nodes returns the running nodes.
+func (c *Cluster) Nodes() []*Node {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	nodes := make([]*Node, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if !node.stopped {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes
+}
+
+// StopNode makes the node leave the cluster.
+func (c *Cluster) StopNode(node *Node) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if node.stopped {
+		return nil
+	}
+	node.stopped = true
+
+	return node.Member.Shutdown()
+}
+
+// WaitConvergence waits until each running node knows all running
+// nodes and only them, it returns the context error otherwise.
+func (c *Cluster) WaitConvergence(ctx context.Context) error {
+	ticker := time.NewTicker(convergencePollInterval)
+	defer ticker.Stop()
+
+	for {
+		if c.converged() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cluster not converged: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cluster) converged() bool {
+	nodes := c.Nodes()
+
+	running := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		running[node.Name] = struct{}{}
+	}
+
+	for _, node := range nodes {
+		peers := node.Member.Peers()
+		if len(peers) != len(nodes) {
+			return false
+		}
+		for _, peer := range peers {
+			if _, ok := running[peer.Name]; !ok || peer.State != "alive" {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (c *Cluster) shutdown() {
+	for _, node := range c.Nodes() {
+		_ = c.StopNode(node)
+	}
+}
+
+// freePort returns a loopback port nothing listens on.
+func freePort(t testing.TB) uint16 {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("while looking for a free port: %s", err)
+	}
+	defer l.Close()
+
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossiptest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterConvergence(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cluster := NewCluster(t, 3, WithZones("a", "b"))
+	require.NoError(t, cluster.WaitConvergence(ctx))
+
+	nodes := cluster.Nodes()
+	require.Len(t, nodes, 3)
+
+	// meta data is propagated to all nodes
+	for _, node := range nodes {
+		metas := node.PeerMeta()
+		require.Len(t, metas, 3)
+		for _, other := range nodes {
+			require.Equal(t, other.Meta.CachePort, metas[other.Name].CachePort)
+			require.Equal(t, other.Meta.Zone, metas[other.Name].Zone)
+		}
+	}
+
+	// keys are distributed across the peers of the same zone, the
+	// only node of zone b distributes keys across all peers
+	require.Equal(t, nodes[0].CachePeers(), nodes[2].CachePeers())
+	require.Len(t, nodes[0].CachePeers(), 2)
+	require.Len(t, nodes[1].CachePeers(), 3)
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("sha256:%d", i)
+		require.Equal(t, nodes[0].CacheOwner(key), nodes[2].CacheOwner(key))
+	}
+
+	// keys of the stopped node are redistributed
+	require.NoError(t, cluster.StopNode(nodes[2]))
+	require.NoError(t, cluster.WaitConvergence(ctx))
+
+	for _, node := range cluster.Nodes() {
+		require.Len(t, node.PeerMeta(), 2)
+		require.NotContains(t, node.CachePeers(), nodes[2].CacheURL())
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrKeyMismatch is returned when peers can't be joined because the
+// messages exchanged with them can't be decrypted.
+var ErrKeyMismatch = errors.New("possible gossip key mismatch, check that all members use the same gossip key")
+
+// keyMismatchWarnInterval bounds the rate of the key mismatch warnings.
+const keyMismatchWarnInterval = time.Minute
+
+// decryptionErrors are the memberlist errors reported when a message is
+// encrypted with another key or when only one side encrypts messages.
+var decryptionErrors = []string{
+	"No installed keys could decrypt the message",
+	"Decrypt packet failed",
+	"Remote state is encrypted and encryption is not configured",
+	"Encryption is configured but remote state is not encrypted",
+}
+
+// isDecryptionError returns true if the memberlist error or log
+// line reports a decryption failure.
+func isDecryptionError(msg string) bool {
+	for _, e := range decryptionErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// memberlistLog receives the memberlist logs which are discarded except
+// for the decryption failures, memberlist doesn't expose them otherwise.
+type memberlistLog struct {
+	nd *nodeDelegate
+}
+
+func (ml memberlistLog) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		if isDecryptionError(string(line)) {
+			ml.nd.decryptionFailed(string(line))
+		}
+	}
+	return len(p), nil
+}
+
+// decryptionFailed records a decryption failure, a key mismatch
+// warning is logged at most once per keyMismatchWarnInterval.
+func (nd *nodeDelegate) decryptionFailed(msg string) {
+	decryptionFailuresCounter.Inc(1)
+
+	now := time.Now()
+
+	nd.decryptionMutex.Lock()
+	nd.lastDecryptionFailure = now
+	warn := now.Sub(nd.decryptionWarned) >= keyMismatchWarnInterval
+	if warn {
+		nd.decryptionWarned = now
+	}
+	nd.decryptionMutex.Unlock()
+
+	if warn {
+		logrus.Warnf("Possible gossip key mismatch, messages from a peer can't be decrypted: %s", msg)
+	}
+}
+
+// LastDecryptionFailure returns the time at which a gossip message
+// couldn't be decrypted for the last time, a zero time is returned
+// if all messages have been decrypted.
+func (member *Member) LastDecryptionFailure() time.Time {
+	if member == nil {
+		return time.Time{}
+	}
+
+	member.nd.decryptionMutex.Lock()
+	defer member.nd.decryptionMutex.Unlock()
+
+	return member.nd.lastDecryptionFailure
+}
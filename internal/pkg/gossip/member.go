@@ -4,8 +4,12 @@
 package gossip
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"math/rand"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/memberlist"
@@ -18,6 +22,9 @@ type Member struct {
 	nd        *nodeDelegate
 }
 
+// errNoMember is returned by member methods when gossip is disabled.
+var errNoMember = errors.New("gossip is disabled")
+
 const (
 	// DefaultLeaveTimeout is the time to wait during while leaving cluster
 	DefaultLeaveTimeout = 5 * time.Second
@@ -25,18 +32,30 @@ const (
 
 // NewMember creates and/or participates to a gossip cluster.
 func NewMember(name string, peers []string, memberOpt ...MemberOption) (*Member, error) {
+	return NewMemberContext(context.Background(), name, peers, memberOpt...)
+}
+
+// NewMemberContext creates and/or participates to a gossip cluster,
+// the cluster join is aborted if the context is cancelled.
+func NewMemberContext(ctx context.Context, name string, peers []string, memberOpt ...MemberOption) (*Member, error) {
 	cfg := memberlist.DefaultLANConfig()
 	cfg.BindPort = 0
 	cfg.Name = name
 
 	eventChan := make(chan MemberEvent, 16)
 	nd := &nodeDelegate{
-		eventChan: eventChan,
+		eventChan:     eventChan,
+		remoteStateCh: make(chan struct{}),
+		entries:       make(map[string]stateEntry),
+		forgotten:     make(map[string]struct{}),
+
+		keyRotationTimeout: DefaultKeyRotationTimeout,
+		keyRotationDrain:   DefaultKeyRotationDrain,
 	}
 	cfg.Delegate = nd
 	cfg.Events = nd
 
-	cfg.LogOutput = io.Discard
+	cfg.LogOutput = memberlistLog{nd: nd}
 	cfg.Keyring, _ = memberlist.NewKeyring(nil, nil)
 
 	for _, opt := range memberOpt {
@@ -45,19 +64,53 @@ func NewMember(name string, peers []string, memberOpt ...MemberOption) (*Member,
 		}
 	}
 
+	nd.name = cfg.Name
+	nd.keyring = cfg.Keyring
+
+	tlsEnabled := nd.tlsServerConfig != nil
+
+	switch {
+	case tlsEnabled && cfg.EncryptionEnabled():
+		return nil, fmt.Errorf("gossip secret key and TLS transport are mutually exclusive")
+	case tlsEnabled:
+		// the TLS transport encrypts and authenticates all messages
+	case nd.requireEncryption && !cfg.EncryptionEnabled():
+		return nil, fmt.Errorf("gossip encryption is required but no secret key is set")
+	case nd.requireEncryption && (!cfg.GossipVerifyIncoming || !cfg.GossipVerifyOutgoing):
+		return nil, fmt.Errorf("gossip encryption is required but plaintext messages are allowed")
+	}
+
+	var transport *tlsTransport
+
+	if tlsEnabled {
+		var err error
+
+		transport, err = newTLSTransport(cfg.BindAddr, cfg.BindPort, nd.tlsServerConfig, nd.tlsClientConfig)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transport = transport
+	}
+
 	// create memberlist network
 	ml, err := memberlist.Create(cfg)
 	if err != nil {
+		if transport != nil {
+			_ = transport.Shutdown()
+		}
 		return nil, err
 	}
 
+	nd.members = ml.Members
+	nd.sendReliable = ml.SendReliable
+
 	member := &Member{
 		ml:        ml,
 		eventChan: eventChan,
 		nd:        nd,
 	}
 	if len(peers) > 0 {
-		peerJoined, err := member.join(peers)
+		peerJoined, err := member.join(ctx, peers)
 		if err != nil {
 			return nil, err
 		} else if peerJoined == 0 {
@@ -65,28 +118,228 @@ func NewMember(name string, peers []string, memberOpt ...MemberOption) (*Member,
 		}
 	}
 
+	// without state received from peers, the local state is the
+	// cluster state agreed by the nodes joining later
+	nd.stateMutex.Lock()
+	if nd.clusterState == nil {
+		nd.clusterState = nd.localState
+	}
+	nd.stateMutex.Unlock()
+
 	return member, nil
 }
 
 // Watch returns an event channel to react to various notifications
-// coming from gossip protocol (join, leave, update ...).
+// coming from gossip protocol (join, leave, update ...). A closed
+// channel is returned for a nil member.
 func (member *Member) Watch() <-chan MemberEvent {
+	if member == nil {
+		eventChan := make(chan MemberEvent)
+		close(eventChan)
+		return eventChan
+	}
 	return member.eventChan
 }
 
+// ErrPeersUnreachable is returned when discovered peers can't be joined.
+var ErrPeersUnreachable = errors.New("discovered peers unreachable")
+
+// joinRetryDelay is the delay between two join attempts.
+const joinRetryDelay = time.Second
+
 // Join joins a peer in the cluster.
-func (member *Member) join(peers []string) (int, error) {
+func (member *Member) join(ctx context.Context, peers []string) (int, error) {
 	if len(peers) == 0 {
 		return 0, fmt.Errorf("at least one master peer address is required to join cluster")
 	}
 
-	count, err := member.ml.Join(peers)
-	if err != nil {
+	type joinResult struct {
+		count int
+		err   error
+	}
+
+	joinCh := make(chan joinResult, 1)
+
+	go func() {
+		count, err := member.joinWithRetry(ctx, peers)
+		joinCh <- joinResult{count: count, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// the shutdown fails the pending joins, no retry
+		// is attempted once the context is done
 		_ = member.ml.Shutdown()
-		return 0, err
+		<-joinCh
+		return 0, ctx.Err()
+	case result := <-joinCh:
+		if result.err != nil {
+			_ = member.ml.Shutdown()
+			return 0, result.err
+		}
+		return result.count, nil
+	}
+}
+
+// joinWithRetry joins the peers until at least joinMinSuccess peers have
+// been joined, peers not joined are retried up to joinRetries times.
+func (member *Member) joinWithRetry(ctx context.Context, peers []string) (int, error) {
+	minJoins := member.nd.joinMinSuccess
+	if minJoins <= 0 {
+		minJoins = 1
+	} else if minJoins > len(peers) {
+		minJoins = len(peers)
 	}
 
-	return count, err
+	remaining := peers
+	joined := 0
+
+	for attempt := 0; ; attempt++ {
+		joinedPeers, err := member.joinPeers(ctx, remaining, minJoins-joined)
+		joined += len(joinedPeers)
+		if joined >= minJoins {
+			return joined, nil
+		} else if ctx.Err() != nil {
+			return joined, ctx.Err()
+		}
+
+		remaining = withoutPeers(remaining, joinedPeers)
+
+		if attempt >= member.nd.joinRetries {
+			err = fmt.Errorf("%w: joined %d of %d peers, %d required: %w", ErrPeersUnreachable, joined, len(peers), minJoins, err)
+			if isDecryptionError(err.Error()) {
+				err = fmt.Errorf("%w: %w", ErrKeyMismatch, err)
+			}
+			return joined, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return joined, ctx.Err()
+		case <-time.After(joinRetryDelay):
+		}
+	}
+}
+
+// joinPeers joins the peers in a random order with at most maxConcurrentJoins
+// peers contacted at once, no other peer is contacted once minJoins peers
+// have been joined. All peers are contacted at once when maxConcurrentJoins
+// is zero. No other peer is contacted either once the context is done.
+// The joined peers are returned along with the join errors.
+func (member *Member) joinPeers(ctx context.Context, peers []string, minJoins int) ([]string, error) {
+	maxJoins := member.nd.maxConcurrentJoins
+	if maxJoins <= 0 {
+		maxJoins = len(peers)
+		minJoins = len(peers)
+	}
+
+	shuffled := append([]string(nil), peers...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	var (
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		joined []string
+		errs   []error
+	)
+
+	sem := make(chan struct{}, maxJoins)
+
+	for _, peer := range shuffled {
+		sem <- struct{}{}
+
+		mutex.Lock()
+		done := len(joined) >= minJoins || ctx.Err() != nil
+		mutex.Unlock()
+
+		if done {
+			break
+		}
+
+		wg.Add(1)
+
+		go func(peer string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			count, err := member.ml.Join([]string{peer})
+
+			mutex.Lock()
+			if count > 0 {
+				joined = append(joined, peer)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+			mutex.Unlock()
+		}(peer)
+	}
+
+	wg.Wait()
+
+	return joined, errors.Join(errs...)
+}
+
+// ErrNoPeerDiscovery is returned by RediscoverPeers when the
+// member has no peer discovery.
+var ErrNoPeerDiscovery = errors.New("no peer discovery configured")
+
+// RediscoverPeers runs the peer discovery again and joins the discovered
+// peers which are not cluster members yet, it returns the peers joined.
+// It allows to re-seed a partitioned cluster.
+func (member *Member) RediscoverPeers(ctx context.Context) ([]string, error) {
+	if member == nil {
+		return nil, errNoMember
+	} else if member.nd.discoverPeers == nil {
+		return nil, ErrNoPeerDiscovery
+	}
+
+	peers, err := member.nd.discoverPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	members := member.ml.Members()
+	known := make([]string, 0, len(members))
+	for _, node := range members {
+		known = append(known, node.Address())
+	}
+
+	newPeers := withoutPeers(peers, known)
+	if len(newPeers) == 0 {
+		return nil, nil
+	}
+
+	joined, err := member.joinPeers(ctx, newPeers, len(newPeers))
+	if len(joined) == 0 && err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPeersUnreachable, err)
+	}
+
+	return joined, nil
+}
+
+// withoutPeers returns the peers not part of excluded.
+func withoutPeers(peers, excluded []string) []string {
+	filtered := make([]string, 0, len(peers))
+
+	for _, peer := range peers {
+		found := false
+		for _, e := range excluded {
+			if e == peer {
+				found = true
+				break
+			}
+		}
+		if !found {
+			filtered = append(filtered, peer)
+		}
+	}
+
+	return filtered
 }
 
 // Shutdown leaves the cluster.
@@ -107,31 +360,183 @@ func (member *Member) Shutdown() error {
 
 // Nodes returns all nodes participating to the cluster.
 func (member *Member) Nodes() []*memberlist.Node {
+	if member == nil {
+		return nil
+	}
 	return member.ml.Members()
 }
 
+// Peer describes a cluster member as seen by the local node.
+type Peer struct {
+	Name string
+	// Addr is the gossip address (host:port) of the member.
+	Addr  string
+	State string
+	// Local is true for the local node.
+	Local bool
+	Meta  []byte
+}
+
+// Peer states, memberlist doesn't report suspect nodes nor whether a
+// node failed or left the cluster, nodes are either alive or dead.
+const (
+	PeerStateAlive = "alive"
+	PeerStateDead  = "dead"
+)
+
+// Peers returns the cluster members known by the local node, the
+// local node included, sorted by name. Members which are dead, left
+// the cluster or have been forgotten are not reported, dead members
+// are reported by DeadPeers.
+func (member *Member) Peers() []Peer {
+	if member == nil {
+		return nil
+	}
+
+	local := member.ml.LocalNode().Name
+	nodes := member.ml.Members()
+	peers := make([]Peer, 0, len(nodes))
+
+	for _, node := range nodes {
+		if member.nd.isForgotten(node.Name) {
+			continue
+		}
+		peers = append(peers, Peer{
+			Name:  node.Name,
+			Addr:  node.Address(),
+			State: PeerStateAlive,
+			Local: node.Name == local,
+			Meta:  node.Meta,
+		})
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+
+	return peers
+}
+
+// DeadPeers returns the members which failed or left the cluster
+// within the last hour as seen by the local node, sorted by name.
+// Members which have been forgotten or joined again are not reported.
+func (member *Member) DeadPeers() []Peer {
+	if member == nil {
+		return nil
+	}
+
+	nodes := member.nd.deadNodes()
+	peers := make([]Peer, 0, len(nodes))
+
+	for _, node := range nodes {
+		if member.nd.isForgotten(node.Name) {
+			continue
+		}
+		peers = append(peers, Peer{
+			Name:  node.Name,
+			Addr:  node.Address(),
+			State: PeerStateDead,
+			Meta:  node.Meta,
+		})
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+
+	return peers
+}
+
 // LocalNode returns the current node information.
 func (member *Member) LocalNode() *memberlist.Node {
+	if member == nil {
+		return nil
+	}
 	return member.ml.LocalNode()
 }
 
 // Send senda message to a particular node.
 func (member *Member) Send(node *memberlist.Node, msg []byte) error {
+	if member == nil {
+		return errNoMember
+	}
 	return member.ml.SendReliable(node, msg)
 }
 
 // PeerState returns the state of the peer used to join the cluster if any.
 func (member *Member) RemoteState() ([]byte, error) {
+	if member == nil {
+		return nil, errNoMember
+	}
+
+	member.nd.stateMutex.Lock()
+	defer member.nd.stateMutex.Unlock()
+
 	if member.nd.remoteState != nil {
 		return member.nd.remoteState, nil
 	}
 	return nil, fmt.Errorf("no remote state received")
 }
 
+// WaitRemoteState waits until a remote state is received from a peer
+// joining the cluster or until the context is cancelled.
+func (member *Member) WaitRemoteState(ctx context.Context) ([]byte, error) {
+	if member == nil {
+		return nil, errNoMember
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-member.nd.remoteStateCh:
+		return member.RemoteState()
+	}
+}
+
 // LocalState returns the state of the node if any.
 func (member *Member) LocalState() ([]byte, error) {
+	if member == nil {
+		return nil, errNoMember
+	}
+
+	member.nd.stateMutex.Lock()
+	defer member.nd.stateMutex.Unlock()
+
 	if member.nd.localState != nil {
 		return member.nd.localState, nil
 	}
 	return nil, fmt.Errorf("no local state set")
 }
+
+// SetStateEntry sets a shared state entry owned by the local node, the
+// entry is sent to peers at each push/pull and refreshed as long as the
+// local node is alive. Peers expire the entry once not refreshed within
+// the state TTL.
+func (member *Member) SetStateEntry(key string, value []byte) error {
+	if member == nil {
+		return errNoMember
+	}
+
+	member.nd.stateMutex.Lock()
+	defer member.nd.stateMutex.Unlock()
+
+	member.nd.setEntry(key, value, time.Now())
+
+	return nil
+}
+
+// StateEntry returns the value of a shared state entry, false is
+// returned if the entry doesn't exist or has expired.
+func (member *Member) StateEntry(key string) ([]byte, bool) {
+	if member == nil {
+		return nil, false
+	}
+
+	member.nd.stateMutex.Lock()
+	defer member.nd.stateMutex.Unlock()
+
+	member.nd.pruneEntries(time.Now())
+
+	entry, ok := member.nd.entries[key]
+	return entry.Value, ok
+}
@@ -4,9 +4,19 @@
 package gossip
 
 import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
 	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
 )
 
+// localStateWarnSize is the local state size above which a warning is
+// logged, peers reject push/pull states exceeding MaxLocalStateSize.
+const localStateWarnSize = MaxLocalStateSize / 10 * 8
+
 const (
 	// NodeJoin represents an event about a node join.
 	NodeJoin memberlist.NodeEventType = iota << 1
@@ -18,6 +28,9 @@ const (
 	NodeMessage
 	// NodeError represents an event about a node error.
 	NodeError
+	// NodeCacheEviction represents an event about a cache eviction
+	// received from a peer.
+	NodeCacheEviction
 )
 
 // MemberEvent
@@ -28,14 +41,72 @@ type MemberEvent struct {
 
 // nodeDelegate regroups some hooks.
 type nodeDelegate struct {
-	meta        []byte
-	eventChan   chan MemberEvent
-	localState  []byte
-	remoteState []byte
+	meta      []byte
+	eventChan chan MemberEvent
+
+	maxConcurrentJoins int
+	joinRetries        int
+	joinMinSuccess     int
+	requireEncryption  bool
+	tlsServerConfig    *tls.Config
+	tlsClientConfig    *tls.Config
+	discoverPeers      func(ctx context.Context) ([]string, error)
+
+	// name is the local node name owning the entries it sets.
+	name string
+	// members returns the cluster members, it's set once
+	// the memberlist is created.
+	members func() []*memberlist.Node
+	// sendReliable sends a user message to a node, it's set
+	// once the memberlist is created.
+	sendReliable func(node *memberlist.Node, msg []byte) error
+
+	keyringMutex       sync.Mutex
+	keyring            *memberlist.Keyring
+	keyRotationTimeout time.Duration
+	keyRotationDrain   time.Duration
+	rotationMutex      sync.Mutex
+	rotation           *keyRotation
+
+	forgottenMutex sync.Mutex
+	forgotten      map[string]struct{}
+
+	// nodes is the view of the cluster nodes built from the
+	// memberlist notifications, including the dead nodes.
+	nodesMutex sync.Mutex
+	nodes      map[string]*viewNode
+
+	decryptionMutex       sync.Mutex
+	lastDecryptionFailure time.Time
+	decryptionWarned      time.Time
+
+	stateMutex sync.Mutex
+	localState []byte
+	// localGenerated is set when the local state was generated by
+	// the node rather than provisioned.
+	localGenerated bool
+	// clusterState is the state agreed when joining the cluster, the
+	// first state received from peers or the local state otherwise.
+	clusterState  []byte
+	remoteState   []byte
+	remoteStateCh chan struct{}
+	entries       map[string]stateEntry
+	// clock is the Lamport clock versioning the entries.
+	clock     uint64
+	stateTTL  time.Duration
+	clockSkew time.Duration
+	// skewWarned holds the last clock skew warning time per peer.
+	skewWarned map[string]time.Time
+	// stateSizeWarned is set once the local state size warning is
+	// logged, it's reset when the size goes back below the threshold.
+	stateSizeWarned bool
 }
 
 // NotifyMsg is called when a user-data message is received.
 func (nd *nodeDelegate) NotifyMsg(b []byte) {
+	if nd.handleForgetMsg(b) || nd.handleEvictMsg(b) || nd.handleKeyringMsg(b) {
+		return
+	}
 	nd.eventChan <- MemberEvent{
 		EventType: NodeMessage,
 		Arg:       b,
@@ -44,6 +115,8 @@ func (nd *nodeDelegate) NotifyMsg(b []byte) {
 
 // NotifyJoin is invoked when a node is detected to have joined.
 func (nd *nodeDelegate) NotifyJoin(node *memberlist.Node) {
+	nd.setNodeState(node, memberlist.StateAlive)
+	nd.unforget(node.Name)
 	nd.eventChan <- MemberEvent{
 		EventType: NodeJoin,
 		Arg:       node,
@@ -52,6 +125,12 @@ func (nd *nodeDelegate) NotifyJoin(node *memberlist.Node) {
 
 // NotifyLeave is invoked when a node is detected to have left.
 func (nd *nodeDelegate) NotifyLeave(node *memberlist.Node) {
+	// memberlist doesn't notify whether the node failed or left
+	nd.setNodeState(node, memberlist.StateDead)
+	// the leave event was emitted when the node was forgotten
+	if nd.unforget(node.Name) {
+		return
+	}
 	nd.eventChan <- MemberEvent{
 		EventType: NodeLeave,
 		Arg:       node,
@@ -61,6 +140,7 @@ func (nd *nodeDelegate) NotifyLeave(node *memberlist.Node) {
 // NotifyUpdate is invoked when a node is detected to have
 // updated, usually involving the meta data.
 func (nd *nodeDelegate) NotifyUpdate(node *memberlist.Node) {
+	nd.setNodeState(node, memberlist.StateAlive)
 	nd.eventChan <- MemberEvent{
 		EventType: NodeUpdate,
 		Arg:       node,
@@ -76,18 +156,53 @@ func (nd *nodeDelegate) GetBroadcasts(_, _ int) [][]byte { return nil }
 
 // LocalState is used for a TCP Push/Pull.
 func (nd *nodeDelegate) LocalState(join bool) []byte {
-	if join && nd.localState != nil {
-		return nd.localState
+	nd.stateMutex.Lock()
+	defer nd.stateMutex.Unlock()
+
+	state := nd.localState
+	if !join {
+		state = nd.encodeEntries()
 	}
-	return nil
+	nd.checkStateSize(len(state))
+
+	return state
+}
+
+// checkStateSize warns once when the local state exchanged during push/pull
+// approaches the size limit enforced by peers, stateMutex must be held.
+func (nd *nodeDelegate) checkStateSize(size int) {
+	if size <= localStateWarnSize {
+		nd.stateSizeWarned = false
+		return
+	} else if nd.stateSizeWarned {
+		return
+	}
+	nd.stateSizeWarned = true
+
+	logrus.Warnf(
+		"Gossip local state of %d bytes is close to the push/pull limit of %d bytes, peers will reject it above the limit",
+		size, MaxLocalStateSize,
+	)
 }
 
 // MergeRemoteState is invoked after a TCP Push/Pull.
 func (nd *nodeDelegate) MergeRemoteState(buf []byte, join bool) {
-	if join && nd.remoteState == nil {
-		if nd.localState == nil {
-			nd.localState = buf
+	nd.stateMutex.Lock()
+	defer nd.stateMutex.Unlock()
+
+	if join && nd.remoteState == nil && len(buf) > 0 {
+		// the state of the nodes joining later isn't adopted
+		if nd.clusterState == nil {
+			nd.clusterState = buf
+			// the cluster state is adopted unless provisioned locally
+			if nd.localState == nil || nd.localGenerated {
+				nd.localState = buf
+				nd.localGenerated = false
+			}
 		}
 		nd.remoteState = buf
+		close(nd.remoteStateCh)
+	} else if !join && len(buf) > 0 {
+		nd.mergeEntries(buf)
 	}
 }
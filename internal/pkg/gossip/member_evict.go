@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// evictMsgPrefix prefixes the user messages broadcasting a cache
+// eviction, the JSON encoded eviction follows the prefix.
+var evictMsgPrefix = []byte("beskar.evict:")
+
+// CacheEviction describes cache keys to evict, Key is a key prefix
+// when Prefix is true.
+type CacheEviction struct {
+	Key    string `json:"key"`
+	Prefix bool   `json:"prefix"`
+}
+
+// BroadcastCacheEviction asks the other alive members to evict the cache
+// keys, members receive the eviction as a NodeCacheEviction event.
+func (member *Member) BroadcastCacheEviction(eviction CacheEviction) error {
+	if member == nil {
+		return errNoMember
+	}
+
+	payload, err := json.Marshal(eviction)
+	if err != nil {
+		return fmt.Errorf("while encoding cache eviction: %w", err)
+	}
+
+	msg := append(append([]byte(nil), evictMsgPrefix...), payload...)
+
+	var errs []error
+
+	for _, n := range member.ml.Members() {
+		if n.Name == member.ml.LocalNode().Name || n.State != memberlist.StateAlive {
+			continue
+		}
+		if err := member.ml.SendReliable(n, msg); err != nil {
+			errs = append(errs, fmt.Errorf("while sending cache eviction to %s: %w", n.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// handleEvictMsg emits a NodeCacheEviction event for a cache eviction
+// received from a peer, it returns false if the message isn't a cache
+// eviction.
+func (nd *nodeDelegate) handleEvictMsg(msg []byte) bool {
+	if !bytes.HasPrefix(msg, evictMsgPrefix) {
+		return false
+	}
+
+	var eviction CacheEviction
+
+	if err := json.Unmarshal(msg[len(evictMsgPrefix):], &eviction); err != nil {
+		nd.eventChan <- MemberEvent{
+			EventType: NodeError,
+			Arg:       fmt.Errorf("while decoding cache eviction: %w", err),
+		}
+		return true
+	}
+
+	nd.eventChan <- MemberEvent{
+		EventType: NodeCacheEviction,
+		Arg:       eviction,
+	}
+
+	return true
+}
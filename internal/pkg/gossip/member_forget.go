@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/memberlist"
+)
+
+var (
+	// ErrNodeNotFound is returned when forgetting a node which isn't a member.
+	ErrNodeNotFound = errors.New("node not found")
+	// ErrNodeAlive is returned when forgetting an alive node without force.
+	ErrNodeAlive = errors.New("node is alive")
+	// ErrLocalNode is returned when forgetting the local node.
+	ErrLocalNode = errors.New("node is the local node")
+)
+
+// forgetMsgPrefix prefixes the user messages broadcasting the intent to
+// forget a node, the node name follows the prefix.
+var forgetMsgPrefix = []byte("beskar.forget:")
+
+// ForgetNode removes a node identified by its name or its gossip address
+// from the local view and asks the other members to forget it as well.
+// Memberlist doesn't allow to forcibly remove a remote node, instead a
+// NodeLeave event is emitted to the watch channel so the node is removed
+// from the member consumers (eg: cache peers) and the node isn't reported
+// by Peers until it joins the cluster again. Dead nodes, which memberlist
+// doesn't report as members anymore, are looked up in the node view of the
+// delegate and alive nodes are only forgotten with force. The forgotten
+// node is returned, it's forgotten locally even if the intent couldn't be
+// sent to some members.
+func (member *Member) ForgetNode(id string, force bool) (*memberlist.Node, error) {
+	if member == nil {
+		return nil, errNoMember
+	}
+
+	node, ok := member.nd.lookupNode(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, id)
+	} else if node.Name == member.ml.LocalNode().Name {
+		return nil, fmt.Errorf("%w: %s", ErrLocalNode, id)
+	} else if node.State == memberlist.StateAlive && !force {
+		return nil, fmt.Errorf("%w: %s", ErrNodeAlive, id)
+	}
+
+	member.nd.forgetNode(node)
+
+	msg := append(append([]byte(nil), forgetMsgPrefix...), node.Name...)
+
+	var errs []error
+
+	for _, n := range member.ml.Members() {
+		if n.Name == node.Name || n.Name == member.ml.LocalNode().Name || n.State != memberlist.StateAlive {
+			continue
+		}
+		if err := member.ml.SendReliable(n, msg); err != nil {
+			errs = append(errs, fmt.Errorf("while sending forget intent to %s: %w", n.Name, err))
+		}
+	}
+
+	return node, errors.Join(errs...)
+}
+
+// forgetNode marks the node as forgotten and emits a NodeLeave event,
+// nothing is done if the node is already forgotten. Dead nodes are only
+// dropped from the node view, their NodeLeave event was already emitted.
+func (nd *nodeDelegate) forgetNode(node *memberlist.Node) {
+	if node.State == memberlist.StateDead {
+		nd.removeNode(node.Name)
+		return
+	}
+
+	nd.forgottenMutex.Lock()
+	_, ok := nd.forgotten[node.Name]
+	nd.forgotten[node.Name] = struct{}{}
+	nd.forgottenMutex.Unlock()
+
+	if !ok {
+		nd.eventChan <- MemberEvent{
+			EventType: NodeLeave,
+			Arg:       node,
+		}
+	}
+}
+
+// isForgotten returns true if the node has been forgotten.
+func (nd *nodeDelegate) isForgotten(name string) bool {
+	nd.forgottenMutex.Lock()
+	defer nd.forgottenMutex.Unlock()
+
+	_, ok := nd.forgotten[name]
+	return ok
+}
+
+// unforget removes the node from the forgotten nodes,
+// it returns true if the node was forgotten.
+func (nd *nodeDelegate) unforget(name string) bool {
+	nd.forgottenMutex.Lock()
+	defer nd.forgottenMutex.Unlock()
+
+	_, ok := nd.forgotten[name]
+	delete(nd.forgotten, name)
+	return ok
+}
+
+// handleForgetMsg forgets the node named in a forget intent received from
+// a peer, it returns false if the message isn't a forget intent.
+func (nd *nodeDelegate) handleForgetMsg(msg []byte) bool {
+	if !bytes.HasPrefix(msg, forgetMsgPrefix) {
+		return false
+	}
+
+	name := string(msg[len(forgetMsgPrefix):])
+	if name == nd.name {
+		return true
+	}
+
+	if node, ok := nd.lookupNode(name); ok && node.Name == name {
+		nd.forgetNode(node)
+	}
+
+	return true
+}
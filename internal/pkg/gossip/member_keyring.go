@@ -0,0 +1,361 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultKeyRotationTimeout is the time to wait for all members
+	// to acknowledge a key rotation step.
+	DefaultKeyRotationTimeout = 30 * time.Second
+	// DefaultKeyRotationDrain is the time during which the old key is
+	// still accepted once the new key is used by all members.
+	DefaultKeyRotationDrain = 5 * time.Second
+)
+
+var (
+	// ErrKeyRotationAborted is returned when a key rotation is aborted,
+	// the members are then asked to use the old key again.
+	ErrKeyRotationAborted = errors.New("gossip key rotation aborted")
+	// ErrKeyRotationInProgress is returned when a key rotation is
+	// started while another one is running.
+	ErrKeyRotationInProgress = errors.New("gossip key rotation in progress")
+	// ErrNoSecretKey is returned when rotating the key of a member
+	// which doesn't encrypt messages with a secret key.
+	ErrNoSecretKey = errors.New("gossip messages are not encrypted with a secret key")
+)
+
+var (
+	// keyringMsgPrefix prefixes the user messages sending a key rotation
+	// step, the JSON encoded step follows the prefix.
+	keyringMsgPrefix = []byte("beskar.keyring:")
+	// keyringAckMsgPrefix prefixes the user messages acknowledging a key
+	// rotation step, the JSON encoded acknowledgment follows the prefix.
+	keyringAckMsgPrefix = []byte("beskar.keyring-ack:")
+)
+
+// key rotation steps, the new key is installed as a secondary key, then
+// used as the primary key and finally the old key is removed. An aborted
+// rotation restores the old key as the primary key and removes the new key.
+const (
+	keyringStepInstall = "install"
+	keyringStepUse     = "use"
+	keyringStepRemove  = "remove"
+	keyringStepAbort   = "abort"
+)
+
+type keyringMsg struct {
+	ID     string `json:"id"`
+	Step   string `json:"step"`
+	Node   string `json:"node"`
+	Key    []byte `json:"key"`
+	OldKey []byte `json:"old-key"`
+}
+
+type keyringAck struct {
+	ID    string `json:"id"`
+	Step  string `json:"step"`
+	Node  string `json:"node"`
+	Error string `json:"error,omitempty"`
+}
+
+// keyRotation tracks the acknowledgments of the running rotation step.
+type keyRotation struct {
+	id   string
+	step string
+	acks chan keyringAck
+}
+
+// RotateKey replaces the secret key used to encrypt gossip messages by
+// newKey on all the alive members. The new key is first installed on all
+// members, then used to encrypt messages and the old key is finally
+// removed once drained. Each step waits for the acknowledgment of all
+// members, the rotation is aborted and the old key is used again if a
+// member doesn't acknowledge a step within the rotation timeout. Members
+// joining during the rotation and members which don't receive the abort
+// may need to be restarted. The configured key must be updated before
+// members restart.
+func (member *Member) RotateKey(newKey []byte) error {
+	if member == nil {
+		return errNoMember
+	}
+
+	nd := member.nd
+
+	if nd.keyring == nil || len(nd.keyring.GetKeys()) == 0 {
+		return ErrNoSecretKey
+	} else if err := memberlist.ValidateKey(newKey); err != nil {
+		return err
+	}
+
+	oldKey := nd.keyring.GetPrimaryKey()
+	if bytes.Equal(oldKey, newKey) {
+		return fmt.Errorf("new gossip key is the current key")
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	rotation, err := nd.startKeyRotation(id.String())
+	if err != nil {
+		return err
+	}
+	defer nd.endKeyRotation()
+
+	msg := keyringMsg{
+		ID:     id.String(),
+		Node:   nd.name,
+		Key:    newKey,
+		OldKey: oldKey,
+	}
+
+	var nodes []*memberlist.Node
+
+	for _, node := range member.ml.Members() {
+		if node.Name == nd.name || node.State != memberlist.StateAlive || nd.isForgotten(node.Name) {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+
+	for _, step := range []string{keyringStepInstall, keyringStepUse, keyringStepRemove} {
+		if step == keyringStepRemove {
+			// messages in flight may still be encrypted with the old key
+			time.Sleep(nd.keyRotationDrain)
+		}
+
+		msg.Step = step
+
+		err := member.keyRotationStep(rotation, msg, nodes)
+		if err == nil {
+			err = nd.applyKeyringMsg(msg)
+		}
+		if err != nil {
+			keyRotationAbortsCounter.Inc(1)
+			return fmt.Errorf("%w at %s step: %w", ErrKeyRotationAborted, step, errors.Join(err, member.abortKeyRotation(msg, nodes)))
+		}
+	}
+
+	keyRotationsCounter.Inc(1)
+
+	return nil
+}
+
+// keyRotationStep sends the rotation step to the nodes and waits
+// until they all acknowledged it or until the rotation timeout.
+func (member *Member) keyRotationStep(rotation *keyRotation, msg keyringMsg, nodes []*memberlist.Node) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("while encoding key rotation step: %w", err)
+	}
+	payload = append(append([]byte(nil), keyringMsgPrefix...), payload...)
+
+	member.nd.rotationMutex.Lock()
+	rotation.step = msg.Step
+	rotation.acks = make(chan keyringAck, len(nodes))
+	acks := rotation.acks
+	member.nd.rotationMutex.Unlock()
+
+	pending := make(map[string]struct{}, len(nodes))
+
+	var errs []error
+
+	for _, node := range nodes {
+		if err := member.ml.SendReliable(node, payload); err != nil {
+			errs = append(errs, fmt.Errorf("while sending key rotation step to %s: %w", node.Name, err))
+			continue
+		}
+		pending[node.Name] = struct{}{}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	timer := time.NewTimer(member.nd.keyRotationTimeout)
+	defer timer.Stop()
+
+	for len(pending) > 0 {
+		select {
+		case ack := <-acks:
+			if _, ok := pending[ack.Node]; !ok {
+				continue
+			}
+			delete(pending, ack.Node)
+			if ack.Error != "" {
+				errs = append(errs, fmt.Errorf("member %s failed: %s", ack.Node, ack.Error))
+			}
+		case <-timer.C:
+			names := make([]string, 0, len(pending))
+			for name := range pending {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			errs = append(errs, fmt.Errorf("members %v didn't acknowledge within %s", names, member.nd.keyRotationTimeout))
+			return errors.Join(errs...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// abortKeyRotation asks the nodes to use the old key again, the abort
+// is sent before the local keyring is restored as some nodes may only
+// have the new key.
+func (member *Member) abortKeyRotation(msg keyringMsg, nodes []*memberlist.Node) error {
+	msg.Step = keyringStepAbort
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("while encoding key rotation abort: %w", err)
+	}
+	payload = append(append([]byte(nil), keyringMsgPrefix...), payload...)
+
+	var errs []error
+
+	for _, node := range nodes {
+		if err := member.ml.SendReliable(node, payload); err != nil {
+			errs = append(errs, fmt.Errorf("while sending key rotation abort to %s: %w", node.Name, err))
+		}
+	}
+
+	return errors.Join(append(errs, member.nd.applyKeyringMsg(msg))...)
+}
+
+func (nd *nodeDelegate) startKeyRotation(id string) (*keyRotation, error) {
+	nd.rotationMutex.Lock()
+	defer nd.rotationMutex.Unlock()
+
+	if nd.rotation != nil {
+		return nil, ErrKeyRotationInProgress
+	}
+	nd.rotation = &keyRotation{id: id}
+
+	return nd.rotation, nil
+}
+
+func (nd *nodeDelegate) endKeyRotation() {
+	nd.rotationMutex.Lock()
+	nd.rotation = nil
+	nd.rotationMutex.Unlock()
+}
+
+// applyKeyringMsg applies a key rotation step to the local keyring.
+func (nd *nodeDelegate) applyKeyringMsg(msg keyringMsg) error {
+	nd.keyringMutex.Lock()
+	defer nd.keyringMutex.Unlock()
+
+	if nd.keyring == nil || len(nd.keyring.GetKeys()) == 0 {
+		return ErrNoSecretKey
+	}
+
+	switch msg.Step {
+	case keyringStepInstall:
+		return nd.keyring.AddKey(msg.Key)
+	case keyringStepUse:
+		return nd.keyring.UseKey(msg.Key)
+	case keyringStepRemove:
+		return nd.keyring.RemoveKey(msg.OldKey)
+	case keyringStepAbort:
+		if err := nd.keyring.AddKey(msg.OldKey); err != nil {
+			return err
+		} else if err := nd.keyring.UseKey(msg.OldKey); err != nil {
+			return err
+		}
+		return nd.keyring.RemoveKey(msg.Key)
+	}
+
+	return fmt.Errorf("unknown key rotation step %q", msg.Step)
+}
+
+// handleKeyringMsg applies a key rotation step received from a peer
+// and acknowledges it or records a key rotation acknowledgment, it
+// returns false if the message isn't related to a key rotation.
+func (nd *nodeDelegate) handleKeyringMsg(msg []byte) bool {
+	switch {
+	case bytes.HasPrefix(msg, keyringMsgPrefix):
+		var step keyringMsg
+
+		if err := json.Unmarshal(msg[len(keyringMsgPrefix):], &step); err != nil {
+			logrus.Errorf("Failed to decode gossip key rotation step: %s", err)
+			return true
+		}
+
+		ack := keyringAck{
+			ID:   step.ID,
+			Step: step.Step,
+			Node: nd.name,
+		}
+		if err := nd.applyKeyringMsg(step); err != nil {
+			logrus.Errorf("Failed to apply gossip key rotation %s step: %s", step.Step, err)
+			ack.Error = err.Error()
+		}
+
+		// aborts are not acknowledged
+		if step.Step != keyringStepAbort {
+			// the acknowledgment is sent over TCP which must not
+			// block the memberlist message handler
+			go nd.sendKeyringAck(step.Node, ack)
+		}
+	case bytes.HasPrefix(msg, keyringAckMsgPrefix):
+		var ack keyringAck
+
+		if err := json.Unmarshal(msg[len(keyringAckMsgPrefix):], &ack); err != nil {
+			logrus.Errorf("Failed to decode gossip key rotation acknowledgment: %s", err)
+			return true
+		}
+
+		nd.rotationMutex.Lock()
+		defer nd.rotationMutex.Unlock()
+
+		if nd.rotation == nil || nd.rotation.id != ack.ID || nd.rotation.step != ack.Step {
+			return true
+		}
+
+		select {
+		case nd.rotation.acks <- ack:
+		default:
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (nd *nodeDelegate) sendKeyringAck(name string, ack keyringAck) {
+	if nd.members == nil || nd.sendReliable == nil {
+		return
+	}
+
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		logrus.Errorf("Failed to encode gossip key rotation acknowledgment: %s", err)
+		return
+	}
+	payload = append(append([]byte(nil), keyringAckMsgPrefix...), payload...)
+
+	for _, node := range nd.members() {
+		if node.Name != name {
+			continue
+		}
+		if err := nd.sendReliable(node, payload); err != nil {
+			logrus.Errorf("Failed to acknowledge gossip key rotation %s step to %s: %s", ack.Step, name, err)
+		}
+		return
+	}
+}
@@ -6,27 +6,135 @@ package gossip
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
 )
 
+// ErrIncompatibleMeta is returned when decoding meta data advertised
+// by a node running an incompatible version or from another cluster.
+var ErrIncompatibleMeta = errors.New("incompatible meta data")
+
+// metaMagic prefixes the encoded meta data, the first byte can't start
+// a gob stream and distinguishes it from meta data advertised by older
+// nodes which is not prefixed.
+var metaMagic = []byte{0xbe, 0x5c}
+
+// metaVersion is the version of the meta data encoding, it must be
+// bumped when a change prevents older nodes from decoding it.
+const metaVersion = 1
+
+// metaHeaderSize is the size of the magic, version and cluster ID.
+const metaHeaderSize = 4
+
+// BeskarMeta is the node meta data advertised to peers, it must fit
+// within the memberlist meta size limit. CachePort is essential while
+// ConfigFingerprint, Zone and PublicURL are optional and dropped if
+// required: a peer without zone is considered to be in another zone and
+// is only used as a cache peer when there aren't enough peers in the same
+// zone, clients are not redirected to a peer without public URL.
 type BeskarMeta struct {
 	// Cache port.
 	CachePort uint16
+	// Zone where the node is running, empty if unknown.
+	Zone string
+	// PublicURL is the externally reachable URL of the node, empty
+	// if not advertised. Nodes running an older version decode meta
+	// data without it.
+	PublicURL string
+	// ConfigFingerprint is the fingerprint of the node configuration,
+	// see config.ConfigFingerprint, empty if not advertised.
+	ConfigFingerprint string
+
+	clusterID byte
+}
+
+// optionalMetaFields lists the fields which can be dropped, in the
+// order they are dropped, when the meta data exceeds the size limit.
+var optionalMetaFields = []struct {
+	name  string
+	field func(*BeskarMeta) *string
+}{
+	{
+		name:  "ConfigFingerprint",
+		field: func(bm *BeskarMeta) *string { return &bm.ConfigFingerprint },
+	},
+	{
+		name:  "Zone",
+		field: func(bm *BeskarMeta) *string { return &bm.Zone },
+	},
+	{
+		name:  "PublicURL",
+		field: func(bm *BeskarMeta) *string { return &bm.PublicURL },
+	},
+}
+
+// NewBeskarMeta returns the meta data of a node part of the
+// gossip cluster, meta data from another cluster is rejected.
+func NewBeskarMeta(cluster string) *BeskarMeta {
+	return &BeskarMeta{
+		clusterID: clusterID(cluster),
+	}
 }
 
-func NewBeskarMeta() *BeskarMeta {
-	return &BeskarMeta{}
+// clusterID returns a one byte identifier of the cluster name.
+func clusterID(cluster string) byte {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(cluster))
+	sum := h.Sum32()
+	return byte(sum ^ sum>>8 ^ sum>>16 ^ sum>>24)
 }
 
+// Encode encodes the meta data in gob format prefixed by the magic,
+// the encoding version and the cluster ID.
 func (bm *BeskarMeta) Encode() ([]byte, error) {
 	b := new(bytes.Buffer)
+	b.Write(metaMagic)
+	b.WriteByte(metaVersion)
+	b.WriteByte(bm.clusterID)
 	if err := gob.NewEncoder(b).Encode(bm); err != nil {
 		return nil, err
 	}
 	return b.Bytes(), nil
 }
 
-// Decode decodes gob format meta data and returns a NodeMeta
-// corresponding structure.
+// EncodeWithLimit encodes the meta data, optional fields are dropped
+// until the encoded meta data fits within maxSize bytes, the names of
+// dropped fields are returned along with the encoded meta data.
+func (bm *BeskarMeta) EncodeWithLimit(maxSize int) ([]byte, []string, error) {
+	meta := *bm
+
+	var dropped []string
+
+	for i := 0; ; i++ {
+		b, err := meta.Encode()
+		if err != nil {
+			return nil, nil, err
+		} else if len(b) <= maxSize {
+			return b, dropped, nil
+		} else if i == len(optionalMetaFields) {
+			return nil, nil, fmt.Errorf("meta data size of %d bytes exceeds limit of %d bytes", len(b), maxSize)
+		}
+		if field := optionalMetaFields[i].field(&meta); *field != "" {
+			*field = ""
+			dropped = append(dropped, optionalMetaFields[i].name)
+		}
+	}
+}
+
+// Decode decodes meta data, ErrIncompatibleMeta is returned if the meta
+// data was encoded by an incompatible version or for another cluster.
+// Meta data without prefix advertised by older nodes is decoded as is.
 func (bm *BeskarMeta) Decode(buf []byte) error {
+	if bytes.HasPrefix(buf, metaMagic[:1]) {
+		if len(buf) < metaHeaderSize || !bytes.HasPrefix(buf, metaMagic) {
+			return fmt.Errorf("%w: unknown format", ErrIncompatibleMeta)
+		} else if version := buf[len(metaMagic)]; version != metaVersion {
+			return fmt.Errorf("%w: unsupported version %d", ErrIncompatibleMeta, version)
+		} else if id := buf[len(metaMagic)+1]; id != bm.clusterID {
+			return fmt.Errorf("%w: cluster ID %d doesn't match %d", ErrIncompatibleMeta, id, bm.clusterID)
+		}
+		buf = buf[metaHeaderSize:]
+	}
 	return gob.NewDecoder(bytes.NewReader(buf)).Decode(bm)
 }
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeskarMetaEncodeWithLimit(t *testing.T) {
+	meta := NewBeskarMeta("beskar")
+	meta.CachePort = 5103
+	meta.Zone = "us-east-1a"
+	meta.PublicURL = "https://beskar-0.example.com"
+	meta.ConfigFingerprint = strings.Repeat("f", 64)
+
+	b, dropped, err := meta.EncodeWithLimit(memberlist.MetaMaxSize)
+	require.NoError(t, err)
+	require.Empty(t, dropped)
+
+	decoded := NewBeskarMeta("beskar")
+	require.NoError(t, decoded.Decode(b))
+	require.Equal(t, meta, decoded)
+
+	meta.Zone = strings.Repeat("z", memberlist.MetaMaxSize)
+
+	b, dropped, err = meta.EncodeWithLimit(memberlist.MetaMaxSize)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ConfigFingerprint", "Zone"}, dropped)
+	require.LessOrEqual(t, len(b), memberlist.MetaMaxSize)
+
+	decoded = NewBeskarMeta("beskar")
+	require.NoError(t, decoded.Decode(b))
+	require.Equal(t, "https://beskar-0.example.com", decoded.PublicURL)
+
+	meta.PublicURL = strings.Repeat("u", memberlist.MetaMaxSize)
+
+	b, dropped, err = meta.EncodeWithLimit(memberlist.MetaMaxSize)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ConfigFingerprint", "Zone", "PublicURL"}, dropped)
+	require.LessOrEqual(t, len(b), memberlist.MetaMaxSize)
+
+	decoded = NewBeskarMeta("beskar")
+	require.NoError(t, decoded.Decode(b))
+	require.Equal(t, uint16(5103), decoded.CachePort)
+	require.Equal(t, "", decoded.Zone)
+	require.Equal(t, "", decoded.PublicURL)
+
+	// the receiver is left untouched
+	require.Len(t, meta.Zone, memberlist.MetaMaxSize)
+
+	_, _, err = meta.EncodeWithLimit(1)
+	require.ErrorContains(t, err, "exceeds limit of 1 bytes")
+}
+
+// legacyBeskarMeta is the meta data advertised by older nodes.
+type legacyBeskarMeta struct {
+	CachePort uint16
+}
+
+func TestBeskarMetaDecodeLegacy(t *testing.T) {
+	b := new(bytes.Buffer)
+	require.NoError(t, gob.NewEncoder(b).Encode(&legacyBeskarMeta{CachePort: 5103}))
+
+	meta := NewBeskarMeta("beskar")
+	require.NoError(t, meta.Decode(b.Bytes()))
+	require.Equal(t, uint16(5103), meta.CachePort)
+}
+
+func TestBeskarMetaDecodeIncompatible(t *testing.T) {
+	meta := NewBeskarMeta("beskar")
+	meta.CachePort = 5103
+
+	b, err := meta.Encode()
+	require.NoError(t, err)
+
+	err = NewBeskarMeta("other").Decode(b)
+	require.ErrorIs(t, err, ErrIncompatibleMeta)
+	require.ErrorContains(t, err, "cluster ID")
+
+	future := append([]byte{}, b...)
+	future[len(metaMagic)] = metaVersion + 1
+
+	err = NewBeskarMeta("beskar").Decode(future)
+	require.ErrorIs(t, err, ErrIncompatibleMeta)
+	require.ErrorContains(t, err, "unsupported version")
+
+	err = NewBeskarMeta("beskar").Decode(metaMagic[:1])
+	require.ErrorIs(t, err, ErrIncompatibleMeta)
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// deadNodeRetention is how long the nodes which failed or left are
+// kept in the delegate view of the cluster nodes.
+const deadNodeRetention = time.Hour
+
+// viewNode is a cluster node as notified by memberlist, memberlist doesn't
+// set the state of the nodes it reports so the state is tracked from its
+// notifications: nodes are alive once joined or updated and dead once they
+// failed or left.
+type viewNode struct {
+	node  memberlist.Node
+	since time.Time
+}
+
+// setNodeState records the node state notified by memberlist, the dead
+// nodes older than deadNodeRetention are dropped from the view.
+func (nd *nodeDelegate) setNodeState(node *memberlist.Node, state memberlist.NodeStateType) {
+	nd.nodesMutex.Lock()
+	defer nd.nodesMutex.Unlock()
+
+	now := time.Now()
+
+	if nd.nodes == nil {
+		nd.nodes = make(map[string]*viewNode)
+	}
+	for name, vn := range nd.nodes {
+		if vn.node.State == memberlist.StateDead && now.Sub(vn.since) > deadNodeRetention {
+			delete(nd.nodes, name)
+		}
+	}
+
+	vn := &viewNode{
+		node:  *node,
+		since: now,
+	}
+	vn.node.State = state
+
+	nd.nodes[node.Name] = vn
+}
+
+// lookupNode returns a copy of the node identified by its name or its
+// gossip address from the delegate view, it includes the dead nodes.
+func (nd *nodeDelegate) lookupNode(id string) (*memberlist.Node, bool) {
+	nd.nodesMutex.Lock()
+	defer nd.nodesMutex.Unlock()
+
+	if vn, ok := nd.nodes[id]; ok {
+		node := vn.node
+		return &node, true
+	}
+	for _, vn := range nd.nodes {
+		if vn.node.Address() == id {
+			node := vn.node
+			return &node, true
+		}
+	}
+
+	return nil, false
+}
+
+// deadNodes returns copies of the dead nodes of the delegate view.
+func (nd *nodeDelegate) deadNodes() []memberlist.Node {
+	nd.nodesMutex.Lock()
+	defer nd.nodesMutex.Unlock()
+
+	nodes := make([]memberlist.Node, 0)
+	for _, vn := range nd.nodes {
+		if vn.node.State == memberlist.StateDead {
+			nodes = append(nodes, vn.node)
+		}
+	}
+
+	return nodes
+}
+
+// removeNode drops the node from the delegate view.
+func (nd *nodeDelegate) removeNode(name string) {
+	nd.nodesMutex.Lock()
+	defer nd.nodesMutex.Unlock()
+
+	delete(nd.nodes, name)
+}
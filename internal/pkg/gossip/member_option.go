@@ -4,13 +4,20 @@
 package gossip
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/hashicorp/memberlist"
 )
 
+// MaxLocalStateSize mirrors the memberlist push/pull state limit,
+// local state is always exchanged over TCP during push/pull.
+const MaxLocalStateSize = 20 * 1024 * 1024
+
 // MemberOption defines a member configuration function.
 type MemberOption func(*memberlist.Config) error
 
@@ -21,6 +28,32 @@ func WithSecretKey(key []byte) MemberOption {
 	}
 }
 
+// WithTLSTransport runs gossip over mutual TLS connections instead of
+// UDP packets and TCP streams encrypted with a secret key, the server
+// configuration must require and verify client certificates. It can't
+// be combined with a secret key.
+func WithTLSTransport(serverConfig, clientConfig *tls.Config) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		} else if serverConfig == nil || clientConfig == nil {
+			return fmt.Errorf("gossip TLS transport requires server and client configurations")
+		}
+
+		// client certificates may be verified by VerifyConnection
+		verified := serverConfig.ClientAuth == tls.RequireAndVerifyClientCert ||
+			(serverConfig.ClientAuth == tls.RequireAnyClientCert && serverConfig.VerifyConnection != nil)
+		if !verified {
+			return fmt.Errorf("gossip TLS transport requires verified client certificates")
+		}
+
+		nd.tlsServerConfig = serverConfig
+		nd.tlsClientConfig = clientConfig
+		return nil
+	}
+}
+
 // WithNodeMeta sets meta data associated to a node.
 func WithNodeMeta(meta []byte) MemberOption {
 	return func(cfg *memberlist.Config) error {
@@ -35,17 +68,46 @@ func WithNodeMeta(meta []byte) MemberOption {
 	}
 }
 
+// WithLocalState sets the local state exchanged with peers during push/pull.
 func WithLocalState(state []byte) MemberOption {
 	return func(cfg *memberlist.Config) error {
 		nd, ok := cfg.Delegate.(*nodeDelegate)
 		if !ok {
 			return fmt.Errorf("no node delegate found")
+		} else if len(state) > MaxLocalStateSize {
+			return fmt.Errorf("local state size exceed limit of %d bytes", MaxLocalStateSize)
 		}
 		nd.localState = state
 		return nil
 	}
 }
 
+// WithGeneratedLocalState sets a local state generated by the node, unlike
+// a provisioned state it's replaced by the state received from peers when
+// joining the cluster.
+func WithGeneratedLocalState(state []byte) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		if err := WithLocalState(state)(cfg); err != nil {
+			return err
+		}
+		cfg.Delegate.(*nodeDelegate).localGenerated = true
+		return nil
+	}
+}
+
+// WithMaxConcurrentJoins sets the number of peers contacted at once
+// when joining the cluster, all peers are contacted at once when zero.
+func WithMaxConcurrentJoins(maxJoins int) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		}
+		nd.maxConcurrentJoins = maxJoins
+		return nil
+	}
+}
+
 // WithBindAddress sets the bind address to listen on.
 func WithBindAddress(addr string) MemberOption {
 	return func(cfg *memberlist.Config) error {
@@ -62,3 +124,134 @@ func WithBindAddress(addr string) MemberOption {
 		return nil
 	}
 }
+
+// WithAdvertiseAddress sets the address advertised to peers.
+func WithAdvertiseAddress(addr string) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		ip, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		advertisePort, err := strconv.Atoi(port)
+		if err != nil {
+			return err
+		}
+		cfg.AdvertiseAddr = ip
+		cfg.AdvertisePort = advertisePort
+		return nil
+	}
+}
+
+// WithDeadNodeReclaimTime sets the time after which a dead node can
+// rejoin the cluster with the same name but a different address,
+// zero means dead nodes can't be reclaimed.
+func WithDeadNodeReclaimTime(reclaimTime time.Duration) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		cfg.DeadNodeReclaimTime = reclaimTime
+		return nil
+	}
+}
+
+// WithUDPBufferSize sets the maximum size of UDP packets, messages
+// larger than this size are not sent over UDP.
+func WithUDPBufferSize(size int) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		if size <= 0 {
+			return fmt.Errorf("UDP buffer size must be positive")
+		}
+		cfg.UDPBufferSize = size
+		return nil
+	}
+}
+
+// WithJoinRetries sets the number of times peers not joined are
+// contacted again until minSuccess peers have been joined, at least
+// one peer must be joined when minSuccess is zero.
+func WithJoinRetries(retries, minSuccess int) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		}
+		nd.joinRetries = retries
+		nd.joinMinSuccess = minSuccess
+		return nil
+	}
+}
+
+// WithRequiredEncryption makes the member creation fail when neither
+// a secret key nor the TLS transport is set or when plaintext messages
+// are accepted.
+func WithRequiredEncryption() MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		}
+		nd.requireEncryption = true
+		return nil
+	}
+}
+
+// WithPeerDiscovery sets the function used by RediscoverPeers
+// to discover the cluster peers.
+func WithPeerDiscovery(discover func(ctx context.Context) ([]string, error)) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		}
+		nd.discoverPeers = discover
+		return nil
+	}
+}
+
+// WithClockSkewAllowance sets the tolerated clock difference between
+// nodes when expiring shared state entries, a warning is logged
+// when a larger skew is detected with a peer.
+func WithClockSkewAllowance(clockSkew time.Duration) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		} else if clockSkew < 0 {
+			clockSkew = 0
+		}
+		nd.clockSkew = clockSkew
+		return nil
+	}
+}
+
+// WithStateTTL sets the time after which shared state entries not
+// refreshed by their owner are expired, a zero TTL disables expiry.
+// The state exchanged on join is exempt.
+func WithStateTTL(ttl time.Duration) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		}
+		nd.stateTTL = ttl
+		return nil
+	}
+}
+
+// WithKeyRotation sets the time to wait for all members to acknowledge
+// a key rotation step and the time during which the old key is still
+// accepted once the new key is used by all members, a zero timeout keeps
+// the default timeout.
+func WithKeyRotation(timeout, drain time.Duration) MemberOption {
+	return func(cfg *memberlist.Config) error {
+		nd, ok := cfg.Delegate.(*nodeDelegate)
+		if !ok {
+			return fmt.Errorf("no node delegate found")
+		} else if timeout < 0 || drain < 0 {
+			return fmt.Errorf("gossip key rotation timeout and drain must be positive")
+		} else if timeout == 0 {
+			timeout = DefaultKeyRotationTimeout
+		}
+		nd.keyRotationTimeout = timeout
+		nd.keyRotationDrain = drain
+		return nil
+	}
+}
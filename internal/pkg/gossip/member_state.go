@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// clockSkewWarnInterval is the minimum interval between two clock
+// skew warnings about the same peer.
+const clockSkewWarnInterval = 10 * time.Minute
+
+// stateEntry is a shared state entry, entries are owned by the node which
+// set them and are refreshed by their owner at each push/pull. Version is
+// the Lamport clock of the node when it set the entry.
+type stateEntry struct {
+	Node    string    `json:"node"`
+	Value   []byte    `json:"value"`
+	Version uint64    `json:"version"`
+	Updated time.Time `json:"updated"`
+}
+
+// stateEntries is the payload of the periodic push/pull, the state
+// exchanged on join (the CA) is sent as is and never expires.
+type stateEntries struct {
+	Entries map[string]stateEntry `json:"entries"`
+	// Node and Sent are the sender name and clock, they are
+	// used to detect the clock skew between nodes.
+	Node string    `json:"node,omitempty"`
+	Sent time.Time `json:"sent,omitempty"`
+}
+
+// expired returns true if the entry hasn't been refreshed within the TTL
+// extended by the clock skew allowance, entries never expire with a zero TTL.
+func (e stateEntry) expired(now time.Time, ttl, clockSkew time.Duration) bool {
+	return ttl > 0 && now.Sub(e.Updated) > ttl+clockSkew
+}
+
+// newer returns true if the entry supersedes the current one. Entries are
+// ordered by version, an entry set by a node supersedes all the entries the
+// node has seen whatever the node clocks. Entries set concurrently by
+// different nodes have the same version, the entry of the greatest node
+// name wins so that all nodes converge to the same entry.
+func (e stateEntry) newer(current stateEntry) bool {
+	switch {
+	case e.Version != current.Version:
+		return e.Version > current.Version
+	case e.Node == current.Node:
+		// the entry has been refreshed by its owner
+		return e.Updated.After(current.Updated)
+	}
+	return e.Node > current.Node
+}
+
+// setEntry sets an entry owned by the local node with the next version
+// of the local Lamport clock, stateMutex must be held.
+func (nd *nodeDelegate) setEntry(key string, value []byte, now time.Time) {
+	nd.clock++
+	nd.entries[key] = stateEntry{
+		Node:    nd.name,
+		Value:   value,
+		Version: nd.clock,
+		Updated: now,
+	}
+}
+
+// pruneEntries removes the expired entries and refreshes the timestamp
+// of the entries owned by the local node, stateMutex must be held.
+func (nd *nodeDelegate) pruneEntries(now time.Time) {
+	for key, entry := range nd.entries {
+		if entry.Node == nd.name {
+			entry.Updated = now
+			nd.entries[key] = entry
+		} else if entry.expired(now, nd.stateTTL, nd.clockSkew) {
+			delete(nd.entries, key)
+		}
+	}
+}
+
+// encodeEntries returns the fresh entries to send to a peer,
+// nil is returned when there is no entry.
+func (nd *nodeDelegate) encodeEntries() []byte {
+	now := time.Now()
+
+	nd.pruneEntries(now)
+
+	if len(nd.entries) == 0 {
+		return nil
+	}
+
+	buf, err := json.Marshal(stateEntries{
+		Entries: nd.entries,
+		Node:    nd.name,
+		Sent:    now,
+	})
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+// mergeEntries merges the entries received from a peer, the newer entry
+// wins and expired entries are ignored. Entries claiming to be owned by the
+// local node are ignored as the local node is the source of truth. The local
+// Lamport clock is advanced to the received versions.
+func (nd *nodeDelegate) mergeEntries(buf []byte) {
+	var remote stateEntries
+
+	if err := json.Unmarshal(buf, &remote); err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	nd.checkClockSkew(remote.Node, remote.Sent, now)
+
+	for key, entry := range remote.Entries {
+		if entry.Version > nd.clock {
+			nd.clock = entry.Version
+		}
+		if entry.Node == nd.name || entry.expired(now, nd.stateTTL, nd.clockSkew) {
+			continue
+		} else if current, ok := nd.entries[key]; ok && !entry.newer(current) {
+			continue
+		}
+		nd.entries[key] = entry
+	}
+}
+
+// checkClockSkew logs a warning when the clock of a peer differs from the
+// local clock by more than the clock skew allowance, the skew includes the
+// network latency which is negligible in comparison, stateMutex must be held.
+func (nd *nodeDelegate) checkClockSkew(node string, sent, now time.Time) {
+	if node == "" || sent.IsZero() {
+		// sent by a node not reporting its clock
+		return
+	}
+
+	skew := sent.Sub(now)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= nd.clockSkew {
+		return
+	} else if last, ok := nd.skewWarned[node]; ok && now.Sub(last) < clockSkewWarnInterval {
+		return
+	}
+
+	if nd.skewWarned == nil {
+		nd.skewWarned = make(map[string]time.Time)
+	}
+	nd.skewWarned[node] = now
+
+	direction := "ahead of"
+	if sent.Before(now) {
+		direction = "behind"
+	}
+
+	logrus.Warnf(
+		"Gossip peer %s clock is %s %s the local clock, exceeding the clock skew allowance of %s: check the NTP synchronization of the nodes",
+		node, skew.Round(time.Millisecond), direction, nd.clockSkew,
+	)
+}
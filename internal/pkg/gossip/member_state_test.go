@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func newStateDelegate(name string, ttl time.Duration) *nodeDelegate {
+	return &nodeDelegate{
+		name:          name,
+		stateTTL:      ttl,
+		entries:       make(map[string]stateEntry),
+		remoteStateCh: make(chan struct{}),
+	}
+}
+
+func TestStateEntries(t *testing.T) {
+	now := time.Now()
+
+	a := newStateDelegate("a", time.Minute)
+	a.entries["route"] = stateEntry{Node: "a", Value: []byte("a-route"), Version: 1, Updated: now.Add(-time.Hour)}
+	a.localState = []byte("ca")
+
+	// the join state is the CA only
+	require.Equal(t, []byte("ca"), a.LocalState(true))
+
+	b := newStateDelegate("b", time.Minute)
+	b.entries["leader"] = stateEntry{Node: "b", Value: []byte("b"), Updated: now}
+
+	// local entries are refreshed before being sent
+	b.MergeRemoteState(a.LocalState(false), false)
+	require.Equal(t, []byte("a-route"), b.entries["route"].Value)
+	require.Equal(t, []byte("b"), b.entries["leader"].Value)
+
+	// entries owned by the local node can't be overridden
+	buf, err := json.Marshal(stateEntries{Entries: map[string]stateEntry{
+		"leader": {Node: "b", Value: []byte("other"), Updated: now.Add(time.Second)},
+	}})
+	require.NoError(t, err)
+	b.MergeRemoteState(buf, false)
+	require.Equal(t, []byte("b"), b.entries["leader"].Value)
+
+	// the newer entry wins, expired entries are ignored
+	buf, err = json.Marshal(stateEntries{Entries: map[string]stateEntry{
+		"route": {Node: "c", Value: []byte("c-route"), Version: 2, Updated: now.Add(time.Second)},
+		"stale": {Node: "c", Value: []byte("stale"), Updated: now.Add(-time.Hour)},
+	}})
+	require.NoError(t, err)
+	b.MergeRemoteState(buf, false)
+	require.Equal(t, []byte("c-route"), b.entries["route"].Value)
+	require.NotContains(t, b.entries, "stale")
+
+	// entries of other nodes not refreshed within the TTL are expired
+	b.entries["route"] = stateEntry{Node: "c", Value: []byte("c-route"), Updated: now.Add(-2 * time.Minute)}
+	b.pruneEntries(now)
+	require.NotContains(t, b.entries, "route")
+	require.Contains(t, b.entries, "leader")
+
+	// the CA is exempt
+	require.Equal(t, []byte("ca"), a.LocalState(true))
+
+	// no expiry with a zero TTL
+	c := newStateDelegate("c", 0)
+	c.entries["route"] = stateEntry{Node: "a", Updated: now.Add(-24 * time.Hour)}
+	c.pruneEntries(now)
+	require.Contains(t, c.entries, "route")
+}
+
+func TestMemberStateEntry(t *testing.T) {
+	_, ok := (*Member)(nil).StateEntry("key")
+	require.False(t, ok)
+	require.Error(t, (*Member)(nil).SetStateEntry("key", nil))
+
+	m, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), WithStateTTL(time.Minute))
+	require.NoError(t, err)
+	defer m.ml.Shutdown()
+
+	require.NoError(t, m.SetStateEntry("key", []byte("value")))
+
+	value, ok := m.StateEntry("key")
+	require.True(t, ok)
+	require.Equal(t, []byte("value"), value)
+
+	_, ok = m.StateEntry("missing")
+	require.False(t, ok)
+}
+
+func TestStateEntriesClockSkew(t *testing.T) {
+	now := time.Now()
+
+	a := newStateDelegate("a", time.Minute)
+	a.clockSkew = 30 * time.Second
+
+	// expiry is delayed by the clock skew allowance
+	a.entries["route"] = stateEntry{Node: "c", Updated: now.Add(-80 * time.Second)}
+	a.pruneEntries(now)
+	require.Contains(t, a.entries, "route")
+	a.pruneEntries(now.Add(20 * time.Second))
+	require.NotContains(t, a.entries, "route")
+
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	// the sender clock is within the allowance
+	a.checkClockSkew("b", now.Add(10*time.Second), now)
+	require.Empty(t, hook.AllEntries())
+
+	// the sender clock is ahead, the warning is not repeated
+	a.checkClockSkew("b", now.Add(2*time.Minute), now)
+	require.Len(t, hook.AllEntries(), 1)
+	require.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	require.Contains(t, hook.LastEntry().Message, "Gossip peer b clock is 2m0s ahead of the local clock")
+	a.checkClockSkew("b", now.Add(2*time.Minute), now.Add(time.Second))
+	require.Len(t, hook.AllEntries(), 1)
+
+	// the sender clock is behind
+	a.checkClockSkew("c", now.Add(-2*time.Minute), now)
+	require.Len(t, hook.AllEntries(), 2)
+	require.Contains(t, hook.LastEntry().Message, "Gossip peer c clock is 2m0s behind the local clock")
+
+	// senders not reporting their clock are ignored
+	a.checkClockSkew("d", time.Time{}, now)
+	require.Len(t, hook.AllEntries(), 2)
+
+	// the sender clock is reported with the entries
+	b3 := newStateDelegate("b", time.Minute)
+	b3.entries["leader"] = stateEntry{Node: "b", Value: []byte("b"), Updated: now}
+	var remote stateEntries
+	require.NoError(t, json.Unmarshal(b3.LocalState(false), &remote))
+	require.Equal(t, "b", remote.Node)
+	require.False(t, remote.Sent.IsZero())
+}
+
+func TestStateEntriesVersion(t *testing.T) {
+	now := time.Now()
+
+	// the higher version wins whatever the timestamps and node names
+	b := stateEntry{Node: "b", Version: 2, Updated: now.Add(-time.Minute)}
+	c := stateEntry{Node: "c", Version: 1, Updated: now}
+	require.True(t, b.newer(c))
+	require.False(t, c.newer(b))
+
+	// refreshed entries of the same node are ordered by time
+	b2 := b
+	b2.Updated = now
+	require.True(t, b2.newer(b))
+	require.False(t, b.newer(b2))
+
+	// concurrent entries are ordered by node
+	c.Version = 2
+	require.True(t, c.newer(b))
+	require.False(t, b.newer(c))
+
+	// a write supersedes the entries its node has seen
+	a := newStateDelegate("a", time.Minute)
+	c3 := newStateDelegate("c", time.Minute)
+	c3.setEntry("leader", []byte("c"), now)
+	c3.setEntry("leader", []byte("c"), now)
+	a.MergeRemoteState(c3.LocalState(false), false)
+	require.Equal(t, uint64(2), a.clock)
+	require.Equal(t, []byte("c"), a.entries["leader"].Value)
+
+	a.setEntry("leader", []byte("a"), now.Add(-time.Minute))
+	c3.MergeRemoteState(a.LocalState(false), false)
+	require.Equal(t, []byte("a"), c3.entries["leader"].Value)
+	require.Equal(t, uint64(3), c3.clock)
+}
+
+func TestLocalStateSizeWarning(t *testing.T) {
+	a := newStateDelegate("a", time.Minute)
+
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	a.localState = make([]byte, localStateWarnSize)
+	require.Len(t, a.LocalState(true), localStateWarnSize)
+	require.Empty(t, hook.AllEntries())
+
+	// the warning is logged once while the state stays above the threshold
+	a.localState = make([]byte, localStateWarnSize+1)
+	a.LocalState(true)
+	a.LocalState(true)
+	require.Len(t, hook.AllEntries(), 1)
+	require.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	require.Contains(t, hook.LastEntry().Message, "close to the push/pull limit")
+
+	// the warning is logged again once the state went back below
+	a.localState = []byte("ca")
+	a.LocalState(true)
+	a.localState = make([]byte, localStateWarnSize+1)
+	a.LocalState(true)
+	require.Len(t, hook.AllEntries(), 2)
+}
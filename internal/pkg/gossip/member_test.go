@@ -0,0 +1,471 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemberMaxConcurrentJoins(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	leader, err := NewMember("leader", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer leader.ml.Shutdown()
+
+	leaderAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(leader.LocalNode().Port)))
+
+	// an unused port, join attempts to it fail
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	member, err := NewMemberContext(
+		ctx, "member", []string{unreachable, leaderAddr, unreachable},
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithMaxConcurrentJoins(1),
+	)
+	require.NoError(t, err)
+	defer member.ml.Shutdown()
+
+	require.Len(t, member.Nodes(), 2)
+
+	_, err = NewMemberContext(
+		ctx, "isolated", []string{unreachable},
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithMaxConcurrentJoins(1),
+	)
+	require.Error(t, err)
+}
+
+func TestMemberRequiredEncryption(t *testing.T) {
+	_, err := NewMember("plaintext", nil, WithBindAddress("127.0.0.1:0"), WithRequiredEncryption())
+	require.ErrorContains(t, err, "no secret key is set")
+
+	member, err := NewMember(
+		"encrypted", nil,
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(bytes.Repeat([]byte{1}, 32)), WithRequiredEncryption(),
+	)
+	require.NoError(t, err)
+	require.NoError(t, member.ml.Shutdown())
+}
+
+func TestMemberPeers(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	require.Nil(t, (*Member)(nil).Peers())
+
+	a, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithNodeMeta([]byte("meta")))
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	b, err := NewMember("b", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	peers := b.Peers()
+	require.Len(t, peers, 2)
+
+	require.Equal(t, "a", peers[0].Name)
+	require.Equal(t, a.LocalNode().Address(), peers[0].Addr)
+	require.Equal(t, PeerStateAlive, peers[0].State)
+	require.False(t, peers[0].Local)
+	require.Equal(t, []byte("meta"), peers[0].Meta)
+
+	require.Equal(t, "b", peers[1].Name)
+	require.True(t, peers[1].Local)
+}
+
+func TestMemberJoinRetries(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	var addrs []string
+
+	for _, name := range []string{"a", "b"} {
+		m, err := NewMember(name, nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+		require.NoError(t, err)
+		defer m.ml.Shutdown()
+
+		addrs = append(addrs, m.LocalNode().Address())
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	member, err := NewMemberContext(
+		ctx, "member", addrs,
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithMaxConcurrentJoins(1), WithJoinRetries(0, 2),
+	)
+	require.NoError(t, err)
+	defer member.ml.Shutdown()
+
+	require.Len(t, member.Nodes(), 3)
+
+	_, err = NewMemberContext(
+		ctx, "partial", []string{addrs[0], unreachable},
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(key), WithJoinRetries(1, 2),
+	)
+	require.ErrorIs(t, err, ErrPeersUnreachable)
+	require.ErrorContains(t, err, "joined 1 of 2 peers, 2 required")
+}
+
+func TestMemberJoinAbort(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	// the join is retried until the context is cancelled
+	_, err = NewMemberContext(
+		ctx, "aborted", []string{unreachable},
+		WithBindAddress("127.0.0.1:0"), WithJoinRetries(100, 1),
+	)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	leader, err := NewMember("leader", nil, WithBindAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer leader.ml.Shutdown()
+
+	member, err := NewMember("member", nil, WithBindAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer member.ml.Shutdown()
+
+	// no peer is contacted once the context is done
+	joined, err := member.joinPeers(ctx, []string{leader.LocalNode().Address()}, 1)
+	require.NoError(t, err)
+	require.Empty(t, joined)
+	require.Len(t, member.Nodes(), 1)
+}
+
+func TestMemberRediscoverPeers(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	_, err := (*Member)(nil).RediscoverPeers(context.Background())
+	require.Error(t, err)
+
+	a, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	_, err = a.RediscoverPeers(context.Background())
+	require.ErrorIs(t, err, ErrNoPeerDiscovery)
+
+	b, err := NewMember("b", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	var discovered []string
+
+	c, err := NewMember("c", []string{a.LocalNode().Address()},
+		WithBindAddress("127.0.0.1:0"),
+		WithSecretKey(key),
+		WithPeerDiscovery(func(ctx context.Context) ([]string, error) {
+			return discovered, nil
+		}),
+	)
+	require.NoError(t, err)
+	defer c.ml.Shutdown()
+
+	// known members are not joined again
+	discovered = []string{a.LocalNode().Address()}
+	joined, err := c.RediscoverPeers(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, joined)
+
+	discovered = []string{a.LocalNode().Address(), b.LocalNode().Address()}
+	joined, err = c.RediscoverPeers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{b.LocalNode().Address()}, joined)
+	require.Len(t, c.Peers(), 3)
+}
+
+func TestMemberForgetNode(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	_, err := (*Member)(nil).ForgetNode("a", false)
+	require.Error(t, err)
+
+	a, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	b, err := NewMember("b", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	c, err := NewMember("c", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer c.ml.Shutdown()
+
+	require.Eventually(t, func() bool {
+		return len(b.Peers()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = a.ForgetNode("unknown", false)
+	require.ErrorIs(t, err, ErrNodeNotFound)
+
+	_, err = a.ForgetNode("a", true)
+	require.ErrorIs(t, err, ErrLocalNode)
+
+	_, err = a.ForgetNode("c", false)
+	require.ErrorIs(t, err, ErrNodeAlive)
+
+	// drain the join events
+	drain := func(m *Member) {
+		for {
+			select {
+			case <-m.Watch():
+			default:
+				return
+			}
+		}
+	}
+	drain(a)
+	drain(b)
+
+	node, err := a.ForgetNode(c.LocalNode().Address(), true)
+	require.NoError(t, err)
+	require.Equal(t, "c", node.Name)
+
+	for _, m := range []*Member{a, b} {
+		require.Eventually(t, func() bool {
+			for {
+				select {
+				case event := <-m.Watch():
+					if n, ok := event.Arg.(*memberlist.Node); ok && event.EventType == NodeLeave && n.Name == "c" {
+						return true
+					}
+				default:
+					return false
+				}
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+
+		for _, peer := range m.Peers() {
+			require.NotEqual(t, "c", peer.Name)
+		}
+	}
+}
+
+func TestMemberForgetDeadNode(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	a, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	b, err := NewMember("b", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	c, err := NewMember("c", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(a.Peers()) == 3 && len(b.Peers()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	address := c.LocalNode().Address()
+
+	// c fails without leaving the cluster
+	require.NoError(t, c.ml.Shutdown())
+
+	require.Eventually(t, func() bool {
+		return len(a.Peers()) == 2 && len(b.Peers()) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// dead nodes are reported apart from the members
+	require.Nil(t, (*Member)(nil).DeadPeers())
+	dead := a.DeadPeers()
+	require.Len(t, dead, 1)
+	require.Equal(t, "c", dead[0].Name)
+	require.Equal(t, address, dead[0].Addr)
+	require.Equal(t, PeerStateDead, dead[0].State)
+
+	// dead nodes are not members anymore but are forgotten without force
+	node, err := a.ForgetNode(address, false)
+	require.NoError(t, err)
+	require.Equal(t, "c", node.Name)
+	require.Equal(t, memberlist.StateDead, node.State)
+
+	_, err = a.ForgetNode("c", false)
+	require.ErrorIs(t, err, ErrNodeNotFound)
+	require.Empty(t, a.DeadPeers())
+
+	// the forget intent is received by b
+	require.Eventually(t, func() bool {
+		_, ok := b.nd.lookupNode("c")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMemberBroadcastCacheEviction(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	require.Error(t, (*Member)(nil).BroadcastCacheEviction(CacheEviction{Key: "a"}))
+
+	a, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	b, err := NewMember("b", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), WithSecretKey(key))
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	require.Eventually(t, func() bool {
+		return len(a.Peers()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	eviction := CacheEviction{Key: "library/alpine@", Prefix: true}
+	require.NoError(t, a.BroadcastCacheEviction(eviction))
+
+	require.Eventually(t, func() bool {
+		for {
+			select {
+			case event := <-b.Watch():
+				if event.EventType == NodeCacheEviction {
+					return event.Arg == eviction
+				}
+			default:
+				return false
+			}
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMemberKeyMismatch(t *testing.T) {
+	require.True(t, (*Member)(nil).LastDecryptionFailure().IsZero())
+
+	a, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), WithSecretKey(bytes.Repeat([]byte{1}, 32)))
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	require.True(t, a.LastDecryptionFailure().IsZero())
+
+	_, err = NewMember(
+		"b", []string{a.LocalNode().Address()},
+		WithBindAddress("127.0.0.1:0"),
+		WithSecretKey(bytes.Repeat([]byte{2}, 32)),
+		WithJoinRetries(0, 1),
+	)
+	require.ErrorIs(t, err, ErrKeyMismatch)
+	require.ErrorIs(t, err, ErrPeersUnreachable)
+
+	require.Eventually(t, func() bool {
+		return !a.LastDecryptionFailure().IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMemberRotateKey(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	require.Error(t, (*Member)(nil).RotateKey(newKey))
+
+	options := []MemberOption{
+		WithBindAddress("127.0.0.1:0"),
+		WithSecretKey(oldKey),
+		WithKeyRotation(5*time.Second, 10*time.Millisecond),
+	}
+
+	a, err := NewMember("a", nil, options...)
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	b, err := NewMember("b", []string{a.LocalNode().Address()}, options...)
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	c, err := NewMember("c", []string{a.LocalNode().Address()}, options...)
+	require.NoError(t, err)
+	defer c.ml.Shutdown()
+
+	require.Eventually(t, func() bool {
+		return len(a.Peers()) == 3 && len(b.Peers()) == 3 && len(c.Peers()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.ErrorContains(t, a.RotateKey(oldKey), "new gossip key is the current key")
+	require.Error(t, a.RotateKey([]byte("short")))
+
+	require.NoError(t, a.RotateKey(newKey))
+
+	for _, member := range []*Member{a, b, c} {
+		require.Equal(t, newKey, member.nd.keyring.GetPrimaryKey())
+		require.Equal(t, [][]byte{newKey}, member.nd.keyring.GetKeys())
+	}
+
+	// a member with the new key only can join
+	d, err := NewMember("d", []string{b.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), WithSecretKey(newKey))
+	require.NoError(t, err)
+	defer d.ml.Shutdown()
+
+	// a member without secret key has nothing to rotate
+	e, err := NewMember("e", nil, WithBindAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer e.ml.Shutdown()
+
+	require.ErrorIs(t, e.RotateKey(newKey), ErrNoSecretKey)
+}
+
+func TestMemberRotateKeyAbort(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	options := []MemberOption{
+		WithBindAddress("127.0.0.1:0"),
+		WithSecretKey(oldKey),
+		WithKeyRotation(500*time.Millisecond, 0),
+	}
+
+	a, err := NewMember("a", nil, options...)
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	b, err := NewMember("b", []string{a.LocalNode().Address()}, options...)
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	require.Eventually(t, func() bool {
+		return len(a.Peers()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// b doesn't acknowledge the rotation steps anymore
+	b.nd.sendReliable = func(*memberlist.Node, []byte) error {
+		return nil
+	}
+
+	err = a.RotateKey(newKey)
+	require.ErrorIs(t, err, ErrKeyRotationAborted)
+	require.ErrorContains(t, err, "members [b] didn't acknowledge")
+
+	require.Eventually(t, func() bool {
+		return len(b.nd.keyring.GetKeys()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, member := range []*Member{a, b} {
+		require.Equal(t, oldKey, member.nd.keyring.GetPrimaryKey())
+		require.Equal(t, [][]byte{oldKey}, member.nd.keyring.GetKeys())
+	}
+}
@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+)
+
+var (
+	gossipNamespace = metrics.NewNamespace("beskar", "gossip")
+
+	suspectNodesGauge = gossipNamespace.NewGauge("suspect_nodes", "The number of cluster nodes in suspect state", metrics.Total)
+	reapedNodesGauge  = gossipNamespace.NewGauge("reaped_nodes", "The number of suspect nodes removed from the cluster view", metrics.Total)
+	// the expiry is a timestamp for alerts to compare with the current time
+	caExpiryGauge = gossipNamespace.NewGauge("ca_expiry", "The Unix time at which the cluster CA expires", metrics.Seconds)
+
+	decryptionFailuresCounter = gossipNamespace.NewCounter("decryption_failures", "The number of gossip messages which couldn't be decrypted")
+	keyRotationsCounter       = gossipNamespace.NewCounter("key_rotations", "The number of gossip key rotations completed by this member")
+	keyRotationAbortsCounter  = gossipNamespace.NewCounter("key_rotation_aborts", "The number of gossip key rotations aborted by this member")
+)
+
+func init() {
+	metrics.Register(gossipNamespace)
+}
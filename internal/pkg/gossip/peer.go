@@ -4,190 +4,328 @@
 package gossip
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
 	"github.com/google/uuid"
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
 	"go.ciq.dev/beskar/internal/pkg/config"
 	"go.ciq.dev/beskar/pkg/mtls"
 	"go.ciq.dev/beskar/pkg/netutil"
-	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"go.ciq.dev/beskar/pkg/retry"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-)
-
-const (
-	GossipLabelKey = "go.ciq.dev/beskar-gossip"
-	namespaceFile  = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 )
 
+// Start starts a gossip member, discovery and cluster join are
+// aborted once the timeout is reached.
 func Start(beskarConfig *config.BeskarConfig, client kubernetes.Interface, timeout time.Duration) (*Member, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return StartContext(ctx, beskarConfig, client)
+}
+
+// StartContext starts a gossip member, discovery and cluster join are
+// aborted as soon as the context is cancelled.
+func StartContext(ctx context.Context, beskarConfig *config.BeskarConfig, client kubernetes.Interface) (*Member, error) {
 	id, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
 	}
 
-	peers, err := getPeers(beskarConfig, client, timeout)
-	if err != nil {
+	if err := startupDelay(ctx, beskarConfig); err != nil {
 		return nil, err
 	}
-	key, err := getKey(beskarConfig)
+
+	peers, leader, err := getPeers(ctx, beskarConfig, client, retry.DefaultBackoffFactory)
 	if err != nil {
 		return nil, err
 	}
+	if err := resolveNodeZone(ctx, beskarConfig, client); err != nil {
+		logrus.Warnf("Cache zone unavailable, cache peers of the same zone won't be preferred: %s", err)
+	}
 	meta, err := getMeta(beskarConfig)
 	if err != nil {
 		return nil, err
 	}
-	state, err := getState(beskarConfig, len(peers))
+	encryption, err := getEncryption(beskarConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	host, port, err := net.SplitHostPort(beskarConfig.Gossip.Addr)
+	options := []MemberOption{
+		WithBindAddress(beskarConfig.Gossip.Addr),
+		encryption,
+		WithNodeMeta(meta),
+		WithDeadNodeReclaimTime(beskarConfig.Gossip.DeadNodeReclaim),
+		WithUDPBufferSize(beskarConfig.Gossip.UDPBufferSize),
+		WithMaxConcurrentJoins(beskarConfig.Gossip.MaxConcurrentJoins),
+		WithJoinRetries(beskarConfig.Gossip.JoinRetryCount(), beskarConfig.Gossip.JoinMinSuccess),
+		WithStateTTL(beskarConfig.Gossip.StateTTL),
+		WithClockSkewAllowance(beskarConfig.Gossip.ClockSkewAllowance),
+		WithKeyRotation(beskarConfig.Gossip.KeyRotation.Timeout, beskarConfig.Gossip.KeyRotation.Drain),
+	}
+	if beskarConfig.Gossip.AdvertiseAddr != "" {
+		options = append(options, WithAdvertiseAddress(beskarConfig.Gossip.AdvertiseAddr))
+	}
+	if beskarConfig.Gossip.RequireEncryption {
+		options = append(options, WithRequiredEncryption())
+	}
+
+	options = append(options, WithPeerDiscovery(func(ctx context.Context) ([]string, error) {
+		peers, leader, err := getPeers(ctx, beskarConfig, client, retry.DefaultBackoffFactory)
+		if leader != "" {
+			peers = append(peers, leader)
+		}
+		return peers, err
+	}))
+
+	// with TLS the CA is provisioned on all nodes, there is no election
+	if leader != "" && beskarConfig.Gossip.TLS.Enabled {
+		peers = append(peers, leader)
+	} else if leader != "" {
+		member, err := joinCALeader(ctx, id.String(), leader, beskarConfig.Gossip.CAElectionTimeout, options)
+		if err == nil || ctx.Err() != nil {
+			return member, err
+		}
+		logrus.Warnf("CA election leader %s not joined within %s, generating CA: %s", leader, beskarConfig.Gossip.CAElectionTimeout, err)
+	}
+
+	state, err := getState(beskarConfig, len(peers))
 	if err != nil {
 		return nil, err
-	} else if host == "" {
-		host = "0.0.0.0"
 	}
 
-	return NewMember(
-		id.String(),
-		peers,
-		WithBindAddress(net.JoinHostPort(host, port)),
-		WithSecretKey(key),
-		WithNodeMeta(meta),
-		WithLocalState(state),
-	)
+	// a generated CA is replaced by the cluster CA once joined
+	stateOption := WithGeneratedLocalState
+	if beskarConfig.Gossip.TLS.Enabled {
+		stateOption = WithLocalState
+	}
+
+	return NewMemberContext(ctx, id.String(), peers, append(options, stateOption(state))...)
 }
 
-func getKey(beskarConfig *config.BeskarConfig) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(beskarConfig.Gossip.Key)
+// Members are the members of the gossip clusters keyed by cluster
+// name, the default cluster is keyed by config.DefaultGossipClusterName.
+type Members map[string]*Member
+
+// Get returns the member of the named cluster, nil is returned when the
+// cluster isn't joined which makes the member methods report that gossip
+// is disabled.
+func (members Members) Get(name string) *Member {
+	return members[name]
 }
 
-func getMeta(beskarConfig *config.BeskarConfig) ([]byte, error) {
-	_, port, err := net.SplitHostPort(beskarConfig.Cache.Addr)
-	if err != nil {
-		return nil, err
+// Shutdown leaves all the gossip clusters.
+func (members Members) Shutdown() error {
+	var errs []error
+	for name, member := range members {
+		if err := member.Shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("while leaving gossip cluster %q: %w", name, err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	meta := NewBeskarMeta()
-	cachePort, err := strconv.ParseUint(port, 10, 16)
-	if err != nil {
-		return nil, fmt.Errorf("while parsing cache address: %w", err)
+// StartClusters starts a gossip member for each enabled gossip cluster
+// or for the named clusters only if any, discovery and cluster joins are
+// aborted once the timeout is reached.
+func StartClusters(beskarConfig *config.BeskarConfig, client kubernetes.Interface, timeout time.Duration, names ...string) (Members, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return StartClustersContext(ctx, beskarConfig, client, names...)
+}
+
+// StartClustersContext starts a gossip member for each enabled gossip
+// cluster or for the named clusters only if any, the members already
+// started are shut down if a cluster can't be joined.
+func StartClustersContext(ctx context.Context, beskarConfig *config.BeskarConfig, client kubernetes.Interface, names ...string) (Members, error) {
+	if len(names) == 0 {
+		names = beskarConfig.GossipClusterNames()
 	}
 
-	meta.CachePort = uint16(cachePort)
+	members := make(Members)
 
-	return meta.Encode()
-}
+	for _, name := range names {
+		clusterConfig, ok := beskarConfig.GossipCluster(name)
+		if !ok {
+			_ = members.Shutdown()
+			return nil, fmt.Errorf("gossip cluster %q is not configured", name)
+		}
 
-func getState(beskarConfig *config.BeskarConfig, numPeers int) ([]byte, error) {
-	if numPeers == 0 || !beskarConfig.RunInKubernetes() {
-		caCert, caKey, err := mtls.GenerateCA("beskar", time.Now().AddDate(10, 0, 0), mtls.ECDSAKey)
+		member, err := StartContext(ctx, clusterConfig, client)
 		if err != nil {
-			return nil, err
+			_ = members.Shutdown()
+			return nil, fmt.Errorf("while joining gossip cluster %q: %w", name, err)
 		}
-		return mtls.MarshalCAPEM(&mtls.CAPEM{
-			Cert: caCert,
-			Key:  caKey,
-		})
+		members[name] = member
 	}
-	return nil, nil
+
+	return members, nil
 }
 
-func getPeers(beskarConfig *config.BeskarConfig, client kubernetes.Interface, timeout time.Duration) ([]string, error) {
-	if !beskarConfig.RunInKubernetes() {
-		return beskarConfig.Gossip.Peers, nil
+// startupDelay waits a random delay bounded by the startup jitter before
+// discovery, nodes starting at once then don't list endpoints and join
+// peers simultaneously. There is no delay when the jitter is zero or with
+// the static discovery and no configured peers, other discovery types
+// are always delayed as their peers are only known after discovery.
+func startupDelay(ctx context.Context, beskarConfig *config.BeskarConfig) error {
+	jitter := beskarConfig.Gossip.StartupJitterBound()
+	if jitter <= 0 || (discoveryType(beskarConfig) == StaticDiscovery && len(beskarConfig.Gossip.Peers) == 0) {
+		return nil
 	}
 
-	data, err := os.ReadFile(namespaceFile)
-	if err != nil {
-		return nil, err
+	//nolint:gosec // jitter doesn't require a secure random generator
+	delay := time.Duration(rand.Int63n(int64(jitter)))
+
+	logrus.Infof("Delaying gossip discovery by %s", delay.Round(time.Millisecond))
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
 	}
+}
 
-	namespace := string(bytes.TrimSpace(data))
+// joinCALeader joins the member elected to generate the CA, the join is
+// retried until the election timeout is reached as the leader may not be
+// listening yet.
+func joinCALeader(ctx context.Context, name, leader string, timeout time.Duration, options []MemberOption) (*Member, error) {
+	electionCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	if client == nil {
-		inCluster, err := rest.InClusterConfig()
-		if err != nil {
-			return nil, fmt.Errorf("while getting k8s cluster configuration: %w", err)
-		}
-		client, err = kubernetes.NewForConfig(inCluster)
+	var member *Member
+
+	err := retry.Retry(electionCtx, retry.ConstantBackoffFactory(time.Second, 0), func() (err error) {
+		member, err = NewMemberContext(electionCtx, name, []string{leader}, options...)
+		return err
+	})
+
+	return member, err
+}
+
+// getEncryption returns the member option encrypting gossip messages,
+// either with the secret key or with the TLS transport.
+func getEncryption(beskarConfig *config.BeskarConfig) (MemberOption, error) {
+	if !beskarConfig.Gossip.TLS.Enabled {
+		key, err := beskarConfig.Gossip.DecodeKey()
 		if err != nil {
-			return nil, fmt.Errorf("while instantiating k8s client: %w", err)
+			return nil, err
 		}
+		return WithSecretKey(key), nil
 	}
 
-	podIP, err := netutil.RouteGetSourceAddress(os.Getenv("KUBERNETES_SERVICE_HOST"))
+	localIPs, err := netutil.LocalIPs()
 	if err != nil {
 		return nil, err
 	}
+	if beskarConfig.Gossip.AdvertiseAddr != "" {
+		host, _, err := net.SplitHostPort(beskarConfig.Gossip.AdvertiseAddr)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing gossip advertise address: %w", err)
+		} else if ip := net.ParseIP(host); ip != nil {
+			localIPs = append(localIPs, ip)
+		}
+	}
 
-	var peers []string
+	tlsConfig := beskarConfig.Gossip.TLS
+	validity := time.Now().AddDate(10, 0, 0)
+	clockSkew := mtls.WithClockSkew(beskarConfig.Gossip.ClockSkewAllowance)
 
-	getPeers := func() error {
-		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(5*time.Second))
-		defer cancel()
+	serverConfig, err := mtls.GenerateServerConfigFromFile(
+		tlsConfig.CACert,
+		tlsConfig.CAKey,
+		validity,
+		mtls.WithCertRequestIPs(localIPs...),
+		clockSkew,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("while generating gossip server mTLS certificates: %w", err)
+	}
 
-		endpointList, err := client.CoreV1().Endpoints(namespace).List(ctx, metav1.ListOptions{
-			LabelSelector: labels.Set(map[string]string{
-				GossipLabelKey: "true",
-			}).String(),
-		})
-		if err != nil {
-			return fmt.Errorf("while listing endpoints: %w", err)
-		}
+	clientConfig, err := mtls.GenerateClientConfigFromFile(tlsConfig.CACert, tlsConfig.CAKey, validity, clockSkew)
+	if err != nil {
+		return nil, fmt.Errorf("while generating gossip client mTLS certificates: %w", err)
+	}
 
-		var subsetIPs []string
-		gossipPort := int32(0)
-		peers = nil
-
-		for _, ep := range endpointList.Items {
-			for _, subset := range ep.Subsets {
-				for _, port := range subset.Ports {
-					if port.Protocol != v1.ProtocolTCP {
-						continue
-					}
-					gossipPort = port.Port
-					break
-				}
-				for _, address := range subset.Addresses {
-					subsetIPs = append(subsetIPs, address.IP)
-				}
-			}
-		}
+	return WithTLSTransport(serverConfig, clientConfig), nil
+}
 
-		if gossipPort == 0 {
-			return fmt.Errorf("no gossip port found")
-		}
+func getMeta(beskarConfig *config.BeskarConfig) ([]byte, error) {
+	_, port, err := net.SplitHostPort(beskarConfig.Cache.Addr)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, ip := range subsetIPs {
-			if ip == podIP {
-				continue
-			}
-			peer := net.JoinHostPort(ip, fmt.Sprintf("%d", gossipPort))
-			peers = append(peers, peer)
-		}
+	meta := NewBeskarMeta(beskarConfig.Gossip.Cluster)
+	cachePort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing cache address: %w", err)
+	}
 
-		if len(subsetIPs) == 0 {
-			return fmt.Errorf("no gossip peer found")
-		}
+	meta.CachePort = uint16(cachePort)
+	meta.Zone = beskarConfig.Cache.Zone
+	meta.PublicURL = beskarConfig.Node.PublicURL
+	meta.ConfigFingerprint = config.ConfigFingerprint(beskarConfig)
 
-		return nil
+	b, dropped, err := meta.EncodeWithLimit(memberlist.MetaMaxSize)
+	if err != nil {
+		return nil, err
+	} else if len(dropped) > 0 {
+		logrus.Warnf("gossip meta data exceeds %d bytes, dropped fields: %s", memberlist.MetaMaxSize, strings.Join(dropped, ", "))
 	}
 
-	eb := backoff.NewExponentialBackOff()
-	eb.MaxElapsedTime = timeout
+	return b, nil
+}
 
-	return peers, backoff.RetryNotify(getPeers, eb, func(err error, backoff time.Duration) {})
+func getState(beskarConfig *config.BeskarConfig, numPeers int) ([]byte, error) {
+	if beskarConfig.Gossip.TLS.Enabled {
+		// the CA securing the gossip transport is shared with the cache
+		return readCAFiles(beskarConfig.Gossip.TLS)
+	} else if numPeers == 0 && beskarConfig.Gossip.SoloCA == config.SoloCAWait {
+		// CA is expected from peers joining later
+		return nil, nil
+	} else if numPeers == 0 || discoveryType(beskarConfig) != KubernetesDiscovery {
+		// the CA is backdated so that nodes with a clock behind can use it
+		caCert, caKey, err := mtls.GenerateCA(
+			"beskar",
+			time.Now().AddDate(10, 0, 0),
+			mtls.ECDSAKey,
+			mtls.WithClockSkew(beskarConfig.Gossip.ClockSkewAllowance),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return mtls.MarshalCAPEM(&mtls.CAPEM{
+			Cert: caCert,
+			Key:  caKey,
+		})
+	}
+	return nil, nil
+}
+
+// readCAFiles returns the PEM encoded CA read from the gossip TLS files.
+func readCAFiles(tlsConfig config.GossipTLS) ([]byte, error) {
+	caCert, err := os.ReadFile(tlsConfig.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("while reading gossip CA certificate: %w", err)
+	}
+	caKey, err := os.ReadFile(tlsConfig.CAKey)
+	if err != nil {
+		return nil, fmt.Errorf("while reading gossip CA key: %w", err)
+	}
+	return mtls.MarshalCAPEM(&mtls.CAPEM{
+		Cert: caCert,
+		Key:  caKey,
+	})
 }
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+func TestElectCALeader(t *testing.T) {
+	require.Equal(t, "", electCALeader(nil))
+	require.Equal(t, "10.0.0.9", electCALeader([]string{"10.0.0.10", "10.0.0.9", "10.0.1.1"}))
+	require.Equal(t, "10.0.0.2", electCALeader([]string{"invalid", "10.0.0.2"}))
+
+	// all members elect the same leader whatever the listing order
+	require.Equal(t,
+		electCALeader([]string{"10.0.0.3", "10.0.0.1", "10.0.0.2"}),
+		electCALeader([]string{"10.0.0.2", "10.0.0.3", "10.0.0.1"}),
+	)
+}
+
+func TestStartClusters(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	gossipConfig := func(cluster string) config.Gossip {
+		return config.Gossip{
+			Addr:          "127.0.0.1:0",
+			Key:           base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+			Cluster:       cluster,
+			SoloCA:        config.SoloCAGenerate,
+			UDPBufferSize: config.DefaultGossipUDPBufferSize,
+		}
+	}
+
+	disabled := false
+
+	beskarConfig := &config.BeskarConfig{
+		Cache:  config.Cache{Addr: "127.0.0.1:5103"},
+		Gossip: gossipConfig(config.DefaultGossipCluster),
+		GossipClusters: map[string]config.Gossip{
+			"control":  gossipConfig("control"),
+			"disabled": {Enabled: &disabled},
+		},
+	}
+
+	members, err := StartClusters(beskarConfig, nil, 30*time.Second)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, members.Shutdown())
+	}()
+
+	require.Len(t, members, 2)
+	require.NotNil(t, members.Get(config.DefaultGossipClusterName))
+	require.NotNil(t, members.Get("control"))
+	require.NotEqual(t, members.Get("control").LocalNode().Address(), members.Get(config.DefaultGossipClusterName).LocalNode().Address())
+
+	// members of clusters not joined report that gossip is disabled
+	_, err = members.Get("disabled").LocalState()
+	require.ErrorIs(t, err, errNoMember)
+
+	_, err = StartClusters(beskarConfig, nil, 30*time.Second, "unknown")
+	require.ErrorContains(t, err, `gossip cluster "unknown" is not configured`)
+}
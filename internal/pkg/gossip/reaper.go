@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const suspectReaperInterval = 5 * time.Second
+
+// StartSuspectReaper periodically looks for nodes which are in the suspect
+// state for more than maxSuspectAge. Memberlist doesn't allow to forcibly
+// remove a remote node, instead a NodeLeave event is emitted to the watch
+// channel so the node is removed from the member consumers (eg: cache peers),
+// a NodeJoin event is emitted if a reaped node becomes alive again. The
+// reaper stops when the context is cancelled.
+func (member *Member) StartSuspectReaper(ctx context.Context, maxSuspectAge time.Duration, onReap func(*memberlist.Node)) {
+	if member == nil || maxSuspectAge <= 0 {
+		return
+	}
+
+	go func() {
+		suspects := make(map[string]time.Time)
+		reaped := make(map[string]struct{})
+
+		ticker := time.NewTicker(suspectReaperInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now()
+			seen := make(map[string]struct{})
+			suspectCount := 0
+
+			for _, node := range member.ml.Members() {
+				seen[node.Name] = struct{}{}
+
+				if node.State != memberlist.StateSuspect {
+					delete(suspects, node.Name)
+					if _, ok := reaped[node.Name]; ok && node.State == memberlist.StateAlive {
+						delete(reaped, node.Name)
+						member.eventChan <- MemberEvent{EventType: NodeJoin, Arg: node}
+					}
+					continue
+				}
+
+				suspectCount++
+
+				since, ok := suspects[node.Name]
+				if !ok {
+					suspects[node.Name] = now
+					continue
+				} else if _, ok := reaped[node.Name]; ok || now.Sub(since) < maxSuspectAge {
+					continue
+				}
+
+				reaped[node.Name] = struct{}{}
+				member.eventChan <- MemberEvent{EventType: NodeLeave, Arg: node}
+				if onReap != nil {
+					onReap(node)
+				}
+			}
+
+			// forget nodes which have been removed by memberlist
+			for name := range suspects {
+				if _, ok := seen[name]; !ok {
+					delete(suspects, name)
+				}
+			}
+			for name := range reaped {
+				if _, ok := seen[name]; !ok {
+					delete(reaped, name)
+				}
+			}
+
+			suspectNodesGauge.Set(float64(suspectCount))
+			reapedNodesGauge.Set(float64(len(reaped)))
+		}
+	}()
+}
@@ -0,0 +1,440 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/pkg/netutil"
+)
+
+const (
+	// tlsPacketFrame starts a connection carrying length
+	// prefixed gossip packets.
+	tlsPacketFrame byte = iota + 1
+	// tlsStreamFrame starts a memberlist stream connection.
+	tlsStreamFrame
+)
+
+const (
+	// maxTLSPacketSize bounds the size of a gossip packet, packets
+	// are bounded by the UDP buffer size with the default transport.
+	maxTLSPacketSize = 64 * 1024
+	// tlsPacketTimeout bounds the connection and the write of a packet
+	// as memberlist expects packets to be sent without blocking.
+	tlsPacketTimeout = time.Second
+	// tlsHandshakeTimeout bounds the time to receive the first frame
+	// of an incoming connection.
+	tlsHandshakeTimeout = 10 * time.Second
+	// tlsPacketIdleTimeout is the time after which an incoming
+	// packet connection without packets is closed.
+	tlsPacketIdleTimeout = 5 * time.Minute
+)
+
+// errTransportShutdown is returned once the transport is shut down.
+var errTransportShutdown = errors.New("gossip transport is shut down")
+
+// packetConn is an outgoing packet connection, writes are serialized.
+// The connection is established without holding the transport lock,
+// ready is closed once the connection or the dial error is set.
+type packetConn struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	err   error
+	ready chan struct{}
+}
+
+// tlsTransport is a memberlist transport running over mutual TLS
+// connections. Packets are framed and sent over persistent connections
+// to each peer, streams use their own connection.
+type tlsTransport struct {
+	serverConfig *tls.Config
+	clientConfig *tls.Config
+	listener     net.Listener
+
+	packetCh chan *memberlist.Packet
+	streamCh chan net.Conn
+
+	connsMutex sync.Mutex
+	conns      map[string]*packetConn
+	// inConns are the incoming connections read by the transport,
+	// stream connections are removed once handed to memberlist.
+	inConns map[net.Conn]struct{}
+
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	wg           sync.WaitGroup
+}
+
+// newTLSTransport returns a TLS transport listening on the bind address,
+// the server configuration must require client certificates.
+func newTLSTransport(bindAddr string, bindPort int, serverConfig, clientConfig *tls.Config) (*tlsTransport, error) {
+	listener, err := tls.Listen("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(bindPort)), serverConfig)
+	if err != nil {
+		return nil, fmt.Errorf("while starting gossip TLS listener: %w", err)
+	}
+
+	t := &tlsTransport{
+		serverConfig: serverConfig,
+		clientConfig: clientConfig,
+		listener:     listener,
+		packetCh:     make(chan *memberlist.Packet),
+		streamCh:     make(chan net.Conn),
+		conns:        make(map[string]*packetConn),
+		inConns:      make(map[net.Conn]struct{}),
+		shutdownCh:   make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.accept()
+
+	return t, nil
+}
+
+// port returns the port the transport is listening on.
+func (t *tlsTransport) port() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
+// FinalAdvertiseAddr returns the configured advertise address, the
+// listener address is advertised otherwise with the first non loopback
+// local IP when listening on all interfaces.
+func (t *tlsTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	var advertiseIP net.IP
+
+	if ip != "" {
+		advertiseIP = net.ParseIP(ip)
+		if advertiseIP == nil {
+			return nil, 0, fmt.Errorf("failed to parse advertise address %q", ip)
+		}
+	} else {
+		advertiseIP = t.listener.Addr().(*net.TCPAddr).IP
+		port = t.port()
+
+		if advertiseIP.IsUnspecified() {
+			localIPs, err := netutil.LocalIPs()
+			if err != nil {
+				return nil, 0, err
+			}
+			advertiseIP = nil
+			for _, localIP := range localIPs {
+				if !localIP.IsLoopback() {
+					advertiseIP = localIP
+					break
+				}
+			}
+			if advertiseIP == nil {
+				return nil, 0, fmt.Errorf("no private IP address found to advertise")
+			}
+		}
+	}
+
+	if ip4 := advertiseIP.To4(); ip4 != nil {
+		advertiseIP = ip4
+	}
+
+	return advertiseIP, port, nil
+}
+
+// WriteTo sends the packet over the packet connection to the peer, the
+// connection is established again once if the write fails.
+func (t *tlsTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	if len(b) > maxTLSPacketSize {
+		return time.Time{}, fmt.Errorf("gossip packet of %d bytes exceeds %d bytes", len(b), maxTLSPacketSize)
+	}
+
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+
+	var err error
+
+	for attempt := 0; attempt < 2; attempt++ {
+		var pc *packetConn
+
+		pc, err = t.packetConn(addr)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		now := time.Now()
+
+		pc.mutex.Lock()
+		_ = pc.conn.SetWriteDeadline(now.Add(tlsPacketTimeout))
+		_, err = pc.conn.Write(frame)
+		pc.mutex.Unlock()
+
+		if err == nil {
+			return now, nil
+		}
+
+		// the connection may have been closed by the peer
+		t.closePacketConn(addr, pc)
+	}
+
+	return time.Time{}, err
+}
+
+// packetConn returns the packet connection to the peer, the connection
+// is established on first use. Concurrent callers for the same peer wait
+// for the connection being established, packets sent to other peers are
+// not delayed by the dial.
+func (t *tlsTransport) packetConn(addr string) (*packetConn, error) {
+	t.connsMutex.Lock()
+
+	select {
+	case <-t.shutdownCh:
+		t.connsMutex.Unlock()
+		return nil, errTransportShutdown
+	default:
+	}
+
+	if pc, ok := t.conns[addr]; ok {
+		t.connsMutex.Unlock()
+
+		<-pc.ready
+		if pc.err != nil {
+			return nil, pc.err
+		}
+		return pc, nil
+	}
+
+	pc := &packetConn{ready: make(chan struct{})}
+	t.conns[addr] = pc
+	t.connsMutex.Unlock()
+
+	conn, err := t.dial(addr, tlsPacketTimeout, tlsPacketFrame)
+
+	t.connsMutex.Lock()
+	defer t.connsMutex.Unlock()
+	defer close(pc.ready)
+
+	select {
+	case <-t.shutdownCh:
+		if err == nil {
+			_ = conn.Close()
+		}
+		err = errTransportShutdown
+	default:
+	}
+
+	if err != nil {
+		pc.err = err
+		if t.conns[addr] == pc {
+			delete(t.conns, addr)
+		}
+		return nil, err
+	}
+
+	pc.conn = conn
+
+	return pc, nil
+}
+
+func (t *tlsTransport) closePacketConn(addr string, pc *packetConn) {
+	t.connsMutex.Lock()
+	defer t.connsMutex.Unlock()
+
+	if t.conns[addr] == pc {
+		delete(t.conns, addr)
+	}
+	_ = pc.conn.Close()
+}
+
+// dial establishes a TLS connection to the peer and sends the frame
+// type starting the connection.
+func (t *tlsTransport) dial(addr string, timeout time.Duration, frameType byte) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, t.clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte{frameType}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetWriteDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func (t *tlsTransport) PacketCh() <-chan *memberlist.Packet {
+	return t.packetCh
+}
+
+func (t *tlsTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return t.dial(addr, timeout, tlsStreamFrame)
+}
+
+func (t *tlsTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+// Shutdown closes the listener, the outgoing packet connections and the
+// incoming connections not handed to memberlist.
+func (t *tlsTransport) Shutdown() error {
+	t.shutdownOnce.Do(func() {
+		close(t.shutdownCh)
+		_ = t.listener.Close()
+
+		t.connsMutex.Lock()
+		for addr, pc := range t.conns {
+			// connections being established are closed by their dialer
+			if pc.conn != nil {
+				_ = pc.conn.Close()
+			}
+			delete(t.conns, addr)
+		}
+		for conn := range t.inConns {
+			_ = conn.Close()
+			delete(t.inConns, conn)
+		}
+		t.connsMutex.Unlock()
+	})
+
+	t.wg.Wait()
+
+	return nil
+}
+
+func (t *tlsTransport) accept() {
+	defer t.wg.Done()
+
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.shutdownCh:
+				return
+			default:
+			}
+
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				continue
+			}
+			logrus.Errorf("gossip TLS listener stopped: %s", err)
+			return
+		}
+
+		if !t.trackConn(conn) {
+			_ = conn.Close()
+			return
+		}
+
+		t.wg.Add(1)
+		go t.handleConn(conn)
+	}
+}
+
+// trackConn records an incoming connection so it's closed on shutdown,
+// false is returned once the transport is shut down.
+func (t *tlsTransport) trackConn(conn net.Conn) bool {
+	t.connsMutex.Lock()
+	defer t.connsMutex.Unlock()
+
+	select {
+	case <-t.shutdownCh:
+		return false
+	default:
+	}
+
+	t.inConns[conn] = struct{}{}
+
+	return true
+}
+
+// untrackConn forgets an incoming connection closed by the transport
+// or handed to memberlist.
+func (t *tlsTransport) untrackConn(conn net.Conn) {
+	t.connsMutex.Lock()
+	defer t.connsMutex.Unlock()
+
+	delete(t.inConns, conn)
+}
+
+// handleConn reads the frame type of an incoming connection, the TLS
+// handshake is completed by the first read.
+func (t *tlsTransport) handleConn(conn net.Conn) {
+	defer t.wg.Done()
+	defer t.untrackConn(conn)
+
+	frameType := make([]byte, 1)
+
+	_ = conn.SetReadDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if _, err := io.ReadFull(conn, frameType); err != nil {
+		logrus.Debugf("gossip TLS connection from %s rejected: %s", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	switch frameType[0] {
+	case tlsStreamFrame:
+		// the stream connection is owned by memberlist once handed
+		t.untrackConn(conn)
+		select {
+		case t.streamCh <- conn:
+		case <-t.shutdownCh:
+			_ = conn.Close()
+		}
+	case tlsPacketFrame:
+		t.readPackets(conn)
+	default:
+		logrus.Debugf("gossip TLS connection from %s rejected: unknown frame type %d", conn.RemoteAddr(), frameType[0])
+		_ = conn.Close()
+	}
+}
+
+// readPackets reads the packets of an incoming packet connection
+// until the connection is closed or idle.
+func (t *tlsTransport) readPackets(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 4)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(tlsPacketIdleTimeout))
+
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size > maxTLSPacketSize {
+			logrus.Debugf("gossip TLS packet from %s of %d bytes exceeds %d bytes", conn.RemoteAddr(), size, maxTLSPacketSize)
+			return
+		}
+
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+
+		packet := &memberlist.Packet{
+			Buf:       buf,
+			From:      conn.RemoteAddr(),
+			Timestamp: time.Now(),
+		}
+
+		select {
+		case t.packetCh <- packet:
+		case <-t.shutdownCh:
+			return
+		}
+	}
+}
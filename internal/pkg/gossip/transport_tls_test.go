@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/pkg/mtls"
+)
+
+func tlsTransportConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	validity := time.Now().AddDate(1, 0, 0)
+
+	caCert, caKey, err := mtls.GenerateCA("beskar", validity, mtls.ECDSAKey)
+	require.NoError(t, err)
+
+	serverConfig, err := mtls.GenerateServerConfig(bytes.NewReader(caCert), bytes.NewReader(caKey), validity)
+	require.NoError(t, err)
+	clientConfig, err := mtls.GenerateClientConfig(bytes.NewReader(caCert), bytes.NewReader(caKey), validity)
+	require.NoError(t, err)
+
+	return serverConfig, clientConfig
+}
+
+func tlsTransportOption(t *testing.T) MemberOption {
+	return WithTLSTransport(tlsTransportConfigs(t))
+}
+
+func TestMemberTLSTransport(t *testing.T) {
+	tlsTransport := tlsTransportOption(t)
+
+	a, err := NewMember("a", nil, WithBindAddress("127.0.0.1:0"), tlsTransport, WithRequiredEncryption())
+	require.NoError(t, err)
+	defer a.ml.Shutdown()
+
+	b, err := NewMember("b", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), tlsTransport)
+	require.NoError(t, err)
+	defer b.ml.Shutdown()
+
+	require.Len(t, b.Nodes(), 2)
+	require.Eventually(t, func() bool {
+		return len(a.Nodes()) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// packets are exchanged over the TLS connections
+	rtt, err := a.ml.Ping("b", &net.TCPAddr{IP: b.LocalNode().Addr, Port: int(b.LocalNode().Port)})
+	require.NoError(t, err)
+	require.Greater(t, rtt, time.Duration(0))
+
+	// certificates issued by another CA are rejected
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = NewMemberContext(ctx, "c", []string{a.LocalNode().Address()}, WithBindAddress("127.0.0.1:0"), tlsTransportOption(t))
+	require.Error(t, err)
+
+	// the secret key and the TLS transport can't be combined
+	_, err = NewMember(
+		"d", nil,
+		WithBindAddress("127.0.0.1:0"), WithSecretKey(bytes.Repeat([]byte{1}, 32)), tlsTransport,
+	)
+	require.ErrorContains(t, err, "mutually exclusive")
+}
+
+func TestTLSTransportPacketConns(t *testing.T) {
+	serverConfig, clientConfig := tlsTransportConfigs(t)
+
+	a, err := newTLSTransport("127.0.0.1", 0, serverConfig, clientConfig)
+	require.NoError(t, err)
+	defer a.Shutdown()
+
+	b, err := newTLSTransport("127.0.0.1", 0, serverConfig, clientConfig)
+	require.NoError(t, err)
+	defer b.Shutdown()
+
+	// a peer accepting connections without completing the TLS handshake
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer stalled.Close()
+
+	stalledErr := make(chan error, 1)
+	go func() {
+		_, err := a.WriteTo([]byte("stalled"), stalled.Addr().String())
+		stalledErr <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		a.connsMutex.Lock()
+		defer a.connsMutex.Unlock()
+		_, ok := a.conns[stalled.Addr().String()]
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// packets to other peers are not delayed by the stalled dial
+	bAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(b.port()))
+	_, err = a.WriteTo([]byte("packet"), bAddr)
+	require.NoError(t, err)
+
+	select {
+	case packet := <-b.PacketCh():
+		require.Equal(t, []byte("packet"), packet.Buf)
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet not received")
+	}
+
+	select {
+	case <-stalledErr:
+		t.Fatal("stalled dial completed before the packet was sent")
+	default:
+	}
+	require.Error(t, <-stalledErr)
+
+	// the incoming packet connection is closed on shutdown
+	require.NoError(t, b.Shutdown())
+	b.connsMutex.Lock()
+	require.Empty(t, b.inConns)
+	b.connsMutex.Unlock()
+
+	a.connsMutex.Lock()
+	pc := a.conns[bAddr]
+	a.connsMutex.Unlock()
+	require.NotNil(t, pc)
+
+	_ = pc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = pc.conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
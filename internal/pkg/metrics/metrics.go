@@ -0,0 +1,425 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics declares beskar metrics independently of the backend
+// they are emitted to. Metrics are declared in namespaces registered at
+// init time and are bound to the Prometheus backend until another backend
+// is selected with SetBackend.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Unit represents the type or precision of a metric, it's appended to
+// the fully qualified name of gauges and histograms.
+type Unit string
+
+const (
+	Seconds Unit = "seconds"
+	Bytes   Unit = "bytes"
+	Total   Unit = "total"
+)
+
+// Backend creates the instruments metrics are emitted to.
+type Backend interface {
+	NewCounter(desc *Desc) CounterVec
+	NewGauge(desc *Desc) GaugeVec
+	NewHistogram(desc *Desc) HistogramVec
+	NewCollectedGauge(desc *Desc, collect CollectFunc) CollectedGaugeVec
+	// Register is called once the instruments of the namespace metrics
+	// are created.
+	Register(ns *Namespace) error
+	// Unregister is called when the namespace metrics are bound to
+	// another backend.
+	Unregister(ns *Namespace)
+}
+
+// CounterVec is a counter instrument, label values are in the
+// order of the metric labels.
+type CounterVec interface {
+	Add(value float64, labelValues ...string)
+}
+
+// GaugeVec is a gauge instrument.
+type GaugeVec interface {
+	Add(value float64, labelValues ...string)
+	Set(value float64, labelValues ...string)
+}
+
+// HistogramVec is a histogram instrument.
+type HistogramVec interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// CollectedGaugeVec is a gauge instrument reporting the observations of
+// its collect function when the metrics are exported.
+type CollectedGaugeVec interface {
+	Observations() []Observation
+}
+
+// Observation is a gauge value, label values are in the
+// order of the metric labels.
+type Observation struct {
+	Value       float64
+	LabelValues []string
+}
+
+// CollectFunc returns the current values of a collected gauge, the
+// series no longer returned disappear instead of keeping their last
+// value.
+type CollectFunc func() []Observation
+
+// Counter is a metric that can only increment its current count.
+type Counter interface {
+	// Inc adds Sum(vs) to the counter, it's incremented by 1 if
+	// len(vs) == 0.
+	Inc(vs ...float64)
+}
+
+// LabeledCounter is a counter that must have labels populated before use.
+type LabeledCounter interface {
+	WithValues(vs ...string) Counter
+}
+
+// Gauge is a metric that can increment, decrement or be set.
+type Gauge interface {
+	Inc(vs ...float64)
+	Dec(vs ...float64)
+	Set(v float64)
+}
+
+// LabeledGauge is a gauge that must have labels populated before use.
+type LabeledGauge interface {
+	WithValues(vs ...string) Gauge
+}
+
+// Histogram is a metric recording the distribution of values.
+type Histogram interface {
+	Observe(v float64)
+}
+
+// LabeledHistogram is a histogram that must have labels populated before use.
+type LabeledHistogram interface {
+	WithValues(vs ...string) Histogram
+}
+
+// Desc describes a metric.
+type Desc struct {
+	Namespace string
+	Subsystem string
+	Name      string
+	Help      string
+	Unit      Unit
+	Labels    []string
+}
+
+type metricKind int
+
+const (
+	counterKind metricKind = iota
+	gaugeKind
+	histogramKind
+	collectedGaugeKind
+)
+
+type metric struct {
+	kind    metricKind
+	desc    *Desc
+	collect CollectFunc
+	// instrument is the CounterVec, GaugeVec, HistogramVec or
+	// CollectedGaugeVec created by the backend the metric is bound to.
+	instrument atomic.Value
+}
+
+func (m *metric) bind(backend Backend) {
+	switch m.kind {
+	case counterKind:
+		m.instrument.Store(&counterInstrument{backend.NewCounter(m.desc)})
+	case gaugeKind:
+		m.instrument.Store(&gaugeInstrument{backend.NewGauge(m.desc)})
+	case histogramKind:
+		m.instrument.Store(&histogramInstrument{backend.NewHistogram(m.desc)})
+	case collectedGaugeKind:
+		m.instrument.Store(&collectedGaugeInstrument{backend.NewCollectedGauge(m.desc, m.collect)})
+	}
+}
+
+// instruments are wrapped to store values of the same concrete type.
+type (
+	counterInstrument        struct{ CounterVec }
+	gaugeInstrument          struct{ GaugeVec }
+	histogramInstrument      struct{ HistogramVec }
+	collectedGaugeInstrument struct{ CollectedGaugeVec }
+)
+
+// Namespace regroups the metrics of a subsystem.
+type Namespace struct {
+	name      string
+	subsystem string
+
+	mutex   sync.Mutex
+	metrics []*metric
+}
+
+var (
+	backendMutex sync.Mutex
+	backend      Backend = NewPrometheusBackend(nil)
+	namespaces   []*Namespace
+)
+
+// NewNamespace returns a namespace, metric names are prefixed by the
+// namespace name and subsystem.
+func NewNamespace(name, subsystem string) *Namespace {
+	return &Namespace{
+		name:      name,
+		subsystem: subsystem,
+	}
+}
+
+// Name returns the namespace name.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Subsystem returns the namespace subsystem.
+func (n *Namespace) Subsystem() string {
+	return n.subsystem
+}
+
+func (n *Namespace) newMetric(kind metricKind, name, help string, unit Unit, labels []string, collect CollectFunc) *metric {
+	m := &metric{
+		kind:    kind,
+		collect: collect,
+		desc: &Desc{
+			Namespace: n.name,
+			Subsystem: n.subsystem,
+			Name:      name,
+			Help:      help,
+			Unit:      unit,
+			Labels:    labels,
+		},
+	}
+
+	// the metric is added while holding the backend mutex so that
+	// it's bound to the same backend as the namespace metrics
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+
+	m.bind(backend)
+
+	n.mutex.Lock()
+	n.metrics = append(n.metrics, m)
+	n.mutex.Unlock()
+
+	return m
+}
+
+func (n *Namespace) NewCounter(name, help string) Counter {
+	return &counter{metric: n.newMetric(counterKind, name, help, Total, nil, nil)}
+}
+
+func (n *Namespace) NewLabeledCounter(name, help string, labels ...string) LabeledCounter {
+	return &labeledCounter{metric: n.newMetric(counterKind, name, help, Total, labels, nil)}
+}
+
+func (n *Namespace) NewGauge(name, help string, unit Unit) Gauge {
+	return &gauge{metric: n.newMetric(gaugeKind, name, help, unit, nil, nil)}
+}
+
+func (n *Namespace) NewLabeledGauge(name, help string, unit Unit, labels ...string) LabeledGauge {
+	return &labeledGauge{metric: n.newMetric(gaugeKind, name, help, unit, labels, nil)}
+}
+
+func (n *Namespace) NewHistogram(name, help string, unit Unit) Histogram {
+	return &histogram{metric: n.newMetric(histogramKind, name, help, unit, nil, nil)}
+}
+
+func (n *Namespace) NewLabeledHistogram(name, help string, unit Unit, labels ...string) LabeledHistogram {
+	return &labeledHistogram{metric: n.newMetric(histogramKind, name, help, unit, labels, nil)}
+}
+
+// NewCollectedGauge declares a gauge whose values are returned by collect
+// each time the metrics are exported.
+func (n *Namespace) NewCollectedGauge(name, help string, unit Unit, collect CollectFunc, labels ...string) {
+	n.newMetric(collectedGaugeKind, name, help, unit, labels, collect)
+}
+
+// Register registers the namespace metrics with the current backend,
+// it panics if the backend rejects them like the Prometheus registry
+// does for duplicated metrics.
+func Register(n *Namespace) {
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+
+	if err := backend.Register(n); err != nil {
+		panic(err)
+	}
+	namespaces = append(namespaces, n)
+}
+
+// SetBackend binds the metrics of the registered namespaces to the
+// backend, metrics declared afterwards are bound to it as well. If the
+// backend rejects a namespace, the metrics remain bound to the current
+// backend.
+func SetBackend(b Backend) error {
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+
+	bound := make([][]interface{}, 0, len(namespaces))
+
+	for i, n := range namespaces {
+		backend.Unregister(n)
+
+		bound = append(bound, n.bind(b))
+
+		if err := b.Register(n); err != nil {
+			errs := []error{err}
+			// namespaces are registered again with the current backend
+			for j := i; j >= 0; j-- {
+				b.Unregister(namespaces[j])
+				namespaces[j].restore(bound[j])
+				if err := backend.Register(namespaces[j]); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		}
+	}
+
+	backend = b
+
+	return nil
+}
+
+// Handler returns the HTTP handler exposing the Prometheus metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func sum(vs []float64) float64 {
+	if len(vs) == 0 {
+		return 1
+	}
+	total := 0.0
+	for _, v := range vs {
+		total += v
+	}
+	return total
+}
+
+type counter struct {
+	*metric
+	labelValues []string
+}
+
+func (c *counter) Inc(vs ...float64) {
+	c.instrument.Load().(*counterInstrument).Add(sum(vs), c.labelValues...)
+}
+
+type labeledCounter struct {
+	*metric
+}
+
+func (lc *labeledCounter) WithValues(vs ...string) Counter {
+	return &counter{metric: lc.metric, labelValues: vs}
+}
+
+type gauge struct {
+	*metric
+	labelValues []string
+}
+
+func (g *gauge) Inc(vs ...float64) {
+	g.instrument.Load().(*gaugeInstrument).Add(sum(vs), g.labelValues...)
+}
+
+func (g *gauge) Dec(vs ...float64) {
+	g.instrument.Load().(*gaugeInstrument).Add(-sum(vs), g.labelValues...)
+}
+
+func (g *gauge) Set(v float64) {
+	g.instrument.Load().(*gaugeInstrument).Set(v, g.labelValues...)
+}
+
+type labeledGauge struct {
+	*metric
+}
+
+func (lg *labeledGauge) WithValues(vs ...string) Gauge {
+	return &gauge{metric: lg.metric, labelValues: vs}
+}
+
+type histogram struct {
+	*metric
+	labelValues []string
+}
+
+func (h *histogram) Observe(v float64) {
+	h.instrument.Load().(*histogramInstrument).Observe(v, h.labelValues...)
+}
+
+type labeledHistogram struct {
+	*metric
+}
+
+func (lh *labeledHistogram) WithValues(vs ...string) Histogram {
+	return &histogram{metric: lh.metric, labelValues: vs}
+}
+
+// bind binds the namespace metrics to the backend, the instruments
+// they were bound to are returned.
+func (n *Namespace) bind(b Backend) []interface{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	previous := make([]interface{}, 0, len(n.metrics))
+	for _, m := range n.metrics {
+		previous = append(previous, m.instrument.Load())
+		m.bind(b)
+	}
+	return previous
+}
+
+// restore binds the namespace metrics to the instruments returned by bind.
+func (n *Namespace) restore(instruments []interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for i, m := range n.metrics {
+		m.instrument.Store(instruments[i])
+	}
+}
+
+// instruments returns the instruments the namespace metrics are bound to.
+func (n *Namespace) instruments() []interface{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	instruments := make([]interface{}, 0, len(n.metrics))
+	for _, m := range n.metrics {
+		switch instrument := m.instrument.Load().(type) {
+		case *counterInstrument:
+			instruments = append(instruments, instrument.CounterVec)
+		case *gaugeInstrument:
+			instruments = append(instruments, instrument.GaugeVec)
+		case *histogramInstrument:
+			instruments = append(instruments, instrument.HistogramVec)
+		case *collectedGaugeInstrument:
+			instruments = append(instruments, instrument.CollectedGaugeVec)
+		}
+	}
+	return instruments
+}
+
+// FullName returns the metric name prefixed by the namespace
+// name and subsystem.
+func (d *Desc) FullName() string {
+	return strings.Join([]string{d.Namespace, d.Subsystem, d.Name}, "_")
+}
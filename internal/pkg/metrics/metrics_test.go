@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// recordingBackend records the values emitted to its instruments.
+type recordingBackend struct {
+	values map[string]float64
+	err    error
+}
+
+func (rb *recordingBackend) record(desc *Desc, labelValues []string, value float64, set bool) {
+	key := desc.FullName() + "{" + strings.Join(labelValues, ",") + "}"
+	if set {
+		rb.values[key] = value
+	} else {
+		rb.values[key] += value
+	}
+}
+
+type recordingInstrument struct {
+	rb   *recordingBackend
+	desc *Desc
+}
+
+func (ri *recordingInstrument) Add(value float64, labelValues ...string) {
+	ri.rb.record(ri.desc, labelValues, value, false)
+}
+
+func (ri *recordingInstrument) Set(value float64, labelValues ...string) {
+	ri.rb.record(ri.desc, labelValues, value, true)
+}
+
+func (ri *recordingInstrument) Observe(value float64, labelValues ...string) {
+	ri.rb.record(ri.desc, labelValues, value, false)
+}
+
+func (rb *recordingBackend) NewCounter(desc *Desc) CounterVec {
+	return &recordingInstrument{rb: rb, desc: desc}
+}
+
+func (rb *recordingBackend) NewGauge(desc *Desc) GaugeVec {
+	return &recordingInstrument{rb: rb, desc: desc}
+}
+
+func (rb *recordingBackend) NewHistogram(desc *Desc) HistogramVec {
+	return &recordingInstrument{rb: rb, desc: desc}
+}
+
+func (rb *recordingBackend) NewCollectedGauge(desc *Desc, collect CollectFunc) CollectedGaugeVec {
+	return &recordingCollectedGauge{collect: collect}
+}
+
+type recordingCollectedGauge struct {
+	collect CollectFunc
+}
+
+func (rg *recordingCollectedGauge) Observations() []Observation {
+	return rg.collect()
+}
+
+func (rb *recordingBackend) Register(*Namespace) error { return rb.err }
+
+func (rb *recordingBackend) Unregister(*Namespace) {}
+
+func TestSetBackend(t *testing.T) {
+	ns := NewNamespace("beskar", "test")
+
+	counter := ns.NewLabeledCounter("requests", "The number of requests", "method")
+	gauge := ns.NewGauge("nodes", "The number of nodes", Total)
+	histogram := ns.NewHistogram("duration", "The request duration", Seconds)
+
+	Register(ns)
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, SetBackend(NewPrometheusBackend(registry)))
+
+	counter.WithValues("GET").Inc()
+	counter.WithValues("GET").Inc(2)
+	gauge.Set(3)
+	gauge.Dec()
+	histogram.Observe(0.5)
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP beskar_test_requests_total The number of requests
+# TYPE beskar_test_requests_total counter
+beskar_test_requests_total{method="GET"} 3
+# HELP beskar_test_nodes_total The number of nodes
+# TYPE beskar_test_nodes_total gauge
+beskar_test_nodes_total 2
+`), "beskar_test_requests_total", "beskar_test_nodes_total"))
+
+	// metrics are emitted to the new backend from now on
+	rb := &recordingBackend{values: make(map[string]float64)}
+	require.NoError(t, SetBackend(rb))
+
+	counter.WithValues("PUT").Inc()
+	gauge.Inc(4)
+	histogram.Observe(1.5)
+
+	require.Equal(t, map[string]float64{
+		"beskar_test_requests{PUT}": 1,
+		"beskar_test_nodes{}":       4,
+		"beskar_test_duration{}":    1.5,
+	}, rb.values)
+
+	// metrics were unregistered from the previous backend
+	count, err := testutil.GatherAndCount(registry)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	// metrics remain bound to the current backend if rejected
+	require.NoError(t, SetBackend(NewPrometheusBackend(registry)))
+	rejecting := &recordingBackend{values: make(map[string]float64), err: errors.New("rejected")}
+	require.EqualError(t, SetBackend(rejecting), "rejected")
+
+	counter.WithValues("GET").Inc()
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP beskar_test_requests_total The number of requests
+# TYPE beskar_test_requests_total counter
+beskar_test_requests_total{method="GET"} 1
+`), "beskar_test_requests_total"))
+	require.Empty(t, rejecting.values)
+
+	require.NoError(t, SetBackend(NewPrometheusBackend(nil)))
+}
+
+func TestCollectedGauge(t *testing.T) {
+	ns := NewNamespace("beskar", "collected")
+
+	var observations atomic.Value
+	observations.Store([]Observation{
+		{Value: 3, LabelValues: []string{"rocky"}},
+		{Value: 5, LabelValues: []string{"alma"}},
+	})
+
+	ns.NewCollectedGauge("packages", "The number of packages", "", func() []Observation {
+		return observations.Load().([]Observation)
+	}, "repository")
+
+	Register(ns)
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, SetBackend(NewPrometheusBackend(registry)))
+	defer func() {
+		require.NoError(t, SetBackend(NewPrometheusBackend(nil)))
+	}()
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP beskar_collected_packages The number of packages
+# TYPE beskar_collected_packages gauge
+beskar_collected_packages{repository="alma"} 5
+beskar_collected_packages{repository="rocky"} 3
+`), "beskar_collected_packages"))
+
+	// series no longer collected disappear
+	observations.Store([]Observation{{Value: 4, LabelValues: []string{"rocky"}}})
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP beskar_collected_packages The number of packages
+# TYPE beskar_collected_packages gauge
+beskar_collected_packages{repository="rocky"} 4
+`), "beskar_collected_packages"))
+
+	// the OpenTelemetry backend observes the collected values
+	otelRegistry := prometheus.NewRegistry()
+	meterProvider, err := NewPrometheusMeterProvider(otelRegistry)
+	require.NoError(t, err)
+	require.NoError(t, SetBackend(NewOpenTelemetryBackend(meterProvider)))
+
+	require.NoError(t, testutil.GatherAndCompare(otelRegistry, strings.NewReader(`
+# HELP beskar_collected_packages The number of packages
+# TYPE beskar_collected_packages gauge
+beskar_collected_packages{repository="rocky"} 4
+`), "beskar_collected_packages"))
+}
+
+func TestPrometheusMeterProvider(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	meterProvider, err := NewPrometheusMeterProvider(registry)
+	require.NoError(t, err)
+
+	backend := NewOpenTelemetryBackend(meterProvider)
+
+	counter := backend.NewCounter(&Desc{
+		Namespace: "beskar",
+		Subsystem: "otel",
+		Name:      "requests",
+		Help:      "The number of requests",
+		Labels:    []string{"method"},
+	})
+	counter.Add(2, "GET")
+
+	// OpenTelemetry instruments are exported with the registerer
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(families))
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	require.Contains(t, names, "beskar_otel_requests_total")
+}
+
+func TestOTLPMeterProvider(t *testing.T) {
+	var pushed atomic.Int32
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/metrics" {
+			pushed.Add(1)
+		}
+	}))
+	defer collector.Close()
+
+	registry := prometheus.NewRegistry()
+
+	meterProvider, err := NewOTLPMeterProvider(context.Background(), config.MetricsExporter{
+		Endpoint: strings.TrimPrefix(collector.URL, "http://"),
+		Insecure: true,
+		Interval: time.Hour,
+	}, registry)
+	require.NoError(t, err)
+
+	counter := NewOpenTelemetryBackend(meterProvider).NewCounter(&Desc{
+		Namespace: "beskar",
+		Subsystem: "otlp",
+		Name:      "requests",
+		Help:      "The number of requests",
+	})
+	counter.Add(1)
+
+	// metrics are still exported with the registerer
+	count, err := testutil.GatherAndCount(registry, "beskar_otlp_requests_total")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// the last values are pushed to the collector on shutdown
+	require.NoError(t, meterProvider.Shutdown(context.Background()))
+	require.Equal(t, int32(1), pushed.Load())
+}
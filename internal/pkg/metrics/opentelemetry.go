@@ -0,0 +1,278 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/unit"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+const meterName = "go.ciq.dev/beskar"
+
+// OpenTelemetryBackend records metrics with OpenTelemetry instruments,
+// metrics are exported by the readers of the meter provider.
+type OpenTelemetryBackend struct {
+	meter otelmetric.Meter
+}
+
+// NewOpenTelemetryBackend returns an OpenTelemetry backend creating
+// instruments with the meter provider.
+func NewOpenTelemetryBackend(provider otelmetric.MeterProvider) *OpenTelemetryBackend {
+	return &OpenTelemetryBackend{
+		meter: provider.Meter(meterName),
+	}
+}
+
+// NewPrometheusMeterProvider returns a meter provider whose instruments are
+// exported with the Prometheus registerer, the default registerer exposed
+// by Handler is used if nil.
+func NewPrometheusMeterProvider(registerer prometheus.Registerer) (otelmetric.MeterProvider, error) {
+	reader, err := newPrometheusReader(registerer)
+	if err != nil {
+		return nil, err
+	}
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), nil
+}
+
+// NewOTLPMeterProvider returns a meter provider whose instruments are
+// pushed every exporter interval to the OTLP HTTP collector and are also
+// exported with the Prometheus registerer like NewPrometheusMeterProvider
+// does. The meter provider must be shut down to push the last values.
+func NewOTLPMeterProvider(ctx context.Context, exporter config.MetricsExporter, registerer prometheus.Registerer) (*sdkmetric.MeterProvider, error) {
+	reader, err := newPrometheusReader(registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []otlpmetrichttp.Option
+	if exporter.Endpoint != "" {
+		options = append(options, otlpmetrichttp.WithEndpoint(exporter.Endpoint))
+	}
+	if exporter.Insecure {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	}
+
+	otlpExporter, err := otlpmetrichttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("while creating OpenTelemetry OTLP exporter: %w", err)
+	}
+
+	var readerOptions []sdkmetric.PeriodicReaderOption
+	if exporter.Interval > 0 {
+		readerOptions = append(readerOptions, sdkmetric.WithInterval(exporter.Interval))
+	}
+
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(otlpExporter, readerOptions...)),
+	), nil
+}
+
+// newPrometheusReader returns a reader exporting the instruments with the
+// Prometheus registerer. Units are left out of the exported names as the
+// exporter only knows a few of them.
+func newPrometheusReader(registerer prometheus.Registerer) (sdkmetric.Reader, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	exporter, err := otelprometheus.New(
+		otelprometheus.WithRegisterer(registerer),
+		otelprometheus.WithoutScopeInfo(),
+		otelprometheus.WithoutUnits(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("while creating OpenTelemetry Prometheus exporter: %w", err)
+	}
+
+	return exporter, nil
+}
+
+func openTelemetryUnit(u Unit) string {
+	switch u {
+	case Seconds:
+		return "s"
+	case Bytes:
+		return string(unit.Bytes)
+	}
+	return string(unit.Dimensionless)
+}
+
+// attributes returns the attributes corresponding to the label values.
+func attributes(desc *Desc, labelValues []string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(desc.Labels))
+	for i, label := range desc.Labels {
+		if i < len(labelValues) {
+			attrs = append(attrs, attribute.String(label, labelValues[i]))
+		}
+	}
+	return attrs
+}
+
+func (ob *OpenTelemetryBackend) NewCounter(desc *Desc) CounterVec {
+	counter, err := ob.meter.Float64Counter(
+		desc.FullName(),
+		instrument.WithDescription(desc.Help),
+		instrument.WithUnit(openTelemetryUnit(desc.Unit)),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return &openTelemetryCounter{desc: desc, counter: counter}
+}
+
+func (ob *OpenTelemetryBackend) NewGauge(desc *Desc) GaugeVec {
+	gauge := &openTelemetryGauge{
+		desc:   desc,
+		values: make(map[string]*gaugeValue),
+	}
+
+	_, err := ob.meter.Float64ObservableGauge(
+		desc.FullName(),
+		instrument.WithDescription(desc.Help),
+		instrument.WithUnit(openTelemetryUnit(desc.Unit)),
+		instrument.WithFloat64Callback(gauge.observe),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	return gauge
+}
+
+func (ob *OpenTelemetryBackend) NewHistogram(desc *Desc) HistogramVec {
+	histogram, err := ob.meter.Float64Histogram(
+		desc.FullName(),
+		instrument.WithDescription(desc.Help),
+		instrument.WithUnit(openTelemetryUnit(desc.Unit)),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return &openTelemetryHistogram{desc: desc, histogram: histogram}
+}
+
+func (ob *OpenTelemetryBackend) NewCollectedGauge(desc *Desc, collect CollectFunc) CollectedGaugeVec {
+	gauge := &openTelemetryCollectedGauge{desc: desc, collect: collect}
+
+	_, err := ob.meter.Float64ObservableGauge(
+		desc.FullName(),
+		instrument.WithDescription(desc.Help),
+		instrument.WithUnit(openTelemetryUnit(desc.Unit)),
+		instrument.WithFloat64Callback(gauge.observe),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	return gauge
+}
+
+// Register is a no-op, instruments are registered with the meter
+// when they are created.
+func (ob *OpenTelemetryBackend) Register(*Namespace) error {
+	return nil
+}
+
+// Unregister is a no-op, instruments remain registered with the meter
+// but are not updated anymore.
+func (ob *OpenTelemetryBackend) Unregister(*Namespace) {}
+
+type openTelemetryCounter struct {
+	desc    *Desc
+	counter instrument.Float64Counter
+}
+
+func (oc *openTelemetryCounter) Add(value float64, labelValues ...string) {
+	if oc.counter != nil {
+		oc.counter.Add(context.Background(), value, attributes(oc.desc, labelValues)...)
+	}
+}
+
+type gaugeValue struct {
+	attrs []attribute.KeyValue
+	value float64
+}
+
+// openTelemetryGauge keeps the gauge values which are reported
+// when the asynchronous gauge is observed.
+type openTelemetryGauge struct {
+	desc   *Desc
+	mutex  sync.Mutex
+	values map[string]*gaugeValue
+}
+
+func (og *openTelemetryGauge) value(labelValues []string) *gaugeValue {
+	key := strings.Join(labelValues, "\x00")
+	gv, ok := og.values[key]
+	if !ok {
+		gv = &gaugeValue{attrs: attributes(og.desc, labelValues)}
+		og.values[key] = gv
+	}
+	return gv
+}
+
+func (og *openTelemetryGauge) Add(value float64, labelValues ...string) {
+	og.mutex.Lock()
+	og.value(labelValues).value += value
+	og.mutex.Unlock()
+}
+
+func (og *openTelemetryGauge) Set(value float64, labelValues ...string) {
+	og.mutex.Lock()
+	og.value(labelValues).value = value
+	og.mutex.Unlock()
+}
+
+func (og *openTelemetryGauge) observe(_ context.Context, observer instrument.Float64Observer) error {
+	og.mutex.Lock()
+	defer og.mutex.Unlock()
+
+	for _, gv := range og.values {
+		observer.Observe(gv.value, gv.attrs...)
+	}
+	return nil
+}
+
+// openTelemetryCollectedGauge reports the observations of the
+// collect function when the asynchronous gauge is observed.
+type openTelemetryCollectedGauge struct {
+	desc    *Desc
+	collect CollectFunc
+}
+
+func (og *openTelemetryCollectedGauge) Observations() []Observation {
+	return og.collect()
+}
+
+func (og *openTelemetryCollectedGauge) observe(_ context.Context, observer instrument.Float64Observer) error {
+	for _, o := range og.collect() {
+		observer.Observe(o.Value, attributes(og.desc, o.LabelValues)...)
+	}
+	return nil
+}
+
+type openTelemetryHistogram struct {
+	desc      *Desc
+	histogram instrument.Float64Histogram
+}
+
+func (oh *openTelemetryHistogram) Observe(value float64, labelValues ...string) {
+	if oh.histogram != nil {
+		oh.histogram.Record(context.Background(), value, attributes(oh.desc, labelValues)...)
+	}
+}
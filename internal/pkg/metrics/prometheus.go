@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusBackend registers metrics with a Prometheus registry, the
+// unit is appended to metric names.
+type PrometheusBackend struct {
+	registerer prometheus.Registerer
+}
+
+// NewPrometheusBackend returns a Prometheus backend registering metrics
+// with the registerer, the default registerer is used if nil.
+func NewPrometheusBackend(registerer prometheus.Registerer) *PrometheusBackend {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	return &PrometheusBackend{
+		registerer: registerer,
+	}
+}
+
+func prometheusName(desc *Desc) string {
+	if desc.Unit == "" {
+		return desc.Name
+	}
+	return fmt.Sprintf("%s_%s", desc.Name, desc.Unit)
+}
+
+func (pb *PrometheusBackend) NewCounter(desc *Desc) CounterVec {
+	vec := &prometheusCounter{
+		CounterVec: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: desc.Namespace,
+			Subsystem: desc.Subsystem,
+			Name:      prometheusName(desc),
+			Help:      desc.Help,
+		}, desc.Labels),
+	}
+	if len(desc.Labels) == 0 {
+		// metrics without labels are exported before their first update
+		vec.CounterVec.WithLabelValues()
+	}
+	return vec
+}
+
+func (pb *PrometheusBackend) NewGauge(desc *Desc) GaugeVec {
+	vec := &prometheusGauge{
+		GaugeVec: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: desc.Namespace,
+			Subsystem: desc.Subsystem,
+			Name:      prometheusName(desc),
+			Help:      desc.Help,
+		}, desc.Labels),
+	}
+	if len(desc.Labels) == 0 {
+		// metrics without labels are exported before their first update
+		vec.GaugeVec.WithLabelValues()
+	}
+	return vec
+}
+
+func (pb *PrometheusBackend) NewHistogram(desc *Desc) HistogramVec {
+	vec := &prometheusHistogram{
+		HistogramVec: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: desc.Namespace,
+			Subsystem: desc.Subsystem,
+			Name:      prometheusName(desc),
+			Help:      desc.Help,
+		}, desc.Labels),
+	}
+	if len(desc.Labels) == 0 {
+		// metrics without labels are exported before their first update
+		vec.HistogramVec.WithLabelValues()
+	}
+	return vec
+}
+
+func (pb *PrometheusBackend) NewCollectedGauge(desc *Desc, collect CollectFunc) CollectedGaugeVec {
+	return &prometheusCollectedGauge{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(desc.Namespace, desc.Subsystem, prometheusName(desc)),
+			desc.Help, desc.Labels, nil,
+		),
+		collect: collect,
+	}
+}
+
+func (pb *PrometheusBackend) Register(ns *Namespace) error {
+	for _, instrument := range ns.instruments() {
+		if collector, ok := instrument.(prometheus.Collector); ok {
+			if err := pb.registerer.Register(collector); err != nil {
+				return fmt.Errorf("while registering %s_%s metrics: %w", ns.Name(), ns.Subsystem(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (pb *PrometheusBackend) Unregister(ns *Namespace) {
+	for _, instrument := range ns.instruments() {
+		if collector, ok := instrument.(prometheus.Collector); ok {
+			pb.registerer.Unregister(collector)
+		}
+	}
+}
+
+type prometheusCounter struct {
+	*prometheus.CounterVec
+}
+
+func (pc *prometheusCounter) Add(value float64, labelValues ...string) {
+	pc.WithLabelValues(labelValues...).Add(value)
+}
+
+type prometheusGauge struct {
+	*prometheus.GaugeVec
+}
+
+func (pg *prometheusGauge) Add(value float64, labelValues ...string) {
+	pg.WithLabelValues(labelValues...).Add(value)
+}
+
+func (pg *prometheusGauge) Set(value float64, labelValues ...string) {
+	pg.WithLabelValues(labelValues...).Set(value)
+}
+
+type prometheusHistogram struct {
+	*prometheus.HistogramVec
+}
+
+func (ph *prometheusHistogram) Observe(value float64, labelValues ...string) {
+	ph.WithLabelValues(labelValues...).Observe(value)
+}
+
+// prometheusCollectedGauge is a collector exporting the
+// observations of the collect function.
+type prometheusCollectedGauge struct {
+	desc    *prometheus.Desc
+	collect CollectFunc
+}
+
+func (pg *prometheusCollectedGauge) Observations() []Observation {
+	return pg.collect()
+}
+
+func (pg *prometheusCollectedGauge) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pg.desc
+}
+
+func (pg *prometheusCollectedGauge) Collect(ch chan<- prometheus.Metric) {
+	for _, o := range pg.collect() {
+		m, err := prometheus.NewConstMetric(pg.desc, prometheus.GaugeValue, o.Value, o.LabelValues...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(pg.desc, err)
+		}
+		ch <- m
+	}
+}
@@ -33,11 +33,17 @@ func WithDisableSSL(disable bool) AuthMethodOption {
 	}
 }
 
+// NewAuthMethod returns an S3 session for the endpoint, the AWS endpoint
+// of the configured region is used when the endpoint is empty.
 func NewAuthMethod(endpoint string, options ...AuthMethodOption) (*AuthMethod, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Endpoint:         aws.String(endpoint),
+	cfg := &aws.Config{
 		S3ForcePathStyle: aws.Bool(true),
-	})
+	}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+
+	sess, err := session.NewSession(cfg)
 	if err != nil {
 		return nil, err
 	}
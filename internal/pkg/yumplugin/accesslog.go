@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.ciq.dev/beskar/internal/pkg/accesslog"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+type accessLogRecordKey struct{}
+
+// accessLogRecord holds the request information only known by handlers.
+type accessLogRecord struct {
+	identity string
+}
+
+// setAccessIdentity records the identity of the client for the access log.
+func setAccessIdentity(r *http.Request, identity string) {
+	if record, ok := r.Context().Value(accessLogRecordKey{}).(*accessLogRecord); ok {
+		record.identity = identity
+	}
+}
+
+// accessLogger emits one structured record per repository request.
+type accessLogger struct {
+	*accesslog.Logger
+}
+
+func newAccessLogger(accessLog config.AccessLog) (*accessLogger, error) {
+	logger, err := accesslog.New(accessLog)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLogger{Logger: logger}, nil
+}
+
+// middleware logs requests of named routes, the route name
+// is the repository operation.
+func (al *accessLogger) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || route.GetName() == "" || !al.Sampled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		record := new(accessLogRecord)
+		body := accesslog.NewRequestBody(r)
+		rw := accesslog.NewResponseWriter(w)
+		start := time.Now()
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), accessLogRecordKey{}, record)))
+
+		al.Log(map[string]interface{}{
+			config.AccessLogFieldIdentity:   record.identity,
+			config.AccessLogFieldRepository: mux.Vars(r)["repository"],
+			config.AccessLogFieldOperation:  route.GetName(),
+			config.AccessLogFieldMethod:     r.Method,
+			config.AccessLogFieldPath:       r.URL.Path,
+			config.AccessLogFieldHeaders:    accesslog.RedactHeaders(r.Header),
+			config.AccessLogFieldBytes:      rw.Bytes,
+			config.AccessLogFieldBytesIn:    body.Bytes,
+			config.AccessLogFieldStatus:     rw.Status,
+			config.AccessLogFieldDuration:   time.Since(start).Seconds(),
+		})
+	})
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
+)
+
+// bootstrapRepositories creates the repositories declared in the configuration
+// which don't exist yet. A repository exists as soon as its database is present
+// in the storage, existing repositories are left untouched.
+func (p *Plugin) bootstrapRepositories(ctx context.Context) {
+	for _, repo := range p.beskarYumConfig.Bootstrap {
+		if err := p.bootstrapRepository(ctx, repo.Name); err != nil {
+			logrus.Errorf("while bootstrapping repository %s: %s", repo.Name, err)
+		}
+	}
+}
+
+// bootstrapRepository holds the repository lock so packages processed
+// meanwhile don't race with the database creation.
+func (p *Plugin) bootstrapRepository(ctx context.Context, repoName string) error {
+	unlock := p.repoLocks.lock(repoName)
+	defer unlock()
+
+	key := p.storageLayout.RepositoryKey(repoName, "doltdb.tar.lz4")
+
+	exists, err := p.bucket.Exists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("while checking repository database: %w", err)
+	} else if exists {
+		return nil
+	}
+
+	dbPath, err := os.MkdirTemp(p.beskarYumConfig.DataDir, "db-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary database directory: %w", err)
+	}
+
+	if err := p.createEmptyDatabase(ctx, dbPath, key); err != nil {
+		_ = os.RemoveAll(dbPath)
+		return err
+	}
+
+	// the database directory is removed by the metadata generation
+	return p.GenerateAndSaveMetadata(ctx, filepath.Join(pluginName, repoName), dbPath, true)
+}
+
+func (p *Plugin) createEmptyDatabase(ctx context.Context, dbPath, key string) error {
+	db, err := yumdb.Open(dbPath)
+	if err != nil {
+		return fmt.Errorf("while opening dolt database: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("while closing dolt database: %w", err)
+	}
+
+	return p.pushDatabase(ctx, key, dbPath)
+}
@@ -0,0 +1,496 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+	"go.ciq.dev/beskar/pkg/oras"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	// chunkedUploadStateFile is the object holding the state of a
+	// resumable upload, chunks are stored next to it.
+	chunkedUploadStateFile = "state.json"
+	// chunkedUploadGCInterval is the interval between two garbage
+	// collections of the interrupted resumable uploads.
+	chunkedUploadGCInterval = 10 * time.Minute
+)
+
+// contentRangeRegexp matches the Content-Range header of the chunks
+// sent with PATCH requests as defined by the OCI distribution spec.
+var contentRangeRegexp = regexp.MustCompile(`^([0-9]+)-([0-9]+)$`)
+
+var (
+	errChunkedUploadNotFound = errors.New("upload not found")
+	errChunkedUploadDigest   = errors.New("uploaded package doesn't match the digest")
+	errChunkedUploadFilename = errors.New("filename must be the base name of a .rpm file")
+	errChunkedUploadRange    = errors.New("chunk doesn't match the content range")
+)
+
+// chunkedUpload is the persisted state of a resumable package upload,
+// the chunks received are stored as objects next to the state which is
+// updated once a chunk has been entirely written.
+type chunkedUpload struct {
+	ID         string `json:"id"`
+	Repository string `json:"repository"`
+	Filename   string `json:"filename"`
+	// Chunks are the sizes of the chunks received in order.
+	Chunks  []int64   `json:"chunks"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+}
+
+// size returns the number of bytes received.
+func (u *chunkedUpload) size() int64 {
+	size := int64(0)
+	for _, chunk := range u.Chunks {
+		size += chunk
+	}
+	return size
+}
+
+// location returns the plugin URL path of the upload.
+func (u *chunkedUpload) location() string {
+	return fmt.Sprintf("/yum/api/v1/repo/%s/uploads/%s", u.Repository, u.ID)
+}
+
+// setRangeHeaders sets the headers reporting the upload progress,
+// the range of the received bytes is reported as 0-0 before the
+// first chunk as done by the registry.
+func (u *chunkedUpload) setRangeHeaders(w http.ResponseWriter) {
+	end := u.size() - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Location", u.location())
+	w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+}
+
+func (p *Plugin) chunkedUploadKey(id, file string) string {
+	return p.storageLayout.UploadsKey(path.Join(id, file))
+}
+
+// chunkKey returns the object key of the chunk starting at offset, keys
+// are padded so that chunks are listed in order.
+func (p *Plugin) chunkKey(id string, offset int64) string {
+	return p.chunkedUploadKey(id, fmt.Sprintf("%020d", offset))
+}
+
+func (p *Plugin) saveChunkedUpload(ctx context.Context, upload *chunkedUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return p.bucket.WriteAll(ctx, p.chunkedUploadKey(upload.ID, chunkedUploadStateFile), data, &blob.WriterOptions{
+		ContentType: "application/json",
+	})
+}
+
+// startChunkedUpload starts a resumable upload of the package named
+// filename to the repository.
+func (p *Plugin) startChunkedUpload(ctx context.Context, repository, filename string) (*chunkedUpload, error) {
+	if filename != filepath.Base(filename) || !strings.HasSuffix(filename, ".rpm") {
+		return nil, errChunkedUploadFilename
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	upload := &chunkedUpload{
+		ID:         hex.EncodeToString(b),
+		Repository: repository,
+		Filename:   filename,
+		Started:    now,
+		Updated:    now,
+	}
+	if err := p.saveChunkedUpload(ctx, upload); err != nil {
+		return nil, fmt.Errorf("while saving upload state: %w", err)
+	}
+
+	return upload, nil
+}
+
+// loadChunkedUpload returns the state of an upload of the repository,
+// expired uploads waiting for the garbage collection are not returned.
+func (p *Plugin) loadChunkedUpload(ctx context.Context, repository, id string) (*chunkedUpload, error) {
+	if _, err := hex.DecodeString(id); err != nil {
+		return nil, errChunkedUploadNotFound
+	}
+
+	data, err := p.bucket.ReadAll(ctx, p.chunkedUploadKey(id, chunkedUploadStateFile))
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, errChunkedUploadNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading upload state: %w", err)
+	}
+
+	upload := new(chunkedUpload)
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, fmt.Errorf("while decoding upload state: %w", err)
+	} else if upload.Repository != repository || p.chunkedUploadExpired(upload, time.Now()) {
+		return nil, errChunkedUploadNotFound
+	}
+
+	return upload, nil
+}
+
+func (p *Plugin) chunkedUploadExpired(upload *chunkedUpload, now time.Time) bool {
+	return now.Sub(upload.Updated) > p.beskarYumConfig.Uploads.ResumableTTL
+}
+
+// writeChunk appends the chunk read from r to the upload, size is the
+// expected chunk size or -1 when unknown. The upload state is only updated
+// once the chunk has been entirely written, a chunk interrupted by a client
+// disconnection is sent again from the offset reported by the state.
+func (p *Plugin) writeChunk(ctx context.Context, upload *chunkedUpload, r io.Reader, size int64) error {
+	offset := upload.size()
+	key := p.chunkKey(upload.ID, offset)
+
+	w, err := p.bucket.NewWriter(ctx, key, &blob.WriterOptions{})
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("%w: got %d bytes, expected %d", errChunkedUploadRange, n, size)
+	}
+	if err != nil || n == 0 {
+		_ = p.bucket.Delete(ctx, key)
+		return err
+	}
+
+	upload.Chunks = append(upload.Chunks, n)
+	upload.Updated = time.Now()
+
+	return p.saveChunkedUpload(ctx, upload)
+}
+
+// completeChunkedUpload assembles the chunks of the upload in the staging
+// directory, checks the package digest and pushes the package to the
+// repository, the package is then processed like the packages pushed to
+// the registry. The upload is deleted once the package is pushed, it can
+// be completed again if the push fails. It returns the package ID.
+func (p *Plugin) completeChunkedUpload(ctx context.Context, upload *chunkedUpload, digest string) (string, error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("%w: unsupported digest %q", errChunkedUploadDigest, digest)
+	}
+
+	stagingDir, err := os.MkdirTemp(p.stagingDir, stagingPattern)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	packagePath := filepath.Join(stagingDir, upload.Filename)
+
+	id, err := p.assembleChunks(ctx, upload, packagePath)
+	if err != nil {
+		return "", err
+	} else if "sha256:"+id != digest {
+		return "", fmt.Errorf("%w: got sha256:%s", errChunkedUploadDigest, id)
+	}
+
+	ref, err := name.ParseReference(filepath.Join(p.registry, pluginName, upload.Repository, "packages:"+id), p.nameOptions...)
+	if err != nil {
+		return "", err
+	}
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+		return oras.Push(orasrpm.NewRPMPusher(ref, packagePath), options...)
+	})
+	if err != nil {
+		return "", fmt.Errorf("while pushing package %s: %w", upload.Filename, err)
+	}
+
+	if err := p.deleteChunkedUpload(ctx, upload.ID); err != nil {
+		logrus.Warnf("upload %s of package %s not deleted: %s", upload.ID, upload.Filename, err)
+	}
+
+	return id, nil
+}
+
+// assembleChunks writes the chunks of the upload to the file and returns
+// the sha256 checksum of the package.
+func (p *Plugin) assembleChunks(ctx context.Context, upload *chunkedUpload, path string) (string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	w := io.MultiWriter(f, sum)
+
+	offset := int64(0)
+	for _, size := range upload.Chunks {
+		r, err := p.bucket.NewReader(ctx, p.chunkKey(upload.ID, offset), &blob.ReaderOptions{})
+		if err != nil {
+			return "", fmt.Errorf("while reading upload chunk at offset %d: %w", offset, err)
+		}
+		n, err := io.Copy(w, r)
+		_ = r.Close()
+		if err != nil {
+			return "", fmt.Errorf("while reading upload chunk at offset %d: %w", offset, err)
+		} else if n != size {
+			return "", fmt.Errorf("upload chunk at offset %d has %d bytes, expected %d", offset, n, size)
+		}
+		offset += size
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// deleteChunkedUpload deletes the chunks then the state of the upload so
+// that an upload partially deleted is still collected.
+func (p *Plugin) deleteChunkedUpload(ctx context.Context, id string) error {
+	stateKey := p.chunkedUploadKey(id, chunkedUploadStateFile)
+
+	iter := p.bucket.List(&blob.ListOptions{Prefix: p.chunkedUploadKey(id, "") + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if obj.Key == stateKey {
+			continue
+		}
+		if err := p.bucket.Delete(ctx, obj.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+	}
+
+	if err := p.bucket.Delete(ctx, stateKey); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+
+	return nil
+}
+
+// CollectChunkedUploads deletes the resumable uploads which didn't receive
+// any chunk during the configured TTL and returns the number of deleted
+// uploads.
+func (p *Plugin) CollectChunkedUploads(ctx context.Context, now time.Time) (int, error) {
+	var (
+		expired []string
+		errs    []error
+	)
+
+	iter := p.bucket.List(&blob.ListOptions{Prefix: p.storageLayout.UploadsKey("") + "/", Delimiter: "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		} else if !obj.IsDir {
+			continue
+		}
+
+		id := path.Base(obj.Key)
+
+		data, err := p.bucket.ReadAll(ctx, p.chunkedUploadKey(id, chunkedUploadStateFile))
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			// chunks left by an interrupted deletion
+			expired = append(expired, id)
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		upload := new(chunkedUpload)
+		if err := json.Unmarshal(data, upload); err != nil || p.chunkedUploadExpired(upload, now) {
+			expired = append(expired, id)
+		}
+	}
+
+	deleted := 0
+	for _, id := range expired {
+		if err := p.deleteChunkedUpload(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("while deleting upload %s: %w", id, err))
+			continue
+		}
+		deleted++
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+// scheduleChunkedUploadsCollection periodically deletes the expired
+// resumable uploads.
+func (p *Plugin) scheduleChunkedUploadsCollection(ctx context.Context) {
+	ticker := time.NewTicker(chunkedUploadGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := p.CollectChunkedUploads(ctx, now)
+			if err != nil {
+				logrus.Errorf("resumable uploads garbage collection: %s", err)
+			}
+			if deleted > 0 {
+				logrus.Infof("resumable uploads garbage collection: %d expired uploads deleted", deleted)
+			}
+		}
+	}
+}
+
+// chunkedUploadsHandler starts a resumable upload with a POST request, the
+// filename query parameter is the package file name. The upload location
+// is returned in the Location header.
+func chunkedUploadsHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		upload, err := plugin.startChunkedUpload(r.Context(), mux.Vars(r)["repository"], r.URL.Query().Get("filename"))
+		if errors.Is(err, errChunkedUploadFilename) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		upload.setRangeHeaders(w)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// chunkedUploadHandler serves a resumable upload with the semantics of the
+// OCI distribution chunked uploads:
+//   - GET reports the received range to resume the upload from
+//   - PATCH appends a chunk, the Content-Range header must start at the
+//     end of the received range, a 416 response is returned otherwise
+//   - PUT completes the upload with the digest query parameter, the
+//     request body is an optional last chunk
+//   - DELETE cancels the upload
+func chunkedUploadHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodPatch, http.MethodPut, http.MethodDelete:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// requests of the same upload are serialized
+		unlock := plugin.chunkedLocks.lock(vars["id"])
+		defer unlock()
+
+		upload, err := plugin.loadChunkedUpload(r.Context(), vars["repository"], vars["id"])
+		if errors.Is(err, errChunkedUploadNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			upload.setRangeHeaders(w)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			if err := plugin.deleteChunkedUpload(r.Context(), upload.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPatch:
+			size := int64(-1)
+
+			if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+				m := contentRangeRegexp.FindStringSubmatch(contentRange)
+				if m == nil {
+					http.Error(w, fmt.Sprintf("malformed Content-Range %q", contentRange), http.StatusBadRequest)
+					return
+				}
+				start, _ := strconv.ParseInt(m[1], 10, 64)
+				end, _ := strconv.ParseInt(m[2], 10, 64)
+				if start != upload.size() || end < start {
+					upload.setRangeHeaders(w)
+					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+				size = end - start + 1
+			}
+
+			if err := plugin.writeChunk(r.Context(), upload, r.Body, size); errors.Is(err, errChunkedUploadRange) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			upload.setRangeHeaders(w)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			if err := plugin.writeChunk(r.Context(), upload, r.Body, -1); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			id, err := plugin.completeChunkedUpload(r.Context(), upload, r.URL.Query().Get("digest"))
+			if errors.Is(err, errChunkedUploadDigest) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if errors.Is(err, errRegistryUnavailable) {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/%s/packages/blobs/sha256:%s", pluginName, upload.Repository, id))
+			w.WriteHeader(http.StatusCreated)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/gorilla/mux"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	ctx := context.Background()
+
+	vr := newVerifyTestRepository(t)
+	plugin := vr.plugin
+	plugin.stagingDir = t.TempDir()
+	plugin.beskarYumConfig.Uploads.ResumableTTL = time.Hour
+
+	router := mux.NewRouter()
+	router.HandleFunc("/yum/api/v1/repo/{repository}/uploads", chunkedUploadsHandler(plugin))
+	router.HandleFunc("/yum/api/v1/repo/{repository}/uploads/{id}", chunkedUploadHandler(plugin))
+
+	serve := func(method, target, contentRange, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/yum/api/v1/repo/rocky/uploads?filename=../pkg.rpm", "", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(http.MethodPost, "/yum/api/v1/repo/rocky/uploads?filename=pkg-1.0-1.x86_64.rpm", "", "")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Equal(t, "0-0", rec.Header().Get("Range"))
+	location := rec.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "/yum/api/v1/repo/rocky/uploads/"))
+
+	// chunks must start at the end of the received range
+	rec = serve(http.MethodPatch, location, "0-3", "abcd")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Equal(t, "0-3", rec.Header().Get("Range"))
+
+	rec = serve(http.MethodPatch, location, "0-3", "abcd")
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+	require.Equal(t, "0-3", rec.Header().Get("Range"))
+
+	// an interrupted chunk isn't recorded
+	rec = serve(http.MethodPatch, location, "4-9", "efg")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// the upload state is persisted, the upload is resumed from the
+	// received range by another plugin instance
+	resumed := &Plugin{
+		registry:        plugin.registry,
+		stagingDir:      plugin.stagingDir,
+		nameOptions:     plugin.nameOptions,
+		bucket:          plugin.bucket,
+		storageLayout:   plugin.storageLayout,
+		beskarYumConfig: plugin.beskarYumConfig,
+	}
+	router = mux.NewRouter()
+	router.HandleFunc("/yum/api/v1/repo/{repository}/uploads/{id}", chunkedUploadHandler(resumed))
+
+	rec = serve(http.MethodGet, location, "", "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "0-3", rec.Header().Get("Range"))
+	require.Equal(t, location, rec.Header().Get("Location"))
+
+	// uploads are scoped to their repository
+	rec = serve(http.MethodGet, strings.Replace(location, "/rocky/", "/other/", 1), "", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(http.MethodPatch, location, "", "efgh")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Equal(t, "0-7", rec.Header().Get("Range"))
+
+	// the digest is checked before the package is pushed
+	rec = serve(http.MethodPut, location+"?digest=sha256:"+checksum("other"), "", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	id := checksum("abcdefghij")
+
+	rec = serve(http.MethodPut, location+"?digest=sha256:"+id, "", "ij")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, "/v2/yum/rocky/packages/blobs/sha256:"+id, rec.Header().Get("Location"))
+
+	ref, err := name.ParseReference(vr.host+"/yum/rocky/packages:"+id, name.Insecure)
+	require.NoError(t, err)
+	manifest, err := remote.Get(ref)
+	require.NoError(t, err)
+	img, err := manifest.Image()
+	require.NoError(t, err)
+	m, err := img.Manifest()
+	require.NoError(t, err)
+	require.Len(t, m.Layers, 1)
+	require.Equal(t, id, m.Layers[0].Digest.Hex)
+	require.Equal(t, "pkg-1.0-1.x86_64.rpm", m.Layers[0].Annotations[imagespec.AnnotationTitle])
+
+	// the upload is deleted once completed
+	rec = serve(http.MethodGet, location, "", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// uploads are cancelled with DELETE
+	upload, err := plugin.startChunkedUpload(ctx, "rocky", "cancelled.rpm")
+	require.NoError(t, err)
+	rec = serve(http.MethodDelete, upload.location(), "", "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve(http.MethodGet, upload.location(), "", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCollectChunkedUploads(t *testing.T) {
+	ctx := context.Background()
+
+	plugin := newVerifyTestRepository(t).plugin
+	plugin.beskarYumConfig.Uploads.ResumableTTL = time.Hour
+
+	active, err := plugin.startChunkedUpload(ctx, "rocky", "active.rpm")
+	require.NoError(t, err)
+	require.NoError(t, plugin.writeChunk(ctx, active, strings.NewReader("active"), -1))
+
+	abandoned, err := plugin.startChunkedUpload(ctx, "rocky", "abandoned.rpm")
+	require.NoError(t, err)
+	require.NoError(t, plugin.writeChunk(ctx, abandoned, strings.NewReader("abandoned"), -1))
+	abandoned.Updated = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, plugin.saveChunkedUpload(ctx, abandoned))
+
+	// expired uploads can't be resumed
+	_, err = plugin.loadChunkedUpload(ctx, "rocky", abandoned.ID)
+	require.ErrorIs(t, err, errChunkedUploadNotFound)
+
+	deleted, err := plugin.CollectChunkedUploads(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	keys := func(id string) []string {
+		var keys []string
+		iter := plugin.bucket.List(&blob.ListOptions{Prefix: plugin.chunkedUploadKey(id, "") + "/"})
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				return keys
+			}
+			require.NoError(t, err)
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	require.Empty(t, keys(abandoned.ID))
+	require.Len(t, keys(active.ID), 2)
+
+	_, err = plugin.loadChunkedUpload(ctx, "rocky", active.ID)
+	require.NoError(t, err)
+
+	// the active upload expires once it didn't receive any chunk
+	deleted, err = plugin.CollectChunkedUploads(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.Empty(t, keys(active.ID))
+}
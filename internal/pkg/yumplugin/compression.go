@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+)
+
+// metadataCodec compresses the XML metadata files, the file extension
+// tells clients how to decompress them.
+type metadataCodec struct {
+	ext string
+	// mediatypes are the layer media types by uncompressed file name
+	mediatypes map[string]string
+	newWriter  func(io.Writer) (io.WriteCloser, error)
+}
+
+var metadataCodecs = map[string]metadataCodec{
+	config.MetadataCompressionGzip: {
+		ext: ".gz",
+		mediatypes: map[string]string{
+			primaryXMLFile:   orasrpm.PrimaryXMLLayerType,
+			filelistsXMLFile: orasrpm.FilelistsXMLLayerType,
+			otherXMLFile:     orasrpm.OtherXMLLayerType,
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	},
+	config.MetadataCompressionXZ: {
+		ext: ".xz",
+		mediatypes: map[string]string{
+			primaryXMLFile:   orasrpm.PrimaryXMLXZLayerType,
+			filelistsXMLFile: orasrpm.FilelistsXMLXZLayerType,
+			otherXMLFile:     orasrpm.OtherXMLXZLayerType,
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+	},
+	config.MetadataCompressionZstd: {
+		ext: ".zst",
+		mediatypes: map[string]string{
+			primaryXMLFile:   orasrpm.PrimaryXMLZstdLayerType,
+			filelistsXMLFile: orasrpm.FilelistsXMLZstdLayerType,
+			otherXMLFile:     orasrpm.OtherXMLZstdLayerType,
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+	},
+}
+
+// getMetadataCodec returns the codec of the compression, gzip is used
+// when the compression isn't set.
+func getMetadataCodec(compression string) (metadataCodec, error) {
+	if compression == "" {
+		compression = config.DefaultMetadataCompression
+	}
+	codec, ok := metadataCodecs[compression]
+	if !ok {
+		return metadataCodec{}, fmt.Errorf("unknown metadata compression %q", compression)
+	}
+	return codec, nil
+}
+
+// isPrimaryXMLLayer returns true if the media type is the
+// media type of a primary.xml layer of any codec.
+func isPrimaryXMLLayer(mediatype string) bool {
+	for _, codec := range metadataCodecs {
+		if codec.mediatypes[primaryXMLFile] == mediatype {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// metadataReader reads a decompressed metadata file, closing it closes
+// the decompressor and the compressed file.
+type metadataReader struct {
+	io.Reader
+	closeFn func() error
+	file    io.Closer
+}
+
+func (mr *metadataReader) Close() error {
+	err := mr.closeFn()
+	if fileErr := mr.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// newMetadataReader returns a reader decompressing the metadata
+// file, the codec is detected from the file magic number.
+func newMetadataReader(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataReader{Reader: gzr, closeFn: gzr.Close, file: rc}, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		xzr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataReader{Reader: xzr, closeFn: func() error { return nil }, file: rc}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &metadataReader{Reader: zr, closeFn: func() error { zr.Close(); return nil }, file: rc}, nil
+	}
+
+	return nil, fmt.Errorf("unknown metadata compression format")
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+)
+
+func TestMetadataCompression(t *testing.T) {
+	tests := []struct {
+		compression   string
+		wantFile      string
+		wantMediatype string
+	}{
+		{
+			compression:   "",
+			wantFile:      "primary.xml.gz",
+			wantMediatype: orasrpm.PrimaryXMLLayerType,
+		},
+		{
+			compression:   config.MetadataCompressionXZ,
+			wantFile:      "primary.xml.xz",
+			wantMediatype: orasrpm.PrimaryXMLXZLayerType,
+		},
+		{
+			compression:   config.MetadataCompressionZstd,
+			wantFile:      "primary.xml.zst",
+			wantMediatype: orasrpm.PrimaryXMLZstdLayerType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantFile, func(t *testing.T) {
+			dir := t.TempDir()
+
+			codec, err := getMetadataCodec(tt.compression)
+			require.NoError(t, err)
+
+			primary, err := newPrimaryXML(dir, 1, codec)
+			require.NoError(t, err)
+			require.NoError(t, primary.add(strings.NewReader("<package/>\n")))
+			require.NoError(t, primary.save(primaryFooter))
+
+			require.Equal(t, filepath.Join(dir, tt.wantFile), primary.Path())
+			require.Equal(t, "repodata/"+tt.wantFile, primary.href())
+			require.Equal(t, tt.wantMediatype, primary.Mediatype())
+			require.True(t, isPrimaryXMLLayer(primary.Mediatype()))
+
+			f, err := os.Open(primary.Path())
+			require.NoError(t, err)
+
+			mr, err := newMetadataReader(f)
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(mr)
+			require.NoError(t, err)
+			require.NoError(t, mr.Close())
+
+			require.Equal(t, primary.openSize, len(data))
+			require.True(t, strings.HasSuffix(string(data), "<package/>\n"+primaryFooter+"\n"))
+		})
+	}
+
+	_, err := getMetadataCodec("bzip2")
+	require.ErrorContains(t, err, `unknown metadata compression "bzip2"`)
+
+	_, err = newMetadataReader(io.NopCloser(strings.NewReader("<metadata/>")))
+	require.ErrorContains(t, err, "unknown metadata compression format")
+}
@@ -6,17 +6,65 @@ package yumplugin
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
 	"gocloud.dev/blob"
 )
 
-func (p *Plugin) AddPackageToDatabase(ctx context.Context, id, repository, packageDir string, execute, keepDatabaseDir bool) (string, error) {
+// repositoryLocks serializes the updates of the repository databases, the
+// databases are cloned, modified and pushed back so concurrent updates of
+// the same repository would overwrite each other.
+type repositoryLocks struct {
+	mutex sync.Mutex
+	locks map[string]*repositoryLock
+}
+
+type repositoryLock struct {
+	sync.Mutex
+	holders int
+}
+
+// lock locks the repository database and returns the function releasing
+// it, locks are dropped once released by all their holders.
+func (rl *repositoryLocks) lock(repository string) func() {
+	rl.mutex.Lock()
+	if rl.locks == nil {
+		rl.locks = make(map[string]*repositoryLock)
+	}
+	l, ok := rl.locks[repository]
+	if !ok {
+		l = &repositoryLock{}
+		rl.locks[repository] = l
+	}
+	l.holders++
+	rl.mutex.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		rl.mutex.Lock()
+		l.holders--
+		if l.holders == 0 {
+			delete(rl.locks, repository)
+		}
+		rl.mutex.Unlock()
+	}
+}
+
+// AddPackageToDatabase adds the package to the repository database and returns
+// the database directory, an empty directory is returned without error if
+// the package was already present in the database. The repository database
+// must be locked by the caller.
+func (p *Plugin) AddPackageToDatabase(ctx context.Context, id, repository, packageDir, idempotencyKey string, execute, keepDatabaseDir bool) (string, error) {
 	if execute {
 		stdout := new(bytes.Buffer)
 		stderr := new(bytes.Buffer)
@@ -27,6 +75,7 @@ func (p *Plugin) AddPackageToDatabase(ctx context.Context, id, repository, packa
 			fmt.Sprintf("-dir=%s", packageDir),
 			fmt.Sprintf("-id=%s", id),
 			fmt.Sprintf("-repository=%s", repository),
+			fmt.Sprintf("-idempotency-key=%s", idempotencyKey),
 		}
 
 		if keepDatabaseDir {
@@ -46,32 +95,28 @@ func (p *Plugin) AddPackageToDatabase(ctx context.Context, id, repository, packa
 		return strings.TrimSpace(stdout.String()), nil
 	}
 
-	return p.addPackageToDatabase(ctx, id, repository, packageDir, keepDatabaseDir)
+	return p.addPackageToDatabase(ctx, id, repository, packageDir, idempotencyKey, keepDatabaseDir)
 }
 
-func (p *Plugin) addPackageToDatabase(ctx context.Context, id, repository, packageDir string, keepDatabaseDir bool) (string, error) {
-	key := filepath.Join(filepath.Dir(repository), "doltdb.tar.lz4")
+func (p *Plugin) addPackageToDatabase(ctx context.Context, id, repository, packageDir, idempotencyKey string, keepDatabaseDir bool) (string, error) {
+	// repository is yum/<repo>/packages
+	repoName := strings.TrimPrefix(filepath.Dir(repository), pluginName+"/")
+	key := p.storageLayout.RepositoryKey(repoName, "doltdb.tar.lz4")
 
 	dbPath, err := os.MkdirTemp(p.beskarYumConfig.DataDir, "db-")
 	if err != nil {
 		return "", fmt.Errorf("while creating temporary database directory: %w", err)
 	}
-	if !keepDatabaseDir {
-		defer os.RemoveAll(dbPath)
-	}
-
-	remoteReader, err := p.bucket.NewReader(ctx, key, &blob.ReaderOptions{})
-	if err == nil {
-		defer remoteReader.Close()
-
-		if err := yumdb.Clone(dbPath, remoteReader); err != nil {
-			return "", fmt.Errorf("while cloning DB: %w", err)
+	removeDatabaseDir := !keepDatabaseDir
+	defer func() {
+		if removeDatabaseDir {
+			_ = os.RemoveAll(dbPath)
 		}
-	}
+	}()
 
-	db, err := yumdb.Open(dbPath)
+	db, err := p.cloneDatabase(ctx, key, dbPath)
 	if err != nil {
-		return "", fmt.Errorf("while opening dolt database: %w", err)
+		return "", err
 	}
 	defer db.Close()
 
@@ -82,20 +127,78 @@ func (p *Plugin) addPackageToDatabase(ctx context.Context, id, repository, packa
 		filepath.Join(packageDir, primaryXMLFile),
 		filepath.Join(packageDir, filelistsXMLFile),
 		filepath.Join(packageDir, otherXMLFile),
+		idempotencyKey,
 	)
-	if err != nil {
+	if errors.Is(err, yumdb.ErrPackageExists) {
+		removeDatabaseDir = true
+		return "", nil
+	} else if err != nil {
 		return "", fmt.Errorf("while adding package: %w", err)
 	}
 
+	return dbPath, p.pushDatabase(ctx, key, dbPath)
+}
+
+// cloneDatabase clones the database stored at key into dbPath and opens it,
+// an empty database is opened if the database doesn't exist in the storage.
+func (p *Plugin) cloneDatabase(ctx context.Context, key, dbPath string) (*yumdb.YumDB, error) {
+	remoteReader, err := p.bucket.NewReader(ctx, key, &blob.ReaderOptions{})
+	if err == nil {
+		defer remoteReader.Close()
+
+		if err := yumdb.Clone(dbPath, remoteReader); err != nil {
+			return nil, fmt.Errorf("while cloning DB: %w", err)
+		}
+	}
+
+	db, err := yumdb.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("while opening dolt database: %w", err)
+	}
+
+	return db, nil
+}
+
+// pushDatabase pushes the database located at dbPath to the storage,
+// the stored database is left untouched if the push fails.
+func (p *Plugin) pushDatabase(ctx context.Context, key, dbPath string) error {
+	// cancelling the writer context before closing it aborts the write
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	remoteWriter, err := p.bucket.NewWriter(ctx, key, &blob.WriterOptions{})
 	if err != nil {
-		return "", fmt.Errorf("while initializing s3 object writer: %w", err)
+		return fmt.Errorf("while initializing s3 object writer: %w", err)
 	}
 
 	if err := yumdb.Push(dbPath, remoteWriter); err != nil {
+		cancel()
 		_ = remoteWriter.Close()
-		return "", fmt.Errorf("while pushing database to s3 bucket: %w", err)
+		return fmt.Errorf("while pushing database to s3 bucket: %w", err)
+	}
+
+	return remoteWriter.Close()
+}
+
+// openRepositoryDatabase clones the repository database into a temporary
+// directory which must be removed by the caller.
+func (p *Plugin) openRepositoryDatabase(ctx context.Context, repoName string) (string, *yumdb.YumDB, error) {
+	dbPath, err := os.MkdirTemp(p.beskarYumConfig.DataDir, "db-")
+	if err != nil {
+		return "", nil, fmt.Errorf("while creating temporary database directory: %w", err)
+	}
+
+	db, err := p.cloneDatabase(ctx, p.storageLayout.RepositoryKey(repoName, "doltdb.tar.lz4"), dbPath)
+	if err != nil {
+		_ = os.RemoveAll(dbPath)
+		return "", nil, err
 	}
 
-	return dbPath, remoteWriter.Close()
+	return dbPath, db, nil
+}
+
+// pushRepositoryDatabase pushes the repository database located at dbPath
+// to the storage.
+func (p *Plugin) pushRepositoryDatabase(ctx context.Context, repoName, dbPath string) error {
+	return p.pushDatabase(ctx, p.storageLayout.RepositoryKey(repoName, "doltdb.tar.lz4"), dbPath)
 }
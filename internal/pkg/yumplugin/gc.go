@@ -0,0 +1,501 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// GCEntry describes a package manifest which is not referenced by the
+// repository database.
+type GCEntry struct {
+	Tags     []string `json:"tags"`
+	Digest   string   `json:"digest"`
+	Name     string   `json:"name"`
+	Checksum string   `json:"checksum"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// GCReport is the result of a repository garbage collection.
+type GCReport struct {
+	Repository string `json:"repository"`
+	// Packages is the number of package manifests stored in the repository.
+	Packages int `json:"packages"`
+	// InFlight is the number of unreferenced packages skipped because
+	// they are still being processed.
+	InFlight int `json:"inflight"`
+	// Pending is the number of unreferenced packages skipped because
+	// they haven't been seen unreferenced during the grace period.
+	Pending      int        `json:"pending"`
+	Unreferenced []*GCEntry `json:"unreferenced"`
+	Deleted      int        `json:"deleted"`
+	DryRun       bool       `json:"dry-run"`
+}
+
+// gcCandidatesFile holds the time the unreferenced package manifests of
+// a repository were first seen by the garbage collection, it's shared by
+// the plugin instances.
+const gcCandidatesFile = "gc-candidates.json"
+
+// packageKey identifies a package of a registry repository.
+type packageKey struct {
+	repository string
+	id         string
+}
+
+// inflightPackages tracks the packages received but not yet added to
+// the repository database, they are skipped by the garbage collection.
+type inflightPackages struct {
+	mutex    sync.Mutex
+	packages map[packageKey]int
+}
+
+func newInflightPackages() *inflightPackages {
+	return &inflightPackages{
+		packages: make(map[packageKey]int),
+	}
+}
+
+func (ip *inflightPackages) add(repository, id string) {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	ip.packages[packageKey{repository: repository, id: id}]++
+}
+
+func (ip *inflightPackages) remove(repository, id string) {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	pk := packageKey{repository: repository, id: id}
+	if ip.packages[pk] <= 1 {
+		delete(ip.packages, pk)
+	} else {
+		ip.packages[pk]--
+	}
+}
+
+func (ip *inflightPackages) has(repository, id string) bool {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	_, ok := ip.packages[packageKey{repository: repository, id: id}]
+	return ok
+}
+
+// hasRepository returns true if packages of the repository are in-flight.
+func (ip *inflightPackages) hasRepository(repository string) bool {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	for pk := range ip.packages {
+		if pk.repository == repository {
+			return true
+		}
+	}
+	return false
+}
+
+// packageManifest is a package manifest stored in the repository
+// along with the tags referencing it.
+type packageManifest struct {
+	tags     []string
+	digest   string
+	name     string
+	id       string
+	inflight bool
+}
+
+func (p *Plugin) gcCandidatesKey(repository string) string {
+	return p.storageLayout.RepositoryKey(repository, gcCandidatesFile)
+}
+
+// loadGCCandidates returns the time the unreferenced package manifests of
+// the repository were first seen keyed by manifest digest.
+func (p *Plugin) loadGCCandidates(ctx context.Context, repository string) (map[string]time.Time, error) {
+	candidates := make(map[string]time.Time)
+
+	data, err := p.bucket.ReadAll(ctx, p.gcCandidatesKey(repository))
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return candidates, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading %s garbage collection candidates: %w", repository, err)
+	}
+
+	if err := json.Unmarshal(data, &candidates); err != nil {
+		return nil, fmt.Errorf("while decoding %s garbage collection candidates: %w", repository, err)
+	}
+
+	return candidates, nil
+}
+
+func (p *Plugin) saveGCCandidates(ctx context.Context, repository string, candidates map[string]time.Time) error {
+	data, err := json.Marshal(candidates)
+	if err != nil {
+		return err
+	}
+	return p.bucket.WriteAll(ctx, p.gcCandidatesKey(repository), data, &blob.WriterOptions{
+		ContentType: "application/json",
+	})
+}
+
+// CollectGarbage deletes the package manifests of the repository which
+// are not referenced by the repository database, in dry-run mode they are
+// only reported. Packages still being processed are skipped, as the other
+// plugin instances may be processing packages, manifests are only deleted
+// once they have been seen unreferenced for the grace period. The blobs of
+// the deleted manifests are reclaimed by the registry garbage collection.
+func (p *Plugin) CollectGarbage(ctx context.Context, repository string, dryRun bool) (*GCReport, error) {
+	report := &GCReport{
+		Repository:   repository,
+		Unreferenced: []*GCEntry{},
+		DryRun:       dryRun,
+	}
+
+	eventRepository := filepath.Join(pluginName, repository, "packages")
+
+	repo, err := name.NewRepository(filepath.Join(p.registry, eventRepository), p.nameOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := p.listPackageManifests(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	report.Packages = len(manifests)
+
+	// in-flight packages must be checked before reading the database: a
+	// package not in flight anymore has already been added to the database
+	for _, manifest := range manifests {
+		manifest.inflight = p.inflight.has(eventRepository, manifest.id)
+	}
+
+	referenced, err := p.referencedPackages(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	gracePeriod := p.beskarYumConfig.GC.GracePeriod
+
+	candidates, err := p.loadGCCandidates(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	// pending holds the unreferenced manifests not deleted, the
+	// manifests referenced or deleted since are forgotten
+	pending := make(map[string]time.Time)
+	now := time.Now()
+
+	for _, manifest := range manifests {
+		if _, ok := referenced[manifest.id]; ok {
+			continue
+		} else if manifest.inflight {
+			report.InFlight++
+			continue
+		}
+
+		firstSeen, ok := candidates[manifest.digest]
+		if !ok {
+			firstSeen = now
+		}
+		if now.Sub(firstSeen) < gracePeriod {
+			pending[manifest.digest] = firstSeen
+			report.Pending++
+			continue
+		}
+
+		entry := &GCEntry{
+			Tags:     manifest.tags,
+			Digest:   manifest.digest,
+			Name:     manifest.name,
+			Checksum: manifest.id,
+		}
+		report.Unreferenced = append(report.Unreferenced, entry)
+
+		if dryRun {
+			pending[manifest.digest] = firstSeen
+			continue
+		}
+
+		err := p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+			return remote.Delete(repo.Digest(manifest.digest), options...)
+		})
+
+		var terr *transport.Error
+
+		switch {
+		case errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound:
+			// deleted concurrently
+			continue
+		case errors.Is(err, errRegistryUnavailable):
+			return nil, err
+		case err != nil:
+			entry.Error = err.Error()
+			pending[manifest.digest] = firstSeen
+			continue
+		}
+
+		report.Deleted++
+		gcDeletedPackagesCounter.WithValues(repository).Inc()
+	}
+
+	if len(pending) > 0 || len(candidates) > 0 {
+		if err := p.saveGCCandidates(ctx, repository, pending); err != nil {
+			return nil, fmt.Errorf("while saving %s garbage collection candidates: %w", repository, err)
+		}
+	}
+
+	return report, nil
+}
+
+// listPackageManifests returns the package manifests of the repository
+// sorted by their first tag, manifests without package layer are ignored.
+func (p *Plugin) listPackageManifests(ctx context.Context, repo name.Repository) ([]*packageManifest, error) {
+	var tags []string
+
+	err := p.withRegistryRetry(ctx, func(options ...remote.Option) (err error) {
+		tags, err = remote.List(repo, options...)
+		return err
+	})
+
+	var terr *transport.Error
+
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while listing %s tags: %w", repo, err)
+	}
+
+	sort.Strings(tags)
+
+	manifests := make(map[string]*packageManifest)
+	sorted := make([]*packageManifest, 0, len(tags))
+
+	for _, tag := range tags {
+		var desc *remote.Descriptor
+
+		err := p.withRegistryRetry(ctx, func(options ...remote.Option) (err error) {
+			desc, err = remote.Get(repo.Tag(tag), options...)
+			return err
+		})
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			// untagged concurrently
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("while getting %s:%s manifest: %w", repo, tag, err)
+		}
+
+		digest := desc.Digest.String()
+		if manifest, ok := manifests[digest]; ok {
+			manifest.tags = append(manifest.tags, tag)
+			continue
+		}
+
+		ociManifest, err := v1.ParseManifest(bytes.NewReader(desc.Manifest))
+		if err != nil {
+			continue
+		}
+		packageLayer, err := getPackageLayer(ociManifest)
+		if err != nil {
+			continue
+		}
+
+		manifest := &packageManifest{
+			tags:   []string{tag},
+			digest: digest,
+			name:   packageLayer.Annotations["org.opencontainers.image.title"],
+			id:     packageLayer.Digest.Hex,
+		}
+		manifests[digest] = manifest
+		sorted = append(sorted, manifest)
+	}
+
+	return sorted, nil
+}
+
+// referencedPackages returns the identifiers of the packages stored in
+// the repository database.
+func (p *Plugin) referencedPackages(ctx context.Context, repository string) (map[string]struct{}, error) {
+	dbPath, db, err := p.openRepositoryDatabase(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	referenced := make(map[string]struct{})
+
+	err = db.WalkPackages(ctx, func(dbPackage *yumdb.Package) error {
+		referenced[dbPackage.ID] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while walking repository %s database: %w", repository, err)
+	}
+
+	return referenced, nil
+}
+
+// listRepositories returns the yum repositories found in the registry
+// catalog.
+func (p *Plugin) listRepositories(ctx context.Context) ([]string, error) {
+	registry, err := name.NewRegistry(p.registry, p.nameOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog []string
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) (err error) {
+		catalog, err = remote.Catalog(ctx, registry, options...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while listing registry repositories: %w", err)
+	}
+
+	return repositoriesFromCatalog(catalog), nil
+}
+
+// repositoriesFromCatalog returns the yum repositories holding packages
+// from the registry catalog.
+func repositoriesFromCatalog(catalog []string) []string {
+	repositories := make([]string, 0, len(catalog))
+
+	for _, repo := range catalog {
+		repository, ok := strings.CutPrefix(repo, pluginName+"/")
+		if !ok {
+			continue
+		} else if repository, ok = strings.CutSuffix(repository, "/packages"); ok {
+			repositories = append(repositories, repository)
+		}
+	}
+
+	return repositories
+}
+
+// CollectAllGarbage collects the garbage of all repositories, a failing
+// repository doesn't prevent the collection of the others.
+func (p *Plugin) CollectAllGarbage(ctx context.Context, dryRun bool) ([]*GCReport, error) {
+	repositories, err := p.listRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*GCReport, 0, len(repositories))
+
+	var errs []error
+
+	for _, repository := range repositories {
+		report, err := p.CollectGarbage(ctx, repository, dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("while collecting repository %s garbage: %w", repository, err))
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, errors.Join(errs...)
+}
+
+// scheduleGarbageCollection periodically collects the garbage of all
+// repositories when the scheduled collection is enabled.
+func (p *Plugin) scheduleGarbageCollection(ctx context.Context) {
+	gc := p.beskarYumConfig.GC
+	if gc.Interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(gc.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reports, err := p.CollectAllGarbage(ctx, gc.DryRun)
+		if err != nil {
+			logrus.Errorf("garbage collection: %s", err)
+		}
+		for _, report := range reports {
+			if len(report.Unreferenced) == 0 {
+				continue
+			}
+			logrus.Infof(
+				"garbage collection of repository %s: %d unreferenced packages, %d deleted",
+				report.Repository, len(report.Unreferenced), report.Deleted,
+			)
+		}
+	}
+}
+
+func gcHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !plugin.authorizeAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// packages are only deleted by explicit POST requests
+		var dryRun bool
+
+		switch r.Method {
+		case http.MethodGet:
+			dryRun = true
+		case http.MethodPost:
+			dryRun = r.URL.Query().Get("dry-run") == "true"
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var (
+			result interface{}
+			err    error
+		)
+
+		if repository, ok := mux.Vars(r)["repository"]; ok {
+			result, err = plugin.CollectGarbage(r.Context(), repository, dryRun)
+		} else {
+			result, err = plugin.CollectAllGarbage(r.Context(), dryRun)
+		}
+		if errors.Is(err, errRegistryUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	}
+}
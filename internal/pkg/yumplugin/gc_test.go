@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/storage"
+)
+
+func TestInflightPackages(t *testing.T) {
+	ip := newInflightPackages()
+
+	require.False(t, ip.has("yum/repo/packages", "id1"))
+
+	// the same package may be pushed twice before being processed
+	ip.add("yum/repo/packages", "id1")
+	ip.add("yum/repo/packages", "id1")
+	require.True(t, ip.has("yum/repo/packages", "id1"))
+	require.False(t, ip.has("yum/other/packages", "id1"))
+
+	ip.remove("yum/repo/packages", "id1")
+	require.True(t, ip.has("yum/repo/packages", "id1"))
+	ip.remove("yum/repo/packages", "id1")
+	require.False(t, ip.has("yum/repo/packages", "id1"))
+}
+
+func TestRepositoriesFromCatalog(t *testing.T) {
+	require.Equal(t, []string{"rocky", "rocky/9/baseos"}, repositoriesFromCatalog([]string{
+		"iso/rocky/images",
+		"yum/rocky/packages",
+		"yum/rocky/repodata",
+		"yum/rocky/9/baseos/packages",
+		"yum/packages",
+	}))
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+
+	dataDir := t.TempDir()
+	t.Setenv("HOME", dataDir)
+
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	beskarYumConfig := &config.BeskarYumConfig{
+		DataDir: dataDir,
+		Storage: config.BeskarYumStorage{
+			Driver: config.InMemoryStorageDriver,
+		},
+	}
+
+	plugin := &Plugin{
+		registry:        registryURL.Host,
+		dataDir:         dataDir,
+		nameOptions:     []name.Option{name.Insecure},
+		storageLayout:   storage.NewLayout("", pluginName),
+		beskarYumConfig: beskarYumConfig,
+		inflight:        newInflightPackages(),
+	}
+
+	plugin.bucket, err = storage.Init(ctx, beskarYumConfig)
+	require.NoError(t, err)
+	defer plugin.bucket.Close()
+
+	repo, err := name.NewRepository(registryURL.Host+"/yum/repo/packages", name.Insecure)
+	require.NoError(t, err)
+
+	pushPackage := func(tag, filename string) (string, string) {
+		layer := static.NewLayer([]byte(filename), orasrpm.RPMPackageLayerType)
+		img, err := mutate.Append(empty.Image, mutate.Addendum{
+			Layer: layer,
+			Annotations: map[string]string{
+				"org.opencontainers.image.title": filename,
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(repo.Tag(tag), img))
+
+		layerDigest, err := layer.Digest()
+		require.NoError(t, err)
+		manifestDigest, err := img.Digest()
+		require.NoError(t, err)
+
+		return layerDigest.Hex, manifestDigest.String()
+	}
+
+	// deleted manifests are checked by digest as the test registry
+	// doesn't remove the tags of deleted manifests
+	exists := func(digest string) bool {
+		_, err := remote.Head(repo.Digest(digest))
+		return err == nil
+	}
+
+	referencedID, referencedDigest := pushPackage("referenced", "referenced-1.0-1.x86_64.rpm")
+	unreferencedID, unreferencedDigest := pushPackage("unreferenced", "unreferenced-1.0-1.x86_64.rpm")
+	inflightID, inflightDigest := pushPackage("inflight", "inflight-1.0-1.x86_64.rpm")
+
+	dbPath, db, err := plugin.openRepositoryDatabase(ctx, "repo")
+	require.NoError(t, err)
+
+	metaFile := filepath.Join(dataDir, "meta.xml")
+	require.NoError(t, os.WriteFile(metaFile, []byte("<package/>"), 0o600))
+	require.NoError(t, db.AddPackage(ctx, referencedID, "referenced-1.0-1.x86_64.rpm", metaFile, metaFile, metaFile, ""))
+	require.NoError(t, db.Close())
+	require.NoError(t, plugin.pushRepositoryDatabase(ctx, "repo", dbPath))
+	require.NoError(t, os.RemoveAll(dbPath))
+
+	plugin.inflight.add("yum/repo/packages", inflightID)
+
+	// dry run only reports the unreferenced packages
+	report, err := plugin.CollectGarbage(ctx, "repo", true)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Packages)
+	require.Equal(t, 1, report.InFlight)
+	require.Equal(t, 0, report.Deleted)
+	require.Len(t, report.Unreferenced, 1)
+	require.Equal(t, []string{"unreferenced"}, report.Unreferenced[0].Tags)
+	require.Equal(t, unreferencedDigest, report.Unreferenced[0].Digest)
+	require.Equal(t, unreferencedID, report.Unreferenced[0].Checksum)
+	require.Equal(t, "unreferenced-1.0-1.x86_64.rpm", report.Unreferenced[0].Name)
+	require.True(t, exists(unreferencedDigest))
+
+	report, err = plugin.CollectGarbage(ctx, "repo", false)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Deleted)
+	require.Empty(t, report.Unreferenced[0].Error)
+	require.False(t, exists(unreferencedDigest))
+	require.True(t, exists(inflightDigest))
+	require.True(t, exists(referencedDigest))
+
+	// processed packages are not protected anymore
+	plugin.inflight.remove("yum/repo/packages", inflightID)
+
+	report, err = plugin.CollectGarbage(ctx, "repo", false)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Deleted)
+	require.False(t, exists(inflightDigest))
+	require.True(t, exists(referencedDigest))
+
+	// manifests are only deleted once seen unreferenced during the
+	// grace period, possibly by another plugin instance
+	beskarYumConfig.GC.GracePeriod = time.Hour
+	_, recentDigest := pushPackage("recent", "recent-1.0-1.x86_64.rpm")
+
+	// the deleted manifests are still listed by their tags
+	report, err = plugin.CollectGarbage(ctx, "repo", false)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Pending)
+	require.Equal(t, 0, report.Deleted)
+	require.True(t, exists(recentDigest))
+
+	candidates, err := plugin.loadGCCandidates(ctx, "repo")
+	require.NoError(t, err)
+	require.Contains(t, candidates, recentDigest)
+
+	for digest, firstSeen := range candidates {
+		candidates[digest] = firstSeen.Add(-time.Hour)
+	}
+	require.NoError(t, plugin.saveGCCandidates(ctx, "repo", candidates))
+
+	report, err = plugin.CollectGarbage(ctx, "repo", false)
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Pending)
+	require.Equal(t, 1, report.Deleted)
+	require.False(t, exists(recentDigest))
+
+	candidates, err = plugin.loadGCCandidates(ctx, "repo")
+	require.NoError(t, err)
+	require.Empty(t, candidates)
+
+	// unknown repositories have nothing to collect
+	report, err = plugin.CollectGarbage(ctx, "unknown", false)
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Packages)
+	require.Empty(t, report.Unreferenced)
+
+	reports, err := plugin.CollectAllGarbage(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Equal(t, "repo", reports[0].Repository)
+	require.Equal(t, 0, reports[0].InFlight)
+}
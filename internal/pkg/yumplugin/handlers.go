@@ -5,15 +5,20 @@ package yumplugin
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/gorilla/mux"
-	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/rpmcheck"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
 	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
 	"go.ciq.dev/beskar/pkg/oras"
 	"google.golang.org/protobuf/proto"
@@ -52,11 +57,67 @@ func (p *Plugin) eventHandler() func(http.ResponseWriter, *http.Request) {
 
 		ociManifest.Annotations["repository"] = event.Repository
 
+		// the manifest is already stored by the registry, the package is
+		// protected from the garbage collection before any wait and until
+		// it's added to the repository database
+		packageLayer, err := getPackageLayer(ociManifest)
+		isPackage := err == nil
+		enqueued := false
+
+		if isPackage {
+			p.inflight.add(event.Repository, packageLayer.Digest.Hex)
+			defer func() {
+				if !enqueued {
+					p.inflight.remove(event.Repository, packageLayer.Digest.Hex)
+				}
+			}()
+		}
+
+		// malformed packages are rejected before being indexed
+		if isPackage {
+			if err := p.validatePackage(r.Context(), event.Repository, packageLayer); rpmcheck.IsInvalid(err) {
+				invalidUploadsCounter.WithValues(event.Repository).Inc()
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// the slot is released once the package is processed
+		if err := p.uploads.acquire(r.Context(), event.Repository); err != nil {
+			rejectedUploadsCounter.WithValues(event.Repository).Inc()
+			retryAfter := p.beskarYumConfig.Uploads.RetryAfter
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		// the idempotency key only applies to package manifests
+		if key := r.Header.Get(idempotencyKeyHeader); key != "" && isPackage {
+			reserved, err := p.reserveIdempotencyKey(r.Context(), event.Repository, key, packageLayer.Digest.Hex)
+			if errors.Is(err, yumdb.ErrIdempotencyKeyConflict) {
+				p.uploads.release(event.Repository)
+				w.WriteHeader(http.StatusConflict)
+				return
+			} else if err != nil {
+				p.uploads.release(event.Repository)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			} else if !reserved {
+				p.uploads.release(event.Repository)
+				return
+			}
+			ociManifest.Annotations[idempotencyKeyAnnotation] = key
+		}
+
+		enqueued = true
 		p.enqueue(ociManifest)
 	}
 }
 
-func repomdHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+func repomdHandler(plugin *Plugin, mediatype string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 
@@ -67,16 +128,40 @@ func repomdHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		manifest, err := oras.GetManifest(ref, plugin.remoteOptions...)
-		if err != nil {
+		var manifest *v1.Manifest
+
+		err = plugin.withRegistryRetry(r.Context(), func(options ...remote.Option) (err error) {
+			manifest, err = oras.GetManifest(ref, options...)
+			return err
+		})
+		switch {
+		case err == nil:
+			plugin.lastGoodRepodata.Store(vars["repository"], manifest)
+		case errors.Is(err, errRegistryUnavailable):
+			// metadata blobs are content addressed, the last known
+			// metadata can be served while the registry is unavailable
+			lastGood, ok := plugin.lastGoodRepodata.Load(vars["repository"])
+			if !ok {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			manifest = lastGood.(*v1.Manifest)
+		default:
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
 		for _, layer := range manifest.Layers {
-			if layer.MediaType != orasrpm.RepomdXMLLayerType {
+			if string(layer.MediaType) != mediatype {
 				continue
 			}
+			// metadata layers are regenerated along with their digest
+			etag := fmt.Sprintf("%q", layer.Digest.String())
+			w.Header().Set("ETag", etag)
+			if etagMatch(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
 			uri := fmt.Sprintf(
 				"/v2/yum/%s/repodata/blobs/%s",
 				vars["repository"], layer.Digest.String(),
@@ -89,6 +174,21 @@ func repomdHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// etagMatch returns true if the If-None-Match header value matches
+// the entity tag, weak comparison is used as defined by RFC 7232.
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func blobsHandler(blobType string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -96,3 +196,14 @@ func blobsHandler(blobType string) func(http.ResponseWriter, *http.Request) {
 		http.Redirect(w, r, uri, http.StatusMovedPermanently)
 	}
 }
+
+func repomdKeyHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if plugin.signer == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pgp-keys")
+		_, _ = w.Write(plugin.signer.PublicKey())
+	}
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/storage"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
+)
+
+func TestETagMatch(t *testing.T) {
+	etag := `"sha256:abc"`
+
+	require.False(t, etagMatch("", etag))
+	require.True(t, etagMatch(`"sha256:abc"`, etag))
+	require.True(t, etagMatch(`W/"sha256:abc"`, etag))
+	require.True(t, etagMatch(`"sha256:def", "sha256:abc"`, etag))
+	require.True(t, etagMatch("*", etag))
+	require.False(t, etagMatch(`"sha256:def"`, etag))
+	require.False(t, etagMatch(`sha256:abc`, etag))
+}
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+
+	dataDir := t.TempDir()
+	t.Setenv("HOME", dataDir)
+
+	beskarYumConfig := &config.BeskarYumConfig{
+		DataDir: dataDir,
+		Storage: config.BeskarYumStorage{
+			Driver: config.InMemoryStorageDriver,
+		},
+	}
+
+	plugin := &Plugin{
+		dataDir:         dataDir,
+		pendingKeys:     make(map[pendingKey]string),
+		storageLayout:   storage.NewLayout("", pluginName),
+		beskarYumConfig: beskarYumConfig,
+	}
+
+	var err error
+
+	plugin.bucket, err = storage.Init(ctx, beskarYumConfig)
+	require.NoError(t, err)
+	defer plugin.bucket.Close()
+
+	dbPath, db, err := plugin.openRepositoryDatabase(ctx, "repo")
+	require.NoError(t, err)
+
+	metaFile := filepath.Join(dataDir, "meta.xml")
+	require.NoError(t, os.WriteFile(metaFile, []byte("<package/>"), 0o600))
+	require.NoError(t, db.AddPackage(ctx, "id1", "pkg1", metaFile, metaFile, metaFile, "persisted"))
+	require.NoError(t, db.Close())
+	require.NoError(t, plugin.pushRepositoryDatabase(ctx, "repo", dbPath))
+	require.NoError(t, os.RemoveAll(dbPath))
+
+	const repository = "yum/repo/packages"
+
+	// keys persisted in the repository database conflict synchronously
+	_, err = plugin.reserveIdempotencyKey(ctx, repository, "persisted", "id2")
+	require.ErrorIs(t, err, yumdb.ErrIdempotencyKeyConflict)
+
+	reserved, err := plugin.reserveIdempotencyKey(ctx, repository, "persisted", "id1")
+	require.NoError(t, err)
+	require.False(t, reserved)
+
+	// neither check keeps the key reserved
+	require.Empty(t, plugin.pendingKeys)
+
+	reserved, err = plugin.reserveIdempotencyKey(ctx, repository, "pending", "id2")
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	reserved, err = plugin.reserveIdempotencyKey(ctx, repository, "pending", "id2")
+	require.NoError(t, err)
+	require.False(t, reserved)
+
+	_, err = plugin.reserveIdempotencyKey(ctx, repository, "pending", "id3")
+	require.ErrorIs(t, err, yumdb.ErrIdempotencyKeyConflict)
+
+	// keys are scoped to their repository
+	reserved, err = plugin.reserveIdempotencyKey(ctx, "yum/other/packages", "persisted", "id2")
+	require.NoError(t, err)
+	require.True(t, reserved)
+}
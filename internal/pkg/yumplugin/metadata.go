@@ -4,6 +4,7 @@
 package yumplugin
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/xml"
 	"errors"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/klauspost/compress/gzip"
 	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
 	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yummeta"
@@ -58,6 +60,7 @@ func (w writer) Write(p []byte) (int, error) {
 type metaXML struct {
 	io.Writer
 	path         string
+	mediatype    string
 	openChecksum hash.Hash
 	openSize     int
 	checkSum     hash.Hash
@@ -65,27 +68,34 @@ type metaXML struct {
 	close        func() error
 }
 
-func newMetaXML(path, header string) (*metaXML, error) {
+// newMetaXML creates the XML file compressed with the codec in dir, the
+// file name is the uncompressed file name with the codec extension.
+func newMetaXML(dir, file, header string, codec metadataCodec) (*metaXML, error) {
 	meta := &metaXML{
-		path:         path,
+		path:         filepath.Join(dir, file+codec.ext),
+		mediatype:    codec.mediatypes[file],
 		openChecksum: sha256.New(),
 		checkSum:     sha256.New(),
 	}
 
-	f, err := os.Create(path)
+	f, err := os.Create(meta.path)
 	if err != nil {
-		return nil, fmt.Errorf("while creating %s: %w", path, err)
+		return nil, fmt.Errorf("while creating %s: %w", meta.path, err)
+	}
+	cw, err := codec.newWriter(io.MultiWriter(f, meta.checkSum, meta.getWriter()))
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("while creating %s: %w", meta.path, err)
 	}
-	gw := gzip.NewWriter(io.MultiWriter(f, meta.checkSum, meta.getWriter()))
 
 	meta.close = func() error {
-		if err := gw.Close(); err != nil {
+		if err := cw.Close(); err != nil {
 			_ = f.Close()
 			return err
 		}
 		return f.Close()
 	}
-	meta.Writer = io.MultiWriter(gw, meta.openChecksum, meta.getOpenWriter())
+	meta.Writer = io.MultiWriter(cw, meta.openChecksum, meta.getOpenWriter())
 
 	_, err = meta.Write([]byte(header + "\n"))
 	return meta, err
@@ -115,6 +125,15 @@ func (x *metaXML) Path() string {
 	return x.path
 }
 
+func (x *metaXML) Mediatype() string {
+	return x.mediatype
+}
+
+// href returns the location of the file in the repository.
+func (x *metaXML) href() string {
+	return filepath.Join("repodata", filepath.Base(x.path))
+}
+
 func (x *metaXML) Digest() (string, string) {
 	return "sha256", fmt.Sprintf("%x", x.checkSum.Sum(nil))
 }
@@ -141,9 +160,9 @@ type primaryXML struct {
 	*metaXML
 }
 
-func newPrimaryXML(path string, packageCount int) (*primaryXML, error) {
+func newPrimaryXML(dir string, packageCount int, codec metadataCodec) (*primaryXML, error) {
 	header := fmt.Sprintf(primaryHeaderFormat, packageCount)
-	metaXML, err := newMetaXML(path, header)
+	metaXML, err := newMetaXML(dir, primaryXMLFile, header, codec)
 	if err != nil {
 		return nil, err
 	}
@@ -152,10 +171,6 @@ func newPrimaryXML(path string, packageCount int) (*primaryXML, error) {
 	}, nil
 }
 
-func (x *primaryXML) Mediatype() string {
-	return orasrpm.PrimaryXMLLayerType
-}
-
 func (x *primaryXML) Annotations() map[string]string {
 	return nil
 }
@@ -164,9 +179,9 @@ type filelistsXML struct {
 	*metaXML
 }
 
-func newFilelistsXML(path string, packageCount int) (*filelistsXML, error) {
+func newFilelistsXML(dir string, packageCount int, codec metadataCodec) (*filelistsXML, error) {
 	header := fmt.Sprintf(filelistsHeaderFormat, packageCount)
-	metaXML, err := newMetaXML(path, header)
+	metaXML, err := newMetaXML(dir, filelistsXMLFile, header, codec)
 	if err != nil {
 		return nil, err
 	}
@@ -175,10 +190,6 @@ func newFilelistsXML(path string, packageCount int) (*filelistsXML, error) {
 	}, nil
 }
 
-func (x *filelistsXML) Mediatype() string {
-	return orasrpm.FilelistsXMLLayerType
-}
-
 func (x *filelistsXML) Annotations() map[string]string {
 	return nil
 }
@@ -187,9 +198,9 @@ type otherXML struct {
 	*metaXML
 }
 
-func newOtherXML(path string, packageCount int) (*otherXML, error) {
+func newOtherXML(dir string, packageCount int, codec metadataCodec) (*otherXML, error) {
 	header := fmt.Sprintf(otherHeaderFormat, packageCount)
-	metaXML, err := newMetaXML(path, header)
+	metaXML, err := newMetaXML(dir, otherXMLFile, header, codec)
 	if err != nil {
 		return nil, err
 	}
@@ -198,16 +209,13 @@ func newOtherXML(path string, packageCount int) (*otherXML, error) {
 	}, nil
 }
 
-func (x *otherXML) Mediatype() string {
-	return orasrpm.OtherXMLLayerType
-}
-
 func (x *otherXML) Annotations() map[string]string {
 	return nil
 }
 
 type repoMetadata struct {
 	repository    string
+	distro        []string
 	registry      string
 	repomdXMLPath string
 	primaryXML    *primaryXML
@@ -215,8 +223,11 @@ type repoMetadata struct {
 	otherXML      *otherXML
 }
 
-func newRepoMetadata(dir, registry, repository string, packageCount int) (*repoMetadata, error) {
-	var err error
+func newRepoMetadata(dir, registry, repository string, packageCount int, compression string) (*repoMetadata, error) {
+	codec, err := getMetadataCodec(compression)
+	if err != nil {
+		return nil, err
+	}
 
 	rm := &repoMetadata{
 		repository:    repository,
@@ -224,17 +235,17 @@ func newRepoMetadata(dir, registry, repository string, packageCount int) (*repoM
 		repomdXMLPath: filepath.Join(dir, repomdXMLFile),
 	}
 
-	rm.primaryXML, err = newPrimaryXML(filepath.Join(dir, primaryXMLGzipFile), packageCount)
+	rm.primaryXML, err = newPrimaryXML(dir, packageCount, codec)
 	if err != nil {
 		return nil, err
 	}
 
-	rm.filelistsXML, err = newFilelistsXML(filepath.Join(dir, filelistsXMLGzipFile), packageCount)
+	rm.filelistsXML, err = newFilelistsXML(dir, packageCount, codec)
 	if err != nil {
 		return nil, err
 	}
 
-	rm.otherXML, err = newOtherXML(filepath.Join(dir, otherXMLGzipFile), packageCount)
+	rm.otherXML, err = newOtherXML(dir, packageCount, codec)
 	if err != nil {
 		return nil, err
 	}
@@ -294,7 +305,7 @@ func (r *repoMetadata) getRepomd() (*yummeta.RepoMdRoot, error) {
 	return repomdRoot, xml.NewDecoder(repomd).Decode(repomdRoot)
 }
 
-func (r *repoMetadata) Save(plugin *Plugin) error {
+func (r *repoMetadata) Save(ctx context.Context, plugin *Plugin) error {
 	pushRef, err := name.ParseReference(
 		filepath.Join(r.registry, r.repository+":latest"),
 		plugin.nameOptions...,
@@ -317,6 +328,15 @@ func (r *repoMetadata) Save(plugin *Plugin) error {
 	repomdRoot.Xmlns = "http://linux.duke.edu/metadata/repo"
 	repomdRoot.XmlnsRpm = "http://linux.duke.edu/metadata/rpm"
 
+	if len(r.distro) > 0 {
+		repomdRoot.Tags = new(yummeta.RepoMdDistroRoot)
+		for _, distro := range r.distro {
+			repomdRoot.Tags.Distro = append(repomdRoot.Tags.Distro, &yummeta.RepoMdDistro{
+				Value: distro,
+			})
+		}
+	}
+
 	now := time.Now().UTC().Unix()
 
 	primaryChecksum := fmt.Sprintf("%x", r.primaryXML.checkSum.Sum(nil))
@@ -337,7 +357,7 @@ func (r *repoMetadata) Save(plugin *Plugin) error {
 			},
 			OpenSize: r.primaryXML.openSize,
 			Location: &yummeta.RepoMdDataLocation{
-				Href: filepath.Join("repodata", primaryXMLGzipFile),
+				Href: r.primaryXML.href(),
 			},
 			Timestamp: now,
 		},
@@ -354,7 +374,7 @@ func (r *repoMetadata) Save(plugin *Plugin) error {
 			},
 			OpenSize: r.filelistsXML.openSize,
 			Location: &yummeta.RepoMdDataLocation{
-				Href: filepath.Join("repodata", filelistsXMLGzipFile),
+				Href: r.filelistsXML.href(),
 			},
 			Timestamp: now,
 		},
@@ -371,7 +391,7 @@ func (r *repoMetadata) Save(plugin *Plugin) error {
 			},
 			OpenSize: r.otherXML.openSize,
 			Location: &yummeta.RepoMdDataLocation{
-				Href: filepath.Join("repodata", otherXMLGzipFile),
+				Href: r.otherXML.href(),
 			},
 			Timestamp: now,
 		},
@@ -412,11 +432,11 @@ func (r *repoMetadata) Save(plugin *Plugin) error {
 	for _, data := range repomdRoot.Data {
 		switch data.Type {
 		case "primary":
-			data.Location.Href = fmt.Sprintf("repodata/%s-%s", "sha256:"+primaryChecksum, primaryXMLGzipFile)
+			data.Location.Href = fmt.Sprintf("repodata/%s-%s", "sha256:"+primaryChecksum, filepath.Base(r.primaryXML.path))
 		case "filelists":
-			data.Location.Href = fmt.Sprintf("repodata/%s-%s", "sha256:"+filelistsChecksum, filelistsXMLGzipFile)
+			data.Location.Href = fmt.Sprintf("repodata/%s-%s", "sha256:"+filelistsChecksum, filepath.Base(r.filelistsXML.path))
 		case "other":
-			data.Location.Href = fmt.Sprintf("repodata/%s-%s", "sha256:"+otherChecksum, otherXMLGzipFile)
+			data.Location.Href = fmt.Sprintf("repodata/%s-%s", "sha256:"+otherChecksum, filepath.Base(r.otherXML.path))
 		case "primary_db":
 			for _, layer := range metadataLayers {
 				mt, _ := layer.MediaType()
@@ -464,9 +484,59 @@ func (r *repoMetadata) Save(plugin *Plugin) error {
 	}
 	metadataLayers = append(metadataLayers, orasrpm.NewRPMMetadataLayer(repomdLayer))
 
+	if plugin.signer != nil {
+		ascPath, err := signRepomd(plugin.signer, r.repomdXMLPath)
+		if err != nil {
+			return err
+		}
+		ascLayer, err := newGenericRPMMetadata(ascPath, orasrpm.RepomdASCLayerType, nil)
+		if err != nil {
+			return err
+		}
+		metadataLayers = append(metadataLayers, orasrpm.NewRPMMetadataLayer(ascLayer))
+	}
+
 	metadataPusher := orasrpm.NewRPMMetadataPusher(pushRef, metadataLayers...)
 
-	return oras.Push(metadataPusher, plugin.remoteOptions...)
+	return plugin.publishMetadata(ctx, metadataPusher)
+}
+
+// publishMetadata pushes the repository metadata by digest and moves the
+// latest tag to them only once they were entirely pushed, clients keep
+// being served the previous metadata if the push fails.
+func (p *Plugin) publishMetadata(ctx context.Context, pusher *orasrpm.RPMMetadataPusher) error {
+	latestTag, ok := pusher.Reference().(name.Tag)
+	if !ok {
+		return fmt.Errorf("metadata reference %s is not a tag", pusher.Reference())
+	}
+
+	image, err := pusher.Image()
+	if err != nil {
+		return fmt.Errorf("while getting metadata image: %w", err)
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return fmt.Errorf("while computing metadata image digest: %w", err)
+	}
+
+	stagingRef := latestTag.Context().Digest(digest.String())
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+		return remote.Write(stagingRef, image, options...)
+	})
+	if err != nil {
+		return fmt.Errorf("while pushing metadata to %s: %w", stagingRef, err)
+	}
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+		return remote.Tag(latestTag, image, options...)
+	})
+	if err != nil {
+		return fmt.Errorf("while tagging metadata %s: %w", latestTag, err)
+	}
+
+	return nil
 }
 
 func generateSQLiteFiles(dir string) error {
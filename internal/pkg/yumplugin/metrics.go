@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+)
+
+var (
+	repodataNamespace = metrics.NewNamespace("beskar_yum", "repodata")
+
+	rebuildFailuresCounter = repodataNamespace.NewLabeledCounter("rebuild_failures", "The number of failed repository metadata rebuilds", "repository")
+
+	uploadsNamespace = metrics.NewNamespace("beskar_yum", "uploads")
+
+	inflightUploadsGauge   = uploadsNamespace.NewLabeledGauge("inflight", "The number of packages queued or being processed", metrics.Total, "repository")
+	rejectedUploadsCounter = uploadsNamespace.NewLabeledCounter("rejected", "The number of uploads rejected by the repository upload limit", "repository")
+	invalidUploadsCounter  = uploadsNamespace.NewLabeledCounter("invalid", "The number of uploads rejected because the package is malformed", "repository")
+
+	gcNamespace = metrics.NewNamespace("beskar_yum", "gc")
+
+	gcDeletedPackagesCounter = gcNamespace.NewLabeledCounter("deleted_packages", "The number of unreferenced package manifests deleted by the garbage collection", "repository")
+
+	repositoryNamespace = metrics.NewNamespace("beskar_yum", "repository")
+
+	// the repository statistics are reported by collected gauges emitting
+	// only the series of the last collected repositories
+	repositoryStatsMetrics = newStatsCollector()
+)
+
+func init() {
+	repositoryNamespace.NewCollectedGauge(
+		"packages", "The number of packages referenced by the repository metadata", "",
+		repositoryStatsMetrics.observations(func(s *repositoryStats) float64 { return float64(s.packages) }), "repository",
+	)
+	repositoryNamespace.NewCollectedGauge(
+		"size", "The total size of the packages referenced by the repository metadata", metrics.Bytes,
+		repositoryStatsMetrics.observations(func(s *repositoryStats) float64 { return float64(s.bytes) }), "repository",
+	)
+	// the update time is a timestamp for alerts to compare with the current time
+	repositoryNamespace.NewCollectedGauge(
+		"last_updated", "The Unix time at which the repository metadata were last regenerated", metrics.Seconds,
+		repositoryStatsMetrics.observations(func(s *repositoryStats) float64 { return float64(s.updated.Unix()) }), "repository",
+	)
+
+	metrics.Register(repodataNamespace)
+	metrics.Register(uploadsNamespace)
+	metrics.Register(gcNamespace)
+	metrics.Register(repositoryNamespace)
+}
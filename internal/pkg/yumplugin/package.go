@@ -11,65 +11,150 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/rpmcheck"
 	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
 )
 
 func (p *Plugin) processPackages(ctx context.Context, manifests []*v1.Manifest) {
-	repos := make(map[string]string)
+	// upload slots and in-flight packages are released once
+	// the metadata are regenerated
+	defer func() {
+		for _, manifest := range manifests {
+			repository := manifest.Annotations["repository"]
+			p.uploads.release(repository)
+			if packageLayer, err := getPackageLayer(manifest); err == nil {
+				p.inflight.remove(repository, packageLayer.Digest.Hex)
+			}
+		}
+	}()
 
-	for idx, manifest := range manifests {
-		if repository, dbDir, err := p.processPackage(ctx, manifest, idx == len(manifests)-1); err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-		} else {
-			repos[repository] = dbDir
+	// packages are processed per repository with the repository
+	// database locked until its metadata are regenerated
+	var repositories []string
+	repoManifests := make(map[string][]*v1.Manifest)
+
+	for _, manifest := range manifests {
+		repository := manifest.Annotations["repository"]
+		if _, ok := repoManifests[repository]; !ok {
+			repositories = append(repositories, repository)
 		}
+		repoManifests[repository] = append(repoManifests[repository], manifest)
+	}
+
+	for _, repository := range repositories {
+		p.processRepositoryPackages(ctx, repository, repoManifests[repository])
 	}
+}
 
-	for repo, dbDir := range repos {
-		err := p.GenerateAndSaveMetadata(ctx, filepath.Dir(repo), dbDir, true)
+// processRepositoryPackages adds the packages to the repository database
+// and regenerates the repository metadata, repository is the package
+// registry repository: yum/<repository>/packages.
+func (p *Plugin) processRepositoryPackages(ctx context.Context, repository string, manifests []*v1.Manifest) {
+	unlock := p.repoLocks.lock(strings.TrimPrefix(filepath.Dir(repository), pluginName+"/"))
+	defer unlock()
+
+	latestDBDir := ""
+
+	for _, manifest := range manifests {
+		_, dbDir, err := p.processPackage(ctx, manifest)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
+			continue
+		} else if dbDir == "" {
+			// package already present, no need to regenerate metadata
+			continue
 		}
+		// only the latest database is required to generate metadata
+		if latestDBDir != "" {
+			_ = os.RemoveAll(latestDBDir)
+		}
+		latestDBDir = dbDir
+	}
+
+	if latestDBDir == "" {
+		return
 	}
-}
 
-func (p *Plugin) processPackage(ctx context.Context, manifest *v1.Manifest, keepDatabaseDir bool) (string, string, error) {
-	layerIndex := -1
+	err := p.GenerateAndSaveMetadata(ctx, filepath.Dir(repository), latestDBDir, true)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+}
 
-	for i, layer := range manifest.Layers {
-		if layer.MediaType != orasrpm.RPMPackageLayerType {
-			continue
+// getPackageLayer returns the RPM package layer, manifests pushed by naive
+// clients and routed by media type inference may use a generic layer media
+// type, the layer is then identified by its .rpm file name.
+func getPackageLayer(manifest *v1.Manifest) (v1.Descriptor, error) {
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == orasrpm.RPMPackageLayerType {
+			return layer, nil
+		}
+	}
+	for _, layer := range manifest.Layers {
+		if strings.HasSuffix(layer.Annotations["org.opencontainers.image.title"], ".rpm") {
+			return layer, nil
 		}
-		layerIndex = i
-		break
 	}
+	return v1.Descriptor{}, fmt.Errorf("no RPM package layer found in manifest")
+}
 
-	if layerIndex < 0 {
-		return "", "", fmt.Errorf("no RPM package layer found in manifest")
+// validatePackage reads the lead and the headers of the uploaded package
+// from the registry, malformed packages are reported with an error
+// satisfying rpmcheck.IsInvalid.
+func (p *Plugin) validatePackage(ctx context.Context, repository string, packageLayer v1.Descriptor) error {
+	digest, err := name.NewDigest(filepath.Join(p.registry, repository+"@"+packageLayer.Digest.String()), p.nameOptions...)
+	if err != nil {
+		return err
 	}
 
-	packageLayer := manifest.Layers[layerIndex]
+	return p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+		layer, err := remote.Layer(digest, options...)
+		if err != nil {
+			return err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		return rpmcheck.Validate(rc, p.beskarYumConfig.Uploads.StrictValidation)
+	})
+}
+
+func (p *Plugin) processPackage(ctx context.Context, manifest *v1.Manifest) (string, string, error) {
+	packageLayer, err := getPackageLayer(manifest)
+	if err != nil {
+		return "", "", err
+	}
 
 	packageFilename := packageLayer.Annotations["org.opencontainers.image.title"]
 
 	repository := manifest.Annotations["repository"]
+	idempotencyKey := manifest.Annotations[idempotencyKeyAnnotation]
+	defer p.releaseIdempotencyKey(repository, idempotencyKey)
+
 	ref := filepath.Join(p.registry, repository+"@sha256:"+packageLayer.Digest.Hex)
-	repoDir := filepath.Join(p.dataDir, repository)
 
-	tmpDir, err := os.MkdirTemp(p.dataDir, "package-")
+	tmpDir, err := os.MkdirTemp(p.stagingDir, stagingPattern)
 	if err != nil {
 		return "", "", fmt.Errorf("while creating temporary package directory: %w", err)
 	}
+	// the package and its extracted metadata are removed
+	// whether the package is processed or not
 	defer os.RemoveAll(tmpDir)
 
+	repoDir := filepath.Join(tmpDir, repository)
+
 	packageFile := filepath.Join(tmpDir, packageFilename)
 
-	if err := downloadPackage(ref, packageFile, p); err != nil {
+	if err := downloadPackage(ctx, ref, packageFile, p); err != nil {
 		return "", "", fmt.Errorf("while downloading package %s: %w", packageFilename, err)
 	}
 
@@ -79,11 +164,10 @@ func (p *Plugin) processPackage(ctx context.Context, manifest *v1.Manifest, keep
 		return "", "", fmt.Errorf("while extracting package %s metadata: %w", packageFilename, err)
 	}
 
-	dbDir, err := p.AddPackageToDatabase(ctx, manifest.Layers[0].Digest.Hex, repository, packageDir, true, keepDatabaseDir)
+	dbDir, err := p.AddPackageToDatabase(ctx, packageLayer.Digest.Hex, repository, packageDir, idempotencyKey, true, true)
 	if err != nil {
 		return "", "", fmt.Errorf("while adding package %s to database: %w", packageFilename, err)
 	}
-	defer os.RemoveAll(packageDir)
 
 	return repository, dbDir, err
 }
@@ -105,7 +189,9 @@ func (p *Plugin) GenerateAndSaveMetadata(ctx context.Context, repository, dbDir
 		cmd.Stdout = stdout
 		cmd.Stderr = stderr
 
+		// the previous metadata are left untouched on failure
 		if err := cmd.Run(); err != nil {
+			rebuildFailuresCounter.WithValues(repository).Inc(1)
 			return fmt.Errorf("while generating metadata: %s", stderr.String())
 		}
 
@@ -137,11 +223,17 @@ func (p *Plugin) GenerateAndSaveMetadata(ctx context.Context, repository, dbDir
 		return err
 	}
 
-	repomd, err := newRepoMetadata(outputDir, p.registry, filepath.Join(repository, "repodata"), packageCount)
+	repoName := strings.TrimPrefix(repository, pluginName+"/")
+
+	repomd, err := newRepoMetadata(outputDir, p.registry, filepath.Join(repository, "repodata"), packageCount, p.beskarYumConfig.MetadataCompression(repoName))
 	if err != nil {
 		return err
 	}
 
+	if repo, ok := p.beskarYumConfig.BootstrapRepository(repoName); ok {
+		repomd.distro = repo.Distro
+	}
+
 	err = db.WalkPackages(ctx, func(pkg *yumdb.Package) error {
 		if err := repomd.Add(bytes.NewReader(pkg.Primary), primaryXMLFile); err != nil {
 			return fmt.Errorf("while adding %s: %w", primaryXMLFile, err)
@@ -158,35 +250,38 @@ func (p *Plugin) GenerateAndSaveMetadata(ctx context.Context, repository, dbDir
 		return err
 	}
 
-	return repomd.Save(p)
+	return repomd.Save(ctx, p)
 }
 
-func downloadPackage(ref string, destinationPath string, plugin *Plugin) (errFn error) {
-	dst, err := os.Create(destinationPath)
+func downloadPackage(ctx context.Context, ref string, destinationPath string, plugin *Plugin) error {
+	digest, err := name.NewDigest(ref, plugin.nameOptions...)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		err = dst.Close()
-		if errFn == nil {
-			errFn = err
+
+	return plugin.withRegistryRetry(ctx, func(options ...remote.Option) (errFn error) {
+		dst, err := os.Create(destinationPath)
+		if err != nil {
+			return err
 		}
-	}()
+		defer func() {
+			err = dst.Close()
+			if errFn == nil {
+				errFn = err
+			}
+		}()
+
+		layer, err := remote.Layer(digest, options...)
+		if err != nil {
+			return err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
 
-	digest, err := name.NewDigest(ref, plugin.nameOptions...)
-	if err != nil {
+		_, err = io.Copy(dst, rc)
 		return err
-	}
-	layer, err := remote.Layer(digest, plugin.remoteOptions...)
-	if err != nil {
-		return err
-	}
-	rc, err := layer.Compressed()
-	if err != nil {
-		return err
-	}
-	defer rc.Close()
-
-	_, err = io.Copy(dst, rc)
-	return err
+	})
 }
@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessPackagesLock(t *testing.T) {
+	plugin := &Plugin{}
+
+	manifest := &v1.Manifest{
+		Annotations: map[string]string{
+			"repository": "yum/locked/packages",
+		},
+	}
+
+	// the repository database is being repaired
+	unlock := plugin.repoLocks.lock("locked")
+
+	done := make(chan struct{})
+	go func() {
+		plugin.processPackages(context.Background(), []*v1.Manifest{manifest})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("packages were processed without the repository database lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	<-done
+
+	require.Empty(t, plugin.repoLocks.locks)
+}
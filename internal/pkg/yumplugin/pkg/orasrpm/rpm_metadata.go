@@ -17,12 +17,21 @@ import (
 const (
 	RepomdConfigType         = "application/vnd.ciq.rpm.repomd.v1.config+json"
 	RepomdXMLLayerType       = "application/vnd.ciq.rpm.repomd.v1.xml"
+	RepomdASCLayerType       = "application/vnd.ciq.rpm.repomd.v1.xml.asc"
 	OtherXMLLayerType        = "application/vnd.ciq.rpm.other.v1.xml+gzip"
 	OtherSQLiteLayerType     = "application/vnd.ciq.rpm.other.sqlite.v1.gzip"
 	PrimaryXMLLayerType      = "application/vnd.ciq.rpm.primary.v1.xml+gzip"
 	PrimarySQLiteLayerType   = "application/vnd.ciq.rpm.primary.sqlite.v1.gzip"
 	FilelistsXMLLayerType    = "application/vnd.ciq.rpm.filelists.v1.xml+gzip"
 	FilelistsSQLiteLayerType = "application/vnd.ciq.rpm.filelists.sqlite.v1.gzip"
+
+	// XML metadata layers compressed with xz or zstd instead of gzip.
+	OtherXMLXZLayerType       = "application/vnd.ciq.rpm.other.v1.xml+xz"
+	OtherXMLZstdLayerType     = "application/vnd.ciq.rpm.other.v1.xml+zstd"
+	PrimaryXMLXZLayerType     = "application/vnd.ciq.rpm.primary.v1.xml+xz"
+	PrimaryXMLZstdLayerType   = "application/vnd.ciq.rpm.primary.v1.xml+zstd"
+	FilelistsXMLXZLayerType   = "application/vnd.ciq.rpm.filelists.v1.xml+xz"
+	FilelistsXMLZstdLayerType = "application/vnd.ciq.rpm.filelists.v1.xml+zstd"
 )
 
 type RPMMetadata interface {
@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+// Package rpmcheck validates the lead and the headers of RPM packages
+// to detect corrupted packages before they are indexed.
+package rpmcheck
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	leadSize        = 96
+	headerIntroSize = 16
+	indexEntrySize  = 16
+
+	// maxIndexEntries and maxHeaderDataSize bound the memory used to read
+	// a header, they are the limits enforced by rpm.
+	maxIndexEntries   = 0x0000ffff
+	maxHeaderDataSize = 0x0fffffff
+
+	leadTypeBinary = 0
+	leadTypeSource = 1
+)
+
+var (
+	leadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// header tags, see rpmtag.h
+const (
+	tagName          = 1000
+	tagVersion       = 1001
+	tagRelease       = 1002
+	tagOS            = 1021
+	tagArch          = 1022
+	tagPayloadFormat = 1124
+
+	sigTagSHA1   = 269
+	sigTagSHA256 = 273
+	sigTagMD5    = 1004
+)
+
+// tag types, see rpmtag.h
+const (
+	typeString      = 6
+	typeBin         = 7
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+// requiredTags are the header tags a package must have to be indexed.
+var requiredTags = []struct {
+	tag  int
+	name string
+}{
+	{tagName, "name"},
+	{tagVersion, "version"},
+	{tagRelease, "release"},
+	{tagArch, "arch"},
+}
+
+// strictTags are additionally required in strict mode.
+var strictTags = []struct {
+	tag  int
+	name string
+}{
+	{tagOS, "os"},
+	{tagPayloadFormat, "payloadformat"},
+}
+
+// Error reports a malformed package.
+type Error struct {
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "malformed RPM package: " + e.Reason
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return &Error{Reason: fmt.Sprintf(format, args...)}
+}
+
+// IsInvalid returns true if the error reports a malformed package.
+func IsInvalid(err error) bool {
+	var rpmErr *Error
+	return errors.As(err, &rpmErr)
+}
+
+// header holds the string tags and the tags present in a header.
+type header struct {
+	tags    map[int]struct{}
+	strings map[int]string
+}
+
+// Validate reads the lead, the signature header and the main header
+// from r and checks that they are well formed and that the main header
+// has the name, version, release and arch tags. In strict mode, the lead
+// must be a 3.0 lead, the signature header must have a digest and the
+// main header must have the os and payloadformat tags, odd packages
+// built by old or custom tools may miss them. Validate stops reading
+// at the start of the payload, malformed packages are reported with
+// an *Error.
+func Validate(r io.Reader, strict bool) error {
+	var lead [leadSize]byte
+
+	if _, err := io.ReadFull(r, lead[:]); err != nil {
+		return readError("lead", err)
+	}
+	if !bytes.Equal(lead[:4], leadMagic) {
+		return invalidf("bad lead magic %x", lead[:4])
+	}
+
+	major, minor := lead[4], lead[5]
+	if major < 3 || major > 4 {
+		return invalidf("unsupported lead version %d.%d", major, minor)
+	} else if strict && (major != 3 || minor != 0) {
+		return invalidf("lead version %d.%d is not 3.0", major, minor)
+	}
+
+	if leadType := binary.BigEndian.Uint16(lead[6:8]); leadType != leadTypeBinary && leadType != leadTypeSource {
+		return invalidf("unknown lead type %d", leadType)
+	}
+
+	sig, err := readHeader(r, "signature", true)
+	if err != nil {
+		return err
+	}
+	if strict {
+		if !sig.has(sigTagSHA256) && !sig.has(sigTagSHA1) && !sig.has(sigTagMD5) {
+			return invalidf("signature header has no digest")
+		}
+	}
+
+	hdr, err := readHeader(r, "main", false)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range requiredTags {
+		if hdr.strings[t.tag] == "" {
+			return invalidf("main header has no %s tag", t.name)
+		}
+	}
+	if strict {
+		for _, t := range strictTags {
+			if hdr.strings[t.tag] == "" {
+				return invalidf("main header has no %s tag", t.name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *header) has(tag int) bool {
+	_, ok := h.tags[tag]
+	return ok
+}
+
+func readError(section string, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return invalidf("%s is truncated", section)
+	}
+	return fmt.Errorf("while reading %s: %w", section, err)
+}
+
+// readHeader reads a header structure, the signature header is padded
+// to a multiple of 8 bytes.
+func readHeader(r io.Reader, name string, pad bool) (*header, error) {
+	section := name + " header"
+
+	var intro [headerIntroSize]byte
+
+	if _, err := io.ReadFull(r, intro[:]); err != nil {
+		return nil, readError(section, err)
+	}
+	if !bytes.Equal(intro[:4], headerMagic) {
+		return nil, invalidf("bad %s magic %x", section, intro[:4])
+	}
+
+	entries := binary.BigEndian.Uint32(intro[8:12])
+	size := binary.BigEndian.Uint32(intro[12:16])
+
+	if entries == 0 || entries > maxIndexEntries {
+		return nil, invalidf("%s index count %d is out of range", section, entries)
+	} else if size > maxHeaderDataSize {
+		return nil, invalidf("%s data size %d is out of range", section, size)
+	}
+
+	index := make([]byte, int(entries)*indexEntrySize)
+	if _, err := io.ReadFull(r, index); err != nil {
+		return nil, readError(section, err)
+	}
+
+	// the data are not allocated upfront, a truncated package
+	// may announce a large header
+	buf := new(bytes.Buffer)
+	if _, err := io.CopyN(buf, r, int64(size)); err != nil {
+		return nil, readError(section, err)
+	}
+	data := buf.Bytes()
+
+	if pad && size%8 != 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(8-size%8)); err != nil {
+			return nil, readError(section, err)
+		}
+	}
+
+	h := &header{
+		tags:    make(map[int]struct{}, entries),
+		strings: make(map[int]string),
+	}
+
+	for i := 0; i < int(entries); i++ {
+		entry := index[i*indexEntrySize : (i+1)*indexEntrySize]
+
+		tag := int(binary.BigEndian.Uint32(entry[0:4]))
+		tagType := binary.BigEndian.Uint32(entry[4:8])
+		offset := binary.BigEndian.Uint32(entry[8:12])
+		count := binary.BigEndian.Uint32(entry[12:16])
+
+		if offset >= size {
+			return nil, invalidf("%s tag %d offset %d is out of range", section, tag, offset)
+		} else if count == 0 {
+			return nil, invalidf("%s tag %d has no value", section, tag)
+		}
+
+		switch tagType {
+		case typeString, typeStringArray, typeI18NString:
+			end := bytes.IndexByte(data[offset:], 0)
+			if end < 0 {
+				return nil, invalidf("%s tag %d string is not terminated", section, tag)
+			}
+			h.strings[tag] = string(data[offset : offset+uint32(end)])
+		case typeBin:
+			if uint64(offset)+uint64(count) > uint64(size) {
+				return nil, invalidf("%s tag %d value is out of range", section, tag)
+			}
+		}
+
+		h.tags[tag] = struct{}{}
+	}
+
+	return h, nil
+}
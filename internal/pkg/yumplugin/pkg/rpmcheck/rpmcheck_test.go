@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package rpmcheck
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testTag struct {
+	tag   int
+	value string
+}
+
+func buildHeader(tags []testTag, pad bool) []byte {
+	index := new(bytes.Buffer)
+	data := new(bytes.Buffer)
+
+	for _, t := range tags {
+		_ = binary.Write(index, binary.BigEndian, []uint32{uint32(t.tag), typeString, uint32(data.Len()), 1})
+		data.WriteString(t.value)
+		data.WriteByte(0)
+	}
+
+	header := new(bytes.Buffer)
+	header.Write(headerMagic)
+	header.Write(make([]byte, 4))
+	_ = binary.Write(header, binary.BigEndian, []uint32{uint32(len(tags)), uint32(data.Len())})
+	header.Write(index.Bytes())
+	header.Write(data.Bytes())
+
+	if pad && data.Len()%8 != 0 {
+		header.Write(make([]byte, 8-data.Len()%8))
+	}
+
+	return header.Bytes()
+}
+
+func buildPackage(major, minor byte, sigTags, tags []testTag) []byte {
+	lead := make([]byte, leadSize)
+	copy(lead, leadMagic)
+	lead[4], lead[5] = major, minor
+
+	pkg := new(bytes.Buffer)
+	pkg.Write(lead)
+	pkg.Write(buildHeader(sigTags, true))
+	pkg.Write(buildHeader(tags, false))
+	pkg.WriteString("payload")
+
+	return pkg.Bytes()
+}
+
+func TestValidate(t *testing.T) {
+	sigTags := []testTag{{sigTagSHA256, "d2a84f4b8b650937ec8f73cd8be2c74add5a911ba64df27458ed8229da804a26"}}
+	tags := []testTag{
+		{tagName, "bash"},
+		{tagVersion, "5.1.8"},
+		{tagRelease, "6.el9"},
+		{tagOS, "linux"},
+		{tagArch, "x86_64"},
+		{tagPayloadFormat, "cpio"},
+	}
+
+	valid := buildPackage(3, 0, sigTags, tags)
+
+	tests := []struct {
+		name          string
+		pkg           []byte
+		wantErr       string
+		wantStrictErr string
+	}{
+		{
+			name: "valid",
+			pkg:  valid,
+		},
+		{
+			name:          "not a package",
+			pkg:           append([]byte("<html>"), valid[6:]...),
+			wantErr:       "bad lead magic 3c68746d",
+			wantStrictErr: "bad lead magic 3c68746d",
+		},
+		{
+			name:          "truncated",
+			pkg:           valid[:leadSize+20],
+			wantErr:       "signature header is truncated",
+			wantStrictErr: "signature header is truncated",
+		},
+		{
+			name:          "corrupted main header",
+			pkg:           append(append([]byte{}, valid[:leadSize+len(buildHeader(sigTags, true))]...), make([]byte, 64)...),
+			wantErr:       "bad main header magic 00000000",
+			wantStrictErr: "bad main header magic 00000000",
+		},
+		{
+			name:          "missing arch",
+			pkg:           buildPackage(3, 0, sigTags, tags[:4]),
+			wantErr:       "main header has no arch tag",
+			wantStrictErr: "main header has no arch tag",
+		},
+		{
+			name:          "empty name",
+			pkg:           buildPackage(3, 0, sigTags, append([]testTag{{tagName, ""}}, tags[1:]...)),
+			wantErr:       "main header has no name tag",
+			wantStrictErr: "main header has no name tag",
+		},
+		{
+			name:          "old lead",
+			pkg:           buildPackage(4, 0, sigTags, tags),
+			wantStrictErr: "lead version 4.0 is not 3.0",
+		},
+		{
+			name:          "unsigned",
+			pkg:           buildPackage(3, 0, []testTag{{1000, "1234"}}, tags),
+			wantStrictErr: "signature header has no digest",
+		},
+		{
+			name:          "missing payload format",
+			pkg:           buildPackage(3, 0, sigTags, tags[:5]),
+			wantStrictErr: "main header has no payloadformat tag",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for strict, wantErr := range map[bool]string{false: tt.wantErr, true: tt.wantStrictErr} {
+				err := Validate(bytes.NewReader(tt.pkg), strict)
+				if wantErr == "" {
+					require.NoError(t, err, "strict=%t", strict)
+					continue
+				}
+				require.ErrorContains(t, err, wantErr, "strict=%t", strict)
+				require.True(t, IsInvalid(err))
+			}
+		})
+	}
+}
+
+func TestValidateStopsAtPayload(t *testing.T) {
+	pkg := buildPackage(3, 0, []testTag{{sigTagMD5, "md5"}}, []testTag{
+		{tagName, "bash"},
+		{tagVersion, "5.1.8"},
+		{tagRelease, "6.el9"},
+		{tagArch, "noarch"},
+	})
+
+	r := bytes.NewReader(pkg)
+	require.NoError(t, Validate(r, false))
+	require.Equal(t, len("payload"), r.Len())
+}
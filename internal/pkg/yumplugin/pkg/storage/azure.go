@@ -1,6 +1,8 @@
 // SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
 // SPDX-License-Identifier: Apache-2.0
 
+//go:build !exclude_azure
+
 package storage
 
 import (
@@ -15,7 +17,13 @@ import (
 	"gocloud.dev/blob/azureblob"
 )
 
-func initAzure(ctx context.Context, storageConfig config.BeskarYumAzureStorage, prefix string) (*blob.Bucket, error) {
+func init() {
+	registerBucketOpener(config.AzureStorageDriver, func(ctx context.Context, storage config.BeskarYumStorage) (*blob.Bucket, error) {
+		return initAzure(ctx, storage.Azure)
+	})
+}
+
+func initAzure(ctx context.Context, storageConfig config.BeskarYumAzureStorage) (*blob.Bucket, error) {
 	sharedKeyCred, err := azblob.NewSharedKeyCredential(storageConfig.AccountName, storageConfig.AccountKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed azblob.NewSharedKeyCredential: %w", err)
@@ -44,9 +52,5 @@ func initAzure(ctx context.Context, storageConfig config.BeskarYumAzureStorage,
 		return nil, err
 	}
 
-	if prefix != "" {
-		bucket = blob.PrefixedBucket(bucket, prefix)
-	}
-
 	return bucket, nil
 }
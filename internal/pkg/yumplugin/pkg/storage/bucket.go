@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"gocloud.dev/blob"
+)
+
+// Bucket is a blob bucket transparently encrypting objects on write and
+// decrypting them on read when the storage encryption is enabled, storage
+// operations failing with a transient error are retried.
+type Bucket struct {
+	*blob.Bucket
+	keyring *keyring
+	driver  string
+	retry   config.BeskarYumStorageRetry
+	// spillDir is the directory of the spill files, the default
+	// temporary directory is used if empty.
+	spillDir string
+	// beforeWrite is the driver hook applied to object writes.
+	beforeWrite beforeWriteFunc
+}
+
+// NewReader returns a reader for the object, objects encrypted in chunks
+// are decrypted while being read, other encrypted objects are read
+// entirely and decrypted before being returned.
+func (b *Bucket) NewReader(ctx context.Context, key string, opts *blob.ReaderOptions) (io.ReadCloser, error) {
+	if b.keyring == nil {
+		var reader io.ReadCloser
+
+		err := b.withRetry(ctx, "read", func() (err error) {
+			reader, err = b.Bucket.NewReader(ctx, key, opts)
+			return err
+		})
+
+		return reader, err
+	}
+
+	var reader io.ReadCloser
+
+	err := b.withRetry(ctx, "read", func() (err error) {
+		reader, err = b.Bucket.NewReader(ctx, key, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(reader)
+
+	if magic, err := br.Peek(len(streamMagic)); err == nil && bytes.Equal(magic, streamMagic) {
+		_, _ = br.Discard(len(streamMagic))
+
+		r, err := b.keyring.openStream(key, br)
+		if err != nil {
+			_ = reader.Close()
+			return nil, err
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, reader}, nil
+	}
+
+	data, err := io.ReadAll(br)
+	_ = reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = b.keyring.open(key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// ReadAll reads and decrypts the object.
+func (b *Bucket) ReadAll(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+
+	err := b.withRetry(ctx, "read", func() (err error) {
+		data, err = b.Bucket.ReadAll(ctx, key)
+		return err
+	})
+	if err != nil || b.keyring == nil {
+		return data, err
+	}
+
+	return b.keyring.open(key, data)
+}
+
+// NewWriter returns a writer for the object, when encryption or retries
+// are enabled the object is buffered and written when the writer is closed.
+// Objects larger than spillThreshold are buffered in a temporary file of
+// the staging directory, the upload is then streamed from the file and replayed from it on retry.
+func (b *Bucket) NewWriter(ctx context.Context, key string, opts *blob.WriterOptions) (io.WriteCloser, error) {
+	if b.keyring == nil && b.retry.MaxRetries <= 0 {
+		return b.Bucket.NewWriter(ctx, key, b.writerOptions(opts))
+	}
+
+	return &spillWriter{
+		ctx:    ctx,
+		bucket: b,
+		key:    key,
+		opts:   opts,
+	}, nil
+}
+
+// WriteAll encrypts and writes the object, the encryption metadata is
+// added to the object metadata.
+func (b *Bucket) WriteAll(ctx context.Context, key string, p []byte, opts *blob.WriterOptions) error {
+	if b.keyring != nil {
+		var (
+			metadata map[string]string
+			err      error
+		)
+
+		p, metadata, err = b.keyring.seal(key, p)
+		if err != nil {
+			return err
+		} else if len(metadata) > 0 {
+			opts = withMetadata(opts, metadata)
+		}
+	}
+
+	return b.withRetry(ctx, "write", func() error {
+		return b.Bucket.WriteAll(ctx, key, p, b.writerOptions(opts))
+	})
+}
+
+// Exists returns true if the object exists.
+func (b *Bucket) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+
+	err := b.withRetry(ctx, "exists", func() (err error) {
+		exists, err = b.Bucket.Exists(ctx, key)
+		return err
+	})
+
+	return exists, err
+}
+
+// Delete deletes the object.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	return b.withRetry(ctx, "delete", func() error {
+		return b.Bucket.Delete(ctx, key)
+	})
+}
+
+// writerOptions returns a copy of the writer options with the driver hook
+// chained after the BeforeWrite function of opts if any.
+func (b *Bucket) writerOptions(opts *blob.WriterOptions) *blob.WriterOptions {
+	if b.beforeWrite == nil {
+		return opts
+	}
+
+	merged := new(blob.WriterOptions)
+	if opts != nil {
+		*merged = *opts
+	}
+
+	beforeWrite := merged.BeforeWrite
+	merged.BeforeWrite = func(asFunc func(interface{}) bool) error {
+		if beforeWrite != nil {
+			if err := beforeWrite(asFunc); err != nil {
+				return err
+			}
+		}
+		return b.beforeWrite(asFunc)
+	}
+
+	return merged
+}
+
+// withMetadata returns a copy of the writer options with the metadata added.
+func withMetadata(opts *blob.WriterOptions, metadata map[string]string) *blob.WriterOptions {
+	merged := new(blob.WriterOptions)
+	if opts != nil {
+		*merged = *opts
+	}
+
+	merged.Metadata = make(map[string]string, len(merged.Metadata)+len(metadata))
+	if opts != nil {
+		for k, v := range opts.Metadata {
+			merged.Metadata[k] = v
+		}
+	}
+	for k, v := range metadata {
+		merged.Metadata[k] = v
+	}
+
+	return merged
+}
@@ -0,0 +1,270 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// encryptionMagic prefixes encrypted objects, it's followed by the key ID
+// length, the key ID, the nonce and the sealed object.
+var encryptionMagic = []byte("BSKENC1\x00")
+
+// envelopeMagic prefixes objects encrypted with a data key, it's followed
+// by the master key ID length, the master key ID, the algorithm, the wrapped
+// data key length, the wrapped data key, the nonce and the sealed object.
+var envelopeMagic = []byte("BSKENC2\x00")
+
+const (
+	// envelopeAlgorithmAES256GCM encrypts objects with a 256 bits AES-GCM
+	// data key.
+	envelopeAlgorithmAES256GCM byte = 1
+
+	dataKeySize = 32
+)
+
+// Object metadata describing how the object data key is wrapped, the
+// envelope header remains the reference to decrypt objects.
+const (
+	metadataEncryptionAlgorithm  = "beskar-encryption-algorithm"
+	metadataEncryptionKeyID      = "beskar-encryption-key-id"
+	metadataEncryptionWrappedKey = "beskar-encryption-wrapped-key"
+)
+
+var envelopeAlgorithms = map[byte]string{
+	envelopeAlgorithmAES256GCM: "AES-256-GCM",
+}
+
+type keyring struct {
+	activeID string
+	envelope bool
+	aeads    map[string]cipher.AEAD
+}
+
+func loadKeyring(encryption config.BeskarYumEncryption) (*keyring, error) {
+	kr := &keyring{
+		activeID: encryption.KeyID,
+		envelope: encryption.Mode == config.EncryptionModeEnvelope,
+		aeads:    make(map[string]cipher.AEAD, len(encryption.Keys)),
+	}
+
+	for _, key := range encryption.Keys {
+		var encoded string
+
+		if key.KeyFile != "" {
+			data, err := os.ReadFile(key.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("while reading encryption key %s: %w", key.ID, err)
+			}
+			encoded = string(data)
+		} else {
+			encoded = os.Getenv(key.KeyEnv)
+			if encoded == "" {
+				return nil, fmt.Errorf("encryption key environment variable %s is not set", key.KeyEnv)
+			}
+		}
+
+		material, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("while decoding encryption key %s: %w", key.ID, err)
+		} else if kr.envelope && key.ID == kr.activeID && len(material) != dataKeySize {
+			return nil, fmt.Errorf("envelope encryption master key %s must be a %d bits AES key", key.ID, dataKeySize*8)
+		}
+
+		aead, err := newAEAD(material)
+		if err != nil {
+			return nil, fmt.Errorf("while loading encryption key %s: %w", key.ID, err)
+		}
+		kr.aeads[key.ID] = aead
+	}
+
+	if _, ok := kr.aeads[kr.activeID]; !ok {
+		return nil, fmt.Errorf("encryption key %s not found", kr.activeID)
+	}
+
+	// ensure the active key is usable before writing any object with it
+	probe := []byte("beskar")
+	sealed, _, err := kr.seal("probe", probe)
+	if err == nil {
+		sealed, err = kr.open("probe", sealed)
+	}
+	if err != nil || !bytes.Equal(sealed, probe) {
+		return nil, fmt.Errorf("encryption key %s failed the startup check: %v", kr.activeID, err)
+	}
+
+	return kr, nil
+}
+
+// newAEAD returns an AES-GCM cipher for an AES-128, AES-192 or AES-256 key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with the active key, the object key is used
+// as additional data so an encrypted object can't be moved to another key.
+// The returned metadata must be attached to the object.
+func (kr *keyring) seal(objectKey string, plaintext []byte) ([]byte, map[string]string, error) {
+	if kr.envelope {
+		return kr.sealEnvelope(objectKey, plaintext)
+	}
+
+	aead := kr.aeads[kr.activeID]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, 0, len(encryptionMagic)+1+len(kr.activeID)+len(nonce))
+	header = append(header, encryptionMagic...)
+	header = append(header, byte(len(kr.activeID)))
+	header = append(header, kr.activeID...)
+	header = append(header, nonce...)
+
+	return aead.Seal(header, nonce, plaintext, []byte(objectKey)), nil, nil
+}
+
+// sealEnvelope encrypts the plaintext with a random data key which is
+// wrapped by the active key and stored along with the object.
+func (kr *keyring) sealEnvelope(objectKey string, plaintext []byte) ([]byte, map[string]string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	wrappedKey, err := sealWithNonce(kr.aeads[kr.activeID], nil, dataKey, []byte(objectKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, 0, len(envelopeMagic)+3+len(kr.activeID)+len(wrappedKey))
+	header = append(header, envelopeMagic...)
+	header = append(header, byte(len(kr.activeID)))
+	header = append(header, kr.activeID...)
+	header = append(header, envelopeAlgorithmAES256GCM, byte(len(wrappedKey)))
+	header = append(header, wrappedKey...)
+
+	sealed, err := sealWithNonce(aead, header, plaintext, []byte(objectKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sealed, map[string]string{
+		metadataEncryptionAlgorithm:  envelopeAlgorithms[envelopeAlgorithmAES256GCM],
+		metadataEncryptionKeyID:      kr.activeID,
+		metadataEncryptionWrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+	}, nil
+}
+
+// sealWithNonce appends a random nonce followed by the sealed plaintext to dst.
+func sealWithNonce(aead cipher.AEAD, dst, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(append(dst, nonce...), nonce, plaintext, additionalData), nil
+}
+
+// openWithNonce opens data produced by sealWithNonce.
+func openWithNonce(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("missing nonce")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+}
+
+// open decrypts an object with the key it was encrypted with, objects
+// written before encryption was enabled are returned as is.
+func (kr *keyring) open(objectKey string, data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, streamMagic) {
+		r, err := kr.openStream(objectKey, bytes.NewReader(data[len(streamMagic):]))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	} else if bytes.HasPrefix(data, envelopeMagic) {
+		return kr.openEnvelope(objectKey, data[len(envelopeMagic):])
+	} else if !bytes.HasPrefix(data, encryptionMagic) {
+		return data, nil
+	}
+	data = data[len(encryptionMagic):]
+
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, fmt.Errorf("object %s has a malformed encryption header", objectKey)
+	}
+	keyID := string(data[1 : 1+int(data[0])])
+	data = data[1+int(data[0]):]
+
+	aead, ok := kr.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("object %s is encrypted with an unknown key %s", objectKey, keyID)
+	} else if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("object %s has a malformed encryption header", objectKey)
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(objectKey))
+	if err != nil {
+		return nil, fmt.Errorf("while decrypting object %s: %w", objectKey, err)
+	}
+
+	return plaintext, nil
+}
+
+// openEnvelope unwraps the data key of an object with the master key
+// it was wrapped with and decrypts the object.
+func (kr *keyring) openEnvelope(objectKey string, data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0])+2 {
+		return nil, fmt.Errorf("object %s has a malformed encryption header", objectKey)
+	}
+	keyID := string(data[1 : 1+int(data[0])])
+	data = data[1+int(data[0]):]
+
+	algorithm, wrappedKeySize := data[0], int(data[1])
+	data = data[2:]
+
+	aead, ok := kr.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("object %s is encrypted with an unknown key %s", objectKey, keyID)
+	} else if _, ok := envelopeAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("object %s is encrypted with an unknown algorithm %d", objectKey, algorithm)
+	} else if len(data) < wrappedKeySize {
+		return nil, fmt.Errorf("object %s has a malformed encryption header", objectKey)
+	}
+
+	dataKey, err := openWithNonce(aead, data[:wrappedKeySize], []byte(objectKey))
+	if err != nil {
+		return nil, fmt.Errorf("while unwrapping data key of object %s: %w", objectKey, err)
+	}
+
+	aead, err = newAEAD(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("while unwrapping data key of object %s: %w", objectKey, err)
+	}
+
+	plaintext, err := openWithNonce(aead, data[wrappedKeySize:], []byte(objectKey))
+	if err != nil {
+		return nil, fmt.Errorf("while decrypting object %s: %w", objectKey, err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,296 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// streamMagic prefixes objects encrypted in chunks, it's followed by the
+// key ID length, the key ID, the algorithm, the wrapped data key length
+// and the wrapped data key for envelope encryption, the nonce prefix and
+// the sealed chunks. Objects encrypted with the key directly have the
+// streamAlgorithmDirect algorithm and no wrapped data key.
+var streamMagic = []byte("BSKENC3\x00")
+
+const (
+	// streamAlgorithmDirect encrypts the chunks with the key itself.
+	streamAlgorithmDirect byte = 0
+
+	// streamChunkSize is the plaintext size of the sealed chunks.
+	streamChunkSize = 64 << 10
+
+	// the chunk nonce is the random prefix followed by the chunk
+	// counter and the last chunk flag, it requires a 12 bytes nonce.
+	streamNoncePrefixSize = 7
+	streamNonceSize       = streamNoncePrefixSize + 4 + 1
+)
+
+// sealStream returns a reader of the plaintext read from r encrypted in
+// chunks with the active key, the object key is used as additional data.
+// Chunk nonces carry the chunk index and a last chunk flag so reordered or
+// truncated objects fail to decrypt. The returned metadata must be
+// attached to the object.
+func (kr *keyring) sealStream(objectKey string, r io.Reader) (io.Reader, map[string]string, error) {
+	aead := kr.aeads[kr.activeID]
+
+	header := make([]byte, 0, len(streamMagic)+1+len(kr.activeID)+1+streamNoncePrefixSize)
+	header = append(header, streamMagic...)
+	header = append(header, byte(len(kr.activeID)))
+	header = append(header, kr.activeID...)
+
+	var metadata map[string]string
+
+	if kr.envelope {
+		dataKey := make([]byte, dataKeySize)
+		if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+			return nil, nil, err
+		}
+
+		wrappedKey, err := sealWithNonce(aead, nil, dataKey, []byte(objectKey))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		aead, err = newAEAD(dataKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		header = append(header, envelopeAlgorithmAES256GCM, byte(len(wrappedKey)))
+		header = append(header, wrappedKey...)
+
+		metadata = map[string]string{
+			metadataEncryptionAlgorithm:  envelopeAlgorithms[envelopeAlgorithmAES256GCM],
+			metadataEncryptionKeyID:      kr.activeID,
+			metadataEncryptionWrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		}
+	} else {
+		header = append(header, streamAlgorithmDirect)
+	}
+
+	if aead.NonceSize() != streamNonceSize {
+		return nil, nil, fmt.Errorf("encryption key %s nonce size is not supported", kr.activeID)
+	}
+
+	prefix := make([]byte, streamNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, nil, err
+	}
+	header = append(header, prefix...)
+
+	return &sealingReader{
+		chunkStream: chunkStream{
+			objectKey: objectKey,
+			src:       bufio.NewReader(r),
+			aead:      aead,
+			prefix:    prefix,
+			chunk:     make([]byte, streamChunkSize),
+			out:       make([]byte, 0, streamChunkSize+aead.Overhead()),
+			buf:       header,
+		},
+	}, metadata, nil
+}
+
+// openStream returns a reader of the object decrypted from r, the
+// stream magic must have been read from r.
+func (kr *keyring) openStream(objectKey string, r io.Reader) (io.Reader, error) {
+	src := bufio.NewReader(r)
+
+	malformed := fmt.Errorf("object %s has a malformed encryption header", objectKey)
+
+	keyIDSize, err := src.ReadByte()
+	if err != nil {
+		return nil, malformed
+	}
+	keyID := make([]byte, int(keyIDSize))
+	if _, err := io.ReadFull(src, keyID); err != nil {
+		return nil, malformed
+	}
+
+	aead, ok := kr.aeads[string(keyID)]
+	if !ok {
+		return nil, fmt.Errorf("object %s is encrypted with an unknown key %s", objectKey, keyID)
+	}
+
+	algorithm, err := src.ReadByte()
+	if err != nil {
+		return nil, malformed
+	}
+
+	if algorithm != streamAlgorithmDirect {
+		if _, ok := envelopeAlgorithms[algorithm]; !ok {
+			return nil, fmt.Errorf("object %s is encrypted with an unknown algorithm %d", objectKey, algorithm)
+		}
+
+		wrappedKeySize, err := src.ReadByte()
+		if err != nil {
+			return nil, malformed
+		}
+		wrappedKey := make([]byte, int(wrappedKeySize))
+		if _, err := io.ReadFull(src, wrappedKey); err != nil {
+			return nil, malformed
+		}
+
+		dataKey, err := openWithNonce(aead, wrappedKey, []byte(objectKey))
+		if err != nil {
+			return nil, fmt.Errorf("while unwrapping data key of object %s: %w", objectKey, err)
+		}
+
+		aead, err = newAEAD(dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("while unwrapping data key of object %s: %w", objectKey, err)
+		}
+	}
+
+	if aead.NonceSize() != streamNonceSize {
+		return nil, fmt.Errorf("object %s is encrypted with an unsupported nonce size", objectKey)
+	}
+
+	prefix := make([]byte, streamNoncePrefixSize)
+	if _, err := io.ReadFull(src, prefix); err != nil {
+		return nil, malformed
+	}
+
+	return &openingReader{
+		chunkStream: chunkStream{
+			objectKey: objectKey,
+			src:       src,
+			aead:      aead,
+			prefix:    prefix,
+			chunk:     make([]byte, streamChunkSize+aead.Overhead()),
+			out:       make([]byte, 0, streamChunkSize),
+		},
+	}, nil
+}
+
+// chunkStream holds the state shared by the sealing and opening readers,
+// buf holds the output not read yet.
+type chunkStream struct {
+	objectKey string
+	src       *bufio.Reader
+	aead      cipher.AEAD
+	prefix    []byte
+	counter   uint32
+	chunk     []byte
+	out       []byte
+	buf       []byte
+	done      bool
+}
+
+// next reads the next chunk from the source, the chunk is the last one
+// if the source is exhausted. The chunk is empty if the source is empty.
+func (cs *chunkStream) next() (int, bool, error) {
+	n, err := io.ReadFull(cs.src, cs.chunk)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return n, true, nil
+	case err != nil:
+		return 0, false, err
+	}
+
+	if _, err := cs.src.Peek(1); errors.Is(err, io.EOF) {
+		return n, true, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return n, false, nil
+}
+
+// nonce returns the nonce of the current chunk.
+func (cs *chunkStream) nonce(last bool) ([]byte, error) {
+	if cs.counter == math.MaxUint32 {
+		return nil, fmt.Errorf("object %s exceeds the encrypted object size limit", cs.objectKey)
+	}
+
+	nonce := make([]byte, streamNonceSize)
+	copy(nonce, cs.prefix)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], cs.counter)
+	if last {
+		nonce[streamNonceSize-1] = 1
+	}
+	cs.counter++
+
+	return nonce, nil
+}
+
+func (cs *chunkStream) read(p []byte, fill func() error) (int, error) {
+	for len(cs.buf) == 0 {
+		if cs.done {
+			return 0, io.EOF
+		} else if err := fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cs.buf)
+	cs.buf = cs.buf[n:]
+
+	return n, nil
+}
+
+type sealingReader struct {
+	chunkStream
+}
+
+func (sr *sealingReader) Read(p []byte) (int, error) {
+	return sr.read(p, sr.seal)
+}
+
+func (sr *sealingReader) seal() error {
+	n, last, err := sr.next()
+	if err != nil {
+		return err
+	}
+
+	nonce, err := sr.nonce(last)
+	if err != nil {
+		return err
+	}
+
+	sr.buf = sr.aead.Seal(sr.out[:0], nonce, sr.chunk[:n], []byte(sr.objectKey))
+	sr.done = last
+
+	return nil
+}
+
+type openingReader struct {
+	chunkStream
+}
+
+func (or *openingReader) Read(p []byte) (int, error) {
+	return or.read(p, or.open)
+}
+
+func (or *openingReader) open() error {
+	n, last, err := or.next()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		// the last chunk is always written, even empty
+		return fmt.Errorf("object %s is truncated", or.objectKey)
+	}
+
+	nonce, err := or.nonce(last)
+	if err != nil {
+		return err
+	}
+
+	or.buf, err = or.aead.Open(or.out[:0], nonce, or.chunk[:n], []byte(or.objectKey))
+	if err != nil {
+		return fmt.Errorf("while decrypting object %s: %w", or.objectKey, err)
+	}
+	or.done = last
+
+	return nil
+}
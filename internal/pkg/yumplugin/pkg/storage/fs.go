@@ -1,6 +1,8 @@
 // SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
 // SPDX-License-Identifier: Apache-2.0
 
+//go:build !exclude_filesystem
+
 package storage
 
 import (
@@ -12,7 +14,13 @@ import (
 	"gocloud.dev/blob/fileblob"
 )
 
-func initFS(_ context.Context, pluginConfig config.BeskarYumFSStorage, prefix string) (*blob.Bucket, error) {
+func init() {
+	registerBucketOpener(config.FSStorageDriver, func(ctx context.Context, storage config.BeskarYumStorage) (*blob.Bucket, error) {
+		return initFS(ctx, storage.Filesystem)
+	})
+}
+
+func initFS(_ context.Context, pluginConfig config.BeskarYumFSStorage) (*blob.Bucket, error) {
 	if err := os.MkdirAll(pluginConfig.Directory, 0o700); err != nil {
 		return nil, err
 	}
@@ -22,9 +30,5 @@ func initFS(_ context.Context, pluginConfig config.BeskarYumFSStorage, prefix st
 		return nil, err
 	}
 
-	if prefix != "" {
-		bucket = blob.PrefixedBucket(bucket, prefix)
-	}
-
 	return bucket, nil
 }
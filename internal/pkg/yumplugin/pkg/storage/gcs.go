@@ -1,6 +1,8 @@
 // SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
 // SPDX-License-Identifier: Apache-2.0
 
+//go:build !exclude_gcs
+
 package storage
 
 import (
@@ -15,7 +17,13 @@ import (
 	storagev1 "google.golang.org/api/storage/v1"
 )
 
-func initGCS(ctx context.Context, storageConfig config.BeskarYumGCSStorage, prefix string) (*blob.Bucket, error) {
+func init() {
+	registerBucketOpener(config.GCSStorageDriver, func(ctx context.Context, storage config.BeskarYumStorage) (*blob.Bucket, error) {
+		return initGCS(ctx, storage.GCS)
+	})
+}
+
+func initGCS(ctx context.Context, storageConfig config.BeskarYumGCSStorage) (*blob.Bucket, error) {
 	data, err := os.ReadFile(storageConfig.Keyfile)
 	if err != nil {
 		return nil, err
@@ -38,9 +46,5 @@ func initGCS(ctx context.Context, storageConfig config.BeskarYumGCSStorage, pref
 		return nil, err
 	}
 
-	if prefix != "" {
-		bucket = blob.PrefixedBucket(bucket, prefix)
-	}
-
 	return bucket, nil
 }
@@ -0,0 +1,17 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+)
+
+// initInMemory returns a bucket keeping objects in memory, objects are
+// lost when the bucket is closed, it's intended to be used by tests.
+func initInMemory(_ context.Context) (*blob.Bucket, error) {
+	return memblob.OpenBucket(nil), nil
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"path"
+	"strings"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// uploadsDir is the directory of the resumable uploads.
+const uploadsDir = "_uploads"
+
+// Layout computes object keys from the storage prefix template.
+// When the template doesn't reference the repository, objects are
+// stored under <prefix>/<plugin>/<repository> to preserve the historical
+// layout, otherwise they are stored under the rendered prefix.
+type Layout struct {
+	template string
+	plugin   string
+}
+
+// NewLayout returns a storage layout for the plugin, the template
+// must have been validated with config.ValidateStoragePrefix.
+func NewLayout(template, plugin string) *Layout {
+	return &Layout{
+		template: strings.Trim(template, "/"),
+		plugin:   plugin,
+	}
+}
+
+// RepositoryKey returns the object key of a repository file.
+func (l *Layout) RepositoryKey(repository, file string) string {
+	prefix := strings.NewReplacer(
+		config.StoragePrefixPluginVar, l.plugin,
+		config.StoragePrefixRepoVar, repository,
+	).Replace(l.template)
+
+	if !strings.Contains(l.template, config.StoragePrefixRepoVar) {
+		prefix = path.Join(prefix, l.plugin, repository)
+	}
+
+	return strings.TrimPrefix(path.Join(prefix, file), "/")
+}
+
+// UploadsKey returns the object key of a resumable upload file, uploads
+// are stored under <static prefix>/<plugin>/_uploads apart from the
+// repositories so they can be listed, repository names can't start
+// with an underscore.
+func (l *Layout) UploadsKey(file string) string {
+	return strings.TrimPrefix(path.Join(staticPrefix(l.template), l.plugin, uploadsDir, file), "/")
+}
+
+// staticPrefix returns the part of the storage prefix template
+// preceding the first variable.
+func staticPrefix(template string) string {
+	template = strings.TrimPrefix(template, "/")
+	if i := strings.Index(template, "{"); i >= 0 {
+		template = template[:i]
+	}
+	return template
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayoutRepositoryKey(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "", want: "yum/rocky/tokens.json"},
+		{template: "/", want: "yum/rocky/tokens.json"},
+		{template: "/beskar/", want: "beskar/yum/rocky/tokens.json"},
+		{template: "{plugin}", want: "yum/yum/rocky/tokens.json"},
+		{template: "artifacts/{plugin}/{repo}", want: "artifacts/yum/rocky/tokens.json"},
+		{template: "{repo}-{plugin}", want: "rocky-yum/tokens.json"},
+	}
+
+	for _, tt := range tests {
+		key := NewLayout(tt.template, "yum").RepositoryKey("rocky", "tokens.json")
+		require.Equal(t, tt.want, key, "template %q", tt.template)
+	}
+}
+
+func TestLayoutUploadsKey(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "", want: "yum/_uploads/id/state.json"},
+		{template: "/beskar/", want: "beskar/yum/_uploads/id/state.json"},
+		{template: "artifacts/{plugin}/{repo}", want: "artifacts/yum/_uploads/id/state.json"},
+		{template: "{repo}-{plugin}", want: "yum/_uploads/id/state.json"},
+	}
+
+	for _, tt := range tests {
+		key := NewLayout(tt.template, "yum").UploadsKey("id/state.json")
+		require.Equal(t, tt.want, key, "template %q", tt.template)
+	}
+}
+
+func TestStaticPrefix(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"/beskar/":                  "beskar/",
+		"{plugin}":                  "",
+		"artifacts/{plugin}/{repo}": "artifacts/",
+	}
+
+	for template, want := range tests {
+		require.Equal(t, want, staticPrefix(template), "template %q", template)
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+	"go.ciq.dev/beskar/pkg/retry"
+	"gocloud.dev/gcerrors"
+)
+
+var (
+	storageNamespace = metrics.NewNamespace("beskar_yum", "storage")
+
+	retriesCounter = storageNamespace.NewLabeledCounter("retries", "The number of retried storage operations", "driver", "operation")
+)
+
+func init() {
+	metrics.Register(storageNamespace)
+}
+
+// isRetryable returns true for errors returned by object stores under
+// load or during transient failures.
+func isRetryable(ctx context.Context, err error) bool {
+	switch gcerrors.Code(err) {
+	case gcerrors.Internal, gcerrors.ResourceExhausted:
+		return true
+	case gcerrors.DeadlineExceeded:
+		return ctx.Err() == nil
+	case gcerrors.Unknown:
+		var nerr net.Error
+		return errors.As(err, &nerr)
+	}
+	return false
+}
+
+// withRetry calls the storage operation and retries it with an exponential
+// backoff with jitter when it fails with a retryable error.
+func (b *Bucket) withRetry(ctx context.Context, operation string, op func() error) error {
+	if b.retry.MaxRetries <= 0 {
+		return op()
+	}
+
+	newBackoff := func() backoff.BackOff {
+		eb := backoff.NewExponentialBackOff()
+		eb.InitialInterval = b.retry.BaseDelay
+		eb.MaxElapsedTime = 0
+		return backoff.WithMaxRetries(eb, uint64(b.retry.MaxRetries))
+	}
+
+	attempt := 0
+
+	return retry.Retry(ctx, newBackoff, func() error {
+		if attempt > 0 {
+			retriesCounter.WithValues(b.driver, operation).Inc(1)
+		}
+		attempt++
+
+		err := op()
+		if err == nil {
+			return nil
+		} else if !isRetryable(ctx, err) {
+			return backoff.Permanent(err)
+		}
+
+		logrus.Debugf("retrying storage %s operation after error: %s", operation, err)
+
+		return err
+	})
+}
@@ -1,10 +1,16 @@
 // SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
 // SPDX-License-Identifier: Apache-2.0
 
+//go:build !exclude_s3
+
 package storage
 
 import (
 	"context"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
 	"go.ciq.dev/beskar/internal/pkg/config"
 	"go.ciq.dev/beskar/internal/pkg/s3"
@@ -12,7 +18,38 @@ import (
 	"gocloud.dev/blob/s3blob"
 )
 
-func initS3(ctx context.Context, storageConfig config.BeskarYumS3Storage, prefix string) (*blob.Bucket, error) {
+func init() {
+	registerBucketOpener(config.S3StorageDriver, func(ctx context.Context, storage config.BeskarYumStorage) (*blob.Bucket, error) {
+		return initS3(ctx, storage.S3)
+	})
+	registerBeforeWriteHook(config.S3StorageDriver, func(storage config.BeskarYumStorage) beforeWriteFunc {
+		return s3ObjectTagging(storage.S3.ObjectTags)
+	})
+}
+
+// s3ObjectTagging returns a write hook setting the object tags on uploads,
+// nil is returned when there is no tag.
+func s3ObjectTagging(tags map[string]string) beforeWriteFunc {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	values := make(url.Values, len(tags))
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	tagging := values.Encode()
+
+	return func(asFunc func(interface{}) bool) error {
+		var input *s3manager.UploadInput
+		if asFunc(&input) {
+			input.Tagging = aws.String(tagging)
+		}
+		return nil
+	}
+}
+
+func initS3(ctx context.Context, storageConfig config.BeskarYumS3Storage) (*blob.Bucket, error) {
 	bucketName := storageConfig.Bucket
 
 	authMethod, err := s3.NewAuthMethod(
@@ -34,9 +71,5 @@ func initS3(ctx context.Context, storageConfig config.BeskarYumS3Storage, prefix
 		return nil, err
 	}
 
-	if prefix != "" {
-		bucket = blob.PrefixedBucket(bucket, prefix)
-	}
-
 	return bucket, nil
 }
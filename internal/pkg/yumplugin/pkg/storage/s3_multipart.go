@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !exclude_s3
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+var abortedUploadsCounter = storageNamespace.NewCounter(
+	"aborted_multipart_uploads", "The number of aborted incomplete multipart uploads",
+)
+
+func init() {
+	registerSweeper(config.S3StorageDriver, sweepMultipartUploads)
+}
+
+// sweepMultipartUploads periodically aborts the incomplete multipart
+// uploads under the storage prefix when the cleanup is enabled.
+func sweepMultipartUploads(ctx context.Context, bucket *Bucket, storage config.BeskarYumStorage) {
+	cleanup := storage.S3.MultipartCleanup
+	if !cleanup.Enabled {
+		return
+	}
+
+	var client *s3.S3
+	if !bucket.As(&client) {
+		logrus.Errorf("multipart uploads cleanup disabled: no S3 client found")
+		return
+	}
+
+	prefix := staticPrefix(storage.Prefix)
+
+	ticker := time.NewTicker(cleanup.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := abortMultipartUploads(ctx, client, storage.S3.Bucket, prefix, cleanup.MaxAge); err != nil {
+			logrus.Errorf("while aborting incomplete multipart uploads: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// abortMultipartUploads aborts the multipart uploads initiated more than
+// maxAge ago, uploads which can't be aborted are retried at the next run.
+func abortMultipartUploads(ctx context.Context, client *s3.S3, bucket, prefix string, maxAge time.Duration) error {
+	threshold := time.Now().Add(-maxAge)
+
+	var stale []*s3.MultipartUpload
+
+	err := client.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, upload := range output.Uploads {
+			if upload.Initiated != nil && upload.Initiated.Before(threshold) {
+				stale = append(stale, upload)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range stale {
+		_, err := client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err != nil {
+			logrus.Errorf("while aborting multipart upload %s of %s: %s", aws.StringValue(upload.UploadId), aws.StringValue(upload.Key), err)
+			continue
+		}
+
+		abortedUploadsCounter.Inc(1)
+
+		logrus.Infof(
+			"Aborted multipart upload %s of %s initiated at %s",
+			aws.StringValue(upload.UploadId), aws.StringValue(upload.Key), upload.Initiated.Format(time.RFC3339),
+		)
+	}
+
+	return nil
+}
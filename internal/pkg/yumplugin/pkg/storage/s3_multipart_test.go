@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !exclude_s3
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbortMultipartUploads(t *testing.T) {
+	now := time.Now().UTC()
+
+	mutex := sync.Mutex{}
+	aborted := []string{}
+	listPrefix := ""
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		switch {
+		case r.Method == http.MethodGet && query.Has("uploads"):
+			mutex.Lock()
+			listPrefix = query.Get("prefix")
+			mutex.Unlock()
+
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, `<ListMultipartUploadsResult>
+<Bucket>bucket</Bucket>
+<IsTruncated>false</IsTruncated>
+<Upload><Key>yum/stale</Key><UploadId>stale-id</UploadId><Initiated>%s</Initiated></Upload>
+<Upload><Key>yum/failing</Key><UploadId>failing-id</UploadId><Initiated>%s</Initiated></Upload>
+<Upload><Key>yum/recent</Key><UploadId>recent-id</UploadId><Initiated>%s</Initiated></Upload>
+</ListMultipartUploadsResult>`,
+				now.Add(-2*time.Hour).Format(time.RFC3339),
+				now.Add(-3*time.Hour).Format(time.RFC3339),
+				now.Add(-time.Minute).Format(time.RFC3339),
+			)
+		case r.Method == http.MethodDelete && query.Has("uploadId"):
+			if query.Get("uploadId") == "failing-id" {
+				// uploads which can't be aborted don't prevent others to be aborted
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			mutex.Lock()
+			aborted = append(aborted, r.URL.Path+"?uploadId="+query.Get("uploadId"))
+			mutex.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	})
+	require.NoError(t, err)
+
+	err = abortMultipartUploads(context.Background(), s3.New(sess), "bucket", "yum/", time.Hour)
+	require.NoError(t, err)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	require.Equal(t, "yum/", listPrefix)
+	// only uploads initiated before the max age are aborted
+	require.Equal(t, []string{"/bucket/yum/stale?uploadId=stale-id"}, aborted)
+}
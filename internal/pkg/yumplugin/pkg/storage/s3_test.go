@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !exclude_s3
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3ObjectTagging(t *testing.T) {
+	require.Nil(t, s3ObjectTagging(nil))
+
+	input := &s3manager.UploadInput{}
+	asFunc := func(i interface{}) bool {
+		p, ok := i.(**s3manager.UploadInput)
+		if ok {
+			*p = input
+		}
+		return ok
+	}
+
+	tagging := s3ObjectTagging(map[string]string{"expiry": "30d", "team": "beskar ops"})
+	require.NoError(t, tagging(asFunc))
+	require.Equal(t, "expiry=30d&team=beskar+ops", aws.StringValue(input.Tagging))
+
+	// other drivers types are ignored
+	require.NoError(t, tagging(func(interface{}) bool { return false }))
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"gocloud.dev/blob"
+)
+
+// spillThreshold is the size above which buffered objects
+// are spilled to a temporary file.
+var spillThreshold = 4 << 20
+
+// SpillPattern is the name pattern of the spill files created
+// in the bucket spill directory.
+const SpillPattern = "upload-"
+
+// spillWriter buffers an object in memory up to spillThreshold bytes and
+// in a temporary file beyond, the object is written when the writer is
+// closed so the upload can be replayed on retry.
+type spillWriter struct {
+	ctx    context.Context
+	bucket *Bucket
+	key    string
+	opts   *blob.WriterOptions
+
+	buf  bytes.Buffer
+	file *os.File
+}
+
+func (sw *spillWriter) Write(p []byte) (int, error) {
+	if sw.file == nil && sw.buf.Len()+len(p) > spillThreshold {
+		if err := sw.spill(); err != nil {
+			return 0, err
+		}
+	}
+	if sw.file != nil {
+		return sw.file.Write(p)
+	}
+	return sw.buf.Write(p)
+}
+
+// spill moves the buffered data to a temporary file created in
+// the bucket spill directory.
+func (sw *spillWriter) spill() error {
+	f, err := os.CreateTemp(sw.bucket.spillDir, SpillPattern+"*")
+	if err != nil {
+		return fmt.Errorf("while creating upload spill file: %w", err)
+	}
+
+	if _, err := sw.buf.WriteTo(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("while writing upload spill file: %w", err)
+	}
+
+	sw.buf = bytes.Buffer{}
+	sw.file = f
+
+	return nil
+}
+
+// reader returns a reader of the buffered data from the start.
+func (sw *spillWriter) reader() (io.Reader, error) {
+	if sw.file == nil {
+		return bytes.NewReader(sw.buf.Bytes()), nil
+	} else if _, err := sw.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return sw.file, nil
+}
+
+// Close streams the object to the storage, encrypted objects are sealed
+// in chunks while being streamed.
+func (sw *spillWriter) Close() error {
+	if sw.file != nil {
+		defer func() {
+			_ = sw.file.Close()
+			_ = os.Remove(sw.file.Name())
+		}()
+	}
+
+	return sw.bucket.withRetry(sw.ctx, "write", func() error {
+		r, err := sw.reader()
+		if err != nil {
+			return err
+		}
+
+		opts := sw.opts
+
+		if sw.bucket.keyring != nil {
+			var metadata map[string]string
+
+			// each attempt is sealed with a new nonce prefix
+			r, metadata, err = sw.bucket.keyring.sealStream(sw.key, r)
+			if err != nil {
+				return err
+			} else if len(metadata) > 0 {
+				opts = withMetadata(opts, metadata)
+			}
+		}
+
+		return sw.bucket.upload(sw.ctx, sw.key, r, opts)
+	})
+}
+
+// upload streams the reader content to the object, the
+// object isn't written if the copy fails.
+func (b *Bucket) upload(ctx context.Context, key string, r io.Reader, opts *blob.WriterOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := b.Bucket.NewWriter(ctx, key, b.writerOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		// cancelling the context before closing aborts the write
+		cancel()
+		_ = w.Close()
+		return err
+	}
+
+	return w.Close()
+}
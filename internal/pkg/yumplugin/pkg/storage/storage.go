@@ -11,29 +11,72 @@ import (
 	"gocloud.dev/blob"
 )
 
-func Init(ctx context.Context, pluginConfig *config.BeskarYumConfig) (*blob.Bucket, error) {
-	prefix := pluginConfig.Storage.Prefix
-
-	if prefix != "" && prefix != "/" {
-		if prefix[0] == '/' {
-			prefix = prefix[1:]
-		} else if prefix[len(prefix)-1] != '/' {
-			prefix += "/"
+// Init opens the configured bucket, the storage prefix is not applied
+// to the bucket but to object keys by the storage Layout. Buffered
+// uploads are spilled to the staging directory.
+func Init(ctx context.Context, pluginConfig *config.BeskarYumConfig) (*Bucket, error) {
+	var kr *keyring
+
+	if pluginConfig.Storage.Encryption.Enabled {
+		var err error
+
+		kr, err = loadKeyring(pluginConfig.Storage.Encryption)
+		if err != nil {
+			return nil, err
 		}
-	} else if prefix == "/" {
-		prefix = ""
 	}
 
-	switch pluginConfig.Storage.Driver {
-	case config.S3StorageDriver:
-		return initS3(ctx, pluginConfig.Storage.S3, prefix)
-	case config.FSStorageDriver:
-		return initFS(ctx, pluginConfig.Storage.Filesystem, prefix)
-	case config.GCSStorageDriver:
-		return initGCS(ctx, pluginConfig.Storage.GCS, prefix)
-	case config.AzureStorageDriver:
-		return initAzure(ctx, pluginConfig.Storage.Azure, prefix)
+	bucket, err := openBucket(ctx, pluginConfig.Storage)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("unknown storage driver %s", pluginConfig.Storage.Driver)
+	b := &Bucket{
+		Bucket:  bucket,
+		keyring: kr,
+		driver:  pluginConfig.Storage.Driver,
+		retry:   pluginConfig.Storage.DriverRetry(),
+		// spill files are staged with the packages
+		spillDir: pluginConfig.StagingDir,
+	}
+
+	if newBeforeWrite, ok := beforeWriteHooks[pluginConfig.Storage.Driver]; ok {
+		b.beforeWrite = newBeforeWrite(pluginConfig.Storage)
+	}
+
+	return b, nil
+}
+
+type bucketOpener func(context.Context, config.BeskarYumStorage) (*blob.Bucket, error)
+
+// bucketOpeners holds the storage drivers compiled in, drivers
+// are excluded with the same build tags than config.StorageDrivers.
+var bucketOpeners = map[string]bucketOpener{
+	config.InMemoryStorageDriver: func(ctx context.Context, _ config.BeskarYumStorage) (*blob.Bucket, error) {
+		return initInMemory(ctx)
+	},
+}
+
+func registerBucketOpener(driver string, opener bucketOpener) {
+	bucketOpeners[driver] = opener
+}
+
+// beforeWriteFunc is called with the driver specific types before
+// an object is written, see blob.WriterOptions.BeforeWrite.
+type beforeWriteFunc func(asFunc func(interface{}) bool) error
+
+// beforeWriteHooks holds the drivers customizing object writes,
+// a nil function means no customization.
+var beforeWriteHooks = map[string]func(config.BeskarYumStorage) beforeWriteFunc{}
+
+func registerBeforeWriteHook(driver string, hook func(config.BeskarYumStorage) beforeWriteFunc) {
+	beforeWriteHooks[driver] = hook
+}
+
+func openBucket(ctx context.Context, storage config.BeskarYumStorage) (*blob.Bucket, error) {
+	opener, ok := bucketOpeners[storage.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %s", storage.Driver)
+	}
+	return opener(ctx, storage)
 }
@@ -0,0 +1,403 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestInitInMemory(t *testing.T) {
+	ctx := context.Background()
+
+	bucket, err := Init(ctx, &config.BeskarYumConfig{
+		Storage: config.BeskarYumStorage{
+			Driver: config.InMemoryStorageDriver,
+		},
+	})
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	err = bucket.WriteAll(ctx, "repo/repomd.xml", []byte("repomd"), nil)
+	require.NoError(t, err)
+
+	data, err := bucket.ReadAll(ctx, "repo/repomd.xml")
+	require.NoError(t, err)
+	require.Equal(t, []byte("repomd"), data)
+
+	err = bucket.Delete(ctx, "repo/repomd.xml")
+	require.NoError(t, err)
+
+	exists, err := bucket.Exists(ctx, "repo/repomd.xml")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestInitEncryption(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("BESKAR_YUM_TEST_KEY_1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	t.Setenv("BESKAR_YUM_TEST_KEY_2", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+
+	storageConfig := config.BeskarYumStorage{
+		Driver: config.InMemoryStorageDriver,
+		Encryption: config.BeskarYumEncryption{
+			Enabled: true,
+			KeyID:   "key1",
+			Keys: []config.BeskarYumEncryptionKey{
+				{ID: "key1", KeyEnv: "BESKAR_YUM_TEST_KEY_1"},
+			},
+		},
+	}
+
+	bucket, err := Init(ctx, &config.BeskarYumConfig{Storage: storageConfig})
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	err = bucket.WriteAll(ctx, "repo/plain", []byte("plain"), nil)
+	require.NoError(t, err)
+
+	w, err := bucket.NewWriter(ctx, "repo/key1", nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("secret"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	raw, err := bucket.Bucket.ReadAll(ctx, "repo/key1")
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "secret")
+
+	// objects are copied as is to another key
+	require.NoError(t, bucket.Copy(ctx, "repo/moved", "repo/key1", nil))
+	_, err = bucket.ReadAll(ctx, "repo/moved")
+	require.Error(t, err)
+
+	// rotate key, objects encrypted with key1 remain readable
+	bucket.keyring, err = loadKeyring(config.BeskarYumEncryption{
+		Enabled: true,
+		KeyID:   "key2",
+		Keys: []config.BeskarYumEncryptionKey{
+			{ID: "key2", KeyEnv: "BESKAR_YUM_TEST_KEY_2"},
+			{ID: "key1", KeyEnv: "BESKAR_YUM_TEST_KEY_1"},
+		},
+	})
+	require.NoError(t, err)
+
+	err = bucket.WriteAll(ctx, "repo/key2", []byte("key2"), nil)
+	require.NoError(t, err)
+
+	for key, content := range map[string]string{"plain": "plain", "key1": "secret", "key2": "key2"} {
+		r, err := bucket.NewReader(ctx, "repo/"+key, nil)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, content, string(data))
+	}
+
+	// key1 is required to read key1 objects
+	bucket.keyring, err = loadKeyring(config.BeskarYumEncryption{
+		Enabled: true,
+		KeyID:   "key2",
+		Keys: []config.BeskarYumEncryptionKey{
+			{ID: "key2", KeyEnv: "BESKAR_YUM_TEST_KEY_2"},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = bucket.ReadAll(ctx, "repo/key1")
+	require.ErrorContains(t, err, "unknown key key1")
+}
+
+func TestInitEnvelopeEncryption(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("BESKAR_YUM_TEST_MASTER_KEY_1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	t.Setenv("BESKAR_YUM_TEST_MASTER_KEY_2", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+	t.Setenv("BESKAR_YUM_TEST_SHORT_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 16)))
+
+	encryption := config.BeskarYumEncryption{
+		Enabled: true,
+		Mode:    config.EncryptionModeEnvelope,
+		KeyID:   "master1",
+		Keys: []config.BeskarYumEncryptionKey{
+			{ID: "master1", KeyEnv: "BESKAR_YUM_TEST_MASTER_KEY_1"},
+		},
+	}
+
+	bucket, err := Init(ctx, &config.BeskarYumConfig{
+		Storage: config.BeskarYumStorage{
+			Driver:     config.InMemoryStorageDriver,
+			Encryption: encryption,
+		},
+	})
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	err = bucket.WriteAll(ctx, "repo/master1", []byte("secret"), nil)
+	require.NoError(t, err)
+
+	raw, err := bucket.Bucket.ReadAll(ctx, "repo/master1")
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(raw, envelopeMagic))
+	require.NotContains(t, string(raw), "secret")
+
+	attrs, err := bucket.Attributes(ctx, "repo/master1")
+	require.NoError(t, err)
+	require.Equal(t, "AES-256-GCM", attrs.Metadata[metadataEncryptionAlgorithm])
+	require.Equal(t, "master1", attrs.Metadata[metadataEncryptionKeyID])
+	require.NotEmpty(t, attrs.Metadata[metadataEncryptionWrappedKey])
+
+	// rotate master key, objects wrapped with master1 remain readable
+	encryption.KeyID = "master2"
+	encryption.Keys = append(encryption.Keys, config.BeskarYumEncryptionKey{
+		ID: "master2", KeyEnv: "BESKAR_YUM_TEST_MASTER_KEY_2",
+	})
+	bucket.keyring, err = loadKeyring(encryption)
+	require.NoError(t, err)
+
+	err = bucket.WriteAll(ctx, "repo/master2", []byte("master2"), nil)
+	require.NoError(t, err)
+
+	for key, content := range map[string]string{"master1": "secret", "master2": "master2"} {
+		data, err := bucket.ReadAll(ctx, "repo/"+key)
+		require.NoError(t, err)
+		require.Equal(t, content, string(data))
+	}
+
+	// the wrapped data key is bound to the object key
+	require.NoError(t, bucket.Copy(ctx, "repo/moved", "repo/master1", nil))
+	_, err = bucket.ReadAll(ctx, "repo/moved")
+	require.ErrorContains(t, err, "while unwrapping data key")
+
+	// the master key must be an AES-256 key
+	_, err = loadKeyring(config.BeskarYumEncryption{
+		Enabled: true,
+		Mode:    config.EncryptionModeEnvelope,
+		KeyID:   "short",
+		Keys: []config.BeskarYumEncryptionKey{
+			{ID: "short", KeyEnv: "BESKAR_YUM_TEST_SHORT_KEY"},
+		},
+	})
+	require.ErrorContains(t, err, "must be a 256 bits AES key")
+}
+
+func TestBucketRetry(t *testing.T) {
+	ctx := context.Background()
+
+	bucket, err := Init(ctx, &config.BeskarYumConfig{
+		Storage: config.BeskarYumStorage{
+			Driver: config.InMemoryStorageDriver,
+		},
+	})
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	bucket.retry = config.BeskarYumStorageRetry{MaxRetries: 2, BaseDelay: time.Millisecond}
+
+	netErr := &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}
+	require.True(t, isRetryable(ctx, netErr))
+	require.False(t, isRetryable(ctx, errors.New("unknown")))
+
+	calls := 0
+	err = bucket.withRetry(ctx, "read", func() error {
+		calls++
+		return netErr
+	})
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	err = bucket.withRetry(ctx, "read", func() error {
+		calls++
+		_, err := bucket.Bucket.ReadAll(ctx, "repo/missing")
+		return err
+	})
+	require.Equal(t, gcerrors.NotFound, gcerrors.Code(err))
+	require.Equal(t, 1, calls)
+
+	w, err := bucket.NewWriter(ctx, "repo/buffered", nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("buffered"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	data, err := bucket.ReadAll(ctx, "repo/buffered")
+	require.NoError(t, err)
+	require.Equal(t, []byte("buffered"), data)
+}
+
+func TestBucketLargeUpload(t *testing.T) {
+	if !config.StorageDriverEnabled(config.FSStorageDriver) {
+		t.Skip("filesystem storage driver is excluded from this build")
+	}
+
+	t.Setenv("BESKAR_YUM_TEST_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+
+	keys := []config.BeskarYumEncryptionKey{
+		{ID: "key", KeyEnv: "BESKAR_YUM_TEST_KEY"},
+	}
+
+	for name, encryption := range map[string]config.BeskarYumEncryption{
+		"plain":    {},
+		"direct":   {Enabled: true, KeyID: "key", Keys: keys},
+		"envelope": {Enabled: true, Mode: config.EncryptionModeEnvelope, KeyID: "key", Keys: keys},
+	} {
+		encryption := encryption
+
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			stagingDir := t.TempDir()
+
+			bucket, err := Init(ctx, &config.BeskarYumConfig{
+				StagingDir: stagingDir,
+				Storage: config.BeskarYumStorage{
+					Driver: config.FSStorageDriver,
+					Filesystem: config.BeskarYumFSStorage{
+						Directory: t.TempDir(),
+					},
+					Encryption: encryption,
+				},
+			})
+			require.NoError(t, err)
+			defer bucket.Close()
+
+			bucket.retry = config.BeskarYumStorageRetry{MaxRetries: 2, BaseDelay: time.Millisecond}
+
+			const (
+				chunkSize  = 1 << 20
+				uploadSize = 64 << 20
+			)
+
+			chunk := bytes.Repeat([]byte("beskar"), chunkSize/6+1)[:chunkSize]
+			sum := sha256.New()
+
+			var before, after runtime.MemStats
+
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			w, err := bucket.NewWriter(ctx, "repo/large.rpm", nil)
+			require.NoError(t, err)
+			for written := 0; written < uploadSize; written += chunkSize {
+				_, err = w.Write(chunk)
+				require.NoError(t, err)
+				sum.Write(chunk)
+			}
+
+			// the upload is spilled to the staging directory
+			spilled, err := filepath.Glob(filepath.Join(stagingDir, SpillPattern+"*"))
+			require.NoError(t, err)
+			require.Len(t, spilled, 1)
+
+			require.NoError(t, w.Close())
+			require.NoFileExists(t, spilled[0])
+
+			r, err := bucket.NewReader(ctx, "repo/large.rpm", nil)
+			require.NoError(t, err)
+			defer r.Close()
+
+			readSum := sha256.New()
+			n, err := io.Copy(readSum, r)
+			require.NoError(t, err)
+			require.Equal(t, int64(uploadSize), n)
+			require.Equal(t, sum.Sum(nil), readSum.Sum(nil))
+
+			runtime.ReadMemStats(&after)
+
+			// the upload is spilled to a file instead of being buffered in
+			// memory, encrypted objects are sealed and opened in chunks
+			require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(uploadSize/2))
+		})
+	}
+}
+
+func TestStreamEncryption(t *testing.T) {
+	t.Setenv("BESKAR_YUM_TEST_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+
+	kr, err := loadKeyring(config.BeskarYumEncryption{
+		Enabled: true,
+		KeyID:   "key",
+		Keys: []config.BeskarYumEncryptionKey{
+			{ID: "key", KeyEnv: "BESKAR_YUM_TEST_KEY"},
+		},
+	})
+	require.NoError(t, err)
+
+	seal := func(plaintext []byte) []byte {
+		r, _, err := kr.sealStream("repo/object", bytes.NewReader(plaintext))
+		require.NoError(t, err)
+		sealed, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return sealed
+	}
+
+	for _, size := range []int{0, 1, streamChunkSize, 3*streamChunkSize + 1} {
+		plaintext := bytes.Repeat([]byte{'b'}, size)
+
+		data, err := kr.open("repo/object", seal(plaintext))
+		require.NoError(t, err)
+		require.Equal(t, plaintext, data)
+	}
+
+	sealed := seal(bytes.Repeat([]byte{'b'}, 2*streamChunkSize+1))
+
+	// truncated objects are detected even on a chunk boundary
+	_, err = kr.open("repo/object", sealed[:len(sealed)-1])
+	require.ErrorContains(t, err, "while decrypting object repo/object")
+	_, err = kr.open("repo/object", sealed[:len(sealed)-17])
+	require.ErrorContains(t, err, "while decrypting object repo/object")
+
+	// chunks are bound to the object key
+	_, err = kr.open("repo/moved", sealed)
+	require.ErrorContains(t, err, "while decrypting object repo/moved")
+}
+
+func TestBucketBeforeWriteHook(t *testing.T) {
+	ctx := context.Background()
+
+	bucket, err := Init(ctx, &config.BeskarYumConfig{
+		Storage: config.BeskarYumStorage{
+			Driver: config.InMemoryStorageDriver,
+		},
+	})
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	var calls []string
+
+	bucket.beforeWrite = func(func(interface{}) bool) error {
+		calls = append(calls, "driver")
+		return nil
+	}
+
+	err = bucket.WriteAll(ctx, "repo/hooked", []byte("hooked"), &blob.WriterOptions{
+		BeforeWrite: func(func(interface{}) bool) error {
+			calls = append(calls, "caller")
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"caller", "driver"}, calls)
+
+	calls = nil
+	require.NoError(t, bucket.WriteAll(ctx, "repo/hooked", []byte("hooked"), nil))
+	require.Equal(t, []string{"driver"}, calls)
+}
@@ -0,0 +1,29 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+)
+
+// sweeper periodically removes the leftovers of a storage driver
+// until the context is cancelled.
+type sweeper func(ctx context.Context, bucket *Bucket, storage config.BeskarYumStorage)
+
+// sweepers holds the sweepers of the storage drivers compiled in.
+var sweepers = map[string]sweeper{}
+
+func registerSweeper(driver string, s sweeper) {
+	sweepers[driver] = s
+}
+
+// StartSweeper starts the background cleanup of the configured storage
+// driver if it has one, the cleanup stops once the context is cancelled.
+func (b *Bucket) StartSweeper(ctx context.Context, storage config.BeskarYumStorage) {
+	if s, ok := sweepers[storage.Driver]; ok {
+		go s(ctx, b, storage)
+	}
+}
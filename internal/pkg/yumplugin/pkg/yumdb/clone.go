@@ -5,9 +5,11 @@ package yumdb
 
 import (
 	"archive/tar"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pierrec/lz4/v4"
 )
@@ -32,8 +34,12 @@ func Clone(path string, remoteReader io.Reader) error {
 			continue
 		}
 
-		//nolint:gosec // internal use only
+		// restored snapshots are uploaded, entries must
+		// not escape the database directory
 		target := filepath.Join(path, header.Name)
+		if target != filepath.Clean(path) && !strings.HasPrefix(target, filepath.Clean(path)+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %s is outside of the database directory", header.Name)
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
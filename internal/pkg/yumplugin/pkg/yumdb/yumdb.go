@@ -5,12 +5,19 @@ package yumdb
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 
 	"go.ciq.dev/beskar/pkg/doltdb"
 )
 
+var (
+	ErrPackageExists          = errors.New("package already exists")
+	ErrIdempotencyKeyConflict = errors.New("idempotency key already used by another package")
+)
+
 type WalkPackageFunc func(*Package) error
 
 type Package struct {
@@ -30,6 +37,12 @@ var packageTable = `CREATE TABLE IF NOT EXISTS packages (
 );
 `
 
+var idempotencyTable = `CREATE TABLE IF NOT EXISTS idempotency (
+	idempotency_key VARCHAR(255) PRIMARY KEY,
+	package_id VARCHAR(64)
+);
+`
+
 type YumDB struct {
 	*doltdb.DB
 }
@@ -45,11 +58,36 @@ func Open(path string) (*YumDB, error) {
 		return nil, fmt.Errorf("while initializing yum DB: %w", err)
 	}
 
+	_, err = db.Exec(idempotencyTable)
+	if err != nil {
+		return nil, fmt.Errorf("while initializing yum DB: %w", err)
+	}
+
 	return &YumDB{db}, nil
 }
 
-func (db *YumDB) AddPackage(ctx context.Context, id, name, primary, filelists, other string) error {
-	var err error
+// AddPackage adds a package to the database, if an idempotency key is provided
+// it's recorded along with the package. It returns ErrPackageExists if the
+// package is already present and ErrIdempotencyKeyConflict if the idempotency
+// key was previously used for another package.
+func (db *YumDB) AddPackage(ctx context.Context, id, name, primary, filelists, other, idempotencyKey string) error {
+	if idempotencyKey != "" {
+		packageID, err := db.PackageIDFromIdempotencyKey(ctx, idempotencyKey)
+		if err != nil {
+			return err
+		} else if packageID == id {
+			return ErrPackageExists
+		} else if packageID != "" {
+			return ErrIdempotencyKeyConflict
+		}
+	}
+
+	exists, err := db.HasPackage(ctx, id)
+	if err != nil {
+		return err
+	} else if exists {
+		return ErrPackageExists
+	}
 
 	dbPackage := &Package{
 		ID:   id,
@@ -81,9 +119,63 @@ func (db *YumDB) AddPackage(ctx context.Context, id, name, primary, filelists, o
 		return fmt.Errorf("package not inserted into database")
 	}
 
+	if idempotencyKey != "" {
+		_, err := db.ExecContext(ctx, "INSERT INTO idempotency VALUES(?, ?)", idempotencyKey, id)
+		if err != nil {
+			return err
+		}
+	}
+
 	return db.CommitAll(ctx, id)
 }
 
+// RemovePackage removes a package and its idempotency keys from the database,
+// it returns false if the package wasn't present.
+func (db *YumDB) RemovePackage(ctx context.Context, id string) (bool, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM packages WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	} else if deleted == 0 {
+		return false, nil
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM idempotency WHERE package_id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, db.CommitAll(ctx, "remove "+id)
+}
+
+func (db *YumDB) HasPackage(ctx context.Context, id string) (bool, error) {
+	count := 0
+
+	err := db.QueryRowxContext(ctx, "SELECT COUNT(id) FROM packages WHERE id = ?", id).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// PackageIDFromIdempotencyKey returns the ID of the package recorded with
+// the idempotency key, an empty ID is returned if the key is unused.
+func (db *YumDB) PackageIDFromIdempotencyKey(ctx context.Context, idempotencyKey string) (string, error) {
+	packageID := ""
+
+	err := db.QueryRowxContext(ctx, "SELECT package_id FROM idempotency WHERE idempotency_key = ?", idempotencyKey).Scan(&packageID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	return packageID, nil
+}
+
 func (db *YumDB) CountPackages(ctx context.Context) (int, error) {
 	//nolint:sqlclosecheck // closed by caller
 	rows, err := db.QueryxContext(ctx, "SELECT COUNT(id) AS id FROM packages")
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddPackageIdempotency(t *testing.T) {
+	dbPath, err := os.MkdirTemp("", "yumdb-")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dbPath)
+
+	t.Setenv("HOME", dbPath)
+
+	metaFile := filepath.Join(dbPath, "meta.xml")
+	require.NoError(t, os.WriteFile(metaFile, []byte("<package/>"), 0o600))
+
+	dbDir := filepath.Join(dbPath, "db")
+	require.NoError(t, os.Mkdir(dbDir, 0o700))
+
+	db, err := Open(dbDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	err = db.AddPackage(ctx, "id1", "pkg1", metaFile, metaFile, metaFile, "key1")
+	require.NoError(t, err)
+
+	err = db.AddPackage(ctx, "id1", "pkg1", metaFile, metaFile, metaFile, "key1")
+	require.ErrorIs(t, err, ErrPackageExists)
+
+	err = db.AddPackage(ctx, "id1", "pkg1", metaFile, metaFile, metaFile, "")
+	require.ErrorIs(t, err, ErrPackageExists)
+
+	err = db.AddPackage(ctx, "id2", "pkg2", metaFile, metaFile, metaFile, "key1")
+	require.ErrorIs(t, err, ErrIdempotencyKeyConflict)
+
+	err = db.AddPackage(ctx, "id2", "pkg2", metaFile, metaFile, metaFile, "key2")
+	require.NoError(t, err)
+
+	count, err := db.CountPackages(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	removed, err := db.RemovePackage(ctx, "id1")
+	require.NoError(t, err)
+	require.True(t, removed)
+
+	removed, err = db.RemovePackage(ctx, "id1")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	// the idempotency key is released along with the package
+	err = db.AddPackage(ctx, "id3", "pkg3", metaFile, metaFile, metaFile, "key1")
+	require.NoError(t, err)
+
+	count, err = db.CountPackages(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
+func TestCloneRejectsEscapingEntries(t *testing.T) {
+	buf := new(bytes.Buffer)
+	lz := lz4.NewWriter(buf)
+	tw := tar.NewWriter(lz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escaped", Mode: 0o600, Size: 1, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, lz.Close())
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db")
+	require.NoError(t, os.Mkdir(dbPath, 0o700))
+
+	err = Clone(dbPath, buf)
+	require.ErrorContains(t, err, "archive entry ../escaped is outside of the database directory")
+	require.NoFileExists(t, filepath.Join(dir, "escaped"))
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumsign
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	//nolint:staticcheck // no replacement in golang.org/x/crypto
+	"golang.org/x/crypto/openpgp"
+	//nolint:staticcheck // no replacement in golang.org/x/crypto
+	"golang.org/x/crypto/openpgp/armor"
+	//nolint:staticcheck // no replacement in golang.org/x/crypto
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Signer signs repository metadata with a GPG private key.
+type Signer struct {
+	entity    *openpgp.Entity
+	publicKey []byte
+}
+
+// NewSigner returns a signer for the armored private key, if a key ID
+// is provided the corresponding key is selected from the key ring otherwise
+// the first key is used. The passphrase is required for encrypted keys.
+func NewSigner(armoredKey io.Reader, keyID string, passphrase []byte) (*Signer, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(armoredKey)
+	if err != nil {
+		return nil, fmt.Errorf("while reading GPG key: %w", err)
+	}
+
+	entity, err := findEntity(entities, keyID)
+	if err != nil {
+		return nil, err
+	} else if entity.PrivateKey == nil {
+		return nil, fmt.Errorf("GPG key %s has no private key", entity.PrimaryKey.KeyIdString())
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("GPG key %s is encrypted and no passphrase provided", entity.PrimaryKey.KeyIdString())
+		} else if err := entity.PrivateKey.Decrypt(passphrase); err != nil {
+			return nil, fmt.Errorf("while decrypting GPG key: %w", err)
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
+					return nil, fmt.Errorf("while decrypting GPG subkey: %w", err)
+				}
+			}
+		}
+	}
+
+	// ensure the key is usable for signing
+	if err := openpgp.DetachSign(io.Discard, entity, strings.NewReader(""), nil); err != nil {
+		return nil, fmt.Errorf("while validating GPG key: %w", err)
+	}
+
+	publicKey := new(bytes.Buffer)
+
+	aw, err := armor.Encode(publicKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	} else if err := entity.Serialize(aw); err != nil {
+		return nil, fmt.Errorf("while serializing GPG public key: %w", err)
+	} else if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		entity:    entity,
+		publicKey: publicKey.Bytes(),
+	}, nil
+}
+
+func findEntity(entities openpgp.EntityList, keyID string) (*openpgp.Entity, error) {
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no GPG key found")
+	} else if keyID == "" {
+		return entities[0], nil
+	}
+
+	keyID = strings.ToUpper(strings.TrimPrefix(keyID, "0x"))
+
+	for _, entity := range entities {
+		if matchKeyID(entity.PrimaryKey, keyID) {
+			return entity, nil
+		}
+		for _, subkey := range entity.Subkeys {
+			if matchKeyID(subkey.PublicKey, keyID) {
+				return entity, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no GPG key found with ID %s", keyID)
+}
+
+func matchKeyID(pk *packet.PublicKey, keyID string) bool {
+	return pk.KeyIdString() == keyID || pk.KeyIdShortString() == keyID
+}
+
+// KeyID returns the key ID of the signing key.
+func (s *Signer) KeyID() string {
+	return s.entity.PrimaryKey.KeyIdString()
+}
+
+// PublicKey returns the armored public key.
+func (s *Signer) PublicKey() []byte {
+	return s.publicKey
+}
+
+// Sign writes an armored detached signature of the message to the writer.
+func (s *Signer) Sign(message io.Reader, w io.Writer) error {
+	return openpgp.ArmoredDetachSign(w, s.entity, message, nil)
+}
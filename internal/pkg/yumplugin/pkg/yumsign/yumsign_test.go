@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumsign
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestSigner(t *testing.T) {
+	entity, err := openpgp.NewEntity("beskar", "", "beskar@ciq.com", nil)
+	require.NoError(t, err)
+
+	privateKey := new(bytes.Buffer)
+	aw, err := armor.Encode(privateKey, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(aw, nil))
+	require.NoError(t, aw.Close())
+
+	_, err = NewSigner(bytes.NewReader(privateKey.Bytes()), "DEADBEEF", nil)
+	require.Error(t, err)
+
+	signer, err := NewSigner(bytes.NewReader(privateKey.Bytes()), entity.PrimaryKey.KeyIdShortString(), nil)
+	require.NoError(t, err)
+	require.Equal(t, entity.PrimaryKey.KeyIdString(), signer.KeyID())
+
+	message := "<repomd/>"
+	signature := new(bytes.Buffer)
+	require.NoError(t, signer.Sign(strings.NewReader(message), signature))
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(signer.PublicKey()))
+	require.NoError(t, err)
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(message), signature)
+	require.NoError(t, err)
+}
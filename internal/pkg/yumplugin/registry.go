@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"go.ciq.dev/beskar/pkg/retry"
+)
+
+// registryRetryDeadline bounds the time after which a failed registry
+// call isn't retried anymore, it doesn't bound the call itself as
+// package transfers may take longer.
+const registryRetryDeadline = 30 * time.Second
+
+// errRegistryUnavailable is returned once all retries of a registry call
+// have failed because of transient errors.
+var errRegistryUnavailable = errors.New("registry unavailable")
+
+// isTransientRegistryError returns true for network errors and registry
+// responses which may succeed if the call is retried.
+func isTransientRegistryError(err error) bool {
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode >= http.StatusInternalServerError || terr.StatusCode == http.StatusTooManyRequests
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr)
+}
+
+func (p *Plugin) remoteOptionsWithContext(ctx context.Context) []remote.Option {
+	options := make([]remote.Option, 0, len(p.remoteOptions)+1)
+	options = append(options, p.remoteOptions...)
+	return append(options, remote.WithContext(ctx))
+}
+
+func (p *Plugin) registryBackoff() backoff.BackOff {
+	eb := backoff.NewExponentialBackOff()
+	eb.InitialInterval = p.beskarYumConfig.Registry.RetryBackoff
+	eb.MaxElapsedTime = registryRetryDeadline
+	return backoff.WithMaxRetries(eb, uint64(p.beskarYumConfig.Registry.Retries))
+}
+
+// withRegistryRetry calls the registry operation and retries it with
+// a backoff on transient errors, registry operations are read or content
+// addressable push operations and are safe to retry. The remote options
+// passed to the operation are bound to the retry context.
+func (p *Plugin) withRegistryRetry(ctx context.Context, op func(options ...remote.Option) error) error {
+	if p.beskarYumConfig.Registry.Retries <= 0 {
+		return op(p.remoteOptionsWithContext(ctx)...)
+	}
+
+	options := p.remoteOptionsWithContext(ctx)
+	transient := false
+
+	err := retry.Retry(ctx, p.registryBackoff, func() error {
+		err := op(options...)
+		if err != nil && !isTransientRegistryError(err) {
+			return backoff.Permanent(err)
+		}
+		transient = err != nil
+		return err
+	})
+	if err != nil && transient {
+		return fmt.Errorf("%w: %w", errRegistryUnavailable, err)
+	}
+
+	return err
+}
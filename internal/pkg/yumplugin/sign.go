@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumsign"
+)
+
+const repomdASCFile = "repomd.xml.asc"
+
+func loadSigner(gpgConfig config.BeskarYumGPG) (*yumsign.Signer, error) {
+	var keyReader io.Reader
+
+	switch {
+	case gpgConfig.KeyFile != "" && gpgConfig.KeyEnv != "":
+		return nil, fmt.Errorf("GPG key file and GPG key environment variable are mutually exclusive")
+	case gpgConfig.KeyFile != "":
+		f, err := os.Open(gpgConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("while opening GPG key file: %w", err)
+		}
+		defer f.Close()
+		keyReader = f
+	default:
+		key := os.Getenv(gpgConfig.KeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("GPG key environment variable %s is not set", gpgConfig.KeyEnv)
+		}
+		keyReader = strings.NewReader(key)
+	}
+
+	var passphrase []byte
+	if gpgConfig.PassphraseEnv != "" {
+		passphrase = []byte(os.Getenv(gpgConfig.PassphraseEnv))
+	}
+
+	return yumsign.NewSigner(keyReader, gpgConfig.KeyID, passphrase)
+}
+
+func signRepomd(signer *yumsign.Signer, repomdPath string) (string, error) {
+	repomd, err := os.Open(repomdPath)
+	if err != nil {
+		return "", err
+	}
+	defer repomd.Close()
+
+	ascPath := filepath.Join(filepath.Dir(repomdPath), repomdASCFile)
+
+	asc, err := os.Create(ascPath)
+	if err != nil {
+		return "", err
+	}
+
+	if err := signer.Sign(repomd, asc); err != nil {
+		_ = asc.Close()
+		return "", fmt.Errorf("while signing %s: %w", repomdXMLFile, err)
+	}
+
+	return ascPath, asc.Close()
+}
@@ -0,0 +1,578 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
+	"go.ciq.dev/beskar/pkg/oras"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	// snapshotVersion is the version of the snapshot archive format,
+	// snapshots of other versions are rejected.
+	snapshotVersion = 1
+
+	snapshotIndexFile    = "snapshot.json"
+	snapshotDatabaseFile = "doltdb.tar.lz4"
+	snapshotRepodataDir  = "repodata"
+
+	maxSnapshotIndexSize = 1 << 20
+)
+
+var (
+	errSnapshotNotFound = errors.New("repository not found")
+	errInvalidSnapshot  = errors.New("invalid snapshot")
+	errRestoreInFlight  = errors.New("repository packages are being processed")
+)
+
+// SnapshotFile describes a file of a snapshot archive.
+type SnapshotFile struct {
+	Name        string            `json:"name"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	MediaType   string            `json:"media-type,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SnapshotIndex is the first entry of a snapshot archive, it lists the
+// repository database and the repository metadata layers with their
+// checksums. Packages aren't part of snapshots, they must be present
+// in the registry when a snapshot is restored.
+type SnapshotIndex struct {
+	Version    int             `json:"version"`
+	Repository string          `json:"repository"`
+	Created    time.Time       `json:"created"`
+	Database   *SnapshotFile   `json:"database"`
+	Repodata   []*SnapshotFile `json:"repodata"`
+}
+
+// Snapshot is a repository snapshot staged in a temporary directory,
+// it must be closed to remove the directory.
+type Snapshot struct {
+	Index *SnapshotIndex
+	dir   string
+}
+
+// Close removes the staged snapshot files.
+func (s *Snapshot) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+func (s *Snapshot) path(file *SnapshotFile) string {
+	return filepath.Join(s.dir, strings.TrimPrefix(file.Digest, "sha256:"))
+}
+
+func (s *Snapshot) files() []*SnapshotFile {
+	return append([]*SnapshotFile{s.Index.Database}, s.Index.Repodata...)
+}
+
+// stage writes the content read from r to the snapshot directory and
+// returns the file description, at most maxSize + 1 bytes are read
+// unless maxSize is negative.
+func (s *Snapshot) stage(name string, r io.Reader, maxSize int64) (*SnapshotFile, error) {
+	f, err := os.CreateTemp(s.dir, "staging-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	if maxSize >= 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+
+	checksum := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(f, checksum), r)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &SnapshotFile{
+		Name:   name,
+		Digest: "sha256:" + hex.EncodeToString(checksum.Sum(nil)),
+		Size:   size,
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	return file, os.Rename(f.Name(), s.path(file))
+}
+
+// WriteArchive writes the snapshot as a tar archive, the index is
+// written first.
+func (s *Snapshot) WriteArchive(w io.Writer) error {
+	index, err := json.Marshal(s.Index)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+
+	header := &tar.Header{
+		Name:    snapshotIndexFile,
+		Mode:    0o644,
+		Size:    int64(len(index)),
+		ModTime: s.Index.Created,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	} else if _, err := tw.Write(index); err != nil {
+		return err
+	}
+
+	for _, file := range s.files() {
+		if err := s.writeFile(tw, file); err != nil {
+			return fmt.Errorf("while writing %s: %w", file.Name, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+func (s *Snapshot) writeFile(tw *tar.Writer, file *SnapshotFile) error {
+	f, err := os.Open(s.path(file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := &tar.Header{
+		Name:    file.Name,
+		Mode:    0o644,
+		Size:    file.Size,
+		ModTime: s.Index.Created,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func invalidSnapshotf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errInvalidSnapshot, fmt.Sprintf(format, args...))
+}
+
+// validate checks the snapshot index and returns the described files
+// indexed by name.
+func (idx *SnapshotIndex) validate() (map[string]*SnapshotFile, error) {
+	if idx.Version != snapshotVersion {
+		return nil, invalidSnapshotf("unsupported version %d", idx.Version)
+	} else if idx.Database == nil || idx.Database.Name != snapshotDatabaseFile {
+		return nil, invalidSnapshotf("no repository database")
+	}
+
+	files := make(map[string]*SnapshotFile)
+	repomd := false
+
+	for _, file := range append([]*SnapshotFile{idx.Database}, idx.Repodata...) {
+		if file == nil {
+			return nil, invalidSnapshotf("empty file entry")
+		}
+		hash, err := v1.NewHash(file.Digest)
+		if err != nil || hash.Algorithm != "sha256" {
+			return nil, invalidSnapshotf("file %s digest %q is invalid", file.Name, file.Digest)
+		} else if file.Size < 0 {
+			return nil, invalidSnapshotf("file %s size %d is invalid", file.Name, file.Size)
+		}
+		if file != idx.Database {
+			if file.Name != snapshotRepodataDir+"/"+file.Digest {
+				return nil, invalidSnapshotf("repodata file name %s doesn't match its digest", file.Name)
+			} else if file.MediaType == "" {
+				return nil, invalidSnapshotf("repodata file %s has no media type", file.Name)
+			}
+			repomd = repomd || file.MediaType == orasrpm.RepomdXMLLayerType
+		}
+		if _, ok := files[file.Name]; ok {
+			return nil, invalidSnapshotf("duplicate file %s", file.Name)
+		}
+		files[file.Name] = file
+	}
+
+	if !repomd {
+		return nil, invalidSnapshotf("no %s in repository metadata", repomdXMLFile)
+	}
+
+	return files, nil
+}
+
+// readSnapshot reads a snapshot archive into dir, the index is validated
+// and the size and the checksum of every file are checked against it.
+func readSnapshot(r io.Reader, dir string) (*Snapshot, error) {
+	tr := tar.NewReader(r)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, invalidSnapshotf("while reading archive: %s", err)
+	} else if header.Name != snapshotIndexFile {
+		return nil, invalidSnapshotf("first entry %s is not %s", header.Name, snapshotIndexFile)
+	} else if header.Size > maxSnapshotIndexSize {
+		return nil, invalidSnapshotf("%s is too large", snapshotIndexFile)
+	}
+
+	index := new(SnapshotIndex)
+	if err := json.NewDecoder(tr).Decode(index); err != nil {
+		return nil, invalidSnapshotf("while decoding %s: %s", snapshotIndexFile, err)
+	}
+
+	files, err := index.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Index: index,
+		dir:   dir,
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, invalidSnapshotf("while reading archive: %s", err)
+		}
+
+		expected, ok := files[header.Name]
+		if !ok {
+			return nil, invalidSnapshotf("unexpected entry %s", header.Name)
+		}
+		delete(files, header.Name)
+
+		staged, err := snapshot.stage(header.Name, tr, expected.Size)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, invalidSnapshotf("entry %s is truncated", header.Name)
+		} else if err != nil {
+			return nil, fmt.Errorf("while staging %s: %w", header.Name, err)
+		}
+
+		if staged.Size != expected.Size {
+			return nil, invalidSnapshotf("entry %s size %d doesn't match %d", header.Name, staged.Size, expected.Size)
+		} else if staged.Digest != expected.Digest {
+			return nil, invalidSnapshotf("entry %s checksum %s doesn't match %s", header.Name, staged.Digest, expected.Digest)
+		}
+	}
+
+	for name := range files {
+		return nil, invalidSnapshotf("missing entry %s", name)
+	}
+
+	return snapshot, nil
+}
+
+func (p *Plugin) repodataReference(repository string) (name.Tag, error) {
+	return name.NewTag(filepath.Join(p.registry, pluginName, repository, "repodata:latest"), p.nameOptions...)
+}
+
+// SnapshotRepository stages a snapshot of the repository database and
+// metadata. The database and the metadata are read one after the other,
+// packages uploaded meanwhile may be present in the database only.
+func (p *Plugin) SnapshotRepository(ctx context.Context, repository string) (_ *Snapshot, errFn error) {
+	dir, err := os.MkdirTemp(p.dataDir, "snapshot-")
+	if err != nil {
+		return nil, fmt.Errorf("while creating temporary snapshot directory: %w", err)
+	}
+
+	snapshot := &Snapshot{
+		Index: &SnapshotIndex{
+			Version:    snapshotVersion,
+			Repository: repository,
+			Created:    time.Now().UTC(),
+		},
+		dir: dir,
+	}
+	defer func() {
+		if errFn != nil {
+			_ = snapshot.Close()
+		}
+	}()
+
+	rc, err := p.bucket.NewReader(ctx, p.storageLayout.RepositoryKey(repository, "doltdb.tar.lz4"), &blob.ReaderOptions{})
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, fmt.Errorf("%w: no database for repository %s", errSnapshotNotFound, repository)
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading repository %s database: %w", repository, err)
+	}
+	defer rc.Close()
+
+	snapshot.Index.Database, err = snapshot.stage(snapshotDatabaseFile, rc, -1)
+	if err != nil {
+		return nil, fmt.Errorf("while reading repository %s database: %w", repository, err)
+	}
+
+	ref, err := p.repodataReference(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest *v1.Manifest
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) (err error) {
+		manifest, err = oras.GetManifest(ref, options...)
+		return err
+	})
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: no metadata for repository %s", errSnapshotNotFound, repository)
+	} else if err != nil {
+		return nil, fmt.Errorf("while getting repository %s metadata: %w", repository, err)
+	}
+
+	for _, layer := range manifest.Layers {
+		file, err := p.snapshotLayer(ctx, snapshot, ref.Context().Digest(layer.Digest.String()))
+		if err != nil {
+			return nil, fmt.Errorf("while reading repository %s metadata layer %s: %w", repository, layer.Digest, err)
+		} else if file.Digest != layer.Digest.String() {
+			return nil, fmt.Errorf("repository %s metadata layer %s checksum is %s", repository, layer.Digest, file.Digest)
+		}
+		file.MediaType = string(layer.MediaType)
+		file.Annotations = layer.Annotations
+		snapshot.Index.Repodata = append(snapshot.Index.Repodata, file)
+	}
+
+	return snapshot, nil
+}
+
+func (p *Plugin) snapshotLayer(ctx context.Context, snapshot *Snapshot, ref name.Digest) (file *SnapshotFile, err error) {
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+		layer, err := remote.Layer(ref, options...)
+		if err != nil {
+			return err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		file, err = snapshot.stage(snapshotRepodataDir+"/"+ref.DigestStr(), rc, -1)
+		return err
+	})
+	return file, err
+}
+
+// RestoreRepository restores a repository snapshot read from r. The
+// snapshot is entirely read and validated before the repository is
+// modified, the database is then replaced and the metadata are published.
+// The previous database is restored if the metadata can't be published,
+// clients keep being served the previous metadata until then. Restores
+// are refused while packages of the repository are being processed, the
+// packages received once the repository is locked are added to the
+// restored database.
+func (p *Plugin) RestoreRepository(ctx context.Context, repository string, r io.Reader) (*SnapshotIndex, error) {
+	eventRepository := filepath.Join(pluginName, repository, "packages")
+
+	// fails early before reading the snapshot
+	if p.inflight.hasRepository(eventRepository) {
+		return nil, errRestoreInFlight
+	}
+
+	dir, err := os.MkdirTemp(p.dataDir, "restore-")
+	if err != nil {
+		return nil, fmt.Errorf("while creating temporary restore directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot, err := readSnapshot(r, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSnapshotDatabase(snapshot, filepath.Join(dir, "db")); err != nil {
+		return nil, err
+	}
+
+	ref, err := p.repodataReference(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]oras.Layer, 0, len(snapshot.Index.Repodata))
+	for _, file := range snapshot.Index.Repodata {
+		metadata, err := newGenericRPMMetadata(snapshot.path(file), file.MediaType, file.Annotations)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, orasrpm.NewRPMMetadataLayer(metadata))
+	}
+
+	unlock := p.repoLocks.lock(repository)
+	defer unlock()
+
+	// the in-flight packages would be added to the replaced database
+	if p.inflight.hasRepository(eventRepository) {
+		return nil, errRestoreInFlight
+	}
+
+	key := p.storageLayout.RepositoryKey(repository, "doltdb.tar.lz4")
+
+	previous, err := p.bucket.ReadAll(ctx, key)
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, fmt.Errorf("while reading repository %s database: %w", repository, err)
+	}
+
+	if err := p.writeDatabaseFile(ctx, key, snapshot.path(snapshot.Index.Database)); err != nil {
+		return nil, err
+	}
+
+	if err := p.publishMetadata(ctx, orasrpm.NewRPMMetadataPusher(ref, layers...)); err != nil {
+		if previous == nil {
+			err = errors.Join(err, p.bucket.Delete(ctx, key))
+		} else {
+			err = errors.Join(err, p.bucket.WriteAll(ctx, key, previous, &blob.WriterOptions{}))
+		}
+		return nil, err
+	}
+
+	return snapshot.Index, nil
+}
+
+// checkSnapshotDatabase checks that the snapshot database can be
+// cloned and opened.
+func checkSnapshotDatabase(snapshot *Snapshot, dbPath string) error {
+	f, err := os.Open(snapshot.path(snapshot.Index.Database))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := os.Mkdir(dbPath, 0o700); err != nil {
+		return err
+	}
+
+	if err := yumdb.Clone(dbPath, f); err != nil {
+		return invalidSnapshotf("while cloning database: %s", err)
+	}
+
+	db, err := yumdb.Open(dbPath)
+	if err != nil {
+		return invalidSnapshotf("while opening database: %s", err)
+	}
+
+	return db.Close()
+}
+
+// writeDatabaseFile writes the database archive located at path to the
+// storage, the stored database is left untouched if the write fails.
+func (p *Plugin) writeDatabaseFile(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// cancelling the writer context before closing it aborts the write
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	remoteWriter, err := p.bucket.NewWriter(ctx, key, &blob.WriterOptions{})
+	if err != nil {
+		return fmt.Errorf("while initializing s3 object writer: %w", err)
+	}
+
+	if _, err := io.Copy(remoteWriter, f); err != nil {
+		cancel()
+		_ = remoteWriter.Close()
+		return fmt.Errorf("while writing database to s3 bucket: %w", err)
+	}
+
+	return remoteWriter.Close()
+}
+
+func snapshotHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !plugin.authorizeAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		repository := mux.Vars(r)["repository"]
+
+		snapshot, err := plugin.SnapshotRepository(r.Context(), repository)
+		if errors.Is(err, errSnapshotNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if errors.Is(err, errRegistryUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer snapshot.Close()
+
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", repository+"-snapshot.tar"))
+
+		// the response is already started, the client detects the
+		// truncated archive
+		if err := snapshot.WriteArchive(w); err != nil {
+			logrus.Errorf("Snapshot of repository %s failed: %s", repository, err)
+		}
+	}
+}
+
+func restoreHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !plugin.authorizeAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		index, err := plugin.RestoreRepository(r.Context(), mux.Vars(r)["repository"], r.Body)
+		if errors.Is(err, errInvalidSnapshot) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, errRestoreInFlight) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if errors.Is(err, errRegistryUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(index)
+	}
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+)
+
+func newTestSnapshot(t *testing.T) *Snapshot {
+	snapshot := &Snapshot{
+		Index: &SnapshotIndex{
+			Version:    snapshotVersion,
+			Repository: "rocky",
+			Created:    time.Now().UTC(),
+		},
+		dir: t.TempDir(),
+	}
+
+	var err error
+
+	snapshot.Index.Database, err = snapshot.stage(snapshotDatabaseFile, strings.NewReader("database"), -1)
+	require.NoError(t, err)
+
+	for content, mediatype := range map[string]string{
+		"<repomd/>":   orasrpm.RepomdXMLLayerType,
+		"<metadata/>": orasrpm.PrimaryXMLLayerType,
+	} {
+		file, err := snapshot.stage("", strings.NewReader(content), -1)
+		require.NoError(t, err)
+		file.Name = snapshotRepodataDir + "/" + file.Digest
+		file.MediaType = mediatype
+		snapshot.Index.Repodata = append(snapshot.Index.Repodata, file)
+	}
+
+	return snapshot
+}
+
+type tarEntry struct {
+	name    string
+	content string
+}
+
+func buildArchive(t *testing.T, entries []tarEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.content))}))
+		_, err := tw.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestSnapshotArchive(t *testing.T) {
+	snapshot := newTestSnapshot(t)
+
+	archive := new(bytes.Buffer)
+	require.NoError(t, snapshot.WriteArchive(archive))
+
+	restored, err := readSnapshot(bytes.NewReader(archive.Bytes()), t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, snapshot.Index.Database, restored.Index.Database)
+	require.Equal(t, snapshot.Index.Repodata, restored.Index.Repodata)
+
+	for _, file := range restored.files() {
+		content, err := os.ReadFile(restored.path(file))
+		require.NoError(t, err)
+		expected, err := os.ReadFile(snapshot.path(file))
+		require.NoError(t, err)
+		require.Equal(t, expected, content)
+	}
+}
+
+func TestReadSnapshotInvalid(t *testing.T) {
+	snapshot := newTestSnapshot(t)
+
+	index := func(mutate func(idx *SnapshotIndex)) string {
+		idx := *snapshot.Index
+		idx.Repodata = append([]*SnapshotFile{}, idx.Repodata...)
+		if mutate != nil {
+			mutate(&idx)
+		}
+		data, err := json.Marshal(idx)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	entries := func(indexContent string) []tarEntry {
+		entries := []tarEntry{{snapshotIndexFile, indexContent}}
+		for _, file := range snapshot.files() {
+			content, err := os.ReadFile(snapshot.path(file))
+			require.NoError(t, err)
+			entries = append(entries, tarEntry{file.Name, string(content)})
+		}
+		return entries
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr string
+	}{
+		{
+			name:    "not an archive",
+			archive: []byte("not an archive"),
+			wantErr: "while reading archive",
+		},
+		{
+			name:    "index not first",
+			archive: buildArchive(t, entries(index(nil))[1:]),
+			wantErr: "first entry doltdb.tar.lz4 is not snapshot.json",
+		},
+		{
+			name: "unsupported version",
+			archive: buildArchive(t, entries(index(func(idx *SnapshotIndex) {
+				idx.Version = 2
+			}))),
+			wantErr: "unsupported version 2",
+		},
+		{
+			name: "no repomd",
+			archive: buildArchive(t, entries(index(func(idx *SnapshotIndex) {
+				idx.Repodata = idx.Repodata[:0]
+			}))),
+			wantErr: "no repomd.xml in repository metadata",
+		},
+		{
+			name: "bad checksum",
+			archive: buildArchive(t, append(entries(index(nil))[:1], tarEntry{
+				snapshotDatabaseFile, "databasf",
+			})),
+			wantErr: "entry doltdb.tar.lz4 checksum",
+		},
+		{
+			name: "bad size",
+			archive: buildArchive(t, append(entries(index(nil))[:1], tarEntry{
+				snapshotDatabaseFile, "database+",
+			})),
+			wantErr: "entry doltdb.tar.lz4 size 9 doesn't match 8",
+		},
+		{
+			name:    "unexpected entry",
+			archive: buildArchive(t, append(entries(index(nil)), tarEntry{"../tokens.json", "[]"})),
+			wantErr: "unexpected entry ../tokens.json",
+		},
+		{
+			name:    "missing entry",
+			archive: buildArchive(t, entries(index(nil))[:2]),
+			wantErr: "missing entry repodata/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readSnapshot(bytes.NewReader(tt.archive), t.TempDir())
+			require.ErrorIs(t, err, errInvalidSnapshot)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRestoreRepositoryInFlight(t *testing.T) {
+	plugin := &Plugin{
+		dataDir:  t.TempDir(),
+		inflight: newInflightPackages(),
+		beskarYumConfig: &config.BeskarYumConfig{
+			Registry: config.BeskarYumRegistry{Username: "admin", Password: "secret"},
+		},
+	}
+
+	plugin.inflight.add("yum/rocky/packages", "id")
+	require.True(t, plugin.inflight.hasRepository("yum/rocky/packages"))
+	require.False(t, plugin.inflight.hasRepository("yum/other/packages"))
+
+	// restores are refused while packages of the repository are processed
+	_, err := plugin.RestoreRepository(context.Background(), "rocky", strings.NewReader(""))
+	require.ErrorIs(t, err, errRestoreInFlight)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/yum/api/v1/repo/{repository}/restore", restoreHandler(plugin))
+
+	req := httptest.NewRequest(http.MethodPost, "/yum/api/v1/repo/rocky/restore", strings.NewReader(""))
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	plugin.inflight.remove("yum/rocky/packages", "id")
+	require.False(t, plugin.inflight.hasRepository("yum/rocky/packages"))
+
+	// the snapshot is then read
+	_, err = plugin.RestoreRepository(context.Background(), "rocky", strings.NewReader(""))
+	require.ErrorIs(t, err, errInvalidSnapshot)
+}
@@ -0,0 +1,275 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yummeta"
+	"go.ciq.dev/beskar/pkg/oras"
+)
+
+// otherRepositories labels the aggregated statistics of the
+// repositories without their own series.
+const otherRepositories = "_other"
+
+// repositoryStats holds the statistics of a repository computed from
+// its metadata, they are recomputed once the metadata change.
+type repositoryStats struct {
+	repository string
+	packages   int
+	bytes      int64
+	updated    time.Time
+	// digest is the digest of the primary metadata the
+	// statistics were computed from.
+	digest string
+}
+
+// primarySize holds the package size of a primary.xml package.
+type primarySize struct {
+	Size struct {
+		Package int64 `xml:"package,attr"`
+	} `xml:"size"`
+}
+
+// repositoryStats returns the statistics of a repository, previous
+// statistics are returned if the repository metadata didn't change.
+func (p *Plugin) repositoryStats(ctx context.Context, repository string, previous *repositoryStats) (*repositoryStats, error) {
+	ref, err := name.ParseReference(filepath.Join(p.registry, pluginName, repository, "repodata:latest"), p.nameOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest *v1.Manifest
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) (err error) {
+		manifest, err = oras.GetManifest(ref, options...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while getting repository %s metadata: %w", repository, err)
+	}
+
+	var primaryLayer, repomdLayer *v1.Descriptor
+
+	for i, layer := range manifest.Layers {
+		switch {
+		case isPrimaryXMLLayer(string(layer.MediaType)):
+			primaryLayer = &manifest.Layers[i]
+		case string(layer.MediaType) == orasrpm.RepomdXMLLayerType:
+			repomdLayer = &manifest.Layers[i]
+		}
+	}
+
+	if primaryLayer == nil || repomdLayer == nil {
+		return nil, fmt.Errorf("no %s or %s found in repository %s metadata", primaryXMLFile, repomdXMLFile, repository)
+	} else if previous != nil && previous.digest == primaryLayer.Digest.String() {
+		return previous, nil
+	}
+
+	stats := &repositoryStats{
+		repository: repository,
+		digest:     primaryLayer.Digest.String(),
+	}
+
+	if err := p.countPackages(ctx, ref.Context(), primaryLayer.Digest, stats); err != nil {
+		return nil, fmt.Errorf("while reading repository %s %s: %w", repository, primaryXMLFile, err)
+	}
+
+	rc, err := p.openRepodataLayer(ctx, ref.Context(), repomdLayer.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching repository %s %s: %w", repository, repomdXMLFile, err)
+	}
+	defer rc.Close()
+
+	repomdRoot := new(yummeta.RepoMdRoot)
+	if err := xml.NewDecoder(rc).Decode(repomdRoot); err != nil {
+		return nil, fmt.Errorf("while decoding repository %s %s: %w", repository, repomdXMLFile, err)
+	}
+
+	// the metadata files are timestamped when regenerated
+	for _, data := range repomdRoot.Data {
+		if updated := time.Unix(data.Timestamp, 0); updated.After(stats.updated) {
+			stats.updated = updated
+		}
+	}
+
+	return stats, nil
+}
+
+// countPackages counts the packages of the primary metadata and
+// sums their size.
+func (p *Plugin) countPackages(ctx context.Context, repo name.Repository, digest v1.Hash, stats *repositoryStats) error {
+	rc, err := p.openRepodataLayer(ctx, repo, digest)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	mr, err := newMetadataReader(rc)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	decoder := xml.NewDecoder(mr)
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		pkg := new(primarySize)
+		if err := decoder.DecodeElement(pkg, &start); err != nil {
+			return err
+		}
+
+		stats.packages++
+		stats.bytes += pkg.Size.Package
+	}
+}
+
+// boundStats returns the statistics of the maxRepositories largest
+// repositories followed by the aggregated statistics of the other
+// repositories if any.
+func boundStats(stats []*repositoryStats, maxRepositories int) []*repositoryStats {
+	sorted := append([]*repositoryStats(nil), stats...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].bytes != sorted[j].bytes {
+			return sorted[i].bytes > sorted[j].bytes
+		}
+		return sorted[i].repository < sorted[j].repository
+	})
+
+	if len(sorted) <= maxRepositories {
+		return sorted
+	}
+
+	other := &repositoryStats{repository: otherRepositories}
+	for _, s := range sorted[maxRepositories:] {
+		other.packages += s.packages
+		other.bytes += s.bytes
+		if s.updated.After(other.updated) {
+			other.updated = s.updated
+		}
+	}
+
+	return append(sorted[:maxRepositories], other)
+}
+
+// statsCollector holds the repository statistics of the last collection
+// reported by the repository gauges, the series of the repositories no
+// longer part of the collected statistics disappear instead of being reset.
+type statsCollector struct {
+	mutex sync.Mutex
+	stats []*repositoryStats
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{}
+}
+
+// set replaces the exported statistics.
+func (sc *statsCollector) set(stats []*repositoryStats) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.stats = stats
+}
+
+// observations returns the value of each repository statistics.
+func (sc *statsCollector) observations(value func(s *repositoryStats) float64) metrics.CollectFunc {
+	return func() []metrics.Observation {
+		sc.mutex.Lock()
+		defer sc.mutex.Unlock()
+
+		observations := make([]metrics.Observation, 0, len(sc.stats))
+		for _, s := range sc.stats {
+			observations = append(observations, metrics.Observation{
+				Value:       value(s),
+				LabelValues: []string{s.repository},
+			})
+		}
+		return observations
+	}
+}
+
+// scheduleStatsCollection periodically collects the repository statistics
+// exported by the statistics collector when the collection is enabled. The
+// statistics of a repository are only recomputed once its metadata change.
+func (p *Plugin) scheduleStatsCollection(ctx context.Context) {
+	config := p.beskarYumConfig.Stats
+	if config.Interval == 0 {
+		return
+	}
+
+	cache := make(map[string]*repositoryStats)
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		cache = p.collectStats(ctx, cache)
+
+		stats := make([]*repositoryStats, 0, len(cache))
+		for _, s := range cache {
+			stats = append(stats, s)
+		}
+		repositoryStatsMetrics.set(boundStats(stats, config.MaxRepositories))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectStats returns the statistics of all repositories, the previous
+// statistics of a repository are kept if they can't be recomputed.
+func (p *Plugin) collectStats(ctx context.Context, previous map[string]*repositoryStats) map[string]*repositoryStats {
+	repositories, err := p.listRepositories(ctx)
+	if err != nil {
+		logrus.Errorf("repository statistics: %s", err)
+		return previous
+	}
+
+	stats := make(map[string]*repositoryStats, len(repositories))
+
+	for _, repository := range repositories {
+		s, err := p.repositoryStats(ctx, repository, previous[repository])
+		if err != nil {
+			// repositories without metadata yet are skipped
+			logrus.Debugf("repository statistics: %s", err)
+			if s = previous[repository]; s == nil {
+				continue
+			}
+		}
+		stats[repository] = s
+	}
+
+	return stats
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoundStats(t *testing.T) {
+	now := time.Now()
+
+	stats := []*repositoryStats{
+		{repository: "a", packages: 1, bytes: 100, updated: now.Add(-time.Hour)},
+		{repository: "b", packages: 2, bytes: 300, updated: now.Add(-2 * time.Hour)},
+		{repository: "c", packages: 3, bytes: 200, updated: now},
+		{repository: "d", packages: 4, bytes: 200, updated: now.Add(-3 * time.Hour)},
+	}
+
+	tests := []struct {
+		name            string
+		maxRepositories int
+		want            []repositoryStats
+	}{
+		{
+			name:            "unbounded",
+			maxRepositories: 4,
+			want: []repositoryStats{
+				{repository: "b", packages: 2, bytes: 300, updated: now.Add(-2 * time.Hour)},
+				{repository: "c", packages: 3, bytes: 200, updated: now},
+				{repository: "d", packages: 4, bytes: 200, updated: now.Add(-3 * time.Hour)},
+				{repository: "a", packages: 1, bytes: 100, updated: now.Add(-time.Hour)},
+			},
+		},
+		{
+			name:            "bounded",
+			maxRepositories: 2,
+			want: []repositoryStats{
+				{repository: "b", packages: 2, bytes: 300, updated: now.Add(-2 * time.Hour)},
+				{repository: "c", packages: 3, bytes: 200, updated: now},
+				{repository: otherRepositories, packages: 5, bytes: 300, updated: now.Add(-time.Hour)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bounded := boundStats(stats, tt.maxRepositories)
+
+			got := make([]repositoryStats, 0, len(bounded))
+			for _, s := range bounded {
+				got = append(got, *s)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	// the input order is left untouched
+	require.Equal(t, "a", stats[0].repository)
+}
+
+func TestStatsCollector(t *testing.T) {
+	defer repositoryStatsMetrics.set(nil)
+
+	updated := time.Unix(1700000000, 0)
+
+	repositoryStatsMetrics.set([]*repositoryStats{
+		{repository: "a", packages: 1, bytes: 100, updated: updated},
+		{repository: "b", packages: 2, bytes: 300, updated: updated},
+	})
+
+	err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP beskar_yum_repository_packages The number of packages referenced by the repository metadata
+# TYPE beskar_yum_repository_packages gauge
+beskar_yum_repository_packages{repository="a"} 1
+beskar_yum_repository_packages{repository="b"} 2
+# HELP beskar_yum_repository_size_bytes The total size of the packages referenced by the repository metadata
+# TYPE beskar_yum_repository_size_bytes gauge
+beskar_yum_repository_size_bytes{repository="a"} 100
+beskar_yum_repository_size_bytes{repository="b"} 300
+`), "beskar_yum_repository_packages", "beskar_yum_repository_size_bytes")
+	require.NoError(t, err)
+
+	// the series of the repositories dropped from the statistics are removed
+	repositoryStatsMetrics.set([]*repositoryStats{
+		{repository: "b", packages: 2, bytes: 300, updated: updated},
+	})
+
+	err = testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP beskar_yum_repository_last_updated_seconds The Unix time at which the repository metadata were last regenerated
+# TYPE beskar_yum_repository_last_updated_seconds gauge
+beskar_yum_repository_last_updated_seconds{repository="b"} 1.7e+09
+`), "beskar_yum_repository_last_updated_seconds")
+	require.NoError(t, err)
+}
@@ -0,0 +1,454 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	tokensFile = "tokens.json"
+
+	// tokensCacheTTL bounds how long the tokens of a repository are
+	// cached, tokens created or revoked by another plugin instance
+	// are taken into account once the cached tokens expire.
+	tokensCacheTTL = 30 * time.Second
+
+	TokenPermissionRead  = "read"
+	TokenPermissionWrite = "write"
+)
+
+// repoToken represents a repository access token, only the token
+// hash is stored.
+type repoToken struct {
+	ID         string    `json:"id"`
+	Hash       string    `json:"hash,omitempty"`
+	Permission string    `json:"permission"`
+	Created    time.Time `json:"created"`
+	// Expires is the time after which the token isn't
+	// accepted anymore, tokens without expiry never expire.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (t *repoToken) allows(permission string, now time.Time) bool {
+	if t.Expires != nil && !now.Before(*t.Expires) {
+		return false
+	}
+	return t.Permission == TokenPermissionWrite || t.Permission == permission
+}
+
+// cachedTokens holds the tokens of a repository loaded from the storage.
+type cachedTokens struct {
+	tokens []*repoToken
+	loaded time.Time
+}
+
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// errInvalidRepository is returned for repository names which could
+// address the tokens of another repository in the storage.
+var errInvalidRepository = errors.New("invalid repository name")
+
+// tokensKey returns the object key of the repository tokens, repository
+// names with empty, . or .. path elements are rejected.
+func (p *Plugin) tokensKey(repository string) (string, error) {
+	for _, elem := range strings.Split(repository, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return "", fmt.Errorf("%w %q", errInvalidRepository, repository)
+		}
+	}
+	return p.storageLayout.RepositoryKey(repository, tokensFile), nil
+}
+
+func (p *Plugin) loadTokens(ctx context.Context, repository string) ([]*repoToken, error) {
+	key, err := p.tokensKey(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.bucket.ReadAll(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading %s tokens: %w", repository, err)
+	}
+
+	var tokens []*repoToken
+
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("while decoding %s tokens: %w", repository, err)
+	}
+
+	return tokens, nil
+}
+
+func (p *Plugin) saveTokens(ctx context.Context, repository string, tokens []*repoToken) error {
+	key, err := p.tokensKey(repository)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	err = p.bucket.WriteAll(ctx, key, data, &blob.WriterOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return err
+	}
+	p.tokensCache.Store(repository, cachedTokens{tokens: tokens, loaded: time.Now()})
+	return nil
+}
+
+// repositoryTokens returns the tokens of the repository, tokens are read
+// from the storage once the cached tokens are older than tokensCacheTTL.
+// The returned tokens are shared and must not be modified.
+func (p *Plugin) repositoryTokens(ctx context.Context, repository string) ([]*repoToken, error) {
+	if cached, ok := p.tokensCache.Load(repository); ok {
+		if ct := cached.(cachedTokens); time.Since(ct.loaded) < tokensCacheTTL {
+			return ct.tokens, nil
+		}
+	}
+
+	tokens, err := p.loadTokens(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	p.tokensCache.Store(repository, cachedTokens{tokens: tokens, loaded: time.Now()})
+
+	return tokens, nil
+}
+
+// CreateToken creates a new access token for the repository and
+// returns the token along with its ID, the token can't be retrieved
+// afterward. The token expires after ttl unless ttl is zero.
+func (p *Plugin) CreateToken(ctx context.Context, repository, permission string, ttl time.Duration) (string, string, error) {
+	if permission != TokenPermissionRead && permission != TokenPermissionWrite {
+		return "", "", fmt.Errorf("unknown token permission %q", permission)
+	} else if ttl < 0 {
+		return "", "", fmt.Errorf("token ttl must be positive")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+
+	p.tokensMutex.Lock()
+	defer p.tokensMutex.Unlock()
+
+	tokens, err := p.loadTokens(ctx, repository)
+	if err != nil {
+		return "", "", err
+	}
+
+	rt := &repoToken{
+		ID:         uuid.NewString(),
+		Hash:       hashToken(token),
+		Permission: permission,
+		Created:    time.Now().UTC(),
+	}
+	if ttl > 0 {
+		expires := rt.Created.Add(ttl)
+		rt.Expires = &expires
+	}
+
+	if err := p.saveTokens(ctx, repository, append(tokens, rt)); err != nil {
+		return "", "", err
+	}
+
+	return rt.ID, token, nil
+}
+
+// RevokeToken removes the access token from the repository.
+func (p *Plugin) RevokeToken(ctx context.Context, repository, id string) error {
+	p.tokensMutex.Lock()
+	defer p.tokensMutex.Unlock()
+
+	tokens, err := p.loadTokens(ctx, repository)
+	if err != nil {
+		return err
+	}
+
+	for i, rt := range tokens {
+		if rt.ID == id {
+			remaining := make([]*repoToken, 0, len(tokens)-1)
+			remaining = append(remaining, tokens[:i]...)
+			return p.saveTokens(ctx, repository, append(remaining, tokens[i+1:]...))
+		}
+	}
+
+	return fmt.Errorf("token %s not found", id)
+}
+
+// authorizeAdmin checks that the request is authenticated with the
+// registry credentials.
+func (p *Plugin) authorizeAdmin(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(p.beskarYumConfig.Registry.Username))
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(p.beskarYumConfig.Registry.Password))
+	if usernameMatch&passwordMatch != 1 {
+		return false
+	}
+	setAccessIdentity(r, username)
+	return true
+}
+
+func tokensHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !plugin.authorizeAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		repository := mux.Vars(r)["repository"]
+
+		switch r.Method {
+		case http.MethodGet:
+			tokens, err := plugin.loadTokens(r.Context(), repository)
+			if errors.Is(err, errInvalidRepository) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			for _, rt := range tokens {
+				rt.Hash = ""
+			}
+			if tokens == nil {
+				tokens = []*repoToken{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(tokens)
+		case http.MethodPost:
+			request := struct {
+				Permission string `json:"permission"`
+				// TTL is the token lifetime as a duration string,
+				// eg: 720h, tokens never expire without it.
+				TTL string `json:"ttl"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			var ttl time.Duration
+			if request.TTL != "" {
+				var err error
+				ttl, err = time.ParseDuration(request.TTL)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid token ttl: %s", err), http.StatusBadRequest)
+					return
+				}
+			}
+			id, token, err := plugin.CreateToken(r.Context(), repository, request.Permission, ttl)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"id":         id,
+				"token":      token,
+				"permission": request.Permission,
+			})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func tokenHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !plugin.authorizeAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		vars := mux.Vars(r)
+
+		if err := plugin.RevokeToken(r.Context(), vars["repository"], vars["id"]); errors.Is(err, errInvalidRepository) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authorizeToken returns the repository token granting the permission to
+// the token sent with the request credentials, either as a bearer token or
+// as the basic authentication password. It returns false if the repository
+// isn't protected by any token.
+func (p *Plugin) authorizeToken(ctx context.Context, r *http.Request, repository, permission string) (*repoToken, bool, error) {
+	tokens, err := p.repositoryTokens(ctx, repository)
+	if err != nil {
+		return nil, false, err
+	} else if len(tokens) == 0 {
+		return nil, false, nil
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+
+	hash := hashToken(token)
+	now := time.Now()
+
+	for _, rt := range tokens {
+		if subtle.ConstantTimeCompare([]byte(rt.Hash), []byte(hash)) == 1 && rt.allows(permission, now) {
+			return rt, true, nil
+		}
+	}
+
+	return nil, true, nil
+}
+
+// repoTokenMiddleware enforces repository access tokens on the plugin
+// routes, repositories without any token remain publicly accessible.
+func repoTokenMiddleware(plugin *Plugin, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		permission := TokenPermissionRead
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			permission = TokenPermissionWrite
+		}
+
+		rt, protected, err := plugin.authorizeToken(r.Context(), r, mux.Vars(r)["repository"], permission)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if !protected {
+			next(w, r)
+			return
+		} else if rt != nil {
+			setAccessIdentity(r, "token:"+rt.ID)
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+// writeAccessMiddleware requires the registry credentials or a repository
+// write token even on repositories without any token, the requests push
+// packages with the plugin registry credentials and must not bypass the
+// registry authentication.
+func writeAccessMiddleware(plugin *Plugin, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if plugin.authorizeAdmin(r) {
+			next(w, r)
+			return
+		}
+
+		rt, _, err := plugin.authorizeToken(r.Context(), r, mux.Vars(r)["repository"], TokenPermissionWrite)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if rt != nil {
+			setAccessIdentity(r, "token:"+rt.ID)
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+// registryRepository returns the plugin repository of a registry
+// repository named yum/<repository>/<packages|repodata>.
+func registryRepository(name string) (string, bool) {
+	if !strings.HasPrefix(name, pluginName+"/") {
+		return "", false
+	}
+	repository := path.Dir(strings.TrimPrefix(name, pluginName+"/"))
+	if repository == "." {
+		return "", false
+	}
+	return repository, true
+}
+
+// authorizeHandler is consulted by the registry to authorize the access to
+// the plugin repositories through the registry API, the registry forwards
+// the client credentials along with the registry repository name and the
+// requested action. It responds with:
+//   - 204 when the repository isn't protected by any token
+//   - 200 when a token grants the action, the token identity is returned
+//   - 403 when no token grants the action
+//
+// Only the pull and push actions can be granted by a token.
+func authorizeHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+
+		repository, ok := registryRepository(query.Get("repository"))
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		permission := ""
+		switch query.Get("action") {
+		case "pull":
+			permission = TokenPermissionRead
+		case "push":
+			permission = TokenPermissionWrite
+		}
+
+		rt, protected, err := plugin.authorizeToken(r.Context(), r, repository, permission)
+		switch {
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+		case !protected:
+			w.WriteHeader(http.StatusNoContent)
+		case rt == nil || permission == "":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"identity": "token:" + rt.ID,
+			})
+		}
+	}
+}
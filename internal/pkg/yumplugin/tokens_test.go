@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/storage"
+)
+
+func newTokensTestPlugin(t *testing.T) *Plugin {
+	beskarYumConfig := &config.BeskarYumConfig{
+		Storage: config.BeskarYumStorage{
+			Driver: config.InMemoryStorageDriver,
+		},
+	}
+
+	plugin := &Plugin{
+		storageLayout:   storage.NewLayout("", pluginName),
+		beskarYumConfig: beskarYumConfig,
+	}
+
+	var err error
+
+	plugin.bucket, err = storage.Init(context.Background(), beskarYumConfig)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = plugin.bucket.Close()
+	})
+
+	return plugin
+}
+
+func TestRegistryRepository(t *testing.T) {
+	for name, expected := range map[string]string{
+		"yum/rocky/packages":          "rocky",
+		"yum/rocky/9/baseos/repodata": "rocky/9/baseos",
+		"yum/packages":                "",
+		"iso/rocky/images":            "",
+	} {
+		repository, ok := registryRepository(name)
+		require.Equal(t, expected != "", ok, name)
+		require.Equal(t, expected, repository, name)
+	}
+}
+
+func TestAuthorizeHandler(t *testing.T) {
+	ctx := context.Background()
+	plugin := newTokensTestPlugin(t)
+
+	authorize := func(repository, action, token string) *httptest.ResponseRecorder {
+		query := url.Values{"repository": []string{repository}, "action": []string{action}}
+		req := httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)
+		if token != "" {
+			req.SetBasicAuth("tenant", token)
+		}
+		rec := httptest.NewRecorder()
+		authorizeHandler(plugin)(rec, req)
+		return rec
+	}
+
+	// repositories without token are not restricted
+	require.Equal(t, http.StatusNoContent, authorize("yum/rocky/packages", "pull", "").Code)
+	require.Equal(t, http.StatusNoContent, authorize("iso/rocky/images", "push", "").Code)
+
+	readID, readToken, err := plugin.CreateToken(ctx, "rocky", TokenPermissionRead, 0)
+	require.NoError(t, err)
+	_, writeToken, err := plugin.CreateToken(ctx, "rocky", TokenPermissionWrite, 0)
+	require.NoError(t, err)
+	_, expiredToken, err := plugin.CreateToken(ctx, "rocky", TokenPermissionWrite, time.Nanosecond)
+	require.NoError(t, err)
+
+	rec := authorize("yum/rocky/repodata", "pull", readToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	granted := map[string]string{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&granted))
+	require.Equal(t, "token:"+readID, granted["identity"])
+
+	require.Equal(t, http.StatusForbidden, authorize("yum/rocky/packages", "pull", "").Code)
+	require.Equal(t, http.StatusForbidden, authorize("yum/rocky/packages", "pull", "unknown").Code)
+	require.Equal(t, http.StatusForbidden, authorize("yum/rocky/packages", "push", readToken).Code)
+	require.Equal(t, http.StatusOK, authorize("yum/rocky/packages", "push", writeToken).Code)
+	require.Equal(t, http.StatusOK, authorize("yum/rocky/packages", "pull", writeToken).Code)
+	require.Equal(t, http.StatusForbidden, authorize("yum/rocky/packages", "delete", writeToken).Code)
+	require.Equal(t, http.StatusForbidden, authorize("yum/rocky/packages", "pull", expiredToken).Code)
+
+	// tokens are scoped to their repository
+	require.Equal(t, http.StatusNoContent, authorize("yum/alma/packages", "pull", "").Code)
+
+	// revoked tokens are rejected without waiting for the cache expiry
+	require.NoError(t, plugin.RevokeToken(ctx, "rocky", readID))
+	require.Equal(t, http.StatusForbidden, authorize("yum/rocky/packages", "pull", readToken).Code)
+}
+
+func TestRepositoryTokensCache(t *testing.T) {
+	ctx := context.Background()
+	plugin := newTokensTestPlugin(t)
+
+	_, _, err := plugin.CreateToken(ctx, "rocky", TokenPermissionRead, 0)
+	require.NoError(t, err)
+
+	tokens, err := plugin.repositoryTokens(ctx, "rocky")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+
+	// tokens changed by another instance are only read once expired
+	key, err := plugin.tokensKey("rocky")
+	require.NoError(t, err)
+	require.NoError(t, plugin.bucket.Delete(ctx, key))
+
+	tokens, err = plugin.repositoryTokens(ctx, "rocky")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+
+	plugin.tokensCache.Store("rocky", cachedTokens{tokens: tokens, loaded: time.Now().Add(-tokensCacheTTL)})
+
+	tokens, err = plugin.repositoryTokens(ctx, "rocky")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+}
+
+func TestRepoTokenMiddlewareRedirect(t *testing.T) {
+	ctx := context.Background()
+	plugin := newTokensTestPlugin(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/yum/repo/{repository}/repodata/{digest}-{file}", repoTokenMiddleware(plugin, blobsHandler("repodata")))
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/yum/repo/rocky/repodata/sha256:abc-primary.xml.gz", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusMovedPermanently, get("").Code)
+
+	_, token, err := plugin.CreateToken(ctx, "rocky", TokenPermissionRead, 0)
+	require.NoError(t, err)
+
+	rec := get("")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, "Basic realm=beskar-yum", rec.Header().Get("WWW-Authenticate"))
+
+	// the redirect target is authorized by the registry
+	// with the same token, see authorizeHandler
+	rec = get(token)
+	require.Equal(t, http.StatusMovedPermanently, rec.Code)
+	require.Equal(t, "/v2/yum/rocky/repodata/blobs/sha256:abc", rec.Header().Get("Location"))
+}
+
+func TestCreateTokenInvalid(t *testing.T) {
+	plugin := newTokensTestPlugin(t)
+
+	_, _, err := plugin.CreateToken(context.Background(), "rocky", "admin", 0)
+	require.ErrorContains(t, err, `unknown token permission "admin"`)
+
+	_, _, err = plugin.CreateToken(context.Background(), "rocky", TokenPermissionRead, -time.Second)
+	require.ErrorContains(t, err, "token ttl must be positive")
+
+	// repository names can't address the tokens of another repository
+	for _, repository := range []string{"", "..", "rocky/../alma", "rocky/.", "/rocky", "rocky//9"} {
+		_, _, err = plugin.CreateToken(context.Background(), repository, TokenPermissionRead, 0)
+		require.ErrorIs(t, err, errInvalidRepository, repository)
+	}
+}
+
+func TestWriteAccessMiddleware(t *testing.T) {
+	ctx := context.Background()
+	plugin := newTokensTestPlugin(t)
+	plugin.beskarYumConfig.Registry.Username = "admin"
+	plugin.beskarYumConfig.Registry.Password = "secret"
+
+	router := mux.NewRouter()
+	router.HandleFunc("/yum/api/v1/repo/{repository}/uploads", writeAccessMiddleware(plugin, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	post := func(username, password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/yum/api/v1/repo/rocky/uploads", nil)
+		if password != "" {
+			req.SetBasicAuth(username, password)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// repositories without token are not public for uploads
+	require.Equal(t, http.StatusUnauthorized, post("", ""))
+	require.Equal(t, http.StatusUnauthorized, post("admin", "wrong"))
+	require.Equal(t, http.StatusAccepted, post("admin", "secret"))
+
+	_, readToken, err := plugin.CreateToken(ctx, "rocky", TokenPermissionRead, 0)
+	require.NoError(t, err)
+	_, writeToken, err := plugin.CreateToken(ctx, "rocky", TokenPermissionWrite, 0)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusUnauthorized, post("tenant", readToken))
+	require.Equal(t, http.StatusAccepted, post("tenant", writeToken))
+	require.Equal(t, http.StatusAccepted, post("admin", "secret"))
+}
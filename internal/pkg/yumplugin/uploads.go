@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/storage"
+)
+
+// stagingPattern is the name pattern of the staging directory
+// subdirectories holding the packages being processed.
+const stagingPattern = "package-"
+
+// errTooManyUploads is returned when the upload limit of a
+// repository is reached.
+var errTooManyUploads = errors.New("too many in-flight uploads for the repository")
+
+// uploadLimiter bounds the packages of a repository queued or being
+// processed, a slot is acquired when the package event is received and
+// released once the package has been processed.
+type uploadLimiter struct {
+	max          int
+	queue        bool
+	queueTimeout time.Duration
+
+	mutex sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// newUploadLimiter returns the upload limiter, nil is returned when
+// uploads are not limited.
+func newUploadLimiter(uploads config.BeskarYumUploads) *uploadLimiter {
+	if uploads.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &uploadLimiter{
+		max:          uploads.MaxConcurrent,
+		queue:        uploads.Overflow == config.UploadsOverflowQueue,
+		queueTimeout: uploads.QueueTimeout,
+		slots:        make(map[string]chan struct{}),
+	}
+}
+
+func (ul *uploadLimiter) repositorySlots(repository string) chan struct{} {
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+
+	slots, ok := ul.slots[repository]
+	if !ok {
+		slots = make(chan struct{}, ul.max)
+		ul.slots[repository] = slots
+	}
+	return slots
+}
+
+// acquire acquires an upload slot for the repository, in queue mode it
+// waits for a slot up to the queue timeout. It returns errTooManyUploads
+// if no slot is available.
+func (ul *uploadLimiter) acquire(ctx context.Context, repository string) error {
+	if ul == nil {
+		return nil
+	}
+
+	slots := ul.repositorySlots(repository)
+
+	select {
+	case slots <- struct{}{}:
+		inflightUploadsGauge.WithValues(repository).Inc()
+		return nil
+	default:
+		if !ul.queue {
+			return errTooManyUploads
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ul.queueTimeout)
+	defer cancel()
+
+	select {
+	case slots <- struct{}{}:
+		inflightUploadsGauge.WithValues(repository).Inc()
+		return nil
+	case <-ctx.Done():
+		return errTooManyUploads
+	}
+}
+
+// release releases an upload slot acquired for the repository.
+func (ul *uploadLimiter) release(repository string) {
+	if ul == nil {
+		return
+	}
+
+	select {
+	case <-ul.repositorySlots(repository):
+		inflightUploadsGauge.WithValues(repository).Dec()
+	default:
+	}
+}
+
+// prepareStagingDir creates the staging directory, when removeStale is
+// true it also removes the packages and the storage spill files left by
+// a previous run interrupted while processing them and checks that
+// packages can be staged. Only the server owns the staging directory
+// content, commands sharing it must not remove the server files.
+func prepareStagingDir(dir string, removeStale bool) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	} else if !removeStale {
+		return nil
+	}
+
+	stale, err := filepath.Glob(filepath.Join(dir, stagingPattern+"*"))
+	if err != nil {
+		return err
+	}
+	spilled, err := filepath.Glob(filepath.Join(dir, storage.SpillPattern+"*"))
+	if err != nil {
+		return err
+	}
+	stale = append(stale, spilled...)
+
+	for _, path := range stale {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp(dir, stagingPattern)
+	if err != nil {
+		return err
+	}
+	return os.Remove(tmpDir)
+}
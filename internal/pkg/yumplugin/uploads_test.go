@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/storage"
+	eventv1 "go.ciq.dev/beskar/pkg/api/event/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUploadLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	require.Nil(t, newUploadLimiter(config.BeskarYumUploads{}))
+	require.NoError(t, (*uploadLimiter)(nil).acquire(ctx, "yum/repo"))
+
+	ul := newUploadLimiter(config.BeskarYumUploads{
+		MaxConcurrent: 2,
+		Overflow:      config.UploadsOverflowReject,
+	})
+
+	require.NoError(t, ul.acquire(ctx, "yum/repo"))
+	require.NoError(t, ul.acquire(ctx, "yum/repo"))
+	require.ErrorIs(t, ul.acquire(ctx, "yum/repo"), errTooManyUploads)
+
+	// limits are per repository
+	require.NoError(t, ul.acquire(ctx, "yum/other"))
+
+	ul.release("yum/repo")
+	require.NoError(t, ul.acquire(ctx, "yum/repo"))
+
+	ul = newUploadLimiter(config.BeskarYumUploads{
+		MaxConcurrent: 1,
+		Overflow:      config.UploadsOverflowQueue,
+		QueueTimeout:  50 * time.Millisecond,
+	})
+
+	require.NoError(t, ul.acquire(ctx, "yum/repo"))
+
+	// the queued upload times out
+	require.ErrorIs(t, ul.acquire(ctx, "yum/repo"), errTooManyUploads)
+
+	// the queued upload gets the released slot
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ul.release("yum/repo")
+	}()
+	require.NoError(t, ul.acquire(ctx, "yum/repo"))
+}
+
+func TestEventHandlerUploadLimit(t *testing.T) {
+	uploads := config.BeskarYumUploads{
+		MaxConcurrent: 1,
+		Overflow:      config.UploadsOverflowReject,
+		RetryAfter:    30 * time.Second,
+	}
+
+	p := &Plugin{
+		queued:          make(chan struct{}, 1),
+		pendingKeys:     make(map[pendingKey]string),
+		uploads:         newUploadLimiter(uploads),
+		beskarYumConfig: &config.BeskarYumConfig{Uploads: uploads},
+	}
+
+	event, err := proto.Marshal(&eventv1.ManifestEvent{
+		Repository: "yum/repo/packages",
+		Payload:    []byte(`{"schemaVersion":2,"layers":[]}`),
+	})
+	require.NoError(t, err)
+
+	send := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.eventHandler()(rec, httptest.NewRequest(http.MethodPost, "/event", bytes.NewReader(event)))
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, send().Code)
+	require.Len(t, p.manifests, 1)
+
+	rec := send()
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "30", rec.Header().Get("Retry-After"))
+	require.Len(t, p.manifests, 1)
+
+	// the slot is released once the package is processed
+	p.processPackages(context.Background(), p.manifests)
+	p.manifests = nil
+	require.Equal(t, http.StatusOK, send().Code)
+}
+
+func TestPrepareStagingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "staging")
+
+	require.NoError(t, prepareStagingDir(dir, true))
+
+	// packages of an interrupted run are removed
+	stale, err := os.MkdirTemp(dir, stagingPattern)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(stale, "test.rpm"), []byte("rpm"), 0o600))
+
+	// as well as the storage spill files
+	spilled, err := os.CreateTemp(dir, storage.SpillPattern+"*")
+	require.NoError(t, err)
+	require.NoError(t, spilled.Close())
+
+	other := filepath.Join(dir, "db-test")
+	require.NoError(t, os.Mkdir(other, 0o700))
+
+	// commands don't remove the files of a running server
+	require.NoError(t, prepareStagingDir(dir, false))
+	require.DirExists(t, stale)
+
+	require.NoError(t, prepareStagingDir(dir, true))
+	require.NoDirExists(t, stale)
+	require.NoFileExists(t, spilled.Name())
+	require.DirExists(t, other)
+
+	// the staging directory must be a writable directory
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	require.Error(t, prepareStagingDir(file, true))
+}
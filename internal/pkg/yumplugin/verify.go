@@ -0,0 +1,405 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/gorilla/mux"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
+	"go.ciq.dev/beskar/pkg/oras"
+)
+
+// VerifyEntry describes a package which failed the verification.
+type VerifyEntry struct {
+	Name     string `json:"name"`
+	Href     string `json:"href"`
+	Checksum string `json:"checksum"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VerifyReport is the result of a repository verification.
+type VerifyReport struct {
+	Repository string `json:"repository"`
+	Packages   int    `json:"packages"`
+	// Missing packages are referenced by the repository metadata or
+	// database but are absent from the storage.
+	Missing []*VerifyEntry `json:"missing"`
+	Corrupt []*VerifyEntry `json:"corrupt"`
+	// Unlisted packages are stored but not referenced by the repository
+	// metadata.
+	Unlisted []*VerifyEntry `json:"unlisted"`
+	// Repaired indicates that the repository metadata were regenerated.
+	Repaired bool `json:"repaired"`
+}
+
+// OK returns true if all packages are present and valid and if the
+// repository metadata reference all stored packages.
+func (vr *VerifyReport) OK() bool {
+	return len(vr.Missing) == 0 && len(vr.Corrupt) == 0 && len(vr.Unlisted) == 0
+}
+
+// primaryPackage holds the package fields of primary.xml required
+// for the verification.
+type primaryPackage struct {
+	Name     string `xml:"name"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+}
+
+// VerifyRepository checks that every package referenced by the repository
+// primary metadata is present in the registry and that its content matches
+// the metadata checksum, it also reports packages stored in the repository
+// database which are not referenced by the metadata. The verification is
+// read-only.
+func (p *Plugin) VerifyRepository(ctx context.Context, repository string) (*VerifyReport, error) {
+	report := &VerifyReport{
+		Repository: repository,
+		Missing:    []*VerifyEntry{},
+		Corrupt:    []*VerifyEntry{},
+		Unlisted:   []*VerifyEntry{},
+	}
+
+	referenced := make(map[string]struct{})
+
+	primary, err := p.openPrimaryXML(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer primary.Close()
+
+	decoder := xml.NewDecoder(primary)
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("while decoding %s: %w", primaryXMLFile, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		pkg := new(primaryPackage)
+		if err := decoder.DecodeElement(pkg, &start); err != nil {
+			return nil, fmt.Errorf("while decoding %s package: %w", primaryXMLFile, err)
+		}
+
+		report.Packages++
+
+		if id, ok := packageIDFromHref(pkg.Location.Href); ok {
+			referenced[id] = struct{}{}
+		}
+
+		entry, missing, err := p.verifyPackage(ctx, repository, pkg)
+		if err != nil {
+			return nil, err
+		} else if entry == nil {
+			continue
+		} else if missing {
+			report.Missing = append(report.Missing, entry)
+		} else {
+			report.Corrupt = append(report.Corrupt, entry)
+		}
+	}
+
+	if err := p.verifyUnlistedPackages(ctx, repository, referenced, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// verifyUnlistedPackages walks the repository database and reports the
+// packages which are not referenced by the repository metadata.
+func (p *Plugin) verifyUnlistedPackages(ctx context.Context, repository string, referenced map[string]struct{}, report *VerifyReport) error {
+	dbPath, db, err := p.openRepositoryDatabase(ctx, repository)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	return db.WalkPackages(ctx, func(dbPackage *yumdb.Package) error {
+		if _, ok := referenced[dbPackage.ID]; ok {
+			return nil
+		}
+
+		pkg := new(primaryPackage)
+		pkg.Name = dbPackage.Name
+		pkg.Checksum.Type = "sha256"
+		pkg.Checksum.Value = dbPackage.ID
+		pkg.Location.Href = fmt.Sprintf("packages/sha256:%s/%s", dbPackage.ID, dbPackage.Name)
+
+		entry, missing, err := p.verifyPackage(ctx, repository, pkg)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry == nil:
+			report.Unlisted = append(report.Unlisted, &VerifyEntry{
+				Name:     pkg.Name,
+				Href:     pkg.Location.Href,
+				Checksum: pkg.Checksum.Value,
+				Error:    "package not referenced by repository metadata",
+			})
+		case missing:
+			report.Missing = append(report.Missing, entry)
+		default:
+			report.Corrupt = append(report.Corrupt, entry)
+		}
+
+		return nil
+	})
+}
+
+// RepairRepository verifies the repository and regenerates its metadata
+// to match the storage when discrepancies are found: missing packages are
+// removed from the repository database and unlisted packages are added back
+// to the metadata. Corrupt packages can't be repaired and are only reported.
+// The repository database is locked during the repair so packages processed
+// meanwhile are added once the repair is done.
+func (p *Plugin) RepairRepository(ctx context.Context, repository string) (*VerifyReport, error) {
+	unlock := p.repoLocks.lock(repository)
+	defer unlock()
+
+	report, err := p.VerifyRepository(ctx, repository)
+	if err != nil {
+		return nil, err
+	} else if len(report.Missing) == 0 && len(report.Unlisted) == 0 {
+		return report, nil
+	}
+
+	dbPath, db, err := p.openRepositoryDatabase(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := false
+
+	for _, entry := range report.Missing {
+		id, ok := packageIDFromHref(entry.Href)
+		if !ok {
+			continue
+		}
+		deleted, err := db.RemovePackage(ctx, id)
+		if err != nil {
+			_ = db.Close()
+			_ = os.RemoveAll(dbPath)
+			return nil, fmt.Errorf("while removing package %s from database: %w", entry.Name, err)
+		}
+		removed = removed || deleted
+	}
+
+	if err := db.Close(); err != nil {
+		_ = os.RemoveAll(dbPath)
+		return nil, fmt.Errorf("while closing dolt database: %w", err)
+	}
+
+	if removed {
+		if err := p.pushRepositoryDatabase(ctx, repository, dbPath); err != nil {
+			_ = os.RemoveAll(dbPath)
+			return nil, err
+		}
+	}
+
+	// the database directory is removed by the metadata generation
+	if err := p.GenerateAndSaveMetadata(ctx, filepath.Join(pluginName, repository), dbPath, true); err != nil {
+		return nil, err
+	}
+
+	report.Repaired = true
+
+	return report, nil
+}
+
+// packageIDFromHref returns the package identifier from a package location
+// which is in the form packages/sha256:<digest>/<file>.
+func packageIDFromHref(href string) (string, bool) {
+	parts := strings.Split(href, "/")
+	if len(parts) != 3 || parts[0] != "packages" || !strings.HasPrefix(parts[1], "sha256:") {
+		return "", false
+	}
+	return strings.TrimPrefix(parts[1], "sha256:"), true
+}
+
+func (p *Plugin) openPrimaryXML(ctx context.Context, repository string) (io.ReadCloser, error) {
+	ref, err := name.ParseReference(filepath.Join(p.registry, pluginName, repository, "repodata:latest"), p.nameOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest *v1.Manifest
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) (err error) {
+		manifest, err = oras.GetManifest(ref, options...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while getting repository %s metadata: %w", repository, err)
+	}
+
+	for _, layer := range manifest.Layers {
+		if !isPrimaryXMLLayer(string(layer.MediaType)) {
+			continue
+		}
+
+		rc, err := p.openRepodataLayer(ctx, ref.Context(), layer.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("while fetching %s: %w", primaryXMLFile, err)
+		}
+
+		mr, err := newMetadataReader(rc)
+		if err != nil {
+			_ = rc.Close()
+			return nil, fmt.Errorf("while decompressing %s: %w", primaryXMLFile, err)
+		}
+
+		return mr, nil
+	}
+
+	return nil, fmt.Errorf("no %s found in repository %s metadata", primaryXMLFile, repository)
+}
+
+// openRepodataLayer returns the content of a repository metadata layer
+// as stored in the registry.
+func (p *Plugin) openRepodataLayer(ctx context.Context, repo name.Repository, digest v1.Hash) (rc io.ReadCloser, err error) {
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+		l, err := remote.Layer(repo.Digest(digest.String()), options...)
+		if err != nil {
+			return err
+		}
+		rc, err = l.Compressed()
+		return err
+	})
+	return rc, err
+}
+
+// verifyPackage returns an entry describing the package failure if any,
+// along with a boolean indicating if the package is missing.
+func (p *Plugin) verifyPackage(ctx context.Context, repository string, pkg *primaryPackage) (*VerifyEntry, bool, error) {
+	entry := &VerifyEntry{
+		Name:     pkg.Name,
+		Href:     pkg.Location.Href,
+		Checksum: pkg.Checksum.Value,
+	}
+
+	id, ok := packageIDFromHref(pkg.Location.Href)
+	if !ok {
+		entry.Error = "unexpected package location"
+		return entry, false, nil
+	} else if pkg.Checksum.Type != "sha256" {
+		entry.Error = fmt.Sprintf("unsupported checksum type %q", pkg.Checksum.Type)
+		return entry, false, nil
+	}
+
+	ref := filepath.Join(p.registry, pluginName, repository, "packages") + "@sha256:" + id
+	digest, err := name.NewDigest(ref, p.nameOptions...)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry, false, nil
+	}
+
+	var checksum string
+
+	err = p.withRegistryRetry(ctx, func(options ...remote.Option) error {
+		layer, err := remote.Layer(digest, options...)
+		if err != nil {
+			return err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		h := sha256.New()
+		// the layer reader verifies the content against the digest
+		if _, err := io.Copy(h, rc); err != nil {
+			return err
+		}
+		checksum = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+
+	var terr *transport.Error
+
+	switch {
+	case errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound:
+		entry.Error = "package not found"
+		return entry, true, nil
+	case errors.Is(err, errRegistryUnavailable):
+		return nil, false, err
+	case err != nil:
+		entry.Error = err.Error()
+		return entry, false, nil
+	case checksum != pkg.Checksum.Value:
+		entry.Error = fmt.Sprintf("checksum mismatch, got %s", checksum)
+		return entry, false, nil
+	}
+
+	return nil, false, nil
+}
+
+func verifyHandler(plugin *Plugin) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !plugin.authorizeAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Basic realm=beskar-yum")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var (
+			report *VerifyReport
+			err    error
+		)
+
+		repository := mux.Vars(r)["repository"]
+
+		// the verification is read-only unless the repair is explicitly requested
+		switch {
+		case r.Method == http.MethodGet:
+			report, err = plugin.VerifyRepository(r.Context(), repository)
+		case r.Method == http.MethodPost && r.URL.Query().Get("repair") == "true":
+			report, err = plugin.RepairRepository(r.Context(), repository)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if errors.Is(err, errRegistryUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
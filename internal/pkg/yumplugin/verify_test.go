@@ -0,0 +1,267 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package yumplugin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/storage"
+)
+
+// verifyTestRepository pushes repository packages, metadata and
+// databases to a fake registry and to an in-memory storage.
+type verifyTestRepository struct {
+	t      *testing.T
+	plugin *Plugin
+	host   string
+}
+
+func newVerifyTestRepository(t *testing.T) *verifyTestRepository {
+	ctx := context.Background()
+
+	dataDir := t.TempDir()
+	t.Setenv("HOME", dataDir)
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	beskarYumConfig := &config.BeskarYumConfig{
+		DataDir: dataDir,
+		Storage: config.BeskarYumStorage{
+			Driver: config.InMemoryStorageDriver,
+		},
+	}
+
+	plugin := &Plugin{
+		registry:        registryURL.Host,
+		dataDir:         dataDir,
+		nameOptions:     []name.Option{name.Insecure},
+		storageLayout:   storage.NewLayout("", pluginName),
+		beskarYumConfig: beskarYumConfig,
+	}
+
+	plugin.bucket, err = storage.Init(ctx, beskarYumConfig)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = plugin.bucket.Close() })
+
+	return &verifyTestRepository{
+		t:      t,
+		plugin: plugin,
+		host:   registryURL.Host,
+	}
+}
+
+// pushPackage pushes the package content and returns its identifier.
+func (vr *verifyTestRepository) pushPackage(repository, filename string) string {
+	repo, err := name.NewRepository(vr.host+"/yum/"+repository+"/packages", name.Insecure)
+	require.NoError(vr.t, err)
+
+	layer := static.NewLayer([]byte(filename), orasrpm.RPMPackageLayerType)
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: layer})
+	require.NoError(vr.t, err)
+	require.NoError(vr.t, remote.Write(repo.Tag(strings.TrimSuffix(filename, ".rpm")), img))
+
+	digest, err := layer.Digest()
+	require.NoError(vr.t, err)
+
+	return digest.Hex
+}
+
+// pushPrimary pushes the repository metadata referencing the packages,
+// packages are given as filename and checksum pairs keyed by identifier.
+func (vr *verifyTestRepository) pushPrimary(repository string, packages map[string][2]string) {
+	primary := new(strings.Builder)
+	primary.WriteString("<metadata>")
+	for id, pkg := range packages {
+		fmt.Fprintf(
+			primary,
+			`<package><name>%s</name><checksum type="sha256">%s</checksum><location href="packages/sha256:%s/%s"/></package>`,
+			strings.TrimSuffix(pkg[0], ".rpm"), pkg[1], id, pkg[0],
+		)
+	}
+	primary.WriteString("</metadata>")
+
+	repo, err := name.NewRepository(vr.host+"/yum/"+repository+"/repodata", name.Insecure)
+	require.NoError(vr.t, err)
+
+	compressed := new(bytes.Buffer)
+	gzw := gzip.NewWriter(compressed)
+	_, err = gzw.Write([]byte(primary.String()))
+	require.NoError(vr.t, err)
+	require.NoError(vr.t, gzw.Close())
+
+	layer := static.NewLayer(compressed.Bytes(), types.MediaType(orasrpm.PrimaryXMLLayerType))
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: layer})
+	require.NoError(vr.t, err)
+	require.NoError(vr.t, remote.Write(repo.Tag("latest"), img))
+}
+
+// pushDatabase pushes the repository database holding the packages
+// keyed by identifier.
+func (vr *verifyTestRepository) pushDatabase(repository string, packages map[string]string) {
+	ctx := context.Background()
+
+	dbPath, db, err := vr.plugin.openRepositoryDatabase(ctx, repository)
+	require.NoError(vr.t, err)
+
+	metaFile := filepath.Join(vr.plugin.dataDir, "meta.xml")
+	require.NoError(vr.t, os.WriteFile(metaFile, []byte("<package/>"), 0o600))
+	for id, filename := range packages {
+		require.NoError(vr.t, db.AddPackage(ctx, id, filename, metaFile, metaFile, metaFile, ""))
+	}
+	require.NoError(vr.t, db.Close())
+	require.NoError(vr.t, vr.plugin.pushRepositoryDatabase(ctx, repository, dbPath))
+	require.NoError(vr.t, os.RemoveAll(dbPath))
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyRepository(t *testing.T) {
+	ctx := context.Background()
+
+	vr := newVerifyTestRepository(t)
+
+	t.Run("clean", func(t *testing.T) {
+		goodID := vr.pushPackage("clean", "good-1.0-1.x86_64.rpm")
+		otherID := vr.pushPackage("clean", "other-1.0-1.x86_64.rpm")
+
+		vr.pushPrimary("clean", map[string][2]string{
+			goodID:  {"good-1.0-1.x86_64.rpm", goodID},
+			otherID: {"other-1.0-1.x86_64.rpm", otherID},
+		})
+		vr.pushDatabase("clean", map[string]string{
+			goodID:  "good-1.0-1.x86_64.rpm",
+			otherID: "other-1.0-1.x86_64.rpm",
+		})
+
+		report, err := vr.plugin.VerifyRepository(ctx, "clean")
+		require.NoError(t, err)
+		require.True(t, report.OK())
+		require.Equal(t, 2, report.Packages)
+		require.False(t, report.Repaired)
+	})
+
+	t.Run("missing and corrupt", func(t *testing.T) {
+		goodID := vr.pushPackage("broken", "good-1.0-1.x86_64.rpm")
+		corruptID := vr.pushPackage("broken", "corrupt-1.0-1.x86_64.rpm")
+		missingID := checksum("missing-1.0-1.x86_64.rpm")
+
+		vr.pushPrimary("broken", map[string][2]string{
+			goodID: {"good-1.0-1.x86_64.rpm", goodID},
+			// the metadata checksum doesn't match the stored content
+			corruptID: {"corrupt-1.0-1.x86_64.rpm", checksum("other content")},
+			missingID: {"missing-1.0-1.x86_64.rpm", missingID},
+		})
+		vr.pushDatabase("broken", map[string]string{
+			goodID:    "good-1.0-1.x86_64.rpm",
+			corruptID: "corrupt-1.0-1.x86_64.rpm",
+			missingID: "missing-1.0-1.x86_64.rpm",
+		})
+
+		report, err := vr.plugin.VerifyRepository(ctx, "broken")
+		require.NoError(t, err)
+		require.False(t, report.OK())
+		require.Equal(t, 3, report.Packages)
+		require.Empty(t, report.Unlisted)
+
+		require.Len(t, report.Missing, 1)
+		require.Equal(t, "missing-1.0-1.x86_64", report.Missing[0].Name)
+		require.Equal(t, "package not found", report.Missing[0].Error)
+
+		require.Len(t, report.Corrupt, 1)
+		require.Equal(t, "corrupt-1.0-1.x86_64", report.Corrupt[0].Name)
+		require.Equal(t, "checksum mismatch, got "+corruptID, report.Corrupt[0].Error)
+	})
+
+	t.Run("unlisted", func(t *testing.T) {
+		goodID := vr.pushPackage("unlisted", "good-1.0-1.x86_64.rpm")
+		unlistedID := vr.pushPackage("unlisted", "unlisted-1.0-1.x86_64.rpm")
+
+		vr.pushPrimary("unlisted", map[string][2]string{
+			goodID: {"good-1.0-1.x86_64.rpm", goodID},
+		})
+		vr.pushDatabase("unlisted", map[string]string{
+			goodID:     "good-1.0-1.x86_64.rpm",
+			unlistedID: "unlisted-1.0-1.x86_64.rpm",
+		})
+
+		report, err := vr.plugin.VerifyRepository(ctx, "unlisted")
+		require.NoError(t, err)
+		require.False(t, report.OK())
+		require.Empty(t, report.Missing)
+		require.Empty(t, report.Corrupt)
+		require.Len(t, report.Unlisted, 1)
+		require.Equal(t, fmt.Sprintf("packages/sha256:%s/unlisted-1.0-1.x86_64.rpm", unlistedID), report.Unlisted[0].Href)
+	})
+}
+
+func TestRepairRepositoryLock(t *testing.T) {
+	ctx := context.Background()
+
+	vr := newVerifyTestRepository(t)
+
+	goodID := vr.pushPackage("locked", "good-1.0-1.x86_64.rpm")
+	vr.pushPrimary("locked", map[string][2]string{
+		goodID: {"good-1.0-1.x86_64.rpm", goodID},
+	})
+	vr.pushDatabase("locked", map[string]string{
+		goodID: "good-1.0-1.x86_64.rpm",
+	})
+
+	// the repository database is updated by processed packages
+	unlock := vr.plugin.repoLocks.lock("locked")
+
+	done := make(chan *VerifyReport)
+	go func() {
+		report, err := vr.plugin.RepairRepository(ctx, "locked")
+		require.NoError(t, err)
+		done <- report
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("repair didn't wait for the repository database lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// other repositories aren't locked
+	vr.plugin.repoLocks.lock("other")()
+
+	unlock()
+
+	report := <-done
+	require.True(t, report.OK())
+
+	require.Empty(t, vr.plugin.repoLocks.locks)
+}
@@ -5,35 +5,86 @@ package yumplugin
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 	"go.ciq.dev/beskar/internal/pkg/config"
+	"go.ciq.dev/beskar/internal/pkg/metrics"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/orasrpm"
 	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/storage"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumdb"
+	"go.ciq.dev/beskar/internal/pkg/yumplugin/pkg/yumsign"
 	"go.ciq.dev/beskar/pkg/oras"
-	"gocloud.dev/blob"
 )
 
+const (
+	pluginName = "yum"
+
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotencyKeyAnnotation = "idempotency-key"
+)
+
+type pendingKey struct {
+	repository string
+	key        string
+}
+
 type Plugin struct {
 	registry        string
 	dataDir         string
+	stagingDir      string
 	manifests       []*v1.Manifest
 	manifestMutex   sync.Mutex
 	queued          chan struct{}
 	server          http.Server
 	remoteOptions   []remote.Option
 	nameOptions     []name.Option
-	bucket          *blob.Bucket
+	bucket          *storage.Bucket
+	storageLayout   *storage.Layout
+	signer          *yumsign.Signer
 	beskarYumConfig *config.BeskarYumConfig
+
+	pendingKeysMutex sync.Mutex
+	pendingKeys      map[pendingKey]string
+
+	// repoLocks serializes the repository database updates of
+	// the processed packages, repairs and restores.
+	repoLocks repositoryLocks
+	// chunkedLocks serializes the requests of a resumable upload, the
+	// lock is local to the plugin instance and the requests of an upload
+	// must be routed to the same instance.
+	chunkedLocks repositoryLocks
+
+	uploads  *uploadLimiter
+	inflight *inflightPackages
+
+	tokensMutex sync.Mutex
+	// tokensCache maps repositories to their cachedTokens.
+	tokensCache sync.Map
+
+	// lastGoodRepodata maps repositories to their last fetched
+	// metadata manifest.
+	lastGoodRepodata sync.Map
+
+	// shutdownMetrics pushes the metrics not exported yet,
+	// it's nil unless the plugin exports the metrics itself.
+	shutdownMetrics func(context.Context) error
+
+	// accessLogger is nil unless the access log is enabled.
+	accessLogger *accessLogger
 }
 
 func New(ctx context.Context, beskarYumConfig *config.BeskarYumConfig, server bool) (*Plugin, error) {
@@ -44,12 +95,31 @@ func New(ctx context.Context, beskarYumConfig *config.BeskarYumConfig, server bo
 
 	os.Setenv("HOME", beskarYumConfig.DataDir)
 
+	var shutdownMetrics func(context.Context) error
+
+	if server && beskarYumConfig.Metrics.Backend == config.MetricsBackendOpenTelemetry {
+		// metrics are also exposed by the metrics endpoint
+		meterProvider, err := metrics.NewOTLPMeterProvider(ctx, beskarYumConfig.Metrics.Exporter, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := metrics.SetBackend(metrics.NewOpenTelemetryBackend(meterProvider)); err != nil {
+			return nil, err
+		}
+		shutdownMetrics = meterProvider.Shutdown
+	}
+
 	plugin := &Plugin{
 		registry:        registryURL.Host,
 		manifests:       make([]*v1.Manifest, 0, 32),
 		queued:          make(chan struct{}, 1),
+		pendingKeys:     make(map[pendingKey]string),
+		uploads:         newUploadLimiter(beskarYumConfig.Uploads),
+		inflight:        newInflightPackages(),
 		dataDir:         beskarYumConfig.DataDir,
+		stagingDir:      beskarYumConfig.StagingDir,
 		beskarYumConfig: beskarYumConfig,
+		shutdownMetrics: shutdownMetrics,
 		remoteOptions: []remote.Option{
 			oras.AuthConfig(beskarYumConfig.Registry.Username, beskarYumConfig.Registry.Password),
 		},
@@ -63,32 +133,75 @@ func New(ctx context.Context, beskarYumConfig *config.BeskarYumConfig, server bo
 	if err != nil {
 		return nil, err
 	}
+	logrus.Infof("Storage opened with parameters: %v", beskarYumConfig.Storage.DriverParameters())
+	plugin.storageLayout = storage.NewLayout(beskarYumConfig.Storage.Prefix, pluginName)
 
-	if beskarYumConfig.DataDir == "" {
-		beskarYumConfig.DataDir = config.DefaultBeskarYumDataDir
+	if beskarYumConfig.GPG.Enabled() {
+		plugin.signer, err = loadSigner(beskarYumConfig.GPG)
+		if err != nil {
+			return nil, fmt.Errorf("while loading GPG signing key: %w", err)
+		}
 	}
 
 	if err := os.MkdirAll(plugin.dataDir, 0o700); err != nil {
 		return nil, err
 	}
+	// storage uploads are spilled to the staging directory
+	if err := prepareStagingDir(plugin.stagingDir, server); err != nil {
+		return nil, fmt.Errorf("while preparing staging directory %s: %w", plugin.stagingDir, err)
+	}
 
 	if server {
 		router := mux.NewRouter()
 		router.HandleFunc("/event", plugin.eventHandler())
-		router.HandleFunc("/yum/repo/{repository}/repodata/repomd.xml", repomdHandler(plugin))
-		router.HandleFunc("/yum/repo/{repository}/repodata/{digest}-{file}", blobsHandler("repodata"))
-		router.HandleFunc("/yum/repo/{repository}/packages/{digest}/{file}", blobsHandler("packages"))
+		router.HandleFunc("/authorize", authorizeHandler(plugin))
+		// route names are the repository operations reported by the access log
+		router.HandleFunc("/yum/repo/{repository}/repodata/repomd.xml", repoTokenMiddleware(plugin, repomdHandler(plugin, orasrpm.RepomdXMLLayerType))).Name("repomd")
+		router.HandleFunc("/yum/repo/{repository}/repodata/repomd.xml.asc", repoTokenMiddleware(plugin, repomdHandler(plugin, orasrpm.RepomdASCLayerType))).Name("repomd-signature")
+		router.HandleFunc("/yum/repo/{repository}/repodata/repomd.xml.key", repoTokenMiddleware(plugin, repomdKeyHandler(plugin))).Name("repomd-key")
+		router.HandleFunc("/yum/repo/{repository}/repodata/{digest}-{file}", repoTokenMiddleware(plugin, blobsHandler("repodata"))).Name("repodata")
+		router.HandleFunc("/yum/repo/{repository}/packages/{digest}/{file}", repoTokenMiddleware(plugin, blobsHandler("packages"))).Name("package")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/tokens", tokensHandler(plugin)).Name("tokens")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/tokens/{id}", tokenHandler(plugin)).Name("token")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/verify", verifyHandler(plugin)).Name("verify")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/gc", gcHandler(plugin)).Name("gc")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/snapshot", snapshotHandler(plugin)).Name("snapshot")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/restore", restoreHandler(plugin)).Name("restore")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/uploads", writeAccessMiddleware(plugin, chunkedUploadsHandler(plugin))).Name("uploads")
+		router.HandleFunc("/yum/api/v1/repo/{repository}/uploads/{id}", writeAccessMiddleware(plugin, chunkedUploadHandler(plugin))).Name("upload")
+		router.HandleFunc("/yum/api/v1/gc", gcHandler(plugin)).Name("gc-all")
+		router.Handle("/metrics", metrics.Handler())
+
+		if beskarYumConfig.AccessLog.Enabled {
+			accessLogger, err := newAccessLogger(beskarYumConfig.AccessLog)
+			if err != nil {
+				return nil, err
+			}
+			router.Use(accessLogger.middleware)
+			plugin.accessLogger = accessLogger
+		}
 
 		if beskarYumConfig.Profiling {
 			plugin.setProfiling(router)
 		}
 
 		plugin.server = http.Server{
-			Handler:           router,
-			ReadHeaderTimeout: 5 * time.Second,
+			Handler: router,
 		}
+		beskarYumConfig.Server.Timeouts.Apply(&plugin.server)
 
-		go plugin.dequeue(ctx)
+		plugin.bucket.StartSweeper(ctx, beskarYumConfig.Storage)
+
+		go plugin.scheduleGarbageCollection(ctx)
+		go plugin.scheduleChunkedUploadsCollection(ctx)
+		go plugin.scheduleStatsCollection(ctx)
+
+		go func() {
+			// queued events are processed once bootstrapped
+			// repositories exist
+			plugin.bootstrapRepositories(ctx)
+			plugin.dequeue(ctx)
+		}()
 	}
 
 	return plugin, nil
@@ -107,6 +220,25 @@ func (p *Plugin) Serve(ln net.Listener) error {
 	return p.server.Serve(ln)
 }
 
+// Shutdown releases the plugin resources once the plugin
+// stopped serving requests.
+func (p *Plugin) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	if p.shutdownMetrics != nil {
+		if err := p.shutdownMetrics(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("while shutting down metrics: %w", err))
+		}
+	}
+	if p.accessLogger != nil {
+		if err := p.accessLogger.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("while closing access log: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 func (p *Plugin) dequeue(ctx context.Context) {
 	for {
 		select {
@@ -143,3 +275,64 @@ func (p *Plugin) enqueue(manifest *v1.Manifest) {
 	p.manifestMutex.Unlock()
 	p.enqueueNotify()
 }
+
+// reserveIdempotencyKey reserves the idempotency key for a package while
+// it's processed. It returns false if the same package is already being
+// processed or was already added with this key and an error if the key is
+// in use for another package, either in flight or in the repository database.
+func (p *Plugin) reserveIdempotencyKey(ctx context.Context, repository, key, packageID string) (bool, error) {
+	pk := pendingKey{repository: repository, key: key}
+
+	p.pendingKeysMutex.Lock()
+	if id, ok := p.pendingKeys[pk]; ok {
+		p.pendingKeysMutex.Unlock()
+		if id != packageID {
+			return false, yumdb.ErrIdempotencyKeyConflict
+		}
+		return false, nil
+	}
+	p.pendingKeys[pk] = packageID
+	p.pendingKeysMutex.Unlock()
+
+	// the key is persisted once its package is processed which can't
+	// happen for another package while the key is reserved
+	persistedID, err := p.persistedIdempotencyKey(ctx, repository, key)
+	switch {
+	case err != nil:
+		p.releaseIdempotencyKey(repository, key)
+		return false, err
+	case persistedID == packageID:
+		p.releaseIdempotencyKey(repository, key)
+		return false, nil
+	case persistedID != "":
+		p.releaseIdempotencyKey(repository, key)
+		return false, yumdb.ErrIdempotencyKeyConflict
+	}
+
+	return true, nil
+}
+
+// persistedIdempotencyKey returns the ID of the package recorded with the
+// idempotency key in the repository database.
+func (p *Plugin) persistedIdempotencyKey(ctx context.Context, repository, key string) (string, error) {
+	// repository is yum/<repo>/packages
+	repoName := strings.TrimPrefix(filepath.Dir(repository), pluginName+"/")
+
+	dbPath, db, err := p.openRepositoryDatabase(ctx, repoName)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	return db.PackageIDFromIdempotencyKey(ctx, key)
+}
+
+func (p *Plugin) releaseIdempotencyKey(repository, key string) {
+	if key == "" {
+		return
+	}
+	p.pendingKeysMutex.Lock()
+	delete(p.pendingKeys, pendingKey{repository: repository, key: key})
+	p.pendingKeysMutex.Unlock()
+}
@@ -45,16 +45,23 @@ func loadCerts(caCert, cert, key io.Reader) (*x509.CertPool, []tls.Certificate,
 // ServerConfig returns a mTLS configuration for a server with
 // the provided certificate and key.
 func ServerConfig(caCert, cert, key io.Reader) (*tls.Config, error) {
+	return serverConfig(caCert, cert, key, DefaultClockSkew)
+}
+
+func serverConfig(caCert, cert, key io.Reader, clockSkew time.Duration) (*tls.Config, error) {
 	caCertPool, certs, err := loadCerts(caCert, cert, key)
 	if err != nil {
 		return nil, err
 	}
 
+	// client certificates are verified by VerifyConnection to
+	// apply the clock skew tolerance
 	tlsConfig := &tls.Config{
-		ClientCAs:    caCertPool,
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		Certificates: certs,
-		MinVersion:   tls.VersionTLS13,
+		ClientCAs:        caCertPool,
+		ClientAuth:       tls.RequireAnyClientCert,
+		Certificates:     certs,
+		MinVersion:       tls.VersionTLS13,
+		VerifyConnection: verifyPeerCertificates(caCertPool, clockSkew, x509.ExtKeyUsageClientAuth, false),
 	}
 
 	return tlsConfig, nil
@@ -63,21 +70,30 @@ func ServerConfig(caCert, cert, key io.Reader) (*tls.Config, error) {
 // ClientConfig returns a mTLS configuration for a client with
 // the provided certificate and key.
 func ClientConfig(caCert, cert, key io.Reader) (*tls.Config, error) {
+	return clientConfig(caCert, cert, key, DefaultClockSkew)
+}
+
+func clientConfig(caCert, cert, key io.Reader, clockSkew time.Duration) (*tls.Config, error) {
 	caCertPool, certs, err := loadCerts(caCert, cert, key)
 	if err != nil {
 		return nil, err
 	}
 
+	// server certificates are verified by VerifyConnection to
+	// apply the clock skew tolerance
 	tlsConfig := &tls.Config{
 		RootCAs:      caCertPool,
 		Certificates: certs,
 		MinVersion:   tls.VersionTLS13,
+		//nolint:gosec // verification is done by VerifyConnection
+		InsecureSkipVerify: true,
+		VerifyConnection:   verifyPeerCertificates(caCertPool, clockSkew, x509.ExtKeyUsageServerAuth, true),
 	}
 
 	return tlsConfig, nil
 }
 
-func generateConfig(caCertReader, caKeyReader io.ReadSeeker, validity time.Time, serverConfig bool, certOpts ...CertRequestOption) (*tls.Config, error) {
+func generateConfig(caCertReader, caKeyReader io.ReadSeeker, validity time.Time, isServer bool, certOpts ...CertRequestOption) (*tls.Config, error) {
 	ca, err := LoadCACertificate(caCertReader, caKeyReader)
 	if err != nil {
 		return nil, fmt.Errorf("while loading CA certificate and key: %w", err)
@@ -103,8 +119,9 @@ func generateConfig(caCertReader, caKeyReader io.ReadSeeker, validity time.Time,
 		DNS: []string{
 			"localhost",
 		},
-		CA:     &ca,
-		KeyAlg: keyAlg,
+		CA:        &ca,
+		KeyAlg:    keyAlg,
+		ClockSkew: DefaultClockSkew,
 	}
 
 	for _, certOpt := range certOpts {
@@ -118,11 +135,11 @@ func generateConfig(caCertReader, caKeyReader io.ReadSeeker, validity time.Time,
 		return nil, err
 	}
 
-	if serverConfig {
-		return ServerConfig(caCertReader, bytes.NewReader(cert), bytes.NewReader(key))
+	if isServer {
+		return serverConfig(caCertReader, bytes.NewReader(cert), bytes.NewReader(key), cfg.ClockSkew)
 	}
 
-	return ClientConfig(caCertReader, bytes.NewReader(cert), bytes.NewReader(key))
+	return clientConfig(caCertReader, bytes.NewReader(cert), bytes.NewReader(key), cfg.ClockSkew)
 }
 
 // CertRequestOption represents a certificate request option.
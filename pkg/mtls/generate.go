@@ -34,15 +34,21 @@ type CertRequestConfig struct {
 	DNS      []string
 	CA       *tls.Certificate
 	KeyAlg   KeyAlg
+	// ClockSkew backdates the certificate NotBefore.
+	ClockSkew time.Duration
 }
 
 // GenerateCA generates a CA certificate pair for a validity period
 // with the corresponding key algorithm (RSA or ECDSA).
-func GenerateCA(cn string, validity time.Time, keyAlg KeyAlg) ([]byte, []byte, error) {
+func GenerateCA(cn string, validity time.Time, keyAlg KeyAlg, certOpts ...CertRequestOption) ([]byte, []byte, error) {
 	cfg := CertRequestConfig{
-		CN:       cn,
-		Validity: validity,
-		KeyAlg:   keyAlg,
+		CN:        cn,
+		Validity:  validity,
+		KeyAlg:    keyAlg,
+		ClockSkew: DefaultClockSkew,
+	}
+	for _, certOpt := range certOpts {
+		certOpt(&cfg)
 	}
 	return generateKeyPair(&cfg)
 }
@@ -131,7 +137,7 @@ func generateKeyPair(cfg *CertRequestConfig) ([]byte, []byte, error) {
 		IPAddresses:           cfg.IP,
 		DNSNames:              cfg.DNS,
 		IsCA:                  isCA,
-		NotBefore:             time.Now(),
+		NotBefore:             time.Now().Add(-cfg.ClockSkew),
 		NotAfter:              cfg.Validity,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
 		KeyUsage:              keyUsage,
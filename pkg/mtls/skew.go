@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrCertificateNotYetValid is returned when a certificate
+	// NotBefore is in the future, even with the clock skew tolerance.
+	ErrCertificateNotYetValid = errors.New("certificate is not yet valid")
+	// ErrCertificateExpired is returned when a certificate NotAfter
+	// is in the past, even with the clock skew tolerance.
+	ErrCertificateExpired = errors.New("certificate has expired")
+)
+
+// DefaultClockSkew is the default tolerance applied to certificate
+// validity periods to cope with nodes having imperfect time sync.
+const DefaultClockSkew = time.Minute
+
+// verifyPeerCertificates returns a connection verification function checking
+// the peer certificate chain against the root pool, the validity period is
+// checked at the current time and, if it fails, at the current time shifted
+// by the clock skew in both directions.
+func verifyPeerCertificates(roots *x509.CertPool, clockSkew time.Duration, keyUsage x509.ExtKeyUsage, verifyName bool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("no peer certificate provided")
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{keyUsage},
+		}
+		if verifyName {
+			opts.DNSName = cs.ServerName
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+
+		now := time.Now()
+		verifyTimes := []time.Time{now}
+		if clockSkew > 0 {
+			verifyTimes = append(verifyTimes, now.Add(clockSkew), now.Add(-clockSkew))
+		}
+
+		var firstErr error
+
+		for _, verifyTime := range verifyTimes {
+			opts.CurrentTime = verifyTime
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			if err == nil {
+				return nil
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		return firstErr
+	}
+}
+
+// CheckValidity checks that the PEM encoded certificate is valid at the
+// given time, the validity period is extended by the clock skew on both
+// ends as the certificate may have been issued by a node whose clock
+// is ahead or behind.
+func CheckValidity(certPEM []byte, now time.Time, clockSkew time.Duration) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return fmt.Errorf("no PEM certificate found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("while parsing certificate: %w", err)
+	}
+
+	if clockSkew < 0 {
+		clockSkew = 0
+	}
+
+	if notBefore := cert.NotBefore.Add(-clockSkew); now.Before(notBefore) {
+		return fmt.Errorf(
+			"%w: valid from %s, %s ahead of the local clock with a clock skew allowance of %s",
+			ErrCertificateNotYetValid, cert.NotBefore.UTC().Format(time.RFC3339), cert.NotBefore.Sub(now).Round(time.Second), clockSkew,
+		)
+	} else if notAfter := cert.NotAfter.Add(clockSkew); now.After(notAfter) {
+		return fmt.Errorf(
+			"%w: valid until %s with a clock skew allowance of %s",
+			ErrCertificateExpired, cert.NotAfter.UTC().Format(time.RFC3339), clockSkew,
+		)
+	}
+
+	return nil
+}
+
+// WithClockSkew sets the clock skew tolerance, certificates are issued
+// with a NotBefore backdated by the clock skew and the same tolerance is
+// applied when verifying peer certificates.
+func WithClockSkew(clockSkew time.Duration) CertRequestOption {
+	return func(crc *CertRequestConfig) {
+		if clockSkew < 0 {
+			clockSkew = 0
+		}
+		crc.ClockSkew = clockSkew
+	}
+}
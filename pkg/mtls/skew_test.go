@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package mtls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPeerCertificatesClockSkew(t *testing.T) {
+	caCert, caKey, err := GenerateCA("beskar", time.Now().AddDate(1, 0, 0), ECDSAKey)
+	require.NoError(t, err)
+
+	ca, err := LoadCACertificate(bytes.NewReader(caCert), bytes.NewReader(caKey))
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caCert))
+
+	// simulate a certificate issued by a node with a clock 30 seconds ahead
+	cert, _, err := GenerateKeyPair(&CertRequestConfig{
+		CN:        "peer",
+		Validity:  time.Now().AddDate(1, 0, 0),
+		CA:        &ca,
+		ClockSkew: -30 * time.Second,
+	})
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(cert)
+	require.NotNil(t, block)
+	peerCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	cs := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{peerCert},
+	}
+
+	err = verifyPeerCertificates(roots, 0, x509.ExtKeyUsageClientAuth, false)(cs)
+	require.Error(t, err)
+
+	err = verifyPeerCertificates(roots, DefaultClockSkew, x509.ExtKeyUsageClientAuth, false)(cs)
+	require.NoError(t, err)
+}
+
+func TestCheckValidity(t *testing.T) {
+	now := time.Now()
+
+	caCert, _, err := GenerateCA("beskar", now.Add(time.Hour), ECDSAKey, WithClockSkew(0))
+	require.NoError(t, err)
+
+	require.NoError(t, CheckValidity(caCert, now, 0))
+
+	// a node whose clock is 30 seconds behind the issuer
+	err = CheckValidity(caCert, now.Add(-30*time.Second), 0)
+	require.ErrorIs(t, err, ErrCertificateNotYetValid)
+	require.NoError(t, CheckValidity(caCert, now.Add(-30*time.Second), DefaultClockSkew))
+
+	// a node whose clock is 30 seconds past the expiration
+	err = CheckValidity(caCert, now.Add(time.Hour+30*time.Second), 0)
+	require.ErrorIs(t, err, ErrCertificateExpired)
+	require.NoError(t, CheckValidity(caCert, now.Add(time.Hour+30*time.Second), DefaultClockSkew))
+
+	err = CheckValidity(caCert, now.Add(-2*time.Minute), DefaultClockSkew)
+	require.ErrorIs(t, err, ErrCertificateNotYetValid)
+
+	require.Error(t, CheckValidity([]byte("garbage"), now, DefaultClockSkew))
+}
@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package netutil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ConnLimitOverride sets the connection limit of the client
+// IPs within the network, a zero limit means no limit.
+type ConnLimitOverride struct {
+	Network *net.IPNet
+	Limit   int
+}
+
+// ConnLimiter limits the number of concurrent connections per client IP.
+type ConnLimiter struct {
+	limit     int
+	overrides []ConnLimitOverride
+
+	mutex  sync.Mutex
+	counts map[string]int
+
+	// OnChange, if set, is called with the updated number of
+	// connections of a client IP.
+	OnChange func(ip string, count int)
+	// OnTotal, if set, is called with the change of the total number
+	// of connections, 1 when a connection is accounted and -1 when it's
+	// released.
+	OnTotal func(delta int)
+	// OnReject, if set, is called when a connection of a
+	// client IP is rejected.
+	OnReject func(ip string)
+	// TrustedProxies, if set, are the proxies whose connections are
+	// not limited with ApplyServer, their requests are accounted to the
+	// forwarded client IP for the duration of the request instead.
+	TrustedProxies TrustedProxies
+}
+
+// NewConnLimiter returns a connection limiter allowing limit concurrent
+// connections per client IP unless overridden for the client network, a
+// zero limit means no limit. The first matching override applies.
+func NewConnLimiter(limit int, overrides []ConnLimitOverride) *ConnLimiter {
+	return &ConnLimiter{
+		limit:     limit,
+		overrides: overrides,
+		counts:    make(map[string]int),
+	}
+}
+
+func (cl *ConnLimiter) limitOf(ip net.IP) int {
+	for _, o := range cl.overrides {
+		if o.Network.Contains(ip) {
+			return o.Limit
+		}
+	}
+	return cl.limit
+}
+
+// acquire accounts a connection from the remote address, it returns
+// false if the connection exceeds the client IP limit.
+func (cl *ConnLimiter) acquire(addr net.Addr) (string, bool) {
+	host := remoteHost(addr.String())
+	return host, cl.acquireIP(host)
+}
+
+// acquireIP accounts a connection from the client IP, it returns
+// false if the connection exceeds the client IP limit.
+func (cl *ConnLimiter) acquireIP(host string) bool {
+	limit := cl.limitOf(net.ParseIP(host))
+
+	cl.mutex.Lock()
+	count := cl.counts[host]
+	if limit > 0 && count >= limit {
+		cl.mutex.Unlock()
+		if cl.OnReject != nil {
+			cl.OnReject(host)
+		}
+		return false
+	}
+	count++
+	cl.counts[host] = count
+	cl.mutex.Unlock()
+
+	if cl.OnChange != nil {
+		cl.OnChange(host, count)
+	}
+	if cl.OnTotal != nil {
+		cl.OnTotal(1)
+	}
+
+	return true
+}
+
+func (cl *ConnLimiter) release(ip string) {
+	cl.mutex.Lock()
+	accounted := cl.counts[ip] > 0
+	count := cl.counts[ip] - 1
+	if count <= 0 {
+		count = 0
+		delete(cl.counts, ip)
+	} else {
+		cl.counts[ip] = count
+	}
+	cl.mutex.Unlock()
+
+	if cl.OnChange != nil {
+		cl.OnChange(ip, count)
+	}
+	if cl.OnTotal != nil && accounted {
+		cl.OnTotal(-1)
+	}
+}
+
+// Count returns the number of connections of the client IP.
+func (cl *ConnLimiter) Count(ip string) int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return cl.counts[ip]
+}
+
+// Listener returns a listener closing the accepted connections
+// exceeding the client IP limit.
+func (cl *ConnLimiter) Listener(ln net.Listener) net.Listener {
+	return &limitListener{Listener: ln, limiter: cl}
+}
+
+type limitListener struct {
+	net.Listener
+	limiter *ConnLimiter
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip, ok := l.limiter.acquire(conn.RemoteAddr())
+		if !ok {
+			_ = conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, release: func() { l.limiter.release(ip) }}, nil
+	}
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+type connLimitKey struct{}
+
+// connLimitState is the limiter state of an HTTP server connection,
+// connections of trusted proxies are not accounted.
+type connLimitState struct {
+	ip       string
+	rejected bool
+	proxied  bool
+}
+
+// ApplyServer configures the HTTP server hooks to account connections, the
+// requests of connections exceeding the client IP limit are answered with
+// a 429 Too Many Requests response and the connection is closed. It must be
+// used for servers whose listener can't be wrapped. Requests sent through
+// a trusted proxy are accounted to the forwarded client IP while they are
+// served, the proxy connection is kept open when they are rejected.
+func (cl *ConnLimiter) ApplyServer(server *http.Server) {
+	var states sync.Map
+
+	connContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		var state *connLimitState
+		if ip := remoteHost(conn.RemoteAddr().String()); cl.TrustedProxies.Contains(net.ParseIP(ip)) {
+			state = &connLimitState{ip: ip, proxied: true}
+		} else {
+			state = &connLimitState{ip: ip, rejected: !cl.acquireIP(ip)}
+		}
+		states.Store(conn, state)
+		return context.WithValue(ctx, connLimitKey{}, state)
+	}
+
+	connState := server.ConnState
+	server.ConnState = func(conn net.Conn, cs http.ConnState) {
+		if cs == http.StateClosed || cs == http.StateHijacked {
+			if v, ok := states.LoadAndDelete(conn); ok {
+				if state := v.(*connLimitState); !state.rejected && !state.proxied {
+					cl.release(state.ip)
+				}
+			}
+		}
+		if connState != nil {
+			connState(conn, cs)
+		}
+	}
+
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, ok := r.Context().Value(connLimitKey{}).(*connLimitState)
+		switch {
+		case ok && state.rejected:
+			w.Header().Set("Connection", "close")
+			http.Error(w, "too many connections from client IP", http.StatusTooManyRequests)
+			return
+		case ok && state.proxied:
+			ip := cl.TrustedProxies.ClientIP(r)
+			if !cl.acquireIP(ip) {
+				http.Error(w, "too many connections from client IP", http.StatusTooManyRequests)
+				return
+			}
+			defer cl.release(ip)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
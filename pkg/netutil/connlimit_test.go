@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package netutil
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimiterListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	limiter := NewConnLimiter(1, nil)
+	rejected := make(chan string, 1)
+	limiter.OnReject = func(ip string) {
+		rejected <- ip
+	}
+	total := atomic.Int64{}
+	limiter.OnTotal = func(delta int) {
+		total.Add(int64(delta))
+	}
+
+	ln = limiter.Listener(ln)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+
+	conn := <-accepted
+	require.Equal(t, 1, limiter.Count("127.0.0.1"))
+	require.Equal(t, int64(1), total.Load())
+
+	second, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+
+	require.Equal(t, "127.0.0.1", <-rejected)
+	_ = second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	// rejected connections are not accounted
+	require.Equal(t, int64(1), total.Load())
+
+	require.NoError(t, conn.Close())
+	require.Equal(t, 0, limiter.Count("127.0.0.1"))
+	require.Equal(t, int64(0), total.Load())
+}
+
+func TestConnLimiterServer(t *testing.T) {
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// the override takes precedence over the default limit
+	NewConnLimiter(0, []ConnLimitOverride{{Network: loopback, Limit: 1}}).ApplyServer(server.Config)
+	server.Start()
+	defer server.Close()
+
+	first := &http.Client{Transport: &http.Transport{}}
+	resp, err := first.Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// the first client keeps its idle connection open
+	second := &http.Client{Transport: &http.Transport{}}
+	resp, err = second.Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	first.CloseIdleConnections()
+
+	require.Eventually(t, func() bool {
+		resp, err := second.Get(server.URL)
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusNoContent
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConnLimiterServerTrustedProxy(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.0/8"})
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	limiter := NewConnLimiter(1, nil)
+	limiter.TrustedProxies = proxies
+	limiter.ApplyServer(server.Config)
+	server.Start()
+	defer server.Close()
+
+	get := func(path, clientIP string) int {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Forwarded-For", clientIP)
+		resp, err := server.Client().Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- get("/slow", "198.51.100.1")
+	}()
+
+	require.Eventually(t, func() bool {
+		return limiter.Count("198.51.100.1") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// proxy connections are not limited, forwarded clients are
+	require.Equal(t, 0, limiter.Count("127.0.0.1"))
+	require.Equal(t, http.StatusTooManyRequests, get("/", "198.51.100.1"))
+	require.Equal(t, http.StatusNoContent, get("/", "198.51.100.2"))
+
+	close(release)
+	require.Equal(t, http.StatusNoContent, <-done)
+	require.Equal(t, 0, limiter.Count("198.51.100.1"))
+	require.Equal(t, http.StatusNoContent, get("/", "198.51.100.1"))
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver resolves host names to IP addresses, it's implemented
+// by net.Resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DialFunc dials the address on the named network.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// DNSCache caches host name resolutions for a TTL, a host is resolved
+// again once its TTL expired or once it has been flushed.
+type DNSCache struct {
+	ttl      time.Duration
+	resolver Resolver
+	now      func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]dnsEntry
+}
+
+// NewDNSCache returns a DNS cache keeping resolutions for ttl, host names
+// are resolved with the default resolver when resolver is nil.
+func NewDNSCache(ttl time.Duration, resolver Resolver) *DNSCache {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &DNSCache{
+		ttl:      ttl,
+		resolver: resolver,
+		now:      time.Now,
+		entries:  make(map[string]dnsEntry),
+	}
+}
+
+// Lookup returns the IP addresses of the host from the cache, the host
+// is resolved if it's not cached or if its TTL expired. Failed
+// resolutions are not cached.
+func (dc *DNSCache) Lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := dc.now()
+
+	dc.mutex.Lock()
+	entry, ok := dc.entries[host]
+	dc.mutex.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := dc.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	} else if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address found for host %s", host)
+	}
+
+	dc.mutex.Lock()
+	dc.entries[host] = dnsEntry{
+		addrs:   addrs,
+		expires: now.Add(dc.ttl),
+	}
+	dc.mutex.Unlock()
+
+	return addrs, nil
+}
+
+// Flush removes the host from the cache, it's resolved again on
+// the next lookup.
+func (dc *DNSCache) Flush(host string) {
+	dc.mutex.Lock()
+	delete(dc.entries, host)
+	dc.mutex.Unlock()
+}
+
+// DialContext returns a dial function connecting to the cached addresses
+// of the host with dial, the addresses are tried in turn. The host is
+// flushed when none of its addresses accept connections so that a
+// failover to new addresses is picked up by the next connection.
+func (dc *DNSCache) DialContext(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		} else if net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := dc.Lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ipAddr := range addrs {
+			var conn net.Conn
+
+			conn, err = dial(ctx, network, net.JoinHostPort(ipAddr.String(), port))
+			if err == nil {
+				return conn, nil
+			} else if ctx.Err() != nil {
+				// cancelled by the caller, the addresses may be fine
+				return nil, err
+			}
+		}
+
+		dc.Flush(host)
+
+		return nil, err
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeResolver struct {
+	addrs   []net.IPAddr
+	lookups int
+}
+
+func (fr *fakeResolver) LookupIPAddr(_ context.Context, _ string) ([]net.IPAddr, error) {
+	fr.lookups++
+	if len(fr.addrs) == 0 {
+		return nil, errors.New("no such host")
+	}
+	return fr.addrs, nil
+}
+
+func TestDNSCacheLookup(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	resolver := &fakeResolver{
+		addrs: []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}},
+	}
+
+	dc := NewDNSCache(time.Minute, resolver)
+	dc.now = func() time.Time { return now }
+
+	addrs, err := dc.Lookup(ctx, "plugin")
+	require.NoError(t, err)
+	require.Equal(t, resolver.addrs, addrs)
+
+	_, err = dc.Lookup(ctx, "plugin")
+	require.NoError(t, err)
+	require.Equal(t, 1, resolver.lookups)
+
+	// expired entries are resolved again
+	now = now.Add(time.Minute)
+	_, err = dc.Lookup(ctx, "plugin")
+	require.NoError(t, err)
+	require.Equal(t, 2, resolver.lookups)
+
+	// flushed entries are resolved again
+	dc.Flush("plugin")
+	_, err = dc.Lookup(ctx, "plugin")
+	require.NoError(t, err)
+	require.Equal(t, 3, resolver.lookups)
+
+	// failed resolutions are not cached
+	resolver.addrs = nil
+	dc.Flush("plugin")
+	_, err = dc.Lookup(ctx, "plugin")
+	require.Error(t, err)
+	_, err = dc.Lookup(ctx, "plugin")
+	require.Error(t, err)
+	require.Equal(t, 5, resolver.lookups)
+}
+
+func TestDNSCacheDialContext(t *testing.T) {
+	resolver := &fakeResolver{
+		addrs: []net.IPAddr{
+			{IP: net.ParseIP("10.0.0.1")},
+			{IP: net.ParseIP("10.0.0.2")},
+		},
+	}
+
+	dc := NewDNSCache(time.Hour, resolver)
+
+	var dialed []string
+	reachable := map[string]bool{"10.0.0.2:80": true}
+
+	dial := dc.DialContext(func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if !reachable[addr] {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	})
+
+	// addresses are tried in turn
+	conn, err := dial(context.Background(), "tcp", "plugin:80")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, dialed)
+	require.Equal(t, 1, resolver.lookups)
+
+	// IP addresses are dialed directly
+	dialed = nil
+	conn, err = dial(context.Background(), "tcp", "10.0.0.2:80")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.Equal(t, []string{"10.0.0.2:80"}, dialed)
+	require.Equal(t, 1, resolver.lookups)
+
+	// the host is flushed when none of its addresses is reachable
+	delete(reachable, "10.0.0.2:80")
+	_, err = dial(context.Background(), "tcp", "plugin:80")
+	require.Error(t, err)
+	require.Equal(t, 1, resolver.lookups)
+
+	resolver.addrs = []net.IPAddr{{IP: net.ParseIP("10.0.0.3")}}
+	reachable["10.0.0.3:80"] = true
+
+	dialed = nil
+	conn, err = dial(context.Background(), "tcp", "plugin:80")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.Equal(t, []string{"10.0.0.3:80"}, dialed)
+	require.Equal(t, 2, resolver.lookups)
+}
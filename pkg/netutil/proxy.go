@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package netutil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks of the proxies whose forwarding
+// headers are honored to derive the client IP.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses the trusted proxy CIDRs.
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy cidr %q is invalid: %w", cidr, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Contains returns true if the IP belongs to a trusted proxy.
+func (tp TrustedProxies) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range tp {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client which sent the request. The
+// forwarding headers are only honored for requests sent by a trusted
+// proxy: the Forwarded header takes precedence over X-Forwarded-For and
+// addresses are read from the closest hop, the first address which
+// doesn't belong to a trusted proxy is the client IP. Spoofed addresses
+// prepended by clients are thus ignored.
+func (tp TrustedProxies) ClientIP(r *http.Request) string {
+	client := remoteHost(r.RemoteAddr)
+	if !tp.Contains(net.ParseIP(client)) {
+		return client
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			// obfuscated or unknown identifiers can't be trusted,
+			// the last known hop is kept
+			break
+		}
+		client = ip.String()
+		if !tp.Contains(ip) {
+			break
+		}
+	}
+
+	return client
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// forwardedFor returns the for parameters of the Forwarded
+// header values as defined by RFC 7239.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+func xForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses a forwarded address with an optional port,
+// IPv6 addresses with a port are enclosed in brackets.
+func parseHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(hop)
+	if err != nil {
+		// bracketed IPv6 address without port
+		host = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package netutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "fd00::/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "192.0.2.10:4242",
+			want:       "192.0.2.10",
+		},
+		{
+			name:       "untrusted forwarding headers",
+			remoteAddr: "192.0.2.10:4242",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "192.0.2.10",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.1:4242",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "spoofed address",
+			remoteAddr: "10.0.0.1:4242",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7, 198.51.100.1, 10.0.0.2"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "multiple headers",
+			remoteAddr: "10.0.0.1:4242",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1", "10.0.0.2"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "forwarded precedence",
+			remoteAddr: "10.0.0.1:4242",
+			headers: map[string][]string{
+				"Forwarded":       {`for=198.51.100.1;proto=https, for="[fd00::1]:8443"`},
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:       "forwarded ipv6",
+			remoteAddr: "[fd00::2]:4242",
+			headers:    map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "obfuscated identifier",
+			remoteAddr: "10.0.0.1:4242",
+			headers:    map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "only proxies",
+			remoteAddr: "10.0.0.1:4242",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3:1234, 10.0.0.2"}},
+			want:       "10.0.0.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				r.Header[name] = values
+			}
+			require.Equal(t, tt.want, proxies.ClientIP(r))
+		})
+	}
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.1"})
+	require.ErrorContains(t, err, `trusted proxy cidr "10.0.0.1" is invalid`)
+
+	var none TrustedProxies
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:4242"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	require.Equal(t, "10.0.0.1", none.ClientIP(r))
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package retry
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// BackoffFactory returns a fresh backoff strategy for each retry loop,
+// strategies are stateful and must not be shared between loops.
+type BackoffFactory func() backoff.BackOff
+
+// DefaultBackoffFactory returns an exponential backoff starting at 500ms
+// with a 1.5 multiplier capped at 60s between attempts, it retries forever
+// and relies on the context passed to Retry to stop.
+func DefaultBackoffFactory() backoff.BackOff {
+	eb := backoff.NewExponentialBackOff()
+	eb.MaxElapsedTime = 0
+	return eb
+}
+
+// ConstantBackoffFactory returns a factory producing a constant backoff
+// with an optional maximum number of retries, zero means unlimited.
+// It's mainly useful to get deterministic retries in tests.
+func ConstantBackoffFactory(interval time.Duration, maxRetries uint64) BackoffFactory {
+	return func() backoff.BackOff {
+		var b backoff.BackOff = backoff.NewConstantBackOff(interval)
+		if maxRetries > 0 {
+			b = backoff.WithMaxRetries(b, maxRetries)
+		}
+		return b
+	}
+}
+
+// Retry runs the operation until it succeeds, the backoff strategy gives up
+// or the context is cancelled. A nil factory means DefaultBackoffFactory.
+func Retry(ctx context.Context, newBackoff BackoffFactory, operation backoff.Operation) error {
+	if newBackoff == nil {
+		newBackoff = DefaultBackoffFactory
+	}
+	return backoff.Retry(operation, backoff.WithContext(newBackoff(), ctx))
+}
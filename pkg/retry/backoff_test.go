@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: Copyright (c) 2023, CIQ, Inc. All rights reserved
+// SPDX-License-Identifier: Apache-2.0
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	errFailure := errors.New("failure")
+
+	attempts := 0
+	err := Retry(context.Background(), ConstantBackoffFactory(time.Millisecond, 2), func() error {
+		attempts++
+		return errFailure
+	})
+	require.ErrorIs(t, err, errFailure)
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = Retry(context.Background(), ConstantBackoffFactory(time.Millisecond, 0), func() error {
+		attempts++
+		if attempts < 5 {
+			return errFailure
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = Retry(ctx, nil, func() error {
+		return errFailure
+	})
+	require.Error(t, err)
+}